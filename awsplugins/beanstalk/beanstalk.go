@@ -19,11 +19,14 @@ import (
 // Origin is the type of AWS resource that runs your application.
 const Origin = "AWS::ElasticBeanstalk::Environment"
 
-// Init activates ElasticBeanstalkPlugin at runtime.
+// Init activates ElasticBeanstalkPlugin at runtime. The environment
+// configuration is read when the first segment begins.
 func Init() {
-	if plugins.InstancePluginMetadata != nil && plugins.InstancePluginMetadata.BeanstalkMetadata == nil {
-		addPluginMetadata(plugins.InstancePluginMetadata)
-	}
+	plugins.Register(func(pluginmd *plugins.PluginMetadata) {
+		if pluginmd.BeanstalkMetadata == nil {
+			addPluginMetadata(pluginmd)
+		}
+	})
 }
 
 func addPluginMetadata(pluginmd *plugins.PluginMetadata) {
@@ -27,11 +27,14 @@ type metadata struct {
 	InstanceType     string
 }
 
-//Init activates EC2Plugin at runtime.
+// Init activates EC2Plugin at runtime. The instance metadata is read from
+// the instance metadata service when the first segment begins.
 func Init() {
-	if plugins.InstancePluginMetadata != nil && plugins.InstancePluginMetadata.EC2Metadata == nil {
-		addPluginMetadata(plugins.InstancePluginMetadata)
-	}
+	plugins.Register(func(pluginmd *plugins.PluginMetadata) {
+		if pluginmd.EC2Metadata == nil {
+			addPluginMetadata(pluginmd)
+		}
+	})
 }
 
 func addPluginMetadata(pluginmd *plugins.PluginMetadata) {
@@ -40,6 +43,7 @@ func addPluginMetadata(pluginmd *plugins.PluginMetadata) {
 
 	client := &http.Client{
 		Transport: http.DefaultTransport,
+		Timeout:   plugins.MetadataTimeout(),
 	}
 
 	token, err := getToken(imdsURL, client)
@@ -9,7 +9,11 @@
 package ecs
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 	"github.com/aws/aws-xray-sdk-go/internal/plugins"
@@ -18,11 +22,28 @@ import (
 // Origin is the type of AWS resource that runs your application.
 const Origin = "AWS::ECS::Container"
 
-// Init activates ECSPlugin at runtime.
+// metadataURIEnvVar is the environment variable holding the URI of the
+// container metadata endpoint version 4.
+const metadataURIEnvVar = "ECS_CONTAINER_METADATA_URI_V4"
+
+// containerMetadata is the part of the container metadata document
+// recorded by the plugin.
+type containerMetadata struct {
+	DockerID         string            `json:"DockerId"`
+	ContainerARN     string            `json:"ContainerARN"`
+	AvailabilityZone string            `json:"AvailabilityZone"`
+	LogDriver        string            `json:"LogDriver"`
+	LogOptions       map[string]string `json:"LogOptions"`
+}
+
+// Init activates ECSPlugin at runtime. The container metadata is read when
+// the first segment begins.
 func Init() {
-	if plugins.InstancePluginMetadata != nil && plugins.InstancePluginMetadata.ECSMetadata == nil {
-		addPluginMetadata(plugins.InstancePluginMetadata)
-	}
+	plugins.Register(func(pluginmd *plugins.PluginMetadata) {
+		if pluginmd.ECSMetadata == nil {
+			addPluginMetadata(pluginmd)
+		}
+	})
 }
 
 func addPluginMetadata(pluginmd *plugins.PluginMetadata) {
@@ -33,6 +54,60 @@ func addPluginMetadata(pluginmd *plugins.PluginMetadata) {
 		return
 	}
 
-	pluginmd.ECSMetadata = &plugins.ECSMetadata{ContainerName: hostname}
+	ecsMetadata := &plugins.ECSMetadata{ContainerName: hostname}
+
+	if uri := os.Getenv(metadataURIEnvVar); uri != "" {
+		client := &http.Client{Timeout: plugins.MetadataTimeout()}
+		if md, err := getContainerMetadata(uri, client); err != nil {
+			logger.Debugf("Unable to read ECS container metadata: %v", err)
+		} else {
+			ecsMetadata.ContainerID = md.DockerID
+			ecsMetadata.ContainerArn = md.ContainerARN
+			ecsMetadata.AvailabilityZone = md.AvailabilityZone
+			if logGroup, ok := md.logGroup(); ok {
+				pluginmd.LogGroups = []plugins.LogGroupMetadata{logGroup}
+			}
+		}
+	}
+
+	pluginmd.ECSMetadata = ecsMetadata
 	pluginmd.Origin = Origin
 }
+
+// getContainerMetadata fetches the metadata of the container from the
+// metadata endpoint at uri.
+func getContainerMetadata(uri string, client *http.Client) (*containerMetadata, error) {
+	resp, err := client.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var md containerMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&md); err != nil {
+		return nil, err
+	}
+	return &md, nil
+}
+
+// logGroup returns the CloudWatch Logs log group the container logs to with
+// the awslogs log driver. The ARN of the log group is derived from the
+// region of the log driver and the account of the container ARN.
+func (md *containerMetadata) logGroup() (plugins.LogGroupMetadata, bool) {
+	group := md.LogOptions["awslogs-group"]
+	if md.LogDriver != "awslogs" || group == "" {
+		return plugins.LogGroupMetadata{}, false
+	}
+
+	logGroup := plugins.LogGroupMetadata{LogGroup: group}
+	// arn:partition:ecs:region:account:container/...
+	arn := strings.SplitN(md.ContainerARN, ":", 6)
+	if region := md.LogOptions["awslogs-region"]; len(arn) == 6 && region != "" {
+		logGroup.Arn = fmt.Sprintf("arn:%s:logs:%s:%s:log-group:%s", arn[1], region, arn[4], group)
+	}
+	return logGroup, true
+}
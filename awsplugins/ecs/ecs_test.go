@@ -0,0 +1,107 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package ecs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/internal/plugins"
+	"github.com/stretchr/testify/assert"
+)
+
+const testContainerMetadata = `{
+  "DockerId": "ea32192c8553fbff06c9340478a2ff089b2bb5646fb718b4ee206641c9086d66",
+  "Name": "curl",
+  "DockerName": "ecs-curltest-24-curl-cca48e8dcadd97805600",
+  "Image": "111122223333.dkr.ecr.us-west-2.amazonaws.com/curltest:latest",
+  "Labels": {
+    "com.amazonaws.ecs.cluster": "default",
+    "com.amazonaws.ecs.container-name": "curl"
+  },
+  "LogDriver": "awslogs",
+  "LogOptions": {
+    "awslogs-create-group": "true",
+    "awslogs-group": "/ecs/metadata",
+    "awslogs-region": "us-west-2",
+    "awslogs-stream": "ecs/curl/8f03e41243824aea923aca126495f665"
+  },
+  "ContainerARN": "arn:aws:ecs:us-west-2:111122223333:container/0206b271-b33f-47ab-86c6-a0ba208a70a9",
+  "AvailabilityZone": "us-west-2d"
+}`
+
+func TestAddPluginMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(testContainerMetadata))
+	}))
+	defer server.Close()
+	t.Setenv(metadataURIEnvVar, server.URL)
+
+	hostname, _ := os.Hostname()
+	pluginmd := &plugins.PluginMetadata{}
+	addPluginMetadata(pluginmd)
+
+	assert.Equal(t, &plugins.ECSMetadata{
+		ContainerName:    hostname,
+		ContainerID:      "ea32192c8553fbff06c9340478a2ff089b2bb5646fb718b4ee206641c9086d66",
+		ContainerArn:     "arn:aws:ecs:us-west-2:111122223333:container/0206b271-b33f-47ab-86c6-a0ba208a70a9",
+		AvailabilityZone: "us-west-2d",
+	}, pluginmd.ECSMetadata)
+	assert.Equal(t, []plugins.LogGroupMetadata{{
+		LogGroup: "/ecs/metadata",
+		Arn:      "arn:aws:logs:us-west-2:111122223333:log-group:/ecs/metadata",
+	}}, pluginmd.LogGroups)
+	assert.Equal(t, Origin, pluginmd.Origin)
+}
+
+func TestAddPluginMetadataWithoutAWSLogs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{"DockerId": "ea32192c8553", "LogDriver": "json-file", "LogOptions": {"max-size": "10m"}}`))
+	}))
+	defer server.Close()
+	t.Setenv(metadataURIEnvVar, server.URL)
+
+	pluginmd := &plugins.PluginMetadata{}
+	addPluginMetadata(pluginmd)
+
+	assert.Equal(t, "ea32192c8553", pluginmd.ECSMetadata.ContainerID)
+	assert.Empty(t, pluginmd.LogGroups)
+}
+
+func TestAddPluginMetadataFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	hostname, _ := os.Hostname()
+	tests := []struct {
+		name string
+		uri  string
+	}{
+		{"no endpoint", ""},
+		{"endpoint error", server.URL},
+		{"unreachable endpoint", "http://127.0.0.1:0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(metadataURIEnvVar, tt.uri)
+
+			pluginmd := &plugins.PluginMetadata{}
+			addPluginMetadata(pluginmd)
+
+			assert.Equal(t, &plugins.ECSMetadata{ContainerName: hostname}, pluginmd.ECSMetadata)
+			assert.Empty(t, pluginmd.LogGroups)
+			assert.Equal(t, Origin, pluginmd.Origin)
+		})
+	}
+}
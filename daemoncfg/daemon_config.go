@@ -21,18 +21,29 @@ import (
 var addressDelimiter = " " // delimiter between tcp and udp addresses
 var udpKey = "udp"
 var tcpKey = "tcp"
+var unixKey = "unix"
 
 // DaemonEndpoints stores X-Ray daemon configuration about the ip address and port for UDP and TCP port. It gets the address
 // string from "AWS_TRACING_DAEMON_ADDRESS" and then from recorder's configuration for DaemonAddr.
 // A notation of '127.0.0.1:2000' or 'tcp:127.0.0.1:2000 udp:127.0.0.2:2001' or 'udp:127.0.0.1:2000 tcp:127.0.0.2:2001'
 // are both acceptable. The first one means UDP and TCP are running at the same address.
 // Notation 'hostname:2000' or 'tcp:hostname:2000 udp:hostname:2001' or 'udp:hostname:2000 tcp:hostname:2001' are also acceptable.
+// IPv6 literals are written in brackets, as in '[::1]:2000' or 'udp:[::1]:2000 tcp:[::1]:2000'.
+// A Unix domain socket of the daemon is set with the notation 'unix:/var/run/xray/xray.sock', and can't be combined with
+// other addresses.
 // By default it assumes a X-Ray daemon running at 127.0.0.1:2000 listening to both UDP and TCP traffic.
 type DaemonEndpoints struct {
 	// UDPAddr represents UDP endpoint for segments to be sent by emitter.
 	UDPAddr *net.UDPAddr
+	// UDPHostPort is the UDP endpoint as configured, before resolving it into
+	// UDPAddr. The emitter resolves it again to follow a daemon behind a DNS
+	// name whose IP address changes.
+	UDPHostPort string
 	// TCPAddr represents TCP endpoint of the daemon to make sampling API calls.
 	TCPAddr *net.TCPAddr
+	// UnixAddr represents the Unix domain socket of the daemon, used instead of
+	// UDPAddr and TCPAddr for segments and sampling API calls when set.
+	UnixAddr *net.UnixAddr
 }
 
 // GetDaemonEndpoints returns DaemonEndpoints.
@@ -70,8 +81,9 @@ func GetDefaultDaemonEndpoints() *DaemonEndpoints {
 	}
 
 	return &DaemonEndpoints{
-		UDPAddr: udpAddr,
-		TCPAddr: tcpAddr,
+		UDPAddr:     udpAddr,
+		UDPHostPort: udpAddr.String(),
+		TCPAddr:     tcpAddr,
 	}
 }
 
@@ -94,6 +106,14 @@ func GetDaemonEndpointsFromString(dAddr string) (*DaemonEndpoints, error) {
 
 func resolveAddress(dAddr string) (*DaemonEndpoints, error) {
 	addr := strings.Split(dAddr, addressDelimiter)
+	for _, a := range addr {
+		if strings.HasPrefix(a, unixKey+":") {
+			if len(addr) != 1 {
+				return nil, errors.New("invalid daemon address: unix socket cannot be combined with other addresses: " + dAddr)
+			}
+			return parseUnixForm(a)
+		}
+	}
 	switch len(addr) {
 	case 1:
 		return parseSingleForm(addr[0])
@@ -103,26 +123,30 @@ func resolveAddress(dAddr string) (*DaemonEndpoints, error) {
 	return nil, errors.New("invalid daemon address: " + dAddr)
 }
 
-func parseDoubleForm(addr []string) (*DaemonEndpoints, error) {
-	addr1 := strings.Split(addr[0], ":") // tcp:127.0.0.1:2000  or udp:127.0.0.1:2000
-	addr2 := strings.Split(addr[1], ":") // tcp:127.0.0.1:2000  or udp:127.0.0.1:2000
-
-	if len(addr1) != 3 || len(addr2) != 3 {
-		return nil, errors.New("invalid daemon address: " + addr[0] + " " + addr[1])
+func parseUnixForm(addr string) (*DaemonEndpoints, error) { // format = "unix:/path"
+	path := strings.TrimPrefix(addr, unixKey+":")
+	if path == "" {
+		return nil, errors.New("invalid daemon address: " + addr)
 	}
 
-	// validate ports
-	_, pErr1 := strconv.Atoi(addr1[2])
-	_, pErr2 := strconv.Atoi(addr1[2])
-
-	if pErr1 != nil || pErr2 != nil {
-		return nil, errors.New("invalid daemon address port")
-	}
+	return &DaemonEndpoints{
+		UnixAddr: &net.UnixAddr{Name: path, Net: "unixgram"},
+	}, nil
+}
 
+func parseDoubleForm(addr []string) (*DaemonEndpoints, error) {
 	addrMap := make(map[string]string)
 
-	addrMap[addr1[0]] = addr1[1] + ":" + addr1[2]
-	addrMap[addr2[0]] = addr2[1] + ":" + addr2[2]
+	for _, a := range addr {
+		key, hostPort, ok := strings.Cut(a, ":") // tcp:127.0.0.1:2000 or udp:[::1]:2000
+		if !ok {
+			return nil, errors.New("invalid daemon address: " + addr[0] + " " + addr[1])
+		}
+		if err := validateHostPort(hostPort); err != nil {
+			return nil, err
+		}
+		addrMap[key] = hostPort
+	}
 
 	if addrMap[udpKey] == "" || addrMap[tcpKey] == "" { // for double form, tcp and udp keywords should be present
 		return nil, errors.New("invalid daemon address")
@@ -139,23 +163,15 @@ func parseDoubleForm(addr []string) (*DaemonEndpoints, error) {
 	}
 
 	return &DaemonEndpoints{
-		UDPAddr: udpAddr,
-		TCPAddr: tcpAddr,
+		UDPAddr:     udpAddr,
+		UDPHostPort: addrMap[udpKey],
+		TCPAddr:     tcpAddr,
 	}, nil
 }
 
-func parseSingleForm(addr string) (*DaemonEndpoints, error) { // format = "ip:port"
-	a := strings.Split(addr, ":") // 127.0.0.1:2000
-
-	if len(a) != 2 {
-		return nil, errors.New("invalid daemon address: " + addr)
-	}
-
-	// validate port
-	_, pErr1 := strconv.Atoi(a[1])
-
-	if pErr1 != nil {
-		return nil, errors.New("invalid daemon address port")
+func parseSingleForm(addr string) (*DaemonEndpoints, error) { // format = "ip:port" or "[ipv6]:port"
+	if err := validateHostPort(addr); err != nil {
+		return nil, err
 	}
 
 	udpAddr, uErr := resolveUDPAddr(addr)
@@ -168,11 +184,25 @@ func parseSingleForm(addr string) (*DaemonEndpoints, error) { // format = "ip:po
 	}
 
 	return &DaemonEndpoints{
-		UDPAddr: udpAddr,
-		TCPAddr: tcpAddr,
+		UDPAddr:     udpAddr,
+		UDPHostPort: addr,
+		TCPAddr:     tcpAddr,
 	}, nil
 }
 
+// validateHostPort returns an error if addr isn't of the form "host:port",
+// with IPv6 literals in brackets, or if its port isn't a number.
+func validateHostPort(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.New("invalid daemon address: " + addr)
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return errors.New("invalid daemon address port")
+	}
+	return nil
+}
+
 func resolveUDPAddr(s string) (*net.UDPAddr, error) {
 	return net.ResolveUDPAddr(udpKey, s)
 }
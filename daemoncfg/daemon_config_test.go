@@ -9,6 +9,7 @@ package daemoncfg
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"strings"
 	"testing"
@@ -306,6 +307,42 @@ func TestGetDaemonEndpointsForHostname7(t *testing.T) { // Invalid port - double
 	assert.Nil(t, dEndpt)
 }
 
+func TestGetDaemonEndpointsForIPv6SingleForm(t *testing.T) {
+	dEndpt, err := GetDaemonEndpointsFromString("[::1]:2000")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &net.UDPAddr{IP: net.IPv6loopback, Port: 2000}, dEndpt.UDPAddr)
+	assert.Equal(t, &net.TCPAddr{IP: net.IPv6loopback, Port: 2000}, dEndpt.TCPAddr)
+	assert.Equal(t, "[::1]:2000", dEndpt.UDPHostPort)
+}
+
+func TestGetDaemonEndpointsForIPv6DoubleForm(t *testing.T) {
+	dEndpt, err := GetDaemonEndpointsFromString("udp:[::1]:2000 tcp:[fe80::1]:2001")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &net.UDPAddr{IP: net.IPv6loopback, Port: 2000}, dEndpt.UDPAddr)
+	assert.Equal(t, &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 2001}, dEndpt.TCPAddr)
+	assert.Equal(t, "[::1]:2000", dEndpt.UDPHostPort)
+}
+
+func TestGetDaemonEndpointsForIPv6Invalid(t *testing.T) {
+	for _, dAddr := range []string{
+		"::1:2000", // brackets are required
+		"[::1]",    // no port
+		"udp:::1:2000 tcp:[::1]:2000",
+		"udp:[::1:2000 tcp:[::1]:2000",
+	} {
+		dEndpt, err := GetDaemonEndpointsFromString(dAddr)
+		assert.NotNil(t, err, dAddr)
+		assert.True(t, strings.Contains(fmt.Sprint(err), addrErr), dAddr)
+		assert.Nil(t, dEndpt, dAddr)
+	}
+
+	dEndpt, err := GetDaemonEndpointsFromString("udp:[::1]:x tcp:[::1]:2000")
+	assert.True(t, strings.Contains(fmt.Sprint(err), portErr))
+	assert.Nil(t, dEndpt)
+}
+
 // Benchmarks
 func BenchmarkGetDaemonEndpoints(b *testing.B) {
 	for i := 0; i < b.N; i++ {
@@ -345,3 +382,43 @@ func BenchmarkGetDaemonEndpointsFromString(b *testing.B) {
 		}
 	}
 }
+
+func TestGetDaemonEndpointsForUnixSocket1(t *testing.T) { // unix socket
+	dEndpt, err := GetDaemonEndpointsFromString("unix:/var/run/xray/xray.sock")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &net.UnixAddr{Name: "/var/run/xray/xray.sock", Net: "unixgram"}, dEndpt.UnixAddr)
+	assert.Nil(t, dEndpt.UDPAddr)
+	assert.Nil(t, dEndpt.TCPAddr)
+}
+
+func TestGetDaemonEndpointsForUnixSocket2(t *testing.T) { // unix socket from env variable
+	os.Setenv("AWS_XRAY_DAEMON_ADDRESS", "unix:/var/run/xray/xray.sock")
+	defer os.Unsetenv("AWS_XRAY_DAEMON_ADDRESS")
+
+	dEndpt := GetDaemonEndpoints()
+
+	assert.Equal(t, "/var/run/xray/xray.sock", dEndpt.UnixAddr.Name)
+}
+
+func TestGetDaemonEndpointsForUnixSocket3(t *testing.T) { // Invalid unix socket - combined with tcp or udp
+	for _, dAddr := range []string{
+		"unix:/var/run/xray/xray.sock tcp:127.0.0.1:2000",
+		"udp:127.0.0.1:2000 unix:/var/run/xray/xray.sock",
+		"unix:/var/run/xray/xray.sock unix:/var/run/xray/xray.sock",
+	} {
+		dEndpt, err := GetDaemonEndpointsFromString(dAddr)
+
+		assert.NotNil(t, err)
+		assert.True(t, strings.Contains(fmt.Sprint(err), "unix socket cannot be combined"))
+		assert.Nil(t, dEndpt)
+	}
+}
+
+func TestGetDaemonEndpointsForUnixSocket4(t *testing.T) { // Invalid unix socket - no path
+	dEndpt, err := GetDaemonEndpointsFromString("unix:")
+
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(fmt.Sprint(err), addrErr))
+	assert.Nil(t, dEndpt)
+}
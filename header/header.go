@@ -9,8 +9,12 @@
 package header
 
 import (
-	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -31,6 +35,45 @@ const (
 	SelfPrefix = "Self="
 )
 
+const (
+	// DefaultMaxLength is the default maximum length of the serialized
+	// X-Amzn-Trace-Id header. Some services reject longer headers.
+	DefaultMaxLength = 512
+
+	// MaxKeyLength is the maximum length of a key parsed by FromString.
+	MaxKeyLength = 64
+
+	// MaxValueLength is the maximum length of a value parsed by FromString.
+	MaxValueLength = 256
+)
+
+var maxLength int64 = DefaultMaxLength
+
+// SetMaxLength overrides the maximum length of the serialized header.
+// A value of zero or less removes the limit.
+func SetMaxLength(n int) {
+	atomic.StoreInt64(&maxLength, int64(n))
+}
+
+// MaxLength returns the maximum length of the serialized header.
+func MaxLength() int {
+	return int(atomic.LoadInt64(&maxLength))
+}
+
+// TraceIDTime returns the time the trace began, as encoded in the second
+// part of the trace ID, e.g. 5759e988 in 1-5759e988-bd862e3fe1be46a994272793.
+func TraceIDTime(traceID string) (time.Time, error) {
+	parts := strings.Split(traceID, "-")
+	if len(parts) != 3 || parts[0] != "1" || len(parts[1]) != 8 || len(parts[2]) != 24 {
+		return time.Time{}, fmt.Errorf("invalid trace ID %q", traceID)
+	}
+	epoch, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid trace ID %q: %v", traceID, err)
+	}
+	return time.Unix(int64(epoch), 0), nil
+}
+
 // SamplingDecision is a string representation of
 // whether or not the current segment has been sampled.
 type SamplingDecision string
@@ -75,6 +118,8 @@ type Header struct {
 }
 
 // FromString gets individual value for each item in Header struct.
+// Keys longer than MaxKeyLength and values longer than MaxValueLength
+// are ignored.
 func FromString(s string) *Header {
 	ret := &Header{
 		SamplingDecision: Unknown,
@@ -84,7 +129,7 @@ func FromString(s string) *Header {
 	for i := range parts {
 		p := strings.TrimSpace(parts[i])
 		value, valid := valueFromKeyValuePair(p)
-		if valid {
+		if valid && len(value) <= MaxValueLength {
 			switch {
 			case strings.HasPrefix(p, RootPrefix):
 				ret.TraceID = value
@@ -94,7 +139,7 @@ func FromString(s string) *Header {
 				ret.SamplingDecision = samplingDecision(p)
 			case !strings.HasPrefix(p, SelfPrefix):
 				key, valid := keyFromKeyValuePair(p)
-				if valid {
+				if valid && len(key) <= MaxKeyLength {
 					ret.AdditionalData[key] = value
 				}
 			}
@@ -104,19 +149,92 @@ func FromString(s string) *Header {
 }
 
 // String returns a string representation for header.
+// Root, Parent and Sampled come first, followed by the additional data
+// sorted by key. When the result would be longer than MaxLength, the largest
+// additional data entries are dropped until it fits; ties are broken by key
+// so that the result is deterministic. Root, Parent and Sampled are never
+// dropped.
 func (h Header) String() string {
-	var p [][]byte
+	var p []string
 	if h.TraceID != "" {
-		p = append(p, []byte(RootPrefix+h.TraceID))
+		p = append(p, RootPrefix+h.TraceID)
 	}
 	if h.ParentID != "" {
-		p = append(p, []byte(ParentPrefix+h.ParentID))
+		p = append(p, ParentPrefix+h.ParentID)
 	}
-	p = append(p, []byte(h.SamplingDecision))
+	if h.SamplingDecision != Unknown {
+		p = append(p, string(h.SamplingDecision))
+	}
+
+	keys := make([]string, 0, len(h.AdditionalData))
 	for key := range h.AdditionalData {
-		p = append(p, []byte(key+"="+h.AdditionalData[key]))
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := make([]string, len(keys))
+	for i, key := range keys {
+		entries[i] = key + "=" + h.AdditionalData[key]
+	}
+
+	if limit := MaxLength(); limit > 0 {
+		entries = truncate(joinedLength(p), entries, limit)
+	}
+	return strings.Join(append(p, entries...), ";")
+}
+
+// truncate drops the largest entries until the entries, joined with ";" to a
+// header of length n, fit in limit. The order of the kept entries is preserved.
+func truncate(n int, entries []string, limit int) []string {
+	total := n
+	for _, e := range entries {
+		if total > 0 {
+			total++
+		}
+		total += len(e)
+	}
+	if total <= limit {
+		return entries
+	}
+
+	bySize := make([]int, len(entries))
+	for i := range bySize {
+		bySize[i] = i
+	}
+	// entries are sorted by key, so a stable sort breaks ties by key.
+	sort.SliceStable(bySize, func(i, j int) bool {
+		return len(entries[bySize[i]]) > len(entries[bySize[j]])
+	})
+
+	dropped := make([]bool, len(entries))
+	for _, i := range bySize {
+		if total <= limit {
+			break
+		}
+		dropped[i] = true
+		total -= len(entries[i])
+		if total > 0 {
+			total--
+		}
+	}
+
+	kept := entries[:0]
+	for i, e := range entries {
+		if !dropped[i] {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func joinedLength(p []string) int {
+	n := 0
+	for i, s := range p {
+		if i > 0 {
+			n++
+		}
+		n += len(s)
 	}
-	return string(bytes.Join(p, []byte(";")))
+	return n
 }
 
 func keyFromKeyValuePair(s string) (string, bool) {
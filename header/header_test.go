@@ -9,7 +9,9 @@
 package header
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -74,6 +76,86 @@ func TestLonghToString(t *testing.T) {
 	assert.Equal(t, "Root="+ExampleTraceID+";Parent=foo;Sampled=1;Foo=bar", h.String())
 }
 
+func TestAdditionalDataToStringIsSorted(t *testing.T) {
+	h := &Header{
+		TraceID:          ExampleTraceID,
+		ParentID:         "foo",
+		SamplingDecision: Sampled,
+		AdditionalData:   map[string]string{"b": "2", "c": "3", "a": "1"},
+	}
+	assert.Equal(t, "Root="+ExampleTraceID+";Parent=foo;Sampled=1;a=1;b=2;c=3", h.String())
+}
+
+func TestOversizedAdditionalDataIsDropped(t *testing.T) {
+	h := &Header{
+		TraceID:          ExampleTraceID,
+		ParentID:         "53995c3f42cd8ad8",
+		SamplingDecision: Sampled,
+		AdditionalData: map[string]string{
+			"small": "1",
+			"big1":  strings.Repeat("x", 200),
+			"big2":  strings.Repeat("y", 200),
+			"mid":   strings.Repeat("z", 100),
+		},
+	}
+
+	// big1 and big2 have the same size, so big1 is dropped first.
+	want := "Root=" + ExampleTraceID + ";Parent=53995c3f42cd8ad8;Sampled=1;big2=" + strings.Repeat("y", 200) + ";mid=" + strings.Repeat("z", 100) + ";small=1"
+	for i := 0; i < 10; i++ {
+		s := h.String()
+		assert.Equal(t, want, s)
+		assert.True(t, len(s) <= DefaultMaxLength)
+	}
+
+	// round trip
+	parsed := FromString(h.String())
+	assert.Equal(t, ExampleTraceID, parsed.TraceID)
+	assert.Equal(t, "53995c3f42cd8ad8", parsed.ParentID)
+	assert.Equal(t, Sampled, parsed.SamplingDecision)
+	assert.Equal(t, map[string]string{
+		"big2":  strings.Repeat("y", 200),
+		"mid":   strings.Repeat("z", 100),
+		"small": "1",
+	}, parsed.AdditionalData)
+}
+
+func TestCoreFieldsSurviveMaxLength(t *testing.T) {
+	defer SetMaxLength(DefaultMaxLength)
+	SetMaxLength(10)
+
+	h := &Header{
+		TraceID:          ExampleTraceID,
+		ParentID:         "foo",
+		SamplingDecision: Sampled,
+		AdditionalData:   map[string]string{"Foo": "bar"},
+	}
+	assert.Equal(t, "Root="+ExampleTraceID+";Parent=foo;Sampled=1", h.String())
+
+	SetMaxLength(0)
+	assert.Equal(t, "Root="+ExampleTraceID+";Parent=foo;Sampled=1;Foo=bar", h.String())
+}
+
+func TestOversizedKeyValueFromString(t *testing.T) {
+	longKey := strings.Repeat("k", MaxKeyLength+1)
+	longValue := strings.Repeat("v", MaxValueLength+1)
+	h := FromString("Root=" + ExampleTraceID + ";" + longKey + "=bar;Foo=" + longValue + ";Bar=baz;Parent=" + longValue)
+
+	assert.Equal(t, ExampleTraceID, h.TraceID)
+	assert.Empty(t, h.ParentID)
+	assert.Equal(t, map[string]string{"Bar": "baz"}, h.AdditionalData)
+}
+
+func TestTraceIDTime(t *testing.T) {
+	began, err := TraceIDTime("1-5759e988-bd862e3fe1be46a994272793")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(0x5759e988, 0), began)
+
+	for _, id := range []string{"", "fakeid", "1-5759e988", "2-5759e988-bd862e3fe1be46a994272793", "1-5759e98g-bd862e3fe1be46a994272793", "1-5759e988-bd862e3fe1be46a99427279"} {
+		_, err := TraceIDTime(id)
+		assert.Error(t, err, id)
+	}
+}
+
 // Benchmark
 func BenchmarkFromString(b *testing.B) {
 	str := "Sampled=?; Root=" + ExampleTraceID + "; Parent=foo; Self=2; Foo=bar"
@@ -10,20 +10,59 @@ package awsv2
 
 import (
 	"context"
+	"errors"
+	"net/url"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	v2Middleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/smithy-go"
 	"github.com/aws/smithy-go/middleware"
 	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
+// IDs of the X-Ray middleware, by which they are only added once to a stack.
+const (
+	initializeMiddlewareID  = "XRayInitializeMiddlewareAfter"
+	traceHeaderMiddlewareID = "XRayTraceHeaderMiddleware"
+	deserializeMiddlewareID = "XRayDeserializeMiddleware"
+	eventStreamMiddlewareID = "XRayEventStreamMiddleware"
+)
+
+// errorCodeKey is the key of the code of the API error of an operation in
+// the aws block of its subsegment.
+const errorCodeKey = "error_code"
+
+// throttleErrorCodes are the codes of the API errors returned by services
+// throttling requests, which aren't all sent with the 429 status.
+var throttleErrorCodes = map[string]bool{
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"ThrottledException":                     true,
+	"RequestLimitExceeded":                   true,
+	"TooManyRequestsException":               true,
+	"ProvisionedThroughputExceededException": true,
+	"SlowDown":                               true,
+}
+
 type awsV2SubsegmentKey struct{}
 
+type eventStreamKey struct{}
+
 func initializeMiddlewareAfter(stack *middleware.Stack) error {
-	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("XRayInitializeMiddlewareAfter", func(
+	if _, ok := stack.Initialize.Get(initializeMiddlewareID); ok {
+		return nil
+	}
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc(initializeMiddlewareID, func(
 		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
 		out middleware.InitializeOutput, metadata middleware.Metadata, err error) {
 
+		// Parts of multipart uploads above the threshold are only counted.
+		if skip, _ := ctx.Value(skipSubsegmentKey{}).(bool); skip {
+			return next.HandleInitialize(ctx, in)
+		}
+
 		serviceName := v2Middleware.GetServiceID(ctx)
 		// Start the subsegment
 		ctx, subseg := xray.BeginSubsegment(ctx, serviceName)
@@ -31,24 +70,64 @@ func initializeMiddlewareAfter(stack *middleware.Stack) error {
 			return next.HandleInitialize(ctx, in)
 		}
 		subseg.Namespace = "aws"
+		subseg.AddAnnotation("provider", serviceName)
 		subseg.GetAWS()["region"] = v2Middleware.GetRegion(ctx)
 		subseg.GetAWS()["operation"] = v2Middleware.GetOperationName(ctx)
 
 		// set the subsegment in the context
 		ctx = context.WithValue(ctx, awsV2SubsegmentKey{}, subseg)
+		es := &eventStream{subseg: subseg}
+		ctx = context.WithValue(ctx, eventStreamKey{}, es)
+		t := &timings{}
+		ctx = context.WithValue(ctx, timingsKey{}, t)
 
 		out, metadata, err = next.HandleInitialize(ctx, in)
 
-		// End the subsegment when the response returns from this middleware
-		defer subseg.Close(err)
+		t.record(subseg)
+		// Presign clients return the presigned request without sending it,
+		// so the deserialize middleware doesn't run.
+		if presigned, ok := out.Result.(*v4.PresignedHTTPRequest); ok {
+			recordPresignedRequest(subseg, presigned)
+		}
+
+		// End the subsegment when the response returns from this middleware,
+		// unless it is an event stream that is still being read.
+		if err != nil || !es.streaming() {
+			defer es.finish(err)
+		}
 
 		return out, metadata, err
 	}),
 		middleware.After)
 }
 
+// traceHeaderMiddleware adds the trace header to the request once it's
+// built, ahead of the finalize step, so that the request isn't modified after
+// its payload is hashed or wrapped in a chunked stream and signed, as for S3
+// uploads of unseekable bodies.
+func traceHeaderMiddleware(stack *middleware.Stack) error {
+	if _, ok := stack.Build.Get(traceHeaderMiddlewareID); ok {
+		return nil
+	}
+	return stack.Build.Add(middleware.BuildMiddlewareFunc(traceHeaderMiddlewareID, func(
+		ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (
+		middleware.BuildOutput, middleware.Metadata, error) {
+
+		if subseg, ok := ctx.Value(awsV2SubsegmentKey{}).(*xray.Segment); ok {
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				req.Header.Set(xray.TraceIDHeaderKey, subseg.DownstreamHeader().String())
+			}
+		}
+		return next.HandleBuild(ctx, in)
+	}),
+		middleware.After)
+}
+
 func deserializeMiddleware(stack *middleware.Stack) error {
-	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("XRayDeserializeMiddleware", func(
+	if _, ok := stack.Deserialize.Get(deserializeMiddlewareID); ok {
+		return nil
+	}
+	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc(deserializeMiddlewareID, func(
 		ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (
 		out middleware.DeserializeOutput, metadata middleware.Metadata, err error) {
 
@@ -57,11 +136,10 @@ func deserializeMiddleware(stack *middleware.Stack) error {
 			return next.HandleDeserialize(ctx, in)
 		}
 
-		in.Request.(*smithyhttp.Request).Header.Set(xray.TraceIDHeaderKey, subseg.DownstreamHeader().String())
-
 		out, metadata, err = next.HandleDeserialize(ctx, in)
 
 		resp, ok := out.RawResponse.(*smithyhttp.Response)
+		recordAPIError(subseg, err, ok)
 		if !ok {
 			// No raw response to wrap with.
 			return out, metadata, err
@@ -88,6 +166,130 @@ func deserializeMiddleware(stack *middleware.Stack) error {
 		middleware.Before)
 }
 
+// recordAPIError records the code of the API error in the chain of err, and
+// marks subseg as throttled if it's a throttling error. Without a response
+// status to classify the error by, subseg is marked as an error or a fault as
+// classified by the API error.
+func recordAPIError(subseg *xray.Segment, err error, hasResponse bool) {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return
+	}
+
+	subseg.Lock()
+	defer subseg.Unlock()
+
+	code := apiErr.ErrorCode()
+	if code != "" {
+		subseg.GetAWS()[errorCodeKey] = code
+	}
+	if throttleErrorCodes[code] {
+		subseg.Throttle = true
+		subseg.Error = true
+	}
+	if !hasResponse {
+		switch apiErr.ErrorFault() {
+		case smithy.FaultClient:
+			subseg.Error = true
+		case smithy.FaultServer:
+			subseg.Fault = true
+		}
+	}
+}
+
+// eventStreamMiddleware wraps the body of event stream responses as they
+// are returned by the transport, before the operation deserializers hand it
+// to the event stream reader, so that the subsegment stays open until the
+// stream is closed.
+func eventStreamMiddleware(stack *middleware.Stack) error {
+	if _, ok := stack.Deserialize.Get(eventStreamMiddlewareID); ok {
+		return nil
+	}
+	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc(eventStreamMiddlewareID, func(
+		ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (
+		out middleware.DeserializeOutput, metadata middleware.Metadata, err error) {
+
+		out, metadata, err = next.HandleDeserialize(ctx, in)
+
+		es, ok := ctx.Value(eventStreamKey{}).(*eventStream)
+		if !ok || err != nil {
+			return out, metadata, err
+		}
+		resp, ok := out.RawResponse.(*smithyhttp.Response)
+		if !ok || resp.Body == nil || resp.Header.Get("Content-Type") != eventStreamContentType {
+			return out, metadata, err
+		}
+
+		es.start()
+		resp.Body = &eventStreamBody{ReadCloser: resp.Body, es: es}
+		return out, metadata, err
+	}),
+		middleware.After)
+}
+
+// recordPresignedRequest records the host and method of a presigned request
+// on the subsegment of the operation. The rest of the URL, which holds the
+// signature, isn't recorded.
+func recordPresignedRequest(subseg *xray.Segment, presigned *v4.PresignedHTTPRequest) {
+	subseg.Lock()
+	defer subseg.Unlock()
+
+	subseg.GetAWS()["presigned"] = true
+	subseg.GetHTTP().GetRequest().Method = presigned.Method
+	if u, err := url.Parse(presigned.URL); err == nil {
+		subseg.GetAWS()["presigned_url_host"] = u.Host
+	}
+}
+
+// AWSV2Instrumentor adds the X-Ray middleware to the API options of an AWS
+// SDK for Go v2 client. Operations of presign clients, such as
+// s3.NewPresignClient, are recorded in a subsegment closed once the URL is
+// generated, with the host of the URL recorded. The middleware is only added
+// once to the stack of an operation, so a client may be instrumented both
+// with AWSV2Instrumentor and by the aws.Config it's created from.
 func AWSV2Instrumentor(apiOptions *[]func(*middleware.Stack) error) {
-	*apiOptions = append(*apiOptions, initializeMiddlewareAfter, deserializeMiddleware)
+	*apiOptions = append(*apiOptions, APIOptions()...)
+}
+
+// APIOptions returns the X-Ray middleware as API options, e.g. to load an
+// aws.Config instrumented for all of its clients with config.WithAPIOptions:
+//
+//	cfg, err := config.LoadDefaultConfig(ctx, config.WithAPIOptions(awsv2.APIOptions()))
+func APIOptions() []func(*middleware.Stack) error {
+	return []func(*middleware.Stack) error{initializeMiddlewareAfter, traceHeaderMiddleware, deserializeMiddleware, eventStreamMiddleware, timingMiddleware}
+}
+
+// InstrumentConfig adds the X-Ray middleware to the API options of cfg, so
+// that the operations of every client created from cfg are traced.
+func InstrumentConfig(cfg *aws.Config) {
+	AWSV2Instrumentor(&cfg.APIOptions)
+}
+
+// InstrumentorOptions configures AWSV2InstrumentorWithOptions.
+type InstrumentorOptions struct {
+	// GroupMultipartUploads records the operations of each S3 multipart
+	// upload, such as those of the feature/s3/manager Uploader, under a
+	// single subsegment named MultipartUploadSegmentName, instead of as
+	// siblings in the segment of the caller. The parts completed and the
+	// bytes sent are recorded in its metadata.
+	GroupMultipartUploads bool
+
+	// MultipartUploadPartThreshold is the number of UploadPart operations
+	// of a grouped multipart upload recorded in their own subsegment. The
+	// following parts are only counted. Zero records all of them.
+	MultipartUploadPartThreshold int
+}
+
+// AWSV2InstrumentorWithOptions adds the X-Ray middleware to the API options
+// of an AWS SDK for Go v2 client like AWSV2Instrumentor, configured by opts.
+func AWSV2InstrumentorWithOptions(apiOptions *[]func(*middleware.Stack) error, opts InstrumentorOptions) {
+	if opts.GroupMultipartUploads {
+		uploads := &multipartUploads{
+			partThreshold: opts.MultipartUploadPartThreshold,
+			uploads:       make(map[string]*multipartUpload),
+		}
+		// added ahead of initializeMiddlewareAfter, so that it runs first
+		*apiOptions = append(*apiOptions, uploads.middleware)
+	}
+	AWSV2Instrumentor(apiOptions)
 }
@@ -11,6 +11,7 @@ package awsv2
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -23,6 +24,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/route53/types"
 	"github.com/aws/aws-xray-sdk-go/strategy/ctxmissing"
 	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/smithy-go"
 )
 
 func TestAWSV2(t *testing.T) {
@@ -33,6 +35,8 @@ func TestAWSV2(t *testing.T) {
 		expectedError      string
 		expectedRequestID  string
 		expectedStatusCode int
+		expectedErrorCode  string
+		expectedThrottle   bool
 	}{
 		"fault response": {
 			responseStatus: 500,
@@ -65,6 +69,27 @@ func TestAWSV2(t *testing.T) {
 			expectedError:      "Error",
 			expectedRequestID:  "1234567890A",
 			expectedStatusCode: 404,
+			expectedErrorCode:  "MalformedXML",
+		},
+
+		"throttle response": {
+			responseStatus: 400,
+			responseBody: []byte(`<?xml version="1.0"?>
+		<ErrorResponse xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
+		<Error>
+		  <Type>Sender</Type>
+		  <Code>ThrottlingException</Code>
+		  <Message>Rate exceeded</Message>
+		</Error>
+		<RequestId>1234567890B</RequestId>
+		</ErrorResponse>
+		`),
+			expectedRegion:     "us-west-1",
+			expectedError:      "Error",
+			expectedRequestID:  "1234567890B",
+			expectedStatusCode: 400,
+			expectedErrorCode:  "ThrottlingException",
+			expectedThrottle:   true,
 		},
 
 		"success response": {
@@ -143,6 +168,18 @@ func TestAWSV2(t *testing.T) {
 				t.Errorf("expected namespace to be %s, got %s", e, a)
 			}
 
+			if e, a := "Route 53", subseg.Annotations["provider"]; e != a {
+				t.Errorf("expected provider to be %s, got %v", e, a)
+			}
+
+			if e, a := c.expectedThrottle, subseg.Throttle; e != a {
+				t.Errorf("expected throttle to be %v, got %v", e, a)
+			}
+
+			if e, a := c.expectedErrorCode, subseg.GetAWS()[errorCodeKey]; e != "" && e != a {
+				t.Errorf("expected error code to be %s, got %v", e, a)
+			}
+
 			if subseg.GetAWS()[xray.RequestIDKey] != nil {
 				if e, a := c.expectedRequestID, fmt.Sprintf("%v", subseg.GetAWS()[xray.RequestIDKey]); !strings.EqualFold(e, a) {
 					t.Errorf("expected request id to be %s, got %s", e, a)
@@ -153,6 +190,56 @@ func TestAWSV2(t *testing.T) {
 	}
 }
 
+func TestRecordAPIError(t *testing.T) {
+	cases := map[string]struct {
+		err         error
+		hasResponse bool
+		error       bool
+		fault       bool
+		throttle    bool
+	}{
+		"throttling": {
+			err:         &smithy.GenericAPIError{Code: "SlowDown", Fault: smithy.FaultServer},
+			hasResponse: true,
+			error:       true,
+			throttle:    true,
+		},
+		"client fault without response": {
+			err:   fmt.Errorf("operation error: %w", &smithy.GenericAPIError{Code: "InvalidInput", Fault: smithy.FaultClient}),
+			error: true,
+		},
+		"server fault without response": {
+			err:   &smithy.GenericAPIError{Code: "InternalError", Fault: smithy.FaultServer},
+			fault: true,
+		},
+		"not an API error": {
+			err: errors.New("connection reset"),
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, seg := xray.BeginSegment(context.Background(), "test")
+			defer seg.Close(nil)
+
+			recordAPIError(seg, c.err, c.hasResponse)
+
+			if seg.Error != c.error || seg.Fault != c.fault || seg.Throttle != c.throttle {
+				t.Errorf("expected error %v, fault %v and throttle %v, got %v, %v and %v",
+					c.error, c.fault, c.throttle, seg.Error, seg.Fault, seg.Throttle)
+			}
+			var apiErr smithy.APIError
+			if errors.As(c.err, &apiErr) {
+				if e, a := apiErr.ErrorCode(), seg.GetAWS()[errorCodeKey]; e != a {
+					t.Errorf("expected error code to be %s, got %v", e, a)
+				}
+			} else if _, ok := seg.GetAWS()[errorCodeKey]; ok {
+				t.Errorf("expected no error code")
+			}
+		})
+	}
+}
+
 func TestAWSV2WithoutSegment(t *testing.T) {
 	cases := map[string]struct {
 		responseStatus int
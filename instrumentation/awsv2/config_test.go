@@ -0,0 +1,195 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package awsv2
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v2Middleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// clientStub invokes the operations of clients created from an aws.Config on
+// an httptest server, recording the trace headers signed and received.
+type clientStub struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	signed   []string
+	received []string
+}
+
+func newClientStub(t *testing.T) *clientStub {
+	c := &clientStub{t: t}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		c.mu.Lock()
+		c.received = append(c.received, r.Header.Get(xray.TraceIDHeaderKey))
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+// invoke runs operation of the service serviceID with an unseekable body, as
+// a client created from cfg with the API options of optFns would.
+func (c *clientStub) invoke(ctx context.Context, cfg aws.Config, serviceID, operation string, optFns ...func(*[]func(*middleware.Stack) error)) {
+	stack := middleware.NewStack(operation, smithyhttp.NewStackRequest)
+	err := stack.Initialize.Add(&v2Middleware.RegisterServiceMetadata{
+		ServiceID:     serviceID,
+		Region:        "us-west-2",
+		OperationName: operation,
+	}, middleware.Before)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	err = stack.Serialize.Add(middleware.SerializeMiddlewareFunc("OperationSerializer", func(
+		ctx context.Context, in middleware.SerializeInput, next middleware.SerializeHandler) (
+		middleware.SerializeOutput, middleware.Metadata, error) {
+
+		u, err := url.Parse(c.server.URL + "/")
+		if err != nil {
+			return middleware.SerializeOutput{}, middleware.Metadata{}, err
+		}
+		req := in.Request.(*smithyhttp.Request)
+		req.Method = http.MethodPost
+		req.URL = u
+		if req, err = req.SetStream(struct{ io.Reader }{strings.NewReader("payload")}); err != nil {
+			return middleware.SerializeOutput{}, middleware.Metadata{}, err
+		}
+		in.Request = req
+		return next.HandleSerialize(ctx, in)
+	}), middleware.After)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	// Stands in for the SigV4 signer, recording the trace header it signs.
+	err = stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("Signing", func(
+		ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+		middleware.FinalizeOutput, middleware.Metadata, error) {
+
+		c.mu.Lock()
+		c.signed = append(c.signed, in.Request.(*smithyhttp.Request).Header.Get(xray.TraceIDHeaderKey))
+		c.mu.Unlock()
+		return next.HandleFinalize(ctx, in)
+	}), middleware.After)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	// Clients copy the API options of their aws.Config, then apply theirs.
+	apiOptions := append([]func(*middleware.Stack) error(nil), cfg.APIOptions...)
+	for _, fn := range optFns {
+		fn(&apiOptions)
+	}
+	for _, fn := range apiOptions {
+		if err := fn(stack); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+
+	handler := middleware.DecorateHandler(smithyhttp.NewClientHandler(c.server.Client()), stack)
+	if _, _, err := handler.Handle(ctx, struct{}{}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// subsegmentNames returns the number of subsegments of seg by name.
+func subsegmentNames(t *testing.T, seg *xray.Segment) map[string]int {
+	names := make(map[string]int)
+	for _, raw := range seg.Subsegments {
+		var subseg *xray.Segment
+		if err := json.Unmarshal(raw, &subseg); err != nil {
+			t.Fatal(err)
+		}
+		names[subseg.Name]++
+	}
+	return names
+}
+
+func TestInstrumentConfig(t *testing.T) {
+	c := newClientStub(t)
+	ctx, root := beginSampledSegment(t, "AWSSDKV2_Config")
+
+	cfg := aws.Config{}
+	InstrumentConfig(&cfg)
+	c.invoke(ctx, cfg, "S3", "PutObject")
+	c.invoke(ctx, cfg, "DynamoDB", "PutItem")
+	// clients instrumented both by their aws.Config and their options
+	c.invoke(ctx, cfg, "SQS", "SendMessage", AWSV2Instrumentor)
+	root.Close(nil)
+
+	names := subsegmentNames(t, root)
+	for _, name := range []string{"S3", "DynamoDB", "SQS"} {
+		if names[name] != 1 {
+			t.Errorf("expected 1 subsegment named %s, got %v", name, names)
+		}
+	}
+	if len(names) != 3 {
+		t.Errorf("expected 3 subsegments, got %v", names)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.signed) != 3 || len(c.received) != 3 {
+		t.Fatalf("expected 3 requests, got %d signed and %d received", len(c.signed), len(c.received))
+	}
+	for i := range c.signed {
+		// the trace header is added before the request is signed, and the
+		// request isn't modified after
+		if c.signed[i] == "" || c.signed[i] != c.received[i] {
+			t.Errorf("expected the signed trace header %q to be received, got %q", c.signed[i], c.received[i])
+		}
+	}
+}
+
+func TestAPIOptionsAddedTwice(t *testing.T) {
+	c := newClientStub(t)
+	ctx, root := beginSampledSegment(t, "AWSSDKV2_Config")
+
+	cfg := aws.Config{APIOptions: APIOptions()}
+	InstrumentConfig(&cfg)
+	c.invoke(ctx, cfg, "S3", "PutObject", AWSV2Instrumentor)
+
+	if subseg := closeAndGetSubsegment(t, root); subseg.Name != "S3" {
+		t.Errorf("expected subsegment name to be S3, got %s", subseg.Name)
+	}
+}
+
+func TestInstrumentConfigMultipartUploadGrouped(t *testing.T) {
+	s := newS3Stub(t, InstrumentorOptions{GroupMultipartUploads: true})
+	// the aws.Config of the client is instrumented too
+	s.apiOptions = append(APIOptions(), s.apiOptions...)
+	ctx, root := beginSampledSegment(t, "AWSSDKV2_MultipartUpload")
+
+	s.upload(ctx, 2, 1024, "CompleteMultipartUpload")
+
+	group := closeAndGetSubsegment(t, root)
+	if e, a := MultipartUploadSegmentName, group.Name; e != a {
+		t.Errorf("expected subsegment name to be %s, got %s", e, a)
+	}
+	checkOperations(t, group, map[string]int{
+		"CreateMultipartUpload":   1,
+		"UploadPart":              2,
+		"CompleteMultipartUpload": 1,
+	})
+}
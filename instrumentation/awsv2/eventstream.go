@@ -0,0 +1,251 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package awsv2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// EventStreamTimeout bounds how long the subsegment of an event stream
+// operation is kept open. Event streams keep the response open until the
+// caller closes them; if the caller never does, the subsegment is closed
+// with an error once the timeout expires so it is not leaked.
+// A zero value disables the safety net.
+var EventStreamTimeout = 15 * time.Minute
+
+const eventStreamContentType = "application/vnd.amazon.eventstream"
+
+// errEventStreamTimeout is recorded when EventStreamTimeout expires before the
+// event stream is closed.
+var errEventStreamTimeout = errors.New("event stream was not closed before EventStreamTimeout")
+
+// eventStream tracks the operation subsegment of a request whose response
+// may turn out to be an event stream.
+type eventStream struct {
+	subseg *xray.Segment
+
+	mu      sync.Mutex
+	open    bool
+	events  int
+	timer   *time.Timer
+	closeFn sync.Once
+}
+
+// start marks the response as an event stream, so that the subsegment is
+// closed by finish instead of when the operation returns.
+func (es *eventStream) start() {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.open {
+		return
+	}
+	es.open = true
+	if EventStreamTimeout > 0 {
+		es.timer = time.AfterFunc(EventStreamTimeout, func() {
+			es.finish(errEventStreamTimeout)
+		})
+	}
+}
+
+func (es *eventStream) streaming() bool {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.open
+}
+
+func (es *eventStream) addEvent() {
+	es.mu.Lock()
+	es.events++
+	es.mu.Unlock()
+}
+
+// finish closes the subsegment exactly once, recording the number of events
+// received if the response was an event stream.
+func (es *eventStream) finish(err error) {
+	es.closeFn.Do(func() {
+		es.mu.Lock()
+		open, events := es.open, es.events
+		if es.timer != nil {
+			es.timer.Stop()
+		}
+		es.mu.Unlock()
+
+		if open {
+			es.subseg.Lock()
+			es.subseg.GetAWS()["event_count"] = events
+			es.subseg.Unlock()
+		}
+		es.subseg.Close(err)
+	})
+}
+
+// eventStreamBody wraps the body of an event stream response, counting the
+// messages read from it and closing the subsegment when the stream ends.
+//
+// Each message starts with a prelude holding the total message length and
+// the headers length, followed by the headers, the payload and a checksum.
+// Only the prelude and the headers are inspected, to detect error and
+// exception events.
+type eventStreamBody struct {
+	io.ReadCloser
+	es *eventStream
+
+	head      []byte // prelude and headers of the current message
+	headLen   int    // length of head, known once the prelude is read
+	payload   int    // bytes left in the payload and checksum of the message
+	inPayload bool
+	malformed bool
+	err       error // terminal error carried by an error or exception event
+}
+
+func (b *eventStreamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.scan(p[:n])
+	if err == io.EOF {
+		b.es.finish(b.err)
+	} else if err != nil {
+		b.es.finish(err)
+	}
+	return n, err
+}
+
+func (b *eventStreamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.es.finish(b.err)
+	return err
+}
+
+const (
+	eventStreamPreludeLen  = 12
+	eventStreamChecksumLen = 4
+)
+
+func (b *eventStreamBody) scan(p []byte) {
+	for len(p) > 0 && !b.malformed {
+		if b.inPayload {
+			n := b.payload
+			if n > len(p) {
+				n = len(p)
+			}
+			b.payload -= n
+			p = p[n:]
+			if b.payload == 0 {
+				b.inPayload = false
+				b.head, b.headLen = b.head[:0], 0
+			}
+			continue
+		}
+
+		want := eventStreamPreludeLen
+		if b.headLen > 0 {
+			want = b.headLen
+		}
+		n := want - len(b.head)
+		if n > len(p) {
+			n = len(p)
+		}
+		b.head = append(b.head, p[:n]...)
+		p = p[n:]
+		if len(b.head) < want {
+			return
+		}
+
+		if b.headLen == 0 {
+			total := int(binary.BigEndian.Uint32(b.head[0:4]))
+			b.headLen = eventStreamPreludeLen + int(binary.BigEndian.Uint32(b.head[4:8]))
+			if total < b.headLen+eventStreamChecksumLen {
+				// Not an event stream we understand; stop counting.
+				b.malformed = true
+				return
+			}
+			b.payload = total - b.headLen
+			if b.headLen > len(b.head) {
+				continue
+			}
+		}
+
+		b.endHeaders()
+		b.inPayload = true
+	}
+}
+
+// endHeaders counts the current message and records the error carried by
+// error and exception events.
+func (b *eventStreamBody) endHeaders() {
+	b.es.addEvent()
+	headers := parseEventStreamHeaders(b.head[eventStreamPreludeLen:b.headLen])
+	switch headers[":message-type"] {
+	case "error":
+		b.err = fmt.Errorf("event stream error %s: %s", headers[":error-code"], headers[":error-message"])
+	case "exception":
+		b.err = fmt.Errorf("event stream exception %s", headers[":exception-type"])
+	}
+}
+
+// parseEventStreamHeaders returns the string valued headers of a message.
+func parseEventStreamHeaders(p []byte) map[string]string {
+	headers := make(map[string]string)
+	r := bytes.NewReader(p)
+	for r.Len() > 0 {
+		nameLen, err := r.ReadByte()
+		if err != nil {
+			break
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(r, name); err != nil {
+			break
+		}
+		typ, err := r.ReadByte()
+		if err != nil {
+			break
+		}
+		var size int
+		switch typ {
+		case 0, 1: // bool true, bool false
+		case 2: // byte
+			size = 1
+		case 3: // int16
+			size = 2
+		case 4: // int32
+			size = 4
+		case 5, 8: // int64, timestamp
+			size = 8
+		case 9: // uuid
+			size = 16
+		case 6, 7: // byte array, string
+			var l uint16
+			if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+				return headers
+			}
+			value := make([]byte, l)
+			if _, err := io.ReadFull(r, value); err != nil {
+				return headers
+			}
+			if typ == 7 {
+				headers[strings.ToLower(string(name))] = string(value)
+			}
+			continue
+		default:
+			return headers
+		}
+		if _, err := r.Seek(int64(size), io.SeekCurrent); err != nil {
+			break
+		}
+	}
+	return headers
+}
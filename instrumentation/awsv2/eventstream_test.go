@@ -0,0 +1,209 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package awsv2
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// encodeEventStreamMessage encodes a message with string headers. The
+// checksums are not verified by the instrumentation, so they are left zero.
+func encodeEventStreamMessage(headers map[string]string, payload []byte) []byte {
+	var h bytes.Buffer
+	for name, value := range headers {
+		h.WriteByte(byte(len(name)))
+		h.WriteString(name)
+		h.WriteByte(7)
+		_ = binary.Write(&h, binary.BigEndian, uint16(len(value)))
+		h.WriteString(value)
+	}
+
+	var m bytes.Buffer
+	_ = binary.Write(&m, binary.BigEndian, uint32(eventStreamPreludeLen+h.Len()+len(payload)+eventStreamChecksumLen))
+	_ = binary.Write(&m, binary.BigEndian, uint32(h.Len()))
+	m.Write(make([]byte, 4))
+	m.Write(h.Bytes())
+	m.Write(payload)
+	m.Write(make([]byte, eventStreamChecksumLen))
+	return m.Bytes()
+}
+
+// invokeEventStreamOperation runs an instrumented middleware stack against a
+// stubbed transport returning body, and returns the response body handed to
+// the caller along with the operation subsegment.
+func invokeEventStreamOperation(ctx context.Context, t *testing.T, contentType string, body []byte) (io.ReadCloser, *xray.Segment) {
+	var apiOptions []func(*middleware.Stack) error
+	AWSV2Instrumentor(&apiOptions)
+
+	stack := middleware.NewStack("test", smithyhttp.NewStackRequest)
+	for _, fn := range apiOptions {
+		if err := fn(stack); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var subseg *xray.Segment
+	// Stands in for the operation deserializer, handing the body to the caller.
+	err := stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("TestDeserializer", func(
+		ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (
+		out middleware.DeserializeOutput, metadata middleware.Metadata, err error) {
+
+		subseg = ctx.Value(awsV2SubsegmentKey{}).(*xray.Segment)
+		out, metadata, err = next.HandleDeserialize(ctx, in)
+		out.Result = out.RawResponse.(*smithyhttp.Response).Body
+		return out, metadata, err
+	}), middleware.Before)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := middleware.HandlerFunc(func(ctx context.Context, in interface{}) (interface{}, middleware.Metadata, error) {
+		return &smithyhttp.Response{Response: &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{contentType}},
+			Body:       io.NopCloser(bytes.NewReader(body)),
+		}}, middleware.Metadata{}, nil
+	})
+
+	out, _, err := middleware.DecorateHandler(transport, stack).Handle(ctx, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.(io.ReadCloser), subseg
+}
+
+func inProgress(seg *xray.Segment) bool {
+	seg.RLock()
+	defer seg.RUnlock()
+	return seg.InProgress
+}
+
+func TestAWSV2EventStream(t *testing.T) {
+	ctx, root := xray.BeginSegment(context.Background(), "AWSSDKV2_EventStream")
+	defer root.Close(nil)
+
+	event := map[string]string{":message-type": "event", ":event-type": "Records"}
+	var stream []byte
+	for i := 0; i < 3; i++ {
+		stream = append(stream, encodeEventStreamMessage(event, []byte("payload"))...)
+	}
+	stream = append(stream, encodeEventStreamMessage(map[string]string{":message-type": "event", ":event-type": "End"}, nil)...)
+
+	body, subseg := invokeEventStreamOperation(ctx, t, eventStreamContentType, stream)
+	if !inProgress(subseg) {
+		t.Fatal("expected subsegment to stay open while the event stream is read")
+	}
+
+	// Read one byte at a time to cross every message boundary.
+	if _, err := io.ReadAll(iotest.OneByteReader(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if inProgress(subseg) {
+		t.Fatal("expected subsegment to be closed with the event stream")
+	}
+	subseg.RLock()
+	defer subseg.RUnlock()
+	if e, a := 4, subseg.GetAWS()["event_count"]; e != a {
+		t.Errorf("expected event count to be %v, got %v", e, a)
+	}
+	if subseg.Fault || subseg.Error {
+		t.Error("expected subsegment without error")
+	}
+}
+
+func TestAWSV2EventStreamException(t *testing.T) {
+	ctx, root := xray.BeginSegment(context.Background(), "AWSSDKV2_EventStream")
+	defer root.Close(nil)
+
+	stream := encodeEventStreamMessage(map[string]string{":message-type": "event", ":event-type": "Records"}, []byte("payload"))
+	stream = append(stream, encodeEventStreamMessage(map[string]string{
+		":message-type":   "exception",
+		":exception-type": "InternalError",
+	}, []byte(`{"Message":"boom"}`))...)
+
+	body, subseg := invokeEventStreamOperation(ctx, t, eventStreamContentType, stream)
+	if _, err := io.ReadAll(body); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reaching the end of the stream closes the subsegment.
+	if inProgress(subseg) {
+		t.Fatal("expected subsegment to be closed at the end of the event stream")
+	}
+	_ = body.Close()
+
+	subseg.RLock()
+	defer subseg.RUnlock()
+	if e, a := 2, subseg.GetAWS()["event_count"]; e != a {
+		t.Errorf("expected event count to be %v, got %v", e, a)
+	}
+	if !subseg.Fault {
+		t.Error("expected subsegment to record the exception event")
+	}
+	if subseg.Cause == nil || len(subseg.Cause.Exceptions) == 0 {
+		t.Fatal("expected subsegment to record the exception event")
+	}
+	if e, a := "event stream exception InternalError", subseg.Cause.Exceptions[0].Message; e != a {
+		t.Errorf("expected exception message to be %q, got %q", e, a)
+	}
+}
+
+func TestAWSV2EventStreamNotClosed(t *testing.T) {
+	defer func(d time.Duration) { EventStreamTimeout = d }(EventStreamTimeout)
+	EventStreamTimeout = 50 * time.Millisecond
+
+	ctx, root := xray.BeginSegment(context.Background(), "AWSSDKV2_EventStream")
+	defer root.Close(nil)
+
+	stream := encodeEventStreamMessage(map[string]string{":message-type": "event"}, []byte("payload"))
+	_, subseg := invokeEventStreamOperation(ctx, t, eventStreamContentType, stream)
+	if !inProgress(subseg) {
+		t.Fatal("expected subsegment to stay open while the event stream is read")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if inProgress(subseg) {
+		t.Fatal("expected subsegment to be closed once EventStreamTimeout expired")
+	}
+	subseg.RLock()
+	defer subseg.RUnlock()
+	if !subseg.Fault {
+		t.Error("expected subsegment to record the timeout")
+	}
+}
+
+func TestAWSV2NotEventStream(t *testing.T) {
+	ctx, root := xray.BeginSegment(context.Background(), "AWSSDKV2_EventStream")
+	defer root.Close(nil)
+
+	_, subseg := invokeEventStreamOperation(ctx, t, "application/json", []byte(`{}`))
+	if inProgress(subseg) {
+		t.Fatal("expected subsegment to be closed when the operation returns")
+	}
+	subseg.RLock()
+	defer subseg.RUnlock()
+	if _, ok := subseg.GetAWS()["event_count"]; ok {
+		t.Error("expected no event count outside of event streams")
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package awsv2
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+
+	v2Middleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// MultipartUploadSegmentName is the name of the subsegment grouping the
+// operations of an S3 multipart upload.
+const MultipartUploadSegmentName = "S3 Upload"
+
+// errMultipartUploadAborted is recorded on the subsegment of a multipart
+// upload closed by AbortMultipartUpload.
+var errMultipartUploadAborted = errors.New("multipart upload aborted")
+
+type skipSubsegmentKey struct{}
+
+// multipartUpload is the subsegment grouping the operations of a multipart
+// upload, shared by the goroutines uploading its parts.
+type multipartUpload struct {
+	subseg *xray.Segment
+
+	mu        sync.Mutex
+	started   int // parts recorded in their own subsegment
+	completed int
+	bytesSent int64
+}
+
+// startPart reports whether the next part should be recorded in its own
+// subsegment, rather than only counted, given threshold.
+func (u *multipartUpload) startPart(threshold int) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if threshold > 0 && u.started >= threshold {
+		return false
+	}
+	u.started++
+	return true
+}
+
+func (u *multipartUpload) completePart(size int64) {
+	u.mu.Lock()
+	u.completed++
+	u.bytesSent += size
+	u.mu.Unlock()
+}
+
+// finish records the part counters and closes the subsegment with err.
+func (u *multipartUpload) finish(err error) {
+	u.mu.Lock()
+	completed, bytesSent := u.completed, u.bytesSent
+	u.mu.Unlock()
+
+	u.subseg.AddMetadataToNamespace("aws", "parts_completed", completed)
+	u.subseg.AddMetadataToNamespace("aws", "bytes_sent", bytesSent)
+	u.subseg.Close(err)
+}
+
+// multipartMiddlewareID is the ID of the middleware grouping multipart
+// uploads.
+const multipartMiddlewareID = "XRayMultipartUploadMiddleware"
+
+// multipartUploads tracks the multipart uploads in progress by upload ID.
+type multipartUploads struct {
+	partThreshold int
+
+	mu      sync.Mutex
+	uploads map[string]*multipartUpload
+}
+
+func (m *multipartUploads) get(uploadID string) *multipartUpload {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.uploads[uploadID]
+}
+
+func (m *multipartUploads) put(uploadID string, u *multipartUpload) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads[uploadID] = u
+}
+
+func (m *multipartUploads) remove(uploadID string) *multipartUpload {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.uploads[uploadID]
+	delete(m.uploads, uploadID)
+	return u
+}
+
+// middleware groups the operations of S3 multipart uploads under a
+// subsegment named MultipartUploadSegmentName. CreateMultipartUpload begins
+// it, UploadPart operations are recorded as its children, or only counted
+// above partThreshold parts, and CompleteMultipartUpload or
+// AbortMultipartUpload close it. Uploads which are neither completed nor
+// aborted are never closed.
+func (m *multipartUploads) middleware(stack *middleware.Stack) error {
+	if _, ok := stack.Initialize.Get(multipartMiddlewareID); ok {
+		return nil
+	}
+	mw := middleware.InitializeMiddlewareFunc(multipartMiddlewareID, func(
+		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
+		out middleware.InitializeOutput, metadata middleware.Metadata, err error) {
+
+		if v2Middleware.GetServiceID(ctx) != "S3" || xray.GetSegment(ctx) == nil {
+			return next.HandleInitialize(ctx, in)
+		}
+
+		switch v2Middleware.GetOperationName(ctx) {
+		case "CreateMultipartUpload":
+			_, subseg := xray.BeginSubsegment(ctx, MultipartUploadSegmentName)
+			u := &multipartUpload{subseg: subseg}
+
+			out, metadata, err = next.HandleInitialize(context.WithValue(ctx, xray.ContextKey, subseg), in)
+
+			uploadID := stringField(out.Result, "UploadId")
+			if err != nil || uploadID == "" {
+				u.finish(err)
+				return out, metadata, err
+			}
+			subseg.AddMetadataToNamespace("aws", "upload_id", uploadID)
+			m.put(uploadID, u)
+			return out, metadata, err
+
+		case "UploadPart":
+			u := m.get(stringField(in.Parameters, "UploadId"))
+			if u == nil {
+				return next.HandleInitialize(ctx, in)
+			}
+			if u.startPart(m.partThreshold) {
+				ctx = context.WithValue(ctx, xray.ContextKey, u.subseg)
+			} else {
+				ctx = context.WithValue(ctx, skipSubsegmentKey{}, true)
+			}
+
+			out, metadata, err = next.HandleInitialize(ctx, in)
+			if err == nil {
+				u.completePart(intField(in.Parameters, "ContentLength"))
+			}
+			return out, metadata, err
+
+		case "CompleteMultipartUpload", "AbortMultipartUpload":
+			uploadID := stringField(in.Parameters, "UploadId")
+			u := m.get(uploadID)
+			if u == nil {
+				return next.HandleInitialize(ctx, in)
+			}
+
+			out, metadata, err = next.HandleInitialize(context.WithValue(ctx, xray.ContextKey, u.subseg), in)
+
+			if u = m.remove(uploadID); u != nil {
+				if err == nil && v2Middleware.GetOperationName(ctx) == "AbortMultipartUpload" {
+					u.finish(errMultipartUploadAborted)
+				} else {
+					u.finish(err)
+				}
+			}
+			return out, metadata, err
+		}
+		return next.HandleInitialize(ctx, in)
+	})
+
+	// The upload is begun ahead of the subsegments of its operations, also
+	// when the rest of the X-Ray middleware was added by the aws.Config of
+	// the client.
+	if _, ok := stack.Initialize.Get(initializeMiddlewareID); ok {
+		return stack.Initialize.Insert(mw, initializeMiddlewareID, middleware.Before)
+	}
+	return stack.Initialize.Add(mw, middleware.After)
+}
+
+// stringField returns the value of the *string or string field name of the
+// struct v points to, or an empty string.
+func stringField(v interface{}, name string) string {
+	f := field(v, name)
+	if f.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			return ""
+		}
+		f = f.Elem()
+	}
+	if f.Kind() != reflect.String {
+		return ""
+	}
+	return f.String()
+}
+
+// intField returns the value of the integer or pointer to integer field name
+// of the struct v points to, or 0.
+func intField(v interface{}, name string) int64 {
+	f := field(v, name)
+	if f.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			return 0
+		}
+		f = f.Elem()
+	}
+	switch f.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return f.Int()
+	}
+	return 0
+}
+
+func field(v interface{}, name string) reflect.Value {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return reflect.Value{}
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	return rv.FieldByName(name)
+}
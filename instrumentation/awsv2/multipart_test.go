@@ -0,0 +1,253 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package awsv2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v2Middleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Stand-ins for the shapes of the S3 multipart upload operations.
+type createMultipartUploadOutput struct {
+	UploadId *string
+}
+
+type uploadPartInput struct {
+	Bucket        *string
+	Key           *string
+	UploadId      *string
+	PartNumber    *int32
+	ContentLength *int64
+}
+
+type completeMultipartUploadInput struct {
+	Bucket   *string
+	Key      *string
+	UploadId *string
+}
+
+// s3Stub invokes S3 operations, instrumented with the same API options, on an
+// httptest server.
+type s3Stub struct {
+	t          *testing.T
+	server     *httptest.Server
+	apiOptions []func(*middleware.Stack) error
+}
+
+func newS3Stub(t *testing.T, opts InstrumentorOptions) *s3Stub {
+	s := &s3Stub{t: t}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amz-Request-Id", "request-id")
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(s.server.Close)
+	AWSV2InstrumentorWithOptions(&s.apiOptions, opts)
+	return s
+}
+
+// invoke runs operation with params, and returns result as its output.
+func (s *s3Stub) invoke(ctx context.Context, operation string, params, result interface{}) error {
+	stack := middleware.NewStack(operation, smithyhttp.NewStackRequest)
+	err := stack.Initialize.Add(&v2Middleware.RegisterServiceMetadata{
+		ServiceID:     "S3",
+		Region:        "us-west-2",
+		OperationName: operation,
+	}, middleware.Before)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	err = stack.Serialize.Add(middleware.SerializeMiddlewareFunc("OperationSerializer", func(
+		ctx context.Context, in middleware.SerializeInput, next middleware.SerializeHandler) (
+		middleware.SerializeOutput, middleware.Metadata, error) {
+
+		u, err := url.Parse(s.server.URL + "/bucket/key")
+		if err != nil {
+			return middleware.SerializeOutput{}, middleware.Metadata{}, err
+		}
+		req := in.Request.(*smithyhttp.Request)
+		req.Method = http.MethodPut
+		req.URL = u
+		return next.HandleSerialize(ctx, in)
+	}), middleware.After)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	err = stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("OperationDeserializer", func(
+		ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (
+		out middleware.DeserializeOutput, metadata middleware.Metadata, err error) {
+
+		out, metadata, err = next.HandleDeserialize(ctx, in)
+		out.Result = result
+		return out, metadata, err
+	}), middleware.After)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	for _, fn := range s.apiOptions {
+		if err := fn(stack); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+
+	handler := middleware.DecorateHandler(smithyhttp.NewClientHandler(s.server.Client()), stack)
+	_, _, err = handler.Handle(ctx, params)
+	return err
+}
+
+// upload runs a multipart upload of parts parts of size bytes, uploaded
+// concurrently, ended with the operation end.
+func (s *s3Stub) upload(ctx context.Context, parts int, size int64, end string) {
+	err := s.invoke(ctx, "CreateMultipartUpload", &struct{}{}, &createMultipartUploadOutput{UploadId: aws.String("upload-1")})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= parts; i++ {
+		wg.Add(1)
+		go func(part int32) {
+			defer wg.Done()
+			err := s.invoke(ctx, "UploadPart", &uploadPartInput{
+				Bucket:        aws.String("bucket"),
+				Key:           aws.String("key"),
+				UploadId:      aws.String("upload-1"),
+				PartNumber:    aws.Int32(part),
+				ContentLength: aws.Int64(size),
+			}, &struct{}{})
+			if err != nil {
+				s.t.Error(err)
+			}
+		}(int32(i))
+	}
+	wg.Wait()
+
+	err = s.invoke(ctx, end, &completeMultipartUploadInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("key"),
+		UploadId: aws.String("upload-1"),
+	}, &struct{}{})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// operations returns the operations of the subsegments of seg, by name.
+func operations(t *testing.T, seg *xray.Segment) map[string]int {
+	ops := make(map[string]int)
+	for _, raw := range seg.Subsegments {
+		var subseg *xray.Segment
+		if err := json.Unmarshal(raw, &subseg); err != nil {
+			t.Fatal(err)
+		}
+		ops[subseg.AWS["operation"].(string)]++
+	}
+	return ops
+}
+
+// checkOperations checks the operations of the subsegments of seg.
+func checkOperations(t *testing.T, seg *xray.Segment, expected map[string]int) {
+	t.Helper()
+	if a := operations(t, seg); !reflect.DeepEqual(expected, a) {
+		t.Errorf("expected operations %v, got %v", expected, a)
+	}
+}
+
+// checkPartCounters checks the counters recorded on the subsegment of a
+// multipart upload.
+func checkPartCounters(t *testing.T, group *xray.Segment, parts, bytesSent int) {
+	t.Helper()
+	if e, a := float64(parts), group.Metadata["aws"]["parts_completed"]; e != a {
+		t.Errorf("expected parts_completed to be %v, got %v", e, a)
+	}
+	if e, a := float64(bytesSent), group.Metadata["aws"]["bytes_sent"]; e != a {
+		t.Errorf("expected bytes_sent to be %v, got %v", e, a)
+	}
+}
+
+func TestAWSV2MultipartUploadGrouped(t *testing.T) {
+	s := newS3Stub(t, InstrumentorOptions{GroupMultipartUploads: true})
+	ctx, root := beginSampledSegment(t, "AWSSDKV2_MultipartUpload")
+
+	s.upload(ctx, 8, 1024, "CompleteMultipartUpload")
+
+	group := closeAndGetSubsegment(t, root)
+	if e, a := MultipartUploadSegmentName, group.Name; e != a {
+		t.Errorf("expected subsegment name to be %s, got %s", e, a)
+	}
+	if group.Fault {
+		t.Errorf("expected subsegment not to be a fault")
+	}
+	if e, a := "upload-1", group.Metadata["aws"]["upload_id"]; e != a {
+		t.Errorf("expected upload_id to be %s, got %v", e, a)
+	}
+	checkPartCounters(t, group, 8, 8*1024)
+	checkOperations(t, group, map[string]int{
+		"CreateMultipartUpload":   1,
+		"UploadPart":              8,
+		"CompleteMultipartUpload": 1,
+	})
+}
+
+func TestAWSV2MultipartUploadPartThreshold(t *testing.T) {
+	s := newS3Stub(t, InstrumentorOptions{GroupMultipartUploads: true, MultipartUploadPartThreshold: 3})
+	ctx, root := beginSampledSegment(t, "AWSSDKV2_MultipartUpload")
+
+	s.upload(ctx, 10, 1024, "CompleteMultipartUpload")
+
+	group := closeAndGetSubsegment(t, root)
+	checkPartCounters(t, group, 10, 10*1024)
+	checkOperations(t, group, map[string]int{
+		"CreateMultipartUpload":   1,
+		"UploadPart":              3,
+		"CompleteMultipartUpload": 1,
+	})
+}
+
+func TestAWSV2MultipartUploadAborted(t *testing.T) {
+	s := newS3Stub(t, InstrumentorOptions{GroupMultipartUploads: true})
+	ctx, root := beginSampledSegment(t, "AWSSDKV2_MultipartUpload")
+
+	s.upload(ctx, 2, 1024, "AbortMultipartUpload")
+
+	group := closeAndGetSubsegment(t, root)
+	if !group.Fault {
+		t.Errorf("expected aborted upload to be a fault")
+	}
+	checkOperations(t, group, map[string]int{
+		"CreateMultipartUpload": 1,
+		"UploadPart":            2,
+		"AbortMultipartUpload":  1,
+	})
+}
+
+func TestAWSV2MultipartUploadNotGrouped(t *testing.T) {
+	s := newS3Stub(t, InstrumentorOptions{})
+	ctx, root := beginSampledSegment(t, "AWSSDKV2_MultipartUpload")
+
+	s.upload(ctx, 2, 1024, "CompleteMultipartUpload")
+	root.Close(nil)
+
+	checkOperations(t, root, map[string]int{
+		"CreateMultipartUpload":   1,
+		"UploadPart":              2,
+		"CompleteMultipartUpload": 1,
+	})
+}
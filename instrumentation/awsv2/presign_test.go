@@ -0,0 +1,185 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package awsv2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	v2Middleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// newTestStack returns the stack of a GetObject operation, as built by an S3
+// client, with finalize as its only finalize middleware.
+func newTestStack(t *testing.T, finalize middleware.FinalizeMiddleware) *middleware.Stack {
+	stack := middleware.NewStack("GetObject", smithyhttp.NewStackRequest)
+	err := stack.Initialize.Add(&v2Middleware.RegisterServiceMetadata{
+		ServiceID:     "S3",
+		Region:        "us-west-2",
+		OperationName: "GetObject",
+	}, middleware.Before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stack.Serialize.Add(middleware.SerializeMiddlewareFunc("OperationSerializer", func(
+		ctx context.Context, in middleware.SerializeInput, next middleware.SerializeHandler) (
+		middleware.SerializeOutput, middleware.Metadata, error) {
+
+		req := in.Request.(*smithyhttp.Request)
+		req.Method = http.MethodGet
+		req.URL.Scheme = "https"
+		req.URL.Host = "bucket.s3.us-west-2.amazonaws.com"
+		req.URL.Path = "/key"
+		return next.HandleSerialize(ctx, in)
+	}), middleware.After)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stack.Finalize.Add(finalize, middleware.After); err != nil {
+		t.Fatal(err)
+	}
+
+	var apiOptions []func(*middleware.Stack) error
+	AWSV2Instrumentor(&apiOptions)
+	for _, fn := range apiOptions {
+		if err := fn(stack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return stack
+}
+
+// beginSampledSegment begins a segment which is sampled regardless of the
+// requests sampled before.
+func beginSampledSegment(t *testing.T, name string) (context.Context, *xray.Segment) {
+	ctx, err := xray.ContextWithConfig(context.Background(), xray.Config{
+		SamplingStrategy: sampling.NewFuncStrategy(func(*sampling.Request) bool { return true }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return xray.BeginSegment(ctx, name)
+}
+
+// closeAndGetSubsegment closes the root segment and returns its only subsegment.
+func closeAndGetSubsegment(t *testing.T, root *xray.Segment) *xray.Segment {
+	root.Close(nil)
+	if len(root.Subsegments) != 1 {
+		t.Fatalf("expected 1 subsegment, got %d", len(root.Subsegments))
+	}
+	var subseg *xray.Segment
+	if err := json.Unmarshal(root.Subsegments[0], &subseg); err != nil {
+		t.Fatal(err)
+	}
+	return subseg
+}
+
+func TestAWSV2Presign(t *testing.T) {
+	// Presign clients replace the finalize middleware with one returning
+	// the presigned request, which is never sent.
+	presign := middleware.FinalizeMiddlewareFunc("PresignHTTPRequest", func(
+		ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+		out middleware.FinalizeOutput, metadata middleware.Metadata, err error) {
+
+		req := in.Request.(*smithyhttp.Request)
+		out.Result = &v4.PresignedHTTPRequest{
+			URL:    req.URL.String() + "?X-Amz-Signature=secret",
+			Method: req.Method,
+		}
+		return out, metadata, nil
+	})
+	handler := middleware.DecorateHandler(middleware.HandlerFunc(func(ctx context.Context, in interface{}) (
+		interface{}, middleware.Metadata, error) {
+
+		t.Error("presigned request was sent")
+		return nil, middleware.Metadata{}, nil
+	}), newTestStack(t, presign))
+
+	ctx, root := beginSampledSegment(t, "AWSSDKV2_S3Presign")
+	out, _, err := handler.Handle(ctx, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := out.(*v4.PresignedHTTPRequest); !ok {
+		t.Fatalf("expected a presigned request, got %T", out)
+	}
+
+	subseg := closeAndGetSubsegment(t, root)
+	if subseg.InProgress || subseg.EndTime == 0 {
+		t.Errorf("expected subsegment to be closed")
+	}
+	if e, a := "S3", subseg.Name; e != a {
+		t.Errorf("expected subsegment name to be %s, got %s", e, a)
+	}
+	if e, a := true, subseg.GetAWS()["presigned"]; e != a {
+		t.Errorf("expected presigned to be %v, got %v", e, a)
+	}
+	if e, a := "bucket.s3.us-west-2.amazonaws.com", subseg.GetAWS()["presigned_url_host"]; e != a {
+		t.Errorf("expected presigned URL host to be %s, got %v", e, a)
+	}
+	if e, a := http.MethodGet, subseg.GetHTTP().GetRequest().Method; e != a {
+		t.Errorf("expected method to be %s, got %s", e, a)
+	}
+	if b, _ := json.Marshal(subseg); strings.Contains(string(b), "secret") {
+		t.Errorf("expected signature not to be recorded, got %s", b)
+	}
+	for _, key := range []string{"marshal_ms", "signing_ms"} {
+		if _, ok := subseg.Metadata["aws"][key]; !ok {
+			t.Errorf("expected %s metadata, got %v", key, subseg.Metadata)
+		}
+	}
+}
+
+func TestAWSV2Timings(t *testing.T) {
+	signing := middleware.FinalizeMiddlewareFunc("Signing", func(
+		ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+		middleware.FinalizeOutput, middleware.Metadata, error) {
+
+		in.Request.(*smithyhttp.Request).Header.Set("Authorization", "AWS4-HMAC-SHA256 Signature=secret")
+		return next.HandleFinalize(ctx, in)
+	})
+	handler := middleware.DecorateHandler(middleware.HandlerFunc(func(ctx context.Context, in interface{}) (
+		interface{}, middleware.Metadata, error) {
+
+		return &smithyhttp.Response{Response: &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       http.NoBody,
+		}}, middleware.Metadata{}, nil
+	}), newTestStack(t, signing))
+
+	ctx, root := beginSampledSegment(t, "AWSSDKV2_S3")
+	if _, _, err := handler.Handle(ctx, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	subseg := closeAndGetSubsegment(t, root)
+	if subseg.InProgress {
+		t.Errorf("expected subsegment to be closed")
+	}
+	if _, ok := subseg.GetAWS()["presigned"]; ok {
+		t.Errorf("expected request not to be recorded as presigned")
+	}
+	if e, a := http.StatusOK, subseg.GetHTTP().GetResponse().Status; e != a {
+		t.Errorf("expected status code to be %d, got %d", e, a)
+	}
+	for _, key := range []string{"marshal_ms", "signing_ms"} {
+		if _, ok := subseg.Metadata["aws"][key]; !ok {
+			t.Errorf("expected %s metadata, got %v", key, subseg.Metadata)
+		}
+	}
+}
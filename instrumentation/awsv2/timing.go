@@ -0,0 +1,117 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package awsv2
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// signingMiddlewareIDs are the IDs of the finalize middleware signing
+// requests, and of the one presigning them in presign clients.
+var signingMiddlewareIDs = []string{"Signing", "PresignHTTPRequest"}
+
+type timingsKey struct{}
+
+// timings records how long the steps of an operation take.
+type timings struct {
+	marshalStart time.Time
+	marshal      time.Duration
+	signingStart time.Time
+	signing      time.Duration
+}
+
+// record adds the measured durations to the metadata of the subsegment of
+// the operation, in milliseconds.
+func (t *timings) record(subseg *xray.Segment) {
+	if t.marshal > 0 {
+		subseg.AddMetadataToNamespace("aws", "marshal_ms", float64(t.marshal)/float64(time.Millisecond))
+	}
+	if t.signing > 0 {
+		subseg.AddMetadataToNamespace("aws", "signing_ms", float64(t.signing)/float64(time.Millisecond))
+	}
+}
+
+// timingMiddleware measures the time spent in the serialize step, marshalling
+// the input, and in the middleware signing the request.
+func timingMiddleware(stack *middleware.Stack) error {
+	if _, ok := stack.Serialize.Get("XRayMarshalStart"); ok {
+		return nil
+	}
+	err := stack.Serialize.Add(middleware.SerializeMiddlewareFunc("XRayMarshalStart", func(
+		ctx context.Context, in middleware.SerializeInput, next middleware.SerializeHandler) (
+		middleware.SerializeOutput, middleware.Metadata, error) {
+
+		if t, ok := ctx.Value(timingsKey{}).(*timings); ok {
+			t.marshalStart = time.Now()
+		}
+		return next.HandleSerialize(ctx, in)
+	}), middleware.Before)
+	if err != nil {
+		return err
+	}
+
+	err = stack.Serialize.Add(middleware.SerializeMiddlewareFunc("XRayMarshalEnd", func(
+		ctx context.Context, in middleware.SerializeInput, next middleware.SerializeHandler) (
+		middleware.SerializeOutput, middleware.Metadata, error) {
+
+		if t, ok := ctx.Value(timingsKey{}).(*timings); ok && !t.marshalStart.IsZero() {
+			t.marshal = time.Since(t.marshalStart)
+		}
+		return next.HandleSerialize(ctx, in)
+	}), middleware.After)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range signingMiddlewareIDs {
+		if _, ok := stack.Finalize.Get(id); ok {
+			return timeSigning(stack, id)
+		}
+	}
+	return nil
+}
+
+// timeSigning measures the time spent in the finalize middleware id. The
+// presign middleware doesn't call the rest of the stack, so the duration is
+// then measured once it returns.
+func timeSigning(stack *middleware.Stack, id string) error {
+	err := stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("XRaySigningStart", func(
+		ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+		middleware.FinalizeOutput, middleware.Metadata, error) {
+
+		t, ok := ctx.Value(timingsKey{}).(*timings)
+		if !ok {
+			return next.HandleFinalize(ctx, in)
+		}
+
+		t.signingStart, t.signing = time.Now(), 0
+		out, metadata, err := next.HandleFinalize(ctx, in)
+		if t.signing == 0 {
+			t.signing = time.Since(t.signingStart)
+		}
+		return out, metadata, err
+	}), id, middleware.Before)
+	if err != nil {
+		return err
+	}
+
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("XRaySigningEnd", func(
+		ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+		middleware.FinalizeOutput, middleware.Metadata, error) {
+
+		if t, ok := ctx.Value(timingsKey{}).(*timings); ok {
+			t.signing = time.Since(t.signingStart)
+		}
+		return next.HandleFinalize(ctx, in)
+	}), id, middleware.After)
+}
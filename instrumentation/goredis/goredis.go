@@ -0,0 +1,133 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+// Package goredis records subsegments for the commands of go-redis clients.
+package goredis
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-redis/redis/v8"
+)
+
+// metadataNamespace is the metadata namespace of the commands recorded on
+// subsegments.
+const metadataNamespace = "redis"
+
+var _ redis.Hook = (*Hook)(nil)
+
+type subsegmentKey struct{}
+
+// Hook records a remote subsegment for each command of a go-redis client,
+// and a single one for each pipeline or transaction. Only the names of the
+// commands are recorded, their arguments may hold sensitive values.
+//
+//	rdb := redis.NewClient(opts)
+//	rdb.AddHook(goredis.NewHook(opts.Addr))
+type Hook struct {
+	name string
+}
+
+// NewHook returns a Hook naming subsegments name, the host:port of the
+// redis endpoint or any name identifying it.
+func NewHook(name string) *Hook {
+	return &Hook{name: name}
+}
+
+// BeforeProcess begins a subsegment for cmd. When ctx holds no segment, the
+// ContextMissingStrategy handles it and ctx is returned as is.
+func (h *Hook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.begin(ctx), nil
+}
+
+// AfterProcess closes the subsegment of cmd, recording its name and error.
+func (h *Hook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if seg, ok := ctx.Value(subsegmentKey{}).(*xray.Segment); ok {
+		seg.AddMetadataToNamespace(metadataNamespace, "command", cmd.Name())
+		end(seg, []redis.Cmder{cmd})
+	}
+	return nil
+}
+
+// BeforeProcessPipeline begins a subsegment for the commands of a pipeline
+// or transaction.
+func (h *Hook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return h.begin(ctx), nil
+}
+
+// AfterProcessPipeline closes the subsegment of a pipeline or transaction,
+// recording the number and names of its commands and their errors. The
+// MULTI and EXEC commands wrapping a transaction aren't counted.
+func (h *Hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	seg, ok := ctx.Value(subsegmentKey{}).(*xray.Segment)
+	if !ok {
+		return nil
+	}
+
+	commands := cmds
+	transaction := len(cmds) >= 2 && cmds[0].Name() == "multi" && cmds[len(cmds)-1].Name() == "exec"
+	if transaction {
+		commands = cmds[1 : len(cmds)-1]
+	}
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = cmd.Name()
+	}
+	seg.AddMetadataToNamespace(metadataNamespace, "command_count", len(commands))
+	seg.AddMetadataToNamespace(metadataNamespace, "commands", names)
+	if transaction {
+		seg.AddMetadataToNamespace(metadataNamespace, "transaction", true)
+	}
+	end(seg, cmds)
+	return nil
+}
+
+func (h *Hook) begin(ctx context.Context) context.Context {
+	c, seg := xray.BeginSubsegment(ctx, h.name)
+	if seg == nil {
+		return ctx
+	}
+
+	seg.Lock()
+	seg.Namespace = "remote"
+	seg.Unlock()
+
+	return context.WithValue(c, subsegmentKey{}, seg)
+}
+
+// end closes seg, marking it as an error if any of cmds failed with an error
+// reply, or as a fault if any failed otherwise, such as with a network
+// error. A missing key, redis.Nil, is no failure.
+func end(seg *xray.Segment, cmds []redis.Cmder) {
+	var replyErr, fault error
+	for _, cmd := range cmds {
+		err := cmd.Err()
+		if err == nil || errors.Is(err, redis.Nil) {
+			continue
+		}
+		var re redis.Error
+		if errors.As(err, &re) {
+			if replyErr == nil {
+				replyErr = err
+			}
+		} else if fault == nil {
+			fault = err
+		}
+	}
+
+	if replyErr != nil {
+		seg.Lock()
+		seg.Error = true
+		cause := seg.GetCause()
+		cause.Exceptions = append(cause.Exceptions, seg.ParentSegment.GetConfiguration().ExceptionFormattingStrategy.ExceptionFromError(replyErr))
+		seg.Unlock()
+	}
+	seg.Close(fault)
+}
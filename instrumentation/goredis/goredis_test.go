@@ -0,0 +1,231 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package goredis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/go-redis/redis/v8"
+)
+
+// countingContextMissingStrategy counts the subsegments begun without a
+// segment.
+type countingContextMissingStrategy struct {
+	count int
+}
+
+func (s *countingContextMissingStrategy) ContextMissing(v interface{}) {
+	s.count++
+}
+
+// newTestClient returns a client of a miniredis server, instrumented with a
+// Hook, and a context recording the emitted segments in me.
+func newTestClient(t *testing.T) (context.Context, *xray.MemoryEmitter, *miniredis.Miniredis, *redis.Client) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	rdb.AddHook(NewHook(mr.Addr()))
+
+	me := xray.NewMemoryEmitter()
+	ctx, err := xray.ContextWithConfig(context.Background(), xray.Config{
+		Emitter:          me,
+		SamplingStrategy: sampling.NewFuncStrategy(func(*sampling.Request) bool { return true }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx, me, mr, rdb
+}
+
+// emittedSubsegments closes root and returns its subsegments.
+func emittedSubsegments(t *testing.T, me *xray.MemoryEmitter, root *xray.Segment) []*xray.Segment {
+	root.Close(nil)
+	segs := me.Segments()
+	if len(segs) != 1 {
+		t.Fatalf("expected 1 segment, got %d", len(segs))
+	}
+	var subsegs []*xray.Segment
+	for _, raw := range segs[0].Subsegments {
+		if strings.Contains(string(raw), "s3cr3t") {
+			t.Errorf("expected arguments not to be recorded: %s", raw)
+		}
+		var subseg *xray.Segment
+		if err := json.Unmarshal(raw, &subseg); err != nil {
+			t.Fatal(err)
+		}
+		subsegs = append(subsegs, subseg)
+	}
+	return subsegs
+}
+
+func TestHookCommand(t *testing.T) {
+	ctx, me, mr, rdb := newTestClient(t)
+	ctx, root := xray.BeginSegment(ctx, "test")
+
+	if err := rdb.Set(ctx, "session", "s3cr3t", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rdb.Get(ctx, "missing").Err(); !errors.Is(err, redis.Nil) {
+		t.Fatalf("expected redis.Nil, got %v", err)
+	}
+
+	subsegs := emittedSubsegments(t, me, root)
+	if len(subsegs) != 2 {
+		t.Fatalf("expected 2 subsegments, got %d", len(subsegs))
+	}
+	for i, command := range []string{"set", "get"} {
+		subseg := subsegs[i]
+		if subseg.Name != mr.Addr() {
+			t.Errorf("expected name %s, got %s", mr.Addr(), subseg.Name)
+		}
+		if subseg.Namespace != "remote" {
+			t.Errorf("expected namespace remote, got %s", subseg.Namespace)
+		}
+		if got := subseg.Metadata[metadataNamespace]["command"]; got != command {
+			t.Errorf("expected command %s, got %v", command, got)
+		}
+		if subseg.Error || subseg.Fault {
+			t.Errorf("expected %s not to fail", command)
+		}
+	}
+}
+
+func TestHookCommandError(t *testing.T) {
+	ctx, me, _, rdb := newTestClient(t)
+	ctx, root := xray.BeginSegment(ctx, "test")
+
+	rdb.Set(ctx, "name", "value", 0)
+	if err := rdb.Incr(ctx, "name").Err(); err == nil {
+		t.Fatal("expected incr of a string to fail")
+	}
+
+	subsegs := emittedSubsegments(t, me, root)
+	if len(subsegs) != 2 {
+		t.Fatalf("expected 2 subsegments, got %d", len(subsegs))
+	}
+	incr := subsegs[1]
+	if !incr.Error || incr.Fault {
+		t.Errorf("expected error and no fault, got error %t and fault %t", incr.Error, incr.Fault)
+	}
+	if incr.Cause == nil || len(incr.Cause.Exceptions) != 1 {
+		t.Fatalf("expected the error reply in the cause, got %+v", incr.Cause)
+	}
+}
+
+func TestHookNetworkError(t *testing.T) {
+	ctx, me, mr, rdb := newTestClient(t)
+	ctx, root := xray.BeginSegment(ctx, "test")
+
+	mr.Close()
+	if err := rdb.Ping(ctx).Err(); err == nil {
+		t.Fatal("expected ping to fail")
+	}
+
+	subsegs := emittedSubsegments(t, me, root)
+	if len(subsegs) == 0 {
+		t.Fatal("expected a subsegment")
+	}
+	if !subsegs[0].Fault {
+		t.Error("expected a fault")
+	}
+}
+
+func TestHookPipeline(t *testing.T) {
+	ctx, me, mr, rdb := newTestClient(t)
+	ctx, root := xray.BeginSegment(ctx, "test")
+
+	pipe := rdb.Pipeline()
+	pipe.Set(ctx, "name", "s3cr3t", 0)
+	pipe.Incr(ctx, "name")
+	pipe.Get(ctx, "name")
+	if _, err := pipe.Exec(ctx); err == nil {
+		t.Fatal("expected the pipeline to fail")
+	}
+
+	subsegs := emittedSubsegments(t, me, root)
+	if len(subsegs) != 1 {
+		t.Fatalf("expected 1 subsegment, got %d", len(subsegs))
+	}
+	subseg := subsegs[0]
+	if subseg.Name != mr.Addr() || subseg.Namespace != "remote" {
+		t.Errorf("expected remote subsegment %s, got %s %s", mr.Addr(), subseg.Namespace, subseg.Name)
+	}
+	md := subseg.Metadata[metadataNamespace]
+	if md["command_count"] != float64(3) {
+		t.Errorf("expected 3 commands, got %v", md["command_count"])
+	}
+	if names, _ := json.Marshal(md["commands"]); string(names) != `["set","incr","get"]` {
+		t.Errorf("expected command names, got %s", names)
+	}
+	if _, ok := md["transaction"]; ok {
+		t.Error("expected no transaction")
+	}
+	if !subseg.Error || subseg.Fault {
+		t.Errorf("expected error and no fault, got error %t and fault %t", subseg.Error, subseg.Fault)
+	}
+}
+
+func TestHookTransaction(t *testing.T) {
+	ctx, me, _, rdb := newTestClient(t)
+	ctx, root := xray.BeginSegment(ctx, "test")
+
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, "name", "value", 0)
+		pipe.Incr(ctx, "name")
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected the transaction to fail")
+	}
+
+	subsegs := emittedSubsegments(t, me, root)
+	if len(subsegs) != 1 {
+		t.Fatalf("expected 1 subsegment, got %d", len(subsegs))
+	}
+	md := subsegs[0].Metadata[metadataNamespace]
+	if md["command_count"] != float64(2) || md["transaction"] != true {
+		t.Errorf("expected a transaction of 2 commands, got %v", md)
+	}
+	if !subsegs[0].Error {
+		t.Error("expected an error")
+	}
+}
+
+func TestHookContextMissing(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	rdb.AddHook(NewHook("cache"))
+
+	strategy := &countingContextMissingStrategy{}
+	ctx, err := xray.ContextWithConfig(context.Background(), xray.Config{ContextMissingStrategy: strategy})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rdb.Set(ctx, "name", "value", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Get(ctx, "name")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if strategy.count != 2 {
+		t.Errorf("expected 2 missing contexts, got %d", strategy.count)
+	}
+}
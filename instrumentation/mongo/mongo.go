@@ -0,0 +1,151 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+// Package mongo records subsegments for the commands of MongoDB clients of
+// the official driver.
+package mongo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// metadataNamespace is the metadata namespace of the commands recorded on
+// subsegments.
+const metadataNamespace = "mongodb"
+
+// defaultMaxInFlight is the number of commands whose subsegments may be open
+// at the same time. Commands started beyond it aren't recorded.
+const defaultMaxInFlight = 10000
+
+// commandKey identifies a command across its started and finished events.
+type commandKey struct {
+	connectionID string
+	requestID    int64
+}
+
+// monitor pairs the started and finished events of commands, which share no
+// context, by their request IDs.
+type monitor struct {
+	maxInFlight int
+
+	mu       sync.Mutex
+	inFlight map[commandKey]*xray.Segment
+}
+
+// NewMonitor returns a command monitor recording a remote subsegment for each
+// command of a MongoDB client, named after the database and collection of the
+// command. Only the name of the command and the address of the server are
+// recorded, the command documents may hold user data.
+//
+//	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMonitor(xraymongo.NewMonitor()))
+func NewMonitor() *event.CommandMonitor {
+	return newMonitor(defaultMaxInFlight).commandMonitor()
+}
+
+func newMonitor(maxInFlight int) *monitor {
+	return &monitor{
+		maxInFlight: maxInFlight,
+		inFlight:    make(map[commandKey]*xray.Segment),
+	}
+}
+
+func (m *monitor) commandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started:   m.started,
+		Succeeded: m.succeeded,
+		Failed:    m.failed,
+	}
+}
+
+// started begins a subsegment for the command of e. When ctx holds no
+// segment, the ContextMissingStrategy handles it.
+func (m *monitor) started(ctx context.Context, e *event.CommandStartedEvent) {
+	key := commandKey{connectionID: e.ConnectionID, requestID: e.RequestID}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.inFlight) >= m.maxInFlight {
+		logger.Debugf("Not recording mongodb command %s: %d commands are in flight", e.CommandName, len(m.inFlight))
+		return
+	}
+
+	_, seg := xray.BeginSubsegment(ctx, commandName(e))
+	if seg == nil {
+		return
+	}
+
+	seg.Lock()
+	seg.Namespace = "remote"
+	seg.Unlock()
+	seg.AddMetadataToNamespace(metadataNamespace, "command", e.CommandName)
+	seg.AddMetadataToNamespace(metadataNamespace, "server_address", serverAddress(e.ConnectionID))
+
+	m.inFlight[key] = seg
+}
+
+// succeeded closes the subsegment of the command of e.
+func (m *monitor) succeeded(ctx context.Context, e *event.CommandSucceededEvent) {
+	if seg := m.finish(e.CommandFinishedEvent); seg != nil {
+		seg.Close(nil)
+	}
+}
+
+// failed closes the subsegment of the command of e with its failure.
+func (m *monitor) failed(ctx context.Context, e *event.CommandFailedEvent) {
+	if seg := m.finish(e.CommandFinishedEvent); seg != nil {
+		seg.Close(errors.New(e.Failure))
+	}
+}
+
+// finish returns the subsegment of the command of e, no longer in flight, or
+// nil if it wasn't recorded.
+func (m *monitor) finish(e event.CommandFinishedEvent) *xray.Segment {
+	key := commandKey{connectionID: e.ConnectionID, requestID: e.RequestID}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seg := m.inFlight[key]
+	delete(m.inFlight, key)
+	return seg
+}
+
+// commandName returns the database.collection the command of e applies to,
+// or the database only for database commands. The collection is the value of
+// the first element of the command document, named after the command, or of
+// its collection element for getMore.
+func commandName(e *event.CommandStartedEvent) string {
+	if elem, err := e.Command.IndexErr(0); err == nil {
+		if collection, ok := elem.Value().StringValueOK(); ok && collection != "" {
+			return e.DatabaseName + "." + collection
+		}
+	}
+	if v, err := e.Command.LookupErr("collection"); err == nil {
+		if collection, ok := v.StringValueOK(); ok && collection != "" {
+			return e.DatabaseName + "." + collection
+		}
+	}
+	return e.DatabaseName
+}
+
+// serverAddress returns the host:port of a connection ID, such as
+// "localhost:27017[-4]".
+func serverAddress(connectionID string) string {
+	if i := strings.LastIndex(connectionID, "["); i >= 0 {
+		return connectionID[:i]
+	}
+	return connectionID
+}
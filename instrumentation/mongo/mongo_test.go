@@ -0,0 +1,192 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const testConnectionID = "localhost:27017[-4]"
+
+// countingContextMissingStrategy counts the subsegments begun without a
+// segment.
+type countingContextMissingStrategy struct {
+	count int
+}
+
+func (s *countingContextMissingStrategy) ContextMissing(v interface{}) {
+	s.count++
+}
+
+// newTestContext returns a context recording the emitted segments in me.
+func newTestContext(t *testing.T) (context.Context, *xray.MemoryEmitter) {
+	me := xray.NewMemoryEmitter()
+	ctx, err := xray.ContextWithConfig(context.Background(), xray.Config{
+		Emitter:          me,
+		SamplingStrategy: sampling.NewFuncStrategy(func(*sampling.Request) bool { return true }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx, me
+}
+
+// emittedSubsegments closes root and returns its subsegments.
+func emittedSubsegments(t *testing.T, me *xray.MemoryEmitter, root *xray.Segment) []*xray.Segment {
+	root.Close(nil)
+	segs := me.Segments()
+	if len(segs) != 1 {
+		t.Fatalf("expected 1 segment, got %d", len(segs))
+	}
+	var subsegs []*xray.Segment
+	for _, raw := range segs[0].Subsegments {
+		if strings.Contains(string(raw), "s3cr3t") {
+			t.Errorf("expected the command document not to be recorded: %s", raw)
+		}
+		var subseg *xray.Segment
+		if err := json.Unmarshal(raw, &subseg); err != nil {
+			t.Fatal(err)
+		}
+		subsegs = append(subsegs, subseg)
+	}
+	return subsegs
+}
+
+func startedEvent(t *testing.T, requestID int64, name string, command bson.D) *event.CommandStartedEvent {
+	raw, err := bson.Marshal(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &event.CommandStartedEvent{
+		Command:      raw,
+		DatabaseName: "shop",
+		CommandName:  name,
+		RequestID:    requestID,
+		ConnectionID: testConnectionID,
+	}
+}
+
+func finishedEvent(requestID int64, name string) event.CommandFinishedEvent {
+	return event.CommandFinishedEvent{
+		CommandName:  name,
+		RequestID:    requestID,
+		ConnectionID: testConnectionID,
+	}
+}
+
+func TestMonitorCommands(t *testing.T) {
+	ctx, me := newTestContext(t)
+	ctx, root := xray.BeginSegment(ctx, "test")
+	cm := NewMonitor()
+
+	cm.Started(ctx, startedEvent(t, 1, "find", bson.D{{Key: "find", Value: "orders"}, {Key: "filter", Value: bson.D{{Key: "email", Value: "s3cr3t"}}}}))
+	cm.Started(ctx, startedEvent(t, 2, "aggregate", bson.D{{Key: "aggregate", Value: 1}, {Key: "pipeline", Value: bson.A{}}}))
+	cm.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finishedEvent(2, "aggregate")})
+	cm.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finishedEvent(1, "find")})
+	cm.Started(ctx, startedEvent(t, 3, "getMore", bson.D{{Key: "getMore", Value: int64(42)}, {Key: "collection", Value: "orders"}}))
+	cm.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finishedEvent(3, "getMore")})
+
+	subsegs := emittedSubsegments(t, me, root)
+	if len(subsegs) != 3 {
+		t.Fatalf("expected 3 subsegments, got %d", len(subsegs))
+	}
+	want := map[string]string{"find": "shop.orders", "aggregate": "shop", "getMore": "shop.orders"}
+	for _, subseg := range subsegs {
+		md := subseg.Metadata[metadataNamespace]
+		command, _ := md["command"].(string)
+		if subseg.Name != want[command] {
+			t.Errorf("expected %s to be named %s, got %s", command, want[command], subseg.Name)
+		}
+		if subseg.Namespace != "remote" {
+			t.Errorf("expected namespace remote, got %s", subseg.Namespace)
+		}
+		if md["server_address"] != "localhost:27017" {
+			t.Errorf("expected server address localhost:27017, got %v", md["server_address"])
+		}
+		if subseg.Fault || subseg.Error || subseg.InProgress {
+			t.Errorf("expected %s to succeed", command)
+		}
+	}
+}
+
+func TestMonitorFailedCommand(t *testing.T) {
+	ctx, me := newTestContext(t)
+	ctx, root := xray.BeginSegment(ctx, "test")
+	m := newMonitor(defaultMaxInFlight)
+	cm := m.commandMonitor()
+
+	cm.Started(ctx, startedEvent(t, 1, "insert", bson.D{{Key: "insert", Value: "orders"}}))
+	cm.Failed(ctx, &event.CommandFailedEvent{CommandFinishedEvent: finishedEvent(1, "insert"), Failure: "connection reset"})
+
+	if len(m.inFlight) != 0 {
+		t.Errorf("expected no command in flight, got %d", len(m.inFlight))
+	}
+	subsegs := emittedSubsegments(t, me, root)
+	if len(subsegs) != 1 {
+		t.Fatalf("expected 1 subsegment, got %d", len(subsegs))
+	}
+	if !subsegs[0].Fault {
+		t.Error("expected a fault")
+	}
+	if subsegs[0].Cause == nil || len(subsegs[0].Cause.Exceptions) != 1 || subsegs[0].Cause.Exceptions[0].Message != "connection reset" {
+		t.Errorf("expected the failure in the cause, got %+v", subsegs[0].Cause)
+	}
+}
+
+func TestMonitorMaxInFlight(t *testing.T) {
+	ctx, me := newTestContext(t)
+	ctx, root := xray.BeginSegment(ctx, "test")
+	m := newMonitor(2)
+	cm := m.commandMonitor()
+
+	for i := int64(1); i <= 3; i++ {
+		cm.Started(ctx, startedEvent(t, i, "find", bson.D{{Key: "find", Value: "orders"}}))
+	}
+	if len(m.inFlight) != 2 {
+		t.Fatalf("expected 2 commands in flight, got %d", len(m.inFlight))
+	}
+	for i := int64(1); i <= 3; i++ {
+		cm.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finishedEvent(i, "find")})
+	}
+	if len(m.inFlight) != 0 {
+		t.Errorf("expected no command in flight, got %d", len(m.inFlight))
+	}
+
+	if subsegs := emittedSubsegments(t, me, root); len(subsegs) != 2 {
+		t.Errorf("expected 2 subsegments, got %d", len(subsegs))
+	}
+}
+
+func TestMonitorContextMissing(t *testing.T) {
+	strategy := &countingContextMissingStrategy{}
+	ctx, err := xray.ContextWithConfig(context.Background(), xray.Config{ContextMissingStrategy: strategy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMonitor(defaultMaxInFlight)
+	cm := m.commandMonitor()
+
+	cm.Started(ctx, startedEvent(t, 1, "find", bson.D{{Key: "find", Value: "orders"}}))
+	cm.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finishedEvent(1, "find")})
+
+	if strategy.count != 1 {
+		t.Errorf("expected 1 missing context, got %d", strategy.count)
+	}
+	if len(m.inFlight) != 0 {
+		t.Errorf("expected no command in flight, got %d", len(m.inFlight))
+	}
+}
@@ -76,3 +76,57 @@ type stringerFunc func() string
 func (sf stringerFunc) String() string {
 	return sf()
 }
+
+// Scoped logs to the logger of a recorder, or to Logger if the recorder has
+// none.
+type Scoped struct {
+	l xraylog.Logger
+}
+
+// With returns a Scoped logging to l, or to Logger if l is nil.
+func With(l xraylog.Logger) Scoped {
+	return Scoped{l: l}
+}
+
+func (s Scoped) logger() xraylog.Logger {
+	if s.l != nil {
+		return s.l
+	}
+	return Logger
+}
+
+func (s Scoped) Debugf(format string, args ...interface{}) {
+	s.logger().Log(xraylog.LogLevelDebug, printfArgs{format, args})
+}
+
+func (s Scoped) Debug(args ...interface{}) {
+	s.logger().Log(xraylog.LogLevelDebug, printArgs(args))
+}
+
+func (s Scoped) DebugDeferred(fn func() string) {
+	s.logger().Log(xraylog.LogLevelDebug, stringerFunc(fn))
+}
+
+func (s Scoped) Infof(format string, args ...interface{}) {
+	s.logger().Log(xraylog.LogLevelInfo, printfArgs{format, args})
+}
+
+func (s Scoped) Info(args ...interface{}) {
+	s.logger().Log(xraylog.LogLevelInfo, printArgs(args))
+}
+
+func (s Scoped) Warnf(format string, args ...interface{}) {
+	s.logger().Log(xraylog.LogLevelWarn, printfArgs{format, args})
+}
+
+func (s Scoped) Warn(args ...interface{}) {
+	s.logger().Log(xraylog.LogLevelWarn, printArgs(args))
+}
+
+func (s Scoped) Errorf(format string, args ...interface{}) {
+	s.logger().Log(xraylog.LogLevelError, printfArgs{format, args})
+}
+
+func (s Scoped) Error(args ...interface{}) {
+	s.logger().Log(xraylog.LogLevelError, printArgs(args))
+}
@@ -81,3 +81,21 @@ func TestDeferredDebug(t *testing.T) {
 		t.Errorf("expected deferred message, got %s", buf.String())
 	}
 }
+
+func TestScoped(t *testing.T) {
+	oldLogger := Logger
+	defer func() { Logger = oldLogger }()
+
+	var global, scoped bytes.Buffer
+	Logger = xraylog.NewDefaultLogger(&global, xraylog.LogLevelDebug)
+
+	With(xraylog.NewDefaultLogger(&scoped, xraylog.LogLevelDebug)).Infof("scoped %d", 1)
+	With(nil).Infof("global %d", 2)
+
+	if !strings.Contains(scoped.String(), "[INFO] scoped 1") || strings.Contains(scoped.String(), "global") {
+		t.Errorf("unexpected scoped log contents: %s", scoped.String())
+	}
+	if !strings.Contains(global.String(), "[INFO] global 2") || strings.Contains(global.String(), "scoped") {
+		t.Errorf("unexpected global log contents: %s", global.String())
+	}
+}
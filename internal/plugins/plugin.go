@@ -8,6 +8,14 @@
 
 package plugins
 
+import (
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
 const (
 	// EBServiceName is the key name for metadata of ElasticBeanstalkPlugin.
 	EBServiceName = "elastic_beanstalk"
@@ -17,10 +25,86 @@ const (
 
 	// ECSServiceName is the key name for metadata of ECSPlugin.
 	ECSServiceName = "ecs"
+
+	// CloudWatchLogsServiceName is the key name for the log groups
+	// the application writes to.
+	CloudWatchLogsServiceName = "cloudwatch_logs"
+)
+
+// NoPluginMetadataEnvVar is the environment variable disabling the
+// detectors registered by the plugins when set to true.
+const NoPluginMetadataEnvVar = "AWS_XRAY_NO_PLUGIN_METADATA"
+
+// DefaultMetadataTimeout is the default timeout of each request made by the
+// detectors, such as those to the EC2 instance metadata service.
+const DefaultMetadataTimeout = time.Second
+
+var (
+	// instancePluginMetadata holds the metadata detected so far.
+	instancePluginMetadata atomic.Pointer[PluginMetadata]
+
+	detectMu  sync.Mutex
+	detectors []func(*PluginMetadata)
+	pending   int32
+
+	metadataTimeout = int64(DefaultMetadataTimeout)
 )
 
-// InstancePluginMetadata points to the PluginMetadata struct.
-var InstancePluginMetadata = &PluginMetadata{}
+func init() {
+	instancePluginMetadata.Store(&PluginMetadata{})
+}
+
+// Register adds a detector of the metadata of the platform hosting the
+// application. Detectors make network calls, so they are run by the next
+// call to Detect rather than right away.
+func Register(detect func(*PluginMetadata)) {
+	detectMu.Lock()
+	defer detectMu.Unlock()
+	detectors = append(detectors, detect)
+	atomic.StoreInt32(&pending, 1)
+}
+
+// Detect runs the detectors registered since it last ran, unless
+// AWS_XRAY_NO_PLUGIN_METADATA is set to true, and returns the metadata
+// detected so far. Concurrent callers wait for the detectors to finish.
+// The returned metadata must not be modified.
+func Detect() *PluginMetadata {
+	if atomic.LoadInt32(&pending) == 0 || detectionDisabled() {
+		return instancePluginMetadata.Load()
+	}
+
+	detectMu.Lock()
+	defer detectMu.Unlock()
+	if len(detectors) > 0 {
+		md := *instancePluginMetadata.Load()
+		for _, detect := range detectors {
+			detect(&md)
+		}
+		detectors = nil
+		instancePluginMetadata.Store(&md)
+	}
+	atomic.StoreInt32(&pending, 0)
+	return instancePluginMetadata.Load()
+}
+
+// detectionDisabled returns whether AWS_XRAY_NO_PLUGIN_METADATA is set to true.
+func detectionDisabled() bool {
+	return strings.ToLower(os.Getenv(NoPluginMetadataEnvVar)) == "true"
+}
+
+// SetMetadataTimeout sets the timeout of each request made by the detectors.
+// Non-positive values restore DefaultMetadataTimeout.
+func SetMetadataTimeout(d time.Duration) {
+	if d <= 0 {
+		d = DefaultMetadataTimeout
+	}
+	atomic.StoreInt64(&metadataTimeout, int64(d))
+}
+
+// MetadataTimeout returns the timeout of each request made by the detectors.
+func MetadataTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&metadataTimeout))
+}
 
 // PluginMetadata struct contains items to record information
 // about the AWS infrastructure hosting the traced application.
@@ -36,6 +120,10 @@ type PluginMetadata struct {
 	// ECSMetadata records the ECS container ID.
 	ECSMetadata *ECSMetadata
 
+	// LogGroups records the CloudWatch Logs log groups the application
+	// writes to, letting the X-Ray console link traces to their logs.
+	LogGroups []LogGroupMetadata
+
 	// Origin records original service of the segment.
 	Origin string
 }
@@ -49,7 +137,17 @@ type EC2Metadata struct {
 // ECSMetadata provides the shape for unmarshalling
 // ECS metadata.
 type ECSMetadata struct {
-	ContainerName string `json:"container"`
+	ContainerName    string `json:"container"`
+	ContainerID      string `json:"container_id,omitempty"`
+	ContainerArn     string `json:"container_arn,omitempty"`
+	AvailabilityZone string `json:"availability_zone,omitempty"`
+}
+
+// LogGroupMetadata provides the shape for marshalling
+// a CloudWatch Logs log group.
+type LogGroupMetadata struct {
+	LogGroup string `json:"log_group"`
+	Arn      string `json:"arn,omitempty"`
 }
 
 // BeanstalkMetadata provides the shape for unmarshalling
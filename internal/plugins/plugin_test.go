@@ -0,0 +1,77 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package plugins
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func resetDetection() {
+	detectMu.Lock()
+	defer detectMu.Unlock()
+	detectors = nil
+	pending = 0
+	instancePluginMetadata.Store(&PluginMetadata{})
+}
+
+func TestDetectRunsRegisteredDetectorsOnce(t *testing.T) {
+	defer resetDetection()
+
+	var runs int
+	Register(func(md *PluginMetadata) {
+		runs++
+		md.EC2Metadata = &EC2Metadata{InstanceID: "i-032fe2d42797fb9a1"}
+		md.Origin = "AWS::EC2::Instance"
+	})
+	assert.Zero(t, runs, "detectors run lazily")
+
+	md := Detect()
+	assert.Equal(t, "i-032fe2d42797fb9a1", md.EC2Metadata.InstanceID)
+	assert.Equal(t, "AWS::EC2::Instance", md.Origin)
+
+	assert.Same(t, md, Detect())
+	assert.Equal(t, 1, runs)
+
+	// detectors registered later run on the next call
+	Register(func(md *PluginMetadata) {
+		runs++
+		md.LogGroups = []LogGroupMetadata{{LogGroup: "/app"}}
+	})
+	md = Detect()
+	assert.Equal(t, 2, runs)
+	assert.NotNil(t, md.EC2Metadata)
+	assert.Len(t, md.LogGroups, 1)
+}
+
+func TestDetectDisabled(t *testing.T) {
+	defer resetDetection()
+	os.Setenv(NoPluginMetadataEnvVar, "TRUE")
+	defer os.Unsetenv(NoPluginMetadataEnvVar)
+
+	var ran bool
+	Register(func(*PluginMetadata) { ran = true })
+
+	assert.Equal(t, &PluginMetadata{}, Detect())
+	assert.False(t, ran)
+}
+
+func TestMetadataTimeout(t *testing.T) {
+	defer SetMetadataTimeout(0)
+	assert.Equal(t, DefaultMetadataTimeout, MetadataTimeout())
+
+	SetMetadataTimeout(200 * time.Millisecond)
+	assert.Equal(t, 200*time.Millisecond, MetadataTimeout())
+
+	SetMetadataTimeout(-1)
+	assert.Equal(t, DefaultMetadataTimeout, MetadataTimeout())
+}
@@ -13,6 +13,7 @@ import (
 	"crypto/rand"
 	goerrors "errors"
 	"fmt"
+	"reflect"
 	"runtime"
 	"strings"
 
@@ -32,6 +33,7 @@ type Exception struct {
 	Message string  `json:"message,omitempty"`
 	Stack   []Stack `json:"stack,omitempty"`
 	Remote  bool    `json:"remote,omitempty"`
+	Cause   string  `json:"cause,omitempty"`
 }
 
 // Stack provides the shape for unmarshalling an stack.
@@ -56,8 +58,28 @@ func (e MultiError) Error() string {
 	return buf.String()
 }
 
+// Is reports whether any of the errors matches target, so that errors.Is
+// looks into a MultiError.
+func (e MultiError) Is(target error) bool {
+	for _, err := range e {
+		if goerrors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unwrap returns the errors, so that ExceptionsFromError records each of them.
+func (e MultiError) Unwrap() []error {
+	return e
+}
+
 var defaultErrorFrameCount = 32
 
+// maxExceptionChain is the maximum number of exceptions recorded by
+// ExceptionsFromError for an error and the errors it wraps.
+const maxExceptionChain = 10
+
 // DefaultFormattingStrategy is the default implementation of
 // the ExceptionFormattingStrategy and has a configurable frame count.
 type DefaultFormattingStrategy struct {
@@ -115,6 +137,77 @@ func (dEFS *DefaultFormattingStrategy) Panicf(formatString string, args ...inter
 
 // ExceptionFromError takes an error and returns value of Exception
 func (dEFS *DefaultFormattingStrategy) ExceptionFromError(err error) Exception {
+	e := exceptionFromLink(err)
+	xRayErr := &XRayError{}
+	if goerrors.As(err, &xRayErr) {
+		e.Type = xRayErr.Type
+	}
+	e.Stack = dEFS.stack(err, 6)
+	return e
+}
+
+// ExceptionsFromError takes an error and returns an Exception for it and for
+// each error it wraps, outermost first, each one's Cause being the ID of the
+// exception of the error it wraps. The errors wrapped by an error with an
+// Unwrap() []error method, as returned by errors.Join, are recorded as sibling
+// exceptions, the first of which is the cause of the exception wrapping them;
+// the joining error itself isn't recorded. Only the first exception holds a
+// stack trace, which is the one ExceptionFromError records for err, so that
+// the chain is no larger than the single exception but for the messages. At
+// most 10 exceptions are recorded, and an error wrapped more than once is
+// recorded the first time only.
+func (dEFS *DefaultFormattingStrategy) ExceptionsFromError(err error) []Exception {
+	c := exceptionChain{seen: map[error]bool{}}
+	c.add(err)
+	if len(c.exceptions) > 0 {
+		c.exceptions[0].Stack = dEFS.stack(err, 6)
+	}
+	return c.exceptions
+}
+
+// exceptionChain collects the exceptions of ExceptionsFromError.
+type exceptionChain struct {
+	exceptions []Exception
+	seen       map[error]bool
+}
+
+// add records the exceptions of err and the errors it wraps, and returns the
+// ID of the first one, or "" if none was recorded.
+func (c *exceptionChain) add(err error) string {
+	if err == nil || len(c.exceptions) == maxExceptionChain {
+		return ""
+	}
+	// errors of an uncomparable type can't be map keys, they may be
+	// recorded more than once
+	if reflect.TypeOf(err).Comparable() {
+		if c.seen[err] {
+			return ""
+		}
+		c.seen[err] = true
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var first string
+		for _, e := range joined.Unwrap() {
+			if id := c.add(e); first == "" {
+				first = id
+			}
+		}
+		return first
+	}
+
+	e := exceptionFromLink(err)
+	if xRayErr, ok := err.(*XRayError); ok {
+		e.Type = xRayErr.Type
+	}
+	i := len(c.exceptions)
+	c.exceptions = append(c.exceptions, e)
+	c.exceptions[i].Cause = c.add(goerrors.Unwrap(err))
+	return e.ID
+}
+
+// exceptionFromLink returns the Exception of err, without its stack trace.
+func exceptionFromLink(err error) Exception {
 	var isRemote bool
 	var reqErr awserr.RequestFailure
 	if goerrors.As(err, &reqErr) {
@@ -128,18 +221,17 @@ func (dEFS *DefaultFormattingStrategy) ExceptionFromError(err error) Exception {
 	t := fmt.Sprintf("%T", err)
 	// normalize the type
 	t = strings.Replace(t, "*", "", -1)
-	e := Exception{
+	return Exception{
 		ID:      newExceptionID(),
 		Type:    t,
 		Message: err.Error(),
 		Remote:  isRemote,
 	}
+}
 
-	xRayErr := &XRayError{}
-	if goerrors.As(err, &xRayErr) {
-		e.Type = xRayErr.Type
-	}
-
+// stack returns the stack trace of err, or that of the caller skip frames up
+// the stack of stack if err holds none.
+func (dEFS *DefaultFormattingStrategy) stack(err error, skip int) []Stack {
 	var s []uintptr
 
 	// This is our publicly supported interface for passing along stack traces
@@ -160,12 +252,11 @@ func (dEFS *DefaultFormattingStrategy) ExceptionFromError(err error) Exception {
 
 	if s == nil {
 		s = make([]uintptr, dEFS.FrameCount)
-		n := runtime.Callers(5, s)
+		n := runtime.Callers(skip, s)
 		s = s[:n]
 	}
 
-	e.Stack = convertStack(s)
-	return e
+	return convertStack(s)
 }
 
 func newExceptionID() string {
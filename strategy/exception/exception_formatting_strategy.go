@@ -16,3 +16,13 @@ type FormattingStrategy interface {
 	Panicf(formatString string, args ...interface{}) *XRayError
 	ExceptionFromError(err error) Exception
 }
+
+// ChainFormattingStrategy is implemented by a FormattingStrategy recording an
+// error and the errors it wraps as a chain of exceptions, see
+// DefaultFormattingStrategy.ExceptionsFromError. Segments record the errors
+// they're closed with using ExceptionsFromError when their strategy
+// implements it, and ExceptionFromError otherwise.
+type ChainFormattingStrategy interface {
+	FormattingStrategy
+	ExceptionsFromError(err error) []Exception
+}
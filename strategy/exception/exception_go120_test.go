@@ -0,0 +1,35 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+//go:build go1.20
+// +build go1.20
+
+package exception
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExceptionsFromErrorJoin(t *testing.T) {
+	defaultStrategy := &DefaultFormattingStrategy{}
+	err := errors.Join(errors.New("error one"), errors.New("error two"))
+
+	exceptions := defaultStrategy.ExceptionsFromError(err)
+
+	if !assert.Len(t, exceptions, 2) {
+		return
+	}
+	assert.Equal(t, "error one", exceptions[0].Message)
+	assert.Empty(t, exceptions[0].Cause)
+	assert.NotEmpty(t, exceptions[0].Stack)
+	assert.Equal(t, "error two", exceptions[1].Message)
+	assert.Empty(t, exceptions[1].Cause)
+}
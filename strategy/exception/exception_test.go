@@ -10,6 +10,7 @@ package exception
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -23,6 +24,13 @@ func TestMultiErrorReturnStringFormat(t *testing.T) {
 	assert.Equal(t, "2 errors occurred:\n* error one\n* error two\n", err.Error())
 }
 
+func TestMultiErrorIs(t *testing.T) {
+	target := errors.New("target")
+	err := MultiError{errors.New("error one"), fmt.Errorf("wrapped: %w", target)}
+	assert.True(t, errors.Is(err, target))
+	assert.False(t, errors.Is(MultiError{errors.New("error one")}, target))
+}
+
 func TestDefaultFormattingStrategyWithInvalidFrameCount(t *testing.T) {
 	dss, e := NewDefaultFormattingStrategyWithDefinedErrorFrameCount(-1)
 	ds, err := NewDefaultFormattingStrategyWithDefinedErrorFrameCount(33)
@@ -130,6 +138,75 @@ func TestExceptionFromErrorXRayError(t *testing.T) {
 	assert.Equal(t, "error", err.Type)
 }
 
+func TestExceptionsFromErrorChain(t *testing.T) {
+	defaultStrategy, _ := NewDefaultFormattingStrategy()
+	inner := defaultStrategy.Error("inner")
+	middle := fmt.Errorf("middle: %w", inner)
+	outer := fmt.Errorf("outer: %w", middle)
+
+	exceptions := defaultStrategy.ExceptionsFromError(outer)
+
+	if !assert.Len(t, exceptions, 3) {
+		return
+	}
+	assert.Equal(t, "outer: middle: inner", exceptions[0].Message)
+	assert.Equal(t, "fmt.wrapError", exceptions[0].Type)
+	assert.Equal(t, exceptions[1].ID, exceptions[0].Cause)
+	assert.Equal(t, "middle: inner", exceptions[1].Message)
+	assert.Equal(t, exceptions[2].ID, exceptions[1].Cause)
+	assert.Equal(t, "inner", exceptions[2].Message)
+	assert.Equal(t, "error", exceptions[2].Type)
+	assert.Empty(t, exceptions[2].Cause)
+
+	// the stack of the XRayError is recorded once, on the outermost exception
+	assert.Equal(t, "TestExceptionsFromErrorChain", exceptions[0].Stack[0].Label)
+	assert.Empty(t, exceptions[1].Stack)
+	assert.Empty(t, exceptions[2].Stack)
+}
+
+func TestExceptionsFromErrorSiblings(t *testing.T) {
+	defaultStrategy := &DefaultFormattingStrategy{}
+	err := fmt.Errorf("outer: %w", MultiError{errors.New("error one"), errors.New("error two")})
+
+	exceptions := defaultStrategy.ExceptionsFromError(err)
+
+	if !assert.Len(t, exceptions, 3) {
+		return
+	}
+	assert.Equal(t, exceptions[1].ID, exceptions[0].Cause)
+	assert.Equal(t, "error one", exceptions[1].Message)
+	assert.Empty(t, exceptions[1].Cause)
+	assert.Equal(t, "error two", exceptions[2].Message)
+	assert.Empty(t, exceptions[2].Cause)
+}
+
+func TestExceptionsFromErrorRepeated(t *testing.T) {
+	defaultStrategy := &DefaultFormattingStrategy{}
+	err := errors.New("error")
+
+	exceptions := defaultStrategy.ExceptionsFromError(MultiError{err, fmt.Errorf("wrapped: %w", err)})
+
+	if assert.Len(t, exceptions, 2) {
+		assert.Equal(t, "error", exceptions[0].Message)
+		assert.Equal(t, "wrapped: error", exceptions[1].Message)
+		assert.Empty(t, exceptions[1].Cause)
+	}
+}
+
+func TestExceptionsFromErrorMaxChain(t *testing.T) {
+	defaultStrategy := &DefaultFormattingStrategy{}
+	err := errors.New("error")
+	for i := 0; i < 2*maxExceptionChain; i++ {
+		err = fmt.Errorf("wrapped %d: %w", i, err)
+	}
+
+	exceptions := defaultStrategy.ExceptionsFromError(err)
+
+	if assert.Len(t, exceptions, maxExceptionChain) {
+		assert.Empty(t, exceptions[maxExceptionChain-1].Cause)
+	}
+}
+
 // Benchmarks
 func BenchmarkDefaultFormattingStrategy_Error(b *testing.B) {
 	defs, _ := NewDefaultFormattingStrategy()
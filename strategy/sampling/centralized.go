@@ -9,16 +9,19 @@
 package sampling
 
 import (
+	"context"
 	crypto "crypto/rand"
 	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-xray-sdk-go/daemoncfg"
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 	"github.com/aws/aws-xray-sdk-go/internal/plugins"
+	"github.com/aws/aws-xray-sdk-go/xraylog"
 
 	"github.com/aws/aws-xray-sdk-go/utils"
 
@@ -50,9 +53,35 @@ type CentralizedStrategy struct {
 	// pollerStart, if true represents rule and target pollers are started
 	pollerStart bool
 
+	// proxyRetrying, if true represents the proxy couldn't be created when
+	// starting the pollers and is being retried
+	proxyRetrying bool
+
+	// started, if true represents start was called, so that ShouldTrace
+	// doesn't need to take mu anymore
+	started atomic.Bool
+
 	// represents daemon endpoints
 	daemonEndpoints *daemoncfg.DaemonEndpoints
 
+	// configures the client of the proxy, see NewCentralizedStrategyWithClientOptions
+	clientOptions ClientOptions
+
+	// intervals and jitters of the pollers
+	config CentralizedConfig
+
+	// creates the timers of the pollers, newPollTimer if nil
+	newTimer func(d, jitter time.Duration) pollTimer
+
+	// in-flight one-off refreshes, see Flush
+	inflight sync.WaitGroup
+
+	// logger.Scoped set by LoadLogger, see log
+	scopedLogger atomic.Value
+
+	// cache of the rules matching requests, see EnableMatchCache
+	matchCache atomic.Pointer[matchCache]
+
 	mu sync.RWMutex
 }
 
@@ -129,15 +158,18 @@ func newCentralizedStrategy(fb *LocalizedStrategy) (*CentralizedStrategy, error)
 // ShouldTrace determines whether a request should be sampled. It matches the given parameters against
 // a list of known rules and uses the matched rule's values to make a decision.
 func (ss *CentralizedStrategy) ShouldTrace(request *Request) *Decision {
-	ss.mu.Lock()
-	if !ss.pollerStart {
-		ss.start()
+	if !ss.started.Load() {
+		ss.mu.Lock()
+		if !ss.pollerStart {
+			ss.start()
+		}
+		ss.started.Store(true)
+		ss.mu.Unlock()
 	}
-	ss.mu.Unlock()
 	if request.ServiceType == "" {
-		request.ServiceType = plugins.InstancePluginMetadata.Origin
+		request.ServiceType = plugins.Detect().Origin
 	}
-	logger.Debugf(
+	ss.log().Debugf(
 		"Determining ShouldTrace decision for:\n\thost: %s\n\tpath: %s\n\tmethod: %s\n\tservicename: %s\n\tservicetype: %s",
 		request.Host,
 		request.URL,
@@ -146,98 +178,217 @@ func (ss *CentralizedStrategy) ShouldTrace(request *Request) *Decision {
 		request.ServiceType,
 	)
 
+	// Rules published by the last change of the manifest
+	rs := ss.manifest.load()
+
 	// Use fallback if manifest is expired
-	if ss.manifest.expired() {
-		logger.Debug("Centralized sampling data expired. Using fallback sampling strategy")
+	if rs.expired(ss.manifest.clock.Now().Unix()) {
+		ss.log().Debug("Centralized sampling data expired. Using fallback sampling strategy")
 
-		return ss.fallback.ShouldTrace(request)
+		return ss.fallbackShouldTrace(request)
 	}
 
-	ss.manifest.mu.RLock()
-	defer ss.manifest.mu.RUnlock()
-
 	// Match against known rules
-	for _, r := range ss.manifest.Rules {
-
-		r.mu.RLock()
-		applicable := r.AppliesTo(request)
-		r.mu.RUnlock()
-
-		if !applicable {
-			continue
-		}
+	if i := ss.matchRule(rs, request); i >= 0 {
+		r := rs.rules[i].rule
+		ss.log().Debugf("Applicable rule: %s", r.ruleName)
 
-		logger.Debugf("Applicable rule: %s", r.ruleName)
-
-		return r.Sample()
+		sd := r.Sample()
+		sd.Source = DecisionSourceRule
+		return sd
 	}
 
 	// Match against default rule
-	if r := ss.manifest.Default; r != nil {
-		logger.Debugf("Applicable rule: %s", r.ruleName)
+	if r := rs.def; r != nil {
+		ss.log().Debugf("Applicable rule: %s", r.ruleName)
 
-		return r.Sample()
+		sd := r.Sample()
+		sd.Source = DecisionSourceDefaultRule
+		return sd
 	}
 
 	// Use fallback if default rule is unavailable
-	logger.Debug("Centralized default sampling rule unavailable. Using fallback sampling strategy")
+	ss.log().Debug("Centralized default sampling rule unavailable. Using fallback sampling strategy")
+
+	return ss.fallbackShouldTrace(request)
+}
+
+// fallbackShouldTrace takes the sampling decision for request by the local
+// fallback rules.
+func (ss *CentralizedStrategy) fallbackShouldTrace(request *Request) *Decision {
+	sd := ss.fallback.ShouldTrace(request)
+	sd.Source = DecisionSourceFallback
+	return sd
+}
+
+// matchRule returns the index of the first of the rules rs applying to
+// request, or -1 if none does, using the match cache if enabled. Requests
+// with attributes aren't cached.
+func (ss *CentralizedStrategy) matchRule(rs *manifestRules, request *Request) int {
+	c := ss.matchCache.Load()
+	if c == nil || len(request.Attributes) > 0 {
+		return rs.match(request)
+	}
+	return c.match(newMatchKey(request), rs.generation, func() int {
+		return rs.match(request)
+	})
+}
+
+// EnableMatchCache caches which rule matches the last size distinct
+// requests, by host, method, URL path, service name and service type, or
+// the last DefaultMatchCacheSize ones if size is zero or less. The decision
+// to sample each request is still made by its rule, so the cache only saves
+// matching it against the rules, which is worth it with many rules. Cached
+// matches are discarded when the rules change. Requests with attributes
+// aren't cached.
+func (ss *CentralizedStrategy) EnableMatchCache(size int) {
+	ss.matchCache.Store(newMatchCache(size))
+}
 
-	return ss.fallback.ShouldTrace(request)
+// MatchCacheStats returns the hits and misses of the match cache, see
+// EnableMatchCache.
+func (ss *CentralizedStrategy) MatchCacheStats() MatchCacheStats {
+	return ss.matchCache.Load().stats()
 }
 
-// start initiates rule and target pollers.
+const (
+	// initial and maximum delay between attempts to create the proxy
+	proxyRetryBackoff    = time.Second
+	proxyRetryMaxBackoff = 5 * time.Minute
+)
+
+// start initiates rule and target pollers. If the proxy can't be created,
+// e.g. because the daemon address doesn't resolve yet, it is retried in the
+// background and the fallback strategy is used meanwhile.
+// Only called with ss.mu held.
 func (ss *CentralizedStrategy) start() {
-	if !ss.pollerStart {
-		var er error
-		ss.proxy, er = newProxy(ss.daemonEndpoints)
-		if er != nil {
-			panic(er)
-		}
-		ss.startRulePoller()
-		ss.startTargetPoller()
+	if ss.pollerStart || ss.proxyRetrying {
+		return
 	}
 
+	if err := ss.startPollers(); err != nil {
+		ss.log().Errorf("Error creating proxy for centralized sampling, using fallback sampling strategy until it succeeds. %v", err)
+		ss.proxyRetrying = true
+		go ss.retryStart()
+	}
+}
+
+// startPollers creates the proxy and starts the rule and target pollers.
+// Only called with ss.mu held.
+func (ss *CentralizedStrategy) startPollers() error {
+	p, err := newProxy(ss.daemonEndpoints, ss.log(), ss.clientOptions)
+	if err != nil {
+		return err
+	}
+
+	ss.proxy = p
+	ss.startRulePoller()
+	ss.startTargetPoller()
 	ss.pollerStart = true
+
+	return nil
+}
+
+// retryStart retries startPollers with exponential backoff and jitter,
+// capped at proxyRetryMaxBackoff, until it succeeds.
+func (ss *CentralizedStrategy) retryStart() {
+	backoff := proxyRetryBackoff
+	for {
+		t := utils.NewTimer(backoff, backoff/2)
+		<-t.C()
+
+		ss.mu.Lock()
+		err := ss.startPollers()
+		if err == nil {
+			ss.proxyRetrying = false
+		}
+		ss.mu.Unlock()
+
+		if err == nil {
+			ss.log().Info("Successfully created proxy for centralized sampling")
+			return
+		}
+		ss.log().Debugf("Error creating proxy for centralized sampling. %v", err)
+
+		if backoff *= 2; backoff > proxyRetryMaxBackoff {
+			backoff = proxyRetryMaxBackoff
+		}
+	}
 }
 
 // startRulePoller starts rule poller.
 func (ss *CentralizedStrategy) startRulePoller() {
 	// Initial refresh
-	go func() {
+	ss.goAsync(func() {
 		if err := ss.refreshManifest(); err != nil {
-			logger.Debugf("Error occurred during initial refresh of sampling rules. %v", err)
+			ss.log().Debugf("Error occurred during initial refresh of sampling rules. %v", err)
 		} else {
-			logger.Info("Successfully fetched sampling rules")
+			ss.log().Info("Successfully fetched sampling rules")
 		}
-	}()
+	})
 
 	// Periodic manifest refresh
+	cfg := ss.config.withDefaults()
+	t := ss.pollTimer(cfg.RuleRefreshInterval, cfg.RuleRefreshJitter)
 	go func() {
-		// Period = 300s, Jitter = 5s
-		t := utils.NewTimer(300*time.Second, 5*time.Second)
 
 		for range t.C() {
 			t.Reset()
 			if err := ss.refreshManifest(); err != nil {
-				logger.Debugf("Error occurred while refreshing sampling rules. %v", err)
+				ss.log().Debugf("Error occurred while refreshing sampling rules. %v", err)
 			} else {
-				logger.Debug("Successfully fetched sampling rules")
+				ss.log().Debug("Successfully fetched sampling rules")
 			}
 		}
 	}()
 }
 
+// pollTimer creates the timer of a poller with period d and jitter.
+func (ss *CentralizedStrategy) pollTimer(d, jitter time.Duration) pollTimer {
+	if ss.newTimer != nil {
+		return ss.newTimer(d, jitter)
+	}
+	return newPollTimer(d, jitter)
+}
+
+// goAsync runs the one-off operation fn in a new goroutine, awaited by Flush.
+func (ss *CentralizedStrategy) goAsync(fn func()) {
+	ss.inflight.Add(1)
+	go func() {
+		defer ss.inflight.Done()
+		fn()
+	}()
+}
+
+// Flush waits for the in-flight one-off refreshes of the sampling rules, i.e.
+// the initial and out-of-band refreshes, or until ctx is done. The periodic
+// pollers are not awaited.
+func (ss *CentralizedStrategy) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		ss.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // startTargetPoller starts target poller.
 func (ss *CentralizedStrategy) startTargetPoller() {
 	// Periodic quota refresh
+	cfg := ss.config.withDefaults()
+	t := ss.pollTimer(cfg.TargetRefreshInterval, cfg.TargetRefreshJitter)
 	go func() {
-		// Period = 10.1s, Jitter = 100ms
-		t := utils.NewTimer(10*time.Second+100*time.Millisecond, 100*time.Millisecond)
 
 		for range t.C() {
 			t.Reset()
 			if err := ss.refreshTargets(); err != nil {
-				logger.Debugf("Error occurred while refreshing targets for sampling rules. %v", err)
+				ss.log().Debugf("Error occurred while refreshing targets for sampling rules. %v", err)
 			}
 		}
 	}()
@@ -275,43 +426,38 @@ func (ss *CentralizedStrategy) refreshManifest() (err error) {
 		// Extract rule from record
 		svcRule := record.SamplingRule
 		if svcRule == nil {
-			logger.Debug("Sampling rule missing from sampling rule record.")
+			ss.log().Debug("Sampling rule missing from sampling rule record.")
 			failed = true
 			continue
 		}
 
 		if svcRule.RuleName == nil {
-			logger.Debug("Sampling rule without rule name is not supported")
+			ss.log().Debug("Sampling rule without rule name is not supported")
 			failed = true
 			continue
 		}
 
 		// Only sampling rule with version 1 is valid
 		if svcRule.Version == nil {
-			logger.Debug("Sampling rule without version number is not supported: ", *svcRule.RuleName)
+			ss.log().Debug("Sampling rule without version number is not supported: ", *svcRule.RuleName)
 			failed = true
 			continue
 		}
 		version := *svcRule.Version
 		if version != int64(1) {
-			logger.Debug("Sampling rule without version 1 is not supported: ", *svcRule.RuleName)
+			ss.log().Debug("Sampling rule without version 1 is not supported: ", *svcRule.RuleName)
 			failed = true
 			continue
 		}
 
-		if len(svcRule.Attributes) != 0 {
-			logger.Debug("Sampling rule with non nil Attributes is not applicable: ", *svcRule.RuleName)
-			continue
-		}
-
 		if svcRule.ResourceARN == nil {
-			logger.Debug("Sampling rule without ResourceARN is not applicable: ", *svcRule.RuleName)
+			ss.log().Debug("Sampling rule without ResourceARN is not applicable: ", *svcRule.RuleName)
 			continue
 		}
 
 		resourceARN := *svcRule.ResourceARN
 		if resourceARN != "*" {
-			logger.Debug("Sampling rule with ResourceARN not equal to * is not applicable: ", *svcRule.RuleName)
+			ss.log().Debug("Sampling rule with ResourceARN not equal to * is not applicable: ", *svcRule.RuleName)
 			continue
 		}
 
@@ -319,7 +465,7 @@ func (ss *CentralizedStrategy) refreshManifest() (err error) {
 		r, putErr := ss.manifest.putRule(svcRule)
 		if putErr != nil {
 			failed = true
-			logger.Debugf("Error occurred creating/updating rule. %v", putErr)
+			ss.log().Debugf("Error occurred creating/updating rule. %v", putErr)
 		} else if r != nil {
 			actives[r] = true
 		}
@@ -337,9 +483,7 @@ func (ss *CentralizedStrategy) refreshManifest() (err error) {
 	ss.manifest.sort()
 
 	// Update refreshedAt timestamp
-	ss.manifest.mu.Lock()
-	ss.manifest.refreshedAt = now
-	ss.manifest.mu.Unlock()
+	ss.manifest.refreshed(now)
 
 	return
 }
@@ -366,7 +510,7 @@ func (ss *CentralizedStrategy) refreshTargets() (err error) {
 
 	// Do not refresh targets if no statistics to report
 	if len(statistics) == 0 {
-		logger.Debugf("No statistics to report. Not refreshing sampling targets.")
+		ss.log().Debugf("No statistics to report. Not refreshing sampling targets.")
 		return nil
 	}
 
@@ -380,13 +524,13 @@ func (ss *CentralizedStrategy) refreshTargets() (err error) {
 	for _, t := range output.SamplingTargetDocuments {
 		if err = ss.updateTarget(t); err != nil {
 			failed = true
-			logger.Debugf("Error occurred updating target for rule. %v", err)
+			ss.log().Debugf("Error occurred updating target for rule. %v", err)
 		}
 	}
 
 	// Consume unprocessed statistics messages
 	for _, s := range output.UnprocessedStatistics {
-		logger.Debugf(
+		ss.log().Debugf(
 			"Error occurred updating sampling target for rule: %s, code: %s, message: %s",
 			s.RuleName,
 			s.ErrorCode,
@@ -413,7 +557,7 @@ func (ss *CentralizedStrategy) refreshTargets() (err error) {
 	if failed {
 		err = errors.New("error occurred updating sampling targets")
 	} else {
-		logger.Debug("Successfully refreshed sampling targets")
+		ss.log().Debug("Successfully refreshed sampling targets")
 	}
 
 	// Set refresh flag if modifiedAt timestamp from remote is greater than ours.
@@ -428,13 +572,13 @@ func (ss *CentralizedStrategy) refreshTargets() (err error) {
 	}
 	// Perform out-of-band async manifest refresh if flag is set
 	if refresh {
-		logger.Infof("Refreshing sampling rules out-of-band.")
+		ss.log().Infof("Refreshing sampling rules out-of-band.")
 
-		go func() {
+		ss.goAsync(func() {
 			if err := ss.refreshManifest(); err != nil {
-				logger.Debugf("Error occurred refreshing sampling rules out-of-band. %v", err)
+				ss.log().Debugf("Error occurred refreshing sampling rules out-of-band. %v", err)
 			}
-		}()
+		})
 	}
 	return
 }
@@ -515,6 +659,18 @@ func (ss *CentralizedStrategy) updateTarget(t *xraySvc.SamplingTargetDocument) (
 	return nil
 }
 
+// LoadLogger makes the strategy and its pollers log to l instead of the
+// logger set by xray.SetLogger. A nil l restores the latter.
+func (ss *CentralizedStrategy) LoadLogger(l xraylog.Logger) {
+	ss.scopedLogger.Store(logger.With(l))
+}
+
+// log returns the logger set by LoadLogger.
+func (ss *CentralizedStrategy) log() logger.Scoped {
+	l, _ := ss.scopedLogger.Load().(logger.Scoped)
+	return l
+}
+
 // LoadDaemonEndpoints configures proxy with the provided endpoint.
 func (ss *CentralizedStrategy) LoadDaemonEndpoints(endpoints *daemoncfg.DaemonEndpoints) {
 	ss.daemonEndpoints = endpoints
@@ -0,0 +1,139 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/utils"
+)
+
+const (
+	defaultRuleRefreshInterval   = 300 * time.Second
+	defaultRuleRefreshJitter     = 5 * time.Second
+	defaultTargetRefreshInterval = 10*time.Second + 100*time.Millisecond
+	defaultTargetRefreshJitter   = 100 * time.Millisecond
+
+	// minimum interval between two refreshes of rules or targets
+	minRefreshInterval = time.Second
+)
+
+// CentralizedConfig configures the pollers of a CentralizedStrategy.
+// Zero values are replaced with the defaults.
+type CentralizedConfig struct {
+	// RuleRefreshInterval is the period sampling rules are refreshed with.
+	// It must be at least one second and less than an hour, after which the
+	// rules expire. Defaults to 5 minutes.
+	RuleRefreshInterval time.Duration
+
+	// RuleRefreshJitter is the maximum random amount each rule refresh
+	// happens early by. It must be less than RuleRefreshInterval.
+	// Defaults to 5 seconds, or a tenth of RuleRefreshInterval if less.
+	RuleRefreshJitter time.Duration
+
+	// TargetRefreshInterval is the period sampling targets are refreshed
+	// with, reporting the sampling statistics of the rules. It must be at
+	// least one second. Defaults to 10.1 seconds.
+	TargetRefreshInterval time.Duration
+
+	// TargetRefreshJitter is the maximum random amount each target refresh
+	// happens early by. It must be less than TargetRefreshInterval.
+	// Defaults to 100 milliseconds, or a tenth of TargetRefreshInterval if
+	// less.
+	TargetRefreshJitter time.Duration
+}
+
+// withDefaults returns c with zero values replaced with the defaults.
+func (c CentralizedConfig) withDefaults() CentralizedConfig {
+	if c.RuleRefreshInterval == 0 {
+		c.RuleRefreshInterval = defaultRuleRefreshInterval
+	}
+	if c.RuleRefreshJitter == 0 {
+		c.RuleRefreshJitter = defaultJitter(defaultRuleRefreshJitter, c.RuleRefreshInterval)
+	}
+	if c.TargetRefreshInterval == 0 {
+		c.TargetRefreshInterval = defaultTargetRefreshInterval
+	}
+	if c.TargetRefreshJitter == 0 {
+		c.TargetRefreshJitter = defaultJitter(defaultTargetRefreshJitter, c.TargetRefreshInterval)
+	}
+	return c
+}
+
+// defaultJitter returns def, or a tenth of interval if that's less.
+func defaultJitter(def, interval time.Duration) time.Duration {
+	if interval/10 < def {
+		return interval / 10
+	}
+	return def
+}
+
+// validate returns an error if the intervals or jitters of c, with the
+// defaults applied, are out of range.
+func (c CentralizedConfig) validate() error {
+	c = c.withDefaults()
+	if c.RuleRefreshInterval < minRefreshInterval || c.RuleRefreshInterval >= manifestTTL*time.Second {
+		return fmt.Errorf("rule refresh interval must be between %v and %v: %v", minRefreshInterval, manifestTTL*time.Second, c.RuleRefreshInterval)
+	}
+	if c.TargetRefreshInterval < minRefreshInterval {
+		return fmt.Errorf("target refresh interval must be at least %v: %v", minRefreshInterval, c.TargetRefreshInterval)
+	}
+	if c.RuleRefreshJitter < 0 || c.RuleRefreshJitter >= c.RuleRefreshInterval {
+		return errors.New("rule refresh jitter must be less than the rule refresh interval")
+	}
+	if c.TargetRefreshJitter < 0 || c.TargetRefreshJitter >= c.TargetRefreshInterval {
+		return errors.New("target refresh jitter must be less than the target refresh interval")
+	}
+	return nil
+}
+
+// pollTimer is the timer of a poller, see utils.Timer.
+type pollTimer interface {
+	C() <-chan time.Time
+	Reset()
+}
+
+// newPollTimer creates the timer of a poller.
+func newPollTimer(d, jitter time.Duration) pollTimer {
+	return utils.NewTimer(d, jitter)
+}
+
+// NewCentralizedStrategyWithConfig creates a centralized sampling strategy
+// with a fallback on local default rule, refreshing rules and targets as
+// configured by cfg.
+func NewCentralizedStrategyWithConfig(cfg CentralizedConfig) (*CentralizedStrategy, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	ss, err := NewCentralizedStrategy()
+	if err != nil {
+		return nil, err
+	}
+	ss.config = cfg
+	return ss, nil
+}
+
+// NewCentralizedStrategyWithClientOptions creates a centralized sampling
+// strategy like NewCentralizedStrategy, calling the sampling API of the
+// daemon with the client configured by opts.
+func NewCentralizedStrategyWithClientOptions(opts ClientOptions) (*CentralizedStrategy, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	ss, err := NewCentralizedStrategy()
+	if err != nil {
+		return nil, err
+	}
+	ss.clientOptions = opts
+	return ss, nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	xraySvc "github.com/aws/aws-sdk-go/service/xray"
+	"github.com/aws/aws-xray-sdk-go/utils"
+	"github.com/aws/aws-xray-sdk-go/xraylog"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePollTimer is a pollTimer ticking when the test sends on c.
+type fakePollTimer struct {
+	d, jitter time.Duration
+	c         chan time.Time
+	resets    chan struct{}
+}
+
+func (t *fakePollTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakePollTimer) Reset() {
+	t.resets <- struct{}{}
+}
+
+// signalingProxy signals each call to GetSamplingRules on rulesCalls.
+type signalingProxy struct {
+	mockProxy
+	rulesCalls chan struct{}
+}
+
+func (p *signalingProxy) GetSamplingRules() ([]*xraySvc.SamplingRuleRecord, error) {
+	defer func() { p.rulesCalls <- struct{}{} }()
+	return p.mockProxy.GetSamplingRules()
+}
+
+// newPollerTestStrategy returns a strategy with the given config whose
+// pollers use fake timers, appended to timers as they are created.
+func newPollerTestStrategy(t *testing.T, cfg CentralizedConfig, timers *[]*fakePollTimer) (*CentralizedStrategy, *signalingProxy) {
+	ss, err := NewCentralizedStrategyWithConfig(cfg)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	clock := &utils.MockClock{NowTime: 1500000000}
+	ss.clock = clock
+	ss.manifest.clock = clock
+
+	p := &signalingProxy{rulesCalls: make(chan struct{}, 2)}
+	ss.proxy = p
+	ss.newTimer = func(d, jitter time.Duration) pollTimer {
+		timer := &fakePollTimer{d: d, jitter: jitter, c: make(chan time.Time), resets: make(chan struct{}, 1)}
+		*timers = append(*timers, timer)
+		return timer
+	}
+	return ss, p
+}
+
+func TestCentralizedConfigPollerPeriods(t *testing.T) {
+	var timers []*fakePollTimer
+	ss, p := newPollerTestStrategy(t, CentralizedConfig{
+		RuleRefreshInterval:   10 * time.Second,
+		RuleRefreshJitter:     time.Second,
+		TargetRefreshInterval: 2 * time.Second,
+		TargetRefreshJitter:   500 * time.Millisecond,
+	}, &timers)
+
+	ss.startRulePoller()
+	ss.startTargetPoller()
+	defer func() {
+		for _, timer := range timers {
+			close(timer.c)
+		}
+	}()
+
+	if !assert.Len(t, timers, 2) {
+		return
+	}
+	assert.Equal(t, 10*time.Second, timers[0].d)
+	assert.Equal(t, time.Second, timers[0].jitter)
+	assert.Equal(t, 2*time.Second, timers[1].d)
+	assert.Equal(t, 500*time.Millisecond, timers[1].jitter)
+
+	// initial refresh
+	<-p.rulesCalls
+
+	// a tick of the rule poller resets its timer and refreshes the rules
+	timers[0].c <- time.Now()
+	<-timers[0].resets
+	<-p.rulesCalls
+
+	// a tick of the target poller resets its timer
+	timers[1].c <- time.Now()
+	<-timers[1].resets
+}
+
+func TestCentralizedConfigDefaults(t *testing.T) {
+	var timers []*fakePollTimer
+	ss, p := newPollerTestStrategy(t, CentralizedConfig{}, &timers)
+
+	ss.startRulePoller()
+	ss.startTargetPoller()
+	<-p.rulesCalls
+	for _, timer := range timers {
+		close(timer.c)
+	}
+
+	if !assert.Len(t, timers, 2) {
+		return
+	}
+	assert.Equal(t, 300*time.Second, timers[0].d)
+	assert.Equal(t, 5*time.Second, timers[0].jitter)
+	assert.Equal(t, 10*time.Second+100*time.Millisecond, timers[1].d)
+	assert.Equal(t, 100*time.Millisecond, timers[1].jitter)
+}
+
+func TestCentralizedConfigLiteralStrategyDefaults(t *testing.T) {
+	var timers []*fakePollTimer
+	ss := &CentralizedStrategy{
+		newTimer: func(d, jitter time.Duration) pollTimer {
+			timer := &fakePollTimer{d: d, jitter: jitter, c: make(chan time.Time)}
+			timers = append(timers, timer)
+			return timer
+		},
+	}
+
+	ss.startTargetPoller()
+	close(timers[0].c)
+
+	assert.Equal(t, 10*time.Second+100*time.Millisecond, timers[0].d)
+	assert.Equal(t, 100*time.Millisecond, timers[0].jitter)
+}
+
+func TestCentralizedConfigDefaultJitter(t *testing.T) {
+	cfg := CentralizedConfig{RuleRefreshInterval: 2 * time.Second, TargetRefreshInterval: time.Minute}.withDefaults()
+
+	assert.NoError(t, cfg.validate())
+	assert.Equal(t, 200*time.Millisecond, cfg.RuleRefreshJitter)
+	assert.Equal(t, 100*time.Millisecond, cfg.TargetRefreshJitter)
+}
+
+func TestNewCentralizedStrategyWithInvalidConfig(t *testing.T) {
+	configs := []CentralizedConfig{
+		{RuleRefreshInterval: 500 * time.Millisecond},
+		{RuleRefreshInterval: time.Hour},
+		{TargetRefreshInterval: 500 * time.Millisecond},
+		{TargetRefreshInterval: -time.Second},
+		{RuleRefreshInterval: 2 * time.Second, RuleRefreshJitter: 2 * time.Second},
+		{RuleRefreshJitter: -time.Second},
+		{TargetRefreshInterval: time.Second, TargetRefreshJitter: time.Second},
+	}
+
+	for _, cfg := range configs {
+		ss, err := NewCentralizedStrategyWithConfig(cfg)
+		assert.Error(t, err)
+		assert.Nil(t, ss)
+	}
+}
+
+// chanLogger sends the messages logged to it on its channel.
+type chanLogger chan string
+
+func (l chanLogger) Log(level xraylog.LogLevel, msg fmt.Stringer) {
+	l <- msg.String()
+}
+
+// awaitLog receives from l until a message containing substr.
+func awaitLog(t *testing.T, l chanLogger, substr string) {
+	for {
+		select {
+		case msg := <-l:
+			if strings.Contains(msg, substr) {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no message containing %q was logged", substr)
+		}
+	}
+}
+
+func TestCentralizedStrategyLoadLogger(t *testing.T) {
+	var timers1, timers2 []*fakePollTimer
+	ss1, _ := newPollerTestStrategy(t, CentralizedConfig{}, &timers1)
+	ss2, _ := newPollerTestStrategy(t, CentralizedConfig{}, &timers2)
+
+	l1, l2 := make(chanLogger, 16), make(chanLogger, 16)
+	ss1.LoadLogger(l1)
+	ss2.LoadLogger(l2)
+
+	ss1.startRulePoller()
+	defer close(timers1[0].c)
+	awaitLog(t, l1, "Error occurred during initial refresh of sampling rules")
+
+	timers1[0].c <- time.Now()
+	<-timers1[0].resets
+	awaitLog(t, l1, "Error occurred while refreshing sampling rules")
+
+	ss2.startRulePoller()
+	defer close(timers2[0].c)
+	awaitLog(t, l2, "Error occurred during initial refresh of sampling rules")
+
+	// nothing of the second strategy was logged to the first logger
+	select {
+	case msg := <-l1:
+		t.Errorf("unexpected message: %s", msg)
+	default:
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/pattern"
+)
+
+// SnapshotOptions selects the rules included in a ManifestSnapshot.
+type SnapshotOptions struct {
+	// RuleName is a wildcard pattern rule names must match. All rules match
+	// when empty.
+	RuleName string
+
+	// Offset is the number of matching rules skipped.
+	Offset int
+
+	// Limit is the maximum number of rules returned. All matching rules
+	// after Offset are returned when zero.
+	Limit int
+}
+
+// RuleSnapshot is the state of a centralized sampling rule.
+type RuleSnapshot struct {
+	Name        string  `json:"name"`
+	Priority    int64   `json:"priority"`
+	ServiceName string  `json:"service_name"`
+	ServiceType string  `json:"service_type"`
+	Host        string  `json:"host"`
+	HTTPMethod  string  `json:"http_method"`
+	URLPath     string  `json:"url_path"`
+	ResourceARN string  `json:"resource_arn"`
+	FixedTarget int64   `json:"fixed_target"`
+	Rate        float64 `json:"rate"`
+	Quota       int64   `json:"quota"`
+	Requests    int64   `json:"requests"`
+	Sampled     int64   `json:"sampled"`
+	Borrows     int64   `json:"borrows"`
+}
+
+// ManifestSnapshot is the state of the centralized sampling rules manifest.
+// Rules are sorted by priority, then by name.
+type ManifestSnapshot struct {
+	RefreshedAt time.Time      `json:"refreshed_at"`
+	Expired     bool           `json:"expired"`
+	Default     *RuleSnapshot  `json:"default,omitempty"`
+	Total       int            `json:"total"` // number of rules matching RuleName
+	Rules       []RuleSnapshot `json:"rules"`
+}
+
+// ManifestSnapshot returns the current state of the sampling rules manifest,
+// with the user-defined rules selected by opts.
+func (ss *CentralizedStrategy) ManifestSnapshot(opts SnapshotOptions) *ManifestSnapshot {
+	return ss.manifest.snapshot(opts)
+}
+
+// ManifestHandler returns a handler serving ManifestSnapshot as JSON, for
+// debugging. The rule, offset and limit query parameters set the
+// corresponding SnapshotOptions.
+func (ss *CentralizedStrategy) ManifestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		opts := SnapshotOptions{RuleName: q.Get("rule")}
+		for _, p := range []struct {
+			name string
+			v    *int
+		}{{"offset", &opts.Offset}, {"limit", &opts.Limit}} {
+			s := q.Get(p.name)
+			if s == "" {
+				continue
+			}
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid "+p.name+" parameter", http.StatusBadRequest)
+				return
+			}
+			*p.v = n
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ss.ManifestSnapshot(opts))
+	})
+}
+
+// snapshot returns the state of the manifest and of the rules selected by opts.
+func (m *CentralizedManifest) snapshot(opts SnapshotOptions) *ManifestSnapshot {
+	m.mu.RLock()
+	rules := make([]*CentralizedRule, 0, len(m.Rules))
+	for _, r := range m.Rules {
+		if opts.RuleName == "" || pattern.WildcardMatchCaseInsensitive(opts.RuleName, r.ruleName) {
+			rules = append(rules, r)
+		}
+	}
+	def := m.Default
+	refreshedAt := m.refreshedAt
+	expired := m.refreshedAt < m.clock.Now().Unix()-manifestTTL
+	m.mu.RUnlock()
+
+	// Sort copies of the rules, as priorities may be updated concurrently.
+	snapshots := make([]RuleSnapshot, len(rules))
+	for i, r := range rules {
+		snapshots[i] = r.ruleSnapshot()
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].Priority == snapshots[j].Priority {
+			return strings.Compare(snapshots[i].Name, snapshots[j].Name) < 0
+		}
+		return snapshots[i].Priority < snapshots[j].Priority
+	})
+
+	s := &ManifestSnapshot{
+		Expired: expired,
+		Total:   len(snapshots),
+	}
+	if refreshedAt != 0 {
+		s.RefreshedAt = time.Unix(refreshedAt, 0).UTC()
+	}
+	if def != nil {
+		d := def.ruleSnapshot()
+		s.Default = &d
+	}
+
+	if opts.Offset < len(snapshots) {
+		snapshots = snapshots[opts.Offset:]
+	} else {
+		snapshots = []RuleSnapshot{}
+	}
+	if opts.Limit > 0 && opts.Limit < len(snapshots) {
+		snapshots = snapshots[:opts.Limit]
+	}
+	s.Rules = snapshots
+	return s
+}
+
+// ruleSnapshot returns the state of r. Unlike snapshot, it does not reset
+// the statistics counters.
+func (r *CentralizedRule) ruleSnapshot() RuleSnapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s := RuleSnapshot{
+		Name:        r.ruleName,
+		Priority:    r.priority,
+		ServiceType: r.serviceType,
+		ResourceARN: r.resourceARN,
+		Requests:    r.requests.Load(),
+		Sampled:     r.sampled.Load(),
+		Borrows:     r.borrows.Load(),
+	}
+	if r.Properties != nil {
+		s.ServiceName = r.ServiceName
+		s.Host = r.Host
+		s.HTTPMethod = r.HTTPMethod
+		s.URLPath = r.URLPath
+		s.FixedTarget = r.FixedTarget
+		s.Rate = r.Rate
+	}
+	if r.reservoir != nil {
+		s.Quota = r.reservoir.quota
+	}
+	return s
+}
@@ -0,0 +1,143 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	xraySvc "github.com/aws/aws-sdk-go/service/xray"
+	"github.com/aws/aws-xray-sdk-go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSvcSamplingRule(name string, priority int64) *xraySvc.SamplingRule {
+	wildcard := "*"
+	reservoirSize := int64(1)
+	fixedRate := 0.05
+	return &xraySvc.SamplingRule{
+		RuleName:      &name,
+		Priority:      &priority,
+		ServiceName:   &wildcard,
+		ServiceType:   &wildcard,
+		Host:          &wildcard,
+		HTTPMethod:    &wildcard,
+		URLPath:       &wildcard,
+		ResourceARN:   &wildcard,
+		ReservoirSize: &reservoirSize,
+		FixedRate:     &fixedRate,
+	}
+}
+
+// newSnapshotTestStrategy returns a strategy whose manifest holds 150 rules
+// named rule-000 to rule-149, with priorities cycling from 9 down to 0.
+func newSnapshotTestStrategy(t *testing.T, clock *utils.MockClock) *CentralizedStrategy {
+	m := &CentralizedManifest{
+		Rules:       []*CentralizedRule{},
+		Index:       map[string]*CentralizedRule{},
+		refreshedAt: 1500000000,
+		clock:       clock,
+	}
+	for i := 0; i < 150; i++ {
+		_, err := m.putRule(newSvcSamplingRule(fmt.Sprintf("rule-%03d", i), int64(9-i%10)))
+		assert.NoError(t, err)
+	}
+	_, err := m.putRule(newSvcSamplingRule(defaultRule, 10000))
+	assert.NoError(t, err)
+	return &CentralizedStrategy{manifest: m, clock: clock}
+}
+
+func ruleNames(rules []RuleSnapshot) []string {
+	names := make([]string, len(rules))
+	for i, r := range rules {
+		names[i] = r.Name
+	}
+	return names
+}
+
+func TestManifestSnapshot(t *testing.T) {
+	clock := &utils.MockClock{NowTime: 1500000010}
+	ss := newSnapshotTestStrategy(t, clock)
+
+	s := ss.ManifestSnapshot(SnapshotOptions{})
+	assert.Equal(t, 150, s.Total)
+	assert.Len(t, s.Rules, 150)
+	assert.Equal(t, time.Unix(1500000000, 0).UTC(), s.RefreshedAt)
+	assert.False(t, s.Expired)
+	if assert.NotNil(t, s.Default) {
+		assert.Equal(t, defaultRule, s.Default.Name)
+	}
+
+	// sorted by priority, then by name
+	assert.Equal(t, []string{"rule-009", "rule-019", "rule-029"}, ruleNames(s.Rules[:3]))
+	assert.Equal(t, []string{"rule-130", "rule-140"}, ruleNames(s.Rules[148:]))
+	for i := 1; i < len(s.Rules); i++ {
+		prev, cur := s.Rules[i-1], s.Rules[i]
+		assert.True(t, prev.Priority < cur.Priority || prev.Priority == cur.Priority && prev.Name < cur.Name)
+	}
+
+	clock.NowTime += manifestTTL
+	assert.True(t, ss.ManifestSnapshot(SnapshotOptions{}).Expired)
+}
+
+func TestManifestSnapshotFilterAndPagination(t *testing.T) {
+	ss := newSnapshotTestStrategy(t, &utils.MockClock{NowTime: 1500000010})
+
+	// rule-050 to rule-059
+	s := ss.ManifestSnapshot(SnapshotOptions{RuleName: "RULE-?5?"})
+	assert.Equal(t, 10, s.Total)
+	assert.Equal(t, []string{"rule-059", "rule-058", "rule-057"}, ruleNames(s.Rules[:3]))
+
+	// rule-100 to rule-149
+	s = ss.ManifestSnapshot(SnapshotOptions{RuleName: "rule-1*", Offset: 2, Limit: 3})
+	assert.Equal(t, 50, s.Total)
+	assert.Equal(t, []string{"rule-129", "rule-139", "rule-149"}, ruleNames(s.Rules))
+
+	// pages cover every rule once
+	var names []string
+	for offset := 0; ; offset += 40 {
+		page := ss.ManifestSnapshot(SnapshotOptions{Offset: offset, Limit: 40})
+		if len(page.Rules) == 0 {
+			break
+		}
+		names = append(names, ruleNames(page.Rules)...)
+	}
+	assert.Equal(t, ruleNames(ss.ManifestSnapshot(SnapshotOptions{}).Rules), names)
+
+	s = ss.ManifestSnapshot(SnapshotOptions{RuleName: "missing-*"})
+	assert.Equal(t, 0, s.Total)
+	assert.Empty(t, s.Rules)
+	assert.NotNil(t, s.Default)
+}
+
+func TestManifestHandler(t *testing.T) {
+	ss := newSnapshotTestStrategy(t, &utils.MockClock{NowTime: 1500000010})
+	h := ss.ManifestHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sampling?rule=rule-1*&offset=2&limit=3", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var s ManifestSnapshot
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &s))
+	assert.Equal(t, 50, s.Total)
+	assert.Equal(t, []string{"rule-129", "rule-139", "rule-149"}, ruleNames(s.Rules))
+	assert.Equal(t, defaultRule, s.Default.Name)
+
+	for _, query := range []string{"limit=ten", "offset=-1"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sampling?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
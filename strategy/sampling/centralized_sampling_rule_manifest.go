@@ -13,6 +13,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	xraySvc "github.com/aws/aws-sdk-go/service/xray"
 	"github.com/aws/aws-xray-sdk-go/utils"
@@ -33,6 +34,75 @@ type CentralizedManifest struct {
 	refreshedAt int64
 	clock       utils.Clock
 	mu          sync.RWMutex
+
+	// copy of the rules published on every change, see load
+	published atomic.Pointer[manifestRules]
+
+	// number of times the rules were published
+	generation uint64
+}
+
+// manifestRules is an immutable copy of the sorted rules of a manifest,
+// matched against requests without locking.
+type manifestRules struct {
+	rules       []ruleMatcher
+	def         *CentralizedRule
+	refreshedAt int64
+
+	// generation of the rules, which differs from that of any other
+	// rules published by the manifest
+	generation uint64
+}
+
+// match returns the index of the first rule applying to request, or -1 if
+// none does.
+func (rs *manifestRules) match(request *Request) int {
+	for i := range rs.rules {
+		if rs.rules[i].appliesTo(request) {
+			return i
+		}
+	}
+	return -1
+}
+
+// expired returns true if the rules have not been successfully refreshed in
+// 'manifestTTL' seconds.
+func (rs *manifestRules) expired(now int64) bool {
+	return rs.refreshedAt < now-manifestTTL
+}
+
+// load returns the rules last published, publishing them first if they
+// never were.
+func (m *CentralizedManifest) load() *manifestRules {
+	if rs := m.published.Load(); rs != nil {
+		return rs
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.published.Load() == nil {
+		m.publish()
+	}
+	return m.published.Load()
+}
+
+// publish replaces the published rules with a copy of the current ones.
+// Assumes write lock is already held.
+func (m *CentralizedManifest) publish() {
+	m.generation++
+	rs := &manifestRules{
+		rules:       make([]ruleMatcher, len(m.Rules)),
+		def:         m.Default,
+		refreshedAt: m.refreshedAt,
+		generation:  m.generation,
+	}
+	for i, r := range m.Rules {
+		r.mu.RLock()
+		rs.rules[i] = r.matcher()
+		r.mu.RUnlock()
+	}
+	m.published.Store(rs)
 }
 
 // putRule updates the named rule if it already exists or creates it if it does not.
@@ -136,6 +206,8 @@ func (m *CentralizedManifest) createUserRule(svcRule *xraySvc.SamplingRule) *Cen
 	// Update index
 	m.Index[*svcRule.RuleName] = csr
 
+	m.publish()
+
 	return csr
 }
 
@@ -155,16 +227,21 @@ func (m *CentralizedManifest) updateUserRule(r *CentralizedRule, svcRule *xraySv
 	}
 
 	p, c := *svcRule.Priority, *svcRule.ReservoirSize
+	st, arn := *svcRule.ServiceType, *svcRule.ResourceARN
 
 	r.mu.Lock()
-	defer r.mu.Unlock()
-
 	r.Properties = pr
 	r.priority = p
 	r.reservoir.capacity = c
-	r.serviceType = *svcRule.ServiceType
-	r.resourceARN = *svcRule.ResourceARN
+	r.serviceType = st
+	r.resourceARN = arn
 	r.attributes = svcRule.Attributes
+	r.mu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.publish()
 }
 
 // createDefaultRule creates a default CentralizedRule and adds it to the manifest.
@@ -210,6 +287,8 @@ func (m *CentralizedManifest) createDefaultRule(svcRule *xraySvc.SamplingRule) *
 	// Update index
 	m.Index[*svcRule.RuleName] = csr
 
+	m.publish()
+
 	return csr
 }
 
@@ -249,6 +328,8 @@ func (m *CentralizedManifest) prune(actives map[*CentralizedRule]bool) {
 			m.deleteRule(i)
 		}
 	}
+
+	m.publish()
 }
 
 // deleteRule deletes the rule from the array, and the index.
@@ -282,13 +363,22 @@ func (m *CentralizedManifest) sort() {
 	defer m.mu.Unlock()
 
 	sort.Slice(m.Rules, less)
+
+	m.publish()
+}
+
+// refreshed sets the timestamp of the last successful refresh of the manifest.
+func (m *CentralizedManifest) refreshed(now int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.refreshedAt = now
+
+	m.publish()
 }
 
 // expired returns true if the manifest has not been successfully refreshed in
 // 'manifestTTL' seconds.
 func (m *CentralizedManifest) expired() bool {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	return m.refreshedAt < m.clock.Now().Unix()-manifestTTL
+	return m.load().expired(m.clock.Now().Unix())
 }
@@ -9,7 +9,11 @@
 package sampling
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -154,8 +158,9 @@ func TestShouldTracePositive1(t *testing.T) {
 
 	assert.True(t, sd.Sample)
 	assert.Equal(t, "r1", *sd.Rule)
-	assert.Equal(t, int64(1), csr1.requests)
-	assert.Equal(t, int64(1), csr1.sampled)
+	assert.Equal(t, DecisionSourceRule, sd.Source)
+	assert.Equal(t, int64(1), csr1.requests.Load())
+	assert.Equal(t, int64(1), csr1.sampled.Load())
 	assert.Equal(t, int64(9), csr1.reservoir.used)
 }
 
@@ -251,8 +256,8 @@ func TestShouldTracePositive2(t *testing.T) {
 
 	assert.True(t, sd.Sample)
 	assert.Equal(t, "r1", *sd.Rule)
-	assert.Equal(t, int64(1), csr1.requests)
-	assert.Equal(t, int64(1), csr1.sampled)
+	assert.Equal(t, int64(1), csr1.requests.Load())
+	assert.Equal(t, int64(1), csr1.sampled.Load())
 	assert.Equal(t, int64(9), csr1.reservoir.used)
 }
 
@@ -331,13 +336,14 @@ func TestShouldTraceDefaultPositive(t *testing.T) {
 	// Assert 'Default' rule was used
 	assert.True(t, sd.Sample)
 	assert.Equal(t, "Default", *sd.Rule)
-	assert.Equal(t, int64(1), m.Default.requests)
-	assert.Equal(t, int64(1), m.Default.sampled)
+	assert.Equal(t, DecisionSourceDefaultRule, sd.Source)
+	assert.Equal(t, int64(1), m.Default.requests.Load())
+	assert.Equal(t, int64(1), m.Default.sampled.Load())
 	assert.Equal(t, int64(9), m.Default.reservoir.used)
 
 	// Assert 'r1' was not used
-	assert.Equal(t, int64(0), csr.requests)
-	assert.Equal(t, int64(0), csr.sampled)
+	assert.Equal(t, int64(0), csr.requests.Load())
+	assert.Equal(t, int64(0), csr.sampled.Load())
 	assert.Equal(t, int64(8), csr.reservoir.used)
 }
 
@@ -425,13 +431,50 @@ func TestShouldTraceExpiredManifest(t *testing.T) {
 	// Assert fallback 'Default' rule was sampled
 	assert.True(t, sd.Sample)
 	assert.Nil(t, sd.Rule)
+	assert.Equal(t, DecisionSourceFallback, sd.Source)
 
 	// Assert 'r1' was not used
-	assert.Equal(t, int64(0), csr.requests)
-	assert.Equal(t, int64(0), csr.sampled)
+	assert.Equal(t, int64(0), csr.requests.Load())
+	assert.Equal(t, int64(0), csr.sampled.Load())
 	assert.Equal(t, int64(8), csr.reservoir.used)
 }
 
+// Assert the fallback strategy is used while the daemon address doesn't resolve
+func TestShouldTraceUnresolvableDaemon(t *testing.T) {
+	t.Setenv("AWS_XRAY_DAEMON_ADDRESS", "xray-daemon.invalid:2000")
+
+	s, err := NewCentralizedStrategy()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	decisions := make([]*Decision, 20)
+	for i := range decisions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			decisions[i] = s.ShouldTrace(&Request{
+				Host:   "www.foo.com",
+				URL:    "/resource/bar",
+				Method: "GET",
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, sd := range decisions {
+		assert.Nil(t, sd.Rule)
+		assert.Equal(t, DecisionSourceFallback, sd.Source)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	assert.False(t, s.pollerStart)
+	assert.True(t, s.proxyRetrying)
+	assert.Nil(t, s.proxy)
+}
+
 // Assert that snapshots returns an array of valid sampling statistics
 func TestSnapshots(t *testing.T) {
 	clock := &utils.MockClock{
@@ -450,13 +493,13 @@ func TestSnapshots(t *testing.T) {
 	}
 	csr1 := &CentralizedRule{
 		ruleName:  name1,
-		requests:  requests1,
-		sampled:   sampled1,
-		borrows:   borrows1,
 		usedAt:    1500000000,
 		reservoir: r1,
 		clock:     clock,
 	}
+	csr1.requests.Store(requests1)
+	csr1.sampled.Store(sampled1)
+	csr1.borrows.Store(borrows1)
 
 	name2 := "r2"
 	requests2 := int64(500)
@@ -467,13 +510,13 @@ func TestSnapshots(t *testing.T) {
 	}
 	csr2 := &CentralizedRule{
 		ruleName:  name2,
-		requests:  requests2,
-		sampled:   sampled2,
-		borrows:   borrows2,
 		usedAt:    1500000000,
 		reservoir: r2,
 		clock:     clock,
 	}
+	csr2.requests.Store(requests2)
+	csr2.sampled.Store(sampled2)
+	csr2.borrows.Store(borrows2)
 
 	rules := []*CentralizedRule{csr1, csr2}
 
@@ -532,13 +575,13 @@ func TestMixedSnapshots(t *testing.T) {
 	}
 	csr1 := &CentralizedRule{
 		ruleName:  name1,
-		requests:  requests1,
-		sampled:   sampled1,
-		borrows:   borrows1,
 		usedAt:    1499999999,
 		reservoir: r1,
 		clock:     clock,
 	}
+	csr1.requests.Store(requests1)
+	csr1.sampled.Store(sampled1)
+	csr1.borrows.Store(borrows1)
 
 	// Stale and inactive rule
 	name2 := "r2"
@@ -551,13 +594,13 @@ func TestMixedSnapshots(t *testing.T) {
 	}
 	csr2 := &CentralizedRule{
 		ruleName:  name2,
-		requests:  requests2,
-		sampled:   sampled2,
-		borrows:   borrows2,
 		usedAt:    1499999999,
 		reservoir: r2,
 		clock:     clock,
 	}
+	csr2.requests.Store(requests2)
+	csr2.sampled.Store(sampled2)
+	csr2.borrows.Store(borrows2)
 
 	// Fresh rule
 	name3 := "r3"
@@ -570,13 +613,13 @@ func TestMixedSnapshots(t *testing.T) {
 	}
 	csr3 := &CentralizedRule{
 		ruleName:  name3,
-		requests:  requests3,
-		sampled:   sampled3,
-		borrows:   borrows3,
 		usedAt:    1499999999,
 		reservoir: r3,
 		clock:     clock,
 	}
+	csr3.requests.Store(requests3)
+	csr3.sampled.Store(sampled3)
+	csr3.borrows.Store(borrows3)
 
 	rules := []*CentralizedRule{csr1, csr2, csr3}
 
@@ -1051,11 +1094,11 @@ func TestRefreshManifestRuleAdditionInvalidRule1(t *testing.T) { // ResourceARN
 	assert.Equal(t, 0, len(ss.manifest.Rules)) // Rule not added
 }
 
-func TestRefreshManifestRuleAdditionInvalidRule2(t *testing.T) { // non nil Attributes
+func TestRefreshManifestRuleAdditionWithAttributes(t *testing.T) {
 	serviceTye := ""
 	resourceARN := "*"
-	attributes := make(map[string]*string)
-	attributes["a"] = &resourceARN
+	attributeValue := "premium-*"
+	attributes := map[string]*string{"tier": &attributeValue}
 
 	// Rule 'r1'
 	r1 := &CentralizedRule{
@@ -1084,7 +1127,7 @@ func TestRefreshManifestRuleAdditionInvalidRule2(t *testing.T) { // non nil Attr
 		refreshedAt: 1500000000,
 	}
 
-	// Valid no-op update for rule 'r1'
+	// Valid update for rule 'r1' with Attributes
 	name1 := "r1"
 	fixedRate1 := 0.05
 	httpMethod1 := "POST"
@@ -1106,7 +1149,7 @@ func TestRefreshManifestRuleAdditionInvalidRule2(t *testing.T) { // non nil Attr
 			Host:          &serviceName1,
 			ServiceType:   &serviceTye,
 			ResourceARN:   &resourceARN,
-			Attributes:    attributes, // invalid
+			Attributes:    attributes,
 		},
 	}
 
@@ -1129,14 +1172,14 @@ func TestRefreshManifestRuleAdditionInvalidRule2(t *testing.T) { // non nil Attr
 
 	err := ss.refreshManifest()
 	assert.Nil(t, err)
-	assert.Equal(t, 0, len(ss.manifest.Rules)) // rule not added
+	assert.Equal(t, 1, len(ss.manifest.Rules))
+	assert.Equal(t, attributes, ss.manifest.Rules[0].attributes)
 }
 
 func TestRefreshManifestRuleAdditionInvalidRule3(t *testing.T) { // 1 valid and 1 invalid rule
 	serviceTye := ""
 	resourceARN := "*"
-	attributes := make(map[string]*string)
-	attributes["a"] = &resourceARN
+	invalidResourceARN := "arn:aws:lambda:us-east-1:123456789012:function:foo"
 
 	// Rule 'r1'
 	r1 := &CentralizedRule{
@@ -1199,8 +1242,7 @@ func TestRefreshManifestRuleAdditionInvalidRule3(t *testing.T) { // 1 valid and
 			Version:       &version1,
 			Host:          &serviceName1,
 			ServiceType:   &serviceTye,
-			ResourceARN:   &resourceARN,
-			Attributes:    attributes, // invalid
+			ResourceARN:   &invalidResourceARN, // invalid
 		},
 	}
 
@@ -1821,9 +1863,6 @@ func TestRefreshTargets(t *testing.T) {
 	// Rule 'r1'
 	r1 := &CentralizedRule{
 		ruleName: "r1",
-		requests: 100,
-		sampled:  6,
-		borrows:  0,
 		usedAt:   1500000000,
 		reservoir: &CentralizedReservoir{
 			quota: 5,
@@ -1844,13 +1883,12 @@ func TestRefreshTargets(t *testing.T) {
 		priority: 4,
 		clock:    clock,
 	}
+	r1.requests.Store(100)
+	r1.sampled.Store(6)
 
 	// Rule 'r3'
 	r3 := &CentralizedRule{
 		ruleName: "r3",
-		requests: 50,
-		sampled:  50,
-		borrows:  0,
 		usedAt:   1500000000,
 		reservoir: &CentralizedReservoir{
 			quota: 10,
@@ -1871,6 +1909,8 @@ func TestRefreshTargets(t *testing.T) {
 		priority: 8,
 		clock:    clock,
 	}
+	r3.requests.Store(50)
+	r3.sampled.Store(50)
 
 	// Sorted array
 	rules := []*CentralizedRule{r1, r3}
@@ -1999,9 +2039,6 @@ func TestRefreshTargetsVariableIntervals(t *testing.T) {
 	// Rule 'r1'. Interval of 20 seconds
 	r1 := &CentralizedRule{
 		ruleName: "r1",
-		requests: 100,
-		sampled:  6,
-		borrows:  0,
 		usedAt:   1499999999,
 		reservoir: &CentralizedReservoir{
 			quota: 5,
@@ -2022,13 +2059,12 @@ func TestRefreshTargetsVariableIntervals(t *testing.T) {
 		priority: 4,
 		clock:    clock,
 	}
+	r1.requests.Store(100)
+	r1.sampled.Store(6)
 
 	// Rule 'r3'. Interval of 30 seconds.
 	r3 := &CentralizedRule{
 		ruleName: "r3",
-		requests: 50,
-		sampled:  50,
-		borrows:  0,
 		usedAt:   1500000000,
 		reservoir: &CentralizedReservoir{
 			quota: 10,
@@ -2049,6 +2085,8 @@ func TestRefreshTargetsVariableIntervals(t *testing.T) {
 		priority: 8,
 		clock:    clock,
 	}
+	r3.requests.Store(50)
+	r3.sampled.Store(50)
 
 	// Sorted array
 	rules := []*CentralizedRule{r1, r3}
@@ -2145,9 +2183,6 @@ func TestRefreshTargetsVariableIntervals(t *testing.T) {
 	// Expected state of 'r3' after refresh
 	expR3 := &CentralizedRule{
 		ruleName: "r3",
-		requests: 50,
-		sampled:  50,
-		borrows:  0,
 		usedAt:   1500000000,
 		reservoir: &CentralizedReservoir{
 			quota: 10,
@@ -2168,6 +2203,8 @@ func TestRefreshTargetsVariableIntervals(t *testing.T) {
 		priority: 8,
 		clock:    clock,
 	}
+	expR3.requests.Store(50)
+	expR3.sampled.Store(50)
 
 	// Only r1 should be refreshed
 	err = ss.refreshTargets()
@@ -2225,9 +2262,6 @@ func TestRefreshTargetsInvalidTarget(t *testing.T) {
 	// Rule 'r1'
 	r1 := &CentralizedRule{
 		ruleName: "r1",
-		requests: 100,
-		sampled:  6,
-		borrows:  0,
 		usedAt:   1500000000,
 		reservoir: &CentralizedReservoir{
 			quota: 5,
@@ -2247,13 +2281,12 @@ func TestRefreshTargetsInvalidTarget(t *testing.T) {
 		priority: 4,
 		clock:    clock,
 	}
+	r1.requests.Store(100)
+	r1.sampled.Store(6)
 
 	// Rule 'r3'
 	r3 := &CentralizedRule{
 		ruleName: "r3",
-		requests: 50,
-		sampled:  50,
-		borrows:  0,
 		usedAt:   1500000000,
 		reservoir: &CentralizedReservoir{
 			quota: 10,
@@ -2273,6 +2306,8 @@ func TestRefreshTargetsInvalidTarget(t *testing.T) {
 		priority: 8,
 		clock:    clock,
 	}
+	r3.requests.Store(50)
+	r3.sampled.Store(50)
 
 	// Sorted array
 	rules := []*CentralizedRule{r1, r3}
@@ -2403,9 +2438,6 @@ func TestRefreshTargetsOutdatedManifest(t *testing.T) {
 	// Existing Rule 'r3'
 	r3 := &CentralizedRule{
 		ruleName: "r3",
-		requests: 50,
-		sampled:  50,
-		borrows:  0,
 		usedAt:   1500000000,
 		reservoir: &CentralizedReservoir{
 			quota: 10,
@@ -2427,6 +2459,8 @@ func TestRefreshTargetsOutdatedManifest(t *testing.T) {
 		clock:       clock,
 		resourceARN: resARN,
 	}
+	r3.requests.Store(50)
+	r3.sampled.Store(50)
 
 	// Sorted array
 	rules := []*CentralizedRule{r3}
@@ -2534,9 +2568,6 @@ func TestRefreshTargetsProxyError(t *testing.T) {
 	// Existing Rule 'r3'
 	r3 := &CentralizedRule{
 		ruleName: "r3",
-		requests: 50,
-		sampled:  50,
-		borrows:  0,
 		usedAt:   1500000000,
 		reservoir: &CentralizedReservoir{
 			quota: 10,
@@ -2555,6 +2586,8 @@ func TestRefreshTargetsProxyError(t *testing.T) {
 		priority: 8,
 		clock:    clock,
 	}
+	r3.requests.Store(50)
+	r3.sampled.Store(50)
 
 	// Sorted array
 	rules := []*CentralizedRule{r3}
@@ -2636,6 +2669,171 @@ func TestLoadDaemonEndpoints2(t *testing.T) {
 	assert.Nil(t, s.daemonEndpoints)
 }
 
+// samplingRuleRecord returns the record of a rule matching requests for host,
+// or of the default rule if host is empty.
+func samplingRuleRecord(name, host string, priority int64) *xraySvc.SamplingRuleRecord {
+	version, size, rate, wildcard, arn := int64(1), int64(10), 0.5, "*", "*"
+	if host == "" {
+		host = wildcard
+	}
+	return &xraySvc.SamplingRuleRecord{
+		SamplingRule: &xraySvc.SamplingRule{
+			RuleName:      &name,
+			ServiceName:   &wildcard,
+			URLPath:       &wildcard,
+			HTTPMethod:    &wildcard,
+			Host:          &host,
+			ServiceType:   &wildcard,
+			ResourceARN:   &arn,
+			Priority:      &priority,
+			ReservoirSize: &size,
+			FixedRate:     &rate,
+			Version:       &version,
+		},
+	}
+}
+
+// alternatingProxy returns each of its rule sets in turn, and targets for
+// all rules.
+type alternatingProxy struct {
+	mockProxy
+	ruleSets [][]*xraySvc.SamplingRuleRecord
+	calls    atomic.Int64
+}
+
+func (p *alternatingProxy) GetSamplingRules() ([]*xraySvc.SamplingRuleRecord, error) {
+	n := p.calls.Add(1)
+	return p.ruleSets[int(n)%len(p.ruleSets)], nil
+}
+
+// newRefreshTestStrategy returns a strategy with started pollers, whose rules
+// r0 to r9 match the hosts host0 to host9, with priorities changing on every
+// refresh of the manifest. Rule r9 is missing from every other refresh.
+func newRefreshTestStrategy() *CentralizedStrategy {
+	clock := &utils.MockClock{NowTime: 1500000000}
+	ttl := time.Unix(1500000100, 0)
+	quota, rate := int64(5), 0.5
+
+	var sets [2][]*xraySvc.SamplingRuleRecord
+	var targets []*xraySvc.SamplingTargetDocument
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("r%d", i)
+		sets[0] = append(sets[0], samplingRuleRecord(name, fmt.Sprintf("host%d", i), int64(i)))
+		if i < 9 {
+			sets[1] = append(sets[1], samplingRuleRecord(name, fmt.Sprintf("host%d", i), int64(10-i)))
+		}
+		targets = append(targets, &xraySvc.SamplingTargetDocument{
+			RuleName:          &name,
+			FixedRate:         &rate,
+			ReservoirQuota:    &quota,
+			ReservoirQuotaTTL: &ttl,
+		})
+	}
+	for i := range sets {
+		sets[i] = append(sets[i], samplingRuleRecord(defaultRule, "", 10000))
+	}
+
+	return &CentralizedStrategy{
+		manifest: &CentralizedManifest{
+			Rules: []*CentralizedRule{},
+			Index: map[string]*CentralizedRule{},
+			clock: clock,
+		},
+		proxy: &alternatingProxy{
+			mockProxy: mockProxy{
+				samplingTargetOutput: &xraySvc.GetSamplingTargetsOutput{SamplingTargetDocuments: targets},
+			},
+			ruleSets: sets[:],
+		},
+		clientID:    "c1",
+		clock:       clock,
+		rand:        &utils.DefaultRand{},
+		pollerStart: true,
+	}
+}
+
+// Assert that decisions made while the manifest and targets are refreshed
+// use the rules matching the requests
+func TestShouldTraceConcurrentRefresh(t *testing.T) {
+	ss := newRefreshTestStrategy()
+	if !assert.NoError(t, ss.refreshManifest()) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				host := (g + i) % 10
+				sd := ss.ShouldTrace(&Request{Host: fmt.Sprintf("host%d", host)})
+				if host == 9 && *sd.Rule == defaultRule {
+					continue
+				}
+				assert.Equal(t, fmt.Sprintf("r%d", host), *sd.Rule)
+			}
+		}(g)
+	}
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			assert.NoError(t, ss.refreshManifest())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			ss.refreshTargets()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			ss.snapshots()
+			ss.ManifestSnapshot(SnapshotOptions{})
+		}
+	}()
+	wg.Wait()
+	ss.Flush(context.Background())
+
+	// the published rules are those of the manifest, sorted
+	ss.manifest.mu.RLock()
+	defer ss.manifest.mu.RUnlock()
+	rs := ss.manifest.load()
+	if assert.Len(t, rs.rules, len(ss.manifest.Rules)) {
+		for i, r := range ss.manifest.Rules {
+			assert.True(t, r == rs.rules[i].rule)
+		}
+	}
+	assert.True(t, ss.manifest.Default == rs.def)
+	assert.Equal(t, ss.manifest.refreshedAt, rs.refreshedAt)
+}
+
+// Assert that a rule for a service type matches requests of that service
+// type, such as the origin configured for segments, and that requests without
+// one match the first rule
+func TestShouldTraceServiceType(t *testing.T) {
+	ss := newRefreshTestStrategy()
+	ec2Type, eksType := "AWS::EC2::Instance", "AWS::EKS::Container"
+	ec2 := samplingRuleRecord("ec2", "", 1)
+	ec2.SamplingRule.ServiceType = &ec2Type
+	eks := samplingRuleRecord("eks", "", 2)
+	eks.SamplingRule.ServiceType = &eksType
+	ss.proxy.(*alternatingProxy).ruleSets = [][]*xraySvc.SamplingRuleRecord{{
+		ec2, eks, samplingRuleRecord(defaultRule, "", 10000),
+	}}
+	if !assert.NoError(t, ss.refreshManifest()) {
+		return
+	}
+
+	assert.Equal(t, "eks", *ss.ShouldTrace(&Request{ServiceName: "edge", ServiceType: "AWS::EKS::Container"}).Rule)
+	assert.Equal(t, "ec2", *ss.ShouldTrace(&Request{ServiceName: "edge", ServiceType: "AWS::EC2::Instance"}).Rule)
+	assert.Equal(t, defaultRule, *ss.ShouldTrace(&Request{ServiceName: "edge", ServiceType: "Kubernetes::Pod"}).Rule)
+}
+
 // Benchmarks
 func BenchmarkCentralizedStrategy_ShouldTrace(b *testing.B) {
 	s, _ := NewCentralizedStrategy()
@@ -2647,6 +2845,20 @@ func BenchmarkCentralizedStrategy_ShouldTrace(b *testing.B) {
 	})
 }
 
+func BenchmarkCentralizedStrategy_ShouldTraceRules(b *testing.B) {
+	ss := newRefreshTestStrategy()
+	if err := ss.refreshManifest(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		r := &Request{Host: "host8"}
+		for pb.Next() {
+			ss.ShouldTrace(r)
+		}
+	})
+}
+
 func BenchmarkNewCentralizedStrategy_refreshManifest(b *testing.B) {
 	serviceTye := ""
 	resourceARN := "*"
@@ -2763,3 +2975,35 @@ func BenchmarkCentralizedStrategy_refreshTargets(b *testing.B) {
 		}
 	})
 }
+
+// blockingProxy blocks GetSamplingRules until released.
+type blockingProxy struct {
+	mockProxy
+	release chan struct{}
+}
+
+func (p *blockingProxy) GetSamplingRules() ([]*xraySvc.SamplingRuleRecord, error) {
+	<-p.release
+	return p.mockProxy.GetSamplingRules()
+}
+
+func TestCentralizedStrategyFlush(t *testing.T) {
+	proxy := &blockingProxy{release: make(chan struct{})}
+	ss, err := NewCentralizedStrategy()
+	assert.Nil(t, err)
+	ss.proxy = proxy
+
+	// nothing in flight
+	assert.Nil(t, ss.Flush(context.Background()))
+
+	ss.goAsync(func() {
+		ss.refreshManifest()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, ss.Flush(ctx))
+
+	close(proxy.release)
+	assert.Nil(t, ss.Flush(context.Background()))
+}
@@ -0,0 +1,30 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+// FuncStrategy adapts a predicate into a Strategy.
+type FuncStrategy struct {
+	f func(*Request) bool
+}
+
+// NewFuncStrategy initializes a FuncStrategy sampling the requests f returns
+// true for. f must be safe for concurrent use.
+func NewFuncStrategy(f func(*Request) bool) *FuncStrategy {
+	return &FuncStrategy{f: f}
+}
+
+// ShouldTrace returns a decision sampling rq if the predicate returns true,
+// with DefaultRuleName as its rule.
+func (fs *FuncStrategy) ShouldTrace(rq *Request) *Decision {
+	rule := DefaultRuleName
+	return &Decision{
+		Sample: fs.f(rq),
+		Rule:   &rule,
+	}
+}
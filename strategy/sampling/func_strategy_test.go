@@ -0,0 +1,32 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuncStrategy(t *testing.T) {
+	ss := NewFuncStrategy(func(rq *Request) bool {
+		return strings.HasPrefix(rq.URL, "/admin")
+	})
+
+	sd := ss.ShouldTrace(&Request{URL: "/admin/users"})
+	assert.True(t, sd.Sample)
+	if assert.NotNil(t, sd.Rule) {
+		assert.Equal(t, DefaultRuleName, *sd.Rule)
+	}
+
+	sd = ss.ShouldTrace(&Request{URL: "/"})
+	assert.False(t, sd.Sample)
+	assert.NotNil(t, sd.Rule)
+}
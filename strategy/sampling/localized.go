@@ -9,10 +9,21 @@
 package sampling
 
 import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 	"github.com/aws/aws-xray-sdk-go/resources"
 )
 
+// DefaultReloadInterval is the interval the rules file of a strategy created
+// by NewLocalizedStrategyFromFilePathWithReload is read at if no interval is
+// given.
+const DefaultReloadInterval = 30 * time.Second
+
 // LocalizedStrategy makes trace sampling decisions based on
 // a set of rules provided in a local JSON file. Trace sampling
 // decisions are made by the root node in the trace. If a
@@ -20,6 +31,25 @@ import (
 // to downstream services through the trace header.
 type LocalizedStrategy struct {
 	manifest *RuleManifest
+
+	// rules last reloaded from the file manifest was read from, replacing
+	// it, see NewLocalizedStrategyFromFilePathWithReload
+	reloaded atomic.Pointer[reloadedManifest]
+
+	// cache of the rules matching requests, see EnableMatchCache
+	matchCache atomic.Pointer[matchCache]
+
+	// stops reloading the rules, see Close
+	stopReload chan struct{}
+	closeOnce  sync.Once
+}
+
+// reloadedManifest is a manifest reloaded from file, with the number of
+// times the file was reloaded so that previously matched rules are matched
+// again.
+type reloadedManifest struct {
+	manifest   *RuleManifest
+	generation uint64
 }
 
 // NewLocalizedStrategy initializes an instance of LocalizedStrategy
@@ -47,6 +77,85 @@ func NewLocalizedStrategyFromFilePath(fp string) (*LocalizedStrategy, error) {
 	return &LocalizedStrategy{manifest: manifest}, nil
 }
 
+// NewLocalizedStrategyFromFilePathWithReload initializes an instance of
+// LocalizedStrategy using the ruleset found at the filepath fp, like
+// NewLocalizedStrategyFromFilePath, and reads the file again at every
+// interval, or DefaultReloadInterval if interval is zero or less, e.g. for
+// rules in a mounted config file. When its content changed and is a valid
+// manifest, the rules are replaced; otherwise the error is logged and the
+// current rules are kept. Close stops reloading the file.
+func NewLocalizedStrategyFromFilePathWithReload(fp string, interval time.Duration) (*LocalizedStrategy, error) {
+	b, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := ManifestFromJSONBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+
+	lss := &LocalizedStrategy{manifest: manifest, stopReload: make(chan struct{})}
+	go lss.reload(fp, b, interval)
+	return lss, nil
+}
+
+// reload reads the rules file fp at every interval until the strategy is
+// closed, replacing the rules when the content of the file changed from last.
+func (lss *LocalizedStrategy) reload(fp string, last []byte, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var generation uint64
+	for {
+		select {
+		case <-lss.stopReload:
+			return
+		case <-ticker.C:
+		}
+
+		b, err := ioutil.ReadFile(fp)
+		if err != nil {
+			logger.Errorf("Error reading sampling rules from %s, keeping the current rules: %v", fp, err)
+			continue
+		}
+		// Unchanged rules are kept as is, along with the state of their
+		// reservoirs, and invalid ones are only logged once.
+		if bytes.Equal(b, last) {
+			continue
+		}
+		last = b
+		manifest, err := ManifestFromJSONBytes(b)
+		if err != nil {
+			logger.Errorf("Invalid sampling rules in %s, keeping the current rules: %v", fp, err)
+			continue
+		}
+
+		generation++
+		lss.reloaded.Store(&reloadedManifest{manifest: manifest, generation: generation})
+		logger.Infof("Reloaded %d sampling rules from %s", len(manifest.Rules), fp)
+	}
+}
+
+// Close stops reloading the rules file of a strategy created by
+// NewLocalizedStrategyFromFilePathWithReload. The rules last loaded are kept.
+func (lss *LocalizedStrategy) Close() error {
+	if lss.stopReload != nil {
+		lss.closeOnce.Do(func() { close(lss.stopReload) })
+	}
+	return nil
+}
+
+// rules returns the current rules of the strategy and their generation.
+func (lss *LocalizedStrategy) rules() (*RuleManifest, uint64) {
+	if r := lss.reloaded.Load(); r != nil {
+		return r.manifest, r.generation
+	}
+	return lss.manifest, 0
+}
+
 // NewLocalizedStrategyFromJSONBytes initializes an instance of
 // LocalizedStrategy using a custom ruleset provided in the json bytes b.
 func NewLocalizedStrategyFromJSONBytes(b []byte) (*LocalizedStrategy, error) {
@@ -57,18 +166,73 @@ func NewLocalizedStrategyFromJSONBytes(b []byte) (*LocalizedStrategy, error) {
 	return &LocalizedStrategy{manifest: manifest}, nil
 }
 
+// NewLocalizedStrategyFromRules initializes an instance of
+// LocalizedStrategy using the given rules, which are matched in order.
+// Requests not matching any of them are sampled by the default rule of
+// NewLocalizedStrategy.
+func NewLocalizedStrategyFromRules(rules []RuleSpec) (*LocalizedStrategy, error) {
+	return NewLocalizedStrategyFromRulesWithDefault(rules, defaultRuleSpec)
+}
+
+// NewLocalizedStrategyFromRulesWithDefault initializes an instance of
+// LocalizedStrategy using the given rules, and the default rule def for
+// requests not matching any of them. See ManifestFromRules.
+func NewLocalizedStrategyFromRulesWithDefault(rules []RuleSpec, def RuleSpec) (*LocalizedStrategy, error) {
+	manifest, err := ManifestFromRules(rules, def)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalizedStrategy{manifest: manifest}, nil
+}
+
 // ShouldTrace consults the LocalizedStrategy's rule set to determine
 // if the given request should be traced or not.
 func (lss *LocalizedStrategy) ShouldTrace(rq *Request) *Decision {
 	logger.Debugf("Determining ShouldTrace decision for:\n\thost: %s\n\tpath: %s\n\tmethod: %s", rq.Host, rq.URL, rq.Method)
-	if nil != lss.manifest.Rules {
-		for _, r := range lss.manifest.Rules {
-			if r.AppliesTo(rq.Host, rq.URL, rq.Method) {
-				logger.Debugf("Applicable rule:\n\tfixed_target: %d\n\trate: %f\n\thost: %s\n\turl_path: %s\n\thttp_method: %s", r.FixedTarget, r.Rate, r.Host, r.URLPath, r.HTTPMethod)
-				return r.Sample()
+	manifest, generation := lss.rules()
+	if i := lss.matchRule(manifest, generation, rq); i >= 0 {
+		r := manifest.Rules[i]
+		logger.Debugf("Applicable rule:\n\tdescription: %s\n\tfixed_target: %d\n\trate: %f\n\thost: %s\n\turl_path: %s\n\thttp_method: %s", r.Description, r.FixedTarget, r.Rate, r.Host, r.URLPath, r.HTTPMethod)
+		sd := r.Sample()
+		sd.Source = DecisionSourceLocal
+		return sd
+	}
+	logger.Debugf("Default rule applies:\n\tfixed_target: %d\n\trate: %f", manifest.Default.FixedTarget, manifest.Default.Rate)
+	sd := manifest.Default.Sample()
+	sd.Source = DecisionSourceLocal
+	return sd
+}
+
+// matchRule returns the index of the first rule of manifest applying to rq,
+// or -1 if none does, using the match cache if enabled. Requests with headers
+// aren't cached.
+func (lss *LocalizedStrategy) matchRule(manifest *RuleManifest, generation uint64, rq *Request) int {
+	match := func() int {
+		for i, r := range manifest.Rules {
+			if r.AppliesToRequest(rq) {
+				return i
 			}
 		}
+		return -1
+	}
+	c := lss.matchCache.Load()
+	if c == nil || len(rq.Headers) > 0 {
+		return match()
 	}
-	logger.Debugf("Default rule applies:\n\tfixed_target: %d\n\trate: %f", lss.manifest.Default.FixedTarget, lss.manifest.Default.Rate)
-	return lss.manifest.Default.Sample()
+	return c.match(newMatchKey(rq), generation, match)
+}
+
+// EnableMatchCache caches which rule matches the last size distinct
+// requests, by host, method and URL path, or the last DefaultMatchCacheSize
+// ones if size is zero or less. The decision to sample each request is still
+// made by its rule, so the cache only saves matching it against the rules.
+// Requests with headers aren't cached.
+func (lss *LocalizedStrategy) EnableMatchCache(size int) {
+	lss.matchCache.Store(newMatchCache(size))
+}
+
+// MatchCacheStats returns the hits and misses of the match cache, see
+// EnableMatchCache.
+func (lss *LocalizedStrategy) MatchCacheStats() MatchCacheStats {
+	return lss.matchCache.Load().stats()
 }
@@ -0,0 +1,124 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMatchCacheSize is the number of requests whose matching rule is
+// cached by EnableMatchCache if no size is given.
+const DefaultMatchCacheSize = 1024
+
+// MatchCacheStats counts the lookups of the match cache of a strategy, see
+// EnableMatchCache.
+type MatchCacheStats struct {
+	// Hits is the number of requests whose matching rule was cached.
+	Hits uint64
+
+	// Misses is the number of requests matched against the rules, because
+	// their matching rule wasn't cached or the rules changed since.
+	Misses uint64
+}
+
+// matchKey is a request as matched against the rules, lowercased since the
+// rules match case insensitively.
+type matchKey struct {
+	host        string
+	method      string
+	url         string
+	serviceName string
+	serviceType string
+}
+
+func newMatchKey(r *Request) matchKey {
+	return matchKey{
+		host:        strings.ToLower(r.Host),
+		method:      strings.ToLower(r.Method),
+		url:         strings.ToLower(r.URL),
+		serviceName: strings.ToLower(r.ServiceName),
+		serviceType: strings.ToLower(r.ServiceType),
+	}
+}
+
+type matchEntry struct {
+	key        matchKey
+	generation uint64
+	index      int
+}
+
+// matchCache is a bounded LRU cache of the index of the rule matching
+// requests, or -1 for the default rule. Each index is valid for the
+// generation of the rules it was matched against only.
+type matchCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[matchKey]*list.Element
+	lru     *list.List
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func newMatchCache(size int) *matchCache {
+	if size <= 0 {
+		size = DefaultMatchCacheSize
+	}
+	return &matchCache{
+		size:    size,
+		entries: make(map[matchKey]*list.Element, size),
+		lru:     list.New(),
+	}
+}
+
+// match returns the index of the rule matching key in the given generation
+// of the rules, calling match and caching its result if it isn't cached.
+func (c *matchCache) match(key matchKey, generation uint64, match func() int) int {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		if entry := e.Value.(*matchEntry); entry.generation == generation {
+			c.lru.MoveToFront(e)
+			c.mu.Unlock()
+			c.hits.Add(1)
+			return entry.index
+		}
+	}
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	index := match()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*matchEntry)
+		if entry.generation <= generation {
+			entry.generation, entry.index = generation, index
+		}
+		c.lru.MoveToFront(e)
+		return index
+	}
+	c.entries[key] = c.lru.PushFront(&matchEntry{key: key, generation: generation, index: index})
+	for c.lru.Len() > c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*matchEntry).key)
+	}
+	return index
+}
+
+func (c *matchCache) stats() MatchCacheStats {
+	if c == nil {
+		return MatchCacheStats{}
+	}
+	return MatchCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
@@ -0,0 +1,146 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"fmt"
+	"testing"
+
+	xraySvc "github.com/aws/aws-sdk-go/service/xray"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newMatchCache(2)
+	calls := 0
+	match := func(i int) func() int {
+		return func() int {
+			calls++
+			return i
+		}
+	}
+
+	a, b, d := matchKey{host: "a"}, matchKey{host: "b"}, matchKey{host: "d"}
+	assert.Equal(t, 1, c.match(a, 1, match(1)))
+	assert.Equal(t, 2, c.match(b, 1, match(2)))
+	assert.Equal(t, 1, c.match(a, 1, match(-1))) // a is now the most recently used
+	assert.Equal(t, 3, c.match(d, 1, match(3)))  // evicts b
+	assert.Equal(t, 3, calls)
+
+	assert.Equal(t, 1, c.match(a, 1, match(-1)))
+	assert.Equal(t, 2, c.match(b, 1, match(2)))
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, 2, c.lru.Len())
+	assert.Len(t, c.entries, 2)
+	assert.Equal(t, MatchCacheStats{Hits: 2, Misses: 4}, c.stats())
+
+	// entries of another generation are matched again
+	assert.Equal(t, 5, c.match(b, 2, match(5)))
+	assert.Equal(t, 5, c.match(b, 2, match(-1)))
+	assert.Equal(t, 5, calls)
+}
+
+func TestCentralizedMatchCacheInvalidatedByRuleUpdate(t *testing.T) {
+	ss := newRefreshTestStrategy()
+	ss.EnableMatchCache(0)
+
+	// the first refresh misses rule r9
+	if !assert.NoError(t, ss.refreshManifest()) {
+		return
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, defaultRule, *ss.ShouldTrace(&Request{Host: "host9"}).Rule)
+		assert.Equal(t, "r3", *ss.ShouldTrace(&Request{Host: "HOST3"}).Rule)
+	}
+	assert.Equal(t, MatchCacheStats{Hits: 4, Misses: 2}, ss.MatchCacheStats())
+
+	// the next refresh adds r9 back and reverses the priorities of the rules
+	if !assert.NoError(t, ss.refreshManifest()) {
+		return
+	}
+	assert.Equal(t, "r9", *ss.ShouldTrace(&Request{Host: "host9"}).Rule)
+	assert.Equal(t, "r3", *ss.ShouldTrace(&Request{Host: "host3"}).Rule)
+	assert.Equal(t, MatchCacheStats{Hits: 4, Misses: 4}, ss.MatchCacheStats())
+
+	// requests with attributes aren't cached
+	ss.ShouldTrace(&Request{Host: "host3", Attributes: map[string]string{"tenant": "a"}})
+	assert.Equal(t, MatchCacheStats{Hits: 4, Misses: 4}, ss.MatchCacheStats())
+}
+
+func TestLocalizedMatchCache(t *testing.T) {
+	ss, err := NewLocalizedStrategyFromRulesWithDefault([]RuleSpec{
+		{Host: "*.internal", URLPath: "/health*", FixedTarget: 0, Rate: 0},
+		{Method: "POST", FixedTarget: 0, Rate: 1},
+	}, RuleSpec{FixedTarget: 0, Rate: 0})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, MatchCacheStats{}, ss.MatchCacheStats())
+	ss.EnableMatchCache(10)
+
+	for i := 0; i < 2; i++ {
+		assert.False(t, ss.ShouldTrace(&Request{Host: "orders.internal", Method: "POST", URL: "/healthz"}).Sample)
+		assert.True(t, ss.ShouldTrace(&Request{Host: "orders.internal", Method: "post", URL: "/orders"}).Sample)
+		assert.False(t, ss.ShouldTrace(&Request{Host: "orders.internal", Method: "GET", URL: "/orders"}).Sample)
+	}
+	assert.Equal(t, MatchCacheStats{Hits: 3, Misses: 3}, ss.MatchCacheStats())
+}
+
+// newManyRulesTestStrategy returns a strategy with started pollers and n
+// rules, where rule i matches the hosts "svc<i>.*", and the hosts of the
+// requests matching the last 80 rules.
+func newManyRulesTestStrategy(tb testing.TB, n int) (*CentralizedStrategy, []string) {
+	ss := newRefreshTestStrategy()
+	var rules []*xraySvc.SamplingRuleRecord
+	for i := 0; i < n; i++ {
+		rules = append(rules, samplingRuleRecord(fmt.Sprintf("r%d", i), fmt.Sprintf("svc%d.*", i), int64(i+1)))
+	}
+	rules = append(rules, samplingRuleRecord(defaultRule, "", 10000))
+	ss.proxy.(*alternatingProxy).ruleSets = [][]*xraySvc.SamplingRuleRecord{rules}
+	if err := ss.refreshManifest(); err != nil {
+		tb.Fatal(err)
+	}
+
+	var hosts []string
+	for i := n - 80; i < n; i++ {
+		hosts = append(hosts, fmt.Sprintf("svc%d.internal.example.com", i))
+	}
+	return ss, hosts
+}
+
+func TestManyRulesTestStrategy(t *testing.T) {
+	ss, hosts := newManyRulesTestStrategy(t, 100)
+	ss.EnableMatchCache(0)
+	for _, host := range hosts {
+		assert.Equal(t, "r"+host[len("svc"):len("svc")+2], *ss.ShouldTrace(&Request{Host: host}).Rule)
+	}
+}
+
+func BenchmarkCentralizedStrategy_ShouldTrace100Rules(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf("cached=%v", cached), func(b *testing.B) {
+			ss, hosts := newManyRulesTestStrategy(b, 100)
+			if cached {
+				ss.EnableMatchCache(0)
+			}
+			requests := make([]Request, len(hosts))
+			for i, host := range hosts {
+				requests[i] = Request{Host: host, Method: "GET", URL: "/orders", ServiceName: "edge", ServiceType: "AWS::EC2::Instance"}
+			}
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					r := requests[i%len(requests)]
+					ss.ShouldTrace(&r)
+				}
+			})
+		})
+	}
+}
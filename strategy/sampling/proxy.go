@@ -9,7 +9,14 @@
 package sampling
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -19,20 +26,77 @@ import (
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 )
 
+// ClientOptions configures the client calling the sampling API of the X-Ray
+// daemon, e.g. when it's reached through a reverse proxy requiring TLS and
+// authentication. The address called still comes from the daemon endpoints,
+// see CentralizedStrategy.LoadDaemonEndpoints.
+type ClientOptions struct {
+	// HTTPClient sends the requests, e.g. with a transport trusting custom
+	// root CAs. It isn't used for daemons reached through a Unix domain
+	// socket. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Scheme is the URL scheme of the requests, http or https. Defaults to
+	// http.
+	Scheme string
+
+	// Headers are added to every request, e.g. an Authorization header.
+	Headers map[string]string
+}
+
+func (o ClientOptions) validate() error {
+	switch o.Scheme {
+	case "", "http", "https":
+		return nil
+	default:
+		return fmt.Errorf("sampling API scheme must be http or https: %q", o.Scheme)
+	}
+}
+
 // proxy is an implementation of svcProxy that forwards requests to the XRay daemon
 type proxy struct {
 	// XRay client for sending unsigned proxied requests to the daemon
 	xray *xraySvc.XRay
+
+	// URL of the daemon, for errors
+	url string
 }
 
 // NewProxy returns a Proxy
-func newProxy(d *daemoncfg.DaemonEndpoints) (svcProxy, error) {
+func newProxy(d *daemoncfg.DaemonEndpoints, log logger.Scoped, opts ClientOptions) (svcProxy, error) {
 
 	if d == nil {
-		d = daemoncfg.GetDaemonEndpoints()
+		var err error
+		if d, err = daemoncfg.GetDaemonEndpointsFromEnv(); err != nil {
+			return nil, err
+		}
+		if d == nil {
+			d = daemoncfg.GetDefaultDaemonEndpoints()
+		}
+	}
+	var url string
+	var httpClient *http.Client
+	if d.UnixAddr != nil {
+		log.Infof("X-Ray proxy using address : %v", d.UnixAddr.String())
+		// the host is ignored as requests are sent to the Unix domain socket
+		url = "http://xray-daemon"
+		path := d.UnixAddr.Name
+		httpClient = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", path)
+				},
+			},
+		}
+	} else {
+		log.Infof("X-Ray proxy using address : %v", d.TCPAddr.String())
+		scheme := opts.Scheme
+		if scheme == "" {
+			scheme = "http"
+		}
+		url = scheme + "://" + d.TCPAddr.String()
 	}
-	logger.Infof("X-Ray proxy using address : %v", d.TCPAddr.String())
-	url := "http://" + d.TCPAddr.String()
 
 	// Endpoint resolver for proxying requests through the daemon
 	f := func(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
@@ -42,11 +106,15 @@ func newProxy(d *daemoncfg.DaemonEndpoints) (svcProxy, error) {
 	}
 
 	// Dummy session for unsigned requests
-	sess, err := session.NewSession(&aws.Config{
+	cfg := &aws.Config{
 		Region:           aws.String("us-west-1"),
 		Credentials:      credentials.NewStaticCredentials("", "", ""),
 		EndpointResolver: endpoints.ResolverFunc(f),
-	})
+	}
+	if httpClient != nil {
+		cfg.HTTPClient = httpClient
+	}
+	sess, err := session.NewSession(cfg)
 
 	if err != nil {
 		return nil, err
@@ -54,13 +122,28 @@ func newProxy(d *daemoncfg.DaemonEndpoints) (svcProxy, error) {
 
 	x := xraySvc.New(sess)
 
+	// The client of the options is set once the session is created, so
+	// that its root CAs aren't replaced by those of AWS_CA_BUNDLE.
+	if d.UnixAddr == nil && opts.HTTPClient != nil {
+		x.Config.HTTPClient = opts.HTTPClient
+	}
+
 	// Remove Signer and replace with No-Op handler
 	x.Handlers.Sign.Clear()
 	x.Handlers.Sign.PushBack(func(*request.Request) {
 		// Do nothing
 	})
 
-	p := &proxy{xray: x}
+	if len(opts.Headers) > 0 {
+		headers := opts.Headers
+		x.Handlers.Build.PushBack(func(r *request.Request) {
+			for k, v := range headers {
+				r.HTTPRequest.Header.Set(k, v)
+			}
+		})
+	}
+
+	p := &proxy{xray: x, url: url}
 
 	return p, nil
 }
@@ -73,7 +156,7 @@ func (p *proxy) GetSamplingTargets(s []*xraySvc.SamplingStatisticsDocument) (*xr
 
 	output, err := p.xray.GetSamplingTargets(input)
 	if err != nil {
-		return nil, err
+		return nil, p.requestError(err)
 	}
 
 	return output, nil
@@ -85,10 +168,20 @@ func (p *proxy) GetSamplingRules() ([]*xraySvc.SamplingRuleRecord, error) {
 
 	output, err := p.xray.GetSamplingRules(input)
 	if err != nil {
-		return nil, err
+		return nil, p.requestError(err)
 	}
 
 	rules := output.SamplingRuleRecords
 
 	return rules, nil
 }
+
+// requestError adds the URL and the status code of failed requests to err,
+// e.g. to tell authentication failures of a reverse proxy apart.
+func (p *proxy) requestError(err error) error {
+	var rf awserr.RequestFailure
+	if errors.As(err, &rf) {
+		return fmt.Errorf("sampling API request to %s failed with status %d: %w", p.url, rf.StatusCode(), err)
+	}
+	return err
+}
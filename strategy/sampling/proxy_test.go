@@ -0,0 +1,110 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	xraySvc "github.com/aws/aws-sdk-go/service/xray"
+	"github.com/aws/aws-xray-sdk-go/daemoncfg"
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// newAuthenticatedDaemon returns a TLS server serving the sampling rules to
+// requests with the bearer token, and its daemon endpoints.
+func newAuthenticatedDaemon(t *testing.T) (*httptest.Server, *daemoncfg.DaemonEndpoints) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/GetSamplingRules":
+			w.Write([]byte(`{"SamplingRuleRecords":[{"SamplingRule":{"RuleName":"r1","Priority":1,"FixedRate":0.5,"ReservoirSize":5,"Host":"*","HTTPMethod":"*","URLPath":"*","ServiceName":"*","ServiceType":"*","ResourceARN":"*","Version":1}}]}`))
+		case "/SamplingTargets":
+			w.Write([]byte(`{"SamplingTargetDocuments":[],"UnprocessedStatistics":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	addr := server.Listener.Addr().(*net.TCPAddr)
+	return server, &daemoncfg.DaemonEndpoints{
+		UDPAddr: &net.UDPAddr{IP: addr.IP, Port: addr.Port},
+		TCPAddr: addr,
+	}
+}
+
+func TestProxyClientOptions(t *testing.T) {
+	server, d := newAuthenticatedDaemon(t)
+
+	p, err := newProxy(d, logger.With(nil), ClientOptions{
+		HTTPClient: server.Client(),
+		Scheme:     "https",
+		Headers:    map[string]string{"Authorization": "Bearer token"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rules, err := p.GetSamplingRules()
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, rules, 1) {
+		assert.Equal(t, "r1", *rules[0].SamplingRule.RuleName)
+	}
+
+	_, err = p.GetSamplingTargets([]*xraySvc.SamplingStatisticsDocument{})
+	assert.NoError(t, err)
+}
+
+func TestProxyClientOptionsMissingHeaders(t *testing.T) {
+	server, d := newAuthenticatedDaemon(t)
+
+	p, err := newProxy(d, logger.With(nil), ClientOptions{HTTPClient: server.Client(), Scheme: "https"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = p.GetSamplingRules()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "sampling API request to "+server.URL+" failed with status 401")
+	}
+}
+
+func TestCentralizedStrategyWithClientOptions(t *testing.T) {
+	server, d := newAuthenticatedDaemon(t)
+
+	ss, err := NewCentralizedStrategyWithClientOptions(ClientOptions{
+		HTTPClient: server.Client(),
+		Scheme:     "https",
+		Headers:    map[string]string{"Authorization": "Bearer token"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	ss.LoadDaemonEndpoints(d)
+
+	p, err := newProxy(ss.daemonEndpoints, ss.log(), ss.clientOptions)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ss.proxy = p
+	assert.NoError(t, ss.refreshManifest())
+	assert.Equal(t, "r1", ss.manifest.Rules[0].ruleName)
+
+	_, err = NewCentralizedStrategyWithClientOptions(ClientOptions{Scheme: "ftp"})
+	assert.Error(t, err)
+}
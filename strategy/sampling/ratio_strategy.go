@@ -0,0 +1,98 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-xray-sdk-go/utils"
+)
+
+// DefaultRuleName is the rule name reported by RatioStrategy and
+// FuncStrategy for requests not matching a more specific rule.
+const DefaultRuleName = "Default"
+
+// RatioStrategy samples a fixed ratio of requests, chosen by the longest
+// path prefix of the request URL found in its overrides. It is safe for
+// concurrent use.
+type RatioStrategy struct {
+	defaultRatio float64
+	prefixes     []string
+	ratios       map[string]float64
+
+	// Provides random numbers
+	rand utils.Rand
+}
+
+// NewRatioStrategy initializes a RatioStrategy sampling defaultRatio of the
+// requests, except for the URL path prefixes found in overrides which are
+// sampled at their own ratio. Ratios must be between 0 and 1.
+func NewRatioStrategy(defaultRatio float64, overrides map[string]float64) (*RatioStrategy, error) {
+	return NewRatioStrategyWithRand(defaultRatio, overrides, &utils.DefaultRand{})
+}
+
+// NewRatioStrategyWithRand initializes a RatioStrategy like NewRatioStrategy,
+// drawing random numbers from r. It is meant for tests using utils.MockRand.
+func NewRatioStrategyWithRand(defaultRatio float64, overrides map[string]float64, r utils.Rand) (*RatioStrategy, error) {
+	if err := checkRatio(defaultRatio); err != nil {
+		return nil, err
+	}
+
+	ratios := make(map[string]float64, len(overrides))
+	prefixes := make([]string, 0, len(overrides))
+	for prefix, ratio := range overrides {
+		if err := checkRatio(ratio); err != nil {
+			return nil, fmt.Errorf("override %q: %v", prefix, err)
+		}
+		ratios[prefix] = ratio
+		prefixes = append(prefixes, prefix)
+	}
+
+	// longest prefixes first, so that the most specific override applies
+	sort.Slice(prefixes, func(i, j int) bool {
+		if len(prefixes[i]) != len(prefixes[j]) {
+			return len(prefixes[i]) > len(prefixes[j])
+		}
+		return prefixes[i] < prefixes[j]
+	})
+
+	return &RatioStrategy{
+		defaultRatio: defaultRatio,
+		prefixes:     prefixes,
+		ratios:       ratios,
+		rand:         r,
+	}, nil
+}
+
+func checkRatio(ratio float64) error {
+	if !(ratio >= 0 && ratio <= 1) {
+		return fmt.Errorf("sampling ratio must be between 0 and 1, got %v", ratio)
+	}
+	return nil
+}
+
+// ShouldTrace samples the request at the ratio of the longest override
+// prefixing its URL, or at the default ratio. The decision's rule is the
+// matched prefix, or DefaultRuleName.
+func (rs *RatioStrategy) ShouldTrace(rq *Request) *Decision {
+	rule, ratio := DefaultRuleName, rs.defaultRatio
+	for _, prefix := range rs.prefixes {
+		if strings.HasPrefix(rq.URL, prefix) {
+			rule, ratio = prefix, rs.ratios[prefix]
+			break
+		}
+	}
+
+	return &Decision{
+		Sample: ratio > 0 && rs.rand.Float64() < ratio,
+		Rule:   &rule,
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRatioStrategy(t *testing.T) {
+	rand := &utils.MockRand{F64: 0.5}
+	ss, err := NewRatioStrategyWithRand(0.01, map[string]float64{
+		"/admin":        1,
+		"/admin/health": 0,
+		"/api":          0.6,
+	}, rand)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		url    string
+		sample bool
+		rule   string
+	}{
+		{"/", false, DefaultRuleName},
+		{"/admin/users", true, "/admin"},
+		{"/admin/health", false, "/admin/health"},
+		{"/api/orders", true, "/api"},
+		{"/ap", false, DefaultRuleName},
+	}
+	for _, tt := range tests {
+		sd := ss.ShouldTrace(&Request{URL: tt.url})
+		assert.Equal(t, tt.sample, sd.Sample, tt.url)
+		if assert.NotNil(t, sd.Rule, tt.url) {
+			assert.Equal(t, tt.rule, *sd.Rule, tt.url)
+		}
+	}
+
+	// the random number is compared against the ratio
+	rand.F64 = 0.7
+	assert.False(t, ss.ShouldTrace(&Request{URL: "/api/orders"}).Sample)
+	rand.F64 = 0
+	assert.True(t, ss.ShouldTrace(&Request{URL: "/"}).Sample)
+	assert.False(t, ss.ShouldTrace(&Request{URL: "/admin/health"}).Sample)
+}
+
+func TestNewRatioStrategyInvalidRatio(t *testing.T) {
+	for _, ratio := range []float64{-0.1, 1.1, math.NaN()} {
+		_, err := NewRatioStrategy(ratio, nil)
+		assert.Error(t, err)
+
+		_, err = NewRatioStrategy(0.5, map[string]float64{"/admin": ratio})
+		assert.Error(t, err)
+	}
+}
+
+func TestRatioStrategyOverridesCopied(t *testing.T) {
+	overrides := map[string]float64{"/admin": 1}
+	ss, err := NewRatioStrategyWithRand(0, overrides, &utils.MockRand{F64: 0.5})
+	if !assert.NoError(t, err) {
+		return
+	}
+	overrides["/admin"] = 0
+
+	assert.True(t, ss.ShouldTrace(&Request{URL: "/admin"}).Sample)
+}
+
+func TestRatioStrategyConcurrent(t *testing.T) {
+	ss, err := NewRatioStrategy(0.5, map[string]float64{"/admin": 1})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.True(t, ss.ShouldTrace(&Request{URL: "/admin"}).Sample)
+				ss.ShouldTrace(&Request{URL: "/"})
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -8,12 +8,20 @@
 
 package sampling
 
-import "github.com/aws/aws-xray-sdk-go/utils"
+import (
+	"sync"
+
+	"github.com/aws/aws-xray-sdk-go/utils"
+)
 
 // Reservoirs allow a specified (`perSecond`) amount of `Take()`s per second.
 
 // reservoir is a set of properties common to all reservoirs
 type reservoir struct {
+	// Guards used and currentEpoch, so that rolling over to a new epoch
+	// and consuming from it is a single atomic step.
+	mu sync.Mutex
+
 	// Total size of reservoir
 	capacity int64
 
@@ -38,9 +46,6 @@ type CentralizedReservoir struct {
 	// Polling interval for quota
 	interval int64
 
-	// True if reservoir has been borrowed from this epoch
-	borrowed bool
-
 	// Common reservoir properties
 	*reservoir
 }
@@ -50,23 +55,31 @@ func (r *CentralizedReservoir) expired(now int64) bool {
 	return now > r.expiresAt
 }
 
-// borrow returns true if the reservoir has not been borrowed from this epoch
+// borrow consumes the one request per second a rule without a valid quota
+// may sample, as the other X-Ray SDKs do, and returns true. It returns false
+// once a request was borrowed or taken from quota this epoch, so a quota
+// expiring mid-second doesn't sample more requests in that second, or if
+// the reservoir capacity of the rule is zero.
 func (r *CentralizedReservoir) borrow(now int64) bool {
-	if now != r.currentEpoch {
-		r.reset(now)
-	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	s := r.borrowed
-	r.borrowed = true
+	r.rollover(now)
+
+	if r.reservoir.capacity == 0 || r.used > 0 {
+		return false
+	}
+	r.used++
 
-	return !s && r.reservoir.capacity != 0
+	return true
 }
 
 // Take consumes quota from reservoir, if any remains, and returns true. False otherwise.
 func (r *CentralizedReservoir) Take(now int64) bool {
-	if now != r.currentEpoch {
-		r.reset(now)
-	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rollover(now)
 
 	// Consume from quota, if available
 	if r.quota > r.used {
@@ -78,8 +91,18 @@ func (r *CentralizedReservoir) Take(now int64) bool {
 	return false
 }
 
+// rollover resets the reservoir when now is past the current epoch. Callers
+// read the time before they are serialized, so an older now is treated as
+// part of the current epoch rather than starting that epoch over again.
+// The caller of rollover should hold r.mu.
+func (r *CentralizedReservoir) rollover(now int64) {
+	if now > r.currentEpoch {
+		r.reset(now)
+	}
+}
+
 func (r *CentralizedReservoir) reset(now int64) {
-	r.currentEpoch, r.used, r.borrowed = now, 0, false
+	r.currentEpoch, r.used = now, 0
 }
 
 // Reservoir is a reservoir local to the running instance of the SDK
@@ -92,6 +115,9 @@ type Reservoir struct {
 
 // Take attempts to consume a unit from the local reservoir. Returns true if unit taken, false otherwise.
 func (r *Reservoir) Take() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	// Reset counters if new second
 	if now := r.clock.Now().Unix(); now != r.currentEpoch {
 		r.used = 0
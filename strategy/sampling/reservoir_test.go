@@ -10,6 +10,8 @@ package sampling
 
 import (
 	"math"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/aws/aws-xray-sdk-go/utils"
@@ -249,3 +251,130 @@ func TestResetReservoirUsageRotation(t *testing.T) {
 	assert.Equal(t, true, taken)
 	assert.Equal(t, int64(1), r.used)
 }
+
+func TestTakeStaleEpoch(t *testing.T) {
+	r := &CentralizedReservoir{
+		quota: 2,
+		reservoir: &reservoir{
+			capacity:     100,
+			currentEpoch: 1500000000,
+		},
+	}
+
+	assert.True(t, r.Take(1500000001))
+	assert.True(t, r.Take(1500000001))
+
+	// A caller which read the time before the rollover must not reset the reservoir
+	assert.False(t, r.Take(1500000000))
+	assert.Equal(t, int64(1500000001), r.currentEpoch)
+	assert.Equal(t, int64(2), r.used)
+}
+
+// Asserts concurrent takes crossing epoch boundaries never consume more than quota per epoch
+func TestCentralizedReservoirConcurrentTake(t *testing.T) {
+	const (
+		quota      = 5
+		epochs     = 50
+		goroutines = 32
+		start      = int64(1500000000)
+	)
+	r := &CentralizedReservoir{
+		quota: quota,
+		reservoir: &reservoir{
+			capacity:     100,
+			currentEpoch: start - 1,
+		},
+	}
+
+	var taken int64
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			defer wg.Done()
+			for e := int64(0); e < epochs; e++ {
+				for i := 0; i < quota; i++ {
+					// Every other goroutine lags one epoch behind
+					now := start + e - int64(g%2)
+					if r.Take(now) {
+						atomic.AddInt64(&taken, 1)
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// Lagging goroutines also take from the epoch before start
+	assert.LessOrEqual(t, taken, int64(quota*(epochs+1)))
+}
+
+// Asserts concurrent takes across mocked epoch transitions never consume more than capacity per epoch
+func TestReservoirConcurrentTake(t *testing.T) {
+	const (
+		capacity   = 3
+		epochs     = 20
+		goroutines = 32
+	)
+	clock := &utils.MockClock{NowTime: 1500000000}
+	r := &Reservoir{
+		clock: clock,
+		reservoir: &reservoir{
+			capacity:     capacity,
+			currentEpoch: clock.Now().Unix(),
+		},
+	}
+
+	var taken int64
+	var done int32
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&done) == 0 {
+				if r.Take() {
+					atomic.AddInt64(&taken, 1)
+				}
+			}
+		}()
+	}
+	for e := 0; e < epochs; e++ {
+		clock.Increment(1, 0)
+	}
+	atomic.StoreInt32(&done, 1)
+	wg.Wait()
+
+	assert.LessOrEqual(t, taken, int64(capacity*(epochs+1)))
+}
+
+func BenchmarkReservoir_Take(b *testing.B) {
+	r := &Reservoir{
+		clock: &utils.DefaultClock{},
+		reservoir: &reservoir{
+			capacity: 100,
+		},
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Take()
+		}
+	})
+}
+
+func BenchmarkCentralizedReservoir_Take(b *testing.B) {
+	clock := &utils.DefaultClock{}
+	r := &CentralizedReservoir{
+		quota: 100,
+		reservoir: &reservoir{
+			capacity: 100,
+		},
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Take(clock.Now().Unix())
+		}
+	})
+}
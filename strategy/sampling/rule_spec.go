@@ -0,0 +1,106 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RuleSpec specifies a local sampling rule, for building rule manifests
+// without JSON. Host, Method and URLPath are wildcard patterns matched
+// case-insensitively; an empty pattern matches everything.
+type RuleSpec struct {
+	// Description of the rule, informational only.
+	Description string
+	Host        string
+	Method      string
+	URLPath     string
+
+	// Headers maps request header names to the wildcard patterns their
+	// values must match, see Request.Headers.
+	Headers map[string]string
+
+	// FixedTarget is the number of matching requests sampled per second,
+	// before Rate applies.
+	FixedTarget int64
+
+	// Rate is the ratio of matching requests sampled beyond FixedTarget,
+	// between 0 and 1.
+	Rate float64
+}
+
+// defaultRuleSpec matches the default rule of NewLocalizedStrategy.
+var defaultRuleSpec = RuleSpec{FixedTarget: 1, Rate: 0.05}
+
+// validate returns an error if the fixed target or rate of rs are invalid.
+func (rs RuleSpec) validate() error {
+	if rs.FixedTarget < 0 {
+		return errors.New("fixed target must not be negative")
+	}
+	if rs.Rate < 0 || rs.Rate > 1 {
+		return fmt.Errorf("rate %v must be between 0 and 1", rs.Rate)
+	}
+	return nil
+}
+
+func (rs RuleSpec) rule() *Rule {
+	return &Rule{
+		Description: rs.Description,
+		Properties: &Properties{
+			Host:        wildcardIfEmpty(rs.Host),
+			HTTPMethod:  wildcardIfEmpty(rs.Method),
+			URLPath:     wildcardIfEmpty(rs.URLPath),
+			Headers:     rs.Headers,
+			FixedTarget: rs.FixedTarget,
+			Rate:        rs.Rate,
+		},
+	}
+}
+
+func wildcardIfEmpty(p string) string {
+	if p == "" {
+		return "*"
+	}
+	return p
+}
+
+// ManifestFromRules creates a sampling ruleset from the given rules, which
+// are matched in order, and the default rule def applying to requests not
+// matching any of them. def must not specify a host, method or URL path.
+func ManifestFromRules(rules []RuleSpec, def RuleSpec) (*RuleManifest, error) {
+	if def.Host != "" || def.Method != "" || def.URLPath != "" || len(def.Headers) != 0 {
+		return nil, errors.New("the default rule must not specify values for host, method, url path or headers")
+	}
+	if err := def.validate(); err != nil {
+		return nil, fmt.Errorf("default sampling rule: %v", err)
+	}
+
+	m := &RuleManifest{
+		Version: 2,
+		Default: &Rule{
+			Description: def.Description,
+			Properties:  &Properties{FixedTarget: def.FixedTarget, Rate: def.Rate},
+		},
+		Rules: make([]*Rule, 0, len(rules)),
+	}
+	for i, rs := range rules {
+		if err := rs.validate(); err != nil {
+			return nil, fmt.Errorf("sampling rule %d: %v", i, err)
+		}
+		m.Rules = append(m.Rules, rs.rule())
+	}
+
+	if err := processManifest(m); err != nil {
+		return nil, err
+	}
+	initSamplingRules(m)
+
+	return m, nil
+}
@@ -12,8 +12,37 @@ package sampling
 type Decision struct {
 	Sample bool
 	Rule   *string
+
+	// Source is the kind of rule the decision was taken by. It is empty for
+	// strategies not based on sampling rules.
+	Source DecisionSource
 }
 
+// DecisionSource is the kind of rule, or other source, a sampling decision
+// was taken by.
+type DecisionSource string
+
+const (
+	// DecisionSourceRule is a centralized sampling rule matching the request.
+	DecisionSourceRule DecisionSource = "rule"
+
+	// DecisionSourceDefaultRule is the default centralized sampling rule,
+	// applied to requests matching no other rule.
+	DecisionSourceDefaultRule DecisionSource = "default_rule"
+
+	// DecisionSourceLocal is a local sampling rule of a LocalizedStrategy.
+	DecisionSourceLocal DecisionSource = "local"
+
+	// DecisionSourceFallback is a local sampling rule of the LocalizedStrategy
+	// CentralizedStrategy falls back to while centralized rules are
+	// unavailable.
+	DecisionSourceFallback DecisionSource = "fallback"
+
+	// DecisionSourceTraceHeader is the sampling decision propagated by the
+	// trace header of an incoming request. Strategies don't return it.
+	DecisionSourceTraceHeader DecisionSource = "trace_header"
+)
+
 // Request represents parameters used to make a sampling decision.
 type Request struct {
 	Host        string
@@ -21,4 +50,16 @@ type Request struct {
 	URL         string
 	ServiceName string
 	ServiceType string
+
+	// Attributes are matched against the Attributes of centralized sampling
+	// rules. A rule with Attributes only applies to requests carrying all of
+	// them, with values matching the wildcard patterns of the rule.
+	Attributes map[string]string
+
+	// Headers are matched against the headers of local sampling rules,
+	// keyed by canonical header name, as returned by
+	// http.CanonicalHeaderKey. A rule with headers only applies to requests
+	// carrying all of them, with values matching the wildcard patterns of
+	// the rule. Centralized sampling rules don't match headers.
+	Headers map[string]string
 }
@@ -9,7 +9,10 @@
 package sampling
 
 import (
+	"errors"
+	"net/textproto"
 	"sync"
+	"sync/atomic"
 
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 	"github.com/aws/aws-xray-sdk-go/pattern"
@@ -26,6 +29,10 @@ type Properties struct {
 	URLPath     string  `json:"url_path"`
 	FixedTarget int64   `json:"fixed_target"`
 	Rate        float64 `json:"rate"`
+
+	// Headers maps request header names to the wildcard patterns their
+	// values must match. Only local sampling rules specify headers.
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 // AppliesTo returns true if the sampling rule matches against given parameters. False Otherwise.
@@ -36,14 +43,95 @@ func (p *Properties) AppliesTo(host, path, method string) bool {
 		(method == "" || pattern.WildcardMatchCaseInsensitive(p.HTTPMethod, method))
 }
 
+// AppliesToRequest returns true if the sampling rule matches against the
+// host, URL path, method and headers of the given sampling request. False
+// otherwise.
+// Assumes lock is already held, if required.
+func (p *Properties) AppliesToRequest(request *Request) bool {
+	return p.AppliesTo(request.Host, request.URL, request.Method) && headersMatch(p.Headers, request.Headers)
+}
+
+// headersMatch returns true if every rule header is present in headers with
+// a value matching its pattern. False otherwise.
+func headersMatch(ruleHeaders map[string]string, headers map[string]string) bool {
+	for name, p := range ruleHeaders {
+		value, ok := headers[name]
+		if !ok || !pattern.WildcardMatchCaseInsensitive(p, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalHeaders returns headers keyed by canonical header name, or an
+// error if a header name is empty.
+func canonicalHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	canonical := make(map[string]string, len(headers))
+	for name, p := range headers {
+		if name == "" {
+			return nil, errors.New("sampling rule header names must not be empty")
+		}
+		canonical[textproto.CanonicalMIMEHeaderKey(name)] = p
+	}
+	return canonical, nil
+}
+
 // AppliesTo returns true if the sampling rule matches against given sampling request. False Otherwise.
 // Assumes lock is already held, if required.
 func (r *CentralizedRule) AppliesTo(request *Request) bool {
-	return (request.Host == "" || pattern.WildcardMatchCaseInsensitive(r.Host, request.Host)) &&
-		(request.URL == "" || pattern.WildcardMatchCaseInsensitive(r.URLPath, request.URL)) &&
-		(request.Method == "" || pattern.WildcardMatchCaseInsensitive(r.HTTPMethod, request.Method)) &&
-		(request.ServiceName == "" || pattern.WildcardMatchCaseInsensitive(r.ServiceName, request.ServiceName)) &&
-		(request.ServiceType == "" || pattern.WildcardMatchCaseInsensitive(r.serviceType, request.ServiceType))
+	m := r.matcher()
+	return m.appliesTo(request)
+}
+
+// ruleMatcher is a copy of the properties of a CentralizedRule matched
+// against requests, so that they can be matched without its lock.
+type ruleMatcher struct {
+	rule        *CentralizedRule
+	serviceName string
+	host        string
+	httpMethod  string
+	urlPath     string
+	serviceType string
+	attributes  map[string]*string
+}
+
+// matcher copies the properties of r matched against requests.
+// Assumes lock is already held, if required.
+func (r *CentralizedRule) matcher() ruleMatcher {
+	m := ruleMatcher{
+		rule:        r,
+		serviceType: r.serviceType,
+		attributes:  r.attributes,
+	}
+	if p := r.Properties; p != nil {
+		m.serviceName, m.host, m.httpMethod, m.urlPath = p.ServiceName, p.Host, p.HTTPMethod, p.URLPath
+	}
+	return m
+}
+
+// appliesTo returns true if the rule matches against given sampling request. False otherwise.
+func (m *ruleMatcher) appliesTo(request *Request) bool {
+	return (request.Host == "" || pattern.WildcardMatchCaseInsensitive(m.host, request.Host)) &&
+		(request.URL == "" || pattern.WildcardMatchCaseInsensitive(m.urlPath, request.URL)) &&
+		(request.Method == "" || pattern.WildcardMatchCaseInsensitive(m.httpMethod, request.Method)) &&
+		(request.ServiceName == "" || pattern.WildcardMatchCaseInsensitive(m.serviceName, request.ServiceName)) &&
+		(request.ServiceType == "" || pattern.WildcardMatchCaseInsensitive(m.serviceType, request.ServiceType)) &&
+		attributesMatch(m.attributes, request.Attributes)
+}
+
+// attributesMatch returns true if every rule attribute is present in
+// attributes with a value matching its pattern. False otherwise.
+func attributesMatch(ruleAttributes map[string]*string, attributes map[string]string) bool {
+	for key, p := range ruleAttributes {
+		value, ok := attributes[key]
+		if !ok || p == nil || !pattern.WildcardMatchCaseInsensitive(*p, value) {
+			return false
+		}
+	}
+	return true
 }
 
 // CentralizedRule represents a centralized sampling rule
@@ -58,13 +146,13 @@ type CentralizedRule struct {
 	priority int64
 
 	// Number of requests matched against this rule
-	requests int64
+	requests atomic.Int64
 
 	// Number of requests sampled using this rule
-	sampled int64
+	sampled atomic.Int64
 
 	// Number of requests burrowed
-	borrows int64
+	borrows atomic.Int64
 
 	// Timestamp for last match against this rule
 	usedAt int64
@@ -87,6 +175,8 @@ type CentralizedRule struct {
 	// Provides random numbers
 	rand utils.Rand
 
+	// Guards the properties and the targets of the rule, the statistics
+	// counters are updated atomically.
 	mu sync.RWMutex
 }
 
@@ -95,7 +185,7 @@ func (r *CentralizedRule) stale(now int64) bool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	return r.requests != 0 && now >= r.reservoir.refreshedAt+r.reservoir.interval
+	return r.requests.Load() != 0 && now >= r.reservoir.refreshedAt+r.reservoir.interval
 }
 
 // Sample returns true if the request should be sampled. False otherwise.
@@ -105,10 +195,10 @@ func (r *CentralizedRule) Sample() *Decision {
 		Rule: &r.ruleName,
 	}
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	r.requests.Add(1)
 
-	r.requests++
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
 	// Fallback to bernoulli sampling if quota has expired
 	if r.reservoir.expired(now) {
@@ -118,7 +208,7 @@ func (r *CentralizedRule) Sample() *Decision {
 				r.ruleName,
 			)
 			sd.Sample = true
-			r.borrows++
+			r.borrows.Add(1)
 
 			return sd
 		}
@@ -134,7 +224,7 @@ func (r *CentralizedRule) Sample() *Decision {
 
 	// Take from reservoir quota, if possible
 	if r.reservoir.Take(now) {
-		r.sampled++
+		r.sampled.Add(1)
 		sd.Sample = true
 
 		return sd
@@ -154,7 +244,7 @@ func (r *CentralizedRule) Sample() *Decision {
 // bernoulliSample uses bernoulli sampling rate to make a sampling decision
 func (r *CentralizedRule) bernoulliSample() bool {
 	if r.rand.Float64() < r.Rate {
-		r.sampled++
+		r.sampled.Add(1)
 
 		return true
 	}
@@ -165,18 +255,15 @@ func (r *CentralizedRule) bernoulliSample() bool {
 // snapshot takes a snapshot of the sampling statistics counters, returning
 // xraySvc.SamplingStatistics. It also resets statistics counters.
 func (r *CentralizedRule) snapshot() *xraySvc.SamplingStatisticsDocument {
-	r.mu.Lock()
-
 	name := &r.ruleName
 
-	// Copy statistics counters since xraySvc.SamplingStatistics expects
-	// pointers to counters, and ours are mutable.
-	requests, sampled, borrows := r.requests, r.sampled, r.borrows
-
-	// Reset counters
-	r.requests, r.sampled, r.borrows = 0, 0, 0
-
-	r.mu.Unlock()
+	// Reset counters, copying them since xraySvc.SamplingStatistics expects
+	// pointers to counters, and ours are mutable. Requests are counted before
+	// they are sampled or borrowed, so they are reset last to never report
+	// more sampled or borrowed requests than requests.
+	sampled := r.sampled.Swap(0)
+	borrows := r.borrows.Swap(0)
+	requests := r.requests.Swap(0)
 
 	now := r.clock.Now()
 	s := &xraySvc.SamplingStatisticsDocument{
@@ -192,6 +279,9 @@ func (r *CentralizedRule) snapshot() *xraySvc.SamplingStatisticsDocument {
 
 // Rule is local sampling rule.
 type Rule struct {
+	// Description of the rule, informational only.
+	Description string `json:"description,omitempty"`
+
 	reservoir *Reservoir
 
 	// Provides random numbers
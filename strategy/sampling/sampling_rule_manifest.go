@@ -88,7 +88,7 @@ func processManifest(srm *RuleManifest) error {
 	if srm == nil {
 		return errors.New("sampling rule manifest must not be nil")
 	}
-	if srm.Version != 1 && srm.Version != 2 {
+	if srm.Version < 1 || srm.Version > 3 {
 		return fmt.Errorf("sampling rule manifest version %d not supported", srm.Version)
 	}
 	if srm.Default == nil {
@@ -97,6 +97,9 @@ func processManifest(srm *RuleManifest) error {
 	if srm.Default.URLPath != "" || srm.Default.ServiceName != "" || srm.Default.HTTPMethod != "" {
 		return errors.New("the default rule must not specify values for url_path, service_name, or http_method")
 	}
+	if len(srm.Default.Headers) != 0 {
+		return errors.New("the default rule must not specify headers")
+	}
 	if srm.Default.FixedTarget < 0 || srm.Default.Rate < 0 {
 		return errors.New("the default rule must specify non-negative values for fixed_target and rate")
 	}
@@ -121,12 +124,19 @@ func processManifest(srm *RuleManifest) error {
 				r.ServiceName = ""
 			}
 
-			if srm.Version == 2 {
+			// Version 3 rules are version 2 rules with a description
+			if srm.Version >= 2 {
 				if err := validateVersion2(r); err != nil {
 					return err
 				}
 			}
 
+			headers, err := canonicalHeaders(r.Headers)
+			if err != nil {
+				return err
+			}
+			r.Headers = headers
+
 			r.reservoir = &Reservoir{
 				clock: c,
 				reservoir: &reservoir{
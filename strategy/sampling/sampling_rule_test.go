@@ -18,12 +18,12 @@ import (
 
 func TestStaleRule(t *testing.T) {
 	cr := &CentralizedRule{
-		requests: 5,
 		reservoir: &CentralizedReservoir{
 			refreshedAt: 1500000000,
 			interval:    10,
 		},
 	}
+	cr.requests.Store(5)
 
 	s := cr.stale(1500000010)
 	assert.True(t, s)
@@ -31,12 +31,12 @@ func TestStaleRule(t *testing.T) {
 
 func TestFreshRule(t *testing.T) {
 	cr := &CentralizedRule{
-		requests: 5,
 		reservoir: &CentralizedReservoir{
 			refreshedAt: 1500000000,
 			interval:    10,
 		},
 	}
+	cr.requests.Store(5)
 
 	s := cr.stale(1500000009)
 	assert.False(t, s)
@@ -44,7 +44,6 @@ func TestFreshRule(t *testing.T) {
 
 func TestInactiveRule(t *testing.T) {
 	cr := &CentralizedRule{
-		requests: 0,
 		reservoir: &CentralizedReservoir{
 			refreshedAt: 1500000000,
 			interval:    10,
@@ -73,9 +72,9 @@ func TestExpiredReservoirBernoulliSample(t *testing.T) {
 	// Expired reservoir
 	cr := &CentralizedReservoir{
 		expiresAt: 1500000060,
-		borrowed:  true,
 		reservoir: &reservoir{
-			used:         0,
+			// borrowed this second
+			used:         1,
 			capacity:     10,
 			currentEpoch: 1500000061,
 		},
@@ -93,8 +92,8 @@ func TestExpiredReservoirBernoulliSample(t *testing.T) {
 
 	assert.True(t, sd.Sample)
 	assert.Equal(t, "r1", *sd.Rule)
-	assert.Equal(t, int64(1), csr.sampled)
-	assert.Equal(t, int64(1), csr.requests)
+	assert.Equal(t, int64(1), csr.sampled.Load())
+	assert.Equal(t, int64(1), csr.requests.Load())
 }
 
 func TestTakeFromQuotaSample(t *testing.T) {
@@ -124,8 +123,8 @@ func TestTakeFromQuotaSample(t *testing.T) {
 
 	assert.True(t, sd.Sample)
 	assert.Equal(t, "r1", *sd.Rule)
-	assert.Equal(t, int64(1), csr.sampled)
-	assert.Equal(t, int64(1), csr.requests)
+	assert.Equal(t, int64(1), csr.sampled.Load())
+	assert.Equal(t, int64(1), csr.requests.Load())
 	assert.Equal(t, int64(1), csr.reservoir.used)
 }
 
@@ -167,8 +166,8 @@ func TestBernoulliSamplePositve(t *testing.T) {
 
 	assert.True(t, sd.Sample)
 	assert.Equal(t, "r1", *sd.Rule)
-	assert.Equal(t, int64(1), csr.sampled)
-	assert.Equal(t, int64(1), csr.requests)
+	assert.Equal(t, int64(1), csr.sampled.Load())
+	assert.Equal(t, int64(1), csr.requests.Load())
 	assert.Equal(t, int64(10), csr.reservoir.used)
 }
 
@@ -210,8 +209,8 @@ func TestBernoulliSampleNegative(t *testing.T) {
 
 	assert.False(t, sd.Sample)
 	assert.Equal(t, "r1", *sd.Rule)
-	assert.Equal(t, int64(0), csr.sampled)
-	assert.Equal(t, int64(1), csr.requests)
+	assert.Equal(t, int64(0), csr.sampled.Load())
+	assert.Equal(t, int64(1), csr.requests.Load())
 	assert.Equal(t, int64(10), csr.reservoir.used)
 }
 
@@ -290,18 +289,18 @@ func TestSnapshot(t *testing.T) {
 
 	csr := &CentralizedRule{
 		ruleName: "rule1",
-		requests: 100,
-		sampled:  12,
-		borrows:  2,
 		clock:    clock,
 	}
+	csr.requests.Store(100)
+	csr.sampled.Store(12)
+	csr.borrows.Store(2)
 
 	ss := csr.snapshot()
 
 	// Assert counters were reset
-	assert.Equal(t, int64(0), csr.requests)
-	assert.Equal(t, int64(0), csr.sampled)
-	assert.Equal(t, int64(0), csr.borrows)
+	assert.Equal(t, int64(0), csr.requests.Load())
+	assert.Equal(t, int64(0), csr.sampled.Load())
+	assert.Equal(t, int64(0), csr.borrows.Load())
 
 	// Assert on SamplingStatistics counters
 	now := time.Unix(1500000000, 0)
@@ -313,6 +312,215 @@ func TestSnapshot(t *testing.T) {
 	assert.Equal(t, now, *ss.Timestamp)
 }
 
+func TestAppliesToAttributes(t *testing.T) {
+	tier, region, anyValue := "premium", "eu-?", "*"
+
+	tests := []struct {
+		name       string
+		attributes map[string]*string
+		properties Properties
+		request    *Request
+		applies    bool
+	}{
+		{
+			name:       "exact match",
+			attributes: map[string]*string{"tier": &tier},
+			request:    &Request{Attributes: map[string]string{"tier": "premium"}},
+			applies:    true,
+		},
+		{
+			name:       "exact mismatch",
+			attributes: map[string]*string{"tier": &tier},
+			request:    &Request{Attributes: map[string]string{"tier": "basic"}},
+		},
+		{
+			name:       "* wildcard",
+			attributes: map[string]*string{"tier": &anyValue},
+			request:    &Request{Attributes: map[string]string{"tier": "basic"}},
+			applies:    true,
+		},
+		{
+			name:       "? wildcard",
+			attributes: map[string]*string{"region": &region},
+			request:    &Request{Attributes: map[string]string{"region": "eu-1"}},
+			applies:    true,
+		},
+		{
+			name:       "? wildcard mismatch",
+			attributes: map[string]*string{"region": &region},
+			request:    &Request{Attributes: map[string]string{"region": "eu-12"}},
+		},
+		{
+			name:       "missing request attribute",
+			attributes: map[string]*string{"tier": &anyValue},
+			request:    &Request{Attributes: map[string]string{"region": "eu-1"}},
+		},
+		{
+			name:       "no request attributes",
+			attributes: map[string]*string{"tier": &anyValue},
+			request:    &Request{},
+		},
+		{
+			name:    "no rule attributes",
+			request: &Request{Attributes: map[string]string{"tier": "premium"}},
+			applies: true,
+		},
+		{
+			name:       "host, path and attributes match",
+			attributes: map[string]*string{"tier": &tier, "region": &region},
+			properties: Properties{Host: "*.example.com", URLPath: "/api/*", HTTPMethod: "*"},
+			request: &Request{
+				Host:       "www.example.com",
+				URL:        "/api/orders",
+				Method:     "GET",
+				Attributes: map[string]string{"tier": "premium", "region": "eu-2"},
+			},
+			applies: true,
+		},
+		{
+			name:       "path mismatch with matching attributes",
+			attributes: map[string]*string{"tier": &tier},
+			properties: Properties{Host: "*.example.com", URLPath: "/api/*", HTTPMethod: "*"},
+			request: &Request{
+				Host:       "www.example.com",
+				URL:        "/health",
+				Method:     "GET",
+				Attributes: map[string]string{"tier": "premium"},
+			},
+		},
+		{
+			name:       "host match with one mismatched attribute",
+			attributes: map[string]*string{"tier": &tier, "region": &region},
+			properties: Properties{Host: "*.example.com", URLPath: "*", HTTPMethod: "*"},
+			request: &Request{
+				Host:       "www.example.com",
+				URL:        "/api/orders",
+				Method:     "GET",
+				Attributes: map[string]string{"tier": "premium", "region": "us-1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			properties := tt.properties
+			r := &CentralizedRule{
+				Properties: &properties,
+				attributes: tt.attributes,
+			}
+
+			assert.Equal(t, tt.applies, r.AppliesTo(tt.request))
+		})
+	}
+}
+
+// sampleN samples n requests with csr and returns how many were sampled.
+func sampleN(csr *CentralizedRule, n int) int {
+	sampled := 0
+	for i := 0; i < n; i++ {
+		if csr.Sample().Sample {
+			sampled++
+		}
+	}
+	return sampled
+}
+
+// Assert that a rule without quota borrows one request per second, and
+// falls back to the fixed rate for the following requests.
+func TestBorrowBeforeQuotaAssignment(t *testing.T) {
+	clock := &utils.MockClock{
+		NowTime: 1500000000,
+	}
+
+	// Outside of the sampling rate
+	rand := &utils.MockRand{
+		F64: 0.5,
+	}
+
+	// Reservoir which never received a quota
+	csr := &CentralizedRule{
+		ruleName: "r1",
+		reservoir: &CentralizedReservoir{
+			reservoir: &reservoir{
+				capacity: 10,
+			},
+		},
+		Properties: &Properties{
+			Rate: 0.05,
+		},
+		clock: clock,
+		rand:  rand,
+	}
+
+	assert.Equal(t, 1, sampleN(csr, 5))
+
+	// Borrow again in the next second, after a few milliseconds
+	clock.Increment(1, 5e6)
+	assert.Equal(t, 1, sampleN(csr, 3))
+
+	// The clock going backwards doesn't allow borrowing again
+	clock.Increment(-1, 0)
+	assert.Equal(t, 0, sampleN(csr, 2))
+	clock.Increment(1, 0)
+	assert.Equal(t, 0, sampleN(csr, 2))
+
+	clock.Increment(1, 0)
+	assert.Equal(t, 1, sampleN(csr, 1))
+
+	assert.Equal(t, int64(13), csr.requests.Load())
+	assert.Equal(t, int64(0), csr.sampled.Load())
+	assert.Equal(t, int64(3), csr.borrows.Load())
+
+	s := csr.snapshot()
+	assert.Equal(t, int64(13), *s.RequestCount)
+	assert.Equal(t, int64(0), *s.SampledCount)
+	assert.Equal(t, int64(3), *s.BorrowCount)
+}
+
+// Assert that a quota expiring mid-second doesn't allow borrowing a request
+// in a second quota was taken from, and that borrowing resumes afterwards.
+func TestBorrowAfterQuotaExpiry(t *testing.T) {
+	clock := &utils.MockClock{
+		NowTime: 1500000000,
+	}
+
+	// Outside of the sampling rate
+	rand := &utils.MockRand{
+		F64: 0.5,
+	}
+
+	cr := &CentralizedReservoir{
+		quota:     2,
+		expiresAt: 1500000010,
+		reservoir: &reservoir{
+			capacity: 10,
+		},
+	}
+	csr := &CentralizedRule{
+		ruleName:  "r1",
+		reservoir: cr,
+		Properties: &Properties{
+			Rate: 0.05,
+		},
+		clock: clock,
+		rand:  rand,
+	}
+
+	assert.Equal(t, 2, sampleN(csr, 4))
+
+	// The quota expires within the same second
+	clock.Increment(0, 5e8)
+	cr.expiresAt = 1499999999
+	assert.Equal(t, 0, sampleN(csr, 3))
+
+	clock.Increment(1, 0)
+	assert.Equal(t, 1, sampleN(csr, 3))
+
+	assert.Equal(t, int64(10), csr.requests.Load())
+	assert.Equal(t, int64(2), csr.sampled.Load())
+	assert.Equal(t, int64(1), csr.borrows.Load())
+}
+
 // Benchmarks
 func BenchmarkCentralizedRule_Sample(b *testing.B) {
 
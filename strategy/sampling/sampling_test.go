@@ -9,8 +9,10 @@
 package sampling
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -79,6 +81,78 @@ func TestNewLocalizedStrategyFromFilePathInvalidRulesV2(t *testing.T) { // V2 co
 	assert.NotNil(t, err)
 }
 
+func TestNewLocalizedStrategyFromFilePath3(t *testing.T) { // V3 sampling
+	testFile, err := filepath.Abs(filepath.Join("testdata", "rule-v3-sampling.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := NewLocalizedStrategyFromFilePath(testFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 3, ss.manifest.Version)
+	assert.Equal(t, 1, len(ss.manifest.Rules))
+	assert.Equal(t, "Checkout page, sampled more for the launch.", ss.manifest.Rules[0].Description)
+	assert.Equal(t, "/checkout", ss.manifest.Rules[0].URLPath)
+	assert.Equal(t, "Samples the first request per second, and 5% of the others.", ss.manifest.Default.Description)
+
+	_, err = ManifestFromJSONBytes([]byte(`{"version": 4, "default": {"fixed_target": 1, "rate": 0.05}}`))
+	assert.Error(t, err)
+}
+
+func writeRulesFile(t *testing.T, fp string, rate string) {
+	rules := `{
+	  "version": 3,
+	  "default": {"fixed_target": 0, "rate": 0},
+	  "rules": [
+	    {"description": "orders", "host": "*", "http_method": "*", "url_path": "/orders", "fixed_target": 0, "rate": ` + rate + `}
+	  ]
+	}`
+	if err := os.WriteFile(fp, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewLocalizedStrategyFromFilePathWithReload(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "sampling-rules.json")
+	writeRulesFile(t, fp, "0")
+
+	ss, err := NewLocalizedStrategyFromFilePathWithReload(fp, 10*time.Millisecond)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ss.Close()
+	ss.EnableMatchCache(0)
+
+	orders := &Request{Host: "example.com", Method: "GET", URL: "/orders"}
+	assert.False(t, ss.ShouldTrace(orders).Sample)
+
+	writeRulesFile(t, fp, "1")
+	assert.Eventually(t, func() bool { return ss.ShouldTrace(orders).Sample }, time.Second, 5*time.Millisecond)
+
+	// invalid rules are logged and the current ones kept
+	if err := os.WriteFile(fp, []byte(`{"version": 3, "rules": [`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, ss.ShouldTrace(orders).Sample)
+
+	writeRulesFile(t, fp, "0")
+	assert.Eventually(t, func() bool { return !ss.ShouldTrace(orders).Sample }, time.Second, 5*time.Millisecond)
+	assert.NoError(t, ss.Close())
+	assert.NoError(t, ss.Close())
+}
+
+func TestNewLocalizedStrategyFromFilePathWithReloadInvalid(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "sampling-rules.json")
+	if err := os.WriteFile(fp, []byte(`{"version": 3, "default": {"fixed_target": -1, "rate": 0}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ss, err := NewLocalizedStrategyFromFilePathWithReload(fp, time.Second)
+	assert.Nil(t, ss)
+	assert.Error(t, err)
+}
+
 func TestNewLocalizedStrategyFromFilePathWithInvalidJSON(t *testing.T) { // Test V1 sampling rule
 	testFile, err := filepath.Abs(filepath.Join("testdata", "rule-v1-invalid.json"))
 	if err != nil {
@@ -119,6 +193,150 @@ func TestNewLocalizedStrategyFromInvalidJSONBytes(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestNewLocalizedStrategyFromRulesHealthCheckExclusion(t *testing.T) {
+	ss, err := NewLocalizedStrategyFromRules([]RuleSpec{
+		{Description: "health checks", Host: "*.internal", Method: "GET", URLPath: "/health*", FixedTarget: 0, Rate: 0},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// host and method are matched case-insensitively
+	for _, rq := range []*Request{
+		{Host: "orders.internal", Method: "GET", URL: "/health"},
+		{Host: "orders.INTERNAL", Method: "get", URL: "/healthz"},
+		{Host: "Orders.Internal", Method: "Get", URL: "/health/ready"},
+	} {
+		for i := 0; i < 3; i++ {
+			assert.False(t, ss.ShouldTrace(rq).Sample, rq.Host+" "+rq.Method+" "+rq.URL)
+		}
+	}
+
+	// other requests fall through to the default rule, sampling the first
+	// request per second
+	assert.True(t, ss.ShouldTrace(&Request{Host: "orders.internal", Method: "POST", URL: "/health"}).Sample)
+}
+
+func TestNewLocalizedStrategyFromRulesEmptyPatterns(t *testing.T) {
+	ss, err := NewLocalizedStrategyFromRulesWithDefault([]RuleSpec{
+		{URLPath: "/admin*", FixedTarget: 0, Rate: 1},
+	}, RuleSpec{FixedTarget: 0, Rate: 0})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, ss.ShouldTrace(&Request{Host: "example.com", Method: "DELETE", URL: "/admin/users"}).Sample)
+	assert.False(t, ss.ShouldTrace(&Request{Host: "example.com", Method: "GET", URL: "/"}).Sample)
+}
+
+func TestManifestFromRules(t *testing.T) {
+	m, err := ManifestFromRules([]RuleSpec{
+		{Description: "api", Host: "api.example.com", Method: "POST", URLPath: "/orders", FixedTarget: 5, Rate: 0.5},
+	}, RuleSpec{Description: "default", FixedTarget: 2, Rate: 0.1})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 2, m.Version)
+	assert.Equal(t, &Properties{FixedTarget: 2, Rate: 0.1}, m.Default.Properties)
+	if assert.Len(t, m.Rules, 1) {
+		assert.Equal(t, "api", m.Rules[0].Description)
+		assert.Equal(t, &Properties{Host: "api.example.com", HTTPMethod: "POST", URLPath: "/orders", FixedTarget: 5, Rate: 0.5}, m.Rules[0].Properties)
+		assert.Equal(t, int64(5), m.Rules[0].reservoir.capacity)
+	}
+}
+
+func TestManifestFromInvalidRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []RuleSpec
+		def   RuleSpec
+	}{
+		{"negative rate", []RuleSpec{{Rate: -0.1}}, defaultRuleSpec},
+		{"rate above 1", []RuleSpec{{Rate: 1.5}}, defaultRuleSpec},
+		{"negative fixed target", []RuleSpec{{FixedTarget: -1}}, defaultRuleSpec},
+		{"invalid default rate", nil, RuleSpec{Rate: 2}},
+		{"default with host", nil, RuleSpec{Host: "*", Rate: 0.05}},
+		{"default with headers", nil, RuleSpec{Headers: map[string]string{"x-api-tier": "free"}, Rate: 0.05}},
+		{"empty header name", []RuleSpec{{Headers: map[string]string{"": "free"}}}, defaultRuleSpec},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ManifestFromRules(tt.rules, tt.def)
+			assert.Nil(t, m)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewLocalizedStrategyFromJSONBytesWithHeaders(t *testing.T) {
+	ruleBytes := []byte(`{
+	  "version": 2,
+	  "default": {
+	    "fixed_target": 0,
+	    "rate": 1
+	  },
+	  "rules": [
+	    {
+	      "host": "*",
+	      "http_method": "*",
+	      "url_path": "/api/*",
+	      "headers": {"x-api-tier": "free*"},
+	      "fixed_target": 0,
+	      "rate": 0
+	    }
+	  ]
+	}`)
+	ss, err := NewLocalizedStrategyFromJSONBytes(ruleBytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]string{"X-Api-Tier": "free*"}, ss.manifest.Rules[0].Headers)
+
+	free := &Request{Host: "example.com", Method: "GET", URL: "/api/orders", Headers: map[string]string{"X-Api-Tier": "Free-Trial"}}
+	assert.False(t, ss.ShouldTrace(free).Sample)
+
+	// requests with other header values, or without the header, fall through
+	// to the default rule
+	paid := &Request{Host: "example.com", Method: "GET", URL: "/api/orders", Headers: map[string]string{"X-Api-Tier": "paid"}}
+	assert.True(t, ss.ShouldTrace(paid).Sample)
+	assert.True(t, ss.ShouldTrace(&Request{Host: "example.com", Method: "GET", URL: "/api/orders"}).Sample)
+}
+
+func TestNewLocalizedStrategyFromJSONBytesWithInvalidHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{"empty header name", `{"version": 2, "default": {"fixed_target": 1, "rate": 0.05}, "rules": [
+		  {"host": "*", "http_method": "*", "url_path": "*", "headers": {"": "free"}, "fixed_target": 0, "rate": 0}]}`},
+		{"header value not a string", `{"version": 2, "default": {"fixed_target": 1, "rate": 0.05}, "rules": [
+		  {"host": "*", "http_method": "*", "url_path": "*", "headers": {"x-api-tier": 1}, "fixed_target": 0, "rate": 0}]}`},
+		{"default with headers", `{"version": 2, "default": {"headers": {"x-api-tier": "free"}, "fixed_target": 1, "rate": 0.05}, "rules": []}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss, err := NewLocalizedStrategyFromJSONBytes([]byte(tt.manifest))
+			assert.Nil(t, ss)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewLocalizedStrategyFromRulesWithHeaders(t *testing.T) {
+	ss, err := NewLocalizedStrategyFromRulesWithDefault([]RuleSpec{
+		{Headers: map[string]string{"x-api-tier": "paid"}, FixedTarget: 0, Rate: 1},
+	}, RuleSpec{FixedTarget: 0, Rate: 0})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, ss.ShouldTrace(&Request{URL: "/", Headers: map[string]string{"X-Api-Tier": "PAID"}}).Sample)
+	assert.False(t, ss.ShouldTrace(&Request{URL: "/", Headers: map[string]string{"X-Api-Tier": "free"}}).Sample)
+}
+
 // Benchmarks
 func BenchmarkNewLocalizedStrategyFromJSONBytes(b *testing.B) {
 	ruleBytes := []byte(`{
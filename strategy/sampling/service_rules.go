@@ -0,0 +1,195 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	xraySvc "github.com/aws/aws-sdk-go/service/xray"
+	"github.com/aws/aws-xray-sdk-go/strategy/exception"
+)
+
+// FromServiceRules converts centralized sampling rules, as returned by the
+// X-Ray GetSamplingRules API, into a version 2 local sampling rule manifest,
+// so that centralized rules can be snapshotted for environments without
+// access to X-Ray.
+//
+// Local rules only match on host, HTTP method and URL path. Rules which
+// depend on other features, such as a service name, service type, resource
+// ARN or attributes, cannot be expressed and are skipped. Each skipped rule
+// is reported in the returned error, along with the manifest built from the
+// remaining rules. A nil manifest is returned if there is no default rule.
+func FromServiceRules(records []*xraySvc.SamplingRuleRecord) (*RuleManifest, error) {
+	var errs exception.MultiError
+
+	type prioritized struct {
+		priority int64
+		name     string
+		rule     *Rule
+	}
+	var rules []prioritized
+	var def *Rule
+
+	for _, record := range records {
+		if record == nil || record.SamplingRule == nil {
+			errs = append(errs, errors.New("sampling rule missing from sampling rule record"))
+			continue
+		}
+		svcRule := record.SamplingRule
+		if svcRule.RuleName == nil {
+			errs = append(errs, errors.New("sampling rule without rule name is not supported"))
+			continue
+		}
+		name := *svcRule.RuleName
+
+		if err := checkServiceRule(svcRule); err != nil {
+			errs = append(errs, fmt.Errorf("skipping sampling rule %s: %v", name, err))
+			continue
+		}
+
+		r := &Rule{
+			Description: name,
+			Properties: &Properties{
+				FixedTarget: *svcRule.ReservoirSize,
+				Rate:        *svcRule.FixedRate,
+			},
+		}
+		if name == defaultRule {
+			def = r
+			continue
+		}
+		r.Host = *svcRule.Host
+		r.HTTPMethod = *svcRule.HTTPMethod
+		r.URLPath = *svcRule.URLPath
+		rules = append(rules, prioritized{priority: *svcRule.Priority, name: name, rule: r})
+	}
+
+	if def == nil {
+		errs = append(errs, errors.New("sampling rules must include a default rule"))
+		return nil, errs
+	}
+
+	// Local rules are evaluated in order, centralized rules by priority.
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].priority == rules[j].priority {
+			return rules[i].name < rules[j].name
+		}
+		return rules[i].priority < rules[j].priority
+	})
+
+	m := &RuleManifest{
+		Version: 2,
+		Default: def,
+		Rules:   make([]*Rule, 0, len(rules)),
+	}
+	for _, r := range rules {
+		m.Rules = append(m.Rules, r.rule)
+	}
+
+	if err := processManifest(m); err != nil {
+		return nil, err
+	}
+	initSamplingRules(m)
+
+	if len(errs) > 0 {
+		return m, errs
+	}
+	return m, nil
+}
+
+// checkServiceRule returns an error if svcRule cannot be expressed as a
+// local sampling rule.
+func checkServiceRule(svcRule *xraySvc.SamplingRule) error {
+	if svcRule.Version == nil || *svcRule.Version != 1 {
+		return errors.New("only version 1 is supported")
+	}
+	if svcRule.FixedRate == nil || svcRule.ReservoirSize == nil || svcRule.Priority == nil ||
+		svcRule.Host == nil || svcRule.HTTPMethod == nil || svcRule.URLPath == nil ||
+		svcRule.ServiceName == nil || svcRule.ServiceType == nil || svcRule.ResourceARN == nil {
+		return errors.New("missing required fields")
+	}
+	if len(svcRule.Attributes) != 0 {
+		return errors.New("attributes are not supported by local rules")
+	}
+	if *svcRule.ResourceARN != "*" {
+		return errors.New("resource ARN must be *")
+	}
+	if *svcRule.ServiceName != "*" {
+		return errors.New("service name must be *")
+	}
+	if *svcRule.ServiceType != "*" {
+		return errors.New("service type must be *")
+	}
+	return nil
+}
+
+// FromServiceRulesJSON converts the raw JSON body of a GetSamplingRules
+// response, as printed by `aws xray get-sampling-rules`, like FromServiceRules.
+func FromServiceRulesJSON(b []byte) (*RuleManifest, error) {
+	var body struct {
+		SamplingRuleRecords []struct {
+			SamplingRule *xraySvc.SamplingRule
+		}
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, err
+	}
+
+	records := make([]*xraySvc.SamplingRuleRecord, 0, len(body.SamplingRuleRecords))
+	for _, record := range body.SamplingRuleRecords {
+		records = append(records, &xraySvc.SamplingRuleRecord{SamplingRule: record.SamplingRule})
+	}
+	return FromServiceRules(records)
+}
+
+// manifestRule is the JSON representation of a local sampling rule.
+type manifestRule struct {
+	Description string  `json:"description,omitempty"`
+	Host        string  `json:"host,omitempty"`
+	HTTPMethod  string  `json:"http_method,omitempty"`
+	URLPath     string  `json:"url_path,omitempty"`
+	FixedTarget int64   `json:"fixed_target"`
+	Rate        float64 `json:"rate"`
+}
+
+// ManifestToJSONBytes serializes the manifest m to the version 2 local
+// sampling rule JSON format read by ManifestFromJSONBytes.
+func ManifestToJSONBytes(m *RuleManifest) ([]byte, error) {
+	if m == nil || m.Default == nil {
+		return nil, errors.New("sampling rule manifest must include a default rule")
+	}
+
+	out := struct {
+		Version int             `json:"version"`
+		Default manifestRule    `json:"default"`
+		Rules   []*manifestRule `json:"rules"`
+	}{
+		Version: 2,
+		Default: manifestRule{
+			Description: m.Default.Description,
+			FixedTarget: m.Default.FixedTarget,
+			Rate:        m.Default.Rate,
+		},
+		Rules: make([]*manifestRule, 0, len(m.Rules)),
+	}
+	for _, r := range m.Rules {
+		out.Rules = append(out.Rules, &manifestRule{
+			Description: r.Description,
+			Host:        r.Host,
+			HTTPMethod:  r.HTTPMethod,
+			URLPath:     r.URLPath,
+			FixedTarget: r.FixedTarget,
+			Rate:        r.Rate,
+		})
+	}
+	return json.MarshalIndent(out, "", "    ")
+}
@@ -0,0 +1,161 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package sampling
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	xraySvc "github.com/aws/aws-sdk-go/service/xray"
+	"github.com/aws/aws-xray-sdk-go/strategy/exception"
+	"github.com/aws/aws-xray-sdk-go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromServiceRulesJSON(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/get-sampling-rules.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	m, err := FromServiceRulesJSON(b)
+	if assert.Error(t, err) {
+		// orders-service, lambda-arn and tenant-attribute are skipped
+		assert.Len(t, err.(exception.MultiError), 3)
+		assert.Contains(t, err.Error(), "orders-service")
+		assert.Contains(t, err.Error(), "lambda-arn")
+		assert.Contains(t, err.Error(), "tenant-attribute")
+	}
+	if !assert.NotNil(t, m) {
+		return
+	}
+
+	assert.Equal(t, 2, m.Version)
+	assert.Equal(t, "Default", m.Default.Description)
+	assert.Equal(t, int64(0), m.Default.FixedTarget)
+	assert.Equal(t, 0.0, m.Default.Rate)
+
+	// ordered by priority
+	if assert.Len(t, m.Rules, 3) {
+		assert.Equal(t, "api-post", m.Rules[0].Description)
+		assert.Equal(t, "POST", m.Rules[0].HTTPMethod)
+		assert.Equal(t, "/api/*", m.Rules[0].URLPath)
+		assert.Equal(t, "*", m.Rules[0].Host)
+		assert.Equal(t, 1.0, m.Rules[0].Rate)
+		assert.Equal(t, "all-api", m.Rules[1].Description)
+		assert.Equal(t, "foo-host", m.Rules[2].Description)
+		assert.Equal(t, "foo.example.com", m.Rules[2].Host)
+	}
+}
+
+func TestFromServiceRulesWithoutDefault(t *testing.T) {
+	name := "r1"
+	m, err := FromServiceRules([]*xraySvc.SamplingRuleRecord{
+		{SamplingRule: &xraySvc.SamplingRule{RuleName: &name}},
+	})
+	assert.Nil(t, m)
+	assert.Error(t, err)
+}
+
+func TestManifestToJSONBytesRoundTrip(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/get-sampling-rules.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	m, _ := FromServiceRulesJSON(b)
+
+	out, err := ManifestToJSONBytes(m)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var raw map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out, &raw))
+	assert.Equal(t, 2.0, raw["version"])
+	assert.Equal(t, map[string]interface{}{"description": "Default", "fixed_target": 0.0, "rate": 0.0}, raw["default"])
+
+	parsed, err := ManifestFromJSONBytes(out)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, m.Default.Properties, parsed.Default.Properties)
+	if assert.Len(t, parsed.Rules, len(m.Rules)) {
+		for i := range m.Rules {
+			assert.Equal(t, m.Rules[i].Description, parsed.Rules[i].Description)
+			assert.Equal(t, m.Rules[i].Properties, parsed.Rules[i].Properties)
+		}
+	}
+}
+
+// The local strategy built from converted rules makes the same decisions as
+// the centralized strategy for requests the skipped rules do not apply to.
+// Rules of the fixture have no reservoir and a rate of 0 or 1, so decisions
+// are deterministic.
+func TestServiceRulesShouldTraceEquivalence(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/get-sampling-rules.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	m, _ := FromServiceRulesJSON(b)
+	out, err := ManifestToJSONBytes(m)
+	if !assert.NoError(t, err) {
+		return
+	}
+	local, err := NewLocalizedStrategyFromJSONBytes(out)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// CreatedAt and ModifiedAt of the fixture are not RFC 3339 timestamps,
+	// so only the sampling rules are decoded.
+	var body struct {
+		SamplingRuleRecords []struct {
+			SamplingRule *xraySvc.SamplingRule
+		}
+	}
+	if !assert.NoError(t, json.Unmarshal(b, &body)) {
+		return
+	}
+	var records []*xraySvc.SamplingRuleRecord
+	for _, r := range body.SamplingRuleRecords {
+		records = append(records, &xraySvc.SamplingRuleRecord{SamplingRule: r.SamplingRule})
+	}
+
+	clock := &utils.MockClock{
+		NowTime: 1500000000,
+	}
+	centralized := &CentralizedStrategy{
+		manifest: &CentralizedManifest{
+			Rules: []*CentralizedRule{},
+			Index: map[string]*CentralizedRule{},
+			clock: clock,
+		},
+		proxy:       &mockProxy{samplingRules: records},
+		clock:       clock,
+		pollerStart: true,
+	}
+	assert.NoError(t, centralized.refreshManifest())
+
+	requests := []struct {
+		request *Request
+		sampled bool
+	}{
+		{&Request{Host: "www.example.com", Method: "POST", URL: "/api/orders", ServiceName: "checkout"}, true},
+		{&Request{Host: "www.example.com", Method: "GET", URL: "/api/orders", ServiceName: "checkout"}, false},
+		{&Request{Host: "foo.example.com", Method: "GET", URL: "/index.html", ServiceName: "checkout"}, true},
+		{&Request{Host: "foo.example.com", Method: "GET", URL: "/api/orders", ServiceName: "checkout"}, false},
+		{&Request{Host: "www.example.com", Method: "GET", URL: "/index.html", ServiceName: "checkout"}, false},
+	}
+	for _, r := range requests {
+		c, l := *r.request, *r.request
+		assert.Equal(t, r.sampled, centralized.ShouldTrace(&c).Sample, "centralized "+r.request.URL)
+		assert.Equal(t, r.sampled, local.ShouldTrace(&l).Sample, "local "+r.request.URL)
+	}
+}
@@ -21,7 +21,7 @@ type MockClock struct {
 
 // Now function returns NowTime value.
 func (c *MockClock) Now() time.Time {
-	return time.Unix(c.NowTime, c.NowNanos)
+	return time.Unix(atomic.LoadInt64(&c.NowTime), atomic.LoadInt64(&c.NowNanos))
 }
 
 // Increment is a method to increase current time.
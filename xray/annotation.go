@@ -0,0 +1,210 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/exception"
+)
+
+// maxAnnotationKeyLength is the longest annotation key X-Ray indexes.
+const maxAnnotationKeyLength = 500
+
+// Errors returned by AddAnnotation and AddAnnotationsFlattened, wrapped with
+// details.
+var (
+	// ErrInvalidAnnotationKey is returned for an annotation key X-Ray can't
+	// index, which is empty, longer than 500 characters, or contains other
+	// characters than ASCII letters, digits and underscores.
+	ErrInvalidAnnotationKey = errors.New("invalid annotation key")
+
+	// ErrUnsupportedAnnotationType is returned for an annotation value that
+	// isn't a string, a finite number or a boolean.
+	ErrUnsupportedAnnotationType = errors.New("unsupported annotation type")
+)
+
+func isAnnotationKey(key string) bool {
+	if key == "" || len(key) > maxAnnotationKeyLength {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// annotationValue returns value in a form X-Ray accepts as annotation: sized
+// integers become int64 or uint64, and values of named types their underlying
+// string, number or boolean. It returns false for any other value.
+func annotationValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case bool, int, uint, string:
+		return v, true
+	case float32:
+		return v, !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0)
+	case float64:
+		return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+	case nil:
+		return nil, false
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		return f, !math.IsNaN(f) && !math.IsInf(f, 0)
+	case reflect.String:
+		return v.String(), true
+	}
+	return nil, false
+}
+
+func invalidAnnotationKeyError(key string) error {
+	return fmt.Errorf("%w %q: key must be 1 to %d ASCII letters, digits or underscores", ErrInvalidAnnotationKey, key, maxAnnotationKeyLength)
+}
+
+// checkAnnotation returns the value to record for the annotation, or an error
+// wrapping ErrInvalidAnnotationKey or ErrUnsupportedAnnotationType.
+func checkAnnotation(key string, value interface{}) (interface{}, error) {
+	if !isAnnotationKey(key) {
+		return nil, invalidAnnotationKeyError(key)
+	}
+	v, ok := annotationValue(value)
+	if !ok {
+		return nil, fmt.Errorf("%w %T of annotation key %q: value must be of type string, number or boolean", ErrUnsupportedAnnotationType, value, key)
+	}
+	return v, nil
+}
+
+// AddAnnotationsFlattened adds the fields of the struct, or the entries of the
+// map with string keys, v as annotations of the segment, since X-Ray can only
+// filter traces on scalar annotations. Each annotation key is the field name,
+// or its name in the json tag, or the map key, prefixed with prefix and an
+// underscore unless prefix is empty. Only one level is flattened: nil values
+// and values of unsupported types, such as slices and nested structs, are
+// skipped with a debug log. Invalid keys are reported in the returned error,
+// which matches ErrInvalidAnnotationKey with errors.Is.
+func (seg *Segment) AddAnnotationsFlattened(prefix string, v interface{}) error {
+	if seg.isDisabled() {
+		return nil
+	}
+
+	fields, err := flattenAnnotations(prefix, v)
+	if err != nil {
+		return fmt.Errorf("failed to add annotations to segment %q: %w", seg.Name, err)
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	if seg.Dummy {
+		return nil
+	}
+
+	var errors exception.MultiError
+	for _, f := range fields {
+		value, ok := annotationValue(f.value)
+		if !ok {
+			seg.log().Debugf("skipping annotation key: %q of segment %q. value of type %T is not a string, number or boolean", f.key, seg.Name, f.value)
+			continue
+		}
+		if !isAnnotationKey(f.key) {
+			errors = append(errors, invalidAnnotationKeyError(f.key))
+			continue
+		}
+		if seg.Annotations == nil {
+			seg.Annotations = map[string]interface{}{}
+		}
+		seg.Annotations[f.key] = value
+	}
+
+	switch len(errors) {
+	case 0:
+		return nil
+	case 1:
+		return errors[0]
+	default:
+		return errors
+	}
+}
+
+type annotationField struct {
+	key   string
+	value interface{}
+}
+
+// flattenAnnotations returns the fields of the struct or map v, in order of
+// declaration or of sorted keys, with nil pointers and interfaces as nil and
+// other pointers dereferenced.
+func flattenAnnotations(prefix string, v interface{}) ([]annotationField, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	if prefix != "" {
+		prefix += "_"
+	}
+
+	var fields []annotationField
+	switch {
+	case !rv.IsValid():
+		return nil, nil
+	case rv.Kind() == reflect.Struct:
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+			name := sf.Name
+			if tag, _, _ := strings.Cut(sf.Tag.Get("json"), ","); tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			fields = append(fields, annotationField{key: prefix + name, value: leafValue(rv.Field(i))})
+		}
+	case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			fields = append(fields, annotationField{key: prefix + k.String(), value: leafValue(rv.MapIndex(k))})
+		}
+	default:
+		return nil, fmt.Errorf("%w %T: value must be a struct or a map with string keys", ErrUnsupportedAnnotationType, v)
+	}
+	return fields, nil
+}
+
+func leafValue(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	return v.Interface()
+}
@@ -0,0 +1,147 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type orderStatus string
+
+// beginAnnotationSubsegment returns a subsegment without the annotations
+// recorded on segments, closed with its segment at the end of the test.
+func beginAnnotationSubsegment(t *testing.T) *Segment {
+	ctx, _ := newMemoryEmitterContext(t, Config{})
+	ctx, root := BeginSegment(ctx, "test")
+	_, seg := BeginSubsegment(ctx, "sub")
+	t.Cleanup(func() {
+		seg.Close(nil)
+		root.Close(nil)
+	})
+	return seg
+}
+
+func TestAddAnnotationNumericTypes(t *testing.T) {
+	seg := beginAnnotationSubsegment(t)
+
+	assert.NoError(t, seg.AddAnnotation("int8", int8(-8)))
+	assert.NoError(t, seg.AddAnnotation("int64", int64(-64)))
+	assert.NoError(t, seg.AddAnnotation("uint16", uint16(16)))
+	assert.NoError(t, seg.AddAnnotation("uint64", uint64(64)))
+	assert.NoError(t, seg.AddAnnotation("float32", float32(1.5)))
+	assert.NoError(t, seg.AddAnnotation("status", orderStatus("shipped")))
+
+	assert.Equal(t, int64(-8), seg.Annotations["int8"])
+	assert.Equal(t, int64(-64), seg.Annotations["int64"])
+	assert.Equal(t, uint64(16), seg.Annotations["uint16"])
+	assert.Equal(t, uint64(64), seg.Annotations["uint64"])
+	assert.Equal(t, float32(1.5), seg.Annotations["float32"])
+	assert.Equal(t, "shipped", seg.Annotations["status"])
+}
+
+func TestAddAnnotationUnsupportedType(t *testing.T) {
+	seg := beginAnnotationSubsegment(t)
+
+	for _, value := range []interface{}{nil, []string{"a"}, map[string]int{}, struct{}{}, math.NaN(), math.Inf(1)} {
+		err := seg.AddAnnotation("key", value)
+		assert.True(t, errors.Is(err, ErrUnsupportedAnnotationType), "%v: %v", value, err)
+	}
+	assert.NotContains(t, seg.Annotations, "key")
+}
+
+func TestAddAnnotationInvalidKey(t *testing.T) {
+	seg := beginAnnotationSubsegment(t)
+
+	for _, key := range []string{"", "user.id", "user id", "café", strings.Repeat("k", maxAnnotationKeyLength+1)} {
+		err := seg.AddAnnotation(key, "value")
+		assert.True(t, errors.Is(err, ErrInvalidAnnotationKey), "%q: %v", key, err)
+	}
+	assert.Empty(t, seg.Annotations)
+
+	assert.NoError(t, seg.AddAnnotation(strings.Repeat("k", maxAnnotationKeyLength), "value"))
+	assert.NoError(t, seg.AddAnnotation("User_ID_2", "value"))
+}
+
+func TestAddAnnotationErrorNamesSegmentKind(t *testing.T) {
+	ctx, _ := newMemoryEmitterContext(t, Config{})
+	ctx, root := BeginSegment(ctx, "root")
+	_, subseg := BeginSubsegment(ctx, "sub")
+	defer root.Close(nil)
+	defer subseg.Close(nil)
+
+	err := root.AddAnnotation("bad key", "value")
+	assert.EqualError(t, err, `failed to add annotation to segment "root": `+errors.Unwrap(err).Error())
+	err = subseg.AddAnnotation("bad key", "value")
+	assert.EqualError(t, err, `failed to add annotation to subsegment "sub": `+errors.Unwrap(err).Error())
+}
+
+func TestAddAnnotationsFlattenedStruct(t *testing.T) {
+	seg := beginAnnotationSubsegment(t)
+
+	quantity := 3
+	order := &struct {
+		ID       string `json:"id"`
+		Quantity *int
+		Coupon   *string
+		Status   orderStatus `json:"status,omitempty"`
+		Items    []string
+		Customer struct{ Name string }
+		Secret   string `json:"-"`
+		internal string
+	}{ID: "o-1", Quantity: &quantity, Status: "shipped", Items: []string{"a"}, Secret: "s", internal: "i"}
+
+	assert.NoError(t, seg.AddAnnotationsFlattened("order", order))
+
+	assert.Equal(t, map[string]interface{}{
+		"order_id":       "o-1",
+		"order_Quantity": 3,
+		"order_status":   "shipped",
+	}, seg.Annotations)
+}
+
+func TestAddAnnotationsFlattenedMap(t *testing.T) {
+	seg := beginAnnotationSubsegment(t)
+
+	err := seg.AddAnnotationsFlattened("", map[string]interface{}{
+		"tenant":                 "acme",
+		"retries":                uint8(2),
+		"premium":                true,
+		"missing":                nil,
+		"tags":                   []string{"a"},
+		"bad key":                "x",
+		strings.Repeat("k", 501): "y",
+		"nested":                 map[string]string{"a": "b"},
+	})
+
+	assert.True(t, errors.Is(err, ErrInvalidAnnotationKey), "%v", err)
+	assert.Contains(t, err.Error(), `"bad key"`)
+	assert.Contains(t, err.Error(), strings.Repeat("k", 501))
+	assert.Equal(t, map[string]interface{}{
+		"tenant":  "acme",
+		"retries": uint64(2),
+		"premium": true,
+	}, seg.Annotations)
+}
+
+func TestAddAnnotationsFlattenedNil(t *testing.T) {
+	seg := beginAnnotationSubsegment(t)
+
+	var order *struct{ ID string }
+	assert.NoError(t, seg.AddAnnotationsFlattened("order", nil))
+	assert.NoError(t, seg.AddAnnotationsFlattened("order", order))
+	assert.Empty(t, seg.Annotations)
+
+	err := seg.AddAnnotationsFlattened("order", "o-1")
+	assert.True(t, errors.Is(err, ErrUnsupportedAnnotationType), "%v", err)
+}
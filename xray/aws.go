@@ -69,6 +69,11 @@ var xRayBeforeValidateHandler = request.NamedHandler{
 			return
 		}
 		opseg.Namespace = "aws"
+		provider := r.ClientInfo.ServiceID
+		if provider == "" {
+			provider = r.ClientInfo.ServiceName
+		}
+		opseg.AddAnnotation("provider", provider)
 		marshalctx, _ := BeginSubsegment(ctx, "marshal")
 
 		r.SetContext(marshalctx)
@@ -91,14 +96,21 @@ var xRayBeforeSignHandler = request.NamedHandler{
 			return
 		}
 		ct, _ := NewClientTrace(ctx)
+		ctx = context.WithValue(ctx, clientTraceKey{}, ct)
 		r.SetContext(httptrace.WithClientTrace(ctx, ct.httpTrace))
 	},
 }
 
+// clientTraceKey holds the ClientTrace of an attempt, ended once it is sent.
+type clientTraceKey struct{}
+
 var xRayAfterSendHandler = request.NamedHandler{
 	Name: "XRayAfterSendHandler",
 	Fn: func(r *request.Request) {
 		curseg := GetSegment(r.HTTPRequest.Context())
+		if ct, ok := r.HTTPRequest.Context().Value(clientTraceKey{}).(*ClientTrace); ok {
+			ct.subsegments.done(r.Error)
+		}
 
 		if curseg != nil && curseg.Name == "attempt" {
 			// An error could have prevented the connect subsegment from closing,
@@ -150,7 +162,32 @@ var xRayAfterRetryHandler = request.NamedHandler{
 	},
 }
 
-func pushHandlers(handlers *request.Handlers, completionWhitelistFilename string) {
+// AWSOptions configures the instrumentation of an AWS client or session.
+type AWSOptions struct {
+	// CaptureResources records the identifiers of the resources of requests
+	// to well-known services, even for operations missing from the
+	// whitelist: table_name for DynamoDB, bucket_name and key for S3,
+	// queue_url for SQS and function_name for Lambda.
+	CaptureResources bool
+}
+
+// resourceParameter is a request parameter identifying a resource, recorded
+// under key.
+type resourceParameter struct {
+	name string
+	key  string
+}
+
+// resourceParameters are the resourceParameters of well-known services by
+// service name, see AWSOptions.CaptureResources.
+var resourceParameters = map[string][]resourceParameter{
+	"dynamodb": {{"TableName", "table_name"}},
+	"s3":       {{"Bucket", "bucket_name"}, {"Key", "key"}},
+	"sqs":      {{"QueueUrl", "queue_url"}},
+	"lambda":   {{"FunctionName", "function_name"}},
+}
+
+func pushHandlers(handlers *request.Handlers, completionWhitelistFilename string, opts AWSOptions) {
 	handlers.Validate.PushFrontNamed(xRayBeforeValidateHandler)
 	handlers.Build.PushBackNamed(xRayAfterBuildHandler)
 	handlers.Sign.PushFrontNamed(xRayBeforeSignHandler)
@@ -159,7 +196,7 @@ func pushHandlers(handlers *request.Handlers, completionWhitelistFilename string
 	handlers.Unmarshal.PushBackNamed(xRayAfterUnmarshalHandler)
 	handlers.Retry.PushFrontNamed(xRayBeforeRetryHandler)
 	handlers.AfterRetry.PushBackNamed(xRayAfterRetryHandler)
-	handlers.Complete.PushFrontNamed(xrayCompleteHandler(completionWhitelistFilename))
+	handlers.Complete.PushFrontNamed(xrayCompleteHandler(completionWhitelistFilename, opts))
 }
 
 // AWS adds X-Ray tracing to an AWS client.
@@ -167,7 +204,7 @@ func AWS(c *client.Client) {
 	if c == nil {
 		panic("Please initialize the provided AWS client before passing to the AWS() method.")
 	}
-	pushHandlers(&c.Handlers, "")
+	pushHandlers(&c.Handlers, "", AWSOptions{})
 }
 
 // AWSWithWhitelist allows a custom parameter whitelist JSON file to be defined.
@@ -175,24 +212,39 @@ func AWSWithWhitelist(c *client.Client, filename string) {
 	if c == nil {
 		panic("Please initialize the provided AWS client before passing to the AWSWithWhitelist() method.")
 	}
-	pushHandlers(&c.Handlers, filename)
+	pushHandlers(&c.Handlers, filename, AWSOptions{})
+}
+
+// AWSWithOptions adds X-Ray tracing to an AWS client, configured by opts.
+func AWSWithOptions(c *client.Client, opts AWSOptions) {
+	if c == nil {
+		panic("Please initialize the provided AWS client before passing to the AWSWithOptions() method.")
+	}
+	pushHandlers(&c.Handlers, "", opts)
 }
 
 // AWSSession adds X-Ray tracing to an AWS session. Clients created under this
 // session will inherit X-Ray tracing.
 func AWSSession(s *session.Session) *session.Session {
-	pushHandlers(&s.Handlers, "")
+	pushHandlers(&s.Handlers, "", AWSOptions{})
 	return s
 }
 
 // AWSSessionWithWhitelist allows a custom parameter whitelist JSON file to be
 // defined.
 func AWSSessionWithWhitelist(s *session.Session, filename string) *session.Session {
-	pushHandlers(&s.Handlers, filename)
+	pushHandlers(&s.Handlers, filename, AWSOptions{})
 	return s
 }
 
-func xrayCompleteHandler(filename string) request.NamedHandler {
+// AWSSessionWithOptions adds X-Ray tracing to an AWS session, configured by
+// opts. Clients created under this session will inherit X-Ray tracing.
+func AWSSessionWithOptions(s *session.Session, opts AWSOptions) *session.Session {
+	pushHandlers(&s.Handlers, "", opts)
+	return s
+}
+
+func xrayCompleteHandler(filename string, opts AWSOptions) request.NamedHandler {
 	whitelistJSON := parseWhitelistJSON(filename)
 	whitelist := &jsonMap{}
 	err := json.Unmarshal(whitelistJSON, &whitelist.object)
@@ -222,6 +274,13 @@ func xrayCompleteHandler(filename string) request.NamedHandler {
 			for k, v := range extractResponseParameters(r, whitelist) {
 				opseg.GetAWS()[strings.ToLower(addUnderScoreBetweenWords(k))] = v
 			}
+			if opts.CaptureResources {
+				for k, v := range extractResourceParameters(r) {
+					if _, ok := opseg.GetAWS()[k]; !ok {
+						opseg.GetAWS()[k] = v
+					}
+				}
+			}
 
 			opseg.GetAWS()["region"] = r.ClientInfo.SigningRegion
 			opseg.GetAWS()["operation"] = r.Operation.Name
@@ -347,6 +406,38 @@ func extractResponseParameters(r *request.Request, whitelist *jsonMap) map[strin
 	return valueMap
 }
 
+// extractResourceParameters returns the non-empty resourceParameters of the
+// service of r, by key.
+func extractResourceParameters(r *request.Request) map[string]interface{} {
+	params := resourceParameters[r.ClientInfo.ServiceName]
+	if len(params) == 0 {
+		return nil
+	}
+
+	v := reflect.ValueOf(r.Params)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	valueMap := make(map[string]interface{}, len(params))
+	for _, p := range params {
+		f := v.FieldByName(p.name)
+		if f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				continue
+			}
+			f = f.Elem()
+		}
+		if f.Kind() == reflect.String && f.String() != "" {
+			valueMap[p.key] = f.String()
+		}
+	}
+	return valueMap
+}
+
 func extractParameters(whitelistKey string, rType int, r *request.Request, whitelist *jsonMap, valueMap map[string]interface{}) {
 	params := whitelist.search("services", r.ClientInfo.ServiceName, "operations", r.Operation.Name, whitelistKey)
 	if params != nil {
@@ -18,9 +18,13 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -135,6 +139,7 @@ func testClientSuccessfulConnection(ctx context.Context, td *TestDaemon, t *test
 		return
 	}
 	assert.False(t, subseg.Fault)
+	assert.Equal(t, "Lambda", subseg.Annotations["provider"])
 	assert.NotEmpty(t, subseg.Subsegments)
 
 	attemptSubseg := &Segment{}
@@ -239,3 +244,83 @@ func testAWSDataRace(ctx context.Context, td *TestDaemon, t *testing.T, svc *lam
 	wg.Wait()
 	seg.Close(nil)
 }
+
+func TestAWSCaptureResources(t *testing.T) {
+	for _, capture := range []bool{false, true} {
+		ctx, td := NewTestDaemon()
+		defer td.Close()
+
+		dbSession, cleanup := fakeSession(t, false)
+		defer cleanup()
+		db := dynamodb.New(dbSession)
+		AWSWithOptions(db.Client, AWSOptions{CaptureResources: capture})
+
+		s3Session, cleanup := fakeSession(t, false)
+		defer cleanup()
+		bucket := s3.New(AWSSessionWithOptions(s3Session, AWSOptions{CaptureResources: capture}), aws.NewConfig().WithS3ForcePathStyle(true))
+
+		// neither operation is in the default whitelist
+		ctx, root := BeginSegment(ctx, "Test")
+		_, err := db.DescribeTimeToLiveWithContext(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String("table")})
+		assert.NoError(t, err)
+		_, err = bucket.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("object")})
+		assert.NoError(t, err)
+		root.Close(nil)
+
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) || !assert.Len(t, seg.Subsegments, 2) {
+			return
+		}
+		var dbSubseg, s3Subseg *Segment
+		assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &dbSubseg))
+		assert.NoError(t, json.Unmarshal(seg.Subsegments[1], &s3Subseg))
+
+		if capture {
+			assert.Equal(t, "table", dbSubseg.AWS["table_name"])
+			assert.Equal(t, "bucket", s3Subseg.AWS["bucket_name"])
+			assert.Equal(t, "object", s3Subseg.AWS["key"])
+		} else {
+			assert.NotContains(t, dbSubseg.AWS, "table_name")
+			assert.NotContains(t, s3Subseg.AWS, "bucket_name")
+			assert.NotContains(t, s3Subseg.AWS, "key")
+		}
+		assert.Equal(t, "DescribeTimeToLive", dbSubseg.AWS["operation"])
+		assert.Equal(t, "HeadObject", s3Subseg.AWS["operation"])
+	}
+}
+
+// fakeResourceInput has the shape of AWS SDK input structs.
+type fakeResourceInput struct {
+	_ struct{}
+
+	TableName    *string
+	Bucket       *string
+	Key          *string
+	QueueUrl     *string
+	FunctionName string
+}
+
+func TestExtractResourceParameters(t *testing.T) {
+	tests := []struct {
+		service string
+		params  interface{}
+		want    map[string]interface{}
+	}{
+		{"dynamodb", &fakeResourceInput{TableName: aws.String("table")}, map[string]interface{}{"table_name": "table"}},
+		{"s3", &fakeResourceInput{Bucket: aws.String("bucket"), Key: aws.String("object")}, map[string]interface{}{"bucket_name": "bucket", "key": "object"}},
+		{"s3", &fakeResourceInput{Bucket: aws.String("bucket")}, map[string]interface{}{"bucket_name": "bucket"}},
+		{"sqs", fakeResourceInput{QueueUrl: aws.String("https://sqs/queue")}, map[string]interface{}{"queue_url": "https://sqs/queue"}},
+		{"lambda", &fakeResourceInput{FunctionName: "function"}, map[string]interface{}{"function_name": "function"}},
+		{"lambda", &fakeResourceInput{}, map[string]interface{}{}},
+		{"ec2", &fakeResourceInput{TableName: aws.String("table")}, nil},
+		{"dynamodb", nil, nil},
+	}
+
+	for _, test := range tests {
+		r := &request.Request{
+			ClientInfo: metadata.ClientInfo{ServiceName: test.service},
+			Params:     test.params,
+		}
+		assert.Equal(t, test.want, extractResourceParameters(r), test.service)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+)
+
+// maxDatagramSize is the size limit of a datagram sent to the daemon.
+const maxDatagramSize = 64 * 1000
+
+// defaultFlushInterval is the default interval at which batches are sent.
+const defaultFlushInterval = 100 * time.Millisecond
+
+// BatchOptions configures a BatchingEmitter.
+type BatchOptions struct {
+	// MaxBatchSize is the maximum size in bytes of a batch, limited to and
+	// defaulting to 64KB.
+	MaxBatchSize int
+	// FlushInterval is the maximum time a document is kept in a batch,
+	// defaulting to 100ms.
+	FlushInterval time.Duration
+}
+
+// BatchingEmitter is an Emitter sending several segments, each prefixed with
+// the header, in a single UDP datagram. Documents are accumulated until the
+// next one would exceed MaxBatchSize or FlushInterval elapses. Documents
+// exceeding MaxBatchSize alone are sent on their own.
+// Pending documents are sent by Flush and Close, one of which should be called
+// before the program exits.
+type BatchingEmitter struct {
+	sync.Mutex
+	conn   net.Conn
+	addr   *net.UDPAddr
+	opts   BatchOptions
+	batch  []byte
+	closed bool
+	done   chan struct{}
+}
+
+// NewBatchingEmitter initializes and returns a pointer to an instance of
+// BatchingEmitter sending batches to raddr.
+func NewBatchingEmitter(raddr *net.UDPAddr, opts BatchOptions) (*BatchingEmitter, error) {
+	initLambda()
+	if opts.MaxBatchSize <= 0 || opts.MaxBatchSize > maxDatagramSize {
+		opts.MaxBatchSize = maxDatagramSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	be := &BatchingEmitter{
+		addr:  raddr,
+		opts:  opts,
+		batch: make([]byte, 0, opts.MaxBatchSize),
+		done:  make(chan struct{}),
+	}
+	go be.flushPeriodically()
+	return be, nil
+}
+
+// RefreshEmitterWithAddress sends the pending documents and dials UDP based on
+// the input UDP address.
+func (be *BatchingEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {
+	be.Lock()
+	be.flush()
+	be.addr = raddr
+	be.refresh()
+	be.Unlock()
+}
+
+func (be *BatchingEmitter) refresh() error {
+	conn, err := net.DialUDP("udp", nil, be.addr)
+	if err != nil {
+		be.conn = nil
+		logger.Errorf("Error dialing emitter address %v: %s", be.addr, err)
+		return err
+	}
+	be.conn = conn
+	logger.Infof("Emitter using address: %v", be.addr)
+	return nil
+}
+
+// Emit adds segment or subsegment to the batch if root segment is sampled.
+// seg has a write lock acquired by the caller.
+func (be *BatchingEmitter) Emit(seg *Segment) {
+	defer func() {
+		if r := recover(); r != nil {
+			seg.log().Errorf("Panic emitting segment: %s\n%s", r, string(debug.Stack()))
+		}
+	}()
+
+	if seg == nil || !seg.ParentSegment.Sampled {
+		return
+	}
+
+	for _, p := range packSegments(seg, nil) {
+		seg.log().Debug(string(p))
+
+		be.Lock()
+		be.add(p)
+		be.Unlock()
+	}
+}
+
+// add adds the document p to the batch, sending the batch first if p doesn't
+// fit in it. p is sent on its own if it exceeds MaxBatchSize, or if the
+// emitter is closed.
+// The caller of add should have lock on be instance.
+func (be *BatchingEmitter) add(p []byte) {
+	size := len(Header) + len(p)
+	if be.closed || size > be.opts.MaxBatchSize {
+		be.flush()
+		be.write(append([]byte(Header), p...))
+		return
+	}
+	if len(be.batch)+size > be.opts.MaxBatchSize {
+		be.flush()
+	}
+	be.batch = append(be.batch, Header...)
+	be.batch = append(be.batch, p...)
+}
+
+// Flush sends the pending documents, see Flusher.
+func (be *BatchingEmitter) Flush(ctx context.Context) error {
+	be.Lock()
+	be.flush()
+	be.Unlock()
+	return nil
+}
+
+// Close sends the pending documents and stops the emitter. Documents emitted
+// afterwards are sent on their own.
+func (be *BatchingEmitter) Close() error {
+	be.Lock()
+	defer be.Unlock()
+	be.flush()
+	if be.closed {
+		return nil
+	}
+	be.closed = true
+	close(be.done)
+	return nil
+}
+
+// The caller of flush should have lock on be instance.
+func (be *BatchingEmitter) flush() {
+	if len(be.batch) == 0 {
+		return
+	}
+	be.write(be.batch)
+	be.batch = be.batch[:0]
+}
+
+// The caller of write should have lock on be instance.
+func (be *BatchingEmitter) write(b []byte) {
+	if be.conn == nil {
+		if err := be.refresh(); err != nil {
+			return
+		}
+	}
+	if _, err := be.conn.Write(b); err != nil {
+		logger.Error(err)
+	}
+}
+
+func (be *BatchingEmitter) flushPeriodically() {
+	ticker := time.NewTicker(be.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			be.Lock()
+			be.flush()
+			be.Unlock()
+		case <-be.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newBatchingTestListener(t testing.TB) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// recvDocuments reads a datagram from conn and returns the names of the
+// segments it contains.
+func recvDocuments(t *testing.T, conn *net.UDPConn) []string {
+	t.Helper()
+	buffer := make([]byte, 64*1024)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buffer)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	docs := strings.Split(string(buffer[:n]), Header)
+	assert.Equal(t, "", docs[0], "datagram should begin with the header")
+
+	var names []string
+	for _, doc := range docs[1:] {
+		seg := &Segment{}
+		if assert.NoError(t, json.Unmarshal([]byte(doc), seg)) {
+			names = append(names, seg.Name)
+		}
+	}
+	return names
+}
+
+func newBatchingTestSegment(name string) *Segment {
+	seg := &Segment{}
+	json.Unmarshal([]byte(getTestSegment()), seg)
+	seg.Name = name
+	seg.ParentSegment = seg
+	seg.Sampled = true
+	return seg
+}
+
+func TestBatchingEmitterFlush(t *testing.T) {
+	conn := newBatchingTestListener(t)
+	defer conn.Close()
+
+	emitter, err := NewBatchingEmitter(conn.LocalAddr().(*net.UDPAddr), BatchOptions{FlushInterval: time.Hour})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer emitter.Close()
+
+	emitter.Emit(newBatchingTestSegment("a"))
+	emitter.Emit(newBatchingTestSegment("b"))
+	emitter.Emit(newBatchingTestSegment("c"))
+	emitter.Flush(context.Background())
+
+	assert.Equal(t, []string{"a", "b", "c"}, recvDocuments(t, conn))
+}
+
+func TestBatchingEmitterMaxBatchSize(t *testing.T) {
+	conn := newBatchingTestListener(t)
+	defer conn.Close()
+
+	seg := newBatchingTestSegment("a")
+	b, _ := json.Marshal(seg)
+	size := len(Header) + len(b)
+
+	emitter, err := NewBatchingEmitter(conn.LocalAddr().(*net.UDPAddr), BatchOptions{
+		MaxBatchSize:  2*size + size/2,
+		FlushInterval: time.Hour,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer emitter.Close()
+
+	emitter.Emit(newBatchingTestSegment("a"))
+	emitter.Emit(newBatchingTestSegment("b"))
+	// the third document doesn't fit in the batch which is sent first
+	emitter.Emit(newBatchingTestSegment("c"))
+	assert.Equal(t, []string{"a", "b"}, recvDocuments(t, conn))
+
+	emitter.Flush(context.Background())
+	assert.Equal(t, []string{"c"}, recvDocuments(t, conn))
+}
+
+func TestBatchingEmitterOversizedDocument(t *testing.T) {
+	conn := newBatchingTestListener(t)
+	defer conn.Close()
+
+	emitter, err := NewBatchingEmitter(conn.LocalAddr().(*net.UDPAddr), BatchOptions{
+		MaxBatchSize:  100,
+		FlushInterval: time.Hour,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer emitter.Close()
+
+	// documents exceeding the batch size are sent on their own right away
+	emitter.Emit(newBatchingTestSegment("a"))
+	emitter.Emit(newBatchingTestSegment("b"))
+	assert.Equal(t, []string{"a"}, recvDocuments(t, conn))
+	assert.Equal(t, []string{"b"}, recvDocuments(t, conn))
+}
+
+func TestBatchingEmitterFlushInterval(t *testing.T) {
+	conn := newBatchingTestListener(t)
+	defer conn.Close()
+
+	emitter, err := NewBatchingEmitter(conn.LocalAddr().(*net.UDPAddr), BatchOptions{FlushInterval: 10 * time.Millisecond})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer emitter.Close()
+
+	emitter.Emit(newBatchingTestSegment("a"))
+	assert.Equal(t, []string{"a"}, recvDocuments(t, conn))
+}
+
+func TestBatchingEmitterClose(t *testing.T) {
+	conn := newBatchingTestListener(t)
+	defer conn.Close()
+
+	emitter, err := NewBatchingEmitter(conn.LocalAddr().(*net.UDPAddr), BatchOptions{FlushInterval: time.Hour})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	emitter.Emit(newBatchingTestSegment("a"))
+	assert.NoError(t, emitter.Close())
+	assert.Equal(t, []string{"a"}, recvDocuments(t, conn))
+
+	// documents emitted after Close are sent right away
+	emitter.Emit(newBatchingTestSegment("b"))
+	assert.Equal(t, []string{"b"}, recvDocuments(t, conn))
+	assert.NoError(t, emitter.Close())
+}
+
+func TestBatchingEmitterNotSampled(t *testing.T) {
+	conn := newBatchingTestListener(t)
+	defer conn.Close()
+
+	emitter, err := NewBatchingEmitter(conn.LocalAddr().(*net.UDPAddr), BatchOptions{FlushInterval: time.Hour})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer emitter.Close()
+
+	seg := newBatchingTestSegment("a")
+	seg.Sampled = false
+	emitter.Emit(seg)
+
+	emitter.Lock()
+	assert.Empty(t, emitter.batch)
+	emitter.Unlock()
+}
+
+// countingConn counts the writes, i.e. the syscalls, made to a connection.
+type countingConn struct {
+	net.Conn
+	writes int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+// drain discards the datagrams received by conn until it's closed.
+func drain(conn *net.UDPConn) {
+	buffer := make([]byte, 64*1024)
+	for {
+		if _, err := conn.Read(buffer); err != nil {
+			return
+		}
+	}
+}
+
+func BenchmarkDefaultEmitterSyscalls(b *testing.B) {
+	conn := newBatchingTestListener(b)
+	defer conn.Close()
+	go drain(conn)
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	emitter, err := NewDefaultEmitter(addr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	emitter.RefreshEmitterWithAddress(addr)
+	counter := &countingConn{Conn: emitter.conn}
+	emitter.conn = counter
+
+	seg := newBatchingTestSegment("benchmark")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		emitter.Emit(seg)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&counter.writes))/float64(b.N), "writes/op")
+}
+
+func BenchmarkBatchingEmitterSyscalls(b *testing.B) {
+	conn := newBatchingTestListener(b)
+	defer conn.Close()
+	go drain(conn)
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	emitter, err := NewBatchingEmitter(addr, BatchOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer emitter.Close()
+	emitter.RefreshEmitterWithAddress(addr)
+	counter := &countingConn{Conn: emitter.conn}
+	emitter.Lock()
+	emitter.conn = counter
+	emitter.Unlock()
+
+	seg := newBatchingTestSegment("benchmark")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		emitter.Emit(seg)
+	}
+	emitter.Flush(context.Background())
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&counter.writes))/float64(b.N), "writes/op")
+}
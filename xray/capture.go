@@ -11,10 +11,13 @@ package xray
 import (
 	"context"
 	"fmt"
+	"time"
 )
 
 // Capture traces the provided synchronous function by
 // beginning and closing a subsegment around its execution.
+// If fn panics, the subsegment is closed with the panic recorded as a fault,
+// with the stack trace of the panic, and the panic is raised again.
 func Capture(ctx context.Context, name string, fn func(context.Context) error) (err error) {
 	c, seg := BeginSubsegment(ctx, name)
 
@@ -22,19 +25,15 @@ func Capture(ctx context.Context, name string, fn func(context.Context) error) (
 		if seg != nil {
 			seg.Close(err)
 		} else {
-			cfg := GetRecorder(ctx)
-			failedMessage := fmt.Sprintf("failed to end subsegment: subsegment '%v' cannot be found.", name)
-			if cfg != nil && cfg.ContextMissingStrategy != nil {
-				cfg.ContextMissingStrategy.ContextMissing(failedMessage)
-			} else {
-				globalCfg.ContextMissingStrategy().ContextMissing(failedMessage)
-			}
+			captureContextMissing(ctx, name)
 		}
 	}()
 
 	defer func() {
 		if p := recover(); p != nil {
-			err = seg.ParentSegment.GetConfiguration().ExceptionFormattingStrategy.Panicf("%v", p)
+			if seg != nil {
+				err = seg.ParentSegment.GetConfiguration().ExceptionFormattingStrategy.Panicf("%v", p)
+			}
 			panic(p)
 		}
 	}()
@@ -51,11 +50,104 @@ func Capture(ctx context.Context, name string, fn func(context.Context) error) (
 // CaptureAsync traces an arbitrary code segment within a goroutine.
 // Use CaptureAsync instead of manually calling Capture within a goroutine
 // to ensure the segment is flushed properly.
+// If fn panics, the panic is recorded as with Capture and the subsegment is
+// streamed right away on a best-effort basis, so that it isn't lost with the
+// process, before the panic is raised again.
 func CaptureAsync(ctx context.Context, name string, fn func(context.Context) error) {
 	started := make(chan struct{})
-	go Capture(ctx, name, func(ctx context.Context) error {
+	go captureAsync(ctx, name, func(ctx context.Context) error {
 		close(started)
 		return fn(ctx)
 	})
 	<-started
 }
+
+func captureAsync(ctx context.Context, name string, fn func(context.Context) error) {
+	c, seg := BeginSubsegment(ctx, name)
+	if seg == nil {
+		_ = fn(ctx)
+		captureContextMissing(ctx, name)
+		return
+	}
+
+	var err error
+	defer func() {
+		if p := recover(); p != nil {
+			closeAndStreamPanicked(seg, name, seg.ParentSegment.GetConfiguration().ExceptionFormattingStrategy.Panicf("%v", p))
+			panic(p)
+		}
+		seg.Close(err)
+	}()
+
+	err = fn(c)
+}
+
+// captureContextMissing reports the subsegment name of a capture as missing
+// to the context missing strategy.
+func captureContextMissing(ctx context.Context, name string) {
+	cfg := GetRecorder(ctx)
+	failedMessage := fmt.Sprintf("failed to end subsegment: subsegment '%v' cannot be found.", name)
+	if cfg != nil && cfg.ContextMissingStrategy != nil {
+		cfg.ContextMissingStrategy.ContextMissing(failedMessage)
+	} else {
+		globalCfg.ContextMissingStrategy().ContextMissing(failedMessage)
+	}
+}
+
+// panicEmitTimeout bounds how long GoWithRecovery waits for the subsegment of
+// a panicking goroutine to be emitted before re-raising the panic.
+var panicEmitTimeout = 100 * time.Millisecond
+
+// GoWithRecovery runs fn in a new goroutine, within a subsegment named name.
+// If fn panics, the panic is recorded on the subsegment, which is streamed
+// right away on a best-effort basis so that it isn't lost with the process,
+// and the panic is raised again with the trace ID added to its message.
+func GoWithRecovery(ctx context.Context, name string, fn func(ctx context.Context)) {
+	go runWithRecovery(ctx, name, fn)
+}
+
+func runWithRecovery(ctx context.Context, name string, fn func(ctx context.Context)) {
+	c, seg := BeginSubsegment(ctx, name)
+	if seg == nil {
+		fn(ctx)
+		return
+	}
+
+	defer func() {
+		p := recover()
+		if p == nil {
+			seg.Close(nil)
+			return
+		}
+
+		err := seg.ParentSegment.GetConfiguration().ExceptionFormattingStrategy.Panicf("%v", p)
+		closeAndStreamPanicked(seg, name, err)
+
+		seg.RLock()
+		traceID := seg.TraceID
+		seg.RUnlock()
+		if perr, ok := p.(error); ok {
+			panic(fmt.Errorf("%w [X-Ray trace ID: %s]", perr, traceID))
+		}
+		panic(fmt.Sprintf("%v [X-Ray trace ID: %s]", p, traceID))
+	}()
+
+	fn(c)
+}
+
+// closeAndStreamPanicked closes the subsegment of a panicking goroutine with
+// err and streams it, waiting at most panicEmitTimeout.
+func closeAndStreamPanicked(seg *Segment, name string, err error) {
+	emitted := make(chan struct{})
+	go func() {
+		seg.CloseAndStream(err)
+		close(emitted)
+	}()
+	t := time.NewTimer(panicEmitTimeout)
+	select {
+	case <-emitted:
+		t.Stop()
+	case <-t.C:
+		seg.log().Debugf("timed out emitting subsegment named %s of a panicking goroutine", name)
+	}
+}
@@ -12,8 +12,10 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/strategy/exception"
 	"github.com/stretchr/testify/assert"
@@ -37,8 +39,9 @@ func TestSimpleCapture(t *testing.T) {
 	assert.Equal(t, "Test", seg.Name)
 	assert.Equal(t, root.TraceID, seg.TraceID)
 	assert.Equal(t, root.ID, seg.ID)
-	assert.Equal(t, root.StartTime, seg.StartTime)
-	assert.Equal(t, root.EndTime, seg.EndTime)
+	// times are emitted with microsecond precision
+	assert.InDelta(t, root.StartTime, seg.StartTime, 1e-6)
+	assert.InDelta(t, root.EndTime, seg.EndTime, 1e-6)
 	assert.NotNil(t, seg.Subsegments)
 	var subseg *Segment
 	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
@@ -78,6 +81,43 @@ func TestErrorCapture(t *testing.T) {
 	assert.Equal(t, "Capture", subseg.Cause.Exceptions[0].Stack[1].Label)
 }
 
+func TestWrappedErrorCapture(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	captureErr := Capture(ctx, "ErrorService", func(context.Context) error {
+		defer root.Close(nil)
+		return fmt.Errorf("outer: %w", fmt.Errorf("middle: %w", errors.New("inner")))
+	})
+	if !assert.Error(t, captureErr) {
+		return
+	}
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		return
+	}
+	exceptions := subseg.Cause.Exceptions
+	if !assert.Len(t, exceptions, 3) {
+		return
+	}
+	assert.Equal(t, captureErr.Error(), exceptions[0].Message)
+	assert.Equal(t, exceptions[1].ID, exceptions[0].Cause)
+	assert.Equal(t, "middle: inner", exceptions[1].Message)
+	assert.Equal(t, exceptions[2].ID, exceptions[1].Cause)
+	assert.Equal(t, "inner", exceptions[2].Message)
+	assert.Equal(t, "errors.errorString", exceptions[2].Type)
+	assert.Empty(t, exceptions[2].Cause)
+	assert.NotEmpty(t, exceptions[0].Stack)
+	assert.Empty(t, exceptions[1].Stack)
+	assert.Empty(t, exceptions[2].Stack)
+}
+
 func TestPanicCapture(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -104,6 +144,10 @@ func TestPanicCapture(t *testing.T) {
 	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
 		return
 	}
+	assert.Equal(t, "MyPanic", captureErr.Error())
+	assert.True(t, subseg.Fault)
+	assert.NotZero(t, subseg.EndTime)
+	assert.False(t, subseg.InProgress)
 	assert.Equal(t, captureErr.Error(), subseg.Cause.Exceptions[0].Message)
 	assert.Equal(t, "panic", subseg.Cause.Exceptions[0].Type)
 	assert.Equal(t, "TestPanicCapture.func1.2", subseg.Cause.Exceptions[0].Stack[0].Label)
@@ -112,6 +156,23 @@ func TestPanicCapture(t *testing.T) {
 	assert.Equal(t, "TestPanicCapture", subseg.Cause.Exceptions[0].Stack[3].Label)
 }
 
+func TestPanicCaptureWithoutSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	var p interface{}
+	func() {
+		defer func() {
+			p = recover()
+		}()
+		_ = Capture(ctx, "PanicService", func(context.Context) error {
+			panic("MyPanic")
+		})
+	}()
+
+	assert.Equal(t, "MyPanic", p)
+}
+
 func TestNoSegmentCapture(t *testing.T) {
 	ctx, _ := NewTestDaemon()
 	_, seg := BeginSubsegment(ctx, "Name")
@@ -140,8 +201,9 @@ func TestCaptureAsync(t *testing.T) {
 	assert.Equal(t, "Test", seg.Name)
 	assert.Equal(t, root.TraceID, seg.TraceID)
 	assert.Equal(t, root.ID, seg.ID)
-	assert.Equal(t, root.StartTime, seg.StartTime)
-	assert.Equal(t, root.EndTime, seg.EndTime)
+	// times are emitted with microsecond precision
+	assert.InDelta(t, root.StartTime, seg.StartTime, 1e-6)
+	assert.InDelta(t, root.EndTime, seg.EndTime, 1e-6)
 	assert.NotNil(t, seg.Subsegments)
 	var subseg *Segment
 	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
@@ -149,6 +211,153 @@ func TestCaptureAsync(t *testing.T) {
 	}
 }
 
+func TestCaptureAsyncPanic(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	defer root.Close(nil)
+
+	var p interface{}
+	func() {
+		defer func() {
+			p = recover()
+		}()
+		captureAsync(ctx, "PanicService", func(context.Context) error {
+			panic("MyPanic")
+		})
+	}()
+
+	// the panic is raised again unchanged
+	assert.Equal(t, "MyPanic", p)
+
+	// the subsegment is streamed without waiting for the segment
+	subseg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "PanicService", subseg.Name)
+	assert.Equal(t, root.ID, subseg.ParentID)
+	assert.True(t, subseg.Fault)
+	assert.NotZero(t, subseg.EndTime)
+	assert.False(t, subseg.InProgress)
+	if assert.NotNil(t, subseg.Cause) {
+		assert.Equal(t, "MyPanic", subseg.Cause.Exceptions[0].Message)
+		assert.Equal(t, "panic", subseg.Cause.Exceptions[0].Type)
+		assert.NotEmpty(t, subseg.Cause.Exceptions[0].Stack)
+	}
+}
+
+func TestGoWithRecovery(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	done := make(chan struct{})
+	GoWithRecovery(ctx, "Worker", func(ctx context.Context) {
+		assert.Equal(t, "Worker", GetSegment(ctx).Name)
+		close(done)
+	})
+	<-done
+	// the segment is emitted once the subsegment closes, after fn returns
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		assert.Equal(t, "Worker", subseg.Name)
+		assert.Nil(t, subseg.Cause)
+	}
+}
+
+func TestGoWithRecoveryPanic(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	defer root.Close(nil)
+
+	var p interface{}
+	func() {
+		defer func() {
+			p = recover()
+		}()
+		runWithRecovery(ctx, "Worker", func(context.Context) {
+			panic("MyPanic")
+		})
+	}()
+
+	assert.Equal(t, "MyPanic [X-Ray trace ID: "+root.TraceID+"]", p)
+
+	// the subsegment is streamed without waiting for the segment
+	subseg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "Worker", subseg.Name)
+	assert.Equal(t, "subsegment", subseg.Type)
+	assert.Equal(t, root.TraceID, subseg.TraceID)
+	assert.Equal(t, root.ID, subseg.ParentID)
+	assert.True(t, subseg.Fault)
+	if assert.NotNil(t, subseg.Cause) {
+		assert.Equal(t, "MyPanic", subseg.Cause.Exceptions[0].Message)
+		assert.Equal(t, "panic", subseg.Cause.Exceptions[0].Type)
+	}
+}
+
+func TestGoWithRecoveryPanicError(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	defer root.Close(nil)
+
+	errPanic := errors.New("MyPanic")
+	var p interface{}
+	func() {
+		defer func() {
+			p = recover()
+		}()
+		runWithRecovery(ctx, "Worker", func(context.Context) {
+			panic(errPanic)
+		})
+	}()
+
+	err, ok := p.(error)
+	if assert.True(t, ok) {
+		assert.True(t, errors.Is(err, errPanic))
+		assert.Contains(t, err.Error(), root.TraceID)
+	}
+}
+
+func TestGoWithRecoveryPanicEmitTimeout(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	defer func(d time.Duration) { panicEmitTimeout = d }(panicEmitTimeout)
+	panicEmitTimeout = 10 * time.Millisecond
+
+	ctx, root := BeginSegment(ctx, "Test")
+
+	var p interface{}
+	func() {
+		defer func() {
+			p = recover()
+		}()
+		runWithRecovery(ctx, "Worker", func(context.Context) {
+			// streaming the subsegment needs the lock of its parent
+			root.Lock()
+			panic("MyPanic")
+		})
+	}()
+	root.Unlock()
+
+	assert.NotNil(t, p)
+	root.Close(nil)
+}
+
 // Benchmarks
 func BenchmarkCapture(b *testing.B) {
 	ctx, seg := BeginSegment(context.Background(), "TestCaptureSeg")
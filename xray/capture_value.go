@@ -0,0 +1,67 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import "context"
+
+// CaptureValue traces fn like Capture, and returns the value it returns.
+// The zero value of T is returned along with the error when fn fails, so
+// that results of failed calls can't be used by mistake.
+func CaptureValue[T any](ctx context.Context, name string, fn func(context.Context) (T, error)) (T, error) {
+	var v T
+	err := Capture(ctx, name, func(ctx context.Context) (err error) {
+		v, err = fn(ctx)
+		return err
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+}
+
+// CaptureValue2 traces fn like Capture, and returns the two values it
+// returns. Their zero values are returned along with the error when fn fails.
+func CaptureValue2[T1, T2 any](ctx context.Context, name string, fn func(context.Context) (T1, T2, error)) (T1, T2, error) {
+	var v1 T1
+	var v2 T2
+	err := Capture(ctx, name, func(ctx context.Context) (err error) {
+		v1, v2, err = fn(ctx)
+		return err
+	})
+	if err != nil {
+		var zero1 T1
+		var zero2 T2
+		return zero1, zero2, err
+	}
+	return v1, v2, nil
+}
+
+// CaptureValueAsync traces fn within a goroutine like CaptureAsync, and
+// passes the value and error it returns to done, in the same goroutine once
+// the subsegment is closed. The zero value of T is passed along with the
+// error when fn fails.
+func CaptureValueAsync[T any](ctx context.Context, name string, fn func(context.Context) (T, error), done func(T, error)) {
+	started := make(chan struct{})
+	go func() {
+		var v T
+		var err error
+		captureAsync(ctx, name, func(ctx context.Context) error {
+			close(started)
+			v, err = fn(ctx)
+			return err
+		})
+		if err != nil {
+			var zero T
+			v = zero
+		}
+		done(v, err)
+	}()
+	<-started
+}
@@ -0,0 +1,227 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type order struct {
+	ID    string
+	Total int
+}
+
+// orderRepository stands in for a repository backed by a database.
+type orderRepository struct {
+	orders map[string]*order
+}
+
+var errOrderNotFound = errors.New("order not found")
+
+func (r *orderRepository) Find(ctx context.Context, id string) (*order, error) {
+	if o, ok := r.orders[id]; ok {
+		return o, nil
+	}
+	return nil, errOrderNotFound
+}
+
+func (r *orderRepository) List(ctx context.Context) ([]*order, int, error) {
+	var orders []*order
+	for _, o := range r.orders {
+		orders = append(orders, o)
+	}
+	return orders, len(orders), nil
+}
+
+func newOrderRepository() *orderRepository {
+	return &orderRepository{orders: map[string]*order{"42": {ID: "42", Total: 1999}}}
+}
+
+// recvCaptureSubsegment closes root and returns its only subsegment.
+func recvCaptureSubsegment(t *testing.T, td *TestDaemon, root *Segment) *Segment {
+	root.Close(nil)
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) || !assert.Len(t, seg.Subsegments, 1) {
+		t.FailNow()
+	}
+	var subseg *Segment
+	assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg))
+	return subseg
+}
+
+func TestCaptureValue(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "Test")
+
+	repo := newOrderRepository()
+	o, err := CaptureValue(ctx, "orders.Find", func(ctx context.Context) (*order, error) {
+		return repo.Find(ctx, "42")
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, repo.orders["42"], o)
+
+	subseg := recvCaptureSubsegment(t, td, root)
+	assert.Equal(t, "orders.Find", subseg.Name)
+	assert.False(t, subseg.Fault)
+}
+
+func TestCaptureValueError(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "Test")
+
+	n, err := CaptureValue(ctx, "count", func(context.Context) (int, error) {
+		return 3, errOrderNotFound
+	})
+	assert.ErrorIs(t, err, errOrderNotFound)
+	assert.Zero(t, n)
+
+	subseg := recvCaptureSubsegment(t, td, root)
+	assert.True(t, subseg.Fault)
+	assert.Equal(t, errOrderNotFound.Error(), subseg.Cause.Exceptions[0].Message)
+}
+
+func TestCaptureValueWithoutSegment(t *testing.T) {
+	strategy := &countingContextMissingStrategy{}
+	ctx, err := ContextWithConfig(context.Background(), Config{ContextMissingStrategy: strategy})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	n, err := CaptureValue(ctx, "count", func(context.Context) (int, error) {
+		return 3, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	n, err = CaptureValue(ctx, "count", func(context.Context) (int, error) {
+		return 3, errOrderNotFound
+	})
+	assert.ErrorIs(t, err, errOrderNotFound)
+	assert.Zero(t, n)
+
+	// as with Capture, when beginning and closing each subsegment
+	assert.Equal(t, 4, strategy.count)
+}
+
+func TestCaptureValue2(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "Test")
+
+	orders, total, err := CaptureValue2(ctx, "orders.List", newOrderRepository().List)
+	assert.NoError(t, err)
+	assert.Len(t, orders, 1)
+	assert.Equal(t, 1, total)
+
+	subseg := recvCaptureSubsegment(t, td, root)
+	assert.Equal(t, "orders.List", subseg.Name)
+}
+
+func TestCaptureValue2Error(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "Test")
+
+	s, n, err := CaptureValue2(ctx, "lookup", func(context.Context) (string, int, error) {
+		return "partial", 1, errOrderNotFound
+	})
+	assert.ErrorIs(t, err, errOrderNotFound)
+	assert.Empty(t, s)
+	assert.Zero(t, n)
+
+	subseg := recvCaptureSubsegment(t, td, root)
+	assert.True(t, subseg.Fault)
+}
+
+func TestCaptureValueAsync(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "Test")
+
+	type result struct {
+		o   *order
+		err error
+	}
+	results := make(chan result, 2)
+	var subsegs []*Segment
+	repo := newOrderRepository()
+	for _, id := range []string{"42", "7"} {
+		id := id
+		CaptureValueAsync(ctx, "orders.Find", func(ctx context.Context) (*order, error) {
+			subsegs = append(subsegs, GetSegment(ctx))
+			return repo.Find(ctx, id)
+		}, func(o *order, err error) {
+			results <- result{o, err}
+		})
+		// the subsegment is closed before done is called
+		r := <-results
+		assert.False(t, subsegs[len(subsegs)-1].InProgress)
+		if id == "42" {
+			assert.NoError(t, r.err)
+			assert.Equal(t, repo.orders["42"], r.o)
+		} else {
+			assert.ErrorIs(t, r.err, errOrderNotFound)
+			assert.Nil(t, r.o)
+		}
+	}
+
+	root.Close(nil)
+	seg, err := td.Recv()
+	if assert.NoError(t, err) {
+		assert.Len(t, seg.Subsegments, 2)
+	}
+}
+
+func ExampleCaptureValue() {
+	ctx, seg := BeginSegment(context.Background(), "orders")
+	defer seg.Close(nil)
+
+	repo := newOrderRepository()
+	o, err := CaptureValue(ctx, "OrderRepository.Find", func(ctx context.Context) (*order, error) {
+		return repo.Find(ctx, "42")
+	})
+	if err != nil {
+		return
+	}
+	fmt.Println(o.Total)
+}
+
+func ExampleCaptureValue2() {
+	ctx, seg := BeginSegment(context.Background(), "orders")
+	defer seg.Close(nil)
+
+	repo := newOrderRepository()
+	orders, total, err := CaptureValue2(ctx, "OrderRepository.List", repo.List)
+	if err != nil {
+		return
+	}
+	fmt.Println(len(orders), total)
+}
+
+func ExampleCaptureValueAsync() {
+	ctx, seg := BeginSegment(context.Background(), "orders")
+	defer seg.Close(nil)
+
+	repo := newOrderRepository()
+	found := make(chan *order, 1)
+	CaptureValueAsync(ctx, "OrderRepository.Find", func(ctx context.Context) (*order, error) {
+		return repo.Find(ctx, "42")
+	}, func(o *order, err error) {
+		found <- o
+	})
+	fmt.Println((<-found).Total)
+}
@@ -9,12 +9,15 @@
 package xray
 
 import (
-	"context"
+	"io"
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 )
@@ -42,18 +45,128 @@ func Client(c *http.Client) *http.Client {
 
 // RoundTripper wraps the provided http roundtripper with xray.Capture,
 // sets HTTP-specific xray fields, and adds the trace header to the outbound request.
+// The subsegment of a response without a Content-Length header, such as a
+// chunked one, is closed once its body is read to the end or closed, recording
+// the number of bytes read as the content length, or after a minute at most.
 func RoundTripper(rt http.RoundTripper) http.RoundTripper {
-	return &roundtripper{rt}
+	return RoundTripperWithOptions(rt)
+}
+
+// RoundTripperOption configures the roundtripper returned by RoundTripperWithOptions.
+type RoundTripperOption func(rt *roundtripper)
+
+// WithDownloadTiming annotates the remote subsegment with the time to the first
+// response byte ("ttfb_ms") and the time spent reading the response body after
+// it ("download_ms"). The download time is recorded when the body is read to
+// the end or closed, whichever happens first, and the subsegment is closed
+// then, or after a minute if the body is abandoned.
+func WithDownloadTiming() RoundTripperOption {
+	return func(rt *roundtripper) {
+		rt.downloadTiming = true
+	}
+}
+
+// WithResponseBodyLength records the number of bytes of the body of responses
+// without a Content-Length header, such as chunked or decompressed ones, as
+// their content length. The subsegment of such a request is closed only once
+// its body is read to the end or closed, or after a minute otherwise, and its
+// segment isn't emitted before, so the body should always be closed.
+func WithResponseBodyLength() RoundTripperOption {
+	return func(rt *roundtripper) {
+		rt.responseBodyLength = true
+	}
+}
+
+// WithProviderLabeler annotates the remote subsegment with the logical
+// provider of the request ("provider"), e.g. "twilio", as returned by labeler.
+// No annotation is added when labeler returns an empty string.
+func WithProviderLabeler(labeler func(r *http.Request) string) RoundTripperOption {
+	return func(rt *roundtripper) {
+		rt.providerLabeler = labeler
+	}
+}
+
+// ResponseClassifier reports whether a response is an error, and whether it
+// was throttled, e.g. for providers returning errors in 200 OK responses.
+// A classifier reading the response body must replace it for the caller.
+type ResponseClassifier func(resp *http.Response) (isError bool, isThrottle bool)
+
+// WithResponseClassifier classifies responses with classifier instead of by
+// their 4xx and 429 status codes. Responses with a 5xx status code are still
+// recorded as faults.
+func WithResponseClassifier(classifier ResponseClassifier) RoundTripperOption {
+	return func(rt *roundtripper) {
+		rt.classifier = classifier
+	}
+}
+
+// WithSubsegmentName names the remote subsegment as returned by name, e.g.
+// after the logical service called rather than a regional host name. The
+// subsegment is named after the request host when name returns an empty
+// string.
+func WithSubsegmentName(name func(r *http.Request) string) RoundTripperOption {
+	return func(rt *roundtripper) {
+		rt.name = name
+	}
+}
+
+// WithTraceHeaderSuppression doesn't add the trace header to the requests
+// for which suppress returns true, e.g. to third-party APIs which must not
+// receive it. Their remote subsegments are still recorded.
+func WithTraceHeaderSuppression(suppress func(r *http.Request) bool) RoundTripperOption {
+	return func(rt *roundtripper) {
+		rt.suppressTraceHeader = suppress
+	}
+}
+
+// responseHeadersNamespace is the metadata namespace of the response
+// headers recorded with WithResponseHeaders.
+const responseHeadersNamespace = "http.response_headers"
+
+// WithResponseHeaders records the listed response headers in the
+// http.response_headers metadata namespace of the remote subsegment.
+// Hop-by-hop headers and the Set-Cookie and Proxy-Authenticate headers are
+// never recorded, even if listed.
+func WithResponseHeaders(names ...string) RoundTripperOption {
+	return func(rt *roundtripper) {
+		rt.responseHeaders = capturedHeaders(names, "response")
+	}
+}
+
+// WithClientTraceOptions configures the connection subsegments of the remote
+// subsegment, see ClientTraceOptions.
+func WithClientTraceOptions(opts ClientTraceOptions) RoundTripperOption {
+	return func(rt *roundtripper) {
+		rt.clientTrace = opts
+	}
+}
+
+// RoundTripperWithOptions wraps the provided http roundtripper like RoundTripper,
+// applying the given options.
+func RoundTripperWithOptions(rt http.RoundTripper, opts ...RoundTripperOption) http.RoundTripper {
+	r := &roundtripper{Base: rt}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 type roundtripper struct {
-	Base http.RoundTripper
+	Base            http.RoundTripper
+	downloadTiming  bool
+	providerLabeler func(r *http.Request) string
+	classifier      ResponseClassifier
+
+	name                func(r *http.Request) string
+	suppressTraceHeader func(r *http.Request) bool
+	responseHeaders     []string
+	responseBodyLength  bool
+	clientTrace         ClientTraceOptions
 }
 
 // RoundTrip wraps a single HTTP transaction and add corresponding information into a subsegment.
 func (rt *roundtripper) RoundTrip(r *http.Request) (*http.Response, error) {
 	var isEmptyHost bool
-	var resp *http.Response
 	host := r.Host
 	if host == "" {
 		if h := r.URL.Host; h != "" {
@@ -63,60 +176,124 @@ func (rt *roundtripper) RoundTrip(r *http.Request) (*http.Response, error) {
 			isEmptyHost = true
 		}
 	}
-
-	err := Capture(r.Context(), host, func(ctx context.Context) error {
-		var err error
-		seg := GetSegment(ctx)
-		if seg == nil {
-			resp, err = rt.Base.RoundTrip(r)
-			logger.Warnf("failed to record HTTP transaction: segment cannot be found.")
-			return err
+	name := host
+	if rt.name != nil {
+		if n := rt.name(r); n != "" {
+			name = n
 		}
+	}
+
+	// The subsegment is closed like Capture does, unless the response body
+	// closes it once read, see responseBody.
+	ctx, seg := BeginSubsegment(r.Context(), name)
+	if seg == nil {
+		resp, err := rt.Base.RoundTrip(r)
+		logger.Warnf("failed to record HTTP transaction: segment cannot be found.")
+		captureContextMissing(r.Context(), name)
+		return resp, err
+	}
 
-		ct, e := NewClientTrace(ctx)
-		if e != nil {
-			return e
+	var body *responseBody
+	var closeErr error
+	defer func() {
+		if p := recover(); p != nil {
+			seg.Close(seg.ParentSegment.GetConfiguration().ExceptionFormattingStrategy.Panicf("%v", p))
+			panic(p)
 		}
-		r = r.WithContext(httptrace.WithClientTrace(ctx, ct.httpTrace))
+		if body != nil {
+			body.closeSegmentAfter(closeErr, responseBodyTimeout)
+		} else {
+			seg.Close(closeErr)
+		}
+	}()
 
-		seg.Lock()
+	start := time.Now()
+	ct, err := NewClientTraceWithOptions(ctx, rt.clientTrace)
+	if err != nil {
+		closeErr = err
+		return nil, err
+	}
+	r = r.WithContext(httptrace.WithClientTrace(ctx, ct.httpTrace))
 
-		if isEmptyHost {
-			seg.Namespace = ""
-		} else {
-			seg.Namespace = "remote"
+	seg.Lock()
+
+	if isEmptyHost {
+		seg.Namespace = ""
+	} else {
+		seg.Namespace = "remote"
+	}
+
+	seg.GetHTTP().GetRequest().Method = r.Method
+	seg.GetHTTP().GetRequest().URL = stripURL(*r.URL)
+	seg.addDeadlineAnnotation(r.Context(), "remaining_budget_ms")
+	if rt.providerLabeler != nil && !seg.Dummy {
+		if provider := rt.providerLabeler(r); provider != "" {
+			if seg.Annotations == nil {
+				seg.Annotations = map[string]interface{}{}
+			}
+			seg.Annotations["provider"] = provider
 		}
+	}
+
+	hedge := hedgeGroupFromContext(ctx)
+	var attempt int
+	if hedge != nil {
+		attempt = hedge.begin(seg)
+	}
 
-		seg.GetHTTP().GetRequest().Method = r.Method
-		seg.GetHTTP().GetRequest().URL = stripURL(*r.URL)
+	if rt.suppressTraceHeader == nil || !rt.suppressTraceHeader(r) {
+		injectTraceHeader(seg, r.Header)
+	}
+	seg.Unlock()
 
-		r.Header.Set(TraceIDHeaderKey, seg.DownstreamHeader().String())
-		seg.Unlock()
+	resp, err := rt.Base.RoundTrip(r)
 
-		resp, err = rt.Base.RoundTrip(r)
+	if resp != nil {
+		var isError, isThrottle bool
+		if rt.classifier != nil {
+			isError, isThrottle = rt.classifier(resp)
+		} else {
+			isError = resp.StatusCode >= 400 && resp.StatusCode < 500
+			isThrottle = resp.StatusCode == 429
+		}
 
-		if resp != nil {
-			seg.Lock()
-			seg.GetHTTP().GetResponse().Status = resp.StatusCode
-			seg.GetHTTP().GetResponse().ContentLength, _ = strconv.Atoi(resp.Header.Get("Content-Length"))
+		seg.Lock()
+		seg.GetHTTP().GetResponse().Status = resp.StatusCode
+		seg.GetHTTP().GetResponse().ContentLength, _ = strconv.Atoi(resp.Header.Get("Content-Length"))
 
-			if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-				seg.Error = true
-			}
-			if resp.StatusCode == 429 {
-				seg.Throttle = true
-			}
-			if resp.StatusCode >= 500 && resp.StatusCode < 600 {
-				seg.Fault = true
-			}
-			seg.Unlock()
+		if isError || isThrottle {
+			seg.Error = true
+		}
+		if isThrottle {
+			seg.Throttle = true
+		}
+		if resp.StatusCode >= 500 && resp.StatusCode < 600 {
+			seg.Fault = true
+		}
+		var firstByte time.Time
+		if rt.downloadTiming {
+			firstByte = annotateTimeToFirstByte(seg, start, ct.subsegments.firstResponseByte())
 		}
-		if err != nil {
-			ct.subsegments.GotConn(nil, err)
+		countBytes := rt.responseBodyLength && resp.Header.Get("Content-Length") == ""
+		if body = newResponseBody(seg, resp, firstByte, countBytes); body != nil {
+			resp.Body = body
 		}
+		seg.Unlock()
 
-		return err
-	})
+		for _, key := range rt.responseHeaders {
+			if values := resp.Header.Values(key); len(values) > 0 {
+				seg.AddMetadataToNamespace(responseHeadersNamespace, key, strings.Join(values, ", "))
+			}
+		}
+	}
+	if err != nil {
+		ct.subsegments.GotConn(nil, err)
+	}
+	// the error of an abandoned hedge attempt is returned but not recorded
+	if hedge == nil || !hedge.finish(seg, attempt, err) {
+		closeErr = err
+	}
+	ct.subsegments.done(closeErr)
 	return resp, err
 }
 
@@ -128,3 +305,98 @@ func stripURL(u url.URL) string {
 	}
 	return u.String()
 }
+
+// responseBodyTimeout bounds how long the subsegment of a request waits for
+// the response body to be read or closed, see responseBody.
+var responseBodyTimeout = time.Minute
+
+// annotateTimeToFirstByte annotates seg with the time to the first response
+// byte and returns the time of that byte.
+// Only called within a seg locked code block.
+func annotateTimeToFirstByte(seg *Segment, start, firstByte time.Time) time.Time {
+	if firstByte.IsZero() {
+		firstByte = time.Now()
+	}
+	if !seg.Dummy {
+		if seg.Annotations == nil {
+			seg.Annotations = map[string]interface{}{}
+		}
+		seg.Annotations["ttfb_ms"] = float64(firstByte.Sub(start)) / float64(time.Millisecond)
+	}
+	return firstByte
+}
+
+// newResponseBody wraps the body of resp to record the time spent downloading
+// it if firstByte is set, and its length if countBytes is set. It returns nil
+// if there's nothing to record.
+// Only called within a seg locked code block.
+func newResponseBody(seg *Segment, resp *http.Response, firstByte time.Time, countBytes bool) *responseBody {
+	if seg.Dummy || seg.isDisabled() || firstByte.IsZero() && !countBytes {
+		return nil
+	}
+	// Bodies of upgraded connections are also writable, and empty bodies have
+	// nothing to download.
+	if resp.StatusCode == http.StatusSwitchingProtocols || resp.Body == nil || resp.Body == http.NoBody {
+		return nil
+	}
+	return &responseBody{ReadCloser: resp.Body, seg: seg, firstByte: firstByte, countBytes: countBytes}
+}
+
+// responseBody closes the subsegment of a request once the response body is
+// read to the end or closed, so that what's only known then can be recorded:
+// the time from the first response byte ("download_ms"), and the number of
+// bytes read as the content length if the body was read to the end. If the
+// body is neither read nor closed in time, the subsegment is closed anyway so
+// that it doesn't hold its segment open.
+type responseBody struct {
+	io.ReadCloser
+	seg        *Segment
+	firstByte  time.Time // zero unless the download time is recorded
+	countBytes bool
+	read       atomic.Int64
+
+	err   error // the subsegment is closed with
+	timer *time.Timer
+	once  sync.Once
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read.Add(int64(n))
+	if err == io.EOF {
+		b.done(true)
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	b.done(false)
+	return b.ReadCloser.Close()
+}
+
+// closeSegmentAfter sets the error the subsegment is closed with, and closes
+// it after timeout unless the body is done by then.
+func (b *responseBody) closeSegmentAfter(err error, timeout time.Duration) {
+	b.err = err
+	b.timer = time.AfterFunc(timeout, func() {
+		b.once.Do(func() { b.seg.Close(b.err) })
+	})
+}
+
+func (b *responseBody) done(eof bool) {
+	b.once.Do(func() {
+		b.timer.Stop()
+		b.seg.Lock()
+		if !b.firstByte.IsZero() {
+			if b.seg.Annotations == nil {
+				b.seg.Annotations = map[string]interface{}{}
+			}
+			b.seg.Annotations["download_ms"] = float64(time.Since(b.firstByte)) / float64(time.Millisecond)
+		}
+		if eof && b.countBytes {
+			b.seg.GetHTTP().GetResponse().ContentLength = int(b.read.Load())
+		}
+		b.seg.Unlock()
+		b.seg.Close(b.err)
+	})
+}
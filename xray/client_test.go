@@ -9,6 +9,7 @@
 package xray
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -17,9 +18,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-xray-sdk-go/header"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/http2"
 )
@@ -517,3 +521,700 @@ func BenchmarkClient(b *testing.B) {
 		Client(nil)
 	}
 }
+
+func TestRoundTripRemainingBudget(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := Client(nil)
+	get := func(ctx context.Context) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp.Body.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	ctx, root := BeginSegment(ctx, "Test")
+
+	ctx, cancel = context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	get(ctx)
+
+	ctx, work := BeginSubsegment(ctx, "work")
+	ctx, cancel = context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	get(ctx)
+	work.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, seg.Subsegments, 2)
+
+	var outer, workSeg, inner *Segment
+	for _, b := range seg.Subsegments {
+		var sub *Segment
+		if !assert.NoError(t, json.Unmarshal(b, &sub)) {
+			return
+		}
+		if sub.Name == "work" {
+			workSeg = sub
+		} else {
+			outer = sub
+		}
+	}
+	if !assert.NotNil(t, outer) || !assert.NotNil(t, workSeg) || !assert.Len(t, workSeg.Subsegments, 1) {
+		return
+	}
+	assert.NoError(t, json.Unmarshal(workSeg.Subsegments[0], &inner))
+
+	deadline := seg.Annotations["deadline_ms"].(float64)
+	outerBudget := outer.Annotations["remaining_budget_ms"].(float64)
+	innerBudget := inner.Annotations["remaining_budget_ms"].(float64)
+	assert.InDelta(t, 3000, deadline, 100)
+	assert.Less(t, outerBudget, deadline)
+	assert.Less(t, innerBudget, outerBudget)
+	assert.InDelta(t, 1000, innerBudget, 100)
+	assert.NotContains(t, workSeg.Annotations, "remaining_budget_ms")
+}
+
+func TestRoundTripDownloadTiming(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	// the subsegment of a body never read is closed before td.Recv times out
+	defer func(timeout time.Duration) { responseBodyTimeout = timeout }(responseBodyTimeout)
+	responseBodyTimeout = 300 * time.Millisecond
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("first chunk"))
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("second chunk"))
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: RoundTripperWithOptions(http.DefaultTransport, WithDownloadTiming())}
+
+	tests := []struct {
+		name     string
+		read     func(resp *http.Response)
+		download bool
+		min      float64
+	}{
+		{
+			name: "read body",
+			read: func(resp *http.Response) {
+				_, _ = io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+			},
+			download: true,
+			min:      150,
+		},
+		{
+			name: "close early",
+			read: func(resp *http.Response) {
+				resp.Body.Close()
+			},
+			download: true,
+		},
+		{
+			name:     "never read",
+			read:     func(resp *http.Response) {},
+			download: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, root, req, err := newRequest(ctx, http.MethodGet, ts.URL, nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			resp, err := client.Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			tt.read(resp)
+			root.Close(nil)
+
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			var subseg *Segment
+			if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+				return
+			}
+			ttfb := subseg.Annotations["ttfb_ms"].(float64)
+			assert.Less(t, ttfb, float64(150))
+			if tt.download {
+				download := subseg.Annotations["download_ms"].(float64)
+				assert.GreaterOrEqual(t, download, tt.min)
+			} else {
+				assert.NotContains(t, subseg.Annotations, "download_ms")
+			}
+			resp.Body.Close()
+		})
+	}
+}
+
+func TestRoundTripChunkedResponseContentLength(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("first chunk"))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("second chunk"))
+	}))
+	defer ts.Close()
+
+	_, root, req, err := newRequest(ctx, http.MethodGet, ts.URL, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	client := &http.Client{Transport: RoundTripperWithOptions(http.DefaultTransport, WithResponseBodyLength())}
+	resp, err := client.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+	// the subsegment is closed with the body
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		assert.Equal(t, len("first chunksecond chunk"), subseg.HTTP.Response.ContentLength)
+		assert.Equal(t, http.StatusOK, subseg.HTTP.Response.Status)
+	}
+}
+
+func TestRoundTripChunkedResponseWithoutBodyLength(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("first chunk"))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("second chunk"))
+	}))
+	defer ts.Close()
+
+	_, root, req, err := newRequest(ctx, http.MethodGet, ts.URL, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := Client(nil).Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	// the subsegment doesn't wait for the body
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		assert.Equal(t, 0, subseg.HTTP.Response.ContentLength)
+		assert.Equal(t, http.StatusOK, subseg.HTTP.Response.Status)
+	}
+}
+
+func TestRoundTripWithoutDownloadTiming(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	if !assert.NoError(t, httpDoTest(ctx, Client(nil), http.MethodGet, ts.URL, nil)) {
+		return
+	}
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		assert.NotContains(t, subseg.Annotations, "ttfb_ms")
+		assert.NotContains(t, subseg.Annotations, "download_ms")
+	}
+}
+
+func TestRoundTripProviderAndClassifier(t *testing.T) {
+	// classifyBody classifies responses like providers returning errors in
+	// 200 OK responses, restoring the body for the caller.
+	classifyBody := func(resp *http.Response) (bool, bool) {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return true, false
+		}
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		switch {
+		case bytes.Contains(body, []byte(`"status":429`)), bytes.Contains(body, []byte("<Code>Throttling</Code>")):
+			return true, true
+		case bytes.Contains(body, []byte("<ErrorResponse>")):
+			return true, false
+		}
+		return false, false
+	}
+
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		provider string
+		error    bool
+		throttle bool
+	}{
+		{name: "json throttle", status: http.StatusOK, body: `{"code":20429,"status":429}`, provider: "twilio", error: true, throttle: true},
+		{name: "xml throttle", status: http.StatusOK, body: `<ErrorResponse><Error><Code>Throttling</Code></Error></ErrorResponse>`, provider: "ses", error: true, throttle: true},
+		{name: "xml error", status: http.StatusOK, body: `<ErrorResponse><Error><Code>MessageRejected</Code></Error></ErrorResponse>`, provider: "ses", error: true},
+		{name: "not found", status: http.StatusNotFound, body: `{}`, provider: "twilio"},
+		{name: "success", status: http.StatusOK, body: `{}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				if _, err := w.Write([]byte(tt.body)); err != nil {
+					panic(err)
+				}
+			}))
+			defer ts.Close()
+
+			client := &http.Client{
+				Transport: RoundTripperWithOptions(http.DefaultTransport,
+					WithProviderLabeler(func(r *http.Request) string { return tt.provider }),
+					WithResponseClassifier(classifyBody),
+				),
+			}
+
+			_, root, req, err := newRequest(ctx, http.MethodGet, ts.URL, nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			resp, err := client.Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.body, string(body))
+			resp.Body.Close()
+			root.Close(nil)
+
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			var subseg *Segment
+			if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+				assert.Equal(t, tt.status, subseg.HTTP.Response.Status)
+				assert.Equal(t, tt.error, subseg.Error)
+				assert.Equal(t, tt.throttle, subseg.Throttle)
+				assert.False(t, subseg.Fault)
+				if tt.provider != "" {
+					assert.Equal(t, tt.provider, subseg.Annotations["provider"])
+				} else {
+					assert.NotContains(t, subseg.Annotations, "provider")
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTripTraceHeaderSuppressionAndName(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	traceHeaders := make(chan string, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceHeaders <- r.Header.Get(TraceIDHeaderKey)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := &http.Client{
+		Transport: RoundTripperWithOptions(http.DefaultTransport,
+			WithSubsegmentName(func(r *http.Request) string {
+				if r.URL.Path == "/charges" {
+					return "payments-api"
+				}
+				return ""
+			}),
+			WithTraceHeaderSuppression(func(r *http.Request) bool { return r.URL.Path == "/charges" }),
+		),
+	}
+
+	ctx, root := BeginSegment(ctx, "test")
+	for _, path := range []string{"/charges", "/internal"} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+path, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp, err := client.Do(req)
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp.Body.Close()
+	}
+	root.Close(nil)
+
+	assert.Empty(t, <-traceHeaders)
+	assert.NotEmpty(t, <-traceHeaders)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) || !assert.Len(t, seg.Subsegments, 2) {
+		return
+	}
+	var suppressed, traced *Segment
+	assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &suppressed))
+	assert.NoError(t, json.Unmarshal(seg.Subsegments[1], &traced))
+	assert.Equal(t, "payments-api", suppressed.Name)
+	assert.Equal(t, "remote", suppressed.Namespace)
+	assert.Equal(t, http.StatusOK, suppressed.HTTP.Response.Status)
+	assert.Equal(t, strings.TrimPrefix(ts.URL, "http://"), traced.Name)
+}
+
+func TestRoundTripResponseHeaders(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "abc")
+		w.Header().Add("X-Ratelimit-Remaining", "10")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := &http.Client{
+		Transport: RoundTripperWithOptions(http.DefaultTransport, WithResponseHeaders("x-request-id", "X-RateLimit-Remaining", "Set-Cookie", "X-Missing")),
+	}
+	if !assert.NoError(t, httpDoTest(ctx, client, http.MethodGet, ts.URL, nil)) {
+		return
+	}
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		assert.Equal(t, map[string]interface{}{
+			"X-Request-Id":          "abc",
+			"X-Ratelimit-Remaining": "10",
+		}, subseg.Metadata[responseHeadersNamespace])
+	}
+}
+
+func TestRoundTripCompactConnectionMetrics(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	for _, compact := range []bool{false, true} {
+		ctx, td := NewTestDaemon()
+		defer td.Close()
+
+		transport := ts.Client().Transport.(*http.Transport).Clone()
+		defer transport.CloseIdleConnections()
+		client := &http.Client{
+			Transport: RoundTripperWithOptions(transport, WithClientTraceOptions(ClientTraceOptions{CompactConnectionMetrics: compact})),
+		}
+
+		// the second request reuses the connection of the first one
+		for _, reused := range []bool{false, true} {
+			if !assert.NoError(t, httpDoTest(ctx, client, http.MethodGet, ts.URL, nil)) {
+				return
+			}
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			var subseg *Segment
+			if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+				return
+			}
+
+			var names []string
+			for _, sub := range subseg.Subsegments {
+				var s *Segment
+				if assert.NoError(t, json.Unmarshal(sub, &s)) {
+					names = append(names, s.Name)
+				}
+			}
+			assert.Contains(t, names, "request")
+
+			if !compact {
+				if !reused {
+					assert.Contains(t, names, "connect")
+				}
+				assert.NotContains(t, subseg.Metadata["http"], "connection")
+				continue
+			}
+
+			assert.NotContains(t, names, "connect")
+			connection, ok := subseg.Metadata["http"]["connection"].(map[string]interface{})
+			if !assert.True(t, ok) {
+				continue
+			}
+			assert.Equal(t, reused, connection["reused"])
+			assert.Contains(t, connection, "was_idle")
+			if reused {
+				assert.NotContains(t, connection, "dial_ms")
+				assert.NotContains(t, connection, "tls_ms")
+			} else {
+				assert.Contains(t, connection, "dial_ms")
+				assert.Contains(t, connection, "tls_ms")
+			}
+		}
+	}
+}
+
+// unmarshalSubsegments returns the subsegments of seg by name.
+func unmarshalSubsegments(t *testing.T, seg *Segment) map[string]*Segment {
+	subs := make(map[string]*Segment)
+	for _, raw := range seg.Subsegments {
+		var sub *Segment
+		if assert.NoError(t, json.Unmarshal(raw, &sub)) {
+			subs[sub.Name] = sub
+		}
+	}
+	return subs
+}
+
+func TestRoundTripRetry(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	var mu sync.Mutex
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+		// the second request finds the kept-alive connection closed, and is
+		// retried by net/http on a new one
+		if n == 2 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if assert.NoError(t, err) {
+				conn.Close()
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: RoundTripper(transport)}
+
+	// a single attempt isn't nested
+	if !assert.NoError(t, httpDoTest(ctx, client, http.MethodGet, ts.URL, nil)) {
+		return
+	}
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		return
+	}
+	subs := unmarshalSubsegments(t, subseg)
+	assert.Contains(t, subs, "connect")
+	assert.Contains(t, subs, "request")
+	assert.Contains(t, subs, "response")
+	assert.NotContains(t, subs, "attempt #1")
+	assert.NotContains(t, subseg.Metadata["http"], "retries")
+
+	if !assert.NoError(t, httpDoTest(ctx, client, http.MethodGet, ts.URL, nil)) {
+		return
+	}
+	seg, err = td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		return
+	}
+	assert.False(t, subseg.Fault)
+	assert.Equal(t, http.StatusOK, subseg.HTTP.Response.Status)
+	assert.Equal(t, 2.0, subseg.Metadata["http"]["attempts"])
+	assert.Equal(t, 1.0, subseg.Metadata["http"]["retries"])
+
+	subs = unmarshalSubsegments(t, subseg)
+	assert.Len(t, subs, 2)
+	first, second := subs["attempt #1"], subs["attempt #2"]
+	if !assert.NotNil(t, first) || !assert.NotNil(t, second) {
+		return
+	}
+	assert.True(t, first.Fault)
+	assert.False(t, first.InProgress)
+	assert.LessOrEqual(t, first.EndTime, second.StartTime)
+
+	// the first attempt reused the connection of the first request
+	subs = unmarshalSubsegments(t, first)
+	assert.NotContains(t, subs, "connect")
+	assert.Contains(t, subs, "request")
+	if assert.Contains(t, subs, "response") {
+		assert.False(t, subs["response"].InProgress)
+	}
+
+	assert.False(t, second.Fault)
+	assert.False(t, second.InProgress)
+	subs = unmarshalSubsegments(t, second)
+	assert.Contains(t, subs, "connect")
+	assert.Contains(t, subs, "request")
+	assert.Contains(t, subs, "response")
+}
+
+func TestRoundTripExpectContinue(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// reading the body sends the 100 Continue response
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	transport := &http.Transport{ExpectContinueTimeout: time.Minute}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: RoundTripper(transport)}
+
+	_, root, req, err := newRequest(ctx, http.MethodPost, ts.URL, strings.NewReader("body"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	req.Header.Set("Expect", "100-continue")
+	resp, err := client.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if !assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		return
+	}
+	subs := unmarshalSubsegments(t, subseg)
+	if assert.Contains(t, subs, "request") {
+		cont := unmarshalSubsegments(t, subs["request"])["continue"]
+		if assert.NotNil(t, cont) {
+			assert.False(t, cont.InProgress)
+		}
+	}
+	// the first byte received was the one of the interim response
+	if assert.Contains(t, subs, "response") {
+		assert.False(t, subs["response"].InProgress)
+	}
+	assert.NotContains(t, subseg.Metadata["http"], "retries")
+}
+
+// TestPassthroughChain calls a far server through a passthrough service,
+// which forwards the incoming trace header without recording segments.
+func TestPassthroughChain(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	t.Setenv("AWS_XRAY_SDK_DISABLED", "passthrough")
+
+	received := make(chan string, 1)
+	far := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(TraceIDHeaderKey)
+	}))
+	defer far.Close()
+
+	middle := httptest.NewServer(HandlerWithContext(ctx, NewFixedSegmentNamer("middle"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, far.URL, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp, err := Client(nil).Do(req)
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp.Body.Close()
+	})))
+	defer middle.Close()
+
+	in := "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+	req, _ := http.NewRequest(http.MethodGet, middle.URL, nil)
+	req.Header.Set(TraceIDHeaderKey, in)
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+
+	th := header.FromString(<-received)
+	assert.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", th.TraceID)
+	assert.Equal(t, "53995c3f42cd8ad8", th.ParentID)
+	assert.Equal(t, header.Sampled, th.SamplingDecision)
+
+	_, err = td.Recv()
+	assert.Error(t, err, "no segment is emitted")
+}
+
+func TestPassthroughConfig(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	GetRecorder(ctx).Passthrough = true
+
+	in := header.FromString("Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0;Foo=bar")
+	ctx, seg := NewSegmentFromHeader(ctx, "test", &http.Request{URL: &url.URL{}}, in)
+	ctx, subseg := BeginSubsegment(ctx, "sub")
+	assert.Equal(t, in, subseg.DownstreamHeader())
+	assert.Equal(t, in.String(), TraceHeaderValue(ctx))
+	subseg.Close(nil)
+	seg.Close(nil)
+
+	_, err := td.Recv()
+	assert.Error(t, err, "no segment is emitted")
+}
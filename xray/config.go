@@ -10,12 +10,19 @@ package xray
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
 	"os"
+	"reflect"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/daemoncfg"
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
+	"github.com/aws/aws-xray-sdk-go/internal/plugins"
 	"github.com/aws/aws-xray-sdk-go/xraylog"
 
 	"github.com/aws/aws-xray-sdk-go/strategy/ctxmissing"
@@ -29,11 +36,153 @@ const SDKVersion = "1.8.5"
 // SDKType records which X-Ray SDK customer uses.
 const SDKType = "X-Ray for Go"
 
+// GetSDKVersion returns the version of the X-Ray Go SDK reported in segments.
+func GetSDKVersion() string {
+	return SDKVersion
+}
+
 // SDK provides the shape for unmarshalling an SDK struct.
 type SDK struct {
 	Version  string `json:"sdk_version,omitempty"`
 	Type     string `json:"sdk,omitempty"`
 	RuleName string `json:"sampling_rule_name,omitempty"`
+
+	// Metadata holds the Config.InstrumentationMetadata entries, emitted
+	// alongside the SDK fields.
+	Metadata map[string]string `json:"-"`
+}
+
+// reservedSDKKeys are the aws.xray keys owned by the SDK.
+var reservedSDKKeys = map[string]bool{
+	"sdk_version":        true,
+	"sdk":                true,
+	"sampling_rule_name": true,
+}
+
+// MarshalJSON merges Metadata into the SDK object. Version, Type and
+// RuleName always take precedence over Metadata entries.
+func (sdk SDK) MarshalJSON() ([]byte, error) {
+	type sdkFields SDK
+	if len(sdk.Metadata) == 0 {
+		return json.Marshal(sdkFields(sdk))
+	}
+
+	fields := make(map[string]string, len(sdk.Metadata)+3)
+	for k, v := range sdk.Metadata {
+		if !reservedSDKKeys[k] {
+			fields[k] = v
+		}
+	}
+	if sdk.Version != "" {
+		fields["sdk_version"] = sdk.Version
+	}
+	if sdk.Type != "" {
+		fields["sdk"] = sdk.Type
+	}
+	if sdk.RuleName != "" {
+		fields["sampling_rule_name"] = sdk.RuleName
+	}
+	return json.Marshal(fields)
+}
+
+// validateInstrumentationMetadata returns a copy of m, or an error if any of
+// its keys is empty, too long, contains characters other than letters,
+// digits, '_', '-' and '.', or is reserved by the SDK.
+func validateInstrumentationMetadata(m map[string]string) (map[string]string, error) {
+	ret := make(map[string]string, len(m))
+	for k, v := range m {
+		if reservedSDKKeys[k] {
+			return nil, fmt.Errorf("instrumentation metadata key %q is reserved by the SDK", k)
+		}
+		if k == "" || len(k) > 64 {
+			return nil, fmt.Errorf("instrumentation metadata key %q must be between 1 and 64 characters", k)
+		}
+		for _, c := range k {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+				return nil, fmt.Errorf("instrumentation metadata key %q contains invalid character %q", k, c)
+			}
+		}
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+// Errors reported by Configure and ContextWithConfig, wrapped with details.
+// Several errors are reported as an exception.MultiError, which matches
+// each of them with errors.Is.
+var (
+	// ErrInvalidDaemonAddr is reported for a DaemonAddr, or an
+	// AWS_XRAY_DAEMON_ADDRESS environment variable, that can't be parsed.
+	ErrInvalidDaemonAddr = errors.New("invalid daemon address")
+
+	// ErrInvalidLogLevel is reported for an unknown LogLevel.
+	ErrInvalidLogLevel = errors.New("invalid log level")
+
+	// ErrConflictingEmitter is reported for an Emitter that can't send to
+	// the DaemonAddr, such as a Unix domain socket the Emitter doesn't
+	// implement UnixEmitter for.
+	ErrConflictingEmitter = errors.New("emitter conflicts with daemon address")
+
+	// ErrNilSamplingStrategy is reported for a SamplingStrategy holding a
+	// nil pointer, which is ignored.
+	ErrNilSamplingStrategy = errors.New("sampling strategy is a nil pointer")
+
+	// ErrInvalidContextMissing is reported for an AWS_XRAY_CONTEXT_MISSING
+	// environment variable naming no strategy, which is ignored.
+	ErrInvalidContextMissing = errors.New("invalid AWS_XRAY_CONTEXT_MISSING")
+)
+
+// logLevels are the LogLevel values accepted by Configure and
+// ContextWithConfig, in lower case.
+var logLevels = map[string]bool{
+	"trace":    true,
+	"debug":    true,
+	"info":     true,
+	"warn":     true,
+	"error":    true,
+	"critical": true,
+	"off":      true,
+}
+
+// validateLogLevel returns an error wrapping ErrInvalidLogLevel if level is
+// neither empty nor a known level.
+func validateLogLevel(level string) error {
+	if level != "" && !logLevels[strings.ToLower(level)] {
+		return fmt.Errorf("%w %q", ErrInvalidLogLevel, level)
+	}
+	return nil
+}
+
+// validateSamplingStrategy returns an error wrapping ErrNilSamplingStrategy
+// if s is a non-nil interface holding a nil pointer.
+func validateSamplingStrategy(s sampling.Strategy) error {
+	if s == nil {
+		return nil
+	}
+	if v := reflect.ValueOf(s); v.Kind() == reflect.Ptr && v.IsNil() {
+		return fmt.Errorf("%w: %T", ErrNilSamplingStrategy, s)
+	}
+	return nil
+}
+
+// contextMissingStrategyFromEnv returns the strategy named by the
+// AWS_XRAY_CONTEXT_MISSING environment variable, or nil if it isn't set.
+// It returns an error wrapping ErrInvalidContextMissing if the variable
+// names no strategy.
+func contextMissingStrategyFromEnv() (ctxmissing.Strategy, error) {
+	switch cms := os.Getenv("AWS_XRAY_CONTEXT_MISSING"); cms {
+	case "":
+		return nil, nil
+	case ctxmissing.RuntimeErrorStrategy:
+		return ctxmissing.NewDefaultRuntimeErrorStrategy(), nil
+	case ctxmissing.LogErrorStrategy:
+		return ctxmissing.NewDefaultLogErrorStrategy(), nil
+	case ctxmissing.IgnoreErrorStrategy:
+		return ctxmissing.NewDefaultIgnoreErrorStrategy(), nil
+	default:
+		return nil, fmt.Errorf("%w %q, expected %s, %s or %s", ErrInvalidContextMissing, cms,
+			ctxmissing.RuntimeErrorStrategy, ctxmissing.LogErrorStrategy, ctxmissing.IgnoreErrorStrategy)
+	}
 }
 
 // SetLogger sets the logger instance used by xray.
@@ -55,6 +204,7 @@ func newGlobalConfig() *globalConfig {
 		daemonEndpoint = daemoncfg.GetDefaultDaemonEndpoints()
 	}
 	ret.daemonAddr = daemonEndpoint.UDPAddr
+	ret.daemonUnixAddr = daemonEndpoint.UnixAddr
 
 	ss, err := sampling.NewCentralizedStrategy()
 	if err != nil {
@@ -74,28 +224,26 @@ func newGlobalConfig() *globalConfig {
 	}
 	ret.streamingStrategy = sts
 
-	emt, err := NewDefaultEmitter(ret.daemonAddr)
+	var emt *DefaultEmitter
+	if ret.daemonUnixAddr != nil {
+		emt, err = NewDefaultEmitterWithUnixAddr(ret.daemonUnixAddr)
+	} else {
+		emt, err = NewDefaultEmitter(ret.daemonAddr)
+	}
 	if err != nil {
 		panic(err)
 	}
+	emt.hostPort = daemonEndpoint.UDPHostPort
 	ret.emitter = emt
 
-	cms := os.Getenv("AWS_XRAY_CONTEXT_MISSING")
-	if cms != "" {
-		if cms == ctxmissing.RuntimeErrorStrategy {
-			cm := ctxmissing.NewDefaultRuntimeErrorStrategy()
-			ret.contextMissingStrategy = cm
-		} else if cms == ctxmissing.LogErrorStrategy {
-			cm := ctxmissing.NewDefaultLogErrorStrategy()
-			ret.contextMissingStrategy = cm
-		} else if cms == ctxmissing.IgnoreErrorStrategy {
-			cm := ctxmissing.NewDefaultIgnoreErrorStrategy()
-			ret.contextMissingStrategy = cm
-		}
-	} else {
-		cm := ctxmissing.NewDefaultLogErrorStrategy()
-		ret.contextMissingStrategy = cm
+	cms, err := contextMissingStrategyFromEnv()
+	if err != nil {
+		logger.Warnf("%v, using %s", err, ctxmissing.LogErrorStrategy)
+	}
+	if cms == nil {
+		cms = ctxmissing.NewDefaultLogErrorStrategy()
 	}
+	ret.contextMissingStrategy = cms
 
 	return ret
 }
@@ -104,12 +252,33 @@ type globalConfig struct {
 	sync.RWMutex
 
 	daemonAddr                  *net.UDPAddr
+	daemonUnixAddr              *net.UnixAddr
 	emitter                     Emitter
 	serviceVersion              string
 	samplingStrategy            sampling.Strategy
 	streamingStrategy           StreamingStrategy
 	exceptionFormattingStrategy exception.FormattingStrategy
 	contextMissingStrategy      ctxmissing.Strategy
+	preEmitProcessors           []PreEmitProcessor
+	emitFilter                  func(*Segment) bool
+	instrumentationMetadata     map[string]string
+	samplingEvalWarnThreshold   time.Duration
+	samplingTimeout             time.Duration
+	propagators                 []Propagator
+	maxMetadataValueBytes       int
+	maxSegmentSizeBytes         int
+	logger                      xraylog.Logger
+	metricsObserver             MetricsObserver
+	pluginMetadata              *PluginMetadata
+	origin                      string
+	noPluginMetadata            bool
+	captureRuntimeStatsOnFault  bool
+	promoteOnFault              bool
+	passthrough                 bool
+	nameNormalization           NameNormalization
+	nameReplacement             rune
+	slowSegmentThreshold        time.Duration
+	slowStackMaxFrames          int
 }
 
 // Config is a set of X-Ray configurations.
@@ -121,6 +290,125 @@ type Config struct {
 	StreamingStrategy           StreamingStrategy
 	ExceptionFormattingStrategy exception.FormattingStrategy
 	ContextMissingStrategy      ctxmissing.Strategy
+	PreEmitProcessors           []PreEmitProcessor
+
+	// EmitFilter is consulted for sampled root segments once they and all
+	// their subsegments are closed, before they are emitted. Returning false
+	// drops the segment. The filter is not consulted for segments that had
+	// subsegments streamed ahead of them, see Segment.Streamed.
+	EmitFilter func(*Segment) bool
+
+	// InstrumentationMetadata is merged into the aws.xray object of every
+	// segment, e.g. to report the version of a wrapper around the SDK. Keys
+	// may only contain letters, digits, '_', '-' and '.'; the sdk_version,
+	// sdk and sampling_rule_name keys are reserved.
+	InstrumentationMetadata map[string]string
+
+	// SamplingEvalWarnThreshold is the SamplingStrategy decision time above
+	// which a warning is logged. Defaults to 1ms.
+	SamplingEvalWarnThreshold time.Duration
+
+	// SamplingTimeout abandons SamplingStrategy decisions taking longer than
+	// the timeout and does not sample the request instead. The strategy keeps
+	// running in the background until it returns. Disabled by default.
+	SamplingTimeout time.Duration
+
+	// Propagators read the trace header of incoming requests, trying each in
+	// order, and all write the trace header of outgoing requests. Defaults
+	// to XRayPropagator only.
+	Propagators []Propagator
+
+	// MaxMetadataValueBytes caps the JSON size of each metadata value, and the
+	// length of string annotations, when segments are emitted. Longer values
+	// are truncated and end with a "...[truncated N bytes]" marker.
+	// Unlimited by default.
+	MaxMetadataValueBytes int
+
+	// MaxSegmentSizeBytes caps the size of emitted segment and subsegment
+	// documents. The metadata of larger documents is dropped, except for the
+	// pii namespace, keeping their annotations and HTTP and SQL data. The
+	// daemon drops datagrams larger than 64KB. Unlimited by default.
+	//
+	// Documents with truncated values or dropped metadata are annotated with
+	// xray_truncated set to true.
+	MaxSegmentSizeBytes int
+
+	// Logger, if set, is used instead of the logger set by SetLogger for the
+	// segments of this configuration, the emitters logging about them, and
+	// the sampling strategy if it has a LoadLogger(xraylog.Logger) method,
+	// like sampling.CentralizedStrategy.
+	Logger xraylog.Logger
+
+	// MetricsObserver, if set, is notified of the changes of the SDK health
+	// metrics counted for the segments of this configuration, see Stats.
+	MetricsObserver MetricsObserver
+
+	// PluginMetadata, if set, is recorded in segments instead of the metadata
+	// detected by the plugins under awsplugins, which then don't run.
+	PluginMetadata *PluginMetadata
+
+	// Origin, if set, overrides the origin of segments, such as
+	// OriginECSFargate, and the service type they are sampled for. It takes
+	// precedence over the AWS_XRAY_ORIGIN environment variable, which takes
+	// precedence over the origin detected by the plugins.
+	Origin string
+
+	// NoPluginMetadata disables the detection of plugin metadata for the
+	// segments of this configuration, like the AWS_XRAY_NO_PLUGIN_METADATA
+	// environment variable set to true does for all segments.
+	NoPluginMetadata bool
+
+	// CaptureRuntimeStatsOnFault records the number of goroutines, the heap
+	// in use and the pause of the last GC in the runtime metadata namespace
+	// of segments and subsegments closed as a fault, to correlate faults
+	// with resource pressure. The memory stats are read at most once per
+	// second and shared by the faults in between. Disabled by default.
+	CaptureRuntimeStatsOnFault bool
+
+	// PromoteOnFault promotes unsampled segments to sampled when they or
+	// one of their subsegments are closed with an error while the segment
+	// is in progress, see Segment.PromoteToSampled. Services called before
+	// the error only recorded their part of sampled traces, so promoted
+	// traces are partial. Disabled by default.
+	PromoteOnFault bool
+
+	// Passthrough disables recording like the AWS_XRAY_SDK_DISABLED
+	// environment variable, while still propagating traces: segments are
+	// neither recorded nor emitted, but the trace header parsed by the
+	// handlers is forwarded unchanged by the HTTP client and the gRPC
+	// interceptors, so that traces passing through the service stay whole.
+	// Setting AWS_XRAY_SDK_DISABLED to passthrough does the same.
+	Passthrough bool
+
+	// NameNormalization replaces the characters X-Ray doesn't allow in the
+	// names of segments and subsegments, such as the braces and question
+	// mark of "GET /users/{id}?q=1", with NameReplacement. The allowed
+	// characters are ASCII letters, digits, spaces and _.:/%&#=+\-@.
+	// NameNormalizationStrict also logs a warning for each name replaced.
+	// Names are only truncated to 200 characters by default.
+	NameNormalization NameNormalization
+
+	// NameReplacement replaces the characters X-Ray doesn't allow in names,
+	// see NameNormalization. Defaults to '_'.
+	NameReplacement rune
+
+	// SlowSegmentThreshold records the stack of the goroutine closing a
+	// subsegment which lasted longer than the threshold, in the slow_stack
+	// metadata of the xray namespace, and annotates the subsegment with
+	// slow set to true. The stack is only captured when the subsegment is
+	// closed, without the frames of the SDK, and not for subsegments closed
+	// as a fault, whose cause holds a stack. Disabled by default.
+	SlowSegmentThreshold time.Duration
+
+	// SlowStackMaxFrames limits the number of frames of the stacks recorded
+	// for slow subsegments, see SlowSegmentThreshold. Defaults to 32.
+	SlowStackMaxFrames int
+
+	// PluginMetadataTimeout bounds each request made by the plugins to
+	// detect metadata, such as those to the EC2 instance metadata service.
+	// Defaults to 1s. It applies to the whole process and is only set by
+	// Configure.
+	PluginMetadataTimeout time.Duration
 
 	// LogLevel and LogFormat are deprecated and no longer have any effect.
 	// See SetLogger() and the associated xraylog.Logger interface to control
@@ -130,34 +418,51 @@ type Config struct {
 }
 
 // ContextWithConfig returns context with given configuration settings.
+// Invalid values are reported in the returned error like with Configure.
 func ContextWithConfig(ctx context.Context, c Config) (context.Context, error) {
 	var errors exception.MultiError
 
+	if er := validateSamplingStrategy(c.SamplingStrategy); er != nil {
+		errors = append(errors, er)
+		c.SamplingStrategy = nil
+	}
+
+	if er := validateLogLevel(c.LogLevel); er != nil {
+		errors = append(errors, er)
+	}
+
 	daemonEndpoints, er := daemoncfg.GetDaemonEndpointsFromString(c.DaemonAddr)
 
 	if daemonEndpoints != nil {
 		if c.Emitter != nil {
-			c.Emitter.RefreshEmitterWithAddress(daemonEndpoints.UDPAddr)
+			if er := refreshEmitter(c.Emitter, daemonEndpoints); er != nil {
+				errors = append(errors, er)
+			}
 		}
 		if c.SamplingStrategy != nil {
 			configureStrategy(c.SamplingStrategy, daemonEndpoints)
 		}
 	} else if er != nil {
+		errors = append(errors, fmt.Errorf("%w: %v", ErrInvalidDaemonAddr, er))
+	}
+
+	cms, er := contextMissingStrategyFromEnv()
+	if er != nil {
 		errors = append(errors, er)
+	} else if cms != nil {
+		c.ContextMissingStrategy = cms
 	}
 
-	cms := os.Getenv("AWS_XRAY_CONTEXT_MISSING")
-	if cms != "" {
-		if cms == ctxmissing.RuntimeErrorStrategy {
-			cm := ctxmissing.NewDefaultRuntimeErrorStrategy()
-			c.ContextMissingStrategy = cm
-		} else if cms == ctxmissing.LogErrorStrategy {
-			cm := ctxmissing.NewDefaultLogErrorStrategy()
-			c.ContextMissingStrategy = cm
-		} else if cms == ctxmissing.IgnoreErrorStrategy {
-			cm := ctxmissing.NewDefaultIgnoreErrorStrategy()
-			c.ContextMissingStrategy = cm
+	if c.InstrumentationMetadata != nil {
+		md, er := validateInstrumentationMetadata(c.InstrumentationMetadata)
+		if er != nil {
+			errors = append(errors, er)
 		}
+		c.InstrumentationMetadata = md
+	}
+
+	if c.Logger != nil {
+		configureLogger(c.SamplingStrategy, c.Logger)
 	}
 
 	var err error
@@ -173,6 +478,25 @@ func ContextWithConfig(ctx context.Context, c Config) (context.Context, error) {
 	return context.WithValue(ctx, RecorderContextKey{}, &c), err
 }
 
+// refreshEmitter points the emitter to the daemon endpoints, the Unix domain
+// socket of the daemon if set. It returns an error wrapping
+// ErrConflictingEmitter if the emitter doesn't support the Unix domain socket.
+func refreshEmitter(e Emitter, daemonEndpoints *daemoncfg.DaemonEndpoints) error {
+	if daemonEndpoints.UnixAddr == nil {
+		if de, ok := e.(*DefaultEmitter); ok {
+			de.refreshEmitterWithHostPort(daemonEndpoints.UDPAddr, daemonEndpoints.UDPHostPort)
+			return nil
+		}
+		e.RefreshEmitterWithAddress(daemonEndpoints.UDPAddr)
+		return nil
+	}
+	if ue, ok := e.(UnixEmitter); ok {
+		ue.RefreshEmitterWithUnixAddress(daemonEndpoints.UnixAddr)
+		return nil
+	}
+	return fmt.Errorf("%w: %T does not support the daemon unix socket %v", ErrConflictingEmitter, e, daemonEndpoints.UnixAddr)
+}
+
 func configureStrategy(s sampling.Strategy, daemonEndpoints *daemoncfg.DaemonEndpoints) {
 	if s == nil {
 		return
@@ -183,17 +507,44 @@ func configureStrategy(s sampling.Strategy, daemonEndpoints *daemoncfg.DaemonEnd
 	}
 }
 
+// configureLogger makes s log to l if it supports a logger of its own.
+func configureLogger(s sampling.Strategy, l xraylog.Logger) {
+	if ls, ok := s.(interface{ LoadLogger(xraylog.Logger) }); ok {
+		ls.LoadLogger(l)
+	}
+}
+
 // Configure overrides default configuration options with customer-defined values.
+// Invalid values are reported in the returned error, which matches the
+// errors of this package, such as ErrInvalidDaemonAddr, with errors.Is. The
+// other values are applied regardless.
 func Configure(c Config) error {
 	globalCfg.Lock()
 	defer globalCfg.Unlock()
 
 	var errors exception.MultiError
 
+	if er := validateSamplingStrategy(c.SamplingStrategy); er != nil {
+		errors = append(errors, er)
+		c.SamplingStrategy = nil
+	}
+
+	if er := validateLogLevel(c.LogLevel); er != nil {
+		errors = append(errors, er)
+	}
+
+	if c.Logger != nil {
+		globalCfg.logger = c.Logger
+	}
+
 	if c.SamplingStrategy != nil {
 		globalCfg.samplingStrategy = c.SamplingStrategy
 	}
 
+	if globalCfg.logger != nil && (c.Logger != nil || c.SamplingStrategy != nil) {
+		configureLogger(globalCfg.samplingStrategy, globalCfg.logger)
+	}
+
 	if c.Emitter != nil {
 		globalCfg.emitter = c.Emitter
 	}
@@ -201,10 +552,13 @@ func Configure(c Config) error {
 	daemonEndpoints, er := daemoncfg.GetDaemonEndpointsFromString(c.DaemonAddr)
 	if daemonEndpoints != nil {
 		globalCfg.daemonAddr = daemonEndpoints.UDPAddr
-		globalCfg.emitter.RefreshEmitterWithAddress(globalCfg.daemonAddr)
+		globalCfg.daemonUnixAddr = daemonEndpoints.UnixAddr
+		if er := refreshEmitter(globalCfg.emitter, daemonEndpoints); er != nil {
+			errors = append(errors, er)
+		}
 		configureStrategy(globalCfg.samplingStrategy, daemonEndpoints)
 	} else if er != nil {
-		errors = append(errors, er)
+		errors = append(errors, fmt.Errorf("%w: %v", ErrInvalidDaemonAddr, er))
 	}
 
 	if c.ExceptionFormattingStrategy != nil {
@@ -215,18 +569,12 @@ func Configure(c Config) error {
 		globalCfg.streamingStrategy = c.StreamingStrategy
 	}
 
-	cms := os.Getenv("AWS_XRAY_CONTEXT_MISSING")
-	if cms != "" {
-		if cms == ctxmissing.RuntimeErrorStrategy {
-			cm := ctxmissing.NewDefaultRuntimeErrorStrategy()
-			globalCfg.contextMissingStrategy = cm
-		} else if cms == ctxmissing.LogErrorStrategy {
-			cm := ctxmissing.NewDefaultLogErrorStrategy()
-			globalCfg.contextMissingStrategy = cm
-		} else if cms == ctxmissing.IgnoreErrorStrategy {
-			cm := ctxmissing.NewDefaultIgnoreErrorStrategy()
-			globalCfg.contextMissingStrategy = cm
-		}
+	cms, er := contextMissingStrategyFromEnv()
+	if er != nil {
+		errors = append(errors, er)
+	}
+	if cms != nil {
+		globalCfg.contextMissingStrategy = cms
 	} else if c.ContextMissingStrategy != nil {
 		globalCfg.contextMissingStrategy = c.ContextMissingStrategy
 	}
@@ -235,6 +583,91 @@ func Configure(c Config) error {
 		globalCfg.serviceVersion = c.ServiceVersion
 	}
 
+	if c.PreEmitProcessors != nil {
+		globalCfg.preEmitProcessors = c.PreEmitProcessors
+	}
+
+	if c.EmitFilter != nil {
+		globalCfg.emitFilter = c.EmitFilter
+	}
+
+	if c.InstrumentationMetadata != nil {
+		md, er := validateInstrumentationMetadata(c.InstrumentationMetadata)
+		if er != nil {
+			errors = append(errors, er)
+		} else {
+			globalCfg.instrumentationMetadata = md
+		}
+	}
+
+	if c.SamplingEvalWarnThreshold != 0 {
+		globalCfg.samplingEvalWarnThreshold = c.SamplingEvalWarnThreshold
+	}
+
+	if c.SamplingTimeout != 0 {
+		globalCfg.samplingTimeout = c.SamplingTimeout
+	}
+
+	if c.Propagators != nil {
+		globalCfg.propagators = c.Propagators
+	}
+
+	if c.MaxMetadataValueBytes != 0 {
+		globalCfg.maxMetadataValueBytes = c.MaxMetadataValueBytes
+	}
+
+	if c.MaxSegmentSizeBytes != 0 {
+		globalCfg.maxSegmentSizeBytes = c.MaxSegmentSizeBytes
+	}
+
+	if c.MetricsObserver != nil {
+		globalCfg.metricsObserver = c.MetricsObserver
+	}
+
+	if c.PluginMetadata != nil {
+		globalCfg.pluginMetadata = c.PluginMetadata
+	}
+
+	if c.Origin != "" {
+		globalCfg.origin = c.Origin
+	}
+
+	if c.NoPluginMetadata {
+		globalCfg.noPluginMetadata = true
+	}
+
+	if c.CaptureRuntimeStatsOnFault {
+		globalCfg.captureRuntimeStatsOnFault = true
+	}
+
+	if c.PromoteOnFault {
+		globalCfg.promoteOnFault = true
+	}
+
+	if c.Passthrough {
+		globalCfg.passthrough = true
+	}
+
+	if c.NameNormalization != NameNormalizationOff {
+		globalCfg.nameNormalization = c.NameNormalization
+	}
+
+	if c.NameReplacement != 0 {
+		globalCfg.nameReplacement = c.NameReplacement
+	}
+
+	if c.SlowSegmentThreshold != 0 {
+		globalCfg.slowSegmentThreshold = c.SlowSegmentThreshold
+	}
+
+	if c.SlowStackMaxFrames != 0 {
+		globalCfg.slowStackMaxFrames = c.SlowStackMaxFrames
+	}
+
+	if c.PluginMetadataTimeout != 0 {
+		plugins.SetMetadataTimeout(c.PluginMetadataTimeout)
+	}
+
 	switch len(errors) {
 	case 0:
 		return nil
@@ -251,6 +684,12 @@ func (c *globalConfig) DaemonAddr() *net.UDPAddr {
 	return c.daemonAddr
 }
 
+func (c *globalConfig) Emitter() Emitter {
+	c.RLock()
+	defer c.RUnlock()
+	return c.emitter
+}
+
 func (c *globalConfig) SamplingStrategy() sampling.Strategy {
 	c.RLock()
 	defer c.RUnlock()
@@ -280,3 +719,75 @@ func (c *globalConfig) ServiceVersion() string {
 	defer c.RUnlock()
 	return c.serviceVersion
 }
+
+func (c *globalConfig) PreEmitProcessors() []PreEmitProcessor {
+	c.RLock()
+	defer c.RUnlock()
+	return c.preEmitProcessors
+}
+
+func (c *globalConfig) EmitFilter() func(*Segment) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.emitFilter
+}
+
+func (c *globalConfig) InstrumentationMetadata() map[string]string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.instrumentationMetadata
+}
+
+func (c *globalConfig) Propagators() []Propagator {
+	c.RLock()
+	defer c.RUnlock()
+	return c.propagators
+}
+
+func (c *globalConfig) MaxMetadataValueBytes() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.maxMetadataValueBytes
+}
+
+func (c *globalConfig) MaxSegmentSizeBytes() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.maxSegmentSizeBytes
+}
+
+func (c *globalConfig) Logger() xraylog.Logger {
+	c.RLock()
+	defer c.RUnlock()
+	return c.logger
+}
+
+func (c *globalConfig) MetricsObserver() MetricsObserver {
+	c.RLock()
+	defer c.RUnlock()
+	return c.metricsObserver
+}
+
+func (c *globalConfig) PluginMetadata() *PluginMetadata {
+	c.RLock()
+	defer c.RUnlock()
+	return c.pluginMetadata
+}
+
+func (c *globalConfig) Origin() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.origin
+}
+
+func (c *globalConfig) NoPluginMetadata() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.noPluginMetadata
+}
+
+func (c *globalConfig) Passthrough() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.passthrough
+}
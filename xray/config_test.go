@@ -9,16 +9,21 @@
 package xray
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"strings"
 	"testing"
 
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
 	"github.com/aws/aws-xray-sdk-go/strategy/ctxmissing"
 	"github.com/aws/aws-xray-sdk-go/strategy/exception"
 	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/aws/aws-xray-sdk-go/xraylog"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -239,6 +244,83 @@ func TestConfigureWithContext(t *testing.T) {
 	ResetConfig()
 }
 
+func TestConfigValidation(t *testing.T) {
+	var nilStrategy *sampling.CentralizedStrategy
+
+	cases := []struct {
+		name     string
+		cfg      Config
+		env      string
+		expected []error
+	}{
+		{name: "malformed daemon address", cfg: Config{DaemonAddr: "not an address"}, expected: []error{ErrInvalidDaemonAddr}},
+		{name: "malformed daemon port", cfg: Config{DaemonAddr: "127.0.0.1:port"}, expected: []error{ErrInvalidDaemonAddr}},
+		{name: "unknown log level", cfg: Config{LogLevel: "verbose"}, expected: []error{ErrInvalidLogLevel}},
+		{name: "emitter without unix socket", cfg: Config{DaemonAddr: "unix:/tmp/xray.sock", Emitter: &TestEmitter{}}, expected: []error{ErrConflictingEmitter}},
+		{name: "nil sampling strategy", cfg: Config{SamplingStrategy: nilStrategy}, expected: []error{ErrNilSamplingStrategy}},
+		{name: "unknown context missing", env: "LOG_EROR", expected: []error{ErrInvalidContextMissing}},
+		{
+			name:     "several errors",
+			cfg:      Config{DaemonAddr: "not an address", LogLevel: "verbose", SamplingStrategy: nilStrategy},
+			expected: []error{ErrInvalidDaemonAddr, ErrInvalidLogLevel, ErrNilSamplingStrategy},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			env := stashEnv()
+			defer popEnv(env)
+			if c.env != "" {
+				os.Setenv("AWS_XRAY_CONTEXT_MISSING", c.env)
+			}
+
+			ctx, err := ContextWithConfig(context.Background(), c.cfg)
+			for _, target := range c.expected {
+				assert.True(t, errors.Is(err, target), "ContextWithConfig: %v is not %v", err, target)
+			}
+			assert.Nil(t, GetRecorder(ctx).SamplingStrategy)
+
+			daemonAddr, samplingStrategy := globalCfg.daemonAddr, globalCfg.samplingStrategy
+			err = Configure(c.cfg)
+			for _, target := range c.expected {
+				assert.True(t, errors.Is(err, target), "Configure: %v is not %v", err, target)
+			}
+			if len(c.expected) > 1 {
+				assert.Len(t, err, len(c.expected))
+			}
+			if c.cfg.Emitter == nil {
+				assert.Equal(t, daemonAddr, globalCfg.daemonAddr)
+			}
+			assert.Equal(t, samplingStrategy, globalCfg.samplingStrategy)
+			assert.NotNil(t, globalCfg.contextMissingStrategy)
+
+			ResetConfig()
+		})
+	}
+}
+
+func TestConfigValidationValidConfig(t *testing.T) {
+	env := stashEnv()
+	defer popEnv(env)
+	os.Setenv("AWS_XRAY_CONTEXT_MISSING", ctxmissing.IgnoreErrorStrategy)
+
+	cfg := Config{DaemonAddr: "127.0.0.1:3000", LogLevel: "WARN", Emitter: &TestEmitter{}}
+	_, err := ContextWithConfig(context.Background(), cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, Configure(cfg))
+
+	ResetConfig()
+}
+
+func TestNewGlobalConfigInvalidContextMissing(t *testing.T) {
+	env := stashEnv()
+	defer popEnv(env)
+	os.Setenv("AWS_XRAY_CONTEXT_MISSING", "LOG_EROR")
+
+	cfg := newGlobalConfig()
+	assert.Equal(t, ctxmissing.NewDefaultLogErrorStrategy(), cfg.contextMissingStrategy)
+}
+
 func TestSelectiveConfigWithContext(t *testing.T) {
 	daemonAddr := "127.0.0.1:3000"
 	serviceVersion := "TestVersion"
@@ -283,3 +365,170 @@ func BenchmarkConfigure(b *testing.B) {
 		Configure(configure)
 	}
 }
+
+func TestGetSDKVersion(t *testing.T) {
+	assert.Equal(t, SDKVersion, GetSDKVersion())
+}
+
+func TestInstrumentationMetadataEmitted(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, err := ContextWithConfig(ctx, Config{
+		Emitter:                 GetRecorder(ctx).Emitter,
+		SamplingStrategy:        GetRecorder(ctx).SamplingStrategy,
+		InstrumentationMetadata: map[string]string{"wrapper_version": "2.3.1"},
+	})
+	assert.NoError(t, err)
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{
+		"sdk_version":     SDKVersion,
+		"sdk":             SDKType,
+		"wrapper_version": "2.3.1",
+	}, emitted.AWS["xray"])
+}
+
+func TestInstrumentationMetadataGlobal(t *testing.T) {
+	defer func() {
+		globalCfg.Lock()
+		globalCfg.instrumentationMetadata = nil
+		globalCfg.Unlock()
+	}()
+
+	md := map[string]string{"wrapper_version": "2.3.1"}
+	assert.NoError(t, Configure(Config{InstrumentationMetadata: md}))
+	md["wrapper_version"] = "changed"
+	assert.Equal(t, map[string]string{"wrapper_version": "2.3.1"}, globalCfg.InstrumentationMetadata())
+
+	// an invalid map leaves the previous one in place
+	err := Configure(Config{InstrumentationMetadata: map[string]string{"bad key": "x"}})
+	assert.Error(t, err)
+	assert.Equal(t, map[string]string{"wrapper_version": "2.3.1"}, globalCfg.InstrumentationMetadata())
+}
+
+func TestInstrumentationMetadataReservedKeys(t *testing.T) {
+	for _, key := range []string{"sdk_version", "sdk", "sampling_rule_name"} {
+		ctx, err := ContextWithConfig(context.Background(), Config{
+			InstrumentationMetadata: map[string]string{key: "forked"},
+		})
+		assert.Error(t, err, key)
+		assert.Nil(t, GetRecorder(ctx).InstrumentationMetadata, key)
+
+		assert.Error(t, Configure(Config{InstrumentationMetadata: map[string]string{key: "forked"}}), key)
+		assert.Nil(t, globalCfg.InstrumentationMetadata(), key)
+	}
+
+	// Version and Type win even when the validation is bypassed.
+	b, err := json.Marshal(SDK{
+		Version:  SDKVersion,
+		Type:     SDKType,
+		Metadata: map[string]string{"sdk_version": "forked", "sdk": "forked", "extra": "x"},
+	})
+	assert.NoError(t, err)
+	var fields map[string]string
+	assert.NoError(t, json.Unmarshal(b, &fields))
+	assert.Equal(t, map[string]string{"sdk_version": SDKVersion, "sdk": SDKType, "extra": "x"}, fields)
+}
+
+func TestSDKMarshalWithoutMetadata(t *testing.T) {
+	b, err := json.Marshal(SDK{Version: SDKVersion, Type: SDKType, RuleName: "rule"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"sdk_version":"`+SDKVersion+`","sdk":"`+SDKType+`","sampling_rule_name":"rule"}`, string(b))
+}
+
+// loggerSamplingStrategy records the logger loaded into it.
+type loggerSamplingStrategy struct {
+	TestSamplingStrategy
+	logger xraylog.Logger
+}
+
+func (s *loggerSamplingStrategy) LoadLogger(l xraylog.Logger) {
+	s.logger = l
+}
+
+func TestConfigLoggerPerRecorder(t *testing.T) {
+	var global bytes.Buffer
+	oldLogger := logger.Logger
+	logger.Logger = xraylog.NewDefaultLogger(&global, xraylog.LogLevelDebug)
+	t.Cleanup(func() { logger.Logger = oldLogger })
+
+	record := func(name string, out *bytes.Buffer) *loggerSamplingStrategy {
+		ss := &loggerSamplingStrategy{}
+		ctx, err := ContextWithConfig(context.Background(), Config{
+			Emitter:          NewMemoryEmitter(),
+			SamplingStrategy: ss,
+			Logger:           xraylog.NewDefaultLogger(out, xraylog.LogLevelDebug),
+		})
+		assert.NoError(t, err)
+
+		ctx, seg := BeginSegment(ctx, name)
+		_, subseg := BeginSubsegment(ctx, name+"-sub")
+		subseg.Close(nil)
+		seg.Close(nil)
+		return ss
+	}
+
+	var alpha, bravo bytes.Buffer
+	alphaStrategy := record("alpha", &alpha)
+	bravoStrategy := record("bravo", &bravo)
+
+	for _, msg := range []string{"Beginning segment named alpha", "Beginning subsegment named alpha-sub", "Closing subsegment named alpha-sub", "Closing segment named alpha"} {
+		assert.Contains(t, alpha.String(), msg)
+	}
+	for _, msg := range []string{"Beginning segment named bravo", "Beginning subsegment named bravo-sub", "Closing subsegment named bravo-sub", "Closing segment named bravo"} {
+		assert.Contains(t, bravo.String(), msg)
+	}
+	assert.NotContains(t, alpha.String(), "bravo")
+	assert.NotContains(t, bravo.String(), "alpha")
+	assert.NotContains(t, global.String(), "alpha")
+	assert.NotContains(t, global.String(), "bravo")
+
+	assert.NotNil(t, alphaStrategy.logger)
+	assert.NotNil(t, bravoStrategy.logger)
+	assert.NotEqual(t, alphaStrategy.logger, bravoStrategy.logger)
+}
+
+func TestConfigLoggerDefaultsToSetLogger(t *testing.T) {
+	var global bytes.Buffer
+	oldLogger := logger.Logger
+	logger.Logger = xraylog.NewDefaultLogger(&global, xraylog.LogLevelDebug)
+	t.Cleanup(func() { logger.Logger = oldLogger })
+
+	ctx, err := ContextWithConfig(context.Background(), Config{
+		Emitter:          NewMemoryEmitter(),
+		SamplingStrategy: &TestSamplingStrategy{},
+	})
+	assert.NoError(t, err)
+
+	_, seg := BeginSegment(ctx, "charlie")
+	seg.Close(nil)
+
+	assert.Contains(t, global.String(), "Beginning segment named charlie")
+	assert.Contains(t, global.String(), "Closing segment named charlie")
+}
+
+func TestConfigureLogger(t *testing.T) {
+	oldStrategy := globalCfg.SamplingStrategy()
+	defer func() {
+		globalCfg.Lock()
+		globalCfg.samplingStrategy = oldStrategy
+		globalCfg.logger = nil
+		globalCfg.Unlock()
+	}()
+
+	l := xraylog.NewDefaultLogger(&bytes.Buffer{}, xraylog.LogLevelDebug)
+	assert.NoError(t, Configure(Config{Logger: l}))
+	assert.Equal(t, l, globalCfg.Logger())
+
+	// a strategy configured later gets the logger too
+	ss := &loggerSamplingStrategy{}
+	assert.NoError(t, Configure(Config{SamplingStrategy: ss}))
+	assert.Equal(t, l, ss.logger)
+}
@@ -0,0 +1,304 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+// maxRPCErrorSize bounds how much of an error body or end-of-stream message
+// is buffered to find the status code of an RPC.
+const maxRPCErrorSize = 4096
+
+type rpcProtocol int
+
+const (
+	rpcNone rpcProtocol = iota
+	rpcGRPC
+	rpcGRPCWeb
+	rpcConnectUnary
+	rpcConnectStream
+)
+
+// Flags marking the message carrying the status of a streaming RPC.
+const (
+	connectEndStreamFlag = 0x02
+	grpcWebTrailerFlag   = 0x80
+	envelopeCompressed   = 0x01
+)
+
+var connectCodes = map[string]codes.Code{
+	"canceled":            codes.Canceled,
+	"unknown":             codes.Unknown,
+	"invalid_argument":    codes.InvalidArgument,
+	"deadline_exceeded":   codes.DeadlineExceeded,
+	"not_found":           codes.NotFound,
+	"already_exists":      codes.AlreadyExists,
+	"permission_denied":   codes.PermissionDenied,
+	"resource_exhausted":  codes.ResourceExhausted,
+	"failed_precondition": codes.FailedPrecondition,
+	"aborted":             codes.Aborted,
+	"out_of_range":        codes.OutOfRange,
+	"unimplemented":       codes.Unimplemented,
+	"internal":            codes.Internal,
+	"unavailable":         codes.Unavailable,
+	"data_loss":           codes.DataLoss,
+	"unauthenticated":     codes.Unauthenticated,
+}
+
+// ConnectHandler wraps the provided http handler like Handler, recognizing
+// Connect, gRPC and gRPC-Web requests by their content type. Segments of
+// such requests are named after the service of the called procedure, the
+// same way UnaryServerInterceptor names them, unless a SegmentNamer is given
+// through WithSegmentNamer. The RPC status code, read from the response
+// trailers or the error carried in the response body, is recorded as
+// Error, Throttle or Fault the same way as UnaryServerInterceptor does.
+//
+// Other requests are traced like Handler does and named after the Host
+// header unless a SegmentNamer is given. The status of gRPC-Web text
+// responses is only read from headers.
+func ConnectHandler(h http.Handler, opts ...GrpcOption) http.Handler {
+	var option grpcOption
+	for _, opt := range opts {
+		opt.apply(&option)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocol := rpcProtocolOf(r)
+		service, isProcedure := procedureService(r.URL.Path)
+		if !isProcedure {
+			protocol = rpcNone
+		}
+
+		var name string
+		switch {
+		case option.segmentNamer != nil:
+			name = segmentName(option.segmentNamer, r)
+		case protocol != rpcNone:
+			name = service
+		default:
+			name = r.Host
+		}
+
+		ctx := r.Context()
+		if option.config != nil {
+			ctx = context.WithValue(ctx, RecorderContextKey{}, option.config)
+		}
+
+		traceHeader := extractTraceHeader(GetRecorder(ctx), r.Header)
+		ctx, seg := NewSegmentFromHeader(ctx, name, r, traceHeader)
+		defer seg.Close(nil)
+		r = r.WithContext(ctx)
+
+		if protocol == rpcNone {
+			httpTrace(seg, h, w, r, traceHeader, HandlerConfig{})
+			return
+		}
+
+		httpCaptureRequest(seg, r)
+		w.Header().Set(TraceIDHeaderKey, generateTraceIDHeaderValue(seg, traceHeader))
+
+		scanner := &rpcStatusScanner{protocol: protocol}
+		capturer := &responseCapturer{ResponseWriter: w, status: 200, body: scanner}
+		h.ServeHTTP(capturer.wrappedResponseWriter(), r)
+
+		seg.Lock()
+		seg.GetHTTP().GetResponse().ContentLength = capturer.contentLength()
+		seg.Unlock()
+
+		code, ok := scanner.statusCode(capturer.Header(), capturer.status)
+		if !ok {
+			HttpCaptureResponse(seg, capturer.status)
+			return
+		}
+		seg.Lock()
+		seg.GetHTTP().GetResponse().Status = capturer.status
+		seg.Unlock()
+		classifyStatusCode(seg, code)
+	})
+}
+
+func rpcProtocolOf(r *http.Request) rpcProtocol {
+	if r.Method == http.MethodGet && r.URL.Query().Get("connect") == "v1" {
+		return rpcConnectUnary
+	}
+
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]))
+	switch {
+	case contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+"):
+		return rpcGRPC
+	case strings.HasPrefix(contentType, "application/grpc-web"):
+		return rpcGRPCWeb
+	case strings.HasPrefix(contentType, "application/connect+"):
+		return rpcConnectStream
+	case r.Header.Get("Connect-Protocol-Version") != "":
+		return rpcConnectUnary
+	}
+	return rpcNone
+}
+
+// procedureService returns the service of a "/package.Service/Method" path.
+func procedureService(path string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) < 2 || parts[len(parts)-2] == "" || parts[len(parts)-1] == "" {
+		return "", false
+	}
+	return parts[len(parts)-2], true
+}
+
+// rpcStatusScanner follows the response body of an RPC and keeps the part
+// of it that carries the status: the error of a Connect unary call, or the
+// last message of a Connect or gRPC-Web stream.
+type rpcStatusScanner struct {
+	protocol rpcProtocol
+
+	prefix    [5]byte
+	prefixLen int
+	remaining uint32
+	keep      bool
+
+	status []byte
+}
+
+func (s *rpcStatusScanner) Write(p []byte) (int, error) {
+	n := len(p)
+	switch s.protocol {
+	case rpcConnectUnary:
+		s.status = appendBounded(s.status, p)
+	case rpcConnectStream, rpcGRPCWeb:
+		s.scanEnvelopes(p)
+	}
+	return n, nil
+}
+
+// scanEnvelopes splits p into length-prefixed messages, keeping the payload
+// of the one flagged as the end of the stream.
+func (s *rpcStatusScanner) scanEnvelopes(p []byte) {
+	endFlag := byte(connectEndStreamFlag)
+	if s.protocol == rpcGRPCWeb {
+		endFlag = grpcWebTrailerFlag
+	}
+
+	for len(p) > 0 {
+		if s.prefixLen < len(s.prefix) {
+			c := copy(s.prefix[s.prefixLen:], p)
+			s.prefixLen += c
+			p = p[c:]
+			if s.prefixLen < len(s.prefix) {
+				return
+			}
+			flags := s.prefix[0]
+			s.remaining = binary.BigEndian.Uint32(s.prefix[1:])
+			s.keep = flags&endFlag != 0 && flags&envelopeCompressed == 0
+			if s.keep {
+				s.status = s.status[:0]
+			}
+		}
+
+		c := len(p)
+		if uint32(c) > s.remaining {
+			c = int(s.remaining)
+		}
+		if s.keep {
+			s.status = appendBounded(s.status, p[:c])
+		}
+		s.remaining -= uint32(c)
+		p = p[c:]
+		if s.remaining == 0 {
+			s.prefixLen = 0
+		}
+	}
+}
+
+func appendBounded(b, p []byte) []byte {
+	if room := maxRPCErrorSize - len(b); len(p) > room {
+		p = p[:room]
+	}
+	return append(b, p...)
+}
+
+// statusCode returns the status code of the RPC, if the response had one.
+func (s *rpcStatusScanner) statusCode(h http.Header, status int) (codes.Code, bool) {
+	switch s.protocol {
+	case rpcGRPC, rpcGRPCWeb:
+		if code, ok := grpcStatusHeader(h); ok {
+			return code, true
+		}
+		if s.protocol == rpcGRPCWeb && s.status != nil {
+			return grpcStatusHeader(parseGRPCWebTrailer(s.status))
+		}
+	case rpcConnectUnary:
+		if status == http.StatusOK {
+			return codes.OK, true
+		}
+		if encoding := h.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+			return 0, false
+		}
+		return connectErrorCode(s.status)
+	case rpcConnectStream:
+		if s.status == nil {
+			return 0, false
+		}
+		var end struct {
+			Error json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(s.status, &end); err != nil {
+			return 0, false
+		}
+		if len(end.Error) == 0 || string(end.Error) == "null" {
+			return codes.OK, true
+		}
+		return connectErrorCode(end.Error)
+	}
+	return 0, false
+}
+
+func grpcStatusHeader(h http.Header) (codes.Code, bool) {
+	value := h.Get("Grpc-Status")
+	if value == "" {
+		value = h.Get(http.TrailerPrefix + "Grpc-Status")
+	}
+	code, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return codes.Code(code), true
+}
+
+// parseGRPCWebTrailer parses the "key: value" lines of a gRPC-Web trailer message.
+func parseGRPCWebTrailer(b []byte) http.Header {
+	trailer := http.Header{}
+	for _, line := range bytes.Split(b, []byte("\r\n")) {
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 {
+			continue
+		}
+		trailer.Add(string(bytes.TrimSpace(line[:i])), string(bytes.TrimSpace(line[i+1:])))
+	}
+	return trailer
+}
+
+func connectErrorCode(b []byte) (codes.Code, bool) {
+	var connectErr struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(b, &connectErr); err != nil {
+		return 0, false
+	}
+	code, ok := connectCodes[connectErr.Code]
+	return code, ok
+}
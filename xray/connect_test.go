@@ -0,0 +1,178 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// connectHandler serves the Connect protocol for a single unary procedure,
+// failing with the given Connect error code unless it is empty.
+func connectHandler(code string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code == "" {
+			w.Header().Set("Content-Type", "application/proto")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte{0x0a, 0x02, 'h', 'i'})
+			return
+		}
+		status := map[string]int{
+			"resource_exhausted": http.StatusTooManyRequests,
+			"internal":           http.StatusInternalServerError,
+			"not_found":          http.StatusNotFound,
+		}[code]
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"code":"` + code + `","message":"failed"}`))
+	})
+}
+
+func envelope(flags byte, payload string) []byte {
+	b := make([]byte, 5, 5+len(payload))
+	b[0] = flags
+	binary.BigEndian.PutUint32(b[1:], uint32(len(payload)))
+	return append(b, payload...)
+}
+
+func serveRPC(t *testing.T, h http.Handler, contentType string) *Segment {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/acme.greet.v1.GreetService/Greet", strings.NewReader(""))
+	req.Header.Set("Content-Type", contentType)
+	if contentType == "application/proto" || contentType == "application/json" {
+		req.Header.Set("Connect-Protocol-Version", "1")
+	}
+	ConnectHandler(h, WithRecorder(GetRecorder(ctx))).ServeHTTP(httptest.NewRecorder(), req)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return seg
+}
+
+func TestConnectHandlerUnary(t *testing.T) {
+	tests := []struct {
+		code                   string
+		status                 int
+		error, throttle, fault bool
+	}{
+		{"", http.StatusOK, false, false, false},
+		{"resource_exhausted", http.StatusTooManyRequests, false, true, false},
+		{"internal", http.StatusInternalServerError, false, false, true},
+		{"not_found", http.StatusNotFound, true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			seg := serveRPC(t, connectHandler(tt.code), "application/proto")
+
+			assert.Equal(t, "acme.greet.v1.GreetService", seg.Name)
+			assert.Equal(t, "http://localhost/acme.greet.v1.GreetService/Greet", seg.HTTP.Request.URL)
+			assert.Equal(t, tt.status, seg.HTTP.Response.Status)
+			assert.Equal(t, tt.error, seg.Error)
+			assert.Equal(t, tt.throttle, seg.Throttle)
+			assert.Equal(t, tt.fault, seg.Fault)
+		})
+	}
+}
+
+func TestConnectHandlerStream(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/connect+proto")
+		w.Write(envelope(0, "message"))
+		end := envelope(connectEndStreamFlag, `{"error":{"code":"resource_exhausted"}}`)
+		// split the end of the stream across writes
+		w.Write(end[:3])
+		w.Write(end[3:])
+	})
+
+	seg := serveRPC(t, h, "application/connect+proto")
+
+	assert.Equal(t, "acme.greet.v1.GreetService", seg.Name)
+	assert.Equal(t, http.StatusOK, seg.HTTP.Response.Status)
+	assert.True(t, seg.Throttle)
+	assert.False(t, seg.Error)
+	assert.False(t, seg.Fault)
+}
+
+func TestConnectHandlerGRPCWeb(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		w.Write(envelope(0, "message"))
+		w.Write(envelope(grpcWebTrailerFlag, "grpc-status: 13\r\ngrpc-message: internal\r\n"))
+	})
+
+	seg := serveRPC(t, h, "application/grpc-web+proto")
+
+	assert.Equal(t, "acme.greet.v1.GreetService", seg.Name)
+	assert.True(t, seg.Fault)
+	assert.False(t, seg.Throttle)
+}
+
+func TestConnectHandlerGRPCTrailer(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write(envelope(0, "message"))
+		w.Header().Set("Grpc-Status", "3")
+	})
+
+	seg := serveRPC(t, h, "application/grpc")
+
+	assert.True(t, seg.Error)
+	assert.False(t, seg.Fault)
+}
+
+func TestConnectHandlerSegmentNamer(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	h := ConnectHandler(connectHandler("internal"), WithRecorder(GetRecorder(ctx)), WithSegmentNamer(NewFixedSegmentNamer("test")))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/acme.greet.v1.GreetService/Greet", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connect-Protocol-Version", "1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "test", seg.Name)
+	assert.True(t, seg.Fault)
+}
+
+func TestConnectHandlerPlainHTTP(t *testing.T) {
+	seg := serveRPC(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}), "text/plain")
+
+	assert.Equal(t, "localhost", seg.Name)
+	assert.Equal(t, http.StatusTooManyRequests, seg.HTTP.Response.Status)
+	assert.True(t, seg.Error)
+	assert.True(t, seg.Throttle)
+}
+
+func TestProcedureService(t *testing.T) {
+	service, ok := procedureService("/acme.greet.v1.GreetService/Greet")
+	assert.True(t, ok)
+	assert.Equal(t, "acme.greet.v1.GreetService", service)
+
+	for _, path := range []string{"/", "/Greet", "/acme.greet.v1.GreetService/", "//Greet"} {
+		_, ok := procedureService(path)
+		assert.False(t, ok, path)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+	"github.com/aws/aws-xray-sdk-go/utils"
+)
+
+var defaultConsoleBufferTTL = 30 * time.Second
+
+const (
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// ConsoleEmitter prints human-readable trace trees for local development
+// instead of sending segments to the daemon. Subsegments which are streamed
+// before their root segment is emitted are buffered until the root segment
+// arrives, or until they are older than the buffer TTL.
+type ConsoleEmitter struct {
+	sync.Mutex
+	w       io.Writer
+	color   bool
+	ttl     time.Duration
+	clock   utils.Clock
+	pending map[string]*pendingTrace
+}
+
+// ConsoleEmitterOption configures a ConsoleEmitter.
+type ConsoleEmitterOption func(ce *ConsoleEmitter)
+
+// WithConsoleColor highlights faults in red and errors and throttles in yellow.
+func WithConsoleColor() ConsoleEmitterOption {
+	return func(ce *ConsoleEmitter) {
+		ce.color = true
+	}
+}
+
+// WithConsoleBufferTTL sets how long streamed subsegments wait for their root
+// segment before they are dropped. The default is 30 seconds.
+func WithConsoleBufferTTL(ttl time.Duration) ConsoleEmitterOption {
+	return func(ce *ConsoleEmitter) {
+		ce.ttl = ttl
+	}
+}
+
+// NewConsoleEmitter initializes and returns a
+// pointer to an instance of ConsoleEmitter writing to w.
+func NewConsoleEmitter(w io.Writer, opts ...ConsoleEmitterOption) *ConsoleEmitter {
+	ce := &ConsoleEmitter{
+		w:       w,
+		ttl:     defaultConsoleBufferTTL,
+		clock:   &utils.DefaultClock{},
+		pending: make(map[string]*pendingTrace),
+	}
+	for _, opt := range opts {
+		opt(ce)
+	}
+	return ce
+}
+
+type pendingTrace struct {
+	created time.Time
+	nodes   []*consoleNode
+}
+
+// consoleNode is a snapshot of a (Sub)Segment taken at emit time.
+type consoleNode struct {
+	id          string
+	parentID    string
+	name        string
+	start       float64
+	end         float64
+	inProgress  bool
+	fault       bool
+	error       bool
+	throttle    bool
+	annotations map[string]interface{}
+	children    []*consoleNode
+}
+
+// RefreshEmitterWithAddress is a no-op, ConsoleEmitter does not use the daemon.
+func (ce *ConsoleEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {}
+
+// RefreshEmitterWithUnixAddress is a no-op, ConsoleEmitter does not use the daemon.
+func (ce *ConsoleEmitter) RefreshEmitterWithUnixAddress(raddr *net.UnixAddr) {}
+
+// Emit prints the trace tree of a root segment, or buffers a streamed subsegment
+// until its root segment is emitted.
+// seg has a write lock acquired by the caller.
+func (ce *ConsoleEmitter) Emit(seg *Segment) {
+	if seg == nil || !seg.ParentSegment.Sampled {
+		return
+	}
+
+	node := snapshotNode(seg)
+
+	ce.Lock()
+	defer ce.Unlock()
+
+	now := ce.clock.Now()
+	for id, p := range ce.pending {
+		if now.Sub(p.created) > ce.ttl {
+			logger.Debugf("Dropping %d buffered subsegments of trace %s", len(p.nodes), id)
+			delete(ce.pending, id)
+		}
+	}
+
+	if seg.parent != nil && !seg.parent.Facade {
+		p := ce.pending[seg.TraceID]
+		if p == nil {
+			p = &pendingTrace{created: now}
+			ce.pending[seg.TraceID] = p
+		}
+		p.nodes = append(p.nodes, node)
+		return
+	}
+
+	if p := ce.pending[seg.TraceID]; p != nil {
+		p.nodes = attachNodes(node, p.nodes, seg.parent == nil)
+		if len(p.nodes) == 0 {
+			delete(ce.pending, seg.TraceID)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "trace %s\n", seg.TraceID)
+	ce.render(&b, node, 1)
+	if _, err := io.WriteString(ce.w, b.String()); err != nil {
+		seg.log().Error(err)
+	}
+}
+
+// snapshotNode copies the fields of seg and its attached subsegments.
+// seg has a write lock acquired by the caller.
+func snapshotNode(seg *Segment) *consoleNode {
+	n := &consoleNode{
+		id:         seg.ID,
+		parentID:   seg.ParentID,
+		name:       seg.Name,
+		start:      seg.StartTime,
+		end:        seg.EndTime,
+		inProgress: seg.InProgress,
+		fault:      seg.Fault,
+		error:      seg.Error,
+		throttle:   seg.Throttle,
+	}
+	if len(seg.Annotations) > 0 {
+		n.annotations = make(map[string]interface{}, len(seg.Annotations))
+		for k, v := range seg.Annotations {
+			n.annotations[k] = v
+		}
+	}
+	for _, s := range seg.rawSubsegments {
+		s.Lock()
+		n.children = append(n.children, snapshotNode(s))
+		s.Unlock()
+	}
+	return n
+}
+
+// attachNodes adds the buffered nodes to the tree of root by parent ID and
+// returns the nodes that could not be attached. If adoptOrphans is true,
+// nodes without a known parent are attached to root itself.
+func attachNodes(root *consoleNode, nodes []*consoleNode, adoptOrphans bool) []*consoleNode {
+	index := make(map[string]*consoleNode)
+	var walk func(n *consoleNode)
+	walk = func(n *consoleNode) {
+		index[n.id] = n
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	for attached := true; attached && len(nodes) > 0; {
+		attached = false
+		var rest []*consoleNode
+		for _, n := range nodes {
+			if parent, ok := index[n.parentID]; ok {
+				parent.children = append(parent.children, n)
+				walk(n)
+				attached = true
+			} else {
+				rest = append(rest, n)
+			}
+		}
+		nodes = rest
+	}
+
+	if adoptOrphans {
+		root.children = append(root.children, nodes...)
+		return nil
+	}
+	return nodes
+}
+
+func (ce *ConsoleEmitter) render(b *strings.Builder, n *consoleNode, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+
+	var color string
+	if ce.color {
+		if n.fault {
+			color = colorRed
+		} else if n.error || n.throttle {
+			color = colorYellow
+		}
+	}
+	b.WriteString(color)
+	b.WriteString(n.name)
+	if n.inProgress {
+		b.WriteString(" (in progress)")
+	} else {
+		fmt.Fprintf(b, " (%.3fms)", (n.end-n.start)*1000)
+	}
+	if n.fault {
+		b.WriteString(" fault")
+	}
+	if n.error {
+		b.WriteString(" error")
+	}
+	if n.throttle {
+		b.WriteString(" throttle")
+	}
+	if color != "" {
+		b.WriteString(colorReset)
+	}
+
+	keys := make([]string, 0, len(n.annotations))
+	for k := range n.annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, " %s=%v", k, n.annotations[k])
+	}
+	b.WriteString("\n")
+
+	sort.SliceStable(n.children, func(i, j int) bool {
+		return n.children[i].start < n.children[j].start
+	})
+	for _, c := range n.children {
+		ce.render(b, c, depth+1)
+	}
+}
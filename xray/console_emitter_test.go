@@ -0,0 +1,160 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newConsoleEmitterContext(ce *ConsoleEmitter) context.Context {
+	ctx, _ := ContextWithConfig(context.Background(), Config{
+		Emitter:                ce,
+		SamplingStrategy:       &TestSamplingStrategy{},
+		ContextMissingStrategy: &TestContextMissingStrategy{},
+		StreamingStrategy:      &TestStreamingStrategy{},
+	})
+	return ctx
+}
+
+// renderedNames strips durations, flags and annotations from the rendered tree.
+func renderedNames(out string) []string {
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		if i := strings.Index(l, " ("); i >= 0 {
+			l = l[:i]
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func TestConsoleEmitterTree(t *testing.T) {
+	var buf bytes.Buffer
+	ce := NewConsoleEmitter(&buf)
+	ctx := newConsoleEmitterContext(ce)
+
+	ctx, root := BeginSegment(ctx, "root")
+	ctx1, a := BeginSubsegment(ctx, "a")
+	_, b := BeginSubsegment(ctx1, "b")
+	assert.NoError(t, b.AddAnnotation("key", "value"))
+	b.Close(errors.New("failed"))
+	a.Close(nil)
+	_, c := BeginSubsegment(ctx, "c")
+	c.Close(nil)
+	root.Close(nil)
+
+	out := buf.String()
+	assert.Equal(t, []string{
+		"trace " + root.TraceID,
+		"  root",
+		"    a",
+		"      b",
+		"    c",
+	}, renderedNames(out))
+	assert.Contains(t, out, "      b (")
+	assert.Contains(t, out, "ms) fault key=value\n")
+}
+
+func TestConsoleEmitterStreamedSubsegments(t *testing.T) {
+	var buf bytes.Buffer
+	ce := NewConsoleEmitter(&buf)
+	ctx := newConsoleEmitterContext(ce)
+
+	ctx, root := BeginSegment(ctx, "root")
+	ctx1, a := BeginSubsegment(ctx, "a")
+	ctx2, b := BeginSubsegment(ctx1, "b")
+	_, c := BeginSubsegment(ctx2, "c")
+
+	// streamed children arrive before their root, deepest first
+	c.CloseAndStream(nil)
+	b.CloseAndStream(nil)
+	assert.Empty(t, buf.String())
+	assert.Len(t, ce.pending[root.TraceID].nodes, 2)
+
+	a.Close(nil)
+	root.Close(nil)
+
+	assert.Equal(t, []string{
+		"trace " + root.TraceID,
+		"  root",
+		"    a",
+		"      b",
+		"        c",
+	}, renderedNames(buf.String()))
+	assert.Empty(t, ce.pending)
+}
+
+func TestConsoleEmitterEviction(t *testing.T) {
+	var buf bytes.Buffer
+	clock := &utils.MockClock{NowTime: 1500000000}
+	ce := NewConsoleEmitter(&buf, WithConsoleBufferTTL(10*time.Second))
+	ce.clock = clock
+	ctx := newConsoleEmitterContext(ce)
+
+	ctx1, root1 := BeginSegment(ctx, "root1")
+	_, a := BeginSubsegment(ctx1, "a")
+	a.CloseAndStream(nil)
+	assert.Len(t, ce.pending, 1)
+
+	clock.Increment(11, 0)
+
+	_, root2 := BeginSegment(ctx, "root2")
+	root2.Close(nil)
+	assert.Empty(t, ce.pending)
+
+	root1.Close(nil)
+	assert.Equal(t, []string{
+		"trace " + root2.TraceID,
+		"  root2",
+		"trace " + root1.TraceID,
+		"  root1",
+	}, renderedNames(buf.String()))
+}
+
+func TestConsoleEmitterColor(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := newConsoleEmitterContext(NewConsoleEmitter(&buf, WithConsoleColor()))
+
+	_, root := BeginSegment(ctx, "root")
+	root.Close(errors.New("failed"))
+
+	assert.Contains(t, buf.String(), colorRed+"root (")
+	assert.Contains(t, buf.String(), "fault"+colorReset)
+}
+
+func TestConsoleEmitterConcurrentEmit(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := newConsoleEmitterContext(NewConsoleEmitter(&buf))
+
+	var wg sync.WaitGroup
+	n := 50
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			ctx, root := BeginSegment(ctx, "root")
+			_, a := BeginSubsegment(ctx, "a")
+			a.CloseAndStream(nil)
+			root.Close(nil)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, n, strings.Count(buf.String(), "  root ("))
+	assert.Equal(t, n, strings.Count(buf.String(), "    a ("))
+}
@@ -11,6 +11,8 @@ package xray
 import (
 	"context"
 	"errors"
+
+	"github.com/aws/aws-xray-sdk-go/header"
 )
 
 // ContextKeytype defines integer to be type of ContextKey.
@@ -63,17 +65,93 @@ func GetSegment(ctx context.Context) *Segment {
 
 // TraceID returns the canonical ID of the cross-service trace from the
 // given segment in ctx. The value can be used in X-Ray's UI to uniquely
-// identify the code paths executed. If no segment is provided in ctx,
-// an empty string is returned.
+// identify the code paths executed. In a Lambda function, the trace ID of
+// the invocation is returned even before a subsegment is begun. If no
+// segment is provided in ctx, or the SDK is disabled, an empty string is
+// returned. TraceID is safe to call while the segment is being closed.
 func TraceID(ctx context.Context) string {
+	if SDKDisabled() {
+		return ""
+	}
 
 	if seg := GetSegment(ctx); seg != nil {
+		if seg.isDisabled() {
+			return ""
+		}
+		seg.RLock()
+		defer seg.RUnlock()
 		return seg.TraceID
 	}
 
+	if th := getTraceHeaderFromContext(ctx); th != nil {
+		return th.TraceID
+	}
 	return ""
 }
 
+// SegmentID returns the ID of the segment or subsegment in ctx, e.g. for
+// correlating log entries with it. In a Lambda function, the ID of the
+// segment of the invocation is returned before a subsegment is begun. If no
+// segment is provided in ctx, or the SDK is disabled, an empty string is
+// returned.
+func SegmentID(ctx context.Context) string {
+	if SDKDisabled() {
+		return ""
+	}
+
+	if seg := GetSegment(ctx); seg != nil {
+		if seg.isDisabled() {
+			return ""
+		}
+		seg.RLock()
+		defer seg.RUnlock()
+		return seg.ID
+	}
+
+	if th := getTraceHeaderFromContext(ctx); th != nil {
+		return th.ParentID
+	}
+	return ""
+}
+
+// TraceHeaderValue returns the value of the X-Amzn-Trace-Id header to pass
+// to calls made within the segment or subsegment in ctx, for propagating the
+// trace manually where no instrumented client is used. In a Lambda function,
+// the header of the invocation is returned before a subsegment is begun. If
+// no segment is provided in ctx, or the SDK is disabled, an empty string is
+// returned, except in passthrough mode where the incoming header is returned
+// unchanged, see Config.Passthrough.
+func TraceHeaderValue(ctx context.Context) string {
+	seg := GetSegment(ctx)
+	if seg != nil && seg.isDisabled() {
+		if th := seg.propagated; th != nil && th.TraceID != "" {
+			return th.String()
+		}
+		return ""
+	}
+	if SDKDisabled() {
+		return ""
+	}
+
+	if seg == nil {
+		if th := getTraceHeaderFromContext(ctx); th != nil {
+			return th.String()
+		}
+		return ""
+	}
+
+	seg.RLock()
+	id := seg.ID
+	seg.RUnlock()
+
+	root := seg.ParentSegment
+	root.RLock()
+	th := root.DownstreamHeader()
+	root.RUnlock()
+	th.ParentID = id
+	return th.String()
+}
+
 // RequestWasTraced returns true if the context contains an X-Ray segment
 // that was created from an HTTP request that contained a trace header.
 // This is useful to ensure that a service is only called from X-Ray traced
@@ -87,6 +165,40 @@ func RequestWasTraced(ctx context.Context) bool {
 	return false
 }
 
+// TraceHeaderFromContext returns a copy of the trace header of the request
+// which began the segment in ctx, as parsed by Handler or the gRPC server
+// interceptors. For segments beginning a new trace, the header of the new
+// trace is returned instead. If no segment is provided in ctx, nil is
+// returned.
+func TraceHeaderFromContext(ctx context.Context) *header.Header {
+	seg := GetSegment(ctx)
+	if seg == nil {
+		return nil
+	}
+	root := seg.ParentSegment
+	root.RLock()
+	defer root.RUnlock()
+
+	th := &header.Header{
+		TraceID:          root.TraceID,
+		ParentID:         root.ParentID,
+		SamplingDecision: header.NotSampled,
+		AdditionalData:   make(map[string]string),
+	}
+	if root.Sampled {
+		th.SamplingDecision = header.Sampled
+	}
+	if in := root.IncomingHeader; in != nil {
+		if in.TraceID != "" {
+			th.TraceID, th.ParentID, th.SamplingDecision = in.TraceID, in.ParentID, in.SamplingDecision
+		}
+		for k, v := range in.AdditionalData {
+			th.AdditionalData[k] = v
+		}
+	}
+	return th
+}
+
 // DetachContext returns a new context with the existing segment.
 // This is useful for creating background tasks which won't be cancelled
 // when a request completes.
@@ -94,6 +206,61 @@ func DetachContext(ctx context.Context) context.Context {
 	return context.WithValue(context.Background(), ContextKey, GetSegment(ctx))
 }
 
+type samplingAttributesKey struct{}
+
+// ContextWithSamplingAttributes returns a copy of ctx carrying attributes,
+// which are matched against the Attributes of centralized sampling rules
+// when a segment is begun with the returned context. They are added to the
+// attributes already carried by ctx.
+func ContextWithSamplingAttributes(ctx context.Context, attributes map[string]string) context.Context {
+	merged := make(map[string]string, len(attributes))
+	for k, v := range samplingAttributes(ctx) {
+		merged[k] = v
+	}
+	for k, v := range attributes {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, samplingAttributesKey{}, merged)
+}
+
+func samplingAttributes(ctx context.Context) map[string]string {
+	attributes, _ := ctx.Value(samplingAttributesKey{}).(map[string]string)
+	return attributes
+}
+
+type samplingHeadersKey struct{}
+
+// contextWithSamplingHeaders returns a copy of ctx carrying the headers
+// matched against the headers of local sampling rules.
+func contextWithSamplingHeaders(ctx context.Context, headers map[string]string) context.Context {
+	return context.WithValue(ctx, samplingHeadersKey{}, headers)
+}
+
+func samplingHeaders(ctx context.Context) map[string]string {
+	headers, _ := ctx.Value(samplingHeadersKey{}).(map[string]string)
+	return headers
+}
+
+type samplingOverrideKey struct{}
+
+// WithSamplingOverride returns a copy of ctx forcing the sampling decision of
+// segments begun with it, e.g. to trace a request carrying a debug header or
+// to suppress health checks. The override takes precedence over the decision
+// of the incoming trace header and of the sampling strategy, which isn't
+// consulted, so that forced decisions aren't counted in the statistics of its
+// rules. Segments forced to be sampled are annotated with xray_forced, and
+// the decision is propagated downstream by their trace headers.
+func WithSamplingOverride(ctx context.Context, decision bool) context.Context {
+	return context.WithValue(ctx, samplingOverrideKey{}, decision)
+}
+
+func samplingOverride(ctx context.Context) *bool {
+	if sampled, ok := ctx.Value(samplingOverrideKey{}).(bool); ok {
+		return &sampled
+	}
+	return nil
+}
+
 // AddAnnotation adds an annotation to the provided segment or subsegment in ctx.
 func AddAnnotation(ctx context.Context, key string, value interface{}) error {
 	if seg := GetSegment(ctx); seg != nil {
@@ -118,6 +285,30 @@ func AddMetadataToNamespace(ctx context.Context, namespace string, key string, v
 	return ErrRetrieveSegment
 }
 
+// AddFeatureFlag records the variant of a feature flag on the root segment of the segment or subsegment in ctx.
+func AddFeatureFlag(ctx context.Context, flagName string, variant interface{}) error {
+	if seg := GetSegment(ctx); seg != nil {
+		return seg.AddFeatureFlag(flagName, variant)
+	}
+	return ErrRetrieveSegment
+}
+
+// AddFeatureFlags records the variants of several feature flags on the root segment of the segment or subsegment in ctx.
+func AddFeatureFlags(ctx context.Context, flags map[string]interface{}) error {
+	if seg := GetSegment(ctx); seg != nil {
+		return seg.AddFeatureFlags(flags)
+	}
+	return ErrRetrieveSegment
+}
+
+// SetPIIFlag marks the trace of the segment or subsegment in ctx as containing PII, see Segment.SetPIIFlag.
+func SetPIIFlag(ctx context.Context, categories ...string) error {
+	if seg := GetSegment(ctx); seg != nil {
+		return seg.SetPIIFlag(categories...)
+	}
+	return ErrRetrieveSegment
+}
+
 // AddError adds an error to the provided segment or subsegment in ctx.
 func AddError(ctx context.Context, err error) error {
 	if seg := GetSegment(ctx); seg != nil {
@@ -10,9 +10,15 @@ package xray
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
 	"testing"
 
+	"github.com/aws/aws-xray-sdk-go/header"
 	"github.com/aws/aws-xray-sdk-go/strategy/exception"
 	"github.com/stretchr/testify/assert"
 )
@@ -32,6 +38,77 @@ func TestEmptyTraceID(t *testing.T) {
 	assert.Empty(t, traceID)
 }
 
+func TestTraceIDSDKDisabled(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := BeginSegment(ctx, "test")
+	defer seg.Close(nil)
+	t.Setenv("AWS_XRAY_SDK_DISABLED", "TRUE")
+
+	assert.Empty(t, TraceID(ctx))
+	assert.Empty(t, SegmentID(ctx))
+	assert.Empty(t, TraceHeaderValue(ctx))
+
+	// segments begun while the SDK is disabled
+	ctx, seg = BeginSegment(context.Background(), "disabled")
+	defer seg.Close(nil)
+	assert.Empty(t, TraceID(ctx))
+}
+
+func TestTraceIDLambda(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx = context.WithValue(ctx, LambdaTraceHeaderKey, ExampleTraceHeader)
+	assert.Equal(t, "1-57ff426a-80c11c39b0c928905eb0828d", TraceID(ctx))
+	assert.Equal(t, "1234abcd1234abcd", SegmentID(ctx))
+	assert.Equal(t, ExampleTraceHeader, TraceHeaderValue(ctx))
+}
+
+func TestSegmentIDAndTraceHeaderValue(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	assert.Empty(t, SegmentID(ctx))
+	assert.Empty(t, TraceHeaderValue(ctx))
+
+	in := header.FromString("Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1;Foo=bar")
+	ctx, seg := NewSegmentFromHeader(ctx, "test", &http.Request{URL: &url.URL{}}, in)
+	defer seg.Close(nil)
+	ctx, subseg := BeginSubsegment(ctx, "sub")
+	defer subseg.Close(nil)
+
+	assert.Equal(t, subseg.ID, SegmentID(ctx))
+	assert.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", TraceID(ctx))
+	assert.Equal(t, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent="+subseg.ID+";Sampled=1;Foo=bar", TraceHeaderValue(ctx))
+}
+
+func TestTraceIDConcurrentClose(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := BeginSegment(ctx, "test")
+	subCtx, subseg := BeginSubsegment(ctx, "sub")
+	traceID := seg.TraceID
+
+	var wg sync.WaitGroup
+	for _, c := range []context.Context{ctx, subCtx} {
+		wg.Add(1)
+		go func(c context.Context) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				assert.Equal(t, traceID, TraceID(c))
+				assert.NotEmpty(t, SegmentID(c))
+				assert.NotEmpty(t, TraceHeaderValue(c))
+			}
+		}(c)
+	}
+	subseg.Close(nil)
+	seg.Close(nil)
+	wg.Wait()
+}
+
 func TestRequestWasNotTraced(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -41,6 +118,34 @@ func TestRequestWasNotTraced(t *testing.T) {
 	assert.Equal(t, seg.RequestWasTraced, RequestWasTraced(ctx))
 }
 
+func TestTraceHeaderFromContext(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	assert.Nil(t, TraceHeaderFromContext(ctx))
+
+	// new trace
+	ctx1, seg := BeginSegment(ctx, "test")
+	th := TraceHeaderFromContext(ctx1)
+	assert.Equal(t, seg.TraceID, th.TraceID)
+	assert.Empty(t, th.ParentID)
+	assert.Equal(t, header.Sampled, th.SamplingDecision)
+	seg.Close(nil)
+
+	// continued trace, from a subsegment
+	in := header.FromString("Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1;Foo=bar")
+	ctx2, seg := NewSegmentFromHeader(ctx, "test", &http.Request{URL: &url.URL{}}, in)
+	defer seg.Close(nil)
+	ctx2, subseg := BeginSubsegment(ctx2, "sub")
+	defer subseg.Close(nil)
+	th = TraceHeaderFromContext(ctx2)
+	assert.Equal(t, in, th)
+
+	// a copy is returned
+	th.AdditionalData["Foo"] = "baz"
+	assert.Equal(t, "bar", in.AdditionalData["Foo"])
+}
+
 func TestDetachContext(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -142,6 +247,126 @@ func TestSimpleMetadata(t *testing.T) {
 	assert.Equal(t, true, seg.Metadata["default"]["bool"])
 }
 
+func TestFeatureFlags(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	ctx1, sub1 := BeginSubsegment(ctx, "sub1")
+	ctx2, sub2 := BeginSubsegment(ctx1, "sub2")
+
+	assert.NoError(t, AddFeatureFlag(ctx2, "new-checkout", "treatment"))
+	assert.NoError(t, AddFeatureFlag(ctx1, "dark-mode", true))
+	assert.NoError(t, AddFeatureFlags(ctx2, map[string]interface{}{
+		"new-checkout": "control",
+		"max-items":    25,
+	}))
+	assert.Error(t, AddFeatureFlag(ctx2, "object", struct{}{}))
+
+	sub2.Close(nil)
+	sub1.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{
+		"new-checkout": "control",
+		"dark-mode":    true,
+		"max-items":    25.0, //json encoder turns this into a float64
+	}, seg.Metadata["feature_flags"])
+
+	var s1, s2 *Segment
+	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &s1)) && assert.NoError(t, json.Unmarshal(s1.Subsegments[0], &s2)) {
+		assert.Nil(t, s1.Metadata)
+		assert.Nil(t, s2.Metadata)
+	}
+}
+
+func TestFeatureFlagsBounded(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	for i := 0; i < maxFeatureFlags; i++ {
+		assert.NoError(t, AddFeatureFlag(ctx, fmt.Sprintf("flag-%d", i), i))
+	}
+	assert.Error(t, AddFeatureFlag(ctx, "one-too-many", true))
+	// existing flags can still be updated
+	assert.NoError(t, AddFeatureFlag(ctx, "flag-0", "updated"))
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, seg.Metadata["feature_flags"], maxFeatureFlags)
+	assert.Equal(t, "updated", seg.Metadata["feature_flags"]["flag-0"])
+	assert.NotContains(t, seg.Metadata["feature_flags"], "one-too-many")
+}
+
+func TestSetPIIFlag(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	// stream completed subsegments so that they are emitted before the root
+	dss, _ := NewDefaultStreamingStrategyWithMaxSubsegmentCount(1)
+	GetRecorder(ctx).StreamingStrategy = dss
+
+	ctx, root := BeginSegment(ctx, "Test")
+	ctx1, sub1 := BeginSubsegment(ctx, "sub1")
+	ctx2, sub2 := BeginSubsegment(ctx1, "sub2")
+	ctx3, sub3 := BeginSubsegment(ctx2, "sub3")
+
+	assert.NoError(t, SetPIIFlag(ctx3, "email"))
+	sub3.Close(nil)
+	assert.NoError(t, SetPIIFlag(ctx2, "payment", "email"))
+	sub2.Close(nil)
+	sub1.Close(nil)
+	root.Close(nil)
+
+	var streamed int
+	for {
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		if seg.Type == "subsegment" {
+			assert.NotContains(t, seg.Annotations, "pii")
+			streamed++
+			continue
+		}
+		assert.NotZero(t, streamed)
+		assert.Equal(t, "Test", seg.Name)
+		assert.Equal(t, true, seg.Annotations["pii"])
+		assert.Equal(t, []interface{}{"email", "payment"}, seg.Metadata["pii"]["categories"])
+		return
+	}
+}
+
+func TestSetPIIFlagBounded(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	for i := 0; i < maxPIICategories; i++ {
+		assert.NoError(t, SetPIIFlag(ctx, fmt.Sprintf("category-%02d", i)))
+	}
+	assert.Error(t, SetPIIFlag(ctx, "one-too-many"))
+	// recorded categories can still be set again
+	assert.NoError(t, SetPIIFlag(ctx, "category-00"))
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, true, seg.Annotations["pii"])
+	assert.Len(t, seg.Metadata["pii"]["categories"], maxPIICategories)
+	assert.NotContains(t, seg.Metadata["pii"]["categories"], "one-too-many")
+}
+
 func TestAddError(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
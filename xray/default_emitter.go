@@ -9,10 +9,11 @@
 package xray
 
 import (
-	"encoding/json"
+	"context"
 	"net"
 	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 )
@@ -20,11 +21,36 @@ import (
 // Header is added before sending segments to daemon.
 const Header = `{"format": "json", "version": 1}` + "\n"
 
+// maxEmitFailures is the number of failed writes since the daemon was last
+// dialed after which DefaultEmitter resolves and dials its address again.
+// Writes in between may succeed: once a UDP daemon is gone, writes only fail
+// every other time, when the ICMP error of the previous one is reported.
+const maxEmitFailures = 3
+
+var (
+	// minRedialBackoff and maxRedialBackoff bound the delay between the
+	// attempts of DefaultEmitter to reconnect to an unreachable daemon.
+	minRedialBackoff = time.Second
+	maxRedialBackoff = time.Minute
+
+	resolveUDPAddr = net.ResolveUDPAddr
+)
+
 // DefaultEmitter provides the naive implementation of emitting trace entities.
+// After repeated failures to send to the daemon, as when its IP address
+// changes, DefaultEmitter resolves and dials the daemon address again in the
+// background, backing off while the daemon stays unreachable.
 type DefaultEmitter struct {
 	sync.Mutex
-	conn *net.UDPConn
-	addr *net.UDPAddr
+	conn     net.Conn
+	addr     *net.UDPAddr
+	unixAddr *net.UnixAddr
+
+	hostPort     string // daemon address to resolve when redialing, if known
+	failures     int
+	backoff      time.Duration
+	nextRedial   time.Time
+	reconnecting bool
 }
 
 // NewDefaultEmitter initializes and returns a
@@ -35,23 +61,132 @@ func NewDefaultEmitter(raddr *net.UDPAddr) (*DefaultEmitter, error) {
 	return d, nil
 }
 
+// NewDefaultEmitterWithUnixAddr initializes and returns a pointer to an
+// instance of DefaultEmitter sending segments to the Unix domain socket
+// of the daemon.
+func NewDefaultEmitterWithUnixAddr(raddr *net.UnixAddr) (*DefaultEmitter, error) {
+	initLambda()
+	d := &DefaultEmitter{unixAddr: raddr}
+	return d, nil
+}
+
 // RefreshEmitterWithAddress dials UDP based on the input UDP address.
 func (de *DefaultEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {
+	de.refreshEmitterWithHostPort(raddr, "")
+}
+
+// refreshEmitterWithHostPort dials UDP based on the input UDP address like
+// RefreshEmitterWithAddress, keeping hostPort, the address raddr was resolved
+// from, to resolve again when reconnecting.
+func (de *DefaultEmitter) refreshEmitterWithHostPort(raddr *net.UDPAddr, hostPort string) {
 	de.Lock()
-	de.refresh(raddr)
+	de.addr = raddr
+	de.unixAddr = nil
+	de.hostPort = hostPort
+	de.resetFailures()
+	de.refresh()
 	de.Unlock()
 }
 
-func (de *DefaultEmitter) refresh(raddr *net.UDPAddr) (err error) {
-	de.conn, err = net.DialUDP("udp", nil, raddr)
-	de.addr = raddr
+// RefreshEmitterWithUnixAddress dials the Unix domain socket of the daemon
+// based on the input Unix address.
+func (de *DefaultEmitter) RefreshEmitterWithUnixAddress(raddr *net.UnixAddr) {
+	de.Lock()
+	de.addr = nil
+	de.unixAddr = raddr
+	de.hostPort = ""
+	de.resetFailures()
+	de.refresh()
+	de.Unlock()
+}
+
+func (de *DefaultEmitter) refresh() error {
+	if de.unixAddr != nil {
+		conn, err := net.DialUnix("unixgram", nil, de.unixAddr)
+		if err != nil {
+			de.conn = nil
+			logger.Errorf("Error dialing emitter address %v: %s", de.unixAddr, err)
+			return err
+		}
+		de.conn = conn
+		logger.Infof("Emitter using address: %v", de.unixAddr)
+		return nil
+	}
 
+	conn, err := net.DialUDP("udp", nil, de.addr)
 	if err != nil {
-		logger.Errorf("Error dialing emitter address %v: %s", raddr, err)
+		de.conn = nil
+		logger.Errorf("Error dialing emitter address %v: %s", de.addr, err)
 		return err
 	}
+	de.conn = conn
+	logger.Infof("Emitter using address: %v", de.addr)
+	return nil
+}
+
+// resetFailures forgets the failed writes and redial backoff.
+// de has a lock acquired by the caller.
+func (de *DefaultEmitter) resetFailures() {
+	de.failures = 0
+	de.backoff = 0
+	de.nextRedial = time.Time{}
+}
+
+// writeFailed counts a failed write and starts reconnecting in the background
+// once writes keep failing, unless an attempt is running or backing off.
+// de has a lock acquired by the caller.
+func (de *DefaultEmitter) writeFailed() {
+	de.failures++
+	if de.failures < maxEmitFailures || de.reconnecting || de.addr == nil {
+		return
+	}
+	now := time.Now()
+	if now.Before(de.nextRedial) {
+		return
+	}
+	de.backoff *= 2
+	if de.backoff < minRedialBackoff {
+		de.backoff = minRedialBackoff
+	} else if de.backoff > maxRedialBackoff {
+		de.backoff = maxRedialBackoff
+	}
+	de.nextRedial = now.Add(de.backoff)
+	de.reconnecting = true
+	go de.reconnect(de.addr, de.hostPort)
+}
 
-	logger.Infof("Emitter using address: %v", raddr)
+// reconnect resolves hostPort, if known, without holding the lock so Emit
+// doesn't block on DNS, then dials the daemon unless the emitter has been
+// pointed to another address meanwhile.
+func (de *DefaultEmitter) reconnect(addr *net.UDPAddr, hostPort string) {
+	raddr := addr
+	if hostPort != "" {
+		if resolved, err := resolveUDPAddr("udp", hostPort); err != nil {
+			logger.Errorf("Error resolving emitter address %s: %s", hostPort, err)
+		} else {
+			raddr = resolved
+		}
+	}
+
+	de.Lock()
+	defer de.Unlock()
+	de.reconnecting = false
+	if de.addr != addr || de.hostPort != hostPort {
+		return
+	}
+	if de.conn != nil {
+		de.conn.Close()
+	}
+	de.addr = raddr
+	de.failures = 0
+	de.refresh()
+}
+
+// Flush waits for the segments being emitted to be written, see Flusher.
+// DefaultEmitter writes segments as they are emitted.
+func (de *DefaultEmitter) Flush(ctx context.Context) error {
+	de.Lock()
+	de.Unlock()
 	return nil
 }
 
@@ -60,52 +195,63 @@ func (de *DefaultEmitter) refresh(raddr *net.UDPAddr) (err error) {
 func (de *DefaultEmitter) Emit(seg *Segment) {
 	defer func() {
 		if r := recover(); r != nil {
-			logger.Errorf("Panic emitting segment: %s\n%s", r, string(debug.Stack()))
+			seg.log().Errorf("Panic emitting segment: %s\n%s", r, string(debug.Stack()))
 		}
 	}()
-	HeaderBytes := []byte(Header)
-
 	if seg == nil || !seg.ParentSegment.Sampled {
 		return
 	}
 
+	buf := getEncodeBuffer()
+	defer putEncodeBuffer(buf)
+
 	for _, p := range packSegments(seg, nil) {
-		logger.Debug(string(p))
+		seg.log().DebugDeferred(func() string { return string(p) })
 
 		de.Lock()
 
 		if de.conn == nil {
-			if err := de.refresh(de.addr); err != nil {
+			if err := de.refresh(); err != nil {
 				de.Unlock()
+				emitErrors.add(seg.metricsObserver(), 1)
 				return
 			}
 		}
 
-		_, err := de.conn.Write(append(HeaderBytes, p...))
+		*buf = append(append((*buf)[:0], Header...), p...)
+		_, err := de.conn.Write(*buf)
 		if err != nil {
-			logger.Error(err)
+			de.writeFailed()
 		}
 		de.Unlock()
+		if err != nil {
+			seg.log().Error(err)
+			emitErrors.add(seg.metricsObserver(), 1)
+		} else {
+			segmentsEmitted.add(seg.metricsObserver(), 1)
+		}
 	}
 }
 
 // seg has a write lock acquired by the caller.
 func packSegments(seg *Segment, outSegments [][]byte) [][]byte {
+	if seg.packed != nil {
+		return append(outSegments, seg.packed...)
+	}
+
 	trimSubsegment := func(s *Segment) []byte {
-		ss := globalCfg.StreamingStrategy()
-		if seg.ParentSegment.Configuration != nil && seg.ParentSegment.Configuration.StreamingStrategy != nil {
-			ss = seg.ParentSegment.Configuration.StreamingStrategy
-		}
+		ss := seg.streamingStrategy()
 		for ss.RequiresStreaming(s) {
 			if len(s.rawSubsegments) == 0 {
 				break
 			}
 			cb := ss.StreamCompletedSubsegments(s)
 			outSegments = append(outSegments, cb...)
+			subsegmentsStreamed.add(seg.metricsObserver(), int64(len(cb)))
 		}
-		b, err := json.Marshal(s)
+		b, err := marshalEmittedSegment(s)
 		if err != nil {
-			logger.Errorf("JSON error while marshalling (Sub)Segment: %v", err)
+			s.log().Errorf("JSON error while marshalling (Sub)Segment: %v", err)
 		}
 		return b
 	}
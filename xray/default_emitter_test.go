@@ -9,10 +9,15 @@
 package xray
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -194,3 +199,142 @@ func TestDefaultEmitterWithPanic(t *testing.T) {
 	}
 	emitter.Emit(seg)
 }
+
+func TestDefaultEmitterWithUnixSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram sockets are not supported on windows")
+	}
+	addr := &net.UnixAddr{Name: filepath.Join(t.TempDir(), "xray.sock"), Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	emitter, err := NewDefaultEmitter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := ContextWithConfig(context.Background(), Config{
+		DaemonAddr:       "unix:" + addr.Name,
+		Emitter:          emitter,
+		SamplingStrategy: &TestSamplingStrategy{},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, seg := BeginSegment(ctx, "unix")
+	seg.Close(nil)
+
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	buffer := make([]byte, 64*1024)
+	n, err := conn.Read(buffer)
+	if !assert.NoError(t, err) {
+		return
+	}
+	b := buffer[:n]
+	if assert.True(t, bytes.HasPrefix(b, []byte(Header))) {
+		received := &Segment{}
+		assert.NoError(t, json.Unmarshal(b[len(Header):], received))
+		assert.Equal(t, "unix", received.Name)
+		assert.Equal(t, seg.ID, received.ID)
+	}
+}
+
+func TestDefaultEmitterReconnectsToMovedDaemon(t *testing.T) {
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	daemon := listen()
+	defer func() { daemon.Close() }()
+
+	var mu sync.Mutex
+	resolved := daemon.LocalAddr().(*net.UDPAddr)
+	defer func(resolve func(string, string) (*net.UDPAddr, error), backoff time.Duration) {
+		resolveUDPAddr = resolve
+		minRedialBackoff = backoff
+	}(resolveUDPAddr, minRedialBackoff)
+	resolveUDPAddr = func(network, address string) (*net.UDPAddr, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return resolved, nil
+	}
+	minRedialBackoff = 10 * time.Millisecond
+
+	emitter, err := NewDefaultEmitter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitter.refreshEmitterWithHostPort(resolved, "xray-daemon:2000")
+	ctx, err := ContextWithConfig(context.Background(), Config{
+		Emitter:          emitter,
+		SamplingStrategy: &TestSamplingStrategy{},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// emitUntilReceived emits segments until one arrives at the daemon.
+	emitUntilReceived := func(name string) bool {
+		buffer := make([]byte, 64*1024)
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			_, seg := BeginSegment(ctx, name)
+			seg.Close(nil)
+
+			if err := daemon.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := daemon.Read(buffer); err == nil {
+				received := &Segment{}
+				if assert.NoError(t, json.Unmarshal(buffer[len(Header):n], received)) {
+					return assert.Equal(t, name, received.Name)
+				}
+				return false
+			}
+		}
+		return false
+	}
+
+	assert.True(t, emitUntilReceived("before"))
+
+	// The daemon moves to another address, which the emitter only learns by
+	// resolving the daemon address again.
+	daemon.Close()
+	daemon = listen()
+	mu.Lock()
+	resolved = daemon.LocalAddr().(*net.UDPAddr)
+	mu.Unlock()
+
+	assert.True(t, emitUntilReceived("after"), "segments were not emitted to the moved daemon")
+}
+
+func TestDefaultEmitterBacksOffRedialing(t *testing.T) {
+	emitter, err := NewDefaultEmitter(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emitter.Lock()
+	defer emitter.Unlock()
+	for i := 0; i < maxEmitFailures; i++ {
+		emitter.writeFailed()
+	}
+	assert.True(t, emitter.reconnecting)
+	assert.Equal(t, minRedialBackoff, emitter.backoff)
+
+	// Failures while reconnecting or backing off don't start another attempt.
+	emitter.reconnecting = false
+	emitter.writeFailed()
+	assert.False(t, emitter.reconnecting)
+	assert.Equal(t, minRedialBackoff, emitter.backoff)
+
+	emitter.nextRedial = time.Now()
+	emitter.writeFailed()
+	assert.True(t, emitter.reconnecting)
+	assert.Equal(t, 2*minRedialBackoff, emitter.backoff)
+}
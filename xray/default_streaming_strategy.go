@@ -9,20 +9,23 @@
 package xray
 
 import (
-	"encoding/json"
 	"errors"
 	"sync/atomic"
-
-	"github.com/aws/aws-xray-sdk-go/internal/logger"
 )
 
 var defaultMaxSubsegmentCount uint32 = 20
 
+var errInvalidMaxSubsegmentCount = errors.New("maxSubsegmentCount must be a non-negative integer")
+
 // DefaultStreamingStrategy provides a default value of 20
 // for the maximum number of subsegments that can be emitted
 // in a single UDP packet.
 type DefaultStreamingStrategy struct {
+	// MaxSubsegmentCount is read atomically, use SetMaxSubsegmentCount
+	// to change it once the strategy is in use.
 	MaxSubsegmentCount uint32
+
+	streamedSubsegments atomic.Uint64
 }
 
 // NewDefaultStreamingStrategy initializes and returns a
@@ -36,17 +39,36 @@ func NewDefaultStreamingStrategy() (*DefaultStreamingStrategy, error) {
 // with a custom maximum number of subsegments per UDP packet.
 func NewDefaultStreamingStrategyWithMaxSubsegmentCount(maxSubsegmentCount int) (*DefaultStreamingStrategy, error) {
 	if maxSubsegmentCount <= 0 {
-		return nil, errors.New("maxSubsegmentCount must be a non-negative integer")
+		return nil, errInvalidMaxSubsegmentCount
 	}
 	c := uint32(maxSubsegmentCount)
 	return &DefaultStreamingStrategy{MaxSubsegmentCount: c}, nil
 }
 
+// SetMaxSubsegmentCount changes the maximum number of subsegments
+// per UDP packet. It is safe to call while segments are being streamed,
+// the new value applies to the next streaming decision.
+func (dSS *DefaultStreamingStrategy) SetMaxSubsegmentCount(maxSubsegmentCount int) error {
+	if maxSubsegmentCount <= 0 {
+		return errInvalidMaxSubsegmentCount
+	}
+	atomic.StoreUint32(&dSS.MaxSubsegmentCount, uint32(maxSubsegmentCount))
+	return nil
+}
+
+// StreamedSubsegmentCount returns the number of subsegments streamed
+// by StreamCompletedSubsegments since the strategy was created.
+func (dSS *DefaultStreamingStrategy) StreamedSubsegmentCount() uint64 {
+	return dSS.streamedSubsegments.Load()
+}
+
 // RequiresStreaming returns true when the number of subsegment
 // children for a given segment is larger than MaxSubsegmentCount.
+// Subtrees rooted at the subsegments of a facade segment are counted
+// on their own.
 func (dSS *DefaultStreamingStrategy) RequiresStreaming(seg *Segment) bool {
 	if seg.ParentSegment.Sampled {
-		return atomic.LoadUint32(&seg.ParentSegment.totalSubSegments) > dSS.MaxSubsegmentCount
+		return atomic.LoadUint32(&seg.subtreeRoot().totalSubSegments) > atomic.LoadUint32(&dSS.MaxSubsegmentCount)
 	}
 	return false
 }
@@ -54,7 +76,7 @@ func (dSS *DefaultStreamingStrategy) RequiresStreaming(seg *Segment) bool {
 // StreamCompletedSubsegments separates subsegments from the provided
 // segment tree and sends them to daemon as streamed subsegment UDP packets.
 func (dSS *DefaultStreamingStrategy) StreamCompletedSubsegments(seg *Segment) [][]byte {
-	logger.Debug("Beginning to stream subsegments.")
+	seg.log().Debug("Beginning to stream subsegments.")
 	var outSegments [][]byte
 	for i := 0; i < len(seg.rawSubsegments); i++ {
 		child := seg.rawSubsegments[i]
@@ -66,21 +88,22 @@ func (dSS *DefaultStreamingStrategy) StreamCompletedSubsegments(seg *Segment) []
 		seg.Subsegments[len(seg.Subsegments)-1] = nil
 		seg.Subsegments = seg.Subsegments[:len(seg.Subsegments)-1]
 
-		atomic.AddUint32(&seg.ParentSegment.totalSubSegments, ^uint32(0))
+		atomic.AddUint32(&seg.subtreeRoot().totalSubSegments, ^uint32(0))
 
 		// Add extra information into child subsegment
 		child.Lock()
 		child.beforeEmitSubsegment(seg)
-		cb, err := json.Marshal(child)
+		cb, err := marshalEmittedSegment(child)
 		if err != nil {
-			logger.Errorf("JSON error while marshalling subsegment: %v", err)
+			seg.log().Errorf("JSON error while marshalling subsegment: %v", err)
 		}
 		outSegments = append(outSegments, cb)
-		logger.Debugf("Streaming subsegment named '%s' from segment tree.", child.Name)
+		dSS.streamedSubsegments.Add(1)
+		seg.log().Debugf("Streaming subsegment named '%s' from segment tree.", child.Name)
 		child.Unlock()
 
 		break
 	}
-	logger.Debug("Finished streaming subsegments.")
+	seg.log().Debug("Finished streaming subsegments.")
 	return outSegments
 }
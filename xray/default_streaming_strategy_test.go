@@ -9,6 +9,7 @@
 package xray
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,3 +26,63 @@ func TestDefaultStreamingStrategyMaxSegmentSizeParameterValidation(t *testing.T)
 	assert.Nil(t, dss)
 	assert.Error(t, e, "maxSubsegmentCount must be a non-negative integer")
 }
+
+func TestDefaultStreamingStrategySetMaxSubsegmentCount(t *testing.T) {
+	dss, _ := NewDefaultStreamingStrategy()
+
+	assert.Error(t, dss.SetMaxSubsegmentCount(0))
+	assert.Equal(t, defaultMaxSubsegmentCount, dss.MaxSubsegmentCount)
+
+	assert.NoError(t, dss.SetMaxSubsegmentCount(5))
+	assert.Equal(t, uint32(5), dss.MaxSubsegmentCount)
+}
+
+func TestDefaultStreamingStrategySetMaxSubsegmentCountMidTrace(t *testing.T) {
+	dss, _ := NewDefaultStreamingStrategyWithMaxSubsegmentCount(10)
+	ctx, me := newMemoryEmitterContext(t, Config{StreamingStrategy: dss})
+
+	ctx, root := BeginSegment(ctx, "root")
+	for _, name := range []string{"first", "second", "third"} {
+		_, subseg := BeginSubsegment(ctx, name)
+		subseg.Close(nil)
+	}
+	assert.False(t, dss.RequiresStreaming(root))
+
+	assert.NoError(t, dss.SetMaxSubsegmentCount(2))
+	assert.True(t, dss.RequiresStreaming(root))
+	for _, name := range []string{"fourth", "fifth"} {
+		_, subseg := BeginSubsegment(ctx, name)
+		subseg.Close(nil)
+	}
+	root.Close(nil)
+
+	// five subsegments, two of which are kept in the root segment, the others
+	// being streamed as they close once the tree requires streaming
+	assert.Equal(t, uint32(3), root.streamedSubSegments)
+	assert.Zero(t, dss.StreamedSubsegmentCount())
+	assert.Len(t, me.Segments(), 4)
+}
+
+func TestDefaultStreamingStrategyConcurrentSetMaxSubsegmentCount(t *testing.T) {
+	dss, _ := NewDefaultStreamingStrategyWithMaxSubsegmentCount(1)
+	ctx, _ := newMemoryEmitterContext(t, Config{StreamingStrategy: dss})
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(2)
+		go func(n int) {
+			defer wg.Done()
+			assert.NoError(t, dss.SetMaxSubsegmentCount(n))
+		}(i)
+		go func() {
+			defer wg.Done()
+			ctx, root := BeginSegment(ctx, "root")
+			for j := 0; j < 5; j++ {
+				_, subseg := BeginSubsegment(ctx, "sub")
+				subseg.Close(nil)
+			}
+			root.Close(nil)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,86 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// BeginDetachedSubsegment begins a subsegment of the (sub)segment in ctx for
+// work that may outlive it, such as a goroutine started by an HTTP handler
+// that finishes after the response is written. The subsegment belongs to the
+// trace of the (sub)segment in ctx, with it as parent, but it is a standalone
+// document emitted on its own when it's closed, whether or not its parent was
+// emitted already.
+//
+// The returned context derives from context.Background(), keeping only the
+// configuration of ctx, so that cancelling the request doesn't end the
+// subsegment. In a Lambda function, the parent is the facade segment of the
+// invocation. Without a segment in ctx, a new segment is begun instead.
+func BeginDetachedSubsegment(ctx context.Context, name string) (context.Context, *Segment) {
+	detached := context.Background()
+	if cfg := GetRecorder(ctx); cfg != nil {
+		detached = context.WithValue(detached, RecorderContextKey{}, cfg)
+	}
+
+	parent := GetSegment(ctx)
+	if parent == nil && getTraceHeaderFromContext(ctx) != nil {
+		_, parent = newFacadeSegment(ctx)
+	}
+	if parent == nil {
+		return BeginSegment(detached, name)
+	}
+	if parent.isDisabled() {
+		seg := &Segment{propagated: parent.propagated}
+		return context.WithValue(detached, ContextKey, seg), seg
+	}
+
+	if len(name) > 200 {
+		name = name[:200]
+	}
+
+	root := parent.ParentSegment
+	root.samplingMu.RLock()
+	sampled := root.Sampled
+	root.samplingMu.RUnlock()
+
+	parent.RLock()
+	parentID := parent.ID
+	parent.RUnlock()
+
+	// The subsegment is the root of its own tree, so that it and its
+	// subsegments are emitted when it's closed, but it's sent as a
+	// subsegment of the trace.
+	seg := &Segment{
+		Name:          parent.normalizeName(name, root.Configuration),
+		TraceID:       root.TraceID,
+		ParentID:      parentID,
+		Type:          "subsegment",
+		StartTime:     float64(time.Now().UnixNano()) / float64(time.Second),
+		InProgress:    true,
+		Sampled:       sampled,
+		Dummy:         !sampled,
+		Configuration: root.Configuration,
+		flushTracked:  true,
+	}
+	seg.ParentSegment = seg
+	if sampled || strings.ToLower(os.Getenv("AWS_XRAY_NOOP_ID")) == "false" {
+		seg.ID = NewSegmentID()
+	} else {
+		seg.ID = noOpSegmentID()
+	}
+	atomic.AddInt64(&inProgressSegments, 1)
+	seg.log().Debugf("Beginning detached subsegment named %s of %s", name, parentID)
+
+	return context.WithValue(detached, ContextKey, seg), seg
+}
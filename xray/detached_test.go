@@ -0,0 +1,121 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBeginDetachedSubsegmentOutlivesRequest(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	var bgCtx context.Context
+	var bgSeg *Segment
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bgCtx, bgSeg = BeginDetachedSubsegment(r.Context(), "background")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	reqCtx, cancel := context.WithCancel(ctx)
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/jobs", nil).WithContext(reqCtx)
+	Handler(NewFixedSegmentNamer("test"), handler).ServeHTTP(httptest.NewRecorder(), req)
+	cancel()
+
+	root, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, root.Subsegments)
+
+	// the background work goes on after the request is done
+	assert.NoError(t, bgCtx.Err())
+	_, child := BeginSubsegment(bgCtx, "step")
+	child.Close(nil)
+	_, err = td.Recv()
+	assert.Error(t, err, "expected nothing to be emitted before the detached subsegment is closed")
+	bgSeg.Close(nil)
+
+	detached, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "background", detached.Name)
+	assert.Equal(t, "subsegment", detached.Type)
+	assert.Equal(t, root.TraceID, detached.TraceID)
+	assert.Equal(t, root.ID, detached.ParentID)
+	assert.False(t, detached.InProgress)
+	assert.False(t, detached.Fault)
+	assert.Len(t, detached.Subsegments, 1)
+}
+
+func TestBeginDetachedSubsegmentOfSubsegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	ctx, sub := BeginSubsegment(ctx, "sub")
+	_, detached := BeginDetachedSubsegment(ctx, "background")
+	sub.Close(nil)
+	root.Close(nil)
+	detached.Close(nil)
+
+	emittedRoot, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "test", emittedRoot.Name)
+	assert.Equal(t, "background", emitted.Name)
+	assert.Equal(t, root.TraceID, emitted.TraceID)
+	assert.Equal(t, sub.ID, emitted.ParentID)
+}
+
+func TestBeginDetachedSubsegmentLambda(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx = context.WithValue(ctx, LambdaTraceHeaderKey, ExampleTraceHeader)
+
+	_, seg := BeginDetachedSubsegment(ctx, "background")
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "background", emitted.Name)
+	assert.Equal(t, "subsegment", emitted.Type)
+	assert.Equal(t, "1-57ff426a-80c11c39b0c928905eb0828d", emitted.TraceID)
+	assert.Equal(t, "1234abcd1234abcd", emitted.ParentID)
+}
+
+func TestBeginDetachedSubsegmentWithoutSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginDetachedSubsegment(ctx, "background")
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "background", emitted.Name)
+	assert.Empty(t, emitted.Type)
+	assert.Empty(t, emitted.ParentID)
+	assert.NotEmpty(t, emitted.TraceID)
+}
@@ -0,0 +1,169 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var defaultDownstreamSummaryTopN = 10
+
+// DownstreamStats provides the shape for the per host entries of the
+// "downstream_summary" metadata.
+type DownstreamStats struct {
+	Count   int     `json:"count"`
+	TotalMs float64 `json:"total_ms"`
+	MaxMs   float64 `json:"max_ms"`
+	Errors  int     `json:"errors"`
+}
+
+// DownstreamSummaryProcessor records a "downstream_summary" metadata entry on
+// root segments, summarizing the remote subsegments of the trace by host.
+// The host is taken from the URL of the HTTP request or the SQL database of
+// a subsegment, or is the name of the subsegment if it has neither.
+// Subsegments are accounted for when they close, so subsegments which were
+// streamed out before the root segment closed are still included.
+type DownstreamSummaryProcessor struct {
+	TopN int
+}
+
+// NewDownstreamSummaryProcessor initializes and returns a pointer to an
+// instance of DownstreamSummaryProcessor which keeps the topN hosts with the
+// highest total time. A topN of zero or less falls back to a default of 10.
+func NewDownstreamSummaryProcessor(topN int) *DownstreamSummaryProcessor {
+	if topN <= 0 {
+		topN = defaultDownstreamSummaryTopN
+	}
+	return &DownstreamSummaryProcessor{TopN: topN}
+}
+
+// Process adds the summary of the closed remote subsegments to seg's metadata.
+func (p *DownstreamSummaryProcessor) Process(seg *Segment) {
+	if seg.parent != nil {
+		return
+	}
+
+	summary := seg.downstream.top(p.TopN)
+	if len(summary) == 0 {
+		return
+	}
+
+	if seg.Metadata == nil {
+		seg.Metadata = map[string]map[string]interface{}{}
+	}
+	if seg.Metadata["default"] == nil {
+		seg.Metadata["default"] = map[string]interface{}{}
+	}
+	seg.Metadata["default"]["downstream_summary"] = summary
+}
+
+// downstreamSummary accumulates DownstreamStats by host for a root segment.
+// It is guarded by its own lock so that it can be updated while holding the
+// lock of a subsegment.
+type downstreamSummary struct {
+	sync.Mutex
+	hosts map[string]*DownstreamStats
+}
+
+func (d *downstreamSummary) add(host string, ms float64, failed bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.hosts == nil {
+		d.hosts = make(map[string]*DownstreamStats)
+	}
+	s := d.hosts[host]
+	if s == nil {
+		s = &DownstreamStats{}
+		d.hosts[host] = s
+	}
+	s.Count++
+	s.TotalMs += ms
+	if ms > s.MaxMs {
+		s.MaxMs = ms
+	}
+	if failed {
+		s.Errors++
+	}
+}
+
+// top returns the n hosts with the highest total time.
+func (d *downstreamSummary) top(n int) map[string]DownstreamStats {
+	d.Lock()
+	defer d.Unlock()
+
+	hosts := make([]string, 0, len(d.hosts))
+	for h := range d.hosts {
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		ti, tj := d.hosts[hosts[i]].TotalMs, d.hosts[hosts[j]].TotalMs
+		if ti != tj {
+			return ti > tj
+		}
+		return hosts[i] < hosts[j]
+	})
+	if len(hosts) > n {
+		hosts = hosts[:n]
+	}
+
+	ret := make(map[string]DownstreamStats, len(hosts))
+	for _, h := range hosts {
+		ret[h] = *d.hosts[h]
+	}
+	return ret
+}
+
+// recordDownstream adds a closed remote subsegment to the summary kept on
+// its root segment, when the root is configured with a DownstreamSummaryProcessor.
+// Only called within a subsegment locked code block.
+func (seg *Segment) recordDownstream() {
+	if seg.parent == nil || seg.Dummy || seg.Namespace != "remote" || !seg.ParentSegment.summarizesDownstream() {
+		return
+	}
+	ms := (seg.EndTime - seg.StartTime) * 1000
+	seg.ParentSegment.downstream.add(seg.downstreamHost(), ms, seg.Error || seg.Fault || seg.Throttle)
+}
+
+// downstreamHost returns the host of the HTTP request or SQL database of a
+// remote subsegment, or its name if it has neither.
+// Only called within a subsegment locked code block.
+func (seg *Segment) downstreamHost() string {
+	if seg.HTTP != nil && seg.HTTP.Request != nil {
+		if u, err := url.Parse(seg.HTTP.Request.URL); err == nil && u.Host != "" {
+			return u.Host
+		}
+	}
+	if seg.SQL != nil && seg.SQL.URL != "" {
+		// The URL of a database has no scheme unless its DSN had one.
+		raw := seg.SQL.URL
+		if !strings.Contains(raw, "://") {
+			raw = "//" + raw
+		}
+		if u, err := url.Parse(raw); err == nil && u.Host != "" {
+			return u.Host
+		}
+	}
+	return seg.Name
+}
+
+func (seg *Segment) summarizesDownstream() bool {
+	if seg.Configuration == nil {
+		return false
+	}
+	for _, p := range seg.Configuration.PreEmitProcessors {
+		if _, ok := p.(*DownstreamSummaryProcessor); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownstreamSummaryTop(t *testing.T) {
+	d := &downstreamSummary{}
+	d.add("a.example.com", 10, false)
+	d.add("a.example.com", 30, true)
+	d.add("b.example.com", 25, false)
+	d.add("c.example.com", 5, false)
+	d.add("c.example.com", 5, true)
+
+	summary := d.top(2)
+
+	assert.Len(t, summary, 2)
+	assert.Equal(t, DownstreamStats{Count: 2, TotalMs: 40, MaxMs: 30, Errors: 1}, summary["a.example.com"])
+	assert.Equal(t, DownstreamStats{Count: 1, TotalMs: 25, MaxMs: 25, Errors: 0}, summary["b.example.com"])
+	assert.NotContains(t, summary, "c.example.com")
+}
+
+func TestNewDownstreamSummaryProcessorDefault(t *testing.T) {
+	assert.Equal(t, 10, NewDownstreamSummaryProcessor(0).TopN)
+	assert.Equal(t, 3, NewDownstreamSummaryProcessor(3).TopN)
+}
+
+func TestDownstreamSummaryWithStreamedSubsegments(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	cfg := *GetRecorder(ctx)
+	cfg.PreEmitProcessors = []PreEmitProcessor{NewDownstreamSummaryProcessor(2)}
+	ctx = context.WithValue(ctx, RecorderContextKey{}, &cfg)
+
+	ctx, root := BeginSegment(ctx, "test")
+
+	remote := func(host string, d time.Duration, err error, stream bool) {
+		_, seg := BeginSubsegment(ctx, host)
+		seg.Lock()
+		seg.Namespace = "remote"
+		seg.StartTime = float64(time.Now().Add(-d).UnixNano()) / float64(time.Second)
+		seg.Unlock()
+		if stream {
+			seg.CloseAndStream(err)
+		} else {
+			seg.Close(err)
+		}
+	}
+	remote("a.example.com", 100*time.Millisecond, nil, false)
+	remote("a.example.com", 300*time.Millisecond, errors.New("boom"), true)
+	remote("b.example.com", 200*time.Millisecond, nil, true)
+	remote("c.example.com", 10*time.Millisecond, nil, false)
+
+	// local subsegments are not part of the summary
+	_, local := BeginSubsegment(ctx, "local")
+	local.Close(nil)
+
+	root.Close(nil)
+
+	var seg *Segment
+	for seg == nil || seg.Type == "subsegment" {
+		var err error
+		seg, err = td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+
+	summary := seg.Metadata["default"]["downstream_summary"].(map[string]interface{})
+	assert.Len(t, summary, 2)
+	assert.NotContains(t, summary, "c.example.com")
+
+	a := summary["a.example.com"].(map[string]interface{})
+	assert.Equal(t, float64(2), a["count"])
+	assert.Equal(t, float64(1), a["errors"])
+	assert.InDelta(t, 400, a["total_ms"], 50)
+	assert.InDelta(t, 300, a["max_ms"], 50)
+
+	b := summary["b.example.com"].(map[string]interface{})
+	assert.Equal(t, float64(1), b["count"])
+	assert.Equal(t, float64(0), b["errors"])
+	assert.InDelta(t, 200, b["total_ms"], 50)
+	assert.InDelta(t, 200, b["max_ms"], 50)
+}
+
+func TestDownstreamSummaryKeyedByHost(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	cfg := *GetRecorder(ctx)
+	cfg.PreEmitProcessors = []PreEmitProcessor{NewDownstreamSummaryProcessor(0)}
+	ctx = context.WithValue(ctx, RecorderContextKey{}, &cfg)
+
+	ctx, root := BeginSegment(ctx, "test")
+	remote := func(name string, record func(seg *Segment)) {
+		_, seg := BeginSubsegment(ctx, name)
+		seg.Lock()
+		seg.Namespace = "remote"
+		record(seg)
+		seg.Unlock()
+		seg.Close(nil)
+	}
+	remote("GetOrder", func(seg *Segment) { seg.GetHTTP().GetRequest().URL = "https://api.example.com/orders/1" })
+	remote("ListOrders", func(seg *Segment) { seg.GetHTTP().GetRequest().URL = "https://api.example.com/orders" })
+	remote("grpc.health.v1.Health/Check", func(seg *Segment) {
+		seg.GetHTTP().GetRequest().URL = "grpc://health.example.com:443/grpc.health.v1.Health/Check"
+	})
+	remote("orders@db", func(seg *Segment) { seg.GetSQL().URL = "db.example.com:5432/orders" })
+	remote("users@db", func(seg *Segment) { seg.GetSQL().URL = "postgres://db.example.com:5432/users" })
+	remote("cache", func(seg *Segment) {})
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	summary := emitted.Metadata["default"]["downstream_summary"].(map[string]interface{})
+	counts := map[string]interface{}{}
+	for host, stats := range summary {
+		counts[host] = stats.(map[string]interface{})["count"]
+	}
+	assert.Equal(t, map[string]interface{}{
+		"api.example.com":        float64(2),
+		"health.example.com:443": float64(1),
+		"db.example.com:5432":    float64(2),
+		"cache":                  float64(1),
+	}, counts)
+}
+
+func TestDownstreamSummaryNotConfigured(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	_, seg := BeginSubsegment(ctx, "a.example.com")
+	seg.Namespace = "remote"
+	seg.Close(nil)
+	root.Close(nil)
+
+	emitted, err := td.Recv()
+	assert.NoError(t, err)
+	assert.NotContains(t, emitted.Metadata["default"], "downstream_summary")
+}
@@ -0,0 +1,70 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Streamed reports whether subsegments of the segment tree seg belongs to
+// have been emitted ahead of the root segment, with CloseAndStream.
+func (seg *Segment) Streamed() bool {
+	return atomic.LoadUint32(&seg.ParentSegment.streamedSubSegments) > 0
+}
+
+// applyEmitFilter reports whether seg should be emitted according to the
+// configured EmitFilter, counting the decision in the SDK health metrics.
+// Segments with streamed subsegments are always kept,
+// since what was already shipped can't be taken back, and so are detached
+// subsegments, since the filter decides on traces by their root segments.
+// The caller of applyEmitFilter should have write lock on seg instance.
+func (seg *Segment) applyEmitFilter() bool {
+	if seg.Configuration == nil || seg.Configuration.EmitFilter == nil || !seg.Sampled || seg.Type == "subsegment" {
+		return true
+	}
+	if seg.Streamed() {
+		emitFilterSkipped.add(seg.metricsObserver(), 1)
+		return true
+	}
+	if !seg.Configuration.EmitFilter(seg) {
+		emitFilterDropped.add(seg.metricsObserver(), 1)
+		seg.log().Debugf("EmitFilter dropped segment named %s", seg.Name)
+		return false
+	}
+	emitFilterKept.add(seg.metricsObserver(), 1)
+	return true
+}
+
+// KeepIfSlowOrErrored returns an EmitFilter keeping the segments that took at
+// least minDuration, or that have a subsegment marked with an error, fault or
+// throttle.
+func KeepIfSlowOrErrored(minDuration time.Duration) func(*Segment) bool {
+	return func(seg *Segment) bool {
+		d := time.Duration((seg.EndTime - seg.StartTime) * float64(time.Second))
+		return d >= minDuration || hasErrors(seg)
+	}
+}
+
+// hasErrors reports whether seg or one of its subsegments has an error,
+// fault or throttle. The caller of hasErrors should have lock on seg instance.
+func hasErrors(seg *Segment) bool {
+	if seg.Error || seg.Fault || seg.Throttle {
+		return true
+	}
+	for _, s := range seg.rawSubsegments {
+		s.RLock()
+		errored := hasErrors(s)
+		s.RUnlock()
+		if errored {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func contextWithEmitFilter(ctx context.Context, filter func(*Segment) bool, observer MetricsObserver) context.Context {
+	cfg := *GetRecorder(ctx)
+	cfg.EmitFilter = filter
+	cfg.MetricsObserver = observer
+	return context.WithValue(ctx, RecorderContextKey{}, &cfg)
+}
+
+func TestEmitFilter(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	observer := &testMetricsObserver{}
+	ctx = contextWithEmitFilter(ctx, KeepIfSlowOrErrored(time.Hour), observer)
+
+	before := Stats()
+
+	// fast and without errors: dropped
+	_, fast := BeginSegment(ctx, "fast")
+	fast.Close(nil)
+
+	// fast but with an errored subsegment: kept
+	ctxErrored, errored := BeginSegment(ctx, "errored")
+	_, sub := BeginSubsegment(ctxErrored, "sub")
+	sub.Close(errors.New("boom"))
+	errored.Close(nil)
+
+	// slow: kept
+	_, slow := BeginSegment(ctx, "slow")
+	slow.Lock()
+	slow.StartTime -= 2 * time.Hour.Seconds()
+	slow.Unlock()
+	slow.Close(nil)
+
+	for _, name := range []string{"errored", "slow"} {
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, name, seg.Name)
+	}
+	_, err := td.Recv()
+	assert.Error(t, err, "no other segment should be emitted")
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	assert.Equal(t, int64(2), observer.metrics[MetricEmitFilterKept])
+	assert.Equal(t, int64(1), observer.metrics[MetricEmitFilterDropped])
+	assert.Zero(t, observer.metrics[MetricEmitFilterSkipped])
+
+	after := Stats()
+	assert.GreaterOrEqual(t, after.EmitFilterKept-before.EmitFilterKept, int64(2))
+	assert.GreaterOrEqual(t, after.EmitFilterDropped-before.EmitFilterDropped, int64(1))
+}
+
+func TestEmitFilterStreamed(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	consulted := false
+	observer := &testMetricsObserver{}
+	ctx = contextWithEmitFilter(ctx, func(*Segment) bool {
+		consulted = true
+		return false
+	}, observer)
+
+	before := Stats()
+
+	ctx, root := BeginSegment(ctx, "test")
+	_, streamed := BeginSubsegment(ctx, "streamed")
+	assert.False(t, root.Streamed())
+	streamed.CloseAndStream(nil)
+	assert.True(t, root.Streamed())
+	root.Close(nil)
+
+	sub, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "streamed", sub.Name)
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "test", seg.Name)
+	assert.False(t, consulted)
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	assert.Zero(t, observer.metrics[MetricEmitFilterKept])
+	assert.Zero(t, observer.metrics[MetricEmitFilterDropped])
+	assert.Equal(t, int64(1), observer.metrics[MetricEmitFilterSkipped])
+
+	after := Stats()
+	assert.GreaterOrEqual(t, after.EmitFilterSkipped-before.EmitFilterSkipped, int64(1))
+}
+
+func TestKeepIfSlowOrErrored(t *testing.T) {
+	filter := KeepIfSlowOrErrored(time.Second)
+
+	assert.False(t, filter(&Segment{StartTime: 10, EndTime: 10.5}))
+	assert.True(t, filter(&Segment{StartTime: 10, EndTime: 11}))
+	assert.True(t, filter(&Segment{StartTime: 10, EndTime: 10.5, Throttle: true}))
+	assert.True(t, filter(&Segment{StartTime: 10, EndTime: 10.5, rawSubsegments: []*Segment{
+		{rawSubsegments: []*Segment{{Fault: true}}},
+	}}))
+}
@@ -15,3 +15,9 @@ type Emitter interface {
 	Emit(seg *Segment)
 	RefreshEmitterWithAddress(raddr *net.UDPAddr)
 }
+
+// UnixEmitter is implemented by emitters able to send segments to the Unix
+// domain socket of the daemon, see daemoncfg.DaemonEndpoints.
+type UnixEmitter interface {
+	RefreshEmitterWithUnixAddress(raddr *net.UnixAddr)
+}
@@ -0,0 +1,99 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+)
+
+// execNamespace is the metadata namespace of the commands recorded with
+// CaptureCmd.
+const execNamespace = "exec"
+
+// CmdOptions configures CaptureCmdWithOptions.
+type CmdOptions struct {
+	// RecordArgs records the arguments of the command in the args metadata
+	// of the exec namespace. Only their number is recorded by default, as
+	// arguments may hold secrets.
+	RecordArgs bool
+}
+
+// CaptureCmd runs cmd within a subsegment named name, which records the
+// binary name, the number of arguments and the exit code of the command in
+// the exec metadata namespace. The subsegment is marked as an error if the
+// command exits with a non-zero status, and as a fault if it fails to start
+// or is killed because ctx is done.
+//
+// The process is killed once ctx is done, as with exec.CommandContext.
+// Without a segment in ctx, the command is run untraced after the context
+// missing strategy is invoked.
+func CaptureCmd(ctx context.Context, name string, cmd *exec.Cmd) error {
+	return CaptureCmdWithOptions(ctx, name, cmd, CmdOptions{})
+}
+
+// CaptureCmdWithOptions runs cmd within a subsegment like CaptureCmd,
+// applying the given CmdOptions.
+func CaptureCmdWithOptions(ctx context.Context, name string, cmd *exec.Cmd, opts CmdOptions) error {
+	_, seg := BeginSubsegment(ctx, name)
+	if seg == nil {
+		return cmd.Run()
+	}
+
+	seg.AddMetadataToNamespace(execNamespace, "binary", filepath.Base(cmd.Path))
+	var args []string
+	if len(cmd.Args) > 1 {
+		args = cmd.Args[1:]
+	}
+	seg.AddMetadataToNamespace(execNamespace, "arg_count", len(args))
+	if opts.RecordArgs {
+		seg.AddMetadataToNamespace(execNamespace, "args", args)
+	}
+
+	if err := cmd.Start(); err != nil {
+		seg.Close(err)
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+
+	if cmd.ProcessState != nil {
+		seg.AddMetadataToNamespace(execNamespace, "exit_code", cmd.ProcessState.ExitCode())
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil && !seg.isDisabled() {
+		seg.Lock()
+		seg.Error = true
+		seg.addCause(err)
+		seg.Unlock()
+		seg.Close(nil)
+		return err
+	}
+	seg.Close(err)
+	return err
+}
+
+// RunCommand runs the named program with the given arguments like
+// exec.CommandContext, within a subsegment named after the program, and
+// returns the error of the command. See CaptureCmd.
+func RunCommand(ctx context.Context, name string, arg ...string) error {
+	return CaptureCmd(ctx, filepath.Base(name), exec.CommandContext(ctx, name, arg...))
+}
@@ -0,0 +1,138 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lookPath returns the path of the program file, skipping the test on
+// platforms without it.
+func lookPath(t *testing.T, file string) string {
+	path, err := exec.LookPath(file)
+	if err != nil {
+		t.Skipf("%s is not available: %v", file, err)
+	}
+	return path
+}
+
+// recvCmdSubsegment closes root and returns its only subsegment.
+func recvCmdSubsegment(t *testing.T, td *TestDaemon, root *Segment) *Segment {
+	root.Close(nil)
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) || !assert.Len(t, seg.Subsegments, 1) {
+		t.FailNow()
+	}
+	var sub Segment
+	assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &sub))
+	return &sub
+}
+
+func TestCaptureCmd(t *testing.T) {
+	path := lookPath(t, "true")
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "test")
+
+	err := CaptureCmd(ctx, "true", exec.Command(path, "--secret", "hunter2"))
+	assert.NoError(t, err)
+
+	sub := recvCmdSubsegment(t, td, root)
+	assert.Equal(t, "true", sub.Name)
+	assert.False(t, sub.Error)
+	assert.False(t, sub.Fault)
+	assert.Equal(t, "true", sub.Metadata["exec"]["binary"])
+	assert.Equal(t, float64(2), sub.Metadata["exec"]["arg_count"])
+	assert.Equal(t, float64(0), sub.Metadata["exec"]["exit_code"])
+	assert.NotContains(t, sub.Metadata["exec"], "args")
+}
+
+func TestCaptureCmdRecordArgs(t *testing.T) {
+	path := lookPath(t, "true")
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "test")
+
+	err := CaptureCmdWithOptions(ctx, "true", exec.Command(path, "-v", "file"), CmdOptions{RecordArgs: true})
+	assert.NoError(t, err)
+
+	sub := recvCmdSubsegment(t, td, root)
+	assert.Equal(t, []interface{}{"-v", "file"}, sub.Metadata["exec"]["args"])
+}
+
+func TestCaptureCmdNonZeroExit(t *testing.T) {
+	path := lookPath(t, "false")
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "test")
+
+	err := RunCommand(ctx, path)
+	var exitErr *exec.ExitError
+	assert.True(t, errors.As(err, &exitErr))
+
+	sub := recvCmdSubsegment(t, td, root)
+	assert.Equal(t, "false", sub.Name)
+	assert.True(t, sub.Error)
+	assert.False(t, sub.Fault)
+	assert.Equal(t, float64(1), sub.Metadata["exec"]["exit_code"])
+	if assert.NotNil(t, sub.Cause) {
+		assert.Len(t, sub.Cause.Exceptions, 1)
+	}
+}
+
+func TestCaptureCmdStartFailure(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "test")
+
+	err := CaptureCmd(ctx, "missing", exec.Command("/nonexistent/xray-missing-binary"))
+	assert.Error(t, err)
+
+	sub := recvCmdSubsegment(t, td, root)
+	assert.True(t, sub.Fault)
+	assert.False(t, sub.Error)
+	assert.NotContains(t, sub.Metadata["exec"], "exit_code")
+}
+
+func TestCaptureCmdContextCancelled(t *testing.T) {
+	path := lookPath(t, "sleep")
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "test")
+
+	cmdCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := CaptureCmd(cmdCtx, "sleep", exec.Command(path, "10"))
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	sub := recvCmdSubsegment(t, td, root)
+	assert.False(t, sub.InProgress)
+	assert.True(t, sub.Fault)
+}
+
+func TestCaptureCmdWithoutSegment(t *testing.T) {
+	path := lookPath(t, "true")
+	strategy := &countingContextMissingStrategy{}
+	ctx, err := ContextWithConfig(context.Background(), Config{ContextMissingStrategy: strategy})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, CaptureCmd(ctx, "true", exec.Command(path)))
+	assert.Equal(t, 1, strategy.count)
+}
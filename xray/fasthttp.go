@@ -7,6 +7,7 @@ import (
 	"net/url"
 
 	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
 	"github.com/valyala/fasthttp"
 )
 
@@ -36,7 +37,6 @@ func (h *fasthttpHandler) Handler(sn SegmentNamer, handler fasthttp.RequestHandl
 		}
 
 		name := sn.Name(string(ctx.Request.Host()))
-		traceHeader := header.FromString(string(ctx.Request.Header.Peek(TraceIDHeaderKey)))
 
 		req, err := fasthttpToNetHTTPRequest(ctx)
 		if err != nil {
@@ -44,6 +44,7 @@ func (h *fasthttpHandler) Handler(sn SegmentNamer, handler fasthttp.RequestHandl
 			ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
 			return
 		}
+		traceHeader := extractTraceHeader(h.cfg, req.Header)
 
 		_, seg := NewSegmentFromHeader(auxCtx, name, req, traceHeader)
 		defer seg.Close(nil)
@@ -96,3 +97,81 @@ func fasthttpTrace(seg *Segment, h fasthttp.RequestHandler, ctx *fasthttp.Reques
 	seg.Unlock()
 	HttpCaptureResponse(seg, ctx.Response.StatusCode())
 }
+
+// FastHTTPClient sends fasthttp requests. It is implemented by
+// fasthttp.Client, fasthttp.HostClient and fasthttp.PipelineClient.
+type FastHTTPClient interface {
+	Do(req *fasthttp.Request, resp *fasthttp.Response) error
+}
+
+// FastHTTPDo sends req with c and fills resp like c.Do, recording the call in
+// a remote subsegment of the segment in ctx named after the request host,
+// and adding the trace header to req. Like c.Do, it doesn't retain req or
+// resp once it returns, so both may be released to their pools.
+func FastHTTPDo(ctx context.Context, c FastHTTPClient, req *fasthttp.Request, resp *fasthttp.Response) error {
+	var isEmptyHost bool
+	host := string(req.Host())
+	if host == "" {
+		host = emptyHostRename
+		isEmptyHost = true
+	}
+
+	return Capture(ctx, host, func(ctx context.Context) error {
+		seg := GetSegment(ctx)
+		if seg == nil {
+			logger.Warnf("failed to record HTTP transaction: segment cannot be found.")
+			return c.Do(req, resp)
+		}
+
+		seg.Lock()
+		if isEmptyHost {
+			seg.Namespace = ""
+		} else {
+			seg.Namespace = "remote"
+		}
+		seg.GetHTTP().GetRequest().Method = string(req.Header.Method())
+		seg.GetHTTP().GetRequest().URL = fasthttpURL(req.URI())
+		seg.addDeadlineAnnotation(ctx, "remaining_budget_ms")
+		h := http.Header{}
+		injectTraceHeader(seg, h)
+		for k := range h {
+			req.Header.Set(k, h.Get(k))
+		}
+		seg.Unlock()
+
+		if err := c.Do(req, resp); err != nil {
+			return err
+		}
+
+		status := resp.StatusCode()
+		seg.Lock()
+		seg.GetHTTP().GetResponse().Status = status
+		if contentLength := resp.Header.ContentLength(); contentLength >= 0 {
+			seg.GetHTTP().GetResponse().ContentLength = contentLength
+		} else {
+			seg.GetHTTP().GetResponse().ContentLength = len(resp.Body())
+		}
+		if status >= 400 && status < 500 {
+			seg.Error = true
+		}
+		if status == 429 {
+			seg.Throttle = true
+		}
+		if status >= 500 && status < 600 {
+			seg.Fault = true
+		}
+		seg.Unlock()
+		return nil
+	})
+}
+
+// fasthttpURL returns the URL of a fasthttp request without its user info
+// and query string.
+func fasthttpURL(uri *fasthttp.URI) string {
+	u := url.URL{
+		Scheme: string(uri.Scheme()),
+		Host:   string(uri.Host()),
+		Path:   string(uri.Path()),
+	}
+	return u.String()
+}
@@ -0,0 +1,62 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// inProgressSegments is the number of segments begun and not closed yet.
+var inProgressSegments int64
+
+// Flusher is implemented by emitters and sampling strategies with operations
+// in flight, awaited by Flush.
+type Flusher interface {
+	// Flush blocks until the operations in flight are done, or until ctx
+	// is done.
+	Flush(ctx context.Context) error
+}
+
+// InProgressError is returned by Flush when segments are still in progress.
+type InProgressError struct {
+	// Count is the number of segments in progress.
+	Count int
+}
+
+func (e *InProgressError) Error() string {
+	return fmt.Sprintf("xray: %d segments still in progress were not flushed", e.Count)
+}
+
+// Flush blocks until the segments emitted so far have been written to the
+// daemon, and the asynchronous operations of the SDK, such as the out-of-band
+// refreshes of the sampling rules, are done, or until ctx is done. It should
+// be called before the process exits or the Lambda function is frozen.
+// The emitter and sampling strategy of the global configuration are flushed
+// when they implement Flusher.
+// Segments still in progress are neither closed nor sent, and are reported by
+// an InProgressError.
+func Flush(ctx context.Context) error {
+	for _, v := range []interface{}{globalCfg.Emitter(), globalCfg.SamplingStrategy()} {
+		if f, ok := v.(Flusher); ok {
+			if err := f.Flush(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if n := atomic.LoadInt64(&inProgressSegments); n > 0 {
+		return &InProgressError{Count: int(n)}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlushInProgressSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginSegment(ctx, "test")
+
+	err := Flush(context.Background())
+	var inProgress *InProgressError
+	if assert.True(t, errors.As(err, &inProgress)) {
+		assert.GreaterOrEqual(t, inProgress.Count, 1)
+	}
+	// the segment is neither closed nor sent by Flush
+	assert.True(t, seg.safeInProgress())
+
+	n := atomic.LoadInt64(&inProgressSegments)
+	seg.Close(nil)
+	assert.Equal(t, n-1, atomic.LoadInt64(&inProgressSegments))
+	seg.Close(nil)
+	assert.Equal(t, n-1, atomic.LoadInt64(&inProgressSegments), "segment closed twice should be counted once")
+
+	emitted, err := td.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "test", emitted.Name)
+	}
+}
+
+type blockingFlushEmitter struct {
+	release chan struct{}
+	flushed int32
+}
+
+func (e *blockingFlushEmitter) Emit(seg *Segment) {}
+
+func (e *blockingFlushEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {}
+
+func (e *blockingFlushEmitter) Flush(ctx context.Context) error {
+	select {
+	case <-e.release:
+		atomic.StoreInt32(&e.flushed, 1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestFlushEmitter(t *testing.T) {
+	emitter := &blockingFlushEmitter{release: make(chan struct{})}
+	assert.NoError(t, Configure(Config{Emitter: emitter}))
+	defer ResetConfig()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, Flush(ctx))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&emitter.flushed))
+
+	close(emitter.release)
+	err := Flush(context.Background())
+	var inProgress *InProgressError
+	if err != nil {
+		// segments left open by other tests are reported
+		assert.True(t, errors.As(err, &inProgress))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&emitter.flushed))
+}
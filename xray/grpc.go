@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 
@@ -44,12 +46,13 @@ func UnaryClientInterceptor(clientInterceptorOptions ...GrpcOption) grpc.UnaryCl
 				return errors.New("failed to record gRPC transaction: segment cannot be found")
 			}
 
-			ctx = metadata.AppendToOutgoingContext(ctx, TraceIDHeaderKey, seg.DownstreamHeader().String())
+			ctx = appendTraceHeaderToOutgoingContext(ctx, seg)
 
 			seg.Lock()
 			seg.Namespace = "remote"
 			seg.GetHTTP().GetRequest().URL = "grpc://" + cc.Target() + method
 			seg.GetHTTP().GetRequest().Method = http.MethodPost
+			seg.addDeadlineAnnotation(ctx, "remaining_budget_ms")
 			seg.Unlock()
 
 			err := invoker(ctx, method, req, reply, cc, opts...)
@@ -72,75 +75,257 @@ func UnaryServerInterceptor(serverInterceptorOptions ...GrpcOption) grpc.UnarySe
 	}
 
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-		md, ok := metadata.FromIncomingContext(ctx)
+		ctx, seg, traceHeader := newGrpcServerSegment(ctx, option, info.FullMethod)
+		defer seg.Close(nil)
 
-		var traceID string
-		if ok && len(md.Get(TraceIDHeaderKey)) == 1 {
-			traceID = md.Get(TraceIDHeaderKey)[0]
+		resp, err = handler(ctx, req)
+		if err != nil {
+			classifyErrorStatus(seg, err)
+		}
+		recordContentLength(seg, resp)
+		if headerErr := addResponseTraceHeader(ctx, seg, traceHeader); headerErr != nil {
+			logger.Debug("fail to set the grpc trace header")
 		}
-		traceHeader := header.FromString(traceID)
 
-		var host string
+		return resp, err
+	}
+}
 
-		if len(md.Get(":authority")) == 1 {
-			host = md.Get(":authority")[0]
-		}
-		requestURL := url.URL{
-			Scheme: "grpc",
-			Host:   host,
-			Path:   info.FullMethod,
-		}
+// StreamClientInterceptor provides gRPC stream client interceptor. The
+// subsegment spans the lifetime of the stream: it is closed once the stream
+// is finished or fails, or when its context is done.
+func StreamClientInterceptor(clientInterceptorOptions ...GrpcOption) grpc.StreamClientInterceptor {
+	var option grpcOption
+	for _, interceptorOption := range clientInterceptorOptions {
+		interceptorOption.apply(&option)
+	}
 
-		var name string
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		var segmentName string
 		if option.segmentNamer == nil {
-			name = inferServiceName(info.FullMethod)
+			segmentName = inferServiceName(method)
 		} else {
-			name = option.segmentNamer.Name(host)
+			segmentName = option.segmentNamer.Name(cc.Target())
 		}
-
 		if option.config != nil {
 			ctx = context.WithValue(ctx, RecorderContextKey{}, option.config)
 		}
 
-		var seg *Segment
-		ctx, seg = NewSegmentFromHeader(ctx, name, &http.Request{
-			Host:   host,
-			URL:    &requestURL,
-			Method: http.MethodPost,
-		}, traceHeader)
-		defer seg.Close(nil)
+		ctx, seg := BeginSubsegment(ctx, segmentName)
+		if seg == nil {
+			return nil, errors.New("failed to record gRPC stream: segment cannot be found")
+		}
+
+		ctx = appendTraceHeaderToOutgoingContext(ctx, seg)
 
 		seg.Lock()
-		seg.GetHTTP().GetRequest().ClientIP, seg.GetHTTP().GetRequest().XForwardedFor = clientIPFromGrpcMetadata(md)
-		seg.GetHTTP().GetRequest().URL = requestURL.String()
+		seg.Namespace = "remote"
+		seg.GetHTTP().GetRequest().URL = "grpc://" + cc.Target() + method
 		seg.GetHTTP().GetRequest().Method = http.MethodPost
-		if len(md.Get("user-agent")) == 1 {
-			seg.GetHTTP().GetRequest().UserAgent = md.Get("user-agent")[0]
-		}
+		seg.addDeadlineAnnotation(ctx, "remaining_budget_ms")
 		seg.Unlock()
 
-		resp, err = handler(ctx, req)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
 		if err != nil {
 			classifyErrorStatus(seg, err)
+			seg.Close(err)
+			return nil, err
 		}
-		recordContentLength(seg, resp)
-		if headerErr := addResponseTraceHeader(ctx, seg, traceHeader); headerErr != nil {
+
+		return newClientStream(ctx, stream, desc, seg), nil
+	}
+}
+
+// StreamServerInterceptor provides gRPC stream server interceptor. The segment
+// is begun when the stream is opened, and is classified by the final stream
+// error and closed once the handler returns.
+func StreamServerInterceptor(serverInterceptorOptions ...GrpcOption) grpc.StreamServerInterceptor {
+	var option grpcOption
+	for _, options := range serverInterceptorOptions {
+		options.apply(&option)
+	}
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, seg, traceHeader := newGrpcServerSegment(ss.Context(), option, info.FullMethod)
+		defer seg.Close(nil)
+
+		// Headers are sent with the first message of the stream, so the trace
+		// header must be set before the handler runs.
+		if headerErr := ss.SetHeader(responseTraceHeader(seg, traceHeader)); headerErr != nil {
 			logger.Debug("fail to set the grpc trace header")
 		}
 
-		return resp, err
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		if err != nil {
+			classifyErrorStatus(seg, err)
+		}
+
+		return err
 	}
 }
 
-func classifyErrorStatus(seg *Segment, err error) {
+// newGrpcServerSegment begins the segment of a server call to fullMethod from
+// the incoming metadata of ctx.
+func newGrpcServerSegment(ctx context.Context, option grpcOption, fullMethod string) (context.Context, *Segment, *header.Header) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var host string
+
+	if len(md.Get(":authority")) == 1 {
+		host = md.Get(":authority")[0]
+	}
+	requestURL := url.URL{
+		Scheme: "grpc",
+		Host:   host,
+		Path:   fullMethod,
+	}
+
+	var name string
+	if option.segmentNamer == nil {
+		name = inferServiceName(fullMethod)
+	} else {
+		name = segmentName(option.segmentNamer, &http.Request{Method: http.MethodPost, URL: &requestURL, Host: host, Header: http.Header{}})
+	}
+
+	if option.config != nil {
+		ctx = context.WithValue(ctx, RecorderContextKey{}, option.config)
+	}
+	traceHeader := extractTraceHeader(GetRecorder(ctx), metadataHeader(md), option.traceHeaderKeys...)
+
+	if option.samplingAttributes != nil {
+		ctx = ContextWithSamplingAttributes(ctx, option.samplingAttributes(ctx, fullMethod))
+	}
+
+	var seg *Segment
+	ctx, seg = NewSegmentFromHeader(ctx, name, &http.Request{
+		Host:   host,
+		URL:    &requestURL,
+		Method: http.MethodPost,
+	}, traceHeader)
+
 	seg.Lock()
-	defer seg.Unlock()
+	seg.GetHTTP().GetRequest().ClientIP, seg.GetHTTP().GetRequest().XForwardedFor = clientIPFromGrpcMetadata(md)
+	seg.GetHTTP().GetRequest().URL = requestURL.String()
+	seg.GetHTTP().GetRequest().Method = http.MethodPost
+	if len(md.Get("user-agent")) == 1 {
+		seg.GetHTTP().GetRequest().UserAgent = md.Get("user-agent")[0]
+	}
+	seg.Unlock()
+
+	return ctx, seg, traceHeader
+}
+
+// appendTraceHeaderToOutgoingContext adds the downstream header of seg to the
+// outgoing metadata of ctx, with each of the propagators of its segment.
+func appendTraceHeaderToOutgoingContext(ctx context.Context, seg *Segment) context.Context {
+	h := http.Header{}
+	injectTraceHeader(seg, h)
+
+	kv := make([]string, 0, 2*len(h))
+	for k, values := range h {
+		for _, v := range values {
+			kv = append(kv, strings.ToLower(k), v)
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// metadataHeader returns the entries of md with a single value as headers.
+func metadataHeader(md metadata.MD) http.Header {
+	h := http.Header{}
+	for k, values := range md {
+		if len(values) == 1 {
+			h.Set(k, values[0])
+		}
+	}
+	return h
+}
+
+// clientStream closes the subsegment of a client stream once the stream is
+// finished.
+type clientStream struct {
+	grpc.ClientStream
+	desc *grpc.StreamDesc
+	seg  *Segment
+
+	once sync.Once
+	done chan struct{}
+}
+
+func newClientStream(ctx context.Context, stream grpc.ClientStream, desc *grpc.StreamDesc, seg *Segment) *clientStream {
+	s := &clientStream{
+		ClientStream: stream,
+		desc:         desc,
+		seg:          seg,
+		done:         make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.finish(status.FromContextError(ctx.Err()).Err())
+		case <-s.done:
+		}
+	}()
+	return s
+}
+
+func (s *clientStream) Header() (metadata.MD, error) {
+	md, err := s.ClientStream.Header()
+	if err != nil {
+		s.finish(err)
+	}
+	return md, err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	case !s.desc.ServerStreams:
+		// the server sends a single message
+		s.finish(nil)
+	}
+	return err
+}
+
+func (s *clientStream) finish(err error) {
+	s.once.Do(func() {
+		close(s.done)
+		if err != nil {
+			classifyErrorStatus(s.seg, err)
+		}
+		s.seg.Close(err)
+	})
+}
+
+// serverStream carries the context of the segment to the stream handler.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func classifyErrorStatus(seg *Segment, err error) {
 	grpcStatus, ok := status.FromError(err)
 	if !ok {
+		seg.Lock()
 		seg.Fault = true
+		seg.Unlock()
 		return
 	}
-	switch grpcStatus.Code() {
+	classifyStatusCode(seg, grpcStatus.Code())
+}
+
+func classifyStatusCode(seg *Segment, code codes.Code) {
+	seg.Lock()
+	defer seg.Unlock()
+	switch code {
 	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied, codes.Unauthenticated, codes.FailedPrecondition, codes.Aborted, codes.OutOfRange:
 		seg.Error = true
 	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal, codes.Unavailable, codes.DataLoss:
@@ -156,7 +341,7 @@ func clientIPFromGrpcMetadata(md metadata.MD) (string, bool) {
 	}
 	forwardedFor := md.Get("x-forwarded-for")[0]
 	if forwardedFor != "" {
-		return strings.TrimSpace(strings.Split(forwardedFor, ",")[0]), true
+		return normalizeIP(strings.TrimSpace(strings.Split(forwardedFor, ",")[0])), true
 	}
 	return "", false
 }
@@ -170,6 +355,10 @@ func recordContentLength(seg *Segment, reply interface{}) {
 }
 
 func addResponseTraceHeader(ctx context.Context, seg *Segment, incomingTraceHeader *header.Header) error {
+	return grpc.SetHeader(ctx, responseTraceHeader(seg, incomingTraceHeader))
+}
+
+func responseTraceHeader(seg *Segment, incomingTraceHeader *header.Header) metadata.MD {
 	var respHeader bytes.Buffer
 	respHeader.WriteString("Root=")
 	respHeader.WriteString(seg.TraceID)
@@ -178,10 +367,9 @@ func addResponseTraceHeader(ctx context.Context, seg *Segment, incomingTraceHead
 		respHeader.WriteString(strconv.Itoa(btoi(seg.Sampled)))
 	}
 
-	headers := metadata.New(map[string]string{
+	return metadata.New(map[string]string{
 		TraceIDHeaderKey: respHeader.String(),
 	})
-	return grpc.SetHeader(ctx, headers)
 }
 
 func inferServiceName(fullMethodName string) string {
@@ -194,8 +382,10 @@ type GrpcOption interface {
 }
 
 type grpcOption struct {
-	config       *Config
-	segmentNamer SegmentNamer
+	config             *Config
+	segmentNamer       SegmentNamer
+	samplingAttributes func(ctx context.Context, fullMethod string) map[string]string
+	traceHeaderKeys    []string
 }
 
 func newFuncGrpcOption(f func(option *grpcOption)) GrpcOption {
@@ -223,3 +413,22 @@ func WithSegmentNamer(sn SegmentNamer) GrpcOption {
 		option.segmentNamer = sn
 	})
 }
+
+// WithSamplingAttributes makes the server interceptors match the attributes
+// returned by f against the Attributes of centralized sampling rules. The
+// context passed to f carries the incoming metadata of the call.
+func WithSamplingAttributes(f func(ctx context.Context, fullMethod string) map[string]string) GrpcOption {
+	return newFuncGrpcOption(func(option *grpcOption) {
+		option.samplingAttributes = f
+	})
+}
+
+// WithTraceHeaderKeys makes the server interceptors read the trace header of
+// calls from the alternate metadata keys, tried in order when the call
+// carries no metadata read by the propagators. Responses still carry the
+// x-amzn-trace-id header.
+func WithTraceHeaderKeys(keys ...string) GrpcOption {
+	return newFuncGrpcOption(func(option *grpcOption) {
+		option.traceHeaderKeys = keys
+	})
+}
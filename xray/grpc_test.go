@@ -3,6 +3,7 @@ package xray
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net"
 	"regexp"
 	"sync"
@@ -48,6 +49,30 @@ func (s *testGRPCPingService) PingError(_ context.Context, req *pb.PingErrorRequ
 	return nil, status.Errorf(code, "Userspace error.")
 }
 
+func (s *testGRPCPingService) PingStream(stream pb.TestService_PingStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if req.ErrorCodeReturned != 0 {
+			return status.Errorf(codes.Code(req.ErrorCodeReturned), "Userspace error.")
+		}
+
+		s.mut.Lock()
+		s.counter++
+		counter := s.counter
+		s.mut.Unlock()
+
+		if err := stream.Send(&pb.PingStreamResponse{Value: req.Value, Counter: counter}); err != nil {
+			return err
+		}
+	}
+}
+
 func newGrpcServer(t *testing.T, opts ...grpc.ServerOption) *bufconn.Listener {
 	const bufSize = 1024 * 1024
 	lis := bufconn.Listen(bufSize)
@@ -387,6 +412,43 @@ func TestUnaryServerInterceptor(t *testing.T) {
 	})
 }
 
+func TestUnaryServerInterceptorTraceHeaderKeys(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	var th *header.Header
+	lis := newGrpcServer(
+		t,
+		grpc.ChainUnaryInterceptor(
+			UnaryServerInterceptor(
+				WithRecorder(GetRecorder(ctx)),
+				WithTraceHeaderKeys("x-client-trace-id")),
+			func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				th = TraceHeaderFromContext(ctx)
+				return handler(ctx, req)
+			},
+		),
+	)
+	client, closeFunc := newGrpcClient(context.Background(), t, lis)
+	defer closeFunc()
+
+	traceID := "1-5759e988-bd862e3fe1be46a994272793"
+	reqCtx := metadata.AppendToOutgoingContext(context.Background(),
+		"x-client-trace-id", "Root="+traceID+";Parent=53995c3f42cd8ad8;Sampled=1")
+	var respHeaders metadata.MD
+	_, err := client.Ping(reqCtx, &pb.PingRequest{Value: "something", SleepTimeMs: 9999}, grpc.Header(&respHeaders))
+	require.NoError(t, err)
+
+	seg, err := td.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, traceID, seg.TraceID)
+	assert.Equal(t, "53995c3f42cd8ad8", seg.ParentID)
+	require.NotNil(t, th)
+	assert.Equal(t, traceID, th.TraceID)
+	require.Len(t, respHeaders[TraceIDHeaderKey], 1)
+	assert.Equal(t, traceID, header.FromString(respHeaders[TraceIDHeaderKey][0]).TraceID)
+}
+
 func TestUnaryServerAndClientInterceptor(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -424,6 +486,211 @@ func TestUnaryServerAndClientInterceptor(t *testing.T) {
 	assert.Equal(t, "TestVersion", seg.Service.Version)
 }
 
+// pingStream sends a single ping over a bidirectional stream and reads the
+// responses until the stream is finished.
+func pingStream(ctx context.Context, client pb.TestServiceClient, code codes.Code, opts ...grpc.CallOption) error {
+	stream, err := client.PingStream(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&pb.PingStreamRequest{Value: "something", ErrorCodeReturned: uint32(code)}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func TestGrpcStreamClientInterceptor(t *testing.T) {
+	lis := newGrpcServer(
+		t,
+		grpc.StreamInterceptor(StreamServerInterceptor()),
+	)
+	client, closeFunc := newGrpcClient(context.Background(), t, lis, grpc.WithStreamInterceptor(StreamClientInterceptor()))
+	defer closeFunc()
+
+	testCases := []testCase{
+		{
+			name:                    "success response",
+			responseErrorStatusCode: codes.OK,
+		},
+		{
+			name:                    "error response",
+			responseErrorStatusCode: codes.Unauthenticated,
+			expectedError:           true,
+			expectedFault:           true,
+		},
+		{
+			name:                    "throttle response",
+			responseErrorStatusCode: codes.ResourceExhausted,
+			expectedThrottle:        true,
+			expectedFault:           true,
+		},
+		{
+			name:                    "fault response",
+			responseErrorStatusCode: codes.Internal,
+			expectedFault:           true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+
+			ctx2, root := BeginSegment(ctx, "Test")
+			err := pingStream(ctx2, client, tc.responseErrorStatusCode)
+			if tc.isTestForSuccessResponse() {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+			root.Close(nil)
+
+			seg, err := td.Recv()
+			require.NoError(t, err)
+
+			var subseg *Segment
+			assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg))
+			assert.Equal(t, "testing.testpb.v1.TestService", subseg.Name)
+			assert.Equal(t, "remote", subseg.Namespace)
+			assert.Equal(t, "grpc://bufnet/testing.testpb.v1.TestService/PingStream", subseg.HTTP.Request.URL)
+			assert.False(t, subseg.InProgress)
+			assert.Equal(t, tc.expectedThrottle, subseg.Throttle)
+			assert.Equal(t, tc.expectedError, subseg.Error)
+			assert.Equal(t, tc.expectedFault, subseg.Fault)
+		})
+	}
+
+	t.Run("canceled stream", func(t *testing.T) {
+		ctx, td := NewTestDaemon()
+		defer td.Close()
+
+		ctx, root := BeginSegment(ctx, "Test")
+		ctx2, cancel := context.WithCancel(ctx)
+		_, err := client.PingStream(ctx2)
+		require.NoError(t, err)
+		cancel()
+
+		// the subsegment is closed in the background once the context is done
+		require.Eventually(t, func() bool {
+			root.RLock()
+			defer root.RUnlock()
+			return root.openSegments == 0
+		}, time.Second, 10*time.Millisecond)
+		root.Close(nil)
+
+		seg, err := td.Recv()
+		require.NoError(t, err)
+
+		var subseg *Segment
+		assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg))
+		assert.True(t, subseg.Error)
+		assert.True(t, subseg.Fault)
+	})
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:                    "success response",
+			responseErrorStatusCode: codes.OK,
+		},
+		{
+			name:                    "error response",
+			responseErrorStatusCode: codes.Unauthenticated,
+			expectedError:           true,
+		},
+		{
+			name:                    "throttle response",
+			responseErrorStatusCode: codes.ResourceExhausted,
+			expectedThrottle:        true,
+		},
+		{
+			name:                    "fault response",
+			responseErrorStatusCode: codes.Internal,
+			expectedFault:           true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+
+			lis := newGrpcServer(
+				t,
+				grpc.StreamInterceptor(
+					StreamServerInterceptor(
+						WithRecorder(GetRecorder(ctx)),
+						WithSegmentNamer(NewFixedSegmentNamer("test")))),
+			)
+			client, closeFunc := newGrpcClient(context.Background(), t, lis)
+			defer closeFunc()
+
+			var respHeaders metadata.MD
+			err := pingStream(context.Background(), client, tc.responseErrorStatusCode, grpc.Header(&respHeaders))
+			if tc.isTestForSuccessResponse() {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+
+			seg, err := td.Recv()
+			require.NoError(t, err)
+
+			assert.Equal(t, "test", seg.Name)
+			assert.Equal(t, "grpc://bufnet/testing.testpb.v1.TestService/PingStream", seg.HTTP.Request.URL)
+			assert.Regexp(t, regexp.MustCompile(`^grpc-go/`), seg.HTTP.Request.UserAgent)
+			assert.Equal(t, tc.expectedThrottle, seg.Throttle)
+			assert.Equal(t, tc.expectedError, seg.Error)
+			assert.Equal(t, tc.expectedFault, seg.Fault)
+			respTraceHeaderSlice := respHeaders[TraceIDHeaderKey]
+			require.Len(t, respTraceHeaderSlice, 1)
+			assert.Equal(t, seg.TraceID, header.FromString(respTraceHeaderSlice[0]).TraceID)
+		})
+	}
+}
+
+func TestStreamServerAndClientInterceptor(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	lis := newGrpcServer(
+		t,
+		grpc.StreamInterceptor(
+			StreamServerInterceptor(
+				WithRecorder(GetRecorder(ctx)),
+				WithSegmentNamer(NewFixedSegmentNamer("test")))),
+	)
+	client, closeFunc := newGrpcClient(context.Background(), t, lis, grpc.WithStreamInterceptor(StreamClientInterceptor()))
+	defer closeFunc()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	require.NoError(t, pingStream(ctx, client, codes.OK))
+	root.Close(nil)
+
+	// the server segment is emitted first as it's closed before the client
+	// reads the end of the stream
+	serverSeg, err := td.Recv()
+	require.NoError(t, err)
+	clientSeg, err := td.Recv()
+	require.NoError(t, err)
+
+	var subseg *Segment
+	require.NoError(t, json.Unmarshal(clientSeg.Subsegments[0], &subseg))
+	assert.Equal(t, clientSeg.TraceID, serverSeg.TraceID)
+	assert.Equal(t, subseg.ID, serverSeg.ParentID)
+}
+
 func TestInferServiceName(t *testing.T) {
 	assert.Equal(t, "com.example.Service", inferServiceName("/com.example.Service/method"))
 }
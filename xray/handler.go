@@ -13,10 +13,14 @@ import (
 	"context"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
 	"github.com/aws/aws-xray-sdk-go/pattern"
 )
 
@@ -73,6 +77,37 @@ func (dSN *DynamicSegmentNamer) Name(host string) string {
 	return dSN.FallbackName
 }
 
+// SegmentNamerFunc is an adapter allowing a function computing segment names
+// from arbitrary properties of incoming requests to be used as a SegmentNamer.
+// As with other namers, the AWS_XRAY_TRACING_NAME environment variable
+// overrides the returned name, and names over 200 characters are truncated.
+type SegmentNamerFunc func(r *http.Request) string
+
+// Name returns the segment name for the given host header value, calling
+// the function with a request carrying only that host.
+func (f SegmentNamerFunc) Name(host string) string {
+	return f(&http.Request{Host: host, URL: &url.URL{Host: host}, Header: http.Header{}})
+}
+
+// NameRequest returns the segment name for the given request.
+func (f SegmentNamerFunc) NameRequest(r *http.Request) string {
+	return f(r)
+}
+
+// RequestSegmentNamer is implemented by segment namers naming segments
+// from the whole incoming request rather than from its host alone.
+type RequestSegmentNamer interface {
+	NameRequest(r *http.Request) string
+}
+
+// segmentName names the segment of r using sn.
+func segmentName(sn SegmentNamer, r *http.Request) string {
+	if rsn, ok := sn.(RequestSegmentNamer); ok {
+		return rsn.NameRequest(r)
+	}
+	return sn.Name(r.Host)
+}
+
 // HandlerWithContext wraps the provided http handler and context to parse
 // the incoming headers, add response headers if needed, and sets HTTP
 // specific trace fields. HandlerWithContext names the generated segments
@@ -80,9 +115,9 @@ func (dSN *DynamicSegmentNamer) Name(host string) string {
 func HandlerWithContext(ctx context.Context, sn SegmentNamer, h http.Handler) http.Handler {
 	cfg := GetRecorder(ctx)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		name := sn.Name(r.Host)
+		name := segmentName(sn, r)
 
-		traceHeader := header.FromString(r.Header.Get(TraceIDHeaderKey))
+		traceHeader := extractTraceHeader(cfg, r.Header)
 		ctx := context.WithValue(r.Context(), RecorderContextKey{}, cfg)
 		c, seg := NewSegmentFromHeader(ctx, name, r, traceHeader)
 		defer seg.Close(nil)
@@ -97,43 +132,275 @@ func HandlerWithContext(ctx context.Context, sn SegmentNamer, h http.Handler) ht
 // adding response headers if needed, and sets HTTP specific trace fields.
 // Handler names the generated segments using the provided SegmentNamer.
 func Handler(sn SegmentNamer, h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		name := sn.Name(r.Host)
+	return HandlerWithConfig(sn, h, HandlerConfig{})
+}
 
-		traceHeader := header.FromString(r.Header.Get(TraceIDHeaderKey))
-		ctx, seg := NewSegmentFromHeader(r.Context(), name, r, traceHeader)
+// HandlerConfig holds optional settings for HandlerWithConfig.
+type HandlerConfig struct {
+	// EchoTraceIDHeader is the name of a response header the trace ID
+	// is written to, in addition to the X-Amzn-Trace-Id header.
+	// By default the trace ID is only echoed for sampled requests.
+	EchoTraceIDHeader string
+
+	// EchoUnsampledTraceID also echoes the trace ID of unsampled requests.
+	EchoUnsampledTraceID bool
+
+	// DeferClientIP records the client IP once the wrapped handler returns
+	// instead of when the segment starts. Middleware wrapped by the handler,
+	// such as proxy protocol middleware, may then rewrite the RemoteAddr of
+	// the request or report the source address with SetRemoteAddr.
+	// Without it, such middleware must run before the handler.
+	DeferClientIP bool
+
+	// MaxPropagatedTraceAge limits the age of traces continued from the
+	// X-Amzn-Trace-Id header of incoming requests, as encoded in their trace
+	// ID. Requests propagating an older trace start a new trace, and the
+	// original trace ID is recorded in the superseded_trace_id annotation.
+	// A value of zero means no limit.
+	MaxPropagatedTraceAge time.Duration
+
+	// SamplingAttributes returns the attributes of a request, which are
+	// matched against the Attributes of centralized sampling rules.
+	// See ContextWithSamplingAttributes.
+	SamplingAttributes func(r *http.Request) map[string]string
+
+	// SamplingHeaders lists headers of incoming requests matched against
+	// the headers of local sampling rules. Other headers aren't available
+	// to sampling rules.
+	SamplingHeaders []string
+
+	// CaptureRequestHeaders lists headers of incoming requests recorded in
+	// the http.request_headers metadata namespace. Hop-by-hop headers and
+	// the Authorization, Proxy-Authorization and Cookie headers are never
+	// recorded, even if listed.
+	CaptureRequestHeaders []string
+
+	// CaptureContentLength records the Content-Length of incoming requests.
+	CaptureContentLength bool
+
+	// TraceHeaderKeys lists alternate names of the X-Amzn-Trace-Id header
+	// of incoming requests, such as X-Client-Trace-Id, tried in order when
+	// the request carries no header read by the propagators. Responses
+	// still carry the X-Amzn-Trace-Id header.
+	TraceHeaderKeys []string
+}
+
+// requestHeadersNamespace is the metadata namespace of the request
+// headers recorded with HandlerConfig.CaptureRequestHeaders.
+const requestHeadersNamespace = "http.request_headers"
+
+// refusedHeaders are never recorded with HandlerConfig.CaptureRequestHeaders
+// or WithResponseHeaders.
+var refusedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Connection":          true,
+	"Cookie":              true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Set-Cookie":          true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// capturedHeaders returns the canonical names of the listed request or
+// response headers, as told by kind, that may be recorded.
+func capturedHeaders(names []string, kind string) []string {
+	var captured []string
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if refusedHeaders[name] {
+			logger.Warnf("Refusing to capture the %s %s header", name, kind)
+			continue
+		}
+		captured = append(captured, name)
+	}
+	return captured
+}
+
+type remoteAddrKey struct{}
+
+// remoteAddr holds the address reported by SetRemoteAddr.
+type remoteAddr struct {
+	mu   sync.Mutex
+	addr string
+}
+
+// SetRemoteAddr reports the network address of the client of the request
+// handled with ctx, overriding its RemoteAddr. It only has an effect on
+// requests handled by HandlerWithConfig with DeferClientIP set.
+func SetRemoteAddr(ctx context.Context, addr string) {
+	if ra, ok := ctx.Value(remoteAddrKey{}).(*remoteAddr); ok {
+		ra.mu.Lock()
+		ra.addr = addr
+		ra.mu.Unlock()
+	}
+}
+
+// HandlerWithConfig wraps the provided http handler like Handler,
+// applying the given HandlerConfig.
+func HandlerWithConfig(sn SegmentNamer, h http.Handler, cfg HandlerConfig) http.Handler {
+	cfg.CaptureRequestHeaders = capturedHeaders(cfg.CaptureRequestHeaders, "request")
+	samplingHeaderNames := make([]string, 0, len(cfg.SamplingHeaders))
+	for _, name := range cfg.SamplingHeaders {
+		samplingHeaderNames = append(samplingHeaderNames, http.CanonicalHeaderKey(name))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := segmentName(sn, r)
+
+		traceHeader := extractTraceHeader(GetRecorder(r.Context()), r.Header, cfg.TraceHeaderKeys...)
+		var superseded string
+		if cfg.MaxPropagatedTraceAge > 0 {
+			traceHeader, superseded = supersedeExpiredTrace(traceHeader, cfg.MaxPropagatedTraceAge)
+		}
+		ctx := r.Context()
+		if cfg.SamplingAttributes != nil {
+			ctx = ContextWithSamplingAttributes(ctx, cfg.SamplingAttributes(r))
+		}
+		if len(samplingHeaderNames) != 0 {
+			ctx = contextWithSamplingHeaders(ctx, requestSamplingHeaders(r, samplingHeaderNames))
+		}
+		ctx, seg := NewSegmentFromHeader(ctx, name, r, traceHeader)
 		defer seg.Close(nil)
+		if superseded != "" {
+			seg.AddAnnotation("superseded_trace_id", superseded)
+		}
+		if cfg.DeferClientIP {
+			ctx = context.WithValue(ctx, remoteAddrKey{}, &remoteAddr{})
+		}
 		r = r.WithContext(ctx)
 
-		HttpTrace(seg, h, w, r, traceHeader)
+		httpTrace(seg, h, w, r, traceHeader, cfg)
 	})
 }
 
+// requestSamplingHeaders returns the values of the listed headers of r, by
+// canonical name. Headers missing from r are left out.
+func requestSamplingHeaders(r *http.Request, names []string) map[string]string {
+	headers := make(map[string]string, len(names))
+	for _, name := range names {
+		if values := r.Header.Values(name); len(values) > 0 {
+			headers[name] = strings.Join(values, ", ")
+		}
+	}
+	return headers
+}
+
+// HandlerOption changes the HandlerConfig of the handlers wrapped by Middleware.
+type HandlerOption func(cfg *HandlerConfig)
+
+// WithHandlerConfig sets the HandlerConfig of the handlers wrapped by Middleware.
+func WithHandlerConfig(cfg HandlerConfig) HandlerOption {
+	return func(c *HandlerConfig) {
+		*c = cfg
+	}
+}
+
+// Middleware returns a middleware wrapping handlers like HandlerWithConfig,
+// for routers chaining func(http.Handler) http.Handler middleware, such as
+// chi. The optional interfaces of response writers, such as http.Hijacker
+// and http.Flusher, are preserved.
+func Middleware(sn SegmentNamer, opts ...HandlerOption) func(http.Handler) http.Handler {
+	var cfg HandlerConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return func(h http.Handler) http.Handler {
+		return HandlerWithConfig(sn, h, cfg)
+	}
+}
+
+// supersedeExpiredTrace returns a header starting a new trace, along with the
+// trace ID of h, when h propagates a trace that began more than maxAge ago.
+// Otherwise h is returned unchanged.
+func supersedeExpiredTrace(h *header.Header, maxAge time.Duration) (*header.Header, string) {
+	if h.TraceID == "" {
+		return h, ""
+	}
+	began, err := header.TraceIDTime(h.TraceID)
+	if err != nil || time.Since(began) <= maxAge {
+		return h, ""
+	}
+	logger.Debugf("Starting a new trace in place of %s which began at %s", h.TraceID, began)
+	return &header.Header{
+		SamplingDecision: header.Unknown,
+		AdditionalData:   h.AdditionalData,
+	}, h.TraceID
+}
+
 func HttpTrace(seg *Segment, h http.Handler, w http.ResponseWriter, r *http.Request, traceHeader *header.Header) {
+	httpTrace(seg, h, w, r, traceHeader, HandlerConfig{})
+}
+
+func httpTrace(seg *Segment, h http.Handler, w http.ResponseWriter, r *http.Request, traceHeader *header.Header, cfg HandlerConfig) {
 	httpCaptureRequest(seg, r)
+	httpCaptureRequestDetails(seg, r, cfg)
 	traceIDHeaderValue := generateTraceIDHeaderValue(seg, traceHeader)
 	w.Header().Set(TraceIDHeaderKey, traceIDHeaderValue)
+	if cfg.EchoTraceIDHeader != "" {
+		seg.RLock()
+		if seg.Sampled || cfg.EchoUnsampledTraceID {
+			w.Header().Set(cfg.EchoTraceIDHeader, seg.TraceID)
+		}
+		seg.RUnlock()
+	}
 
-	capturer := &responseCapturer{w, 200, 0}
+	capturer := &responseCapturer{ResponseWriter: w, status: 200}
 	resp := capturer.wrappedResponseWriter()
 	h.ServeHTTP(resp, r)
 
 	seg.Lock()
-	seg.GetHTTP().GetResponse().ContentLength, _ = strconv.Atoi(capturer.Header().Get("Content-Length"))
+	seg.GetHTTP().GetResponse().ContentLength = capturer.contentLength()
+	if cfg.DeferClientIP {
+		addr := r.RemoteAddr
+		if ra, ok := r.Context().Value(remoteAddrKey{}).(*remoteAddr); ok {
+			ra.mu.Lock()
+			if ra.addr != "" {
+				addr = ra.addr
+			}
+			ra.mu.Unlock()
+		}
+		seg.GetHTTP().GetRequest().ClientIP, seg.GetHTTP().GetRequest().XForwardedFor = clientIPFromAddr(r.Header, addr)
+	}
 	seg.Unlock()
 	HttpCaptureResponse(seg, capturer.status)
 }
 
 func clientIP(r *http.Request) (string, bool) {
-	forwardedFor := r.Header.Get("X-Forwarded-For")
+	return clientIPFromAddr(r.Header, r.RemoteAddr)
+}
+
+// clientIPFromAddr returns the client IP of a request with the given headers
+// and remote address, and whether it was read from X-Forwarded-For.
+func clientIPFromAddr(h http.Header, addr string) (string, bool) {
+	forwardedFor := h.Get("X-Forwarded-For")
 	if forwardedFor != "" {
-		return strings.TrimSpace(strings.Split(forwardedFor, ",")[0]), true
+		return normalizeIP(strings.TrimSpace(strings.Split(forwardedFor, ",")[0])), true
 	}
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	ip, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		return r.RemoteAddr, false
+		return normalizeIP(addr), false
+	}
+	return normalizeIP(ip), false
+}
+
+// normalizeIP converts IPv4-mapped IPv6 addresses, such as ::ffff:203.0.113.7,
+// to their dotted-quad form. Other values are returned unchanged.
+func normalizeIP(ip string) string {
+	if !strings.Contains(ip, ":") {
+		return ip
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
 	}
-	return ip, false
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.String()
+	}
+	return ip
 }
 
 func btoi(b bool) int {
@@ -157,11 +424,8 @@ func generateTraceIDHeaderValue(seg *Segment, traceHeader *header.Header) string
 		respHeader.WriteString(traceHeader.ParentID)
 	}
 
-	if traceHeader.SamplingDecision == header.NotSampled {
-		respHeader.WriteString(";Sampled=0")
-	} else if traceHeader.SamplingDecision == header.Sampled {
-		respHeader.WriteString(";Sampled=1")
-	} else if traceHeader.SamplingDecision == header.Requested {
+	// The decision taken may differ from the incoming one, see resolveSampling.
+	if traceHeader.SamplingDecision != header.Unknown {
 		respHeader.WriteString(";Sampled=")
 		respHeader.WriteString(strconv.Itoa(btoi(seg.Sampled)))
 	}
@@ -187,6 +451,22 @@ func HttpCaptureResponse(seg *Segment, statusCode int) {
 	}
 }
 
+// httpCaptureRequestDetails records the Content-Length and headers of r
+// selected by cfg. Header values are copied, as the handler may modify them.
+// cfg.CaptureRequestHeaders must only list headers that may be recorded.
+func httpCaptureRequestDetails(seg *Segment, r *http.Request, cfg HandlerConfig) {
+	if cfg.CaptureContentLength && r.ContentLength > 0 {
+		seg.Lock()
+		seg.GetHTTP().GetRequest().ContentLength = int(r.ContentLength)
+		seg.Unlock()
+	}
+	for _, name := range cfg.CaptureRequestHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			seg.AddMetadataToNamespace(requestHeadersNamespace, name, strings.Join(values, ", "))
+		}
+	}
+}
+
 // httpCaptureRequest fill request data by http.Request
 func httpCaptureRequest(seg *Segment, r *http.Request) {
 	seg.Lock()
@@ -9,14 +9,18 @@
 package xray
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -74,6 +78,45 @@ func TestHandlerWithContextForRootHandler(t *testing.T) {
 	assert.Equal(t, "TestVersion", seg.Service.Version)
 }
 
+func TestHandlerChunkedResponseContentLength(t *testing.T) {
+	for name, write := range map[string]func(w http.ResponseWriter){
+		"write": func(w http.ResponseWriter) {
+			_, _ = w.Write([]byte("first chunk"))
+			w.(http.Flusher).Flush()
+			_, _ = w.Write([]byte("second chunk"))
+		},
+		"read from": func(w http.ResponseWriter) {
+			w.(http.Flusher).Flush()
+			// hides the io.WriterTo of strings.Reader from io.Copy
+			_, _ = io.Copy(w, struct{ io.Reader }{strings.NewReader("first chunksecond chunk")})
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, td := NewTestDaemon()
+			defer td.Close()
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				write(w)
+			})
+			ts := httptest.NewServer(HandlerWithContext(ctx, NewFixedSegmentNamer("test"), handler))
+			defer ts.Close()
+
+			resp, err := http.Get(ts.URL)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+			seg, err := td.Recv()
+			if assert.NoError(t, err) {
+				assert.Equal(t, len("first chunksecond chunk"), seg.HTTP.Response.ContentLength)
+			}
+		})
+	}
+}
+
 func TestHandlerWithContextForNonRootHandler(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -287,3 +330,543 @@ func TestHTTPCaptureResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestHandlerWithConfigEchoTraceIDHeader(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	tests := []struct {
+		name      string
+		sampled   string
+		unsampled bool
+		want      string
+	}{
+		{"sampled", "1", false, "1-5759e988-bd862e3fe1be46a994272793"},
+		{"unsampled", "0", false, ""},
+		{"unsampled echoed", "0", true, "1-5759e988-bd862e3fe1be46a994272793"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			cfg := HandlerConfig{EchoTraceIDHeader: "X-Trace-Id", EchoUnsampledTraceID: tt.unsampled}
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+			req.Header.Set(TraceIDHeaderKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled="+tt.sampled)
+			rec := httptest.NewRecorder()
+			HandlerWithConfig(NewFixedSegmentNamer("test"), handler, cfg).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.want, rec.Result().Header.Get("X-Trace-Id"))
+			assert.NotEmpty(t, rec.Result().Header.Get(TraceIDHeaderKey))
+		})
+	}
+}
+
+func TestHandlerWithConfigEchoTraceIDHeaderEarlyWrite(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first Write sends the headers with an implicit WriteHeader.
+		if _, err := w.Write([]byte(`200 - OK`)); err != nil {
+			panic(err)
+		}
+		w.Header().Set("X-Late", "ignored")
+	})
+	cfg := HandlerConfig{EchoTraceIDHeader: "X-Trace-Id"}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+	req.Header.Set(TraceIDHeaderKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+	rec := httptest.NewRecorder()
+	HandlerWithConfig(NewFixedSegmentNamer("test"), handler, cfg).ServeHTTP(rec, req)
+
+	resp := rec.Result()
+	assert.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", resp.Header.Get("X-Trace-Id"))
+	assert.Equal(t, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1", resp.Header.Get(TraceIDHeaderKey))
+	assert.Empty(t, resp.Header.Get("X-Late"))
+}
+
+func TestClientIPMappedAddress(t *testing.T) {
+	tests := []struct {
+		remoteAddr    string
+		forwardedFor  string
+		ip            string
+		xForwardedFor bool
+	}{
+		{remoteAddr: "[::ffff:203.0.113.7]:4242", ip: "203.0.113.7"},
+		{remoteAddr: "::ffff:203.0.113.7", ip: "203.0.113.7"},
+		{remoteAddr: "203.0.113.7:4242", ip: "203.0.113.7"},
+		{remoteAddr: "[2001:db8::1]:4242", ip: "2001:db8::1"},
+		{remoteAddr: "10.0.0.1:4242", forwardedFor: "::ffff:198.51.100.2, 10.0.0.1", ip: "198.51.100.2", xForwardedFor: true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		ip, xForwardedFor := clientIP(r)
+		assert.Equal(t, tt.ip, ip, tt.remoteAddr)
+		assert.Equal(t, tt.xForwardedFor, xForwardedFor, tt.remoteAddr)
+	}
+}
+
+func TestHandlerWithConfigDeferClientIP(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	tests := []struct {
+		name       string
+		cfg        HandlerConfig
+		middleware func(r *http.Request)
+		want       string
+	}{
+		{
+			name:       "rewritten RemoteAddr",
+			cfg:        HandlerConfig{DeferClientIP: true},
+			middleware: func(r *http.Request) { r.RemoteAddr = "[::ffff:203.0.113.7]:4242" },
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "SetRemoteAddr",
+			cfg:        HandlerConfig{DeferClientIP: true},
+			middleware: func(r *http.Request) { SetRemoteAddr(r.Context(), "198.51.100.2:4242") },
+			want:       "198.51.100.2",
+		},
+		{
+			name:       "not deferred",
+			cfg:        HandlerConfig{},
+			middleware: func(r *http.Request) { r.RemoteAddr = "[::ffff:203.0.113.7]:4242" },
+			want:       "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// proxy protocol middleware running after the X-Ray handler
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.middleware(r)
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+			req.RemoteAddr = "10.0.0.1:1234"
+			rec := httptest.NewRecorder()
+			HandlerWithConfig(NewFixedSegmentNamer("test"), handler, tt.cfg).ServeHTTP(rec, req)
+
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.want, seg.HTTP.Request.ClientIP)
+			assert.False(t, seg.HTTP.Request.XForwardedFor)
+		})
+	}
+}
+
+func TestHandlerWithConfigMaxPropagatedTraceAge(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	tests := []struct {
+		name       string
+		age        time.Duration
+		superseded bool
+	}{
+		{"fresh", 0, false},
+		{"slightly old", 30 * time.Minute, false},
+		{"very old", 14 * 24 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			cfg := HandlerConfig{MaxPropagatedTraceAge: time.Hour}
+			traceID := fmt.Sprintf("1-%08x-bd862e3fe1be46a994272793", time.Now().Add(-tt.age).Unix())
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+			req.Header.Set(TraceIDHeaderKey, "Root="+traceID+";Parent=53995c3f42cd8ad8;Sampled=1")
+			rec := httptest.NewRecorder()
+			HandlerWithConfig(NewFixedSegmentNamer("test"), handler, cfg).ServeHTTP(rec, req)
+
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			respHeader := header.FromString(rec.Result().Header.Get(TraceIDHeaderKey))
+			if tt.superseded {
+				assert.NotEqual(t, traceID, seg.TraceID)
+				assert.Empty(t, seg.ParentID)
+				assert.Equal(t, traceID, seg.Annotations["superseded_trace_id"])
+			} else {
+				assert.Equal(t, traceID, seg.TraceID)
+				assert.Equal(t, "53995c3f42cd8ad8", seg.ParentID)
+				assert.NotContains(t, seg.Annotations, "superseded_trace_id")
+			}
+			assert.Equal(t, seg.TraceID, respHeader.TraceID)
+		})
+	}
+}
+
+func TestHandlerWithConfigTraceHeaderKeys(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	traceID := fmt.Sprintf("1-%08x-bd862e3fe1be46a994272793", time.Now().Unix())
+	var th *header.Header
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		th = TraceHeaderFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	cfg := HandlerConfig{TraceHeaderKeys: []string{"X-Legacy-Trace-Id", "x-client-trace-id"}}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+	req.Header.Set("X-Client-Trace-Id", "Root="+traceID+";Parent=53995c3f42cd8ad8;Sampled=1")
+	rec := httptest.NewRecorder()
+	HandlerWithConfig(NewFixedSegmentNamer("test"), handler, cfg).ServeHTTP(rec, req)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, traceID, seg.TraceID)
+	assert.Equal(t, "53995c3f42cd8ad8", seg.ParentID)
+	if assert.NotNil(t, th) {
+		assert.Equal(t, traceID, th.TraceID)
+		assert.Equal(t, "53995c3f42cd8ad8", th.ParentID)
+		assert.Equal(t, header.Sampled, th.SamplingDecision)
+	}
+	respHeader := header.FromString(rec.Result().Header.Get(TraceIDHeaderKey))
+	assert.Equal(t, traceID, respHeader.TraceID)
+	assert.Empty(t, rec.Result().Header.Get("X-Client-Trace-Id"))
+}
+
+func TestHandlerWithConfigTraceHeaderKeysPrecedence(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	traceID := fmt.Sprintf("1-%08x-bd862e3fe1be46a994272793", time.Now().Unix())
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	cfg := HandlerConfig{TraceHeaderKeys: []string{"X-Client-Trace-Id"}}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+	req.Header.Set(TraceIDHeaderKey, "Root="+traceID+";Sampled=1")
+	req.Header.Set("X-Client-Trace-Id", "Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1")
+	HandlerWithConfig(NewFixedSegmentNamer("test"), handler, cfg).ServeHTTP(httptest.NewRecorder(), req)
+
+	seg, err := td.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, traceID, seg.TraceID)
+	}
+}
+
+func TestHandlerWithConfigSamplingAttributes(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	var attributes map[string]string
+	ctx, err := ContextWithConfig(ctx, Config{
+		Emitter: GetRecorder(ctx).Emitter,
+		SamplingStrategy: sampling.NewFuncStrategy(func(rq *sampling.Request) bool {
+			attributes = rq.Attributes
+			return rq.Attributes["tier"] == "premium"
+		}),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx = ContextWithSamplingAttributes(ctx, map[string]string{"region": "eu-1"})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	cfg := HandlerConfig{
+		SamplingAttributes: func(r *http.Request) map[string]string {
+			return map[string]string{"tier": r.Header.Get("X-Tier")}
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+	req.Header.Set("X-Tier", "premium")
+	rec := httptest.NewRecorder()
+	HandlerWithConfig(NewFixedSegmentNamer("test"), handler, cfg).ServeHTTP(rec, req)
+
+	assert.Equal(t, map[string]string{"region": "eu-1", "tier": "premium"}, attributes)
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "test", seg.Name)
+}
+
+func TestHandlerWithConfigSamplingHeaders(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ss, err := sampling.NewLocalizedStrategyFromRulesWithDefault([]sampling.RuleSpec{
+		{Headers: map[string]string{"x-api-tier": "free"}, FixedTarget: 0, Rate: 0},
+	}, sampling.RuleSpec{FixedTarget: 0, Rate: 1})
+	if !assert.NoError(t, err) {
+		return
+	}
+	var headers map[string]string
+	ctx, err = ContextWithConfig(ctx, Config{
+		Emitter: GetRecorder(ctx).Emitter,
+		SamplingStrategy: sampling.NewFuncStrategy(func(rq *sampling.Request) bool {
+			headers = rq.Headers
+			return ss.ShouldTrace(rq).Sample
+		}),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := HandlerWithConfig(NewFixedSegmentNamer("test"), handler, HandlerConfig{SamplingHeaders: []string{"x-api-tier"}})
+
+	for _, tier := range []string{"free", "paid"} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+		req.Header.Set("X-Api-Tier", tier)
+		req.Header.Set("X-Other", "other")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		// only the listed headers are matched against sampling rules
+		assert.Equal(t, map[string]string{"X-Api-Tier": tier}, headers)
+	}
+
+	// only the paid request is sampled
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "test", seg.Name)
+	_, err = td.Recv()
+	assert.Error(t, err)
+}
+
+func TestHandlerSegmentNamerFunc(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	sn := SegmentNamerFunc(func(r *http.Request) string {
+		return "api-" + strings.SplitN(r.Host, ".", 2)[0]
+	})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, host := range []string{"acme.example.com", "globex.example.com"} {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil).WithContext(ctx)
+		Handler(sn, handler).ServeHTTP(httptest.NewRecorder(), req)
+
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "api-"+strings.SplitN(host, ".", 2)[0], seg.Name)
+	}
+	assert.Equal(t, "api-initech", sn.Name("initech.example.com"))
+}
+
+func TestHandlerSegmentNamerFuncOverrideAndTruncation(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	sn := SegmentNamerFunc(func(r *http.Request) string {
+		return r.Header.Get("X-Tenant") + strings.Repeat("x", 250)
+	})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+	req.Header.Set("X-Tenant", "acme")
+	HandlerWithContext(ctx, sn, handler).ServeHTTP(httptest.NewRecorder(), req)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, ("acme" + strings.Repeat("x", 250))[:200], seg.Name)
+
+	os.Setenv("AWS_XRAY_TRACING_NAME", "overridden")
+	defer os.Unsetenv("AWS_XRAY_TRACING_NAME")
+
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+	Handler(sn, handler).ServeHTTP(httptest.NewRecorder(), req)
+
+	seg, err = td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "overridden", seg.Name)
+}
+
+func TestHandlerWithConfigCaptureRequestDetails(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Request-Id", "modified")
+		w.WriteHeader(http.StatusOK)
+	})
+	cfg := HandlerConfig{
+		CaptureRequestHeaders: []string{"x-request-id", "Content-Type", "Accept", "Authorization", "cookie", "Connection"},
+		CaptureContentLength:  true,
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader(`{"a":1}`)).WithContext(ctx)
+	req.Header.Set("X-Request-Id", "req-1")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Add("Accept", "text/plain")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("Connection", "keep-alive")
+	HandlerWithConfig(NewFixedSegmentNamer("test"), handler, cfg).ServeHTTP(httptest.NewRecorder(), req)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 7, seg.HTTP.Request.ContentLength)
+	assert.Equal(t, map[string]interface{}{
+		"X-Request-Id": "req-1",
+		"Content-Type": "application/json",
+		"Accept":       "text/plain, application/json",
+	}, seg.Metadata["http.request_headers"])
+}
+
+func TestHandlerCapturesNoRequestDetailsByDefault(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader(`{"a":1}`)).WithContext(ctx)
+	req.Header.Set("X-Request-Id", "req-1")
+	Handler(NewFixedSegmentNamer("test"), handler).ServeHTTP(httptest.NewRecorder(), req)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 0, seg.HTTP.Request.ContentLength)
+	assert.NotContains(t, seg.Metadata, "http.request_headers")
+}
+
+// middlewareRouter chains func(http.Handler) http.Handler middleware like
+// the Use method of routers such as chi.
+type middlewareRouter struct {
+	middlewares []func(http.Handler) http.Handler
+	handler     http.Handler
+}
+
+func (m *middlewareRouter) Use(middlewares ...func(http.Handler) http.Handler) {
+	m.middlewares = append(m.middlewares, middlewares...)
+}
+
+func (m *middlewareRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := m.handler
+	for i := len(m.middlewares) - 1; i >= 0; i-- {
+		h = m.middlewares[i](h)
+	}
+	h.ServeHTTP(w, r)
+}
+
+func TestMiddlewareMatchesHandler(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short"))
+	})
+	router := &middlewareRouter{handler: handler}
+	router.Use(Middleware(NewFixedSegmentNamer("test"), WithHandlerConfig(HandlerConfig{CaptureContentLength: true})))
+
+	serve := func(h http.Handler) *Segment {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/brew?sugar=1", strings.NewReader(`{"a":1}`)).WithContext(ctx)
+		req.Header.Set("User-Agent", "test-agent")
+		req.RemoteAddr = "10.0.0.1:1234"
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return &Segment{}
+		}
+		return seg
+	}
+
+	want := serve(HandlerWithConfig(NewFixedSegmentNamer("test"), handler, HandlerConfig{CaptureContentLength: true}))
+	got := serve(router)
+	assert.Equal(t, want.Name, got.Name)
+	assert.Equal(t, want.HTTP, got.HTTP)
+	assert.Equal(t, want.Fault, got.Fault)
+	assert.Equal(t, want.Error, got.Error)
+	assert.Equal(t, http.StatusTeapot, got.HTTP.Response.Status)
+	assert.Equal(t, 7, got.HTTP.Request.ContentLength)
+}
+
+func TestMiddlewareHijack(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	router := &middlewareRouter{handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !assert.True(t, ok) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, isFlusher := w.(http.Flusher)
+		_, isReaderFrom := w.(io.ReaderFrom)
+		assert.True(t, isFlusher)
+		assert.True(t, isReaderFrom)
+
+		conn, rw, err := hj.Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+	})}
+	router.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}, Middleware(NewFixedSegmentNamer("test")))
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	seg, err := td.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "test", seg.Name)
+	}
+}
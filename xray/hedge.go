@@ -0,0 +1,102 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type hedgeGroupKey struct{}
+
+// HedgeGroup groups the attempts of a hedged call, i.e. a call sent again
+// before the first attempt responds, the first response being used. Each HTTP
+// request made through the Client or RoundTripper with the context returned by
+// WithHedgeGroup is an attempt of the group, and its subsegment is annotated
+// with the ID of the group ("hedge_group_id") and its attempt number
+// ("hedge_attempt"), starting at 1.
+// The first attempt to get a response wins and is annotated with
+// "hedge_winner". The other attempts are annotated with "hedge_abandoned", and
+// are not recorded as errors when they are canceled.
+type HedgeGroup interface {
+	// ID returns the ID of the group.
+	ID() string
+	// Winner returns the number of the attempt that won, or 0 if no attempt
+	// got a response yet.
+	Winner() int
+}
+
+// WithHedgeGroup returns a context making the HTTP requests made with it the
+// attempts of a new HedgeGroup.
+func WithHedgeGroup(ctx context.Context) (context.Context, HedgeGroup) {
+	g := &hedgeGroup{id: NewSegmentID()}
+	return context.WithValue(ctx, hedgeGroupKey{}, g), g
+}
+
+func hedgeGroupFromContext(ctx context.Context) *hedgeGroup {
+	g, _ := ctx.Value(hedgeGroupKey{}).(*hedgeGroup)
+	return g
+}
+
+type hedgeGroup struct {
+	id       string
+	attempts int32
+	winner   int32
+}
+
+func (g *hedgeGroup) ID() string {
+	return g.id
+}
+
+func (g *hedgeGroup) Winner() int {
+	return int(atomic.LoadInt32(&g.winner))
+}
+
+// begin annotates seg as a new attempt of the group and returns its number.
+// The caller of begin should have write lock on seg instance.
+func (g *hedgeGroup) begin(seg *Segment) int {
+	attempt := int(atomic.AddInt32(&g.attempts, 1))
+	if !seg.Dummy {
+		if seg.Annotations == nil {
+			seg.Annotations = map[string]interface{}{}
+		}
+		seg.Annotations["hedge_group_id"] = g.id
+		seg.Annotations["hedge_attempt"] = attempt
+	}
+	return attempt
+}
+
+// finish marks seg, the subsegment of attempt, as the winner of the group if
+// it's the first attempt to get a response, or as abandoned if another attempt
+// won. The error of a canceled abandoned attempt is removed from seg, and
+// finish reports whether it should not be recorded.
+func (g *hedgeGroup) finish(seg *Segment, attempt int, err error) bool {
+	if err == nil && atomic.CompareAndSwapInt32(&g.winner, 0, int32(attempt)) {
+		seg.AddAnnotation("hedge_winner", true)
+		return false
+	}
+	if g.Winner() == 0 {
+		return false
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+	if !seg.Dummy {
+		if seg.Annotations == nil {
+			seg.Annotations = map[string]interface{}{}
+		}
+		seg.Annotations["hedge_abandoned"] = true
+	}
+	if err != nil {
+		seg.Error = false
+		seg.Fault = false
+		return true
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedgeGroup(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer fast.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	ctx, group := WithHedgeGroup(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	client := Client(nil)
+
+	var wg sync.WaitGroup
+	var slowErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, slow.URL, nil)
+		if err != nil {
+			slowErr = err
+			return
+		}
+		_, slowErr = client.Do(req)
+	}()
+
+	// hedge the slow attempt
+	time.Sleep(20 * time.Millisecond)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fast.URL, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err := client.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+
+	// take the first response and abandon the slow attempt
+	cancel()
+	wg.Wait()
+	assert.Error(t, slowErr)
+	assert.Equal(t, 2, group.Winner())
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, seg.Subsegments, 2) {
+		return
+	}
+	attempts := map[string]*Segment{}
+	for _, b := range seg.Subsegments {
+		var subseg *Segment
+		if assert.NoError(t, json.Unmarshal(b, &subseg)) {
+			attempts[subseg.HTTP.Request.URL] = subseg
+		}
+	}
+
+	winner := attempts[fast.URL]
+	if assert.NotNil(t, winner) {
+		assert.Equal(t, group.ID(), winner.Annotations["hedge_group_id"])
+		assert.Equal(t, float64(2), winner.Annotations["hedge_attempt"])
+		assert.Equal(t, true, winner.Annotations["hedge_winner"])
+		assert.NotContains(t, winner.Annotations, "hedge_abandoned")
+	}
+	abandoned := attempts[slow.URL]
+	if assert.NotNil(t, abandoned) {
+		assert.Equal(t, group.ID(), abandoned.Annotations["hedge_group_id"])
+		assert.Equal(t, float64(1), abandoned.Annotations["hedge_attempt"])
+		assert.Equal(t, true, abandoned.Annotations["hedge_abandoned"])
+		assert.NotContains(t, abandoned.Annotations, "hedge_winner")
+		assert.False(t, abandoned.Error)
+		assert.False(t, abandoned.Fault)
+		assert.Nil(t, abandoned.Cause)
+	}
+}
+
+func TestHedgeGroupFailedAttempt(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "Test")
+	ctx, group := WithHedgeGroup(ctx)
+
+	// an attempt failing before any attempt won is recorded as a failure
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:0", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = Client(nil).Do(req)
+	assert.Error(t, err)
+	assert.Equal(t, 0, group.Winner())
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var subseg *Segment
+	if assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		assert.True(t, subseg.Fault)
+		assert.Equal(t, float64(1), subseg.Annotations["hedge_attempt"])
+		assert.NotContains(t, subseg.Annotations, "hedge_abandoned")
+	}
+}
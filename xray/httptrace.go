@@ -15,7 +15,9 @@ import (
 	"crypto/tls"
 	"errors"
 	"net/http/httptrace"
+	"strconv"
 	"sync"
+	"time"
 )
 
 // HTTPSubsegments is a set of context in different HTTP operation.
@@ -32,22 +34,119 @@ type HTTPSubsegments struct {
 	tlsCtx      context.Context
 	reqCtx      context.Context
 	responseCtx context.Context
+	continueCtx context.Context
+	firstByte   time.Time
 	mu          sync.Mutex
+
+	// attempts of the request, see nextAttempt
+	attempts     int
+	attemptCtx   context.Context
+	firstAttempt []*Segment
+	gotConn      bool
+	wrote        bool
+	writeErr     error
+
+	// compact connection metrics, see ClientTraceOptions
+	compact    bool
+	connection map[string]interface{}
+	dnsStart   time.Time
+	dialStarts map[string]time.Time
+	tlsStart   time.Time
 }
 
 // NewHTTPSubsegments creates a new HTTPSubsegments to use in
 // httptrace.ClientTrace functions
 func NewHTTPSubsegments(opCtx context.Context) *HTTPSubsegments {
-	return &HTTPSubsegments{opCtx: opCtx}
+	return &HTTPSubsegments{opCtx: opCtx, attempts: 1}
+}
+
+// beginSubsegment begins a subsegment of the current attempt of the request,
+// directly in the HTTP operation subsegment for the first attempt.
+// The caller of beginSubsegment should hold xt.mu.
+func (xt *HTTPSubsegments) beginSubsegment(name string) context.Context {
+	if xt.attemptCtx != nil {
+		ctx, _ := BeginSubsegment(xt.attemptCtx, name)
+		return ctx
+	}
+	ctx, seg := BeginSubsegment(xt.opCtx, name)
+	if seg != nil {
+		xt.firstAttempt = append(xt.firstAttempt, seg)
+	}
+	return ctx
+}
+
+// nextAttempt ends the current attempt of the request, as net/http retries
+// it, and begins an "attempt #N" subsegment for the next one. The subsegments
+// of the first attempt, begun in the HTTP operation subsegment as if it were
+// the only one, are moved to an "attempt #1" subsegment then. The number of
+// attempts and retries is recorded in the "http" metadata namespace of the
+// HTTP operation subsegment.
+// The caller of nextAttempt should hold xt.mu.
+func (xt *HTTPSubsegments) nextAttempt() {
+	op := GetSegment(xt.opCtx)
+	if !op.safeInProgress() {
+		return
+	}
+	if xt.attemptCtx == nil {
+		ctx, seg := BeginSubsegment(xt.opCtx, "attempt #1")
+		if seg == nil {
+			return
+		}
+		op.moveSubsegments(seg, xt.firstAttempt)
+		xt.firstAttempt = nil
+		xt.attemptCtx = ctx
+	}
+
+	// the subsegments left open by the failed attempt
+	for _, ctx := range []context.Context{xt.dnsCtx, xt.connectCtx, xt.tlsCtx, xt.connCtx, xt.continueCtx, xt.reqCtx, xt.responseCtx} {
+		if ctx != nil && GetSegment(ctx).safeInProgress() {
+			GetSegment(ctx).Close(xt.writeErr)
+		}
+	}
+	attempt := GetSegment(xt.attemptCtx)
+	attempt.Lock()
+	attempt.Fault = true
+	attempt.Unlock()
+	attempt.Close(xt.writeErr)
+
+	xt.attempts++
+	xt.attemptCtx, _ = BeginSubsegment(xt.opCtx, "attempt #"+strconv.Itoa(xt.attempts))
+	AddMetadataToNamespace(xt.opCtx, "http", "attempts", xt.attempts)
+	AddMetadataToNamespace(xt.opCtx, "http", "retries", xt.attempts-1)
+
+	xt.connCtx, xt.dnsCtx, xt.connectCtx, xt.tlsCtx = nil, nil, nil, nil
+	xt.reqCtx, xt.continueCtx, xt.responseCtx = nil, nil, nil
+	xt.firstByte = time.Time{}
+	xt.gotConn, xt.wrote, xt.writeErr = false, false, nil
+}
+
+// recordConnectionTime records the time since start under key in the
+// compact connection metrics. Nothing is recorded without a start time.
+// The caller of recordConnectionTime should hold xt.mu.
+func (xt *HTTPSubsegments) recordConnectionTime(key string, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	if xt.connection == nil {
+		xt.connection = make(map[string]interface{})
+	}
+	xt.connection[key] = float64(time.Since(start)) / float64(time.Millisecond)
 }
 
 // GetConn begins a connect subsegment if the HTTP operation
-// subsegment is still in progress.
+// subsegment is still in progress. Getting a connection again once one
+// was got or the request was written begins a new attempt of the request.
 func (xt *HTTPSubsegments) GetConn(hostPort string) {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
+	if xt.gotConn || xt.wrote {
+		xt.nextAttempt()
+	}
+	if xt.compact {
+		return
+	}
 	if GetSegment(xt.opCtx).safeInProgress() {
-		xt.connCtx, _ = BeginSubsegment(xt.opCtx, "connect")
+		xt.connCtx = xt.beginSubsegment("connect")
 	}
 }
 
@@ -56,6 +155,10 @@ func (xt *HTTPSubsegments) GetConn(hostPort string) {
 func (xt *HTTPSubsegments) DNSStart(info httptrace.DNSStartInfo) {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
+	if xt.compact {
+		xt.dnsStart = time.Now()
+		return
+	}
 	if GetSegment(xt.opCtx).safeInProgress() && xt.connCtx != nil {
 		xt.dnsCtx, _ = BeginSubsegment(xt.connCtx, "dns")
 	}
@@ -69,6 +172,10 @@ func (xt *HTTPSubsegments) DNSStart(info httptrace.DNSStartInfo) {
 func (xt *HTTPSubsegments) DNSDone(info httptrace.DNSDoneInfo) {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
+	if xt.compact {
+		xt.recordConnectionTime("dns_ms", xt.dnsStart)
+		return
+	}
 	if xt.dnsCtx != nil && GetSegment(xt.opCtx).safeInProgress() {
 		metadata := make(map[string]interface{})
 		metadata["addresses"] = info.Addrs
@@ -84,6 +191,14 @@ func (xt *HTTPSubsegments) DNSDone(info httptrace.DNSDoneInfo) {
 func (xt *HTTPSubsegments) ConnectStart(network, addr string) {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
+	if xt.compact {
+		// addresses may be dialed in parallel, see RFC 6555
+		if xt.dialStarts == nil {
+			xt.dialStarts = make(map[string]time.Time)
+		}
+		xt.dialStarts[addr] = time.Now()
+		return
+	}
 	if GetSegment(xt.opCtx).safeInProgress() && xt.connCtx != nil {
 		xt.connectCtx, _ = BeginSubsegment(xt.connCtx, "dial")
 	}
@@ -96,6 +211,12 @@ func (xt *HTTPSubsegments) ConnectStart(network, addr string) {
 func (xt *HTTPSubsegments) ConnectDone(network, addr string, err error) {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
+	if xt.compact {
+		if err == nil {
+			xt.recordConnectionTime("dial_ms", xt.dialStarts[addr])
+		}
+		return
+	}
 	if xt.connectCtx != nil && GetSegment(xt.opCtx).safeInProgress() {
 		metadata := make(map[string]interface{})
 		metadata["network"] = network
@@ -110,6 +231,10 @@ func (xt *HTTPSubsegments) ConnectDone(network, addr string, err error) {
 func (xt *HTTPSubsegments) TLSHandshakeStart() {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
+	if xt.compact {
+		xt.tlsStart = time.Now()
+		return
+	}
 	if GetSegment(xt.opCtx).safeInProgress() && xt.connCtx != nil {
 		xt.tlsCtx, _ = BeginSubsegment(xt.connCtx, "tls")
 	}
@@ -122,6 +247,12 @@ func (xt *HTTPSubsegments) TLSHandshakeStart() {
 func (xt *HTTPSubsegments) TLSHandshakeDone(connState tls.ConnectionState, err error) {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
+	if xt.compact {
+		if err == nil {
+			xt.recordConnectionTime("tls_ms", xt.tlsStart)
+		}
+		return
+	}
 	if xt.tlsCtx != nil && GetSegment(xt.opCtx).safeInProgress() {
 		metadata := make(map[string]interface{})
 		metadata["did_resume"] = connState.DidResume
@@ -142,10 +273,18 @@ func (xt *HTTPSubsegments) TLSHandshakeDone(connState tls.ConnectionState, err e
 func (xt *HTTPSubsegments) GotConn(info *httptrace.GotConnInfo, err error) {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
+	if xt.compact {
+		xt.gotConnCompact(info, err)
+		if info != nil && err == nil {
+			xt.gotConn = true
+		}
+		return
+	}
 	if xt.connCtx != nil && GetSegment(xt.opCtx).safeInProgress() { // GetConn may not have been called (client_test.TestBadRoundTrip)
 		if info != nil {
 			if info.Reused {
-				GetSegment(xt.opCtx).RemoveSubsegment(GetSegment(xt.connCtx))
+				conn := GetSegment(xt.connCtx)
+				conn.parent.RemoveSubsegment(conn)
 				// Remove the connCtx context since it is no longer needed.
 				xt.connCtx = nil
 			} else {
@@ -164,22 +303,63 @@ func (xt *HTTPSubsegments) GotConn(info *httptrace.GotConnInfo, err error) {
 		}
 
 		if err == nil {
-			xt.reqCtx, _ = BeginSubsegment(xt.opCtx, "request")
+			xt.reqCtx = xt.beginSubsegment("request")
+		}
+
+	}
+	if info != nil && err == nil {
+		xt.gotConn = true
+	}
+}
+
+// gotConnCompact records the compact connection metrics in the "http"
+// metadata namespace of the HTTP operation subsegment, under "connection".
+// The caller of gotConnCompact should hold xt.mu.
+func (xt *HTTPSubsegments) gotConnCompact(info *httptrace.GotConnInfo, err error) {
+	if !GetSegment(xt.opCtx).safeInProgress() {
+		return
+	}
+	if info != nil {
+		if xt.connection == nil {
+			xt.connection = make(map[string]interface{})
 		}
+		xt.connection["reused"] = info.Reused
+		xt.connection["was_idle"] = info.WasIdle
+	}
+	if xt.connection != nil {
+		AddMetadataToNamespace(xt.opCtx, "http", "connection", xt.connection)
+		xt.connection = nil
+	}
 
+	if err == nil && xt.reqCtx == nil {
+		xt.reqCtx = xt.beginSubsegment("request")
 	}
 }
 
 // WroteRequest closes the request subsegment if the HTTP operation
 // subsegment is still in progress, passing the error value
-// (if any). The response subsegment is then begun.
+// (if any). The response subsegment is then begun. Writing the request
+// again begins a new attempt of the request.
 func (xt *HTTPSubsegments) WroteRequest(info httptrace.WroteRequestInfo) {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
-	if xt.reqCtx != nil && GetSegment(xt.opCtx).safeInProgress() {
-		GetSegment(xt.reqCtx).Close(info.Err)
-		resCtx, _ := BeginSubsegment(xt.opCtx, "response")
-		xt.responseCtx = resCtx
+	retried := xt.wrote
+	if retried {
+		xt.nextAttempt()
+	}
+	xt.wrote = true
+	xt.writeErr = info.Err
+
+	// the wait for a 100 Continue response timed out, or the server
+	// answered with a final response
+	if xt.continueCtx != nil && GetSegment(xt.continueCtx).safeInProgress() {
+		GetSegment(xt.continueCtx).Close(nil)
+	}
+	if (xt.reqCtx != nil || retried) && GetSegment(xt.opCtx).safeInProgress() {
+		if xt.reqCtx != nil {
+			GetSegment(xt.reqCtx).Close(info.Err)
+		}
+		xt.responseCtx = xt.beginSubsegment("response")
 	}
 
 	// In case the GotConn http trace handler wasn't called,
@@ -196,12 +376,73 @@ func (xt *HTTPSubsegments) WroteRequest(info httptrace.WroteRequestInfo) {
 func (xt *HTTPSubsegments) GotFirstResponseByte() {
 	xt.mu.Lock()
 	defer xt.mu.Unlock()
+	xt.firstByte = time.Now()
 	resCtx := xt.responseCtx
 	if resCtx != nil && GetSegment(xt.opCtx).safeInProgress() {
 		GetSegment(resCtx).Close(nil)
 	}
 }
 
+// Wait100Continue begins a continue subsegment in the request subsegment,
+// for the time spent waiting for a 100 Continue response before writing the
+// request body, if the HTTP operation subsegment is still in progress.
+func (xt *HTTPSubsegments) Wait100Continue() {
+	xt.mu.Lock()
+	defer xt.mu.Unlock()
+	if xt.reqCtx != nil && GetSegment(xt.opCtx).safeInProgress() {
+		xt.continueCtx, _ = BeginSubsegment(xt.reqCtx, "continue")
+	}
+}
+
+// Got100Continue closes the continue subsegment if the HTTP operation
+// subsegment is still in progress. The first byte of the interim response
+// isn't the one of the response.
+func (xt *HTTPSubsegments) Got100Continue() {
+	xt.mu.Lock()
+	defer xt.mu.Unlock()
+	xt.firstByte = time.Time{}
+	if xt.continueCtx != nil && GetSegment(xt.opCtx).safeInProgress() {
+		GetSegment(xt.continueCtx).Close(nil)
+	}
+}
+
+// done closes the response subsegment once the HTTP round trip returns, as
+// its first byte may have been the one of an interim response, and the
+// subsegment of the last attempt if the request was retried, passing the
+// error value (if any).
+func (xt *HTTPSubsegments) done(err error) {
+	xt.mu.Lock()
+	defer xt.mu.Unlock()
+	if !GetSegment(xt.opCtx).safeInProgress() {
+		return
+	}
+	if xt.responseCtx != nil && GetSegment(xt.responseCtx).safeInProgress() {
+		GetSegment(xt.responseCtx).Close(err)
+	}
+	if xt.attemptCtx != nil && GetSegment(xt.attemptCtx).safeInProgress() {
+		GetSegment(xt.attemptCtx).Close(err)
+	}
+}
+
+// firstResponseByte returns the time the first byte of the response
+// headers was received, or the zero time if it has not been received yet.
+func (xt *HTTPSubsegments) firstResponseByte() time.Time {
+	xt.mu.Lock()
+	defer xt.mu.Unlock()
+	return xt.firstByte
+}
+
+// ClientTraceOptions configures the subsegments generated by a ClientTrace.
+type ClientTraceOptions struct {
+	// CompactConnectionMetrics records the DNS lookup, dial and TLS
+	// handshake times ("dns_ms", "dial_ms" and "tls_ms") and whether the
+	// connection was reused ("reused", "was_idle") in the "http" metadata
+	// namespace of the HTTP operation subsegment, under "connection",
+	// instead of in connect, dns, dial and tls subsegments. The times are
+	// missing for reused connections.
+	CompactConnectionMetrics bool
+}
+
 // ClientTrace is a set of pointers of HTTPSubsegments and ClientTrace.
 type ClientTrace struct {
 	subsegments *HTTPSubsegments
@@ -213,11 +454,18 @@ type ClientTrace struct {
 // generate subsegments for connection time, DNS lookup time, TLS
 // handshake time, and provides additional information about the HTTP round trip
 func NewClientTrace(opCtx context.Context) (ct *ClientTrace, err error) {
+	return NewClientTraceWithOptions(opCtx, ClientTraceOptions{})
+}
+
+// NewClientTraceWithOptions returns an instance of xray.ClientTrace like
+// NewClientTrace, configured by opts.
+func NewClientTraceWithOptions(opCtx context.Context, opts ClientTraceOptions) (ct *ClientTrace, err error) {
 	if opCtx == nil {
 		return nil, errors.New("opCtx must be non-nil")
 	}
 
 	segs := NewHTTPSubsegments(opCtx)
+	segs.compact = opts.CompactConnectionMetrics
 
 	return &ClientTrace{
 		subsegments: segs,
@@ -249,6 +497,12 @@ func NewClientTrace(opCtx context.Context) (ct *ClientTrace, err error) {
 			WroteRequest: func(info httptrace.WroteRequestInfo) {
 				segs.WroteRequest(info)
 			},
+			Wait100Continue: func() {
+				segs.Wait100Continue()
+			},
+			Got100Continue: func() {
+				segs.Got100Continue()
+			},
 			GotFirstResponseByte: func() {
 				segs.GotFirstResponseByte()
 			},
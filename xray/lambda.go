@@ -24,6 +24,10 @@ const LambdaTraceHeaderKey string = "x-amzn-trace-id"
 // LambdaTaskRootKey is the key to get Lambda Task Root from environment variable.
 const LambdaTaskRootKey string = "LAMBDA_TASK_ROOT"
 
+// LambdaTraceIDEnvKey is the environment variable the Lambda runtime sets to
+// the trace header of the current invocation.
+const LambdaTraceIDEnvKey string = "_X_AMZN_TRACE_ID"
+
 // SDKInitializedFileFolder records the location of SDK initialized file.
 const SDKInitializedFileFolder string = "/tmp/.aws-xray"
 
@@ -45,6 +49,28 @@ func newFacadeSegment(ctx context.Context) (context.Context, *Segment) {
 	return BeginFacadeSegment(ctx, "facade", traceHeader)
 }
 
+// BeginLambdaSegment begins the subsegment named name of the current
+// invocation of a Lambda function, for custom runtimes which don't put the
+// trace header in the context. The trace header is read from the
+// _X_AMZN_TRACE_ID environment variable on every call.
+func BeginLambdaSegment(ctx context.Context, name string) (context.Context, *Segment) {
+	return BeginLambdaSegmentWithHeader(ctx, name, os.Getenv(LambdaTraceIDEnvKey))
+}
+
+// BeginLambdaSegmentWithHeader begins the subsegment named name of the Lambda
+// invocation with the given trace header, like BeginLambdaSegment. The
+// subsegment is emitted on its own, with the facade segment of the invocation
+// as parent. Without a trace header, a regular segment is begun instead.
+func BeginLambdaSegmentWithHeader(ctx context.Context, name string, traceHeader string) (context.Context, *Segment) {
+	if traceHeader == "" {
+		return BeginSegment(ctx, name)
+	}
+
+	ctx = context.WithValue(ctx, LambdaTraceHeaderKey, traceHeader)
+	ctx, _ = newFacadeSegment(ctx)
+	return BeginSubsegment(ctx, name)
+}
+
 func getLambdaTaskRoot() string {
 	return os.Getenv(LambdaTaskRootKey)
 }
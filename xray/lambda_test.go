@@ -2,8 +2,11 @@ package xray
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 
@@ -32,6 +35,68 @@ func TestLambdaSegmentEmit(t *testing.T) {
 	assert.Equal(t, "subsegment", seg.Type)
 }
 
+func TestBeginLambdaSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	defer os.Unsetenv(LambdaTraceIDEnvKey)
+
+	invocations := []struct {
+		traceHeader string
+		traceID     string
+		parentID    string
+	}{
+		{"Root=1-57ff426a-80c11c39b0c928905eb0828d;Parent=1234abcd1234abcd;Sampled=1", "1-57ff426a-80c11c39b0c928905eb0828d", "1234abcd1234abcd"},
+		{"Root=1-57ff426b-90c11c39b0c928905eb0828e;Parent=abcd1234abcd1234;Sampled=1", "1-57ff426b-90c11c39b0c928905eb0828e", "abcd1234abcd1234"},
+	}
+	for _, invocation := range invocations {
+		os.Setenv(LambdaTraceIDEnvKey, invocation.traceHeader)
+		ctx, subseg := BeginLambdaSegment(ctx, "handler")
+		_, child := BeginSubsegment(ctx, "child")
+		child.Close(nil)
+		subseg.Close(nil)
+
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "handler", seg.Name)
+		assert.Equal(t, "subsegment", seg.Type)
+		assert.Equal(t, invocation.traceID, seg.TraceID)
+		assert.Equal(t, invocation.parentID, seg.ParentID)
+		assert.Len(t, seg.Subsegments, 1)
+	}
+}
+
+func TestBeginLambdaSegmentNotSampled(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, subseg := BeginLambdaSegmentWithHeader(ctx, "handler", "Root=1-57ff426a-80c11c39b0c928905eb0828d;Parent=1234abcd1234abcd;Sampled=0")
+	assert.False(t, subseg.ParentSegment.Sampled)
+	assert.Equal(t, "0000000000000000", subseg.ID)
+	assert.Equal(t, "1-57ff426a-80c11c39b0c928905eb0828d", subseg.ParentSegment.TraceID)
+	subseg.Close(nil)
+
+	_, err := td.Recv()
+	assert.Error(t, err)
+}
+
+func TestBeginLambdaSegmentWithoutHeader(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	os.Unsetenv(LambdaTraceIDEnvKey)
+
+	_, seg := BeginLambdaSegment(ctx, "handler")
+	assert.Nil(t, seg.parent)
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "handler", emitted.Name)
+		assert.Empty(t, emitted.Type)
+	}
+}
+
 func TestLambdaMix(t *testing.T) {
 	// Setup
 	ctx, td := NewTestDaemon()
@@ -55,6 +120,53 @@ func TestLambdaMix(t *testing.T) {
 	testHelper(ctx4, t, td, false)
 }
 
+func TestLambdaStreamingSubtree(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	dss, err := NewDefaultStreamingStrategyWithMaxSubsegmentCount(2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	GetRecorder(ctx).StreamingStrategy = dss
+	ctx = context.WithValue(ctx, LambdaTraceHeaderKey, ExampleTraceHeader)
+
+	ctx, handler := BeginSubsegment(ctx, "handler")
+	ctx, batch := BeginSubsegment(ctx, "batch")
+	for i := 0; i < 5; i++ {
+		_, item := BeginSubsegment(ctx, fmt.Sprintf("item-%d", i))
+		item.Close(nil)
+		if i == 0 {
+			continue
+		}
+
+		// the completed items are streamed while the handler is in progress
+		streamed, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, fmt.Sprintf("item-%d", i-1), streamed.Name)
+		assert.Equal(t, "subsegment", streamed.Type)
+		assert.Equal(t, "1-57ff426a-80c11c39b0c928905eb0828d", streamed.TraceID)
+		assert.Equal(t, batch.ID, streamed.ParentID)
+	}
+	batch.Close(nil)
+	handler.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "handler", emitted.Name)
+	assert.Equal(t, "1234abcd1234abcd", emitted.ParentID)
+	if assert.Len(t, emitted.Subsegments, 1) {
+		var subseg *Segment
+		assert.NoError(t, json.Unmarshal(emitted.Subsegments[0], &subseg))
+		assert.Equal(t, "batch", subseg.Name)
+		assert.Len(t, subseg.Subsegments, 1)
+	}
+}
+
 /*
 	This helper function creates a request and validates the response using the context provided.
 */
@@ -0,0 +1,102 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+)
+
+// MemoryEmitter records emitted segments in memory, so that tests can assert
+// on them without a daemon or any socket. Like DefaultEmitter, it serializes
+// segments along with their subsegments, and streams subsegments separately
+// as the streaming strategy requires. Each serialized segment or streamed
+// subsegment is recorded as it would have been sent to the daemon.
+//
+// Use it as the Emitter of the Config passed to Configure or
+// ContextWithConfig.
+type MemoryEmitter struct {
+	mu       sync.Mutex
+	segments []*Segment
+
+	// closed and replaced whenever segments are recorded
+	emitted chan struct{}
+}
+
+// NewMemoryEmitter returns a MemoryEmitter without recorded segments.
+func NewMemoryEmitter() *MemoryEmitter {
+	return &MemoryEmitter{emitted: make(chan struct{})}
+}
+
+// RefreshEmitterWithAddress is a no-op, MemoryEmitter does not use the daemon.
+func (me *MemoryEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {}
+
+// RefreshEmitterWithUnixAddress is a no-op, MemoryEmitter does not use the daemon.
+func (me *MemoryEmitter) RefreshEmitterWithUnixAddress(raddr *net.UnixAddr) {}
+
+// Emit records the serialized segment and subsegments of seg.
+// seg has a write lock acquired by the caller.
+func (me *MemoryEmitter) Emit(seg *Segment) {
+	if seg == nil || !seg.ParentSegment.Sampled {
+		return
+	}
+
+	var recorded []*Segment
+	for _, p := range packSegments(seg, nil) {
+		var s *Segment
+		if err := json.Unmarshal(p, &s); err != nil {
+			seg.log().Errorf("JSON error while recording (Sub)Segment: %v", err)
+			continue
+		}
+		recorded = append(recorded, s)
+	}
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.segments = append(me.segments, recorded...)
+	close(me.emitted)
+	me.emitted = make(chan struct{})
+}
+
+// Segments returns the recorded segments and streamed subsegments, in the
+// order they were emitted. Subsegments which weren't streamed are found in
+// the Subsegments of their parent, as JSON.
+func (me *MemoryEmitter) Segments() []*Segment {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return append([]*Segment(nil), me.segments...)
+}
+
+// WaitForSegments waits until at least n segments or streamed subsegments
+// are recorded, or until ctx is done.
+func (me *MemoryEmitter) WaitForSegments(ctx context.Context, n int) error {
+	for {
+		me.mu.Lock()
+		count, emitted := len(me.segments), me.emitted
+		me.mu.Unlock()
+
+		if count >= n {
+			return nil
+		}
+		select {
+		case <-emitted:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Reset discards the recorded segments.
+func (me *MemoryEmitter) Reset() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.segments = nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/stretchr/testify/assert"
+)
+
+// newMemoryEmitterContext returns a context sampling every segment and
+// emitting them to a new MemoryEmitter.
+func newMemoryEmitterContext(t *testing.T, cfg Config) (context.Context, *MemoryEmitter) {
+	me := NewMemoryEmitter()
+	cfg.Emitter = me
+	cfg.SamplingStrategy = sampling.NewFuncStrategy(func(*sampling.Request) bool { return true })
+	ctx, err := ContextWithConfig(context.Background(), cfg)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return ctx, me
+}
+
+func TestMemoryEmitterHandler(t *testing.T) {
+	ctx, me := newMemoryEmitterContext(t, Config{})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = Capture(r.Context(), "lookup", func(context.Context) error {
+			return nil
+		})
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/orders", nil).WithContext(ctx)
+	Handler(NewFixedSegmentNamer("orders"), handler).ServeHTTP(httptest.NewRecorder(), req)
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !assert.NoError(t, me.WaitForSegments(waitCtx, 1)) {
+		return
+	}
+
+	segments := me.Segments()
+	if !assert.Len(t, segments, 1) {
+		return
+	}
+	seg := segments[0]
+	assert.Equal(t, "orders", seg.Name)
+	assert.Equal(t, http.MethodPost, seg.HTTP.Request.Method)
+	assert.Equal(t, "http://example.com/orders", seg.HTTP.Request.URL)
+	assert.Equal(t, http.StatusAccepted, seg.HTTP.Response.Status)
+
+	var subseg *Segment
+	if assert.Len(t, seg.Subsegments, 1) && assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &subseg)) {
+		assert.Equal(t, "lookup", subseg.Name)
+		assert.Equal(t, seg.ID, subseg.ParentID)
+	}
+
+	me.Reset()
+	assert.Empty(t, me.Segments())
+}
+
+func TestMemoryEmitterStreamedSubsegments(t *testing.T) {
+	ss, err := NewDefaultStreamingStrategyWithMaxSubsegmentCount(1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx, me := newMemoryEmitterContext(t, Config{StreamingStrategy: ss})
+
+	ctx, root := BeginSegment(ctx, "root")
+	for _, name := range []string{"first", "second"} {
+		_, subseg := BeginSubsegment(ctx, name)
+		subseg.Close(nil)
+	}
+	root.Close(nil)
+
+	var names []string
+	for _, seg := range me.Segments() {
+		names = append(names, seg.Name)
+		assert.Equal(t, root.TraceID, seg.TraceID)
+	}
+	assert.Contains(t, names, "root")
+	assert.Contains(t, names, "first")
+	assert.Greater(t, len(names), 1)
+}
+
+func TestMemoryEmitterConcurrentEmit(t *testing.T) {
+	ctx, me := newMemoryEmitterContext(t, Config{})
+
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, seg := BeginSegment(ctx, "concurrent")
+			seg.Close(nil)
+		}()
+	}
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, me.WaitForSegments(waitCtx, n))
+	wg.Wait()
+	assert.Len(t, me.Segments(), n)
+}
+
+func TestMemoryEmitterWaitForSegmentsTimeout(t *testing.T) {
+	me := NewMemoryEmitter()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, me.WaitForSegments(ctx, 1))
+}
@@ -0,0 +1,54 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+)
+
+// InjectTraceHeader returns the name and value of the trace header passing
+// the trace context of the segment or subsegment in ctx to the consumers of
+// a message, e.g. as an SNS message attribute or a Kafka record header.
+// The SQS AWSTraceHeader system attribute takes the same value. value is
+// empty when ctx has no segment.
+func InjectTraceHeader(ctx context.Context) (key, value string) {
+	key = http.CanonicalHeaderKey(TraceIDHeaderKey)
+	seg := GetSegment(ctx)
+	if seg == nil {
+		return key, ""
+	}
+	return key, seg.DownstreamHeader().String()
+}
+
+// BeginConsumerSegment begins a segment recording the processing of a
+// message whose producer passed traceHeaderValue, as returned by
+// InjectTraceHeader. The segment continues the trace of the producer, its
+// parent being the producer segment, and follows its sampling decision, the
+// sampling strategy deciding when the header carries none. An empty or
+// malformed traceHeaderValue begins a new trace.
+func BeginConsumerSegment(ctx context.Context, name string, traceHeaderValue string) (context.Context, *Segment) {
+	h := header.FromString(traceHeaderValue)
+	if !validTraceHeader(h) {
+		return BeginSegmentWithSampling(ctx, name, nil, nil)
+	}
+	return NewSegmentFromHeader(ctx, name, nil, h)
+}
+
+// validTraceHeader reports whether h carries an X-Ray trace ID, and a
+// segment ID as parent, if any.
+func validTraceHeader(h *header.Header) bool {
+	id := h.TraceID
+	if len(id) != 35 || id[:2] != "1-" || id[10] != '-' || !isLowerHex(id[2:10]) || !isLowerHex(id[11:]) {
+		return false
+	}
+	return h.ParentID == "" || len(h.ParentID) == 16 && isLowerHex(h.ParentID)
+}
@@ -0,0 +1,102 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumerSegmentRoundTrip(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, producer := BeginSegment(ctx, "producer")
+	subCtx, publish := BeginSubsegment(ctx, "SQS.SendMessage")
+	key, value := InjectTraceHeader(subCtx)
+	publish.Close(nil)
+	producer.Close(nil)
+
+	assert.Equal(t, "X-Amzn-Trace-Id", key)
+	_, consumer := BeginConsumerSegment(ctx, "consumer", value)
+	consumer.Close(nil)
+
+	emitted := map[string]*Segment{}
+	for i := 0; i < 2; i++ {
+		seg, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		emitted[seg.Name] = seg
+	}
+	if assert.Contains(t, emitted, "consumer") && assert.Contains(t, emitted, "producer") {
+		assert.Equal(t, emitted["producer"].TraceID, emitted["consumer"].TraceID)
+		assert.Equal(t, publish.ID, emitted["consumer"].ParentID)
+	}
+	assert.True(t, consumer.RequestWasTraced)
+}
+
+func TestInjectTraceHeaderWithoutSegment(t *testing.T) {
+	key, value := InjectTraceHeader(context.Background())
+	assert.Equal(t, "X-Amzn-Trace-Id", key)
+	assert.Empty(t, value)
+}
+
+func TestBeginConsumerSegmentMalformedHeader(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	for _, value := range []string{
+		"",
+		"garbage",
+		"Root=1-xyz;Parent=53995c3f42cd8ad8;Sampled=1",
+		"Root=1-5759e988-bd862e3fe1be46a99427279;Parent=53995c3f42cd8ad8",
+		"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=short;Sampled=1",
+		"Root=2-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8",
+	} {
+		_, seg := BeginConsumerSegment(ctx, "consumer", value)
+		seg.Close(nil)
+
+		emitted, err := td.Recv()
+		if !assert.NoError(t, err, value) {
+			return
+		}
+		assert.NotEqual(t, "1-5759e988-bd862e3fe1be46a994272793", emitted.TraceID, value)
+		assert.Empty(t, emitted.ParentID, value)
+		assert.False(t, seg.RequestWasTraced, value)
+	}
+}
+
+func TestBeginConsumerSegmentSampling(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx = withSamplingConfig(ctx, func(cfg *Config) {
+		cfg.SamplingStrategy = sampling.NewFuncStrategy(func(*sampling.Request) bool { return false })
+	})
+
+	tests := []struct {
+		value   string
+		sampled bool
+	}{
+		{"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1", true},
+		{"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0", false},
+		{"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8", false},
+		{"Root=1-5759e988-bd862e3fe1be46a994272793", false},
+	}
+
+	for _, tt := range tests {
+		_, seg := BeginConsumerSegment(ctx, "consumer", tt.value)
+		assert.Equal(t, tt.sampled, seg.Sampled, tt.value)
+		assert.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", seg.TraceID, tt.value)
+		seg.Close(nil)
+	}
+}
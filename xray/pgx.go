@@ -0,0 +1,176 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// pgxDriverVersion is recorded as the driver version of pgx subsegments.
+const pgxDriverVersion = "github.com/jackc/pgx/v5"
+
+var (
+	_ pgx.QueryTracer   = (*PgxTracer)(nil)
+	_ pgx.BatchTracer   = (*PgxTracer)(nil)
+	_ pgx.ConnectTracer = (*PgxTracer)(nil)
+)
+
+type pgxSubsegmentKey struct{}
+
+// PgxTracer records subsegments for the queries, batches and connections of
+// jackc/pgx connections and pools, the way SQLContext does for database/sql.
+// Set it as the Tracer of the pgx.ConnConfig, for a pool:
+//
+//	cfg, err := pgxpool.ParseConfig(dsn)
+//	cfg.ConnConfig.Tracer = xray.NewPgxTracer()
+//	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+type PgxTracer struct{}
+
+// NewPgxTracer returns a PgxTracer.
+func NewPgxTracer() *PgxTracer {
+	return &PgxTracer{}
+}
+
+// TraceQueryStart begins a subsegment for the query.
+func (t *PgxTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return beginPgxSubsegment(ctx, pgxConnAttributes(conn), data.SQL)
+}
+
+// TraceQueryEnd closes the subsegment of the query.
+func (t *PgxTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	endPgxSubsegment(ctx, data.Err)
+}
+
+// TraceBatchStart begins a subsegment for the batch. The queries of the batch
+// aren't exported by pgx.Batch, they're recorded as they run.
+func (t *PgxTracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	return beginPgxSubsegment(ctx, pgxConnAttributes(conn), "")
+}
+
+// TraceBatchQuery appends the query to the ones of the batch subsegment. The
+// first error of the batch is recorded when it ends.
+func (t *PgxTracer) TraceBatchQuery(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchQueryData) {
+	seg, ok := ctx.Value(pgxSubsegmentKey{}).(*Segment)
+	if !ok {
+		return
+	}
+	seg.Lock()
+	if q := seg.GetSQL().SanitizedQuery; q != "" {
+		seg.GetSQL().SanitizedQuery = q + ";\n" + data.SQL
+	} else {
+		seg.GetSQL().SanitizedQuery = data.SQL
+	}
+	seg.Unlock()
+}
+
+// TraceBatchEnd closes the subsegment of the batch.
+func (t *PgxTracer) TraceBatchEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchEndData) {
+	endPgxSubsegment(ctx, data.Err)
+}
+
+// TraceConnectStart begins a subsegment for establishing the connection.
+// Connections a pool establishes in the background, outside of any segment,
+// are not recorded.
+func (t *PgxTracer) TraceConnectStart(ctx context.Context, data pgx.TraceConnectStartData) context.Context {
+	if GetSegment(ctx) == nil {
+		return ctx
+	}
+	return beginPgxSubsegment(ctx, newPgxAttributes(data.ConnConfig, ""), "")
+}
+
+// TraceConnectEnd closes the subsegment of the connection.
+func (t *PgxTracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
+	endPgxSubsegment(ctx, data.Err)
+}
+
+// pgxAttributes are the attributes of a pgx connection recorded on its
+// subsegments.
+type pgxAttributes struct {
+	url             string
+	host            string
+	user            string
+	dbname          string
+	databaseVersion string
+}
+
+func pgxConnAttributes(conn *pgx.Conn) pgxAttributes {
+	if conn == nil {
+		return pgxAttributes{}
+	}
+	var version string
+	if pgConn := conn.PgConn(); pgConn != nil {
+		version = pgConn.ParameterStatus("server_version")
+	}
+	return newPgxAttributes(conn.Config(), version)
+}
+
+// newPgxAttributes builds the attributes of a connection from its config,
+// leaving out the password.
+func newPgxAttributes(cfg *pgx.ConnConfig, version string) pgxAttributes {
+	if cfg == nil {
+		return pgxAttributes{databaseVersion: version}
+	}
+
+	u := url.URL{Scheme: "postgres", Host: cfg.Host, Path: "/" + cfg.Database}
+	if strings.HasPrefix(cfg.Host, "/") {
+		// unix socket directories go in the query, as libpq does
+		u.Host = ""
+		u.RawQuery = url.Values{"host": {cfg.Host}}.Encode()
+	} else if cfg.Port != 0 {
+		u.Host = net.JoinHostPort(cfg.Host, strconv.Itoa(int(cfg.Port)))
+	}
+	if cfg.User != "" {
+		u.User = url.User(cfg.User)
+	}
+
+	attr := pgxAttributes{
+		url:             u.String(),
+		user:            cfg.User,
+		dbname:          cfg.Database,
+		databaseVersion: version,
+	}
+	if cfg.Host != "" {
+		attr.host = "@" + cfg.Host
+	}
+	return attr
+}
+
+// beginPgxSubsegment begins a subsegment named after the database, like
+// SQLContext does. When ctx holds no segment, the ContextMissingStrategy
+// handles it and ctx is returned as is.
+func beginPgxSubsegment(ctx context.Context, attr pgxAttributes, query string) context.Context {
+	c, seg := BeginSubsegment(ctx, attr.dbname+attr.host)
+	if seg == nil {
+		return ctx
+	}
+
+	seg.Lock()
+	seg.Namespace = "remote"
+	seg.GetSQL().URL = attr.url
+	seg.GetSQL().DatabaseType = "Postgres"
+	seg.GetSQL().DatabaseVersion = attr.databaseVersion
+	seg.GetSQL().DriverVersion = pgxDriverVersion
+	seg.GetSQL().User = attr.user
+	seg.GetSQL().SanitizedQuery = query
+	seg.Unlock()
+
+	return context.WithValue(c, pgxSubsegmentKey{}, seg)
+}
+
+func endPgxSubsegment(ctx context.Context, err error) {
+	if seg, ok := ctx.Value(pgxSubsegmentKey{}).(*Segment); ok {
+		seg.Close(err)
+	}
+}
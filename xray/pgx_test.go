@@ -0,0 +1,140 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingContextMissingStrategy struct {
+	count int
+}
+
+func (s *countingContextMissingStrategy) ContextMissing(v interface{}) {
+	s.count++
+}
+
+func testPgxConnConfig() *pgx.ConnConfig {
+	return &pgx.ConnConfig{Config: pgconn.Config{
+		Host:     "db.example.com",
+		Port:     5432,
+		Database: "orders",
+		User:     "app",
+		Password: "secret",
+	}}
+}
+
+// recvPgxSubsegment closes root and returns its only subsegment.
+func recvPgxSubsegment(t *testing.T, td *TestDaemon, root *Segment) *Segment {
+	root.Close(nil)
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) || !assert.Len(t, seg.Subsegments, 1) {
+		t.FailNow()
+	}
+	var sub Segment
+	assert.NoError(t, json.Unmarshal(seg.Subsegments[0], &sub))
+	return &sub
+}
+
+func TestNewPgxAttributes(t *testing.T) {
+	attr := newPgxAttributes(testPgxConnConfig(), "15.4")
+
+	assert.Equal(t, "postgres://app@db.example.com:5432/orders", attr.url)
+	assert.NotContains(t, attr.url, "secret")
+	assert.Equal(t, "@db.example.com", attr.host)
+	assert.Equal(t, "app", attr.user)
+	assert.Equal(t, "orders", attr.dbname)
+	assert.Equal(t, "15.4", attr.databaseVersion)
+
+	socket := newPgxAttributes(&pgx.ConnConfig{Config: pgconn.Config{Host: "/var/run/postgresql", Port: 5432, Database: "orders"}}, "")
+	assert.Equal(t, "postgres:///orders?host=%2Fvar%2Frun%2Fpostgresql", socket.url)
+	assert.Equal(t, "@/var/run/postgresql", socket.host)
+}
+
+func TestPgxTracerQuery(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "test")
+
+	tracer := NewPgxTracer()
+	qctx := tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT * FROM orders WHERE id = $1", Args: []any{1}})
+	tracer.TraceQueryEnd(qctx, nil, pgx.TraceQueryEndData{Err: errors.New("boom")})
+
+	sub := recvPgxSubsegment(t, td, root)
+	assert.Equal(t, "remote", sub.Namespace)
+	assert.True(t, sub.Fault)
+	assert.Equal(t, "SELECT * FROM orders WHERE id = $1", sub.SQL.SanitizedQuery)
+	assert.Equal(t, "Postgres", sub.SQL.DatabaseType)
+	assert.Equal(t, pgxDriverVersion, sub.SQL.DriverVersion)
+}
+
+func TestPgxTracerBatch(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "test")
+
+	batch := &pgx.Batch{}
+	batch.Queue("INSERT INTO orders VALUES ($1)", 1)
+	batch.Queue("SELECT count(*) FROM orders")
+
+	tracer := NewPgxTracer()
+	bctx := tracer.TraceBatchStart(ctx, nil, pgx.TraceBatchStartData{Batch: batch})
+	tracer.TraceBatchQuery(bctx, nil, pgx.TraceBatchQueryData{SQL: "INSERT INTO orders VALUES ($1)"})
+	tracer.TraceBatchQuery(bctx, nil, pgx.TraceBatchQueryData{SQL: "SELECT count(*) FROM orders"})
+	tracer.TraceBatchEnd(bctx, nil, pgx.TraceBatchEndData{})
+
+	sub := recvPgxSubsegment(t, td, root)
+	assert.False(t, sub.Fault)
+	assert.Equal(t, "INSERT INTO orders VALUES ($1);\nSELECT count(*) FROM orders", sub.SQL.SanitizedQuery)
+}
+
+func TestPgxTracerConnect(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "test")
+
+	tracer := NewPgxTracer()
+	cctx := tracer.TraceConnectStart(ctx, pgx.TraceConnectStartData{ConnConfig: testPgxConnConfig()})
+	tracer.TraceConnectEnd(cctx, pgx.TraceConnectEndData{})
+
+	sub := recvPgxSubsegment(t, td, root)
+	assert.Equal(t, "orders@db.example.com", sub.Name)
+	assert.Equal(t, "postgres://app@db.example.com:5432/orders", sub.SQL.URL)
+	assert.Equal(t, "app", sub.SQL.User)
+}
+
+func TestPgxTracerMissingSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	strategy := &countingContextMissingStrategy{}
+	cfg := *GetRecorder(ctx)
+	cfg.ContextMissingStrategy = strategy
+	ctx = context.WithValue(ctx, RecorderContextKey{}, &cfg)
+
+	tracer := NewPgxTracer()
+	assert.NotPanics(t, func() {
+		qctx := tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT 1"})
+		assert.Equal(t, ctx, qctx)
+		tracer.TraceQueryEnd(qctx, nil, pgx.TraceQueryEndData{})
+	})
+	assert.Equal(t, 1, strategy.count)
+
+	// background pool connections are not reported as missing segments
+	cctx := tracer.TraceConnectStart(ctx, pgx.TraceConnectStartData{ConnConfig: testPgxConnConfig()})
+	tracer.TraceConnectEnd(cctx, pgx.TraceConnectEndData{})
+	assert.Equal(t, 1, strategy.count)
+}
@@ -0,0 +1,72 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"os"
+	"strings"
+
+	"github.com/aws/aws-xray-sdk-go/internal/plugins"
+)
+
+// OriginEnvVar is the environment variable overriding the origin of segments
+// unless Config.Origin is set, e.g. for hosts the plugins detect nothing on.
+const OriginEnvVar = "AWS_XRAY_ORIGIN"
+
+// Origins of segments, for Config.Origin. Any other origin can be set too.
+const (
+	OriginEC2Instance      = "AWS::EC2::Instance"
+	OriginECSContainer     = "AWS::ECS::Container"
+	OriginECSFargate       = "AWS::ECS::Fargate"
+	OriginEKSContainer     = "AWS::EKS::Container"
+	OriginElasticBeanstalk = "AWS::ElasticBeanstalk::Environment"
+)
+
+// PluginMetadata records information about the AWS infrastructure hosting
+// the traced application, see Config.PluginMetadata.
+type PluginMetadata = plugins.PluginMetadata
+
+// EC2Metadata records the EC2 instance ID and availability zone.
+type EC2Metadata = plugins.EC2Metadata
+
+// ECSMetadata records the ECS container.
+type ECSMetadata = plugins.ECSMetadata
+
+// BeanstalkMetadata records the Elastic Beanstalk environment name, version
+// label and deployment ID.
+type BeanstalkMetadata = plugins.BeanstalkMetadata
+
+// LogGroupMetadata records a CloudWatch Logs log group the application
+// writes to.
+type LogGroupMetadata = plugins.LogGroupMetadata
+
+// pluginMetadata returns the plugin metadata of the segment: the metadata
+// set in its configuration, or else the metadata detected by the plugins
+// unless detection is disabled. The plugins run on the first call.
+func (seg *Segment) pluginMetadata() *PluginMetadata {
+	cfg := seg.ParentSegment.GetConfiguration()
+	if cfg.PluginMetadata != nil {
+		return cfg.PluginMetadata
+	}
+	if cfg.NoPluginMetadata {
+		return nil
+	}
+	return plugins.Detect()
+}
+
+// originOverride returns the origin the segment is recorded and sampled with
+// instead of the detected one: the origin set in its configuration, or else
+// in the AWS_XRAY_ORIGIN environment variable. It returns "" if neither is
+// set.
+func (seg *Segment) originOverride() string {
+	if origin := seg.ParentSegment.GetConfiguration().Origin; origin != "" {
+		return origin
+	}
+	return strings.TrimSpace(os.Getenv(OriginEnvVar))
+}
@@ -0,0 +1,156 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/internal/plugins"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/stretchr/testify/assert"
+)
+
+// registerDetector registers a plugin detector recording whether it ran,
+// and runs it, if it didn't, once the test is over.
+func registerDetector(t *testing.T) *bool {
+	detected := new(bool)
+	plugins.Register(func(*plugins.PluginMetadata) { *detected = true })
+	t.Cleanup(func() { plugins.Detect() })
+	return detected
+}
+
+func TestBeginSegmentExplicitPluginMetadata(t *testing.T) {
+	detected := registerDetector(t)
+	ctx, me := newMemoryEmitterContext(t, Config{
+		PluginMetadata: &PluginMetadata{
+			EC2Metadata: &EC2Metadata{InstanceID: "i-032fe2d42797fb9a1", AvailabilityZone: "us-west-2a"},
+			Origin:      OriginEC2Instance,
+		},
+	})
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	assert.False(t, *detected, "plugins don't run with explicit metadata")
+	if assert.Len(t, me.Segments(), 1) {
+		emitted := me.Segments()[0]
+		assert.Equal(t, OriginEC2Instance, emitted.Origin)
+		assert.Equal(t, map[string]interface{}{
+			"instance_id":       "i-032fe2d42797fb9a1",
+			"availability_zone": "us-west-2a",
+		}, emitted.AWS[plugins.EC2ServiceName])
+	}
+}
+
+func TestBeginSegmentOrigin(t *testing.T) {
+	var serviceType string
+	ctx, err := ContextWithConfig(context.Background(), Config{
+		Emitter: NewMemoryEmitter(),
+		SamplingStrategy: sampling.NewFuncStrategy(func(r *sampling.Request) bool {
+			serviceType = r.ServiceType
+			return true
+		}),
+		PluginMetadata: &PluginMetadata{
+			ECSMetadata: &ECSMetadata{ContainerName: "host"},
+			Origin:      OriginECSContainer,
+		},
+		Origin: OriginECSFargate,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	assert.Equal(t, OriginECSFargate, seg.Origin)
+	assert.Equal(t, OriginECSFargate, serviceType)
+	assert.Equal(t, &ECSMetadata{ContainerName: "host"}, seg.AWS[plugins.ECSServiceName])
+}
+
+func TestBeginSegmentOriginEnv(t *testing.T) {
+	os.Setenv(OriginEnvVar, "Kubernetes::Pod")
+	defer os.Unsetenv(OriginEnvVar)
+
+	for _, tc := range []struct {
+		name   string
+		config Config
+		want   string
+	}{
+		{"env", Config{}, "Kubernetes::Pod"},
+		{"env over plugins", Config{PluginMetadata: &PluginMetadata{Origin: OriginEC2Instance}}, "Kubernetes::Pod"},
+		{"config over env", Config{Origin: OriginEKSContainer}, OriginEKSContainer},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var serviceType string
+			tc.config.Emitter = NewMemoryEmitter()
+			tc.config.SamplingStrategy = sampling.NewFuncStrategy(func(r *sampling.Request) bool {
+				serviceType = r.ServiceType
+				return true
+			})
+			ctx, err := ContextWithConfig(context.Background(), tc.config)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			_, seg := BeginSegment(ctx, "test")
+			seg.Close(nil)
+
+			assert.Equal(t, tc.want, seg.Origin)
+			assert.Equal(t, tc.want, serviceType)
+		})
+	}
+}
+
+func TestBeginSegmentNoPluginMetadata(t *testing.T) {
+	detected := registerDetector(t)
+	ctx, _ := newMemoryEmitterContext(t, Config{NoPluginMetadata: true})
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	assert.False(t, *detected)
+	assert.Empty(t, seg.Origin)
+}
+
+func TestBeginSegmentNoPluginMetadataEnv(t *testing.T) {
+	os.Setenv(plugins.NoPluginMetadataEnvVar, "true")
+	defer os.Unsetenv(plugins.NoPluginMetadataEnvVar)
+	detected := registerDetector(t)
+	ctx, _ := newMemoryEmitterContext(t, Config{})
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	assert.False(t, *detected)
+}
+
+func TestBeginSegmentDetectsPluginMetadata(t *testing.T) {
+	detected := registerDetector(t)
+	ctx, _ := newMemoryEmitterContext(t, Config{})
+	assert.False(t, *detected, "plugins run once a segment begins")
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	assert.True(t, *detected)
+}
+
+func TestConfigurePluginMetadataTimeout(t *testing.T) {
+	defer plugins.SetMetadataTimeout(0)
+
+	assert.NoError(t, Configure(Config{PluginMetadataTimeout: 50 * time.Millisecond}))
+	assert.Equal(t, 50*time.Millisecond, plugins.MetadataTimeout())
+
+	plugins.SetMetadataTimeout(0)
+	assert.Equal(t, plugins.DefaultMetadataTimeout, plugins.MetadataTimeout())
+}
@@ -0,0 +1,30 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+// PreEmitProcessor provides an interface for post-processing a root segment
+// after it closes and before it is handed to the Emitter. Subsegments sent on
+// their own, that is streamed with CloseAndStream or belonging to a Lambda
+// facade segment, are processed too; their Type is "subsegment".
+type PreEmitProcessor interface {
+	// Process is called with a write lock on seg acquired by the caller.
+	Process(seg *Segment)
+}
+
+// runPreEmitProcessors applies the configured processors to seg.
+// The caller of runPreEmitProcessors should have write lock on seg instance.
+func (seg *Segment) runPreEmitProcessors() {
+	cfg := seg.ParentSegment.Configuration
+	if cfg == nil {
+		return
+	}
+	for _, p := range cfg.PreEmitProcessors {
+		p.Process(seg)
+	}
+}
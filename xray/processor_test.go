@@ -0,0 +1,102 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// deploymentProcessor annotates segments with a deployment ID and scrubs
+// their "secret" metadata.
+type deploymentProcessor struct{}
+
+func (deploymentProcessor) Process(seg *Segment) {
+	if seg.Annotations == nil {
+		seg.Annotations = map[string]interface{}{}
+	}
+	seg.Annotations["deployment_id"] = "d-123"
+	delete(seg.Metadata["default"], "secret")
+}
+
+func contextWithPreEmitProcessors(ctx context.Context, processors ...PreEmitProcessor) context.Context {
+	cfg := *GetRecorder(ctx)
+	cfg.PreEmitProcessors = processors
+	return context.WithValue(ctx, RecorderContextKey{}, &cfg)
+}
+
+func TestPreEmitProcessorGlobal(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	assert.NoError(t, Configure(Config{PreEmitProcessors: []PreEmitProcessor{deploymentProcessor{}}}))
+	defer func() {
+		globalCfg.Lock()
+		globalCfg.preEmitProcessors = nil
+		globalCfg.Unlock()
+	}()
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.AddMetadata("secret", "s3cr3t")
+	seg.AddMetadata("kept", "value")
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "d-123", emitted.Annotations["deployment_id"])
+	assert.Equal(t, map[string]interface{}{"kept": "value"}, emitted.Metadata["default"])
+}
+
+func TestPreEmitProcessorStreamedSubsegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx = contextWithPreEmitProcessors(ctx, deploymentProcessor{})
+
+	ctx, root := BeginSegment(ctx, "test")
+	_, sub := BeginSubsegment(ctx, "streamed")
+	sub.AddMetadata("secret", "s3cr3t")
+	sub.CloseAndStream(nil)
+	root.Close(nil)
+
+	for _, name := range []string{"streamed", "test"} {
+		emitted, err := td.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, name, emitted.Name)
+		assert.Equal(t, "d-123", emitted.Annotations["deployment_id"], name)
+		assert.Empty(t, emitted.Metadata["default"], name)
+	}
+}
+
+func TestPreEmitProcessorContextOverridesGlobal(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	assert.NoError(t, Configure(Config{PreEmitProcessors: []PreEmitProcessor{deploymentProcessor{}}}))
+	defer func() {
+		globalCfg.Lock()
+		globalCfg.preEmitProcessors = nil
+		globalCfg.Unlock()
+	}()
+	ctx = contextWithPreEmitProcessors(ctx, []PreEmitProcessor{}...)
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Nil(t, emitted.Annotations["deployment_id"])
+}
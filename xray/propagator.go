@@ -0,0 +1,159 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+)
+
+// TraceParentHeaderKey is the header name of W3C trace context.
+const TraceParentHeaderKey = "traceparent"
+
+// Propagator reads the trace header of incoming requests from their headers,
+// and writes the trace header of outgoing requests to their headers. gRPC
+// metadata is propagated as headers too.
+//
+// The propagators of Config.Propagators are used by the HTTP handlers, the
+// HTTP client, and the gRPC and Connect interceptors. By default, only the
+// X-Amzn-Trace-Id header is propagated, see XRayPropagator.
+type Propagator interface {
+	// Extract returns the trace header carried by h, or nil if h carries
+	// none.
+	Extract(h http.Header) *header.Header
+
+	// Inject writes th to h.
+	Inject(h http.Header, th *header.Header)
+}
+
+// XRayPropagator propagates the X-Amzn-Trace-Id header.
+type XRayPropagator struct{}
+
+// Extract parses the X-Amzn-Trace-Id header of h.
+func (XRayPropagator) Extract(h http.Header) *header.Header {
+	value := h.Get(TraceIDHeaderKey)
+	if value == "" {
+		return nil
+	}
+	return header.FromString(value)
+}
+
+// Inject sets the X-Amzn-Trace-Id header of h.
+func (XRayPropagator) Inject(h http.Header, th *header.Header) {
+	h.Set(TraceIDHeaderKey, th.String())
+}
+
+// W3CPropagator propagates the W3C traceparent header. W3C trace IDs are
+// converted to X-Ray trace IDs by splitting their first 8 hex digits, which
+// X-Ray expects to be the start time of the trace in epoch seconds. Trace IDs
+// generated otherwise, e.g. by OpenTelemetry without the X-Ray ID generator,
+// may be rejected by X-Ray. The sampled flag maps to Sampled=1 or Sampled=0.
+type W3CPropagator struct{}
+
+// Extract parses the traceparent header of h. Invalid headers are ignored.
+func (W3CPropagator) Extract(h http.Header) *header.Header {
+	value := strings.TrimSpace(h.Get(TraceParentHeaderKey))
+	// version-traceid-parentid-flags, with fields after flags in later versions
+	if len(value) < 55 || (len(value) > 55 && (value[:2] == "00" || value[55] != '-')) {
+		return nil
+	}
+	version, traceID, parentID, flags := value[:2], value[3:35], value[36:52], value[53:55]
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' ||
+		!isLowerHex(version) || version == "ff" ||
+		!isLowerHex(traceID) || isZeros(traceID) ||
+		!isLowerHex(parentID) || isZeros(parentID) ||
+		!isLowerHex(flags) {
+		return nil
+	}
+
+	th := &header.Header{
+		TraceID:          "1-" + traceID[:8] + "-" + traceID[8:],
+		ParentID:         parentID,
+		SamplingDecision: header.NotSampled,
+		AdditionalData:   make(map[string]string),
+	}
+	if flags[1]&1 == 1 { // '1', '3', ..., 'f' have the sampled bit set
+		th.SamplingDecision = header.Sampled
+	}
+	return th
+}
+
+// Inject sets the traceparent header of h, if th has an X-Ray trace ID and a
+// parent ID.
+func (W3CPropagator) Inject(h http.Header, th *header.Header) {
+	traceID, parentID := th.TraceID, th.ParentID
+	if len(traceID) != 35 || traceID[:2] != "1-" || traceID[10] != '-' || len(parentID) != 16 {
+		return
+	}
+	flags := "00"
+	if th.SamplingDecision == header.Sampled {
+		flags = "01"
+	}
+	h.Set(TraceParentHeaderKey, "00-"+traceID[2:10]+traceID[11:]+"-"+parentID+"-"+flags)
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isZeros(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
+
+var defaultPropagators = []Propagator{XRayPropagator{}}
+
+// propagatorsOf returns the propagators of cfg, or the global ones if cfg is
+// nil or has none.
+func propagatorsOf(cfg *Config) []Propagator {
+	if cfg != nil && cfg.Propagators != nil {
+		return cfg.Propagators
+	}
+	if p := globalCfg.Propagators(); p != nil {
+		return p
+	}
+	return defaultPropagators
+}
+
+// extractTraceHeader returns the trace header of an incoming request with
+// headers h, read by the first of the propagators of cfg carrying one. If
+// none does, it is read from the first of the alternate header names keys
+// carrying an X-Amzn-Trace-Id value.
+func extractTraceHeader(cfg *Config, h http.Header, keys ...string) *header.Header {
+	for _, p := range propagatorsOf(cfg) {
+		if th := p.Extract(h); th != nil {
+			return th
+		}
+	}
+	for _, key := range keys {
+		if value := h.Get(key); value != "" {
+			return header.FromString(value)
+		}
+	}
+	return header.FromString("")
+}
+
+// injectTraceHeader writes the downstream header of seg to the headers h of an
+// outgoing request, with each of the propagators of its segment.
+func injectTraceHeader(seg *Segment, h http.Header) {
+	th := seg.DownstreamHeader()
+	var cfg *Config
+	if seg.ParentSegment != nil {
+		cfg = seg.ParentSegment.GetConfiguration()
+	}
+	for _, p := range propagatorsOf(cfg) {
+		p.Inject(h, th)
+	}
+}
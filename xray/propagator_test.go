@@ -0,0 +1,178 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestW3CPropagatorExtract(t *testing.T) {
+	tests := []struct {
+		traceparent string
+		want        *header.Header
+	}{
+		{"00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01", &header.Header{TraceID: "1-5759e988-bd862e3fe1be46a994272793", ParentID: "53995c3f42cd8ad8", SamplingDecision: header.Sampled}},
+		{"00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-00", &header.Header{TraceID: "1-5759e988-bd862e3fe1be46a994272793", ParentID: "53995c3f42cd8ad8", SamplingDecision: header.NotSampled}},
+		{"00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-03", &header.Header{TraceID: "1-5759e988-bd862e3fe1be46a994272793", ParentID: "53995c3f42cd8ad8", SamplingDecision: header.Sampled}},
+		{"01-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01-future", &header.Header{TraceID: "1-5759e988-bd862e3fe1be46a994272793", ParentID: "53995c3f42cd8ad8", SamplingDecision: header.Sampled}},
+		{"", nil},
+		{"00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01-extra", nil},
+		{"ff-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01", nil},
+		{"00-00000000000000000000000000000000-53995c3f42cd8ad8-01", nil},
+		{"00-5759e988bd862e3fe1be46a994272793-0000000000000000-01", nil},
+		{"00-5759E988BD862E3FE1BE46A994272793-53995c3f42cd8ad8-01", nil},
+		{"00_5759e988bd862e3fe1be46a994272793_53995c3f42cd8ad8_01", nil},
+	}
+
+	for _, tt := range tests {
+		h := http.Header{}
+		h.Set(TraceParentHeaderKey, tt.traceparent)
+		got := W3CPropagator{}.Extract(h)
+		if tt.want == nil {
+			assert.Nil(t, got, tt.traceparent)
+			continue
+		}
+		if assert.NotNil(t, got, tt.traceparent) {
+			assert.Equal(t, tt.want.TraceID, got.TraceID)
+			assert.Equal(t, tt.want.ParentID, got.ParentID)
+			assert.Equal(t, tt.want.SamplingDecision, got.SamplingDecision)
+		}
+	}
+}
+
+func TestW3CPropagatorInject(t *testing.T) {
+	h := http.Header{}
+	W3CPropagator{}.Inject(h, &header.Header{TraceID: "1-5759e988-bd862e3fe1be46a994272793", ParentID: "53995c3f42cd8ad8", SamplingDecision: header.Sampled})
+	assert.Equal(t, "00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01", h.Get(TraceParentHeaderKey))
+
+	h = http.Header{}
+	W3CPropagator{}.Inject(h, &header.Header{TraceID: "1-5759e988-bd862e3fe1be46a994272793", ParentID: "53995c3f42cd8ad8", SamplingDecision: header.NotSampled})
+	assert.Equal(t, "00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-00", h.Get(TraceParentHeaderKey))
+
+	h = http.Header{}
+	W3CPropagator{}.Inject(h, &header.Header{TraceID: "invalid", ParentID: "53995c3f42cd8ad8"})
+	assert.Empty(t, h.Get(TraceParentHeaderKey))
+}
+
+func TestXRayPropagator(t *testing.T) {
+	assert.Nil(t, XRayPropagator{}.Extract(http.Header{}))
+
+	h := http.Header{}
+	XRayPropagator{}.Inject(h, &header.Header{TraceID: "1-5759e988-bd862e3fe1be46a994272793", ParentID: "53995c3f42cd8ad8", SamplingDecision: header.Sampled})
+	th := XRayPropagator{}.Extract(h)
+	if assert.NotNil(t, th) {
+		assert.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", th.TraceID)
+		assert.Equal(t, "53995c3f42cd8ad8", th.ParentID)
+		assert.Equal(t, header.Sampled, th.SamplingDecision)
+	}
+}
+
+// TestPropagatorsRoundTrip calls a service propagating both headers with a
+// traceparent header, which in turn calls an X-Ray instrumented service and
+// a service only reading traceparent.
+func TestPropagatorsRoundTrip(t *testing.T) {
+	alwaysSample := sampling.NewFuncStrategy(func(*sampling.Request) bool { return true })
+
+	xrayEmitter := NewMemoryEmitter()
+	xrayCtx, err := ContextWithConfig(context.Background(), Config{Emitter: xrayEmitter, SamplingStrategy: alwaysSample})
+	if !assert.NoError(t, err) {
+		return
+	}
+	xrayService := httptest.NewServer(HandlerWithContext(xrayCtx, NewFixedSegmentNamer("xray-service"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	defer xrayService.Close()
+
+	traceparents := make(chan string, 1)
+	otelService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get(TraceParentHeaderKey)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer otelService.Close()
+
+	emitter := NewMemoryEmitter()
+	ctx, err := ContextWithConfig(context.Background(), Config{
+		Emitter:          emitter,
+		SamplingStrategy: alwaysSample,
+		Propagators:      []Propagator{XRayPropagator{}, W3CPropagator{}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	service := HandlerWithContext(ctx, NewFixedSegmentNamer("service"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, u := range []string{xrayService.URL, otelService.URL} {
+			req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, u, nil)
+			resp, err := Client(nil).Do(req)
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set(TraceParentHeaderKey, "00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01")
+	service.ServeHTTP(httptest.NewRecorder(), req)
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !assert.NoError(t, emitter.WaitForSegments(waitCtx, 1)) || !assert.NoError(t, xrayEmitter.WaitForSegments(waitCtx, 1)) {
+		return
+	}
+
+	seg := emitter.Segments()[0]
+	assert.Equal(t, "1-5759e988-bd862e3fe1be46a994272793", seg.TraceID)
+	assert.Equal(t, "53995c3f42cd8ad8", seg.ParentID)
+
+	subsegIDs := map[string]string{}
+	for _, raw := range seg.Subsegments {
+		var subseg *Segment
+		if assert.NoError(t, json.Unmarshal(raw, &subseg)) {
+			subsegIDs[subseg.Name] = subseg.ID
+		}
+	}
+	host := func(u string) string {
+		parsed, _ := url.Parse(u)
+		return parsed.Host
+	}
+
+	downstream := xrayEmitter.Segments()[0]
+	assert.Equal(t, seg.TraceID, downstream.TraceID)
+	assert.Equal(t, subsegIDs[host(xrayService.URL)], downstream.ParentID)
+
+	assert.Equal(t, "00-5759e988bd862e3fe1be46a994272793-"+subsegIDs[host(otelService.URL)]+"-01", <-traceparents)
+}
+
+func TestDefaultPropagatorIgnoresTraceParent(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+	req.Header.Set(TraceParentHeaderKey, "00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01")
+	Handler(NewFixedSegmentNamer("test"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotEqual(t, "1-5759e988-bd862e3fe1be46a994272793", seg.TraceID)
+	assert.Empty(t, seg.ParentID)
+}
@@ -3,12 +3,16 @@ package xray
 import (
 	"io"
 	"net/http"
+	"strconv"
 )
 
 type responseCapturer struct {
 	http.ResponseWriter
 	status int
 	length int
+
+	// body, if set, receives a copy of everything written to the response.
+	body io.Writer
 }
 
 func (w *responseCapturer) WriteHeader(status int) {
@@ -17,8 +21,37 @@ func (w *responseCapturer) WriteHeader(status int) {
 }
 
 func (w *responseCapturer) Write(data []byte) (int, error) {
-	w.length += len(data)
-	return w.ResponseWriter.Write(data)
+	if w.body != nil {
+		w.body.Write(data)
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.length += n
+	return n, err
+}
+
+// contentLength returns the Content-Length header of the response, or else
+// the number of bytes written to it, as with chunked responses.
+func (w *responseCapturer) contentLength() int {
+	if n, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil {
+		return n
+	}
+	return w.length
+}
+
+// capturerReaderFrom counts the bytes written to the response with the
+// io.ReaderFrom of the wrapped ResponseWriter, as io.Copy does.
+type capturerReaderFrom struct {
+	w        *responseCapturer
+	readFrom io.ReaderFrom
+}
+
+func (c capturerReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	if c.w.body != nil {
+		r = io.TeeReader(r, c.w.body)
+	}
+	n, err := c.readFrom.ReadFrom(r)
+	c.w.length += int(n)
+	return n, err
 }
 
 // Returns a wrapped http.ResponseWriter that implements the same optional interfaces
@@ -32,6 +65,9 @@ func (w *responseCapturer) wrappedResponseWriter() http.ResponseWriter {
 	hijack, isHijacker := w.ResponseWriter.(http.Hijacker)
 	push, isPusher := w.ResponseWriter.(http.Pusher)
 	readFrom, isReaderFrom := w.ResponseWriter.(io.ReaderFrom)
+	if isReaderFrom {
+		readFrom = capturerReaderFrom{w: w, readFrom: readFrom}
+	}
 
 	switch {
 	case !isCloseNotifier && !isFlusher && !isHijacker && !isPusher && !isReaderFrom:
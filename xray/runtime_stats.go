@@ -0,0 +1,86 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// runtimeStatsNamespace is the metadata namespace of the runtime stats
+// recorded on faults, see Config.CaptureRuntimeStatsOnFault.
+const runtimeStatsNamespace = "runtime"
+
+// memStatsInterval is how long a snapshot of the memory stats is reused
+// for, since runtime.ReadMemStats stops the world.
+const memStatsInterval = time.Second
+
+// memStatsSnapshot holds the memory stats last read for all segments.
+var memStatsSnapshot struct {
+	sync.Mutex
+	readAt      time.Time
+	heapInUse   uint64
+	lastGCPause time.Duration
+	numGC       uint32
+}
+
+// runtimeStats returns the number of goroutines and the memory stats read at
+// most memStatsInterval ago, as the metadata of the runtime namespace.
+func runtimeStats() map[string]interface{} {
+	s := &memStatsSnapshot
+	s.Lock()
+	if now := time.Now(); now.Sub(s.readAt) >= memStatsInterval {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		s.readAt = now
+		s.heapInUse = m.HeapInuse
+		s.lastGCPause = time.Duration(m.PauseNs[(m.NumGC+255)%256])
+		s.numGC = m.NumGC
+	}
+	stats := map[string]interface{}{
+		"goroutines":       runtime.NumGoroutine(),
+		"heap_inuse_bytes": s.heapInUse,
+		"last_gc_pause_ms": float64(s.lastGCPause) / float64(time.Millisecond),
+		"num_gc":           s.numGC,
+	}
+	s.Unlock()
+	return stats
+}
+
+// faultRuntimeStats returns the runtime stats to record on seg when it's
+// closed with err, or nil unless it's a fault and its configuration captures
+// them. It's called before locking seg for the rest of the update, so that
+// the stats are never read with seg locked.
+func (seg *Segment) faultRuntimeStats(err error) map[string]interface{} {
+	cfg := seg.ParentSegment.Configuration
+	if cfg == nil || !cfg.CaptureRuntimeStatsOnFault {
+		return nil
+	}
+
+	seg.RLock()
+	fault := (err != nil || seg.Fault) && !seg.Dummy
+	seg.RUnlock()
+	if !fault {
+		return nil
+	}
+	return runtimeStats()
+}
+
+// addRuntimeStats records stats in the runtime metadata namespace of seg.
+// The caller of addRuntimeStats should have write lock on seg instance.
+func (seg *Segment) addRuntimeStats(stats map[string]interface{}) {
+	if stats == nil {
+		return
+	}
+	if seg.Metadata == nil {
+		seg.Metadata = map[string]map[string]interface{}{}
+	}
+	seg.Metadata[runtimeStatsNamespace] = stats
+}
@@ -0,0 +1,59 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureRuntimeStatsOnFault(t *testing.T) {
+	ctx, _ := newMemoryEmitterContext(t, Config{CaptureRuntimeStatsOnFault: true})
+	ctx, root := BeginSegment(ctx, "test")
+	_, ok := BeginSubsegment(ctx, "ok")
+	_, failed := BeginSubsegment(ctx, "failed")
+	_, faulted := BeginSubsegment(ctx, "faulted")
+
+	ok.Close(nil)
+	failed.Close(errors.New("boom"))
+	// faults recorded before closing, like 5xx responses, count too
+	faulted.Lock()
+	faulted.Fault = true
+	faulted.Unlock()
+	faulted.Close(nil)
+	assert.NoError(t, root.AddError(errors.New("boom")))
+	root.Close(nil)
+
+	assert.NotContains(t, ok.Metadata, runtimeStatsNamespace)
+	for _, seg := range []*Segment{failed, faulted, root} {
+		stats := seg.Metadata[runtimeStatsNamespace]
+		if !assert.NotNil(t, stats, seg.Name) {
+			continue
+		}
+		assert.Greater(t, stats["goroutines"], 0)
+		assert.Greater(t, stats["heap_inuse_bytes"], uint64(0))
+		assert.Contains(t, stats, "last_gc_pause_ms")
+		assert.Contains(t, stats, "num_gc")
+	}
+}
+
+func TestCaptureRuntimeStatsOnFaultDisabled(t *testing.T) {
+	ctx, _ := newMemoryEmitterContext(t, Config{})
+	ctx, root := BeginSegment(ctx, "test")
+	_, failed := BeginSubsegment(ctx, "failed")
+
+	failed.Close(errors.New("boom"))
+	root.Close(errors.New("boom"))
+
+	assert.True(t, failed.Fault)
+	assert.NotContains(t, failed.Metadata, runtimeStatsNamespace)
+	assert.NotContains(t, root.Metadata, runtimeStatsNamespace)
+}
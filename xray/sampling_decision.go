@@ -0,0 +1,87 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+)
+
+// SamplingSource is the source of the sampling decision of a segment,
+// recorded in its sampling_source annotation.
+type SamplingSource string
+
+const (
+	// SamplingSourceOverride is the decision set with WithSamplingOverride.
+	SamplingSourceOverride SamplingSource = "override"
+
+	// SamplingSourceHeader is the decision propagated by the incoming trace header.
+	SamplingSourceHeader SamplingSource = "header"
+
+	// SamplingSourceStrategy is the decision of the sampling strategy.
+	SamplingSourceStrategy SamplingSource = "strategy"
+
+	// SamplingSourceDefault is used when no other source decides; the segment
+	// is not sampled.
+	SamplingSourceDefault SamplingSource = "default"
+)
+
+// forcedAnnotationKey is the annotation of segments forced to be sampled with
+// WithSamplingOverride.
+const forcedAnnotationKey = "xray_forced"
+
+// promotedAnnotationKey is the annotation of segments promoted to sampled
+// with PromoteToSampled.
+const promotedAnnotationKey = "promoted"
+
+// samplingInput holds the sources of the sampling decision of a segment.
+type samplingInput struct {
+	// override is the decision set with WithSamplingOverride, if any.
+	override *bool
+
+	// header is the decision of the incoming trace header. Unknown and
+	// Requested don't decide.
+	header header.SamplingDecision
+
+	// strategy evaluates the sampling strategy, if any. It may return nil.
+	strategy func() *sampling.Decision
+}
+
+// resolveSampling decides whether a segment is sampled. The sources of in
+// take precedence in this order:
+//
+//  1. the per-request override;
+//  2. the decision of the incoming trace header;
+//  3. the decision of the sampling strategy;
+//  4. the default, which is not to sample.
+//
+// The strategy is only evaluated when neither the override nor the header
+// decide; its decision is returned so that the matched rule can be recorded.
+// All entry points begin their segments with BeginSegmentWithSampling, which
+// calls resolveSampling, so the precedence is the same for all of them.
+func resolveSampling(in samplingInput) (bool, SamplingSource, *sampling.Decision) {
+	if in.override != nil {
+		return *in.override, SamplingSourceOverride, nil
+	}
+
+	switch in.header {
+	case header.Sampled:
+		return true, SamplingSourceHeader, nil
+	case header.NotSampled:
+		return false, SamplingSourceHeader, nil
+	}
+
+	if in.strategy != nil {
+		if sd := in.strategy(); sd != nil {
+			return sd.Sample, SamplingSourceStrategy, sd
+		}
+	}
+
+	return false, SamplingSourceDefault, nil
+}
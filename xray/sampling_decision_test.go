@@ -0,0 +1,271 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSampling(t *testing.T) {
+	sampled, notSampled := true, false
+	overrides := []struct {
+		name     string
+		override *bool
+	}{
+		{"no override", nil},
+		{"override sampled", &sampled},
+		{"override not sampled", &notSampled},
+	}
+	headers := []header.SamplingDecision{header.Unknown, header.Requested, header.Sampled, header.NotSampled}
+	strategies := []struct {
+		name     string
+		decision *sampling.Decision
+		present  bool
+	}{
+		{"no strategy", nil, false},
+		{"strategy without decision", nil, true},
+		{"strategy sampled", &sampling.Decision{Sample: true}, true},
+		{"strategy not sampled", &sampling.Decision{Sample: false}, true},
+	}
+
+	// want returns the expected decision and source, following the documented precedence.
+	want := func(override *bool, hdr header.SamplingDecision, sd *sampling.Decision) (bool, SamplingSource) {
+		switch {
+		case override != nil:
+			return *override, SamplingSourceOverride
+		case hdr == header.Sampled:
+			return true, SamplingSourceHeader
+		case hdr == header.NotSampled:
+			return false, SamplingSourceHeader
+		case sd != nil:
+			return sd.Sample, SamplingSourceStrategy
+		default:
+			return false, SamplingSourceDefault
+		}
+	}
+
+	for _, o := range overrides {
+		for _, hdr := range headers {
+			for _, s := range strategies {
+				t.Run(fmt.Sprintf("%s/%q/%s", o.name, hdr, s.name), func(t *testing.T) {
+					evaluated := false
+					in := samplingInput{override: o.override, header: hdr}
+					if s.present {
+						decision := s.decision
+						in.strategy = func() *sampling.Decision {
+							evaluated = true
+							return decision
+						}
+					}
+
+					gotSampled, gotSource, gotDecision := resolveSampling(in)
+
+					wantSampled, wantSource := want(o.override, hdr, s.decision)
+					assert.Equal(t, wantSampled, gotSampled)
+					assert.Equal(t, wantSource, gotSource)
+					// the strategy is only evaluated when it may decide
+					assert.Equal(t, s.present && o.override == nil && hdr != header.Sampled && hdr != header.NotSampled, evaluated)
+					if wantSource == SamplingSourceStrategy {
+						assert.Equal(t, s.decision, gotDecision)
+					} else {
+						assert.Nil(t, gotDecision)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestSamplingSourceAnnotation(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	tests := []struct {
+		name     string
+		override *bool
+		sampled  string
+		source   SamplingSource
+		respHdr  string
+	}{
+		{"strategy", nil, "?", SamplingSourceStrategy, "Sampled=1"},
+		{"header", nil, "1", SamplingSourceHeader, "Sampled=1"},
+		{"override", new(bool), "1", SamplingSourceOverride, "Sampled=0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqCtx := ctx
+			if tt.override != nil {
+				reqCtx = WithSamplingOverride(ctx, *tt.override)
+			}
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(reqCtx)
+			req.Header.Set(TraceIDHeaderKey, "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled="+tt.sampled)
+			rec := httptest.NewRecorder()
+			Handler(NewFixedSegmentNamer("test"), handler).ServeHTTP(rec, req)
+
+			assert.Contains(t, rec.Result().Header.Get(TraceIDHeaderKey), tt.respHdr)
+			if tt.respHdr == "Sampled=0" {
+				_, err := td.Recv()
+				assert.Error(t, err)
+				return
+			}
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, string(tt.source), seg.Annotations["sampling_source"])
+		})
+	}
+}
+
+func TestSamplingOverrideBeginSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx = withSamplingConfig(ctx, func(cfg *Config) {
+		cfg.SamplingStrategy = sampling.NewFuncStrategy(func(*sampling.Request) bool { return false })
+	})
+
+	_, seg := BeginSegment(WithSamplingOverride(ctx, true), "test")
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, string(SamplingSourceOverride), emitted.Annotations["sampling_source"])
+	assert.Equal(t, true, emitted.Annotations[forcedAnnotationKey])
+}
+
+func TestWithSamplingOverrideHandler(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	for _, force := range []bool{true, false} {
+		t.Run(fmt.Sprintf("force=%t", force), func(t *testing.T) {
+			calls := 0
+			reqCtx := withSamplingConfig(ctx, func(cfg *Config) {
+				cfg.SamplingStrategy = sampling.NewFuncStrategy(func(*sampling.Request) bool {
+					calls++
+					return !force
+				})
+			})
+
+			var downstream *header.Header
+			handler := Handler(NewFixedSegmentNamer("test"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				downstream = GetSegment(r.Context()).DownstreamHeader()
+				w.WriteHeader(http.StatusOK)
+			}))
+			// the override is set by a middleware wrapping the handler
+			debug := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handler.ServeHTTP(w, r.WithContext(WithSamplingOverride(r.Context(), r.Header.Get("X-Debug-Trace") == "1")))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(reqCtx)
+			// the decision is only returned when requested
+			req.Header.Set(TraceIDHeaderKey, "Sampled=?")
+			if force {
+				req.Header.Set("X-Debug-Trace", "1")
+			}
+			rec := httptest.NewRecorder()
+			debug.ServeHTTP(rec, req)
+
+			assert.Equal(t, 0, calls, "the strategy must not be consulted")
+			if !assert.NotNil(t, downstream) {
+				return
+			}
+			if !force {
+				assert.Equal(t, header.NotSampled, downstream.SamplingDecision)
+				assert.Contains(t, rec.Result().Header.Get(TraceIDHeaderKey), "Sampled=0")
+				_, err := td.Recv()
+				assert.Error(t, err)
+				return
+			}
+
+			assert.Equal(t, header.Sampled, downstream.SamplingDecision)
+			assert.Contains(t, rec.Result().Header.Get(TraceIDHeaderKey), "Sampled=1")
+			seg, err := td.Recv()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, string(SamplingSourceOverride), seg.Annotations["sampling_source"])
+			assert.Equal(t, true, seg.Annotations[forcedAnnotationKey])
+		})
+	}
+}
+
+// decisionStrategy always returns a copy of its decision.
+type decisionStrategy sampling.Decision
+
+func (ds *decisionStrategy) ShouldTrace(*sampling.Request) *sampling.Decision {
+	sd := sampling.Decision(*ds)
+	return &sd
+}
+
+func TestSegmentSamplingRule(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	r1, def := "r1", "Default"
+	local, err := sampling.NewLocalizedStrategyFromRules([]sampling.RuleSpec{{URLPath: "/", FixedTarget: 1, Rate: 1}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		name     string
+		strategy sampling.Strategy
+		sampled  string
+		rule     string
+		ok       bool
+		source   sampling.DecisionSource
+	}{
+		{"rule", &decisionStrategy{Sample: true, Rule: &r1, Source: sampling.DecisionSourceRule}, "?", r1, true, sampling.DecisionSourceRule},
+		{"default rule", &decisionStrategy{Sample: true, Rule: &def, Source: sampling.DecisionSourceDefaultRule}, "?", def, true, sampling.DecisionSourceDefaultRule},
+		{"local", local, "?", "", true, sampling.DecisionSourceLocal},
+		{"fallback", &decisionStrategy{Sample: true, Source: sampling.DecisionSourceFallback}, "?", "", true, sampling.DecisionSourceFallback},
+		{"trace header", &decisionStrategy{Sample: false}, "1", "", false, sampling.DecisionSourceTraceHeader},
+		{"func strategy", sampling.NewFuncStrategy(func(*sampling.Request) bool { return true }), "?", "", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqCtx := withSamplingConfig(ctx, func(cfg *Config) {
+				cfg.SamplingStrategy = tt.strategy
+			})
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			h := header.FromString("Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=" + tt.sampled)
+			reqCtx, seg := NewSegmentFromHeader(reqCtx, "test", req, h)
+			_, subseg := BeginSubsegment(reqCtx, "child")
+
+			assert.Equal(t, tt.source, seg.DecisionSource)
+			for _, s := range []*Segment{seg, subseg} {
+				rule, sampled, ok := s.SamplingRule()
+				assert.Equal(t, tt.rule, rule)
+				assert.True(t, sampled)
+				assert.Equal(t, tt.ok, ok)
+			}
+
+			subseg.Close(nil)
+			seg.Close(nil)
+			_, err := td.Recv()
+			assert.NoError(t, err)
+		})
+	}
+}
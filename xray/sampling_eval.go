@@ -0,0 +1,127 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+)
+
+var (
+	// defaultSamplingEvalWarnThreshold is used when Config.SamplingEvalWarnThreshold is not set.
+	defaultSamplingEvalWarnThreshold = time.Millisecond
+
+	// samplingEvalWarnInterval rate limits the slow sampling strategy warning.
+	samplingEvalWarnInterval = 10 * time.Second
+
+	// samplingEvalWindow is the period over which the maximum evaluation time is kept.
+	samplingEvalWindow = time.Minute
+)
+
+var samplingEval = &samplingEvalStats{}
+
+// samplingEvalStats keeps the rolling maximum duration of sampling strategy
+// evaluations, and when the slow evaluation warning was last logged.
+type samplingEvalStats struct {
+	sync.Mutex
+	max         time.Duration
+	windowStart time.Time
+	lastWarn    time.Time
+}
+
+// observe records d and reports whether a warning about it should be logged.
+func (s *samplingEvalStats) observe(d, threshold time.Duration) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.windowStart) > samplingEvalWindow {
+		s.windowStart, s.max = now, 0
+	}
+	if d > s.max {
+		s.max = d
+	}
+
+	if d <= threshold || now.Sub(s.lastWarn) < samplingEvalWarnInterval {
+		return false
+	}
+	s.lastWarn = now
+	return true
+}
+
+func (s *samplingEvalStats) maxDuration() time.Duration {
+	s.Lock()
+	defer s.Unlock()
+
+	if time.Since(s.windowStart) > samplingEvalWindow {
+		return 0
+	}
+	return s.max
+}
+
+// shouldTrace evaluates the configured sampling strategy for r, measuring how
+// long the evaluation takes. When Config.SamplingTimeout is set and exceeded,
+// the decision is abandoned and the request is not sampled.
+// Only called within a seg locked code block.
+func (seg *Segment) shouldTrace(r *sampling.Request) *sampling.Decision {
+	cfg := seg.ParentSegment.GetConfiguration()
+
+	start := time.Now()
+	sd := evaluateSampling(cfg.SamplingStrategy, r, cfg.SamplingTimeout, seg.log())
+	d := time.Since(start)
+
+	threshold := cfg.SamplingEvalWarnThreshold
+	if threshold <= 0 {
+		threshold = defaultSamplingEvalWarnThreshold
+	}
+	if sd.Source == sampling.DecisionSourceFallback {
+		fallbackSamplingUsed.add(seg.metricsObserver(), 1)
+	}
+	if samplingEval.observe(d, threshold) {
+		seg.log().Warnf("SamplingStrategy took %v to decide, which is more than %v. This time is added to every request.", d, threshold)
+	}
+
+	if sd.Sample {
+		if seg.Metadata == nil {
+			seg.Metadata = map[string]map[string]interface{}{}
+		}
+		if seg.Metadata["xray"] == nil {
+			seg.Metadata["xray"] = map[string]interface{}{}
+		}
+		seg.Metadata["xray"]["sampling_eval_ms"] = float64(d) / float64(time.Millisecond)
+	}
+	return sd
+}
+
+func evaluateSampling(s sampling.Strategy, r *sampling.Request, timeout time.Duration, log logger.Scoped) *sampling.Decision {
+	if timeout <= 0 {
+		return s.ShouldTrace(r)
+	}
+
+	// The strategy keeps running in its goroutine after the timeout,
+	// its decision is discarded.
+	ch := make(chan *sampling.Decision, 1)
+	go func() {
+		ch <- s.ShouldTrace(r)
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case sd := <-ch:
+		return sd
+	case <-t.C:
+		log.Warnf("SamplingStrategy did not decide within %v. Request is not sampled.", timeout)
+		return &sampling.Decision{Sample: false}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/aws/aws-xray-sdk-go/xraylog"
+	"github.com/stretchr/testify/assert"
+)
+
+type slowSamplingStrategy struct {
+	delay time.Duration
+}
+
+func (s *slowSamplingStrategy) ShouldTrace(request *sampling.Request) *sampling.Decision {
+	time.Sleep(s.delay)
+	return &sampling.Decision{Sample: true}
+}
+
+func withSamplingConfig(ctx context.Context, f func(cfg *Config)) context.Context {
+	cfg := *GetRecorder(ctx)
+	f(&cfg)
+	return context.WithValue(ctx, RecorderContextKey{}, &cfg)
+}
+
+func captureWarnings(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	oldLogger := logger.Logger
+	logger.Logger = xraylog.NewDefaultLogger(&buf, xraylog.LogLevelWarn)
+	samplingEval = &samplingEvalStats{}
+	t.Cleanup(func() {
+		logger.Logger = oldLogger
+		samplingEval = &samplingEvalStats{}
+	})
+	return &buf
+}
+
+func TestSlowSamplingStrategy(t *testing.T) {
+	buf := captureWarnings(t)
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx = withSamplingConfig(ctx, func(cfg *Config) {
+		cfg.SamplingStrategy = &slowSamplingStrategy{delay: 5 * time.Millisecond}
+	})
+
+	for i := 0; i < 3; i++ {
+		_, seg := BeginSegment(ctx, "test")
+		seg.Close(nil)
+	}
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.GreaterOrEqual(t, seg.Metadata["xray"]["sampling_eval_ms"], 5.0)
+	assert.GreaterOrEqual(t, Stats().MaxSamplingEvalDuration, 5*time.Millisecond)
+
+	// the warning is rate limited
+	assert.Equal(t, 1, strings.Count(buf.String(), "SamplingStrategy took"))
+}
+
+func TestSamplingEvalWarnThreshold(t *testing.T) {
+	buf := captureWarnings(t)
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx = withSamplingConfig(ctx, func(cfg *Config) {
+		cfg.SamplingStrategy = &slowSamplingStrategy{delay: 5 * time.Millisecond}
+		cfg.SamplingEvalWarnThreshold = time.Second
+	})
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	_, err := td.Recv()
+	assert.NoError(t, err)
+	assert.Empty(t, buf.String())
+}
+
+func TestSamplingTimeout(t *testing.T) {
+	buf := captureWarnings(t)
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx = withSamplingConfig(ctx, func(cfg *Config) {
+		cfg.SamplingStrategy = &slowSamplingStrategy{delay: 200 * time.Millisecond}
+		cfg.SamplingTimeout = 10 * time.Millisecond
+	})
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	assert.False(t, seg.Sampled)
+	assert.True(t, seg.Dummy)
+	assert.Equal(t, noOpTraceID(), seg.TraceID)
+	assert.Equal(t, noOpSegmentID(), seg.ID)
+	assert.Nil(t, seg.Metadata)
+	assert.Contains(t, buf.String(), "SamplingStrategy did not decide within 10ms")
+
+	_, err := td.Recv()
+	assert.Error(t, err)
+}
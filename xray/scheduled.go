@@ -0,0 +1,55 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// scheduledRunPrefix prefixes the names of segments recording scheduled runs.
+const scheduledRunPrefix = "cron:"
+
+// BeginScheduledRun begins a segment, and so a new trace, for a run of the
+// scheduled job jobName that was due at scheduledAt. The segment is named
+// "cron:<jobName>" and annotated with the job name and with the delay
+// between scheduledAt and the start of the run, in milliseconds, as
+// schedule_drift_ms. The recorder provided in ctx is used, if any.
+func BeginScheduledRun(ctx context.Context, jobName string, scheduledAt time.Time) (context.Context, *Segment) {
+	ctx, seg := BeginSegment(ctx, scheduledRunPrefix+jobName)
+
+	seg.Lock()
+	startTime := seg.StartTime
+	seg.Unlock()
+
+	seg.AddAnnotation("job", jobName)
+	seg.AddAnnotation("schedule_drift_ms", scheduleDriftMillis(startTime, scheduledAt))
+	return ctx, seg
+}
+
+// RecordSkippedRun records a run of the scheduled job jobName that was not
+// started, e.g. because the previous run was still running. It emits a
+// segment named "cron:<jobName>" lasting next to no time, annotated with skipped
+// set to true and the reason as skip_reason, so that skipped runs can be
+// counted. The recorder provided in ctx is used, if any.
+func RecordSkippedRun(ctx context.Context, jobName, reason string) {
+	_, seg := BeginSegment(ctx, scheduledRunPrefix+jobName)
+	seg.AddAnnotation("job", jobName)
+	seg.AddAnnotation("skipped", true)
+	seg.AddAnnotation("skip_reason", reason)
+	seg.Close(nil)
+}
+
+// scheduleDriftMillis returns the milliseconds from scheduledAt to startTime,
+// in seconds since the epoch. The drift is negative for early starts.
+func scheduleDriftMillis(startTime float64, scheduledAt time.Time) int {
+	scheduled := float64(scheduledAt.UnixNano()) / float64(time.Second)
+	return int(math.Round((startTime - scheduled) * 1000))
+}
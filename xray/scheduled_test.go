@@ -0,0 +1,85 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleDriftMillis(t *testing.T) {
+	scheduledAt := time.Unix(1500000000, 0)
+
+	assert.Equal(t, 0, scheduleDriftMillis(1500000000, scheduledAt))
+	assert.Equal(t, 1250, scheduleDriftMillis(1500000001.25, scheduledAt))
+	assert.Equal(t, -500, scheduleDriftMillis(1499999999.5, scheduledAt))
+	assert.Equal(t, 3, scheduleDriftMillis(1500000000, scheduledAt.Add(-2500*time.Microsecond)))
+}
+
+func TestBeginScheduledRun(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	scheduledAt := time.Now().Add(-2 * time.Second)
+	_, seg := BeginScheduledRun(ctx, "nightly-report", scheduledAt)
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "cron:nightly-report", emitted.Name)
+	assert.Equal(t, "nightly-report", emitted.Annotations["job"])
+	drift := emitted.Annotations["schedule_drift_ms"].(float64)
+	assert.Equal(t, float64(scheduleDriftMillis(emitted.StartTime, scheduledAt)), drift)
+	assert.InDelta(t, 2000, drift, 1000)
+}
+
+func TestRecordSkippedRun(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	RecordSkippedRun(ctx, "nightly-report", "previous run still running")
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "cron:nightly-report", emitted.Name)
+	assert.Equal(t, map[string]interface{}{
+		"job":             "nightly-report",
+		"skipped":         true,
+		"skip_reason":     "previous run still running",
+		"sampling_source": "strategy",
+	}, emitted.Annotations)
+	assert.NotEmpty(t, emitted.TraceID)
+	assert.False(t, emitted.InProgress)
+	assert.Empty(t, emitted.Subsegments)
+	assert.InDelta(t, emitted.StartTime, emitted.EndTime, 1)
+}
+
+func TestRecordSkippedRunGlobalRecorder(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	Configure(Config{Emitter: GetRecorder(ctx).Emitter, SamplingStrategy: &TestSamplingStrategy{}})
+	defer ResetConfig()
+
+	RecordSkippedRun(context.Background(), "cleanup", "disabled")
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "cron:cleanup", emitted.Name)
+	assert.Equal(t, true, emitted.Annotations["skipped"])
+}
@@ -0,0 +1,138 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Names of the SDK health metrics reported to a MetricsObserver.
+const (
+	MetricSegmentsEmitted      = "segments_emitted"
+	MetricEmitErrors           = "emit_errors"
+	MetricSubsegmentsStreamed  = "subsegments_streamed"
+	MetricContextMissing       = "context_missing"
+	MetricSampledTrue          = "sampled_true"
+	MetricSampledFalse         = "sampled_false"
+	MetricFallbackSamplingUsed = "fallback_sampling_used"
+	MetricEmitFilterKept       = "emit_filter_kept"
+	MetricEmitFilterDropped    = "emit_filter_dropped"
+	MetricEmitFilterSkipped    = "emit_filter_skipped"
+)
+
+// MetricsObserver is notified of every change of the SDK health metrics
+// counted for the segments of a configuration, e.g. to publish them to
+// Prometheus or as CloudWatch embedded metrics. Observe is called
+// synchronously, from the goroutine that changed the metric, and must not
+// block.
+type MetricsObserver interface {
+	Observe(name string, delta int64)
+}
+
+// SDKStats counts SDK health events since the program started.
+type SDKStats struct {
+	// SegmentsEmitted counts the segment and subsegment documents sent
+	// to the daemon by DefaultEmitter.
+	SegmentsEmitted int64
+
+	// EmitErrors counts the documents DefaultEmitter failed to send.
+	EmitErrors int64
+
+	// SubsegmentsStreamed counts the subsegments emitted ahead of their
+	// root segment.
+	SubsegmentsStreamed int64
+
+	// ContextMissing counts the subsegments and SQL calls that could not be
+	// recorded because the context had no segment.
+	ContextMissing int64
+
+	// SampledTrue and SampledFalse count the segments begun sampled and
+	// not sampled.
+	SampledTrue  int64
+	SampledFalse int64
+
+	// FallbackSamplingUsed counts the sampling decisions CentralizedStrategy
+	// took by its local fallback rules while centralized rules were
+	// unavailable.
+	FallbackSamplingUsed int64
+
+	// EmitFilterKept and EmitFilterDropped count the root segments kept and
+	// dropped by Config.EmitFilter. EmitFilterSkipped counts the segments
+	// the filter was not consulted for, because some of their subsegments
+	// had already been streamed.
+	EmitFilterKept    int64
+	EmitFilterDropped int64
+	EmitFilterSkipped int64
+
+	// MaxSamplingEvalDuration is the longest time a sampling strategy took
+	// to make a decision during the last minute.
+	MaxSamplingEvalDuration time.Duration
+}
+
+// sdkCounter is an SDK health metric.
+type sdkCounter struct {
+	name  string
+	value atomic.Int64
+}
+
+var (
+	segmentsEmitted      = &sdkCounter{name: MetricSegmentsEmitted}
+	emitErrors           = &sdkCounter{name: MetricEmitErrors}
+	subsegmentsStreamed  = &sdkCounter{name: MetricSubsegmentsStreamed}
+	contextMissing       = &sdkCounter{name: MetricContextMissing}
+	sampledTrue          = &sdkCounter{name: MetricSampledTrue}
+	sampledFalse         = &sdkCounter{name: MetricSampledFalse}
+	fallbackSamplingUsed = &sdkCounter{name: MetricFallbackSamplingUsed}
+	emitFilterKept       = &sdkCounter{name: MetricEmitFilterKept}
+	emitFilterDropped    = &sdkCounter{name: MetricEmitFilterDropped}
+	emitFilterSkipped    = &sdkCounter{name: MetricEmitFilterSkipped}
+)
+
+// add adds delta to c, notifying o if not nil.
+func (c *sdkCounter) add(o MetricsObserver, delta int64) {
+	c.value.Add(delta)
+	if o != nil {
+		o.Observe(c.name, delta)
+	}
+}
+
+// Stats returns a snapshot of the SDK health metrics.
+func Stats() SDKStats {
+	return SDKStats{
+		SegmentsEmitted:         segmentsEmitted.value.Load(),
+		EmitErrors:              emitErrors.value.Load(),
+		SubsegmentsStreamed:     subsegmentsStreamed.value.Load(),
+		ContextMissing:          contextMissing.value.Load(),
+		SampledTrue:             sampledTrue.value.Load(),
+		SampledFalse:            sampledFalse.value.Load(),
+		FallbackSamplingUsed:    fallbackSamplingUsed.value.Load(),
+		EmitFilterKept:          emitFilterKept.value.Load(),
+		EmitFilterDropped:       emitFilterDropped.value.Load(),
+		EmitFilterSkipped:       emitFilterSkipped.value.Load(),
+		MaxSamplingEvalDuration: samplingEval.maxDuration(),
+	}
+}
+
+// metricsObserver returns the MetricsObserver of the segment tree.
+func (seg *Segment) metricsObserver() MetricsObserver {
+	if seg == nil || seg.ParentSegment == nil || seg.ParentSegment.Configuration == nil {
+		return globalCfg.MetricsObserver()
+	}
+	return seg.ParentSegment.Configuration.MetricsObserver
+}
+
+// configMetricsObserver returns the MetricsObserver of cfg, the recorder
+// of a context, or the global one.
+func configMetricsObserver(cfg *Config) MetricsObserver {
+	if cfg != nil && cfg.MetricsObserver != nil {
+		return cfg.MetricsObserver
+	}
+	return globalCfg.MetricsObserver()
+}
@@ -0,0 +1,127 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/stretchr/testify/assert"
+)
+
+// testMetricsObserver sums the observed deltas by metric name.
+type testMetricsObserver struct {
+	mu      sync.Mutex
+	metrics map[string]int64
+}
+
+func (o *testMetricsObserver) Observe(name string, delta int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.metrics == nil {
+		o.metrics = map[string]int64{}
+	}
+	o.metrics[name] += delta
+}
+
+// testFallbackStrategy samples requests to /sampled, as if by a local fallback
+// rule of CentralizedStrategy.
+type testFallbackStrategy struct{}
+
+func (testFallbackStrategy) ShouldTrace(rq *sampling.Request) *sampling.Decision {
+	return &sampling.Decision{Sample: rq.URL == "/sampled", Source: sampling.DecisionSourceFallback}
+}
+
+func TestStats(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	observer := &testMetricsObserver{}
+	ctx, err := ContextWithConfig(ctx, Config{
+		Emitter:                GetRecorder(ctx).Emitter,
+		SamplingStrategy:       testFallbackStrategy{},
+		ContextMissingStrategy: &TestContextMissingStrategy{},
+		MetricsObserver:        observer,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	before := Stats()
+	handler := Handler(NewFixedSegmentNamer("test"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, path := range []string{"/sampled", "/ignored", "/sampled"} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil).WithContext(ctx)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := td.Recv()
+		assert.NoError(t, err)
+	}
+
+	// ctx carries no segment
+	_, subseg := BeginSubsegment(ctx, "orphan")
+	assert.Nil(t, subseg)
+
+	assert.Equal(t, map[string]int64{
+		MetricSegmentsEmitted:      2,
+		MetricSampledTrue:          2,
+		MetricSampledFalse:         1,
+		MetricFallbackSamplingUsed: 3,
+		MetricContextMissing:       1,
+	}, observer.metrics)
+
+	after := Stats()
+	assert.GreaterOrEqual(t, after.SegmentsEmitted-before.SegmentsEmitted, int64(2))
+	assert.GreaterOrEqual(t, after.SampledTrue-before.SampledTrue, int64(2))
+	assert.GreaterOrEqual(t, after.SampledFalse-before.SampledFalse, int64(1))
+	assert.GreaterOrEqual(t, after.FallbackSamplingUsed-before.FallbackSamplingUsed, int64(3))
+	assert.GreaterOrEqual(t, after.ContextMissing-before.ContextMissing, int64(1))
+}
+
+func TestStatsSubsegmentsStreamed(t *testing.T) {
+	ss, err := NewDefaultStreamingStrategyWithMaxSubsegmentCount(1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	observer := &testMetricsObserver{}
+	ctx, _ := newMemoryEmitterContext(t, Config{StreamingStrategy: ss, MetricsObserver: observer})
+
+	ctx, root := BeginSegment(ctx, "root")
+	for _, name := range []string{"first", "second", "third"} {
+		_, subseg := BeginSubsegment(ctx, name)
+		subseg.Close(nil)
+	}
+	root.Close(nil)
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	assert.Equal(t, int64(2), observer.metrics[MetricSubsegmentsStreamed])
+}
+
+func TestStatsLocalizedStrategyIsNotFallback(t *testing.T) {
+	local, err := sampling.NewLocalizedStrategyFromRules([]sampling.RuleSpec{{URLPath: "/", FixedTarget: 1, Rate: 1}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	observer := &testMetricsObserver{}
+	ctx, _ := newMemoryEmitterContext(t, Config{SamplingStrategy: local, MetricsObserver: observer})
+
+	_, root := BeginSegment(ctx, "root")
+	root.Close(nil)
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	assert.Equal(t, int64(1), observer.metrics[MetricSampledTrue])
+	assert.Zero(t, observer.metrics[MetricFallbackSamplingUsed])
+}
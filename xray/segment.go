@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -22,6 +23,7 @@ import (
 	"github.com/aws/aws-xray-sdk-go/header"
 	"github.com/aws/aws-xray-sdk-go/internal/logger"
 	"github.com/aws/aws-xray-sdk-go/internal/plugins"
+	"github.com/aws/aws-xray-sdk-go/strategy/exception"
 	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
 )
 
@@ -65,6 +67,7 @@ func BeginFacadeSegment(ctx context.Context, name string, h *header.Header) (con
 
 	cfg := GetRecorder(ctx)
 	seg.assignConfiguration(cfg)
+	seg.log().Debugf("Beginning segment named %s", seg.Name)
 
 	return context.WithValue(ctx, ContextKey, seg), seg
 }
@@ -75,9 +78,14 @@ func BeginSegment(ctx context.Context, name string) (context.Context, *Segment)
 }
 
 func BeginSegmentWithSampling(ctx context.Context, name string, r *http.Request, traceHeader *header.Header) (context.Context, *Segment) {
-	// If SDK is disabled then return with an empty segment
-	if SdkDisabled() {
+	// If SDK is disabled then return with an empty segment, which only
+	// propagates the trace header in passthrough mode
+	if pass := passthrough(GetRecorder(ctx)); pass || SDKDisabled() {
 		seg := &Segment{}
+		if pass && traceHeader != nil {
+			seg.propagated = traceHeader
+			seg.Sampled = traceHeader.SamplingDecision == header.Sampled
+		}
 		return context.WithValue(ctx, ContextKey, seg), seg
 	}
 
@@ -89,68 +97,81 @@ func BeginSegmentWithSampling(ctx context.Context, name string, r *http.Request,
 
 	cfg := GetRecorder(ctx)
 	seg.assignConfiguration(cfg)
+	seg.log().Debugf("Beginning segment named %s", seg.Name)
 
 	seg.Lock()
 	defer seg.Unlock()
 
-	seg.addPlugin(plugins.InstancePluginMetadata)
+	seg.Name = seg.normalizeName(seg.Name, seg.Configuration)
+	seg.flushTracked = true
+	atomic.AddInt64(&inProgressSegments, 1)
+
+	seg.addPlugin(seg.pluginMetadata())
+	if origin := seg.originOverride(); origin != "" {
+		seg.Origin = origin
+	}
 	seg.addSDKAndServiceInformation()
 	if seg.ParentSegment.GetConfiguration().ServiceVersion != "" {
 		seg.GetService().Version = seg.ParentSegment.GetConfiguration().ServiceVersion
 	}
 
-	if r == nil || traceHeader == nil {
-		// No header or request information provided so we can only evaluate sampling based on the serviceName
-		sd := seg.ParentSegment.GetConfiguration().SamplingStrategy.ShouldTrace(&sampling.Request{ServiceName: name})
-		seg.Sampled = sd.Sample
-		logger.Debugf("SamplingStrategy decided: %t", seg.Sampled)
-		seg.AddRuleName(sd)
-	} else {
-		// Sampling strategy for http calls
-		seg.Sampled = traceHeader.SamplingDecision == header.Sampled
-
-		switch traceHeader.SamplingDecision {
-		case header.Sampled:
-			logger.Debug("Incoming header decided: Sampled=true")
-		case header.NotSampled:
-			logger.Debug("Incoming header decided: Sampled=false")
-		}
-
-		if traceHeader.SamplingDecision != header.Sampled && traceHeader.SamplingDecision != header.NotSampled {
-			samplingRequest := &sampling.Request{
-				Host:        r.Host,
-				URL:         r.URL.Path,
-				Method:      r.Method,
-				ServiceName: seg.Name,
-				ServiceType: plugins.InstancePluginMetadata.Origin,
-			}
-			sd := seg.ParentSegment.GetConfiguration().SamplingStrategy.ShouldTrace(samplingRequest)
-			seg.Sampled = sd.Sample
-			logger.Debugf("SamplingStrategy decided: %t", seg.Sampled)
-			seg.AddRuleName(sd)
+	// Without request information, sampling can only be evaluated based on the serviceName
+	samplingRequest := &sampling.Request{ServiceName: name, ServiceType: seg.Origin, Attributes: samplingAttributes(ctx)}
+	if r != nil {
+		samplingRequest = &sampling.Request{
+			Host:        r.Host,
+			URL:         r.URL.Path,
+			Method:      r.Method,
+			ServiceName: seg.Name,
+			ServiceType: seg.Origin,
+			Attributes:  samplingAttributes(ctx),
+			Headers:     samplingHeaders(ctx),
 		}
 	}
 
+	in := samplingInput{
+		override: samplingOverride(ctx),
+		strategy: func() *sampling.Decision { return seg.shouldTrace(samplingRequest) },
+	}
+	if traceHeader != nil {
+		in.header = traceHeader.SamplingDecision
+	}
+
+	sampled, source, sd := resolveSampling(in)
+	seg.Sampled = sampled
+	if sampled {
+		sampledTrue.add(seg.metricsObserver(), 1)
+	} else {
+		sampledFalse.add(seg.metricsObserver(), 1)
+	}
+	seg.log().Debugf("Sampling decided by %s: %t", source, sampled)
+	if sd != nil {
+		seg.AddRuleName(sd)
+		seg.DecisionSource = sd.Source
+	} else if source == SamplingSourceHeader {
+		seg.DecisionSource = sampling.DecisionSourceTraceHeader
+	}
+
 	// check whether segment is dummy or not based on sampling decision
 	if !seg.ParentSegment.Sampled {
 		seg.Dummy = true
+	} else {
+		if seg.Annotations == nil {
+			seg.Annotations = map[string]interface{}{}
+		}
+		seg.Annotations["sampling_source"] = string(source)
+		if source == SamplingSourceOverride {
+			seg.Annotations[forcedAnnotationKey] = true
+		}
 	}
 
-	// Dummy segments don't get sent and don't need a goroutine to cancel them.
+	seg.addDeadlineAnnotation(ctx, "deadline_ms")
+
+	// Dummy segments don't get sent and don't need to watch the context.
 	if !seg.Dummy {
-		// Create a new context for to cancel segment.
-		// Start new goroutine to listen for segment close/cancel events.
-		// Cancel simply calls `segment.Close()`.
-		//
-		// This way, even if the client consumes the `ctx.Done()` event, the
-		// X-Ray SDK has a way of canceling (closing) the segment in the
-		// `segment.Close()` method using this new cancellation context.
-		ctx1, cancelCtx := context.WithCancel(ctx)
-		seg.cancelCtx = cancelCtx
-		go func() {
-			<-ctx1.Done()
-			seg.handleContextDone()
-		}()
+		// Flush the segment tree when ctx is done before the segment is
+		// closed, or else once it's closed, see Close.
+		seg.cancelCtx = watchContext(ctx, seg)
 	}
 
 	// generates segment and trace id based on sampling decision and AWS_XRAY_NOOP_ID env variable
@@ -180,7 +201,6 @@ func basicSegment(name string, h *header.Header) *Segment {
 		name = name[:200]
 	}
 	seg := &Segment{parent: nil}
-	logger.Debugf("Beginning segment named %s", name)
 	seg.ParentSegment = seg
 
 	seg.Lock()
@@ -213,6 +233,25 @@ func (seg *Segment) assignConfiguration(cfg *Config) {
 		seg.GetConfiguration().StreamingStrategy = globalCfg.streamingStrategy
 		seg.GetConfiguration().Emitter = globalCfg.emitter
 		seg.GetConfiguration().ServiceVersion = globalCfg.serviceVersion
+		seg.GetConfiguration().PreEmitProcessors = globalCfg.preEmitProcessors
+		seg.GetConfiguration().EmitFilter = globalCfg.emitFilter
+		seg.GetConfiguration().InstrumentationMetadata = globalCfg.instrumentationMetadata
+		seg.GetConfiguration().SamplingEvalWarnThreshold = globalCfg.samplingEvalWarnThreshold
+		seg.GetConfiguration().SamplingTimeout = globalCfg.samplingTimeout
+		seg.GetConfiguration().Propagators = globalCfg.propagators
+		seg.GetConfiguration().MaxMetadataValueBytes = globalCfg.maxMetadataValueBytes
+		seg.GetConfiguration().MaxSegmentSizeBytes = globalCfg.maxSegmentSizeBytes
+		seg.GetConfiguration().Logger = globalCfg.logger
+		seg.GetConfiguration().MetricsObserver = globalCfg.metricsObserver
+		seg.GetConfiguration().PluginMetadata = globalCfg.pluginMetadata
+		seg.GetConfiguration().Origin = globalCfg.origin
+		seg.GetConfiguration().NoPluginMetadata = globalCfg.noPluginMetadata
+		seg.GetConfiguration().CaptureRuntimeStatsOnFault = globalCfg.captureRuntimeStatsOnFault
+		seg.GetConfiguration().PromoteOnFault = globalCfg.promoteOnFault
+		seg.GetConfiguration().NameNormalization = globalCfg.nameNormalization
+		seg.GetConfiguration().NameReplacement = globalCfg.nameReplacement
+		seg.GetConfiguration().SlowSegmentThreshold = globalCfg.slowSegmentThreshold
+		seg.GetConfiguration().SlowStackMaxFrames = globalCfg.slowStackMaxFrames
 	} else {
 		if cfg.ContextMissingStrategy != nil {
 			seg.GetConfiguration().ContextMissingStrategy = cfg.ContextMissingStrategy
@@ -249,10 +288,119 @@ func (seg *Segment) assignConfiguration(cfg *Config) {
 		} else {
 			seg.GetConfiguration().ServiceVersion = globalCfg.serviceVersion
 		}
+
+		if cfg.PreEmitProcessors != nil {
+			seg.GetConfiguration().PreEmitProcessors = cfg.PreEmitProcessors
+		} else {
+			seg.GetConfiguration().PreEmitProcessors = globalCfg.preEmitProcessors
+		}
+
+		if cfg.EmitFilter != nil {
+			seg.GetConfiguration().EmitFilter = cfg.EmitFilter
+		} else {
+			seg.GetConfiguration().EmitFilter = globalCfg.emitFilter
+		}
+
+		if cfg.InstrumentationMetadata != nil {
+			seg.GetConfiguration().InstrumentationMetadata = cfg.InstrumentationMetadata
+		} else {
+			seg.GetConfiguration().InstrumentationMetadata = globalCfg.instrumentationMetadata
+		}
+
+		if cfg.SamplingEvalWarnThreshold != 0 {
+			seg.GetConfiguration().SamplingEvalWarnThreshold = cfg.SamplingEvalWarnThreshold
+		} else {
+			seg.GetConfiguration().SamplingEvalWarnThreshold = globalCfg.samplingEvalWarnThreshold
+		}
+
+		if cfg.SamplingTimeout != 0 {
+			seg.GetConfiguration().SamplingTimeout = cfg.SamplingTimeout
+		} else {
+			seg.GetConfiguration().SamplingTimeout = globalCfg.samplingTimeout
+		}
+
+		if cfg.Propagators != nil {
+			seg.GetConfiguration().Propagators = cfg.Propagators
+		} else {
+			seg.GetConfiguration().Propagators = globalCfg.propagators
+		}
+
+		if cfg.MaxMetadataValueBytes != 0 {
+			seg.GetConfiguration().MaxMetadataValueBytes = cfg.MaxMetadataValueBytes
+		} else {
+			seg.GetConfiguration().MaxMetadataValueBytes = globalCfg.maxMetadataValueBytes
+		}
+
+		if cfg.MaxSegmentSizeBytes != 0 {
+			seg.GetConfiguration().MaxSegmentSizeBytes = cfg.MaxSegmentSizeBytes
+		} else {
+			seg.GetConfiguration().MaxSegmentSizeBytes = globalCfg.maxSegmentSizeBytes
+		}
+
+		if cfg.Logger != nil {
+			seg.GetConfiguration().Logger = cfg.Logger
+		} else {
+			seg.GetConfiguration().Logger = globalCfg.logger
+		}
+
+		if cfg.MetricsObserver != nil {
+			seg.GetConfiguration().MetricsObserver = cfg.MetricsObserver
+		} else {
+			seg.GetConfiguration().MetricsObserver = globalCfg.metricsObserver
+		}
+
+		if cfg.PluginMetadata != nil {
+			seg.GetConfiguration().PluginMetadata = cfg.PluginMetadata
+		} else {
+			seg.GetConfiguration().PluginMetadata = globalCfg.pluginMetadata
+		}
+
+		if cfg.Origin != "" {
+			seg.GetConfiguration().Origin = cfg.Origin
+		} else {
+			seg.GetConfiguration().Origin = globalCfg.origin
+		}
+
+		if cfg.NameNormalization != NameNormalizationOff {
+			seg.GetConfiguration().NameNormalization = cfg.NameNormalization
+		} else {
+			seg.GetConfiguration().NameNormalization = globalCfg.nameNormalization
+		}
+
+		if cfg.NameReplacement != 0 {
+			seg.GetConfiguration().NameReplacement = cfg.NameReplacement
+		} else {
+			seg.GetConfiguration().NameReplacement = globalCfg.nameReplacement
+		}
+
+		if cfg.SlowSegmentThreshold != 0 {
+			seg.GetConfiguration().SlowSegmentThreshold = cfg.SlowSegmentThreshold
+		} else {
+			seg.GetConfiguration().SlowSegmentThreshold = globalCfg.slowSegmentThreshold
+		}
+
+		if cfg.SlowStackMaxFrames != 0 {
+			seg.GetConfiguration().SlowStackMaxFrames = cfg.SlowStackMaxFrames
+		} else {
+			seg.GetConfiguration().SlowStackMaxFrames = globalCfg.slowStackMaxFrames
+		}
+
+		seg.GetConfiguration().NoPluginMetadata = cfg.NoPluginMetadata || globalCfg.noPluginMetadata
+		seg.GetConfiguration().CaptureRuntimeStatsOnFault = cfg.CaptureRuntimeStatsOnFault || globalCfg.captureRuntimeStatsOnFault
+		seg.GetConfiguration().PromoteOnFault = cfg.PromoteOnFault || globalCfg.promoteOnFault
 	}
 	seg.Unlock()
 }
 
+// log returns the logger of the configuration of the segment tree, see
+// Config.Logger.
+func (seg *Segment) log() logger.Scoped {
+	if seg == nil || seg.ParentSegment == nil || seg.ParentSegment.Configuration == nil {
+		return logger.With(nil)
+	}
+	return logger.With(seg.ParentSegment.Configuration.Logger)
+}
+
 func BeginSubsegmentWithoutSampling(ctx context.Context, name string) (context.Context, *Segment) {
 	newCtx, subseg := BeginSubsegment(ctx, name)
 	subseg.Dummy = true
@@ -262,9 +410,24 @@ func BeginSubsegmentWithoutSampling(ctx context.Context, name string) (context.C
 
 // BeginSubsegment creates a subsegment for a given name and context.
 func BeginSubsegment(ctx context.Context, name string) (context.Context, *Segment) {
-	// If SDK is disabled then return with an empty segment
-	if SdkDisabled() {
+	return BeginSubsegmentWithOptions(ctx, name)
+}
+
+// BeginSubsegmentWithOptions creates a subsegment for a given name and
+// context like BeginSubsegment, applying the given options.
+func BeginSubsegmentWithOptions(ctx context.Context, name string, opts ...SegmentOption) (context.Context, *Segment) {
+	// Subsegments inherit the disabled state of their parent. Without a parent,
+	// the current state of the SDK decides.
+	parent := GetSegment(ctx)
+	if parent != nil && parent.isDisabled() {
+		seg := &Segment{propagated: parent.propagated}
+		return context.WithValue(ctx, ContextKey, seg), seg
+	}
+	if pass := passthrough(GetRecorder(ctx)); parent == nil && (pass || SDKDisabled()) {
 		seg := &Segment{}
+		if pass {
+			seg.propagated = getTraceHeaderFromContext(ctx)
+		}
 		return context.WithValue(ctx, ContextKey, seg), seg
 	}
 
@@ -272,15 +435,14 @@ func BeginSubsegment(ctx context.Context, name string) (context.Context, *Segmen
 		name = name[:200]
 	}
 
-	var parent *Segment
 	// first time to create facade segment
-	if getTraceHeaderFromContext(ctx) != nil && GetSegment(ctx) == nil {
+	if getTraceHeaderFromContext(ctx) != nil && parent == nil {
 		_, parent = newFacadeSegment(ctx)
 	} else {
-		parent = GetSegment(ctx)
 		if parent == nil {
 			cfg := GetRecorder(ctx)
 			failedMessage := fmt.Sprintf("failed to begin subsegment named '%v': segment cannot be found.", name)
+			contextMissing.add(configMetricsObserver(cfg), 1)
 			if cfg != nil && cfg.ContextMissingStrategy != nil {
 				cfg.ContextMissingStrategy.ContextMissing(failedMessage)
 			} else {
@@ -291,13 +453,19 @@ func BeginSubsegment(ctx context.Context, name string) (context.Context, *Segmen
 	}
 
 	seg := &Segment{parent: parent}
-	logger.Debugf("Beginning subsegment named %s", name)
+	parent.log().Debugf("Beginning subsegment named %s", name)
 
 	seg.Lock()
 	defer seg.Unlock()
 
 	seg.ParentSegment = parent.ParentSegment
 
+	// Hold off SetSampled until the subsegment is attached to the tree, so
+	// that it either sees the old sampling decision and is fixed up by the
+	// transition, or sees the new one.
+	seg.ParentSegment.samplingMu.RLock()
+	defer seg.ParentSegment.samplingMu.RUnlock()
+
 	// generates subsegment id based on sampling decision and AWS_XRAY_NOOP_ID env variable
 	noOpID := os.Getenv("AWS_XRAY_NOOP_ID")
 	if noOpID != "" && strings.ToLower(noOpID) == "false" {
@@ -315,23 +483,171 @@ func BeginSubsegment(ctx context.Context, name string) (context.Context, *Segmen
 		seg.Dummy = true
 	}
 
-	atomic.AddUint32(&seg.ParentSegment.totalSubSegments, 1)
+	top := parent.subtreeRoot()
+	atomic.AddUint32(&top.totalSubSegments, 1)
+
+	// Once the tree holds more subsegments than the streaming strategy
+	// allows, completed subtrees are streamed as this subsegment closes, so
+	// that long-lived segments don't keep every subsegment in memory.
+	seg.streaming = !seg.Dummy && parent.streamingStrategy().RequiresStreaming(top)
 
 	parent.Lock()
 	parent.rawSubsegments = append(parent.rawSubsegments, seg)
 	parent.openSegments++
 	parent.Unlock()
 
-	seg.Name = name
+	seg.Name = seg.normalizeName(name, seg.ParentSegment.Configuration)
 	seg.StartTime = float64(time.Now().UnixNano()) / float64(time.Second)
 	seg.InProgress = true
 	seg.Sampled = seg.ParentSegment.Sampled
 	seg.TraceID = seg.ParentSegment.TraceID
 	seg.ParentID = seg.ParentSegment.ID
 
+	if len(opts) > 0 {
+		var o segmentOptions
+		for _, opt := range opts {
+			opt(&o)
+		}
+		o.apply(seg)
+	}
+
 	return context.WithValue(ctx, ContextKey, seg), seg
 }
 
+// SetSampled changes the sampling decision of the segment tree seg belongs to.
+// Changing the decision through the Sampled field directly is not supported,
+// since it leaves the tree with dummy subsegments and, unless
+// AWS_XRAY_NOOP_ID is set to false, with no-op trace and segment IDs.
+//
+// When a tree becomes sampled, the no-op IDs handed out while it was not are
+// replaced with real ones and subsegments that already closed are accounted
+// for, so the whole tree is emitted once it completes. Headers already
+// propagated downstream keep the IDs they were sent with. An error is
+// returned if the root segment has already been closed.
+func (seg *Segment) SetSampled(sampled bool) error {
+	// If segment was created while SDK was disabled then return
+	if seg.isDisabled() {
+		return nil
+	}
+
+	root := seg.ParentSegment
+	root.samplingMu.Lock()
+	defer root.samplingMu.Unlock()
+
+	root.Lock()
+	defer root.Unlock()
+
+	if !root.InProgress {
+		return fmt.Errorf("failed to change sampling decision of segment %q: segment already closed", root.Name)
+	}
+	root.setSampled(sampled)
+	return nil
+}
+
+// PromoteToSampled samples the segment tree of seg, a segment that wasn't,
+// so that it's emitted once it completes, and annotates seg with promoted
+// set to true. It's meant to keep the traces of requests found to be slow or
+// to fail once they're underway, see also Config.PromoteOnFault.
+//
+// The sampling decision was made when seg began and services called since
+// then were sent a trace header with Sampled=0, so they didn't record their
+// part of the trace: promoted traces are partial. As with SetSampled, no-op
+// IDs are replaced with real ones. An error is returned if seg is a
+// subsegment or has already been closed.
+func (seg *Segment) PromoteToSampled() error {
+	// If segment was created while SDK was disabled then return
+	if seg.isDisabled() {
+		return nil
+	}
+	if seg.ParentSegment != seg {
+		return fmt.Errorf("failed to promote subsegment %q: only segments can be promoted", seg.Name)
+	}
+
+	seg.samplingMu.Lock()
+	defer seg.samplingMu.Unlock()
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	if !seg.InProgress {
+		return fmt.Errorf("failed to promote segment %q: segment already closed", seg.Name)
+	}
+	if seg.Sampled {
+		return nil
+	}
+
+	seg.setSampled(true)
+	if seg.Annotations == nil {
+		seg.Annotations = map[string]interface{}{}
+	}
+	seg.Annotations[promotedAnnotationKey] = true
+	return nil
+}
+
+// promoteOnFault promotes the segment tree of seg to sampled when seg is
+// closed with err, if the configuration sets PromoteOnFault. It's called
+// before locking seg to close it.
+func (seg *Segment) promoteOnFault(err error) {
+	root := seg.ParentSegment
+	if err == nil || root.Configuration == nil || !root.Configuration.PromoteOnFault {
+		return
+	}
+	if e := root.PromoteToSampled(); e != nil {
+		seg.log().Debugf("%v", e)
+	}
+}
+
+// setSampled changes the sampling decision of the segment tree of the root
+// segment seg. The caller of setSampled should hold the samplingMu and the
+// write lock of seg.
+func (seg *Segment) setSampled(sampled bool) {
+	if seg.Sampled == sampled {
+		return
+	}
+
+	seg.Sampled = sampled
+	if !seg.Facade {
+		seg.Dummy = !sampled
+	}
+	if sampled && seg.TraceID == noOpTraceID() {
+		seg.TraceID = NewTraceID()
+		seg.ID = NewSegmentID()
+	}
+
+	seg.resample(sampled)
+}
+
+// resample propagates the sampling decision of the root to the subsegments
+// of seg. The caller of resample should have write lock on seg instance.
+func (seg *Segment) resample(sampled bool) {
+	root := seg.ParentSegment
+	for _, sub := range seg.rawSubsegments {
+		sub.Lock()
+		sub.Sampled = sampled
+		sub.Dummy = !sampled
+		if sampled {
+			sub.TraceID = root.TraceID
+			sub.ParentID = root.ID
+			if sub.ID == noOpSegmentID() {
+				sub.ID = NewSegmentID()
+			}
+		}
+
+		sub.resample(sampled)
+
+		// Dummy subsegments skip send when they close, so the ones that
+		// completed while the tree was unsampled never reported back.
+		if sampled && sub.EndTime > 0 && !sub.Emitted {
+			if seg.Facade {
+				sub.flush()
+			} else if sub.openSegments == 0 {
+				seg.openSegments--
+			}
+		}
+		sub.Unlock()
+	}
+}
+
 // NewSegmentFromHeader creates a segment for downstream call and add information to the segment that gets from HTTP header.
 func NewSegmentFromHeader(ctx context.Context, name string, r *http.Request, h *header.Header) (context.Context, *Segment) {
 	con, seg := BeginSegmentWithSampling(ctx, name, r, h)
@@ -349,117 +665,241 @@ func NewSegmentFromHeader(ctx context.Context, name string, r *http.Request, h *
 	return con, seg
 }
 
-// Check if SDK is disabled
+// disabled is set to 1 by SetDisabled(true).
+var disabled int32
+
+// SDKDisabled reports whether the SDK is disabled, either by setting the
+// AWS_XRAY_SDK_DISABLED environment variable to true or passthrough, or by
+// SetDisabled. The state is checked when a segment begins and is kept by the
+// segment and its subsegments until they are closed, so toggling it only
+// affects segments created afterwards.
+func SDKDisabled() bool {
+	if atomic.LoadInt32(&disabled) == 1 {
+		return true
+	}
+	disableKey := strings.ToLower(os.Getenv("AWS_XRAY_SDK_DISABLED"))
+	return disableKey == "true" || disableKey == "passthrough"
+}
+
+// passthrough reports whether segments begun with cfg are only placeholders
+// propagating the incoming trace header, by setting the AWS_XRAY_SDK_DISABLED
+// environment variable to passthrough or by Config.Passthrough.
+func passthrough(cfg *Config) bool {
+	if strings.ToLower(os.Getenv("AWS_XRAY_SDK_DISABLED")) == "passthrough" {
+		return true
+	}
+	return cfg != nil && cfg.Passthrough || globalCfg.Passthrough()
+}
+
+// SdkDisabled reports whether the SDK is disabled.
+//
+// Deprecated: Use SDKDisabled instead.
 func SdkDisabled() bool {
-	disableKey := os.Getenv("AWS_XRAY_SDK_DISABLED")
-	return strings.ToLower(disableKey) == "true"
+	return SDKDisabled()
+}
+
+// SetDisabled disables or re-enables the SDK for subsequently created root
+// segments. Setting it to false does not override the AWS_XRAY_SDK_DISABLED
+// environment variable.
+func SetDisabled(d bool) {
+	var v int32
+	if d {
+		v = 1
+	}
+	atomic.StoreInt32(&disabled, v)
+}
+
+// isDisabled reports whether seg was created while the SDK was disabled,
+// in which case it is an empty Segment without a ParentSegment.
+func (seg *Segment) isDisabled() bool {
+	return seg.ParentSegment == nil
 }
 
 // Close a segment.
 func (seg *Segment) Close(err error) {
-	// If SDK is disabled then return
-	if SdkDisabled() {
+	if seg == nil {
+		logger.Debugf("No input subsegment to end. No-op")
 		return
 	}
 
-	if seg == nil {
-		logger.Debugf("No input subsegment to end. No-op")
+	// If segment was created while SDK was disabled then return
+	if seg.isDisabled() {
 		return
 	}
 
+	seg.promoteOnFault(err)
+	stats := seg.faultRuntimeStats(err)
+	stack := seg.slowStack(err)
+
 	seg.Lock()
 	if seg.parent != nil {
-		logger.Debugf("Closing subsegment named %s", seg.Name)
+		seg.log().Debugf("Closing subsegment named %s", seg.Name)
 	} else {
-		logger.Debugf("Closing segment named %s", seg.Name)
+		seg.log().Debugf("Closing segment named %s", seg.Name)
 	}
-	seg.EndTime = float64(time.Now().UnixNano()) / float64(time.Second)
+	seg.setEndTime()
 	seg.InProgress = false
+	if seg.flushTracked {
+		seg.flushTracked = false
+		atomic.AddInt64(&inProgressSegments, -1)
+	}
 
 	if err != nil {
 		seg.addError(err)
 	}
+	seg.addRuntimeStats(stats)
+	seg.addSlowStack(stack)
+
+	seg.recordDownstream()
 
 	cancelSegCtx := seg.cancelCtx
 
-	seg.Unlock()
+	// Dummy segments aren't sent. Others are sent without unlocking them
+	// first, so that they can't be streamed in between.
+	if seg.Dummy {
+		seg.Unlock()
+	} else {
+		streaming := seg.streaming
+		seg.sendLocked()
+		if streaming {
+			seg.streamCompleted()
+		}
+	}
 
-	// If a goroutine was started to close the extra context (made for
-	// cancelling the segment), stop the goroutine before we close the segment
-	// and lose access to it.
+	// Stop watching the context of the segment, flushing what's left of the
+	// segment tree unless it was sent above.
 	if cancelSegCtx != nil {
 		cancelSegCtx()
 	}
+}
 
-	// If segment is dummy we return
-	if seg.Dummy {
-		return
+// setEndTime sets the end time of seg to the current time. A start time set
+// after it, see WithStartTime, is replaced with the end time.
+// The caller of setEndTime should have write lock on seg instance.
+func (seg *Segment) setEndTime() {
+	seg.EndTime = float64(time.Now().UnixNano()) / float64(time.Second)
+	if seg.StartTime > seg.EndTime {
+		seg.log().Errorf("start time of segment named %s is after its end time, using the end time instead", seg.Name)
+		seg.StartTime = seg.EndTime
 	}
-
-	seg.send()
 }
 
 // CloseAndStream closes a subsegment and sends it.
 func (seg *Segment) CloseAndStream(err error) {
-	// If SDK is disabled then return
-	if SdkDisabled() {
+	// If segment was created while SDK was disabled then return
+	if seg.isDisabled() {
 		return
 	}
-	
-	if seg.parent != nil {
-		logger.Debugf("Ending subsegment named: %s", seg.Name)
-		seg.Lock()
-		seg.EndTime = float64(time.Now().UnixNano()) / float64(time.Second)
+
+	// The parent is locked before the subsegment, as when streaming completed
+	// subtrees, so that the subsegment is removed from the tree and refers to
+	// its parent consistently.
+	parent := seg.parent
+	if parent != nil {
+		parent.Lock()
+		defer parent.Unlock()
+	}
+
+	seg.Lock()
+	defer seg.Unlock()
+
+	if parent != nil {
+		seg.log().Debugf("Ending subsegment named: %s", seg.Name)
+		seg.setEndTime()
 		seg.InProgress = false
 		seg.Emitted = true
-		seg.Unlock()
-		if seg.parent.RemoveSubsegment(seg) {
-			logger.Debugf("Removing subsegment named: %s", seg.Name)
+		if parent.removeSubsegment(seg) {
+			seg.log().Debugf("Removing subsegment named: %s", seg.Name)
+			// the subsegment is streamed along with its own subsegments, except
+			// those the emitter streams and removes on their own
+			defer func() {
+				atomic.AddUint32(&parent.subtreeRoot().totalSubSegments, ^seg.subtreeSize())
+			}()
 		}
 	}
-	
-	seg.Lock()
-	defer seg.Unlock()
 
 	if err != nil {
 		seg.addError(err)
 	}
 
+	seg.recordDownstream()
+
 	// If segment is dummy we return
 	if seg.Dummy {
 		return
 	}
 
-	seg.beforeEmitSubsegment(seg.parent)
+	seg.beforeEmitSubsegment(parent)
+	atomic.AddUint32(&seg.ParentSegment.streamedSubSegments, 1)
+	subsegmentsStreamed.add(seg.metricsObserver(), 1)
+	seg.runPreEmitProcessors()
 	seg.emit()
 }
 
 // RemoveSubsegment removes a subsegment child from a segment or subsegment.
 func (seg *Segment) RemoveSubsegment(remove *Segment) bool {
 	seg.Lock()
+	removed := seg.removeSubsegment(remove)
+	seg.Unlock()
+
+	if removed {
+		atomic.AddUint32(&seg.subtreeRoot().totalSubSegments, ^uint32(0))
+	}
+	return removed
+}
 
+// removeSubsegment removes a subsegment child from seg, without updating the
+// subsegment count of the tree.
+// The caller of removeSubsegment should have write lock on seg instance.
+func (seg *Segment) removeSubsegment(remove *Segment) bool {
 	for i, v := range seg.rawSubsegments {
 		if v == remove {
 			seg.rawSubsegments[i] = seg.rawSubsegments[len(seg.rawSubsegments)-1]
 			seg.rawSubsegments[len(seg.rawSubsegments)-1] = nil
 			seg.rawSubsegments = seg.rawSubsegments[:len(seg.rawSubsegments)-1]
 			seg.openSegments--
-
-			if seg.ParentSegment != seg {
-				seg.Unlock()
-
-				atomic.AddUint32(&seg.ParentSegment.totalSubSegments, ^uint32(0))
-			} else {
-				seg.Unlock()
-			}
-
 			return true
 		}
 	}
-	seg.Unlock()
 	return false
 }
 
+// moveSubsegments moves subs, subsegments of seg, below dest, another of its
+// subsegments, which is made to start no later than them. Subsegments that
+// are no longer children of seg, as when they were streamed, are left out.
+// The subsegments moved must not be closed concurrently.
+func (seg *Segment) moveSubsegments(dest *Segment, subs []*Segment) {
+	seg.Lock()
+	defer seg.Unlock()
+	dest.Lock()
+	defer dest.Unlock()
+
+	for _, sub := range subs {
+		i := 0
+		for i < len(seg.rawSubsegments) && seg.rawSubsegments[i] != sub {
+			i++
+		}
+		if i == len(seg.rawSubsegments) {
+			continue
+		}
+		seg.rawSubsegments = append(seg.rawSubsegments[:i], seg.rawSubsegments[i+1:]...)
+		dest.rawSubsegments = append(dest.rawSubsegments, sub)
+
+		sub.Lock()
+		sub.parent = dest
+		// a subsegment counts as open in its parent until its subtree
+		// completes, see sendLocked
+		if sub.EndTime == 0 || sub.openSegments > 0 {
+			seg.openSegments--
+			dest.openSegments++
+		}
+		if sub.StartTime < dest.StartTime {
+			dest.StartTime = sub.StartTime
+		}
+		sub.Unlock()
+	}
+}
+
 func (seg *Segment) isOrphan() bool {
 	return seg.parent == nil || seg.Type == "subsegment"
 }
@@ -484,8 +924,14 @@ func (seg *Segment) handleContextDone() {
 // ready to send and sends entire subtree from the parent. The locking and traversal of the tree
 // is from child to parent. This method is thread safe.
 func (seg *Segment) send() {
+	seg.Lock()
+	seg.sendLocked()
+}
+
+// sendLocked is send for a (Sub)Segment the caller has already locked. The
+// lock is released before sendLocked returns.
+func (seg *Segment) sendLocked() {
 	s := seg
-	s.Lock()
 	for {
 		if s.flush() {
 			s.Unlock()
@@ -499,6 +945,82 @@ func (seg *Segment) send() {
 		s.Lock()
 		s.openSegments--
 	}
+
+	// Subsegments of a facade segment are emitted on their own, so their
+	// subtrees are streamed while they are open.
+	if s.subtreeRoot() != s.ParentSegment {
+		s.streamCompleted()
+	}
+}
+
+// streamCompleted streams the completed subtrees of the (sub)segment tree
+// seg belongs to, for as long as its streaming strategy requires it.
+func (seg *Segment) streamCompleted() {
+	top := seg.subtreeRoot()
+	top.Lock()
+	if !top.Emitted {
+		top.streamCompletedSubtrees(top.streamingStrategy(), top)
+	}
+	top.Unlock()
+}
+
+// StreamCompletedChildren streams the completed subsegments below seg, with
+// their own subsegments, for as long as the streaming strategy of the tree
+// requires it. The streamed subsegments are removed from the tree, so calling
+// it periodically bounds the memory held by long-lived segments.
+func (seg *Segment) StreamCompletedChildren() {
+	if seg == nil || seg.isDisabled() {
+		return
+	}
+
+	seg.Lock()
+	if !seg.Emitted {
+		seg.streamCompletedSubtrees(seg.streamingStrategy(), seg.subtreeRoot())
+	}
+	seg.Unlock()
+}
+
+// streamCompletedSubtrees streams the completed subtrees below seg, for as
+// long as top, the subsegment of a facade segment seg belongs to, requires
+// streaming. The streamed subsegments are removed from the tree.
+// The caller of streamCompletedSubtrees should have write lock on seg instance.
+func (seg *Segment) streamCompletedSubtrees(ss StreamingStrategy, top *Segment) {
+	for i := 0; i < len(seg.rawSubsegments) && ss.RequiresStreaming(top); {
+		child := seg.rawSubsegments[i]
+		child.Lock()
+		if child.EndTime == 0 || child.openSegments > 0 || child.Emitted {
+			child.streamCompletedSubtrees(ss, top)
+			child.Unlock()
+			i++
+			continue
+		}
+
+		seg.rawSubsegments = append(seg.rawSubsegments[:i], seg.rawSubsegments[i+1:]...)
+
+		child.Emitted = true
+		child.beforeEmitSubsegment(seg)
+		atomic.AddUint32(&seg.ParentSegment.streamedSubSegments, 1)
+		subsegmentsStreamed.add(seg.metricsObserver(), 1)
+		child.runPreEmitProcessors()
+		seg.log().Debugf("Streaming completed subsegment named '%s' of subsegment '%s'.", child.Name, top.Name)
+		child.emit()
+		// the child is streamed along with its own subsegments, except those
+		// the emitter streamed and removed on their own
+		atomic.AddUint32(&top.totalSubSegments, ^child.subtreeSize())
+		child.Unlock()
+	}
+}
+
+// subtreeSize returns the number of subsegments below seg.
+// The caller of subtreeSize should have lock on seg instance.
+func (seg *Segment) subtreeSize() uint32 {
+	n := uint32(len(seg.rawSubsegments))
+	for _, s := range seg.rawSubsegments {
+		s.RLock()
+		n += s.subtreeSize()
+		s.RUnlock()
+	}
+	return n
 }
 
 // flush emits (Sub)Segment, if it is ready to send.
@@ -507,11 +1029,18 @@ func (seg *Segment) flush() bool {
 	if (seg.openSegments == 0 && seg.EndTime > 0) || seg.ContextDone {
 		if seg.isOrphan() {
 			seg.Emitted = true
+			if seg.parent == nil {
+				if !seg.applyEmitFilter() {
+					return true
+				}
+				seg.runPreEmitProcessors()
+			}
 			seg.emit()
 		} else if seg.parent != nil && seg.parent.Facade {
 			seg.Emitted = true
 			seg.beforeEmitSubsegment(seg.parent)
-			logger.Debugf("emit lambda subsegment named: %v", seg.Name)
+			seg.runPreEmitProcessors()
+			seg.log().Debugf("emit lambda subsegment named: %v", seg.Name)
 			seg.emit()
 		} else {
 			return false
@@ -542,6 +1071,26 @@ func (seg *Segment) root() *Segment {
 	return seg.parent.root()
 }
 
+// subtreeRoot returns the segment whose subsegments are counted for
+// streaming: the subsegment of a facade segment seg belongs to, as it's
+// emitted on its own, or the parent segment otherwise.
+func (seg *Segment) subtreeRoot() *Segment {
+	for s := seg; s.parent != nil; s = s.parent {
+		if s.parent.Facade {
+			return s
+		}
+	}
+	return seg.ParentSegment
+}
+
+// streamingStrategy returns the streaming strategy of the segment tree.
+func (seg *Segment) streamingStrategy() StreamingStrategy {
+	if seg.ParentSegment.Configuration != nil && seg.ParentSegment.Configuration.StreamingStrategy != nil {
+		return seg.ParentSegment.Configuration.StreamingStrategy
+	}
+	return globalCfg.StreamingStrategy()
+}
+
 func (seg *Segment) addPlugin(metadata *plugins.PluginMetadata) {
 	// Only called within a seg locked code block
 	if metadata == nil {
@@ -556,6 +1105,10 @@ func (seg *Segment) addPlugin(metadata *plugins.PluginMetadata) {
 		seg.GetAWS()[plugins.ECSServiceName] = metadata.ECSMetadata
 	}
 
+	if len(metadata.LogGroups) > 0 {
+		seg.GetAWS()[plugins.CloudWatchLogsServiceName] = metadata.LogGroups
+	}
+
 	if metadata.BeanstalkMetadata != nil {
 		seg.GetAWS()[plugins.EBServiceName] = metadata.BeanstalkMetadata
 	}
@@ -566,7 +1119,11 @@ func (seg *Segment) addPlugin(metadata *plugins.PluginMetadata) {
 }
 
 func (seg *Segment) addSDKAndServiceInformation() {
-	seg.GetAWS()["xray"] = SDK{Version: SDKVersion, Type: SDKType}
+	seg.GetAWS()["xray"] = SDK{
+		Version:  SDKVersion,
+		Type:     SDKType,
+		Metadata: seg.GetConfiguration().InstrumentationMetadata,
+	}
 
 	seg.GetService().Runtime = runtime.Compiler
 	seg.GetService().RuntimeVersion = runtime.Version()
@@ -580,10 +1137,14 @@ func (seg *Segment) beforeEmitSubsegment(s *Segment) {
 	seg.RequestWasTraced = s.RequestWasTraced
 }
 
-// AddAnnotation allows adding an annotation to the segment.
+// AddAnnotation allows adding an annotation to the segment. The value must be
+// a string, a number or a boolean, and the key must consist of ASCII letters,
+// digits and underscores for X-Ray to index it; otherwise the returned error
+// wraps ErrUnsupportedAnnotationType or ErrInvalidAnnotationKey. Integers of
+// any size are recorded as int64 or uint64.
 func (seg *Segment) AddAnnotation(key string, value interface{}) error {
-	// If SDK is disabled then return
-	if SdkDisabled() {
+	// If segment was created while SDK was disabled then return
+	if seg.isDisabled() {
 		return nil
 	}
 
@@ -595,23 +1156,178 @@ func (seg *Segment) AddAnnotation(key string, value interface{}) error {
 		return nil
 	}
 
-	switch value.(type) {
-	case bool, int, uint, float32, float64, string:
-	default:
-		return fmt.Errorf("failed to add annotation key: %q value: %q to subsegment %q. value must be of type string, number or boolean", key, value, seg.Name)
+	v, err := checkAnnotation(key, value)
+	if err != nil {
+		kind := "subsegment"
+		if seg.ParentSegment == seg {
+			kind = "segment"
+		}
+		return fmt.Errorf("failed to add annotation to %s %q: %w", kind, seg.Name, err)
 	}
 
 	if seg.Annotations == nil {
 		seg.Annotations = map[string]interface{}{}
 	}
-	seg.Annotations[key] = value
+	seg.Annotations[key] = v
+	return nil
+}
+
+// maxFeatureFlags bounds the number of feature flags recorded for a trace.
+const maxFeatureFlags = 100
+
+// AddFeatureFlag records the variant of a feature flag evaluated during the
+// request in the "feature_flags" metadata namespace of the root segment.
+// Recording the same flag again overwrites the previous variant.
+func (seg *Segment) AddFeatureFlag(flagName string, variant interface{}) error {
+	return seg.AddFeatureFlags(map[string]interface{}{flagName: variant})
+}
+
+// AddFeatureFlags records the variants of several feature flags, see AddFeatureFlag.
+func (seg *Segment) AddFeatureFlags(flags map[string]interface{}) error {
+	if seg.isDisabled() {
+		return nil
+	}
+
+	root := seg.rootDocument()
+
+	root.Lock()
+	defer root.Unlock()
+
+	// If segment is dummy we return
+	if root.Dummy {
+		return nil
+	}
+
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errors exception.MultiError
+	for _, name := range names {
+		variant, ok := annotationValue(flags[name])
+		if !ok {
+			errors = append(errors, fmt.Errorf("failed to add feature flag: %q variant: %q. variant must be of type string, number or boolean", name, flags[name]))
+			continue
+		}
+
+		if root.Metadata == nil {
+			root.Metadata = map[string]map[string]interface{}{}
+		}
+		if root.Metadata["feature_flags"] == nil {
+			root.Metadata["feature_flags"] = map[string]interface{}{}
+		}
+		recorded := root.Metadata["feature_flags"]
+		if _, ok := recorded[name]; !ok && len(recorded) >= maxFeatureFlags {
+			errors = append(errors, fmt.Errorf("failed to add feature flag: %q. at most %d feature flags can be recorded", name, maxFeatureFlags))
+			continue
+		}
+		recorded[name] = variant
+	}
+
+	switch len(errors) {
+	case 0:
+		return nil
+	case 1:
+		return errors[0]
+	default:
+		return errors
+	}
+}
+
+// rootDocument returns the root segment of seg, or the topmost
+// subsegment when the root is a Lambda facade segment, which is not emitted.
+func (seg *Segment) rootDocument() *Segment {
+	s := seg
+	for s.parent != nil && !s.parent.Facade {
+		s = s.parent
+	}
+	return s
+}
+
+// maxPIICategories bounds the number of PII categories recorded for a trace.
+const maxPIICategories = 20
+
+// SetPIIFlag marks the trace as containing personally identifiable
+// information, so that trace processing pipelines can handle it differently.
+// It sets the "pii" annotation to true on the root segment and records the
+// given categories, such as "email" or "payment", in the "pii" metadata
+// namespace. Calling it again adds the new categories to the recorded ones.
+func (seg *Segment) SetPIIFlag(categories ...string) error {
+	if seg.isDisabled() {
+		return nil
+	}
+
+	root := seg.rootDocument()
+
+	root.Lock()
+	defer root.Unlock()
+
+	// If segment is dummy we return
+	if root.Dummy {
+		return nil
+	}
+
+	if root.Annotations == nil {
+		root.Annotations = map[string]interface{}{}
+	}
+	root.Annotations["pii"] = true
+
+	if root.Metadata == nil {
+		root.Metadata = map[string]map[string]interface{}{}
+	}
+	if root.Metadata["pii"] == nil {
+		root.Metadata["pii"] = map[string]interface{}{}
+	}
+	recorded, _ := root.Metadata["pii"]["categories"].([]string)
+
+	var dropped []string
+	for _, category := range categories {
+		i := sort.SearchStrings(recorded, category)
+		if i < len(recorded) && recorded[i] == category {
+			continue
+		}
+		if len(recorded) >= maxPIICategories {
+			dropped = append(dropped, category)
+			continue
+		}
+		recorded = append(recorded, "")
+		copy(recorded[i+1:], recorded[i:])
+		recorded[i] = category
+	}
+	root.Metadata["pii"]["categories"] = recorded
+
+	if len(dropped) > 0 {
+		return fmt.Errorf("failed to add pii categories: %q. at most %d categories can be recorded", dropped, maxPIICategories)
+	}
 	return nil
 }
 
+// addDeadlineAnnotation annotates the segment with the milliseconds left until
+// the deadline of ctx. Contexts without a deadline are skipped.
+// Only called within a seg locked code block.
+func (seg *Segment) addDeadlineAnnotation(ctx context.Context, key string) {
+	deadline, ok := ctx.Deadline()
+	if !ok || seg.Dummy {
+		return
+	}
+
+	remaining := time.Until(deadline).Milliseconds()
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	if seg.Annotations == nil {
+		seg.Annotations = map[string]interface{}{}
+	}
+	seg.Annotations[key] = int(remaining)
+}
+
 // AddMetadata allows adding metadata to the segment.
 func (seg *Segment) AddMetadata(key string, value interface{}) error {
-	// If SDK is disabled then return
-	if SdkDisabled() {
+	// If segment was created while SDK was disabled then return
+	if seg.isDisabled() {
 		return nil
 	}
 
@@ -635,8 +1351,8 @@ func (seg *Segment) AddMetadata(key string, value interface{}) error {
 
 // AddMetadataToNamespace allows adding a namespace into metadata for the segment.
 func (seg *Segment) AddMetadataToNamespace(namespace string, key string, value interface{}) error {
-	// If SDK is disabled then return
-	if SdkDisabled() {
+	// If segment was created while SDK was disabled then return
+	if seg.isDisabled() {
 		return nil
 	}
 
@@ -660,21 +1376,37 @@ func (seg *Segment) AddMetadataToNamespace(namespace string, key string, value i
 
 // AddError allows adding an error to the segment.
 func (seg *Segment) AddError(err error) error {
-	// If SDK is disabled then return
-	if SdkDisabled() {
+	// If segment was created while SDK was disabled then return
+	if seg.isDisabled() {
 		return nil
 	}
 
+	stats := seg.faultRuntimeStats(err)
+
 	seg.Lock()
 	defer seg.Unlock()
 
 	seg.addError(err)
+	seg.addRuntimeStats(stats)
 
 	return nil
 }
 
 func (seg *Segment) addError(err error) {
 	seg.Fault = true
+	seg.addCause(err)
+}
+
+// addCause records err in the cause of seg, without marking it as a fault.
+// Strategies implementing exception.ChainFormattingStrategy record the errors
+// err wraps as well.
+// The caller of addCause should have write lock on seg instance.
+func (seg *Segment) addCause(err error) {
 	seg.GetCause().WorkingDirectory, _ = os.Getwd()
-	seg.GetCause().Exceptions = append(seg.GetCause().Exceptions, seg.ParentSegment.GetConfiguration().ExceptionFormattingStrategy.ExceptionFromError(err))
+	strategy := seg.ParentSegment.GetConfiguration().ExceptionFormattingStrategy
+	if chain, ok := strategy.(exception.ChainFormattingStrategy); ok {
+		seg.GetCause().Exceptions = append(seg.GetCause().Exceptions, chain.ExceptionsFromError(err)...)
+		return
+	}
+	seg.GetCause().Exceptions = append(seg.GetCause().Exceptions, strategy.ExceptionFromError(err))
 }
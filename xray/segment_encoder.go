@@ -0,0 +1,384 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// maxPooledBufferSize is the capacity above which encode buffers aren't
+// returned to the pool, so that a few large segments don't pin memory.
+const maxPooledBufferSize = 64 * 1024
+
+var encodeBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 2048)
+		return &b
+	},
+}
+
+func getEncodeBuffer() *[]byte {
+	return encodeBufferPool.Get().(*[]byte)
+}
+
+func putEncodeBuffer(b *[]byte) {
+	if cap(*b) > maxPooledBufferSize {
+		return
+	}
+	*b = (*b)[:0]
+	encodeBufferPool.Put(b)
+}
+
+// marshalSegment returns the JSON encoding of s, as json.Marshal would.
+// s is encoded into a pooled buffer, and copied once to the returned slice.
+func marshalSegment(s *Segment) ([]byte, error) {
+	buf := getEncodeBuffer()
+	defer putEncodeBuffer(buf)
+
+	var err error
+	if *buf, err = appendSegment((*buf)[:0], s); err != nil {
+		return nil, err
+	}
+	b := make([]byte, len(*buf))
+	copy(b, *buf)
+	return b, nil
+}
+
+// appendSegment appends the JSON encoding of s to dst. The fields are
+// written in the order and with the omitempty behavior encoding/json uses
+// for Segment.MarshalJSON. Fields of types without a fixed shape, such as
+// annotations and metadata, are encoded by encoding/json.
+// Subsegments are expected to hold JSON produced by appendSegment, and are
+// copied as is.
+func appendSegment(dst []byte, s *Segment) ([]byte, error) {
+	if err := checkEpochSeconds(s.StartTime); err != nil {
+		return dst, err
+	}
+	if err := checkEpochSeconds(s.EndTime); err != nil {
+		return dst, err
+	}
+
+	var err error
+	start := len(dst)
+	if s.TraceID != "" {
+		dst = appendStringField(dst, "trace_id", s.TraceID)
+	}
+	dst = appendStringField(dst, "id", s.ID)
+	dst = appendStringField(dst, "name", s.Name)
+	if s.InProgress {
+		dst = appendBoolField(dst, "in_progress", true)
+	}
+	if s.ParentID != "" {
+		dst = appendStringField(dst, "parent_id", s.ParentID)
+	}
+	if s.Fault {
+		dst = appendBoolField(dst, "fault", true)
+	}
+	if s.Error {
+		dst = appendBoolField(dst, "error", true)
+	}
+	if s.Throttle {
+		dst = appendBoolField(dst, "throttle", true)
+	}
+	if s.Cause != nil {
+		if dst, err = appendValueField(dst, "cause", s.Cause); err != nil {
+			return dst, err
+		}
+	}
+	if s.ResourceARN != "" {
+		dst = appendStringField(dst, "resource_arn", s.ResourceARN)
+	}
+	if s.Origin != "" {
+		dst = appendStringField(dst, "origin", s.Origin)
+	}
+	if s.Type != "" {
+		dst = appendStringField(dst, "type", s.Type)
+	}
+	if s.Namespace != "" {
+		dst = appendStringField(dst, "namespace", s.Namespace)
+	}
+	if s.User != "" {
+		dst = appendStringField(dst, "user", s.User)
+	}
+	if len(s.PrecursorIDs) > 0 {
+		dst = appendKey(dst, "precursor_ids")
+		for i, id := range s.PrecursorIDs {
+			if i == 0 {
+				dst = append(dst, '[')
+			} else {
+				dst = append(dst, ',')
+			}
+			dst = appendJSONString(dst, id)
+		}
+		dst = append(dst, ']')
+	}
+	if s.HTTP != nil {
+		dst = appendHTTPData(appendKey(dst, "http"), s.HTTP)
+	}
+	if sdk, ok := s.AWS["xray"].(SDK); ok && len(s.AWS) == 1 && len(sdk.Metadata) == 0 {
+		// the aws object of most segments only holds the SDK fields
+		dst = appendSDK(append(appendKey(dst, "aws"), `{"xray":`...), sdk)
+		dst = append(dst, '}')
+	} else if len(s.AWS) > 0 {
+		if dst, err = appendValueField(dst, "aws", s.AWS); err != nil {
+			return dst, err
+		}
+	}
+	if s.Service != nil {
+		dst = appendServiceData(appendKey(dst, "service"), s.Service)
+	}
+	if s.SQL != nil {
+		dst = appendSQLData(appendKey(dst, "sql"), s.SQL)
+	}
+	if len(s.Annotations) > 0 {
+		if dst, err = appendValueField(dst, "annotations", s.Annotations); err != nil {
+			return dst, err
+		}
+	}
+	if len(s.Metadata) > 0 {
+		if dst, err = appendValueField(dst, "metadata", s.Metadata); err != nil {
+			return dst, err
+		}
+	}
+	if len(s.Subsegments) > 0 {
+		dst = appendKey(dst, "subsegments")
+		for i, raw := range s.Subsegments {
+			if i == 0 {
+				dst = append(dst, '[')
+			} else {
+				dst = append(dst, ',')
+			}
+			if raw == nil {
+				dst = append(dst, "null"...)
+				continue
+			}
+			dst = append(dst, raw...)
+		}
+		dst = append(dst, ']')
+	}
+	dst = appendBoolField(dst, "Dummy", s.Dummy)
+	dst = epochSeconds(s.StartTime).appendTo(appendKey(dst, "start_time"))
+	if s.EndTime != 0 {
+		dst = epochSeconds(s.EndTime).appendTo(appendKey(dst, "end_time"))
+	}
+	return closeObject(dst, start), nil
+}
+
+func appendHTTPData(dst []byte, d *HTTPData) []byte {
+	start := len(dst)
+	if r := d.Request; r != nil {
+		dst = appendKey(dst, "request")
+		reqStart := len(dst)
+		if r.Method != "" {
+			dst = appendStringField(dst, "method", r.Method)
+		}
+		if r.URL != "" {
+			dst = appendStringField(dst, "url", r.URL)
+		}
+		if r.ClientIP != "" {
+			dst = appendStringField(dst, "client_ip", r.ClientIP)
+		}
+		if r.UserAgent != "" {
+			dst = appendStringField(dst, "user_agent", r.UserAgent)
+		}
+		if r.XForwardedFor {
+			dst = appendBoolField(dst, "x_forwarded_for", true)
+		}
+		if r.Traced {
+			dst = appendBoolField(dst, "traced", true)
+		}
+		if r.ContentLength != 0 {
+			dst = strconv.AppendInt(appendKey(dst, "content_length"), int64(r.ContentLength), 10)
+		}
+		dst = closeObject(dst, reqStart)
+	}
+	if r := d.Response; r != nil {
+		dst = appendKey(dst, "response")
+		respStart := len(dst)
+		if r.Status != 0 {
+			dst = strconv.AppendInt(appendKey(dst, "status"), int64(r.Status), 10)
+		}
+		if r.ContentLength != 0 {
+			dst = strconv.AppendInt(appendKey(dst, "content_length"), int64(r.ContentLength), 10)
+		}
+		dst = closeObject(dst, respStart)
+	}
+	return closeObject(dst, start)
+}
+
+func appendSDK(dst []byte, sdk SDK) []byte {
+	start := len(dst)
+	if sdk.Version != "" {
+		dst = appendStringField(dst, "sdk_version", sdk.Version)
+	}
+	if sdk.Type != "" {
+		dst = appendStringField(dst, "sdk", sdk.Type)
+	}
+	if sdk.RuleName != "" {
+		dst = appendStringField(dst, "sampling_rule_name", sdk.RuleName)
+	}
+	return closeObject(dst, start)
+}
+
+func appendServiceData(dst []byte, d *ServiceData) []byte {
+	start := len(dst)
+	if d.Version != "" {
+		dst = appendStringField(dst, "version", d.Version)
+	}
+	if d.RuntimeVersion != "" {
+		dst = appendStringField(dst, "runtime_version", d.RuntimeVersion)
+	}
+	if d.Runtime != "" {
+		dst = appendStringField(dst, "runtime", d.Runtime)
+	}
+	return closeObject(dst, start)
+}
+
+func appendSQLData(dst []byte, d *SQLData) []byte {
+	start := len(dst)
+	if d.ConnectionString != "" {
+		dst = appendStringField(dst, "connection_string", d.ConnectionString)
+	}
+	if d.URL != "" {
+		dst = appendStringField(dst, "url", d.URL)
+	}
+	if d.DatabaseType != "" {
+		dst = appendStringField(dst, "database_type", d.DatabaseType)
+	}
+	if d.DatabaseVersion != "" {
+		dst = appendStringField(dst, "database_version", d.DatabaseVersion)
+	}
+	if d.DriverVersion != "" {
+		dst = appendStringField(dst, "driver_version", d.DriverVersion)
+	}
+	if d.User != "" {
+		dst = appendStringField(dst, "user", d.User)
+	}
+	if d.Preparation != "" {
+		dst = appendStringField(dst, "preparation", d.Preparation)
+	}
+	if d.SanitizedQuery != "" {
+		dst = appendStringField(dst, "sanitized_query", d.SanitizedQuery)
+	}
+	return closeObject(dst, start)
+}
+
+// appendKey appends a comma followed by the quoted key and a colon. The
+// comma of the first field of an object is replaced by the opening brace in
+// closeObject.
+func appendKey(dst []byte, key string) []byte {
+	dst = append(dst, ',', '"')
+	dst = append(dst, key...)
+	return append(dst, '"', ':')
+}
+
+// closeObject terminates the object whose fields were appended to dst from
+// start.
+func closeObject(dst []byte, start int) []byte {
+	if len(dst) == start {
+		return append(dst, '{', '}')
+	}
+	dst[start] = '{'
+	return append(dst, '}')
+}
+
+func appendStringField(dst []byte, key, value string) []byte {
+	return appendJSONString(appendKey(dst, key), value)
+}
+
+func appendBoolField(dst []byte, key string, value bool) []byte {
+	return strconv.AppendBool(appendKey(dst, key), value)
+}
+
+func appendValueField(dst []byte, key string, value interface{}) ([]byte, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return dst, err
+	}
+	return append(appendKey(dst, key), b...), nil
+}
+
+func checkEpochSeconds(f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return &json.UnsupportedValueError{Value: reflect.ValueOf(f), Str: strconv.FormatFloat(f, 'g', -1, 64)}
+	}
+	return nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// controlEscapes and invalidUTF8Escape hold what encoding/json writes for the
+// control characters and each invalid UTF-8 byte, which depends on the Go
+// version.
+var (
+	controlEscapes = func() (escapes [' ']string) {
+		for c := 0; c < ' '; c++ {
+			escapes[c] = jsonStringContent(string(rune(c)))
+		}
+		return escapes
+	}()
+	invalidUTF8Escape = jsonStringContent("\xff")
+)
+
+// jsonStringContent returns the JSON encoding of s without its quotes.
+func jsonStringContent(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+// appendJSONString appends s quoted and escaped as encoding/json does,
+// including the escaping of HTML characters and the replacement of invalid
+// UTF-8.
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= ' ' && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				dst = append(dst, '\\', c)
+			case '<', '>', '&':
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			default:
+				dst = append(dst, controlEscapes[c]...)
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, invalidUTF8Escape...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
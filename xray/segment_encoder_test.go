@@ -0,0 +1,359 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/exception"
+	"github.com/stretchr/testify/assert"
+)
+
+// marshalSegmentReference encodes s with encoding/json, the way
+// Segment.MarshalJSON did before appendSegment.
+func marshalSegmentReference(s *Segment) ([]byte, error) {
+	type segment Segment // without the MarshalJSON method
+	return json.Marshal(struct {
+		*segment
+		StartTime epochSeconds `json:"start_time"`
+		EndTime   epochSeconds `json:"end_time,omitempty"`
+	}{(*segment)(s), epochSeconds(s.StartTime), epochSeconds(s.EndTime)})
+}
+
+// encoderTestStrings exercise the escaping of encoding/json.
+var encoderTestStrings = []string{
+	"",
+	"plain",
+	"GET /users?id=1&name=<b>",
+	`quote " and backslash \`,
+	"control \x00\x01\b\f\n\r\t\x1f\x7f",
+	"invalid \xff\xfe utf-8 \xe2\x82",
+	"separators \u2028 \u2029",
+	"unicode é 世界 😀",
+	"SELECT * FROM users WHERE name = $1",
+}
+
+func randomEncoderTestString(r *rand.Rand) string {
+	if r.Intn(4) > 0 {
+		return encoderTestStrings[r.Intn(len(encoderTestStrings))]
+	}
+	b := make([]byte, r.Intn(16))
+	r.Read(b)
+	return string(b)
+}
+
+func randomEncoderTestValue(r *rand.Rand) interface{} {
+	switch r.Intn(5) {
+	case 0:
+		return randomEncoderTestString(r)
+	case 1:
+		return r.Intn(1000) - 500
+	case 2:
+		return r.Float64() * 1e6
+	case 3:
+		return r.Intn(2) == 0
+	default:
+		return map[string]interface{}{randomEncoderTestString(r): []interface{}{randomEncoderTestString(r), r.Intn(10)}}
+	}
+}
+
+// randomEncoderTestSegment returns a segment with a random subset of its
+// fields set.
+func randomEncoderTestSegment(r *rand.Rand) *Segment {
+	s := &Segment{
+		ID:        randomEncoderTestString(r),
+		Name:      randomEncoderTestString(r),
+		StartTime: 1709112300 + r.Float64()*1e6,
+	}
+	set := func() bool { return r.Intn(2) == 0 }
+	if set() {
+		s.TraceID = randomEncoderTestString(r)
+	}
+	if set() {
+		s.EndTime = s.StartTime + r.Float64()
+	}
+	s.InProgress, s.Fault, s.Error, s.Throttle, s.Dummy = set(), set(), set(), set(), set()
+	if set() {
+		s.ParentID = randomEncoderTestString(r)
+	}
+	if set() {
+		s.Cause = &CauseData{WorkingDirectory: randomEncoderTestString(r)}
+		if set() {
+			s.Cause.Exceptions = []exception.Exception{{
+				ID:      randomEncoderTestString(r),
+				Type:    randomEncoderTestString(r),
+				Message: randomEncoderTestString(r),
+				Stack:   []exception.Stack{{Path: randomEncoderTestString(r), Line: r.Intn(100), Label: randomEncoderTestString(r)}},
+				Remote:  set(),
+			}}
+		}
+	}
+	if set() {
+		s.ResourceARN = randomEncoderTestString(r)
+	}
+	if set() {
+		s.Origin = randomEncoderTestString(r)
+	}
+	if set() {
+		s.Type = randomEncoderTestString(r)
+	}
+	if set() {
+		s.Namespace = randomEncoderTestString(r)
+	}
+	if set() {
+		s.User = randomEncoderTestString(r)
+	}
+	if set() {
+		s.PrecursorIDs = []string{}
+		for i := r.Intn(3); i > 0; i-- {
+			s.PrecursorIDs = append(s.PrecursorIDs, randomEncoderTestString(r))
+		}
+	}
+	if set() {
+		s.HTTP = &HTTPData{}
+		if set() {
+			s.HTTP.Request = &RequestData{}
+			if set() {
+				s.HTTP.Request = &RequestData{
+					Method:        randomEncoderTestString(r),
+					URL:           randomEncoderTestString(r),
+					ClientIP:      randomEncoderTestString(r),
+					UserAgent:     randomEncoderTestString(r),
+					XForwardedFor: set(),
+					Traced:        set(),
+					ContentLength: r.Intn(3) - 1,
+				}
+			}
+		}
+		if set() {
+			s.HTTP.Response = &ResponseData{Status: r.Intn(600), ContentLength: r.Intn(3)}
+		}
+	}
+	if set() {
+		s.AWS = map[string]interface{}{}
+		if set() {
+			sdk := SDK{Version: randomEncoderTestString(r), Type: "X-Ray for Go", RuleName: randomEncoderTestString(r)}
+			if set() {
+				sdk.Metadata = map[string]string{"team": randomEncoderTestString(r)}
+			}
+			s.AWS["xray"] = sdk
+		}
+		if set() {
+			s.AWS[randomEncoderTestString(r)] = randomEncoderTestValue(r)
+		}
+	}
+	if set() {
+		s.Service = &ServiceData{}
+		if set() {
+			s.Service = &ServiceData{Version: randomEncoderTestString(r), RuntimeVersion: randomEncoderTestString(r), Runtime: "go"}
+		}
+	}
+	if set() {
+		s.SQL = &SQLData{}
+		if set() {
+			s.SQL = &SQLData{
+				ConnectionString: randomEncoderTestString(r),
+				URL:              randomEncoderTestString(r),
+				DatabaseType:     randomEncoderTestString(r),
+				DatabaseVersion:  randomEncoderTestString(r),
+				DriverVersion:    randomEncoderTestString(r),
+				User:             randomEncoderTestString(r),
+				Preparation:      randomEncoderTestString(r),
+				SanitizedQuery:   randomEncoderTestString(r),
+			}
+		}
+	}
+	if set() {
+		s.Annotations = map[string]interface{}{}
+		for i := r.Intn(4); i > 0; i-- {
+			s.Annotations[randomEncoderTestString(r)] = randomEncoderTestValue(r)
+		}
+	}
+	if set() {
+		s.Metadata = map[string]map[string]interface{}{}
+		for i := r.Intn(3); i > 0; i-- {
+			s.Metadata[randomEncoderTestString(r)] = map[string]interface{}{randomEncoderTestString(r): randomEncoderTestValue(r)}
+		}
+	}
+	return s
+}
+
+func TestAppendSegmentMatchesEncodingJSON(t *testing.T) {
+	// newTree returns a random segment with nested subsegments encoded by
+	// marshal. The same seed gives the same tree.
+	newTree := func(seed int64, marshal func(*Segment) ([]byte, error)) *Segment {
+		r := rand.New(rand.NewSource(seed))
+		s := randomEncoderTestSegment(r)
+		for i := r.Intn(3); i > 0; i-- {
+			child := randomEncoderTestSegment(r)
+			grandchild := randomEncoderTestSegment(r)
+
+			b, err := marshal(grandchild)
+			assert.NoError(t, err)
+			child.Subsegments = []json.RawMessage{b}
+			b, err = marshal(child)
+			assert.NoError(t, err)
+			s.Subsegments = append(s.Subsegments, b)
+		}
+		return s
+	}
+
+	for seed := int64(0); seed < 2000; seed++ {
+		want, err := marshalSegmentReference(newTree(seed, marshalSegmentReference))
+		assert.NoError(t, err)
+
+		s := newTree(seed, marshalSegment)
+		got, err := marshalSegment(s)
+		assert.NoError(t, err)
+		if !assert.Equal(t, string(want), string(got), fmt.Sprintf("seed %d", seed)) {
+			return
+		}
+
+		// json.Marshal goes through Segment.MarshalJSON
+		got, err = json.Marshal(s)
+		assert.NoError(t, err)
+		assert.Equal(t, string(want), string(got), fmt.Sprintf("seed %d", seed))
+	}
+}
+
+func TestAppendSegmentInvalidTimes(t *testing.T) {
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		_, err := marshalSegment(&Segment{ID: "0000000000000001", Name: "test", StartTime: f})
+		assert.Error(t, err)
+		_, err = marshalSegment(&Segment{ID: "0000000000000001", Name: "test", StartTime: 1, EndTime: f})
+		assert.Error(t, err)
+		_, err = json.Marshal(&Segment{ID: "0000000000000001", Name: "test", StartTime: f})
+		assert.Error(t, err)
+	}
+}
+
+func FuzzAppendJSONString(f *testing.F) {
+	for _, s := range encoderTestStrings {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		want, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := appendJSONString(nil, s); string(got) != string(want) {
+			t.Fatalf("appendJSONString(%q) = %s, want %s", s, got, want)
+		}
+	})
+}
+
+// newEncoderBenchmarkSegment returns a sampled segment tree typical of a
+// traced HTTP request making a SQL query and a downstream call.
+func newEncoderBenchmarkSegment() *Segment {
+	seg := &Segment{
+		TraceID:   "1-5759e988-bd862e3fe1be46a994272793",
+		ID:        "53995c3f42cd8ad8",
+		Name:      "api",
+		StartTime: 1709112300.123456,
+		EndTime:   1709112300.323456,
+		Origin:    "AWS::EC2::Instance",
+		HTTP: &HTTPData{
+			Request:  &RequestData{Method: "GET", URL: "https://example.com/users/42", ClientIP: "10.0.0.1", UserAgent: "curl/8.0.1"},
+			Response: &ResponseData{Status: 200, ContentLength: 1024},
+		},
+		AWS:         map[string]interface{}{"xray": SDK{Version: SDKVersion, Type: SDKType}},
+		Service:     &ServiceData{Version: "1.0.0", RuntimeVersion: "go1.19", Runtime: "go"},
+		Annotations: map[string]interface{}{"user": "42"},
+		Sampled:     true,
+	}
+	seg.ParentSegment = seg
+
+	query := &Segment{
+		parent:        seg,
+		ParentSegment: seg,
+		ID:            "6b1dc2a4aa2cbb2c",
+		Name:          "users@db.example.com",
+		Namespace:     "remote",
+		StartTime:     1709112300.133456,
+		EndTime:       1709112300.143456,
+		SQL:           &SQLData{URL: "db.example.com:5432/users", DatabaseType: "Postgres", Preparation: "statement", SanitizedQuery: "SELECT * FROM users WHERE id = $1"},
+	}
+	call := &Segment{
+		parent:        seg,
+		ParentSegment: seg,
+		ID:            "2a3c4e8f0b1d5c7e",
+		Name:          "orders.example.com",
+		Namespace:     "remote",
+		StartTime:     1709112300.153456,
+		EndTime:       1709112300.303456,
+		HTTP: &HTTPData{
+			Request:  &RequestData{Method: "GET", URL: "https://orders.example.com/users/42/orders"},
+			Response: &ResponseData{Status: 200},
+		},
+	}
+	seg.rawSubsegments = []*Segment{query, call}
+	return seg
+}
+
+// resetEncoderBenchmarkSegment drops the subsegments encoded by a previous
+// emission of seg.
+func resetEncoderBenchmarkSegment(seg *Segment) {
+	seg.Subsegments = seg.Subsegments[:0]
+	for _, s := range seg.rawSubsegments {
+		resetEncoderBenchmarkSegment(s)
+	}
+}
+
+func BenchmarkEmit(b *testing.B) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	go drain(conn)
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	emitter, err := NewDefaultEmitter(addr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	emitter.RefreshEmitterWithAddress(addr)
+
+	seg := newEncoderBenchmarkSegment()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resetEncoderBenchmarkSegment(seg)
+		emitter.Emit(seg)
+	}
+}
+
+func BenchmarkMarshalSegment(b *testing.B) {
+	seg := newEncoderBenchmarkSegment()
+	seg.Lock()
+	packSegments(seg, nil)
+	seg.Unlock()
+
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := marshalSegmentReference(seg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := marshalSegment(seg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
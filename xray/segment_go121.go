@@ -0,0 +1,36 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+//go:build go1.21
+// +build go1.21
+
+package xray
+
+import "context"
+
+// watchContext calls seg.handleContextDone once ctx is done, or when the
+// returned function is called, whichever happens first. No goroutine is
+// started unless ctx is done before the segment is closed, or the segment
+// is closed before its subsegments.
+func watchContext(ctx context.Context, seg *Segment) context.CancelFunc {
+	stop := context.AfterFunc(ctx, seg.handleContextDone)
+	return func() {
+		if !stop() {
+			return
+		}
+		seg.RLock()
+		emitted := seg.Emitted
+		seg.RUnlock()
+		// Like the goroutine of segment_prego121.go, flush the incomplete
+		// tree asynchronously, leaving its subsegments closing along with
+		// the segment a chance to be recorded.
+		if !emitted {
+			go seg.handleContextDone()
+		}
+	}
+}
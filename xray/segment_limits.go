@@ -0,0 +1,118 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"strconv"
+	"unicode/utf8"
+)
+
+// truncatedAnnotation is set on documents whose values were truncated, or
+// metadata dropped, to fit the limits of Config.
+const truncatedAnnotation = "xray_truncated"
+
+// marshalEmittedSegment returns the JSON encoding of s, applying the
+// MaxMetadataValueBytes and MaxSegmentSizeBytes limits of its configuration
+// first. s has a write lock acquired by the caller.
+func marshalEmittedSegment(s *Segment) ([]byte, error) {
+	var valueLimit, sizeLimit int
+	if s.ParentSegment != nil && s.ParentSegment.Configuration != nil {
+		valueLimit = s.ParentSegment.Configuration.MaxMetadataValueBytes
+		sizeLimit = s.ParentSegment.Configuration.MaxSegmentSizeBytes
+	}
+
+	if valueLimit > 0 && s.truncateValues(valueLimit) {
+		s.markTruncated()
+	}
+	b, err := marshalSegment(s)
+	if err != nil || sizeLimit <= 0 || len(b) <= sizeLimit {
+		return b, err
+	}
+
+	if s.dropMetadata() {
+		s.markTruncated()
+		if b, err = marshalSegment(s); err != nil {
+			return b, err
+		}
+	}
+	if len(b) > sizeLimit {
+		s.log().Warnf("Segment %q is %d bytes without its metadata, more than MaxSegmentSizeBytes %d.", s.Name, len(b), sizeLimit)
+	}
+	return b, nil
+}
+
+// truncateValues truncates the metadata values and string annotations of s
+// longer than limit bytes, and reports whether any was.
+func (s *Segment) truncateValues(limit int) bool {
+	truncated := false
+	for key, value := range s.Annotations {
+		if v, ok := value.(string); ok && len(v) > limit {
+			s.Annotations[key] = truncateString(v, limit)
+			truncated = true
+		}
+	}
+	for _, values := range s.Metadata {
+		for key, value := range values {
+			if v, ok := truncateValue(value, limit); ok {
+				values[key] = v
+				truncated = true
+			}
+		}
+	}
+	return truncated
+}
+
+// dropMetadata drops the metadata of s but the pii namespace, and reports
+// whether there was any.
+func (s *Segment) dropMetadata() bool {
+	dropped := false
+	for namespace := range s.Metadata {
+		if namespace != "pii" {
+			delete(s.Metadata, namespace)
+			dropped = true
+		}
+	}
+	return dropped
+}
+
+func (s *Segment) markTruncated() {
+	if s.Annotations == nil {
+		s.Annotations = map[string]interface{}{}
+	}
+	s.Annotations[truncatedAnnotation] = true
+}
+
+// truncateValue returns value truncated to limit bytes, as a string, if its
+// JSON encoding is longer than limit bytes. Strings are measured and
+// truncated before being encoded.
+func truncateValue(value interface{}, limit int) (interface{}, bool) {
+	if v, ok := value.(string); ok {
+		if len(v) <= limit {
+			return value, false
+		}
+		return truncateString(v, limit), true
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil || len(b) <= limit {
+		return value, false
+	}
+	return truncateString(string(b), limit), true
+}
+
+// truncateString returns the first limit bytes of s, without splitting a
+// UTF-8 sequence, followed by a marker telling how many bytes were cut.
+func truncateString(s string, limit int) string {
+	n := limit
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "...[truncated " + strconv.Itoa(len(s)-n) + " bytes]"
+}
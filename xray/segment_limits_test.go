@@ -0,0 +1,112 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateString(t *testing.T) {
+	assert.Equal(t, "abc...[truncated 2 bytes]", truncateString("abcde", 3))
+	// the 2 bytes of é aren't split
+	assert.Equal(t, "a...[truncated 3 bytes]", truncateString("aéb", 2))
+}
+
+func TestEmitTruncatesMetadataValues(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx = withSamplingConfig(ctx, func(cfg *Config) {
+		cfg.MaxMetadataValueBytes = 1024
+	})
+
+	ctx, seg := BeginSegment(ctx, "test")
+	_, subseg := BeginSubsegment(ctx, "sub")
+	seg.AddMetadata("body", strings.Repeat("a", 11*1024))
+	seg.AddMetadata("small", "value")
+	seg.AddMetadataToNamespace("ns", "items", []string{strings.Repeat("b", 2048)})
+	seg.AddAnnotation("query", strings.Repeat("c", 2048))
+	subseg.AddMetadata("body", strings.Repeat("d", 2048))
+	subseg.Close(nil)
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, strings.Repeat("a", 1024)+"...[truncated 10240 bytes]", emitted.Metadata["default"]["body"])
+	assert.Equal(t, "value", emitted.Metadata["default"]["small"])
+	items, _ := emitted.Metadata["ns"]["items"].(string)
+	assert.True(t, strings.HasPrefix(items, `["bbb`), items)
+	assert.True(t, strings.HasSuffix(items, "...[truncated 1028 bytes]"), items)
+	assert.Equal(t, strings.Repeat("c", 1024)+"...[truncated 1024 bytes]", emitted.Annotations["query"])
+	assert.Equal(t, true, emitted.Annotations[truncatedAnnotation])
+
+	if assert.Len(t, emitted.Subsegments, 1) {
+		var sub *Segment
+		assert.NoError(t, json.Unmarshal(emitted.Subsegments[0], &sub))
+		assert.Equal(t, strings.Repeat("d", 1024)+"...[truncated 1024 bytes]", sub.Metadata["default"]["body"])
+		assert.Equal(t, true, sub.Annotations[truncatedAnnotation])
+	}
+}
+
+func TestEmitDropsMetadataOfOversizedSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx = withSamplingConfig(ctx, func(cfg *Config) {
+		cfg.MaxSegmentSizeBytes = 60 * 1024
+	})
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.AddMetadata("body", strings.Repeat("a", 100*1024))
+	seg.AddAnnotation("user", "42")
+	seg.SetPIIFlag("email")
+	seg.GetHTTP().GetRequest().Method = "POST"
+	seg.GetSQL().SanitizedQuery = "SELECT 1"
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotContains(t, emitted.Metadata, "default")
+	assert.Equal(t, []interface{}{"email"}, emitted.Metadata["pii"]["categories"])
+	assert.Equal(t, "42", emitted.Annotations["user"])
+	assert.Equal(t, true, emitted.Annotations[truncatedAnnotation])
+	assert.Equal(t, "POST", emitted.HTTP.Request.Method)
+	assert.Equal(t, "SELECT 1", emitted.SQL.SanitizedQuery)
+}
+
+func TestEmitWithoutLimits(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.AddMetadata("body", strings.Repeat("a", 32*1024))
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, strings.Repeat("a", 32*1024), emitted.Metadata["default"]["body"])
+	assert.NotContains(t, emitted.Annotations, truncatedAnnotation)
+}
+
+func TestMarshalEmittedSegmentWithoutConfiguration(t *testing.T) {
+	seg := &Segment{ID: "0000000000000001", Name: "test", Metadata: map[string]map[string]interface{}{"default": {"key": "value"}}}
+	seg.ParentSegment = seg
+
+	b, err := marshalEmittedSegment(seg)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"metadata":{"default":{"key":"value"}}`)
+}
@@ -11,6 +11,7 @@ package xray
 import (
 	"context"
 	"encoding/json"
+	"strconv"
 	"sync"
 
 	"github.com/aws/aws-xray-sdk-go/header"
@@ -21,19 +22,41 @@ import (
 // Segment provides the resource's name, details about the request, and details about the work done.
 type Segment struct {
 	sync.RWMutex
-	parent           *Segment
-	openSegments     int
-	totalSubSegments uint32
-	Sampled          bool           `json:"-"`
-	RequestWasTraced bool           `json:"-"` // Used by xray.RequestWasTraced
-	ContextDone      bool           `json:"-"`
-	Emitted          bool           `json:"-"`
-	IncomingHeader   *header.Header `json:"-"`
-	ParentSegment    *Segment       `json:"-"` // The root of the Segment tree, the parent Segment (not Subsegment).
+	parent              *Segment
+	openSegments        int
+	totalSubSegments    uint32
+	streamedSubSegments uint32
+	streaming           bool
+	Sampled             bool           `json:"-"`
+	RequestWasTraced    bool           `json:"-"` // Used by xray.RequestWasTraced
+	ContextDone         bool           `json:"-"`
+	Emitted             bool           `json:"-"`
+	IncomingHeader      *header.Header `json:"-"`
+	ParentSegment       *Segment       `json:"-"` // The root of the Segment tree, the parent Segment (not Subsegment).
+
+	// DecisionSource is what the sampling decision of the Segment was taken by.
+	// It is empty when the decision wasn't taken by a sampling rule or
+	// propagated by the incoming trace header.
+	DecisionSource sampling.DecisionSource `json:"-"`
 
 	// cancels the context bound to this Segment, after Segment is closed
 	cancelCtx context.CancelFunc
 
+	// summary of closed remote subsegments, only used on the root Segment
+	downstream downstreamSummary
+
+	// serializes SetSampled against subsegment creation, only used on the root Segment
+	samplingMu sync.RWMutex
+
+	// whether the Segment is counted as in progress by Flush until it's closed
+	flushTracked bool
+
+	// header forwarded downstream by a Segment begun in passthrough mode
+	propagated *header.Header
+
+	// documents serialized by a TeeEmitter, emitted instead of the segment tree
+	packed [][]byte
+
 	// Required
 	TraceID   string  `json:"trace_id,omitempty"`
 	ID        string  `json:"id"`
@@ -103,6 +126,7 @@ type RequestData struct {
 	UserAgent     string `json:"user_agent,omitempty"`
 	XForwardedFor bool   `json:"x_forwarded_for,omitempty"`
 	Traced        bool   `json:"traced,omitempty"`
+	ContentLength int    `json:"content_length,omitempty"`
 }
 
 // ResponseData provides the shape for unmarshalling response data.
@@ -130,12 +154,39 @@ type SQLData struct {
 	SanitizedQuery   string `json:"sanitized_query,omitempty"`
 }
 
+// epochSeconds is a time in seconds since the Unix epoch.
+type epochSeconds float64
+
+// MarshalJSON encodes t in fixed decimal notation with microsecond precision,
+// the format expected by the X-Ray daemon, whatever the magnitude of t.
+func (t epochSeconds) MarshalJSON() ([]byte, error) {
+	if err := checkEpochSeconds(float64(t)); err != nil {
+		return nil, err
+	}
+	return t.appendTo(nil), nil
+}
+
+// appendTo appends the encoding of t to dst, t being finite.
+func (t epochSeconds) appendTo(dst []byte) []byte {
+	return strconv.AppendFloat(dst, float64(t), 'f', 6, 64)
+}
+
+// MarshalJSON encodes s like encoding/json would, except for its start and
+// end times which are written as epochSeconds.
+func (s *Segment) MarshalJSON() ([]byte, error) {
+	return marshalSegment(s)
+}
+
 // DownstreamHeader returns a header for passing to downstream calls.
 func (s *Segment) DownstreamHeader() *header.Header {
 	r := &header.Header{}
 
-	// If SDK is disabled then return with an empty header
-	if SdkDisabled() {
+	// If segment was created while SDK was disabled then return with an empty
+	// header, or the propagated header unchanged in passthrough mode
+	if s.isDisabled() {
+		if s.propagated != nil {
+			*r = *s.propagated
+		}
 		return r
 	}
 
@@ -218,6 +269,30 @@ func (s *Segment) GetConfiguration() *Config {
 	return s.Configuration
 }
 
+// SamplingRule returns the name of the sampling rule the sampling decision
+// of the segment tree was taken by, along with the decision. ok is false when
+// the decision wasn't taken by a sampling rule, e.g. when it was propagated
+// by the incoming trace header. Local sampling rules have no name.
+func (s *Segment) SamplingRule() (ruleName string, sampled bool, ok bool) {
+	root := s
+	if s.ParentSegment != nil {
+		root = s.ParentSegment
+	}
+
+	root.RLock()
+	defer root.RUnlock()
+
+	switch root.DecisionSource {
+	case sampling.DecisionSourceRule, sampling.DecisionSourceDefaultRule, sampling.DecisionSourceLocal, sampling.DecisionSourceFallback:
+	default:
+		return "", root.Sampled, false
+	}
+	if sdk, ok := root.AWS["xray"].(SDK); ok {
+		ruleName = sdk.RuleName
+	}
+	return ruleName, root.Sampled, true
+}
+
 // AddRuleName adds rule name, if present from sampling decision to xray context.
 func (s *Segment) AddRuleName(sd *sampling.Decision) {
 	if sd.Rule != nil {
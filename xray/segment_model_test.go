@@ -0,0 +1,104 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEpochSecondsMarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, `0.000000`},
+		{1e-7, `0.000000`},
+		{0.000001, `0.000001`},
+		{999999999.999999, `999999999.999999`},
+		{1e9, `1000000000.000000`},
+		{1.7091123e+09, `1709112300.000000`},
+		{1709112300.123456, `1709112300.123456`},
+		{2147483647.000001, `2147483647.000001`},
+		{4294967295.5, `4294967295.500000`},
+		{1e10, `10000000000.000000`},
+		{1e21, `1000000000000000000000.000000`},
+	}
+
+	for _, tt := range tests {
+		b, err := json.Marshal(epochSeconds(tt.in))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, string(b))
+	}
+
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		_, err := json.Marshal(epochSeconds(f))
+		assert.Error(t, err)
+	}
+}
+
+func TestSegmentMarshalJSONGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		seg  *Segment
+		want string
+	}{
+		{
+			name: "epoch",
+			seg:  &Segment{ID: "0000000000000001", Name: "test"},
+			want: `{"id":"0000000000000001","name":"test","Dummy":false,"start_time":0.000000}`,
+		},
+		{
+			name: "in progress",
+			seg:  &Segment{ID: "0000000000000001", Name: "test", StartTime: 999999999.999999, InProgress: true},
+			want: `{"id":"0000000000000001","name":"test","in_progress":true,"Dummy":false,"start_time":999999999.999999}`,
+		},
+		{
+			name: "crossing the billionth second",
+			seg:  &Segment{ID: "0000000000000001", Name: "test", StartTime: 999999999.5, EndTime: 1e9},
+			want: `{"id":"0000000000000001","name":"test","Dummy":false,"start_time":999999999.500000,"end_time":1000000000.000000}`,
+		},
+		{
+			name: "long duration",
+			seg:  &Segment{TraceID: "1-5759e988-bd862e3fe1be46a994272793", ID: "0000000000000001", Name: "test", StartTime: 1709112300.123456, EndTime: 4102444800.25},
+			want: `{"trace_id":"1-5759e988-bd862e3fe1be46a994272793","id":"0000000000000001","name":"test","Dummy":false,"start_time":1709112300.123456,"end_time":4102444800.250000}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.seg)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(b))
+
+			var seg Segment
+			assert.NoError(t, json.Unmarshal(b, &seg))
+			assert.Equal(t, tt.seg.StartTime, seg.StartTime)
+			assert.Equal(t, tt.seg.EndTime, seg.EndTime)
+		})
+	}
+}
+
+func TestSegmentMarshalJSONSubsegments(t *testing.T) {
+	root := &Segment{ID: "0000000000000001", Name: "root", StartTime: 1709112300, EndTime: 1709112301.5}
+	root.ParentSegment = root
+	sub := &Segment{parent: root, ParentSegment: root, ID: "0000000000000002", Name: "sub", StartTime: 1709112300.000001, EndTime: 1709112300.25}
+	root.rawSubsegments = []*Segment{sub}
+
+	root.Lock()
+	out := packSegments(root, nil)
+	root.Unlock()
+
+	if assert.Len(t, out, 1) {
+		assert.Equal(t, `{"id":"0000000000000001","name":"root","subsegments":[{"id":"0000000000000002","name":"sub","Dummy":false,"start_time":1709112300.000001,"end_time":1709112300.250000}],"Dummy":false,"start_time":1709112300.000000,"end_time":1709112301.500000}`, string(out[0]))
+	}
+}
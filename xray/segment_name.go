@@ -0,0 +1,92 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// NameNormalization selects how the names of segments and subsegments are
+// checked against the characters X-Ray allows, see Config.NameNormalization.
+type NameNormalization int
+
+const (
+	// NameNormalizationOff records names as given, only truncated to 200
+	// characters.
+	NameNormalizationOff NameNormalization = iota
+
+	// NameNormalizationReplace replaces the characters X-Ray doesn't allow
+	// in names with Config.NameReplacement.
+	NameNormalizationReplace
+
+	// NameNormalizationStrict replaces the characters like
+	// NameNormalizationReplace, and logs a warning for each name replaced.
+	NameNormalizationStrict
+)
+
+// defaultNameReplacement replaces the characters X-Ray doesn't allow in names
+// unless Config.NameReplacement is set.
+const defaultNameReplacement = '_'
+
+// nameChars are the ASCII characters X-Ray allows in names: letters, digits,
+// spaces and _.:/%&#=+\-@
+var nameChars = func() (chars [128]bool) {
+	for c := 'a'; c <= 'z'; c++ {
+		chars[c] = true
+	}
+	for c := 'A'; c <= 'Z'; c++ {
+		chars[c] = true
+	}
+	for c := '0'; c <= '9'; c++ {
+		chars[c] = true
+	}
+	for _, c := range ` _.:/%&#=+\-@` {
+		chars[c] = true
+	}
+	return chars
+}()
+
+func isNameChar(r rune) bool {
+	return r < utf8.RuneSelf && nameChars[r]
+}
+
+// validName reports whether name only holds characters X-Ray allows.
+func validName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c >= utf8.RuneSelf || !nameChars[c] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeName returns name with the characters X-Ray doesn't allow replaced
+// as configured by cfg. Valid names are returned without allocating.
+func (seg *Segment) normalizeName(name string, cfg *Config) string {
+	if cfg == nil || cfg.NameNormalization == NameNormalizationOff || validName(name) {
+		return name
+	}
+
+	replacement := cfg.NameReplacement
+	if replacement == 0 {
+		replacement = defaultNameReplacement
+	}
+	normalized := strings.Map(func(r rune) rune {
+		if isNameChar(r) {
+			return r
+		}
+		return replacement
+	}, name)
+
+	if cfg.NameNormalization == NameNormalizationStrict {
+		seg.log().Warnf("Name %q holds characters X-Ray doesn't allow, which are ASCII letters, digits, spaces and _.:/%%&#=+\\-@. Recording it as %q", name, normalized)
+	}
+	return normalized
+}
@@ -0,0 +1,80 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/xraylog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeName(t *testing.T) {
+	seg := &Segment{}
+	cfg := &Config{NameNormalization: NameNormalizationReplace}
+
+	cases := map[string]string{
+		"GET /users/{id}?q=1":          "GET /users/_id__q=1",
+		"orders 🚚 shipping":            "orders _ shipping",
+		"line\nbreak\ttab\x00":         "line_break_tab_",
+		`a-z_A.Z:0/9%&#=+\-@ ok`:       `a-z_A.Z:0/9%&#=+\-@ ok`,
+		"café":                         "caf_",
+		string([]byte{'a', 0xff, 'b'}): "a_b",
+	}
+	for name, expected := range cases {
+		assert.Equal(t, expected, seg.normalizeName(name, cfg), name)
+	}
+
+	cfg.NameReplacement = '-'
+	assert.Equal(t, "GET /users/-id--q=1", seg.normalizeName("GET /users/{id}?q=1", cfg))
+
+	assert.Equal(t, "GET /users/{id}", seg.normalizeName("GET /users/{id}", &Config{}))
+	assert.Equal(t, "GET /users/{id}", seg.normalizeName("GET /users/{id}", nil))
+}
+
+func TestNormalizeNameValidDoesNotAllocate(t *testing.T) {
+	seg := &Segment{}
+	cfg := &Config{NameNormalization: NameNormalizationStrict}
+	allocs := testing.AllocsPerRun(100, func() {
+		seg.normalizeName("GET /users/42 orders.example.com:443", cfg)
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+func TestNameNormalizationStrict(t *testing.T) {
+	var buf bytes.Buffer
+	ctx, me := newMemoryEmitterContext(t, Config{
+		NameNormalization: NameNormalizationStrict,
+		Logger:            xraylog.NewDefaultLogger(&buf, xraylog.LogLevelWarn),
+	})
+
+	ctx, root := BeginSegment(ctx, "orders {v2}")
+	_, subseg := BeginSubsegment(ctx, "SELECT * FROM orders;")
+	subseg.Close(nil)
+	root.Close(nil)
+
+	assert.Equal(t, "orders _v2_", root.Name)
+	assert.Equal(t, "SELECT _ FROM orders_", subseg.Name)
+	assert.Contains(t, buf.String(), `"orders {v2}"`)
+	assert.Contains(t, buf.String(), `"SELECT _ FROM orders_"`)
+	assert.Len(t, me.Segments(), 1)
+}
+
+func TestNameNormalizationOff(t *testing.T) {
+	ctx, _ := newMemoryEmitterContext(t, Config{})
+
+	ctx, root := BeginSegment(ctx, "orders {v2}")
+	_, subseg := BeginSubsegment(ctx, "SELECT * FROM orders;")
+	subseg.Close(nil)
+	root.Close(nil)
+
+	assert.Equal(t, "orders {v2}", root.Name)
+	assert.Equal(t, "SELECT * FROM orders;", subseg.Name)
+}
@@ -0,0 +1,94 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"time"
+)
+
+// SegmentOption configures a subsegment started by BeginSubsegmentWithOptions.
+type SegmentOption func(opts *segmentOptions)
+
+type segmentOptions struct {
+	startTime   time.Time
+	id          string
+	annotations map[string]interface{}
+}
+
+// WithStartTime sets the start time of the subsegment instead of the time it
+// begins at, e.g. to record work that happened earlier. A start time after
+// the time the subsegment is closed is replaced with the latter.
+func WithStartTime(t time.Time) SegmentOption {
+	return func(opts *segmentOptions) {
+		opts.startTime = t
+	}
+}
+
+// WithSegmentID sets the ID of the subsegment instead of a random one.
+// id must be 16 hexadecimal digits, otherwise it is ignored.
+func WithSegmentID(id string) SegmentOption {
+	return func(opts *segmentOptions) {
+		opts.id = id
+	}
+}
+
+// WithAnnotations adds annotations to the subsegment, as AddAnnotation does.
+// Values of unsupported types are ignored.
+func WithAnnotations(annotations map[string]interface{}) SegmentOption {
+	return func(opts *segmentOptions) {
+		if opts.annotations == nil {
+			opts.annotations = make(map[string]interface{}, len(annotations))
+		}
+		for k, v := range annotations {
+			opts.annotations[k] = v
+		}
+	}
+}
+
+// apply applies opts to seg.
+// The caller of apply should have write lock on seg instance.
+func (opts *segmentOptions) apply(seg *Segment) {
+	if !opts.startTime.IsZero() {
+		seg.StartTime = float64(opts.startTime.UnixNano()) / float64(time.Second)
+	}
+
+	if opts.id != "" {
+		if isSegmentID(opts.id) {
+			seg.ID = opts.id
+		} else {
+			seg.log().Errorf("ignoring invalid ID %q of subsegment named %s", opts.id, seg.Name)
+		}
+	}
+
+	if len(opts.annotations) > 0 && !seg.Dummy {
+		if seg.Annotations == nil {
+			seg.Annotations = make(map[string]interface{}, len(opts.annotations))
+		}
+		for k, v := range opts.annotations {
+			value, err := checkAnnotation(k, v)
+			if err != nil {
+				seg.log().Errorf("ignoring annotation of subsegment named %s: %v", seg.Name, err)
+				continue
+			}
+			seg.Annotations[k] = value
+		}
+	}
+}
+
+func isSegmentID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureSubsegment begins a subsegment with opts and returns it as emitted.
+func captureSubsegment(t *testing.T, opts ...SegmentOption) *Segment {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	_, sub := BeginSubsegmentWithOptions(ctx, "sub", opts...)
+	sub.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(t, seg.Subsegments, 1) {
+		t.FailNow()
+	}
+	var subseg *Segment
+	if err := json.Unmarshal(seg.Subsegments[0], &subseg); err != nil {
+		t.Fatal(err)
+	}
+	return subseg
+}
+
+func TestBeginSubsegmentWithStartTime(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	subseg := captureSubsegment(t, WithStartTime(start))
+
+	assert.InDelta(t, float64(start.UnixNano())/float64(time.Second), subseg.StartTime, 1e-6)
+	assert.InDelta(t, time.Hour.Seconds(), subseg.EndTime-subseg.StartTime, 1)
+}
+
+func TestBeginSubsegmentWithStartTimeAfterClose(t *testing.T) {
+	subseg := captureSubsegment(t, WithStartTime(time.Now().Add(time.Hour)))
+
+	assert.Equal(t, subseg.EndTime, subseg.StartTime)
+}
+
+func TestBeginSubsegmentWithSegmentID(t *testing.T) {
+	subseg := captureSubsegment(t, WithSegmentID("0123456789abcdef"))
+	assert.Equal(t, "0123456789abcdef", subseg.ID)
+
+	subseg = captureSubsegment(t, WithSegmentID("not-a-segment-id"))
+	assert.NotEqual(t, "not-a-segment-id", subseg.ID)
+	assert.Len(t, subseg.ID, 16)
+}
+
+func TestBeginSubsegmentWithAnnotations(t *testing.T) {
+	subseg := captureSubsegment(t,
+		WithAnnotations(map[string]interface{}{"item": "a", "attempt": 2}),
+		WithAnnotations(map[string]interface{}{"replayed": true, "invalid": []string{"x"}}),
+	)
+
+	assert.Equal(t, map[string]interface{}{"item": "a", "attempt": 2.0, "replayed": true}, subseg.Annotations)
+}
+
+func TestBeginSubsegmentWithOptionsWithoutSegment(t *testing.T) {
+	ctx, err := ContextWithConfig(context.Background(), Config{ContextMissingStrategy: &TestContextMissingStrategy{}})
+	assert.NoError(t, err)
+
+	_, sub := BeginSubsegmentWithOptions(ctx, "sub", WithStartTime(time.Now()))
+	assert.Nil(t, sub)
+}
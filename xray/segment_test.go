@@ -10,15 +10,19 @@ package xray
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"os"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/internal/plugins"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -94,6 +98,41 @@ func TestSubsegmentDataRaceWithContextCancel(t *testing.T) {
 	seg.Close(nil)
 }
 
+func TestSegmentContextCancelledAfterClose(t *testing.T) {
+	ctx, me := newMemoryEmitterContext(t, Config{})
+	ctx, cancel := context.WithCancel(ctx)
+
+	_, seg := BeginSegment(ctx, "TestSegment")
+	seg.Close(nil)
+	cancel()
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, me.Segments(), 1)
+}
+
+func TestSegmentContextCancelledBeforeClose(t *testing.T) {
+	ctx, me := newMemoryEmitterContext(t, Config{})
+	ctx, cancel := context.WithCancel(ctx)
+
+	ctx, seg := BeginSegment(ctx, "TestSegment")
+	_, subseg := BeginSubsegment(ctx, "TestSubsegment")
+	defer subseg.Close(nil)
+	cancel()
+
+	assert.Eventually(t, func() bool {
+		seg.RLock()
+		defer seg.RUnlock()
+		return seg.ContextDone
+	}, time.Second, time.Millisecond)
+
+	// the incomplete tree is flushed on close
+	seg.Close(nil)
+	segments := me.Segments()
+	if assert.Len(t, segments, 1) {
+		assert.Len(t, segments[0].Subsegments, 1)
+	}
+}
+
 func TestSegmentDownstreamHeader(t *testing.T) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -224,6 +263,81 @@ func TestSDKDisable_otherMethods(t *testing.T) {
 	os.Setenv("AWS_XRAY_SDK_DISABLED", "FALSE")
 }
 
+func TestSDKDisable_toggledAfterBegin(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "Segment")
+	_, subSeg1 := BeginSubsegment(ctx, "Subsegment1")
+
+	os.Setenv("AWS_XRAY_SDK_DISABLED", "TRUE")
+	defer os.Unsetenv("AWS_XRAY_SDK_DISABLED")
+
+	// subsegments of an enabled segment stay enabled
+	_, subSeg2 := BeginSubsegment(ctx, "Subsegment2")
+	assert.Equal(t, root, subSeg2.ParentSegment)
+	assert.NoError(t, subSeg2.AddAnnotation("key", "value"))
+
+	subSeg2.Close(nil)
+	subSeg1.Close(nil)
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "Segment", seg.Name)
+	assert.Len(t, seg.Subsegments, 2)
+}
+
+func TestSDKDisable_toggledBeforeSubsegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	os.Setenv("AWS_XRAY_SDK_DISABLED", "TRUE")
+	ctx, root := BeginSegment(ctx, "Segment")
+	os.Unsetenv("AWS_XRAY_SDK_DISABLED")
+
+	// subsegments of a disabled segment stay disabled
+	_, subSeg := BeginSubsegment(ctx, "Subsegment")
+	assert.Equal(t, &Segment{}, subSeg)
+
+	subSeg.Close(nil)
+	root.Close(nil)
+
+	_, err := td.Recv()
+	assert.Error(t, err)
+}
+
+func TestSetDisabled(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	SetDisabled(true)
+	assert.True(t, SDKDisabled())
+	_, disabledSeg := BeginSegment(ctx, "Disabled")
+	SetDisabled(false)
+	assert.False(t, SDKDisabled())
+	_, enabledSeg := BeginSegment(ctx, "Enabled")
+
+	assert.Equal(t, &Segment{}, disabledSeg)
+	disabledSeg.Close(nil)
+	enabledSeg.Close(nil)
+
+	seg, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "Enabled", seg.Name)
+}
+
+func TestSetDisabled_envStillApplies(t *testing.T) {
+	os.Setenv("AWS_XRAY_SDK_DISABLED", "TRUE")
+	defer os.Unsetenv("AWS_XRAY_SDK_DISABLED")
+
+	SetDisabled(false)
+	assert.True(t, SDKDisabled())
+}
+
 func TestIDGeneration_noOPTrue(t *testing.T) {
 	os.Setenv("AWS_XRAY_NOOP_ID", "true")
 	seg := &Segment{parent: nil}
@@ -285,6 +399,241 @@ func TestIDGeneration_segSubSeg(t *testing.T) {
 	os.Unsetenv("AWS_XRAY_NOOP_ID")
 }
 
+func TestSetSampled_noOpIDs(t *testing.T) {
+	os.Setenv("AWS_XRAY_NOOP_ID", "true")
+	defer os.Unsetenv("AWS_XRAY_NOOP_ID")
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := NewSegmentFromHeader(ctx, "test", &http.Request{URL: &url.URL{}}, &header.Header{
+		SamplingDecision: header.NotSampled,
+	})
+	_, closed := BeginSubsegment(ctx, "closed")
+	closed.Close(nil)
+	ctxOpen, open := BeginSubsegment(ctx, "open")
+	_, nested := BeginSubsegment(ctxOpen, "nested")
+
+	assert.Equal(t, noOpTraceID(), seg.TraceID)
+	assert.Equal(t, noOpSegmentID(), open.ID)
+
+	assert.NoError(t, seg.SetSampled(true))
+	assert.NoError(t, seg.SetSampled(true))
+	_, late := BeginSubsegment(ctx, "late")
+	late.Close(nil)
+	nested.Close(nil)
+	open.Close(nil)
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, seg.TraceID, emitted.TraceID)
+	assert.NotEqual(t, noOpTraceID(), emitted.TraceID)
+	assert.NotEqual(t, noOpSegmentID(), emitted.ID)
+	assert.Len(t, emitted.Subsegments, 3)
+
+	ids := map[string]bool{}
+	for _, raw := range emitted.Subsegments {
+		var sub Segment
+		assert.NoError(t, json.Unmarshal(raw, &sub))
+		assert.NotEqual(t, noOpSegmentID(), sub.ID, sub.Name)
+		ids[sub.ID] = true
+		for _, raw := range sub.Subsegments {
+			var child Segment
+			assert.NoError(t, json.Unmarshal(raw, &child))
+			assert.Equal(t, "nested", child.Name)
+			assert.NotEqual(t, noOpSegmentID(), child.ID)
+		}
+	}
+	assert.Len(t, ids, 3)
+}
+
+func TestSetSampled_lambdaFacade(t *testing.T) {
+	os.Setenv("AWS_XRAY_NOOP_ID", "true")
+	defer os.Unsetenv("AWS_XRAY_NOOP_ID")
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx = context.WithValue(ctx, LambdaTraceHeaderKey, "Root=1-57ff426a-80c11c39b0c928905eb0828d;Parent=1234abcd1234abcd;Sampled=0")
+
+	ctx, handler := BeginSubsegment(ctx, "handler")
+	_, closed := BeginSubsegment(ctx, "closed")
+	closed.Close(nil)
+	_, open := BeginSubsegment(ctx, "open")
+	handler.Close(nil)
+	assert.Equal(t, noOpSegmentID(), handler.ID)
+
+	assert.NoError(t, open.SetSampled(true))
+	open.Close(nil)
+
+	sub, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "handler", sub.Name)
+	assert.Equal(t, "subsegment", sub.Type)
+	assert.Equal(t, "1-57ff426a-80c11c39b0c928905eb0828d", sub.TraceID)
+	assert.Equal(t, "1234abcd1234abcd", sub.ParentID)
+	assert.NotEqual(t, noOpSegmentID(), sub.ID)
+	assert.Len(t, sub.Subsegments, 2)
+	for _, raw := range sub.Subsegments {
+		var child Segment
+		assert.NoError(t, json.Unmarshal(raw, &child))
+		assert.NotEqual(t, noOpSegmentID(), child.ID, child.Name)
+	}
+}
+
+func TestSetSampled_concurrentSubsegments(t *testing.T) {
+	os.Setenv("AWS_XRAY_NOOP_ID", "true")
+	defer os.Unsetenv("AWS_XRAY_NOOP_ID")
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := NewSegmentFromHeader(ctx, "test", &http.Request{URL: &url.URL{}}, &header.Header{
+		SamplingDecision: header.NotSampled,
+	})
+
+	var wg sync.WaitGroup
+	n := 50
+	wg.Add(n + 1)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			_, sub := BeginSubsegment(ctx, "TestSubsegment")
+			sub.Close(nil)
+		}()
+	}
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, seg.SetSampled(true))
+	}()
+	wg.Wait()
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, emitted.Subsegments, n)
+	for _, raw := range emitted.Subsegments {
+		var sub Segment
+		assert.NoError(t, json.Unmarshal(raw, &sub))
+		assert.NotEqual(t, noOpSegmentID(), sub.ID)
+	}
+}
+
+func TestSetSampled_closedSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := NewSegmentFromHeader(ctx, "test", &http.Request{URL: &url.URL{}}, &header.Header{
+		SamplingDecision: header.NotSampled,
+	})
+	seg.Close(nil)
+
+	assert.Error(t, seg.SetSampled(true))
+	assert.False(t, seg.Sampled)
+}
+
+func TestPromoteToSampled(t *testing.T) {
+	os.Setenv("AWS_XRAY_NOOP_ID", "true")
+	defer os.Unsetenv("AWS_XRAY_NOOP_ID")
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := NewSegmentFromHeader(ctx, "test", &http.Request{URL: &url.URL{}}, &header.Header{
+		SamplingDecision: header.NotSampled,
+	})
+	_, sub := BeginSubsegment(ctx, "sub")
+	sub.Close(nil)
+
+	assert.NoError(t, seg.PromoteToSampled())
+	assert.NoError(t, seg.PromoteToSampled())
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, true, emitted.Annotations[promotedAnnotationKey])
+	assert.NotEqual(t, noOpTraceID(), emitted.TraceID)
+	assert.NotEqual(t, noOpSegmentID(), emitted.ID)
+	assert.Len(t, emitted.Subsegments, 1)
+}
+
+func TestPromoteToSampled_notPromoted(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := NewSegmentFromHeader(ctx, "test", &http.Request{URL: &url.URL{}}, &header.Header{
+		SamplingDecision: header.NotSampled,
+	})
+	_, sub := BeginSubsegment(ctx, "sub")
+	sub.Close(errors.New("failed"))
+	seg.Close(nil)
+
+	_, err := td.Recv()
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestPromoteToSampled_sampled(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, seg := BeginSegment(ctx, "test")
+	_, sub := BeginSubsegment(ctx, "sub")
+
+	assert.Error(t, sub.PromoteToSampled())
+	assert.NoError(t, seg.PromoteToSampled())
+	assert.NotContains(t, seg.Annotations, promotedAnnotationKey)
+	sub.Close(nil)
+	seg.Close(nil)
+
+	assert.Error(t, seg.PromoteToSampled())
+}
+
+func TestPromoteToSampled_closedSegment(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := NewSegmentFromHeader(ctx, "test", &http.Request{URL: &url.URL{}}, &header.Header{
+		SamplingDecision: header.NotSampled,
+	})
+	seg.Close(nil)
+
+	assert.Error(t, seg.PromoteToSampled())
+	assert.False(t, seg.Sampled)
+}
+
+func TestPromoteOnFault(t *testing.T) {
+	ctx, me := newMemoryEmitterContext(t, Config{PromoteOnFault: true})
+
+	ctx, seg := NewSegmentFromHeader(ctx, "test", &http.Request{URL: &url.URL{}}, &header.Header{
+		SamplingDecision: header.NotSampled,
+	})
+	_, ok := BeginSubsegment(ctx, "ok")
+	ok.Close(nil)
+	assert.False(t, seg.Sampled)
+	_, failed := BeginSubsegment(ctx, "failed")
+	failed.Close(errors.New("failed"))
+	seg.Close(nil)
+
+	segs := me.Segments()
+	if !assert.Len(t, segs, 1) {
+		return
+	}
+	assert.Equal(t, true, segs[0].Annotations[promotedAnnotationKey])
+	assert.Len(t, segs[0].Subsegments, 2)
+
+	// segments closed without an error aren't promoted
+	me.Reset()
+	_, seg = NewSegmentFromHeader(ctx, "test", &http.Request{URL: &url.URL{}}, &header.Header{
+		SamplingDecision: header.NotSampled,
+	})
+	seg.Close(nil)
+	assert.Empty(t, me.Segments())
+}
+
 // Benchmarks
 func BenchmarkBeginSegment(b *testing.B) {
 	ctx, td := NewTestDaemon()
@@ -295,6 +644,28 @@ func BenchmarkBeginSegment(b *testing.B) {
 	}
 }
 
+func BenchmarkBeginSegmentCancellableContext(b *testing.B) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	goroutines := runtime.NumGoroutine()
+	segs := make([]*Segment, 0, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, seg := BeginSegment(ctx, "TestBenchSeg")
+		segs = append(segs, seg)
+	}
+	b.StopTimer()
+
+	// goroutines started per open segment
+	b.ReportMetric(float64(runtime.NumGoroutine()-goroutines)/float64(b.N), "goroutines/op")
+	for _, seg := range segs {
+		seg.Close(nil)
+	}
+}
+
 func BenchmarkBeginSubsegment(b *testing.B) {
 	ctx, td := NewTestDaemon()
 	defer td.Close()
@@ -343,3 +714,191 @@ func TestBeginSegmentNameFromEnv(t *testing.T) {
 	os.Unsetenv("AWS_XRAY_TRACING_NAME")
 	n.Close(nil)
 }
+
+func TestBeginSegmentDeadlineAnnotation(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.InDelta(t, 1000, emitted.Annotations["deadline_ms"], 100)
+}
+
+func TestBeginSegmentWithoutDeadline(t *testing.T) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	_, seg := BeginSegment(ctx, "test")
+	seg.Close(nil)
+
+	emitted, err := td.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotContains(t, emitted.Annotations, "deadline_ms")
+}
+
+func TestAddPluginLogGroups(t *testing.T) {
+	seg := &Segment{}
+	seg.addPlugin(&plugins.PluginMetadata{
+		ECSMetadata: &plugins.ECSMetadata{ContainerName: "host", ContainerID: "ea32192c8553"},
+		LogGroups:   []plugins.LogGroupMetadata{{LogGroup: "/ecs/app"}},
+		Origin:      "AWS::ECS::Container",
+	})
+
+	b, err := json.Marshal(seg.AWS)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, `{"cloudwatch_logs":[{"log_group":"/ecs/app"}],"ecs":{"container":"host","container_id":"ea32192c8553"}}`, string(b))
+	assert.Equal(t, "AWS::ECS::Container", seg.Origin)
+}
+
+// reportedSubsegments counts the subsegments reported by the recorded
+// segments and streamed subsegments, by name.
+func reportedSubsegments(t *testing.T, recorded []*Segment) map[string]int {
+	counts := map[string]int{}
+	var walk func(segs []*Segment)
+	walk = func(segs []*Segment) {
+		for _, s := range segs {
+			if s.Type == "subsegment" {
+				counts[s.Name]++
+			}
+			var children []*Segment
+			for _, raw := range s.Subsegments {
+				var child *Segment
+				assert.NoError(t, json.Unmarshal(raw, &child))
+				child.Type = "subsegment"
+				children = append(children, child)
+			}
+			walk(children)
+		}
+	}
+	walk(recorded)
+	return counts
+}
+
+func TestStreamCompletedChildren(t *testing.T) {
+	dss, _ := NewDefaultStreamingStrategyWithMaxSubsegmentCount(10)
+	ctx, me := newMemoryEmitterContext(t, Config{StreamingStrategy: dss})
+
+	ctx, root := BeginSegment(ctx, "root")
+	_, open := BeginSubsegment(ctx, "open")
+	for i := 0; i < 5; i++ {
+		_, subseg := BeginSubsegment(ctx, fmt.Sprintf("closed-%d", i))
+		subseg.Close(nil)
+	}
+	root.StreamCompletedChildren()
+	assert.Empty(t, me.Segments(), "the tree doesn't require streaming yet")
+
+	assert.NoError(t, dss.SetMaxSubsegmentCount(2))
+	root.StreamCompletedChildren()
+	streamed := me.Segments()
+	assert.Len(t, streamed, 4)
+	for _, s := range streamed {
+		assert.Equal(t, "subsegment", s.Type)
+		assert.Equal(t, root.ID, s.ParentID)
+		assert.NotEqual(t, "open", s.Name)
+	}
+	root.RLock()
+	assert.Len(t, root.rawSubsegments, 2)
+	root.RUnlock()
+
+	open.Close(nil)
+	root.Close(nil)
+
+	counts := reportedSubsegments(t, me.Segments())
+	assert.Len(t, counts, 6)
+	for name, n := range counts {
+		assert.Equal(t, 1, n, "subsegment %s is reported once", name)
+	}
+}
+
+func TestStreamCompletedChildrenNested(t *testing.T) {
+	dss, _ := NewDefaultStreamingStrategyWithMaxSubsegmentCount(10)
+	ctx, me := newMemoryEmitterContext(t, Config{StreamingStrategy: dss})
+
+	ctx, root := BeginSegment(ctx, "root")
+	ctx, worker := BeginSubsegment(ctx, "worker")
+	for i := 0; i < 3; i++ {
+		_, subseg := BeginSubsegment(ctx, fmt.Sprintf("task-%d", i))
+		subseg.Close(nil)
+	}
+
+	assert.NoError(t, dss.SetMaxSubsegmentCount(1))
+	worker.StreamCompletedChildren()
+	for _, s := range me.Segments() {
+		assert.Equal(t, worker.ID, s.ParentID)
+	}
+	assert.Len(t, me.Segments(), 3)
+
+	worker.Close(nil)
+	root.Close(nil)
+	assert.Len(t, reportedSubsegments(t, me.Segments()), 4)
+}
+
+func TestBeginSubsegmentStreamsOnceRequired(t *testing.T) {
+	dss, _ := NewDefaultStreamingStrategyWithMaxSubsegmentCount(2)
+	ctx, me := newMemoryEmitterContext(t, Config{StreamingStrategy: dss})
+
+	ctx, root := BeginSegment(ctx, "root")
+	for i := 0; i < 10; i++ {
+		_, subseg := BeginSubsegment(ctx, fmt.Sprintf("sub-%d", i))
+		subseg.Close(nil)
+
+		// plain Close streams completed subsegments once the tree is too large
+		root.RLock()
+		assert.LessOrEqual(t, len(root.rawSubsegments), 2)
+		root.RUnlock()
+	}
+	assert.Len(t, me.Segments(), 8)
+	root.Close(nil)
+
+	counts := reportedSubsegments(t, me.Segments())
+	assert.Len(t, counts, 10)
+	for name, n := range counts {
+		assert.Equal(t, 1, n, "subsegment %s is reported once", name)
+	}
+}
+
+func TestStreamingConcurrentClose(t *testing.T) {
+	dss, _ := NewDefaultStreamingStrategyWithMaxSubsegmentCount(3)
+	ctx, me := newMemoryEmitterContext(t, Config{StreamingStrategy: dss})
+
+	ctx, root := BeginSegment(ctx, "root")
+	var wg sync.WaitGroup
+	n := 20
+	wg.Add(2 * n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			c, subseg := BeginSubsegment(ctx, fmt.Sprintf("sub-%d", i))
+			_, child := BeginSubsegment(c, fmt.Sprintf("child-%d", i))
+			if i%2 == 0 {
+				child.CloseAndStream(nil)
+			} else {
+				child.Close(nil)
+			}
+			subseg.Close(nil)
+		}(i)
+		go func() {
+			defer wg.Done()
+			root.StreamCompletedChildren()
+		}()
+	}
+	wg.Wait()
+	root.Close(nil)
+
+	counts := reportedSubsegments(t, me.Segments())
+	assert.Len(t, counts, 2*n)
+	for name, n := range counts {
+		assert.Equal(t, 1, n, "subsegment %s is reported once", name)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+// The methods below inspect the segment tree, e.g. to assert on it in tests
+// or to export it to another tracing backend. They can be called at any time
+// from any goroutine, and return point-in-time snapshots: the tree may change
+// right after they return. Each takes the read lock of a single segment at a
+// time, so they must not be called on a segment the caller has locked, as
+// with the segment passed to Emitter.Emit or Config.EmitFilter; emitters
+// should inspect the segment once Emit returns. Subsegments streamed ahead of
+// their segment, see CloseAndStream, are no longer part of the tree.
+
+// Parent returns the segment or subsegment seg is a subsegment of, or nil if
+// seg is a segment or a detached subsegment.
+func (seg *Segment) Parent() *Segment {
+	if seg == nil {
+		return nil
+	}
+	seg.RLock()
+	defer seg.RUnlock()
+	return seg.parent
+}
+
+// Children returns a copy of the subsegments of seg. Unlike the Subsegments
+// field, which only holds them once the segment is encoded, it's the tree.
+func (seg *Segment) Children() []*Segment {
+	if seg == nil {
+		return nil
+	}
+	seg.RLock()
+	defer seg.RUnlock()
+	if len(seg.rawSubsegments) == 0 {
+		return nil
+	}
+	subs := make([]*Segment, len(seg.rawSubsegments))
+	copy(subs, seg.rawSubsegments)
+	return subs
+}
+
+// OpenSubsegmentCount returns the number of subsegments of seg which are
+// still in progress or have subsegments in progress. The segment tree is
+// emitted once seg and all its subsegments are closed.
+func (seg *Segment) OpenSubsegmentCount() int {
+	if seg == nil {
+		return 0
+	}
+	seg.RLock()
+	defer seg.RUnlock()
+	return seg.openSegments
+}
+
+// IsEmitted reports whether seg has been sent to the emitter, along with its
+// segment tree or streamed on its own.
+func (seg *Segment) IsEmitted() bool {
+	if seg == nil {
+		return false
+	}
+	seg.RLock()
+	defer seg.RUnlock()
+	return seg.Emitted
+}
+
+// Walk calls fn for seg and then for each of its subsegments, depth first,
+// until fn returns false. fn is called without any lock held, so it may
+// inspect or update the segments it's given. The subsegments of a segment
+// are read once fn returns for it, those begun later aren't walked.
+func (seg *Segment) Walk(fn func(*Segment) bool) {
+	seg.walk(fn)
+}
+
+func (seg *Segment) walk(fn func(*Segment) bool) bool {
+	if seg == nil {
+		return true
+	}
+	if !fn(seg) {
+		return false
+	}
+	for _, sub := range seg.Children() {
+		if !sub.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
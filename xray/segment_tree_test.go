@@ -0,0 +1,144 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentTreeAccessors(t *testing.T) {
+	ctx, me := newMemoryEmitterContext(t, Config{})
+
+	ctx, root := BeginSegment(ctx, "root")
+	ctx1, sub1 := BeginSubsegment(ctx, "sub1")
+	_, sub1a := BeginSubsegment(ctx1, "sub1a")
+	_, sub2 := BeginSubsegment(ctx, "sub2")
+
+	assert.Nil(t, root.Parent())
+	assert.Equal(t, root, sub1.Parent())
+	assert.Equal(t, sub1, sub1a.Parent())
+	assert.Equal(t, []*Segment{sub1, sub2}, root.Children())
+	assert.Equal(t, []*Segment{sub1a}, sub1.Children())
+	assert.Nil(t, sub2.Children())
+	assert.Equal(t, 2, root.OpenSubsegmentCount())
+
+	// the copy doesn't change the tree
+	root.Children()[0] = nil
+	assert.Equal(t, sub1, root.Children()[0])
+
+	sub2.Close(nil)
+	assert.Equal(t, 1, root.OpenSubsegmentCount())
+
+	// sub1 counts as open until sub1a is closed
+	sub1.Close(nil)
+	assert.Equal(t, 1, root.OpenSubsegmentCount())
+	assert.Equal(t, 1, sub1.OpenSubsegmentCount())
+	sub1a.Close(nil)
+	assert.Equal(t, 0, root.OpenSubsegmentCount())
+
+	assert.False(t, root.IsEmitted())
+	root.Close(nil)
+	assert.True(t, root.IsEmitted())
+	assert.Len(t, me.Segments(), 1)
+
+	var nilSeg *Segment
+	assert.Nil(t, nilSeg.Parent())
+	assert.Nil(t, nilSeg.Children())
+	assert.Equal(t, 0, nilSeg.OpenSubsegmentCount())
+	assert.False(t, nilSeg.IsEmitted())
+	nilSeg.Walk(func(*Segment) bool {
+		t.Error("walked a nil segment")
+		return true
+	})
+}
+
+func TestSegmentWalk(t *testing.T) {
+	ctx, _ := newMemoryEmitterContext(t, Config{})
+
+	ctx, root := BeginSegment(ctx, "root")
+	ctx1, sub1 := BeginSubsegment(ctx, "sub1")
+	_, sub1a := BeginSubsegment(ctx1, "sub1a")
+	_, sub2 := BeginSubsegment(ctx, "sub2")
+	defer func() {
+		sub1a.Close(nil)
+		sub1.Close(nil)
+		sub2.Close(nil)
+		root.Close(nil)
+	}()
+
+	var names []string
+	root.Walk(func(seg *Segment) bool {
+		// no lock is held
+		seg.AddAnnotation("walked", true)
+		names = append(names, seg.Name)
+		return true
+	})
+	assert.Equal(t, []string{"root", "sub1", "sub1a", "sub2"}, names)
+	assert.Equal(t, true, sub1a.Annotations["walked"])
+
+	names = nil
+	root.Walk(func(seg *Segment) bool {
+		names = append(names, seg.Name)
+		return seg.Name != "sub1a"
+	})
+	assert.Equal(t, []string{"root", "sub1", "sub1a"}, names)
+}
+
+func TestSegmentWalkConcurrent(t *testing.T) {
+	ctx, _ := newMemoryEmitterContext(t, Config{})
+	ctx, root := BeginSegment(ctx, "root")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = Capture(ctx, "outer", func(ctx context.Context) error {
+					return Capture(ctx, "inner", func(context.Context) error { return nil })
+				})
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for walking := true; walking; {
+		select {
+		case <-done:
+			walking = false
+		default:
+		}
+		root.Walk(func(seg *Segment) bool {
+			if seg != root {
+				assert.NotNil(t, seg.Parent())
+			}
+			_ = seg.OpenSubsegmentCount()
+			_ = seg.IsEmitted()
+			return true
+		})
+	}
+
+	// completed subsegments beyond the streaming threshold were streamed
+	assert.Equal(t, 0, root.OpenSubsegmentCount())
+	root.Walk(func(seg *Segment) bool {
+		if seg != root {
+			assert.False(t, seg.safeInProgress(), seg.Name)
+		}
+		return true
+	})
+	root.Close(nil)
+}
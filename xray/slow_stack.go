@@ -0,0 +1,101 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/exception"
+)
+
+const (
+	// slowStackNamespace and slowStackKey are the metadata namespace and key
+	// of the stack of slow subsegments, see Config.SlowSegmentThreshold.
+	slowStackNamespace = "xray"
+	slowStackKey       = "slow_stack"
+
+	// slowAnnotationKey annotates slow subsegments, to filter traces on.
+	slowAnnotationKey = "slow"
+
+	// defaultSlowStackMaxFrames is the number of frames of the stack of slow
+	// subsegments recorded unless Config.SlowStackMaxFrames is set.
+	defaultSlowStackMaxFrames = 32
+
+	// sdkFrames is the room left in the captured stack for the frames of
+	// the SDK, which are skipped.
+	sdkFrames = 16
+
+	sdkPackagePrefix = "github.com/aws/aws-xray-sdk-go/"
+)
+
+// slowStack returns the stack of the goroutine closing seg with err, or nil
+// unless seg is a subsegment lasting longer than the SlowSegmentThreshold of
+// its configuration. Subsegments closed as a fault are skipped, as their
+// cause already holds a stack. It's called before locking seg for the rest
+// of the update, like faultRuntimeStats.
+func (seg *Segment) slowStack(err error) []exception.Stack {
+	cfg := seg.ParentSegment.Configuration
+	if cfg == nil || cfg.SlowSegmentThreshold <= 0 || err != nil {
+		return nil
+	}
+
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	seg.RLock()
+	slow := seg.parent != nil && !seg.Dummy && !seg.Fault && now-seg.StartTime > cfg.SlowSegmentThreshold.Seconds()
+	seg.RUnlock()
+	if !slow {
+		return nil
+	}
+
+	maxFrames := cfg.SlowStackMaxFrames
+	if maxFrames <= 0 {
+		maxFrames = defaultSlowStackMaxFrames
+	}
+	pcs := make([]uintptr, maxFrames+sdkFrames)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+
+	stack := make([]exception.Stack, 0, maxFrames)
+	for len(stack) < maxFrames {
+		frame, more := frames.Next()
+		if !isSDKFrame(frame) {
+			stack = append(stack, exception.Stack{Path: frame.File, Line: frame.Line, Label: frame.Function})
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// isSDKFrame reports whether frame is in the code of the SDK, other than its
+// tests.
+func isSDKFrame(frame runtime.Frame) bool {
+	return strings.HasPrefix(frame.Function, sdkPackagePrefix) && !strings.HasSuffix(frame.File, "_test.go")
+}
+
+// addSlowStack records stack in the metadata of seg and annotates it as slow.
+// The caller of addSlowStack should have write lock on seg instance.
+func (seg *Segment) addSlowStack(stack []exception.Stack) {
+	if stack == nil {
+		return
+	}
+	if seg.Metadata == nil {
+		seg.Metadata = map[string]map[string]interface{}{}
+	}
+	if seg.Metadata[slowStackNamespace] == nil {
+		seg.Metadata[slowStackNamespace] = map[string]interface{}{}
+	}
+	seg.Metadata[slowStackNamespace][slowStackKey] = stack
+	if seg.Annotations == nil {
+		seg.Annotations = map[string]interface{}{}
+	}
+	seg.Annotations[slowAnnotationKey] = true
+}
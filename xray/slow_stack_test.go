@@ -0,0 +1,87 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/exception"
+	"github.com/stretchr/testify/assert"
+)
+
+// captureSlow captures a subsegment sleeping for 5ms and returning err, and
+// returns the subsegment.
+func captureSlow(ctx context.Context, err error) *Segment {
+	var seg *Segment
+	_ = Capture(ctx, "slow", func(ctx context.Context) error {
+		seg = GetSegment(ctx)
+		time.Sleep(5 * time.Millisecond)
+		return err
+	})
+	return seg
+}
+
+func TestSlowSegmentStack(t *testing.T) {
+	ctx, _ := newMemoryEmitterContext(t, Config{SlowSegmentThreshold: time.Millisecond})
+	ctx, root := BeginSegment(ctx, "test")
+	seg := captureSlow(ctx, nil)
+	root.Close(nil)
+
+	assert.Equal(t, true, seg.Annotations[slowAnnotationKey])
+	stack, ok := seg.Metadata[slowStackNamespace][slowStackKey].([]exception.Stack)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.LessOrEqual(t, len(stack), defaultSlowStackMaxFrames)
+	var found bool
+	for _, frame := range stack {
+		assert.False(t, strings.HasSuffix(frame.Label, ".Capture"), "SDK frame %s", frame.Label)
+		if strings.HasSuffix(frame.Label, ".TestSlowSegmentStack") {
+			found = true
+		}
+	}
+	assert.True(t, found, "stack %v", stack)
+
+	// the root segment isn't a subsegment
+	assert.NotContains(t, root.Annotations, slowAnnotationKey)
+}
+
+func TestSlowSegmentStackMaxFrames(t *testing.T) {
+	ctx, _ := newMemoryEmitterContext(t, Config{SlowSegmentThreshold: time.Millisecond, SlowStackMaxFrames: 1})
+	ctx, root := BeginSegment(ctx, "test")
+	seg := captureSlow(ctx, nil)
+	root.Close(nil)
+
+	assert.Len(t, seg.Metadata[slowStackNamespace][slowStackKey], 1)
+}
+
+func TestSlowSegmentStackSkipsFaults(t *testing.T) {
+	ctx, _ := newMemoryEmitterContext(t, Config{SlowSegmentThreshold: time.Millisecond})
+	ctx, root := BeginSegment(ctx, "test")
+	seg := captureSlow(ctx, errors.New("failed"))
+	root.Close(nil)
+
+	assert.True(t, seg.Fault)
+	assert.NotContains(t, seg.Annotations, slowAnnotationKey)
+	assert.NotContains(t, seg.Metadata, slowStackNamespace)
+}
+
+func TestSlowSegmentStackDisabled(t *testing.T) {
+	ctx, _ := newMemoryEmitterContext(t, Config{})
+	ctx, root := BeginSegment(ctx, "test")
+	seg := captureSlow(ctx, nil)
+	root.Close(nil)
+
+	assert.NotContains(t, seg.Annotations, slowAnnotationKey)
+	assert.NotContains(t, seg.Metadata, slowStackNamespace)
+}
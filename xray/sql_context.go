@@ -10,6 +10,7 @@ package xray
 
 import (
 	"bytes"
+	"container/list"
 	"context"
 	"database/sql"
 	"database/sql/driver"
@@ -22,6 +23,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/aws/aws-xray-sdk-go/internal/logger"
 )
 
 // we can't know that the original driver will return driver.ErrSkip in advance.
@@ -39,8 +42,13 @@ type namedValueChecker interface {
 
 var (
 	muInitializedDrivers sync.Mutex
-	initializedDrivers   map[string]struct{}
-	attrHook             func(attr *dbAttribute) // for testing
+	// initializedDrivers indexes the elements of initializedDriversLRU,
+	// which holds the names of the base drivers wrapped by initXRayDriver,
+	// most recently used first.
+	initializedDrivers    map[string]*list.Element
+	initializedDriversLRU *list.List
+	maxInitializedDrivers = 256
+	attrHook              func(attr *dbAttribute) // for testing
 )
 
 func initXRayDriver(driver, dsn string) error {
@@ -48,25 +56,58 @@ func initXRayDriver(driver, dsn string) error {
 	defer muInitializedDrivers.Unlock()
 
 	if initializedDrivers == nil {
-		initializedDrivers = map[string]struct{}{}
+		initializedDrivers = map[string]*list.Element{}
+		initializedDriversLRU = list.New()
 	}
-	if _, ok := initializedDrivers[driver]; ok {
+	if e, ok := initializedDrivers[driver]; ok {
+		initializedDriversLRU.MoveToFront(e)
 		return nil
 	}
 
-	db, err := sql.Open(driver, dsn)
-	if err != nil {
-		return err
+	// The wrapper may already be registered if it was forgotten since, and
+	// database/sql panics when a driver is registered twice.
+	if !isDriverRegistered(driver + ":xray") {
+		db, err := sql.Open(driver, dsn)
+		if err != nil {
+			return err
+		}
+		sql.Register(driver+":xray", &driverDriver{
+			Driver:   db.Driver(),
+			baseName: driver,
+		})
+		db.Close()
+	}
+
+	initializedDrivers[driver] = initializedDriversLRU.PushFront(driver)
+	for initializedDriversLRU.Len() > maxInitializedDrivers {
+		e := initializedDriversLRU.Back()
+		initializedDriversLRU.Remove(e)
+		delete(initializedDrivers, e.Value.(string))
 	}
-	sql.Register(driver+":xray", &driverDriver{
-		Driver:   db.Driver(),
-		baseName: driver,
-	})
-	initializedDrivers[driver] = struct{}{}
-	db.Close()
 	return nil
 }
 
+func isDriverRegistered(name string) bool {
+	for _, d := range sql.Drivers() {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ResetSQLRegistrationsForTest forgets the drivers wrapped by SQLContext and
+// SQLContextWithOptions. database/sql has no way of
+// unregistering drivers, so the wrappers already registered are reused
+// when the same drivers are opened again.
+// It is meant to be used by tests only.
+func ResetSQLRegistrationsForTest() {
+	muInitializedDrivers.Lock()
+	defer muInitializedDrivers.Unlock()
+	initializedDrivers = nil
+	initializedDriversLRU = nil
+}
+
 // SQLContext opens a normalized and traced wrapper around an *sql.DB connection.
 // It uses `sql.Open` internally and shares the same function signature.
 // To ensure passwords are filtered, it is HIGHLY RECOMMENDED that your DSN
@@ -78,6 +119,34 @@ func SQLContext(driver, dsn string) (*sql.DB, error) {
 	return sql.Open(driver+":xray", dsn)
 }
 
+// SQLOptions configures the wrapper returned by SQLContextWithOptions.
+type SQLOptions struct {
+	// TrackDatabaseChanges follows USE statements executed on a connection,
+	// so that subsequent subsegments are named after the selected database
+	// instead of the one detected when the connection was opened.
+	// It is disabled by default as it requires inspecting every executed query.
+	TrackDatabaseChanges bool
+
+	// SkipDetection disables the queries run against the database to detect
+	// its type, version, user and name. DatabaseType, User and DBName are
+	// reported instead, or "Unknown" when left empty.
+	SkipDetection bool
+	DatabaseType  string
+	User          string
+	DBName        string
+
+	// ConnectSegmentName names a standalone segment, e.g. "sql-connect",
+	// recording the connections established and the pings sent without a
+	// segment in their context, as database/sql does when opening
+	// connections in the background. They aren't recorded by default.
+	ConnectSegmentName string
+
+	// QuerySanitizer, if set, returns the query recorded in the
+	// sanitized_query field of subsegments, e.g. DefaultQuerySanitizer to
+	// mask literal values. The raw query is recorded by default.
+	QuerySanitizer func(query string) string
+}
+
 type driverDriver struct {
 	driver.Driver
 	baseName string // the name of the base driver
@@ -88,15 +157,16 @@ func (d *driverDriver) Open(dsn string) (driver.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	attr, err := newDBAttribute(context.Background(), d.baseName, d.Driver, rawConn, dsn, false)
+	attr, err := newDBAttribute(context.Background(), d.baseName, d.Driver, rawConn, dsn, false, SQLOptions{})
 	if err != nil {
 		rawConn.Close()
 		return nil, err
 	}
 
 	conn := &driverConn{
-		Conn: rawConn,
-		attr: attr,
+		Conn:   rawConn,
+		attr:   attr,
+		dbname: attr.dbname,
 	}
 	return conn, nil
 }
@@ -104,11 +174,57 @@ func (d *driverDriver) Open(dsn string) (driver.Conn, error) {
 type driverConn struct {
 	driver.Conn
 	attr *dbAttribute
+
+	// dbname is the current database of the connection. It starts as the
+	// detected database and follows USE statements when trackDBChanges is set.
+	dbname         string
+	trackDBChanges bool
+
+	// connectSegmentName is SQLOptions.ConnectSegmentName.
+	connectSegmentName string
+}
+
+// name returns the name of the subsegments recorded for the connection.
+func (conn *driverConn) name() string {
+	return conn.dbname + conn.attr.host
+}
+
+// trackUse updates the current database after query succeeded, if it is a
+// USE statement.
+func (conn *driverConn) trackUse(query string) {
+	if !conn.trackDBChanges {
+		return
+	}
+	if dbname, ok := parseUseStatement(query); ok {
+		conn.dbname = dbname
+	}
+}
+
+// parseUseStatement returns the database selected by a USE statement.
+// Identifiers may be quoted with backticks, double quotes or brackets.
+func parseUseStatement(query string) (string, bool) {
+	query = strings.TrimSpace(query)
+	if len(query) < 4 || !strings.EqualFold(query[:3], "USE") {
+		return "", false
+	}
+	if c := query[3]; c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+		return "", false
+	}
+	dbname := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query[4:]), ";"))
+	if len(dbname) >= 2 {
+		switch first, last := dbname[0], dbname[len(dbname)-1]; {
+		case first == '`' && last == '`', first == '"' && last == '"', first == '[' && last == ']':
+			dbname = dbname[1 : len(dbname)-1]
+		}
+	}
+	if dbname == "" || strings.ContainsAny(dbname, " \t\n\r;") {
+		return "", false
+	}
+	return dbname, true
 }
 
 func (conn *driverConn) Ping(ctx context.Context) error {
-	return Capture(ctx, conn.attr.dbname+conn.attr.host, func(ctx context.Context) error {
-		conn.attr.populate(ctx, "PING")
+	return conn.attr.captureConnection(ctx, conn.name(), "PING", conn.connectSegmentName, func(ctx context.Context) error {
 		if p, ok := conn.Conn.(driver.Pinger); ok {
 			return p.Ping(ctx)
 		}
@@ -187,13 +303,17 @@ func (conn *driverConn) ExecContext(ctx context.Context, query string, args []dr
 	var err error
 	var result driver.Result
 	if execerCtx, ok := conn.Conn.(driver.ExecerContext); ok {
-		Capture(ctx, conn.attr.dbname+conn.attr.host, func(ctx context.Context) error {
+		Capture(ctx, conn.name(), func(ctx context.Context) error {
 			result, err = execerCtx.ExecContext(ctx, query, args)
 			if err == driver.ErrSkip {
-				conn.attr.populate(ctx, query+msgErrSkip)
+				conn.attr.populateErrSkip(ctx, query)
 				return nil
 			}
 			conn.attr.populate(ctx, query)
+			if err == nil {
+				conn.trackUse(query)
+				addResultMetadata(ctx, result)
+			}
 			return err
 		})
 	} else {
@@ -208,16 +328,22 @@ func (conn *driverConn) ExecContext(ctx context.Context, query string, args []dr
 		}
 		dargs, err0 := namedValuesToValues(args)
 		if err0 != nil {
-			return nil, err0
+			// Let database/sql prepare a statement instead, which may
+			// support named parameters.
+			return nil, driver.ErrSkip
 		}
-		Capture(ctx, conn.attr.dbname+conn.attr.host, func(ctx context.Context) error {
+		Capture(ctx, conn.name(), func(ctx context.Context) error {
 			var err error
 			result, err = execer.Exec(query, dargs)
 			if err == driver.ErrSkip {
-				conn.attr.populate(ctx, query+msgErrSkip)
+				conn.attr.populateErrSkip(ctx, query)
 				return nil
 			}
 			conn.attr.populate(ctx, query)
+			if err == nil {
+				conn.trackUse(query)
+				addResultMetadata(ctx, result)
+			}
 			return err
 		})
 	}
@@ -232,10 +358,10 @@ func (conn *driverConn) QueryContext(ctx context.Context, query string, args []d
 	var err error
 	var rows driver.Rows
 	if queryerCtx, ok := conn.Conn.(driver.QueryerContext); ok {
-		Capture(ctx, conn.attr.dbname+conn.attr.host, func(ctx context.Context) error {
+		Capture(ctx, conn.name(), func(ctx context.Context) error {
 			rows, err = queryerCtx.QueryContext(ctx, query, args)
 			if err == driver.ErrSkip {
-				conn.attr.populate(ctx, query+msgErrSkip)
+				conn.attr.populateErrSkip(ctx, query)
 				return nil
 			}
 			conn.attr.populate(ctx, query)
@@ -253,12 +379,14 @@ func (conn *driverConn) QueryContext(ctx context.Context, query string, args []d
 		}
 		dargs, err0 := namedValuesToValues(args)
 		if err0 != nil {
-			return nil, err0
+			// Let database/sql prepare a statement instead, which may
+			// support named parameters.
+			return nil, driver.ErrSkip
 		}
-		err = Capture(ctx, conn.attr.dbname+conn.attr.host, func(ctx context.Context) error {
+		err = Capture(ctx, conn.name(), func(ctx context.Context) error {
 			rows, err = queryer.Query(query, dargs)
 			if err == driver.ErrSkip {
-				conn.attr.populate(ctx, query+msgErrSkip)
+				conn.attr.populateErrSkip(ctx, query)
 				return nil
 			}
 			conn.attr.populate(ctx, query)
@@ -301,9 +429,13 @@ type dbAttribute struct {
 	user             string
 	dbname           string
 	host             string
+	querySanitizer   func(query string) string
 }
 
-func newDBAttribute(ctx context.Context, driverName string, d driver.Driver, conn driver.Conn, dsn string, filtered bool) (*dbAttribute, error) {
+// newDBAttribute returns the attributes recorded on subsegments of dsn.
+// conn is only used to detect the database and may be nil when
+// opts.SkipDetection is set.
+func newDBAttribute(ctx context.Context, driverName string, d driver.Driver, conn driver.Conn, dsn string, filtered bool, opts SQLOptions) (*dbAttribute, error) {
 	var attr dbAttribute
 
 	// Detect if DSN is a URL or not, set appropriate attribute
@@ -366,24 +498,33 @@ func newDBAttribute(ctx context.Context, driverName string, d driver.Driver, con
 		}
 	}
 
-	// Detect database type and use that to populate attributes
-	var detectors []func(ctx context.Context, conn driver.Conn, attr *dbAttribute) error
-	switch driverName {
-	case "postgres":
-		detectors = append(detectors, postgresDetector)
-	case "mysql":
-		detectors = append(detectors, mysqlDetector)
-	default:
-		detectors = append(detectors, postgresDetector, mysqlDetector, mssqlDetector, oracleDetector)
-	}
-	for _, detector := range detectors {
-		if detector(ctx, conn, &attr) == nil {
-			break
-		}
-		attr.databaseType = "Unknown"
+	attr.querySanitizer = opts.QuerySanitizer
+
+	if opts.SkipDetection {
+		attr.databaseType = valueOrUnknown(opts.DatabaseType)
 		attr.databaseVersion = "Unknown"
-		attr.user = "Unknown"
-		attr.dbname = "Unknown"
+		attr.user = valueOrUnknown(opts.User)
+		attr.dbname = valueOrUnknown(opts.DBName)
+	} else {
+		// Detect database type and use that to populate attributes
+		var detectors []func(ctx context.Context, conn driver.Conn, attr *dbAttribute) error
+		switch driverName {
+		case "postgres":
+			detectors = append(detectors, postgresDetector)
+		case "mysql":
+			detectors = append(detectors, mysqlDetector)
+		default:
+			detectors = append(detectors, postgresDetector, mysqlDetector, mssqlDetector, oracleDetector)
+		}
+		for _, detector := range detectors {
+			if detector(ctx, conn, &attr) == nil {
+				break
+			}
+			attr.databaseType = "Unknown"
+			attr.databaseVersion = "Unknown"
+			attr.user = "Unknown"
+			attr.dbname = "Unknown"
+		}
 	}
 
 	// There's no standard to get SQL driver version information
@@ -408,6 +549,13 @@ func newDBAttribute(ctx context.Context, driverName string, d driver.Driver, con
 	return &attr, nil
 }
 
+func valueOrUnknown(v string) string {
+	if v == "" {
+		return "Unknown"
+	}
+	return v
+}
+
 func postgresDetector(ctx context.Context, conn driver.Conn, attr *dbAttribute) error {
 	attr.databaseType = "Postgres"
 	return queryRow(
@@ -516,7 +664,47 @@ func queryRow(ctx context.Context, conn driver.Conn, query string, dest ...*stri
 	return nil
 }
 
+// captureConnection records fn, establishing a connection or pinging it, in a
+// subsegment named name with the given query. database/sql doesn't always
+// provide a segment when establishing connections, in which case fn runs
+// without a subsegment, or in a standalone segment named segmentName if set.
+func (attr *dbAttribute) captureConnection(ctx context.Context, name, query, segmentName string, fn func(ctx context.Context) error) (err error) {
+	if GetSegment(ctx) == nil {
+		if segmentName == "" {
+			logger.Debugf("Not recording %s of %s: segment cannot be found.", query, name)
+			return fn(ctx)
+		}
+		var seg *Segment
+		ctx, seg = BeginSegment(ctx, segmentName)
+		defer func() { seg.Close(err) }()
+	}
+	return Capture(ctx, name, func(ctx context.Context) error {
+		attr.populate(ctx, query)
+		return fn(ctx)
+	})
+}
+
+// populate records attr and the sanitized query on the subsegment in ctx.
 func (attr *dbAttribute) populate(ctx context.Context, query string) {
+	attr.record(ctx, attr.sanitize(query))
+}
+
+// populateErrSkip records attr and the sanitized query like populate, for a
+// query the driver skipped with driver.ErrSkip, which database/sql retries
+// with a prepared statement.
+func (attr *dbAttribute) populateErrSkip(ctx context.Context, query string) {
+	attr.record(ctx, attr.sanitize(query)+msgErrSkip)
+}
+
+// sanitize returns query sanitized by SQLOptions.QuerySanitizer, if set.
+func (attr *dbAttribute) sanitize(query string) string {
+	if attr.querySanitizer == nil {
+		return query
+	}
+	return attr.querySanitizer(query)
+}
+
+func (attr *dbAttribute) record(ctx context.Context, query string) {
 	seg := GetSegment(ctx)
 
 	if seg == nil {
@@ -536,6 +724,22 @@ func (attr *dbAttribute) populate(ctx context.Context, query string) {
 	seg.Unlock()
 }
 
+// addResultMetadata records the rows affected and last insert id of result
+// on the subsegment in ctx. Drivers not supporting them return errors, which
+// are ignored.
+func addResultMetadata(ctx context.Context, result driver.Result) {
+	seg := GetSegment(ctx)
+	if seg == nil || result == nil {
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil {
+		seg.AddMetadataToNamespace("sql", "rows_affected", n)
+	}
+	if id, err := result.LastInsertId(); err == nil {
+		seg.AddMetadataToNamespace("sql", "last_insert_id", id)
+	}
+}
+
 type driverTx struct {
 	driver.Tx
 }
@@ -571,10 +775,13 @@ func (stmt *driverStmt) ExecContext(ctx context.Context, args []driver.NamedValu
 	var result driver.Result
 	var err error
 	if execerContext, ok := stmt.Stmt.(driver.StmtExecContext); ok {
-		err = Capture(ctx, stmt.attr.dbname+stmt.attr.host, func(ctx context.Context) error {
+		err = Capture(ctx, stmt.conn.name(), func(ctx context.Context) error {
 			stmt.populate(ctx)
 			var err error
 			result, err = execerContext.ExecContext(ctx, args)
+			if err == nil {
+				addResultMetadata(ctx, result)
+			}
 			return err
 		})
 	} else {
@@ -587,16 +794,20 @@ func (stmt *driverStmt) ExecContext(ctx context.Context, args []driver.NamedValu
 		if err0 != nil {
 			return nil, err0
 		}
-		err = Capture(ctx, stmt.attr.dbname+stmt.attr.host, func(ctx context.Context) error {
+		err = Capture(ctx, stmt.conn.name(), func(ctx context.Context) error {
 			stmt.populate(ctx)
 			var err error
 			result, err = stmt.Stmt.Exec(dargs)
+			if err == nil {
+				addResultMetadata(ctx, result)
+			}
 			return err
 		})
 	}
 	if err != nil {
 		return nil, err
 	}
+	stmt.conn.trackUse(stmt.query)
 	return result, nil
 }
 
@@ -608,7 +819,7 @@ func (stmt *driverStmt) QueryContext(ctx context.Context, args []driver.NamedVal
 	var result driver.Rows
 	var err error
 	if queryCtx, ok := stmt.Stmt.(driver.StmtQueryContext); ok {
-		err = Capture(ctx, stmt.attr.dbname+stmt.attr.host, func(ctx context.Context) error {
+		err = Capture(ctx, stmt.conn.name(), func(ctx context.Context) error {
 			stmt.populate(ctx)
 			var err error
 			result, err = queryCtx.QueryContext(ctx, args)
@@ -624,7 +835,7 @@ func (stmt *driverStmt) QueryContext(ctx context.Context, args []driver.NamedVal
 		if err0 != nil {
 			return nil, err0
 		}
-		err = Capture(ctx, stmt.attr.dbname+stmt.attr.host, func(ctx context.Context) error {
+		err = Capture(ctx, stmt.conn.name(), func(ctx context.Context) error {
 			stmt.populate(ctx)
 			var err error
 			result, err = stmt.Stmt.Query(dargs)
@@ -759,6 +970,7 @@ func stripPasswords(dsn string) string {
 func processNilSegment(ctx context.Context) {
 	cfg := GetRecorder(ctx)
 	failedMessage := "failed to get segment from context since segment is nil"
+	contextMissing.add(configMetricsObserver(cfg), 1)
 	if cfg != nil && cfg.ContextMissingStrategy != nil {
 		cfg.ContextMissingStrategy.ContextMissing(failedMessage)
 	} else {
@@ -12,7 +12,9 @@ package xray
 
 import (
 	"context"
+	"database/sql"
 	"database/sql/driver"
+	"errors"
 	"sync"
 )
 
@@ -33,6 +35,42 @@ func SQLConnector(dsn string, connector driver.Connector) driver.Connector {
 	}
 }
 
+// SQLContextWithOptions opens a normalized and traced wrapper around an *sql.DB
+// connection like SQLContext, applying the given SQLOptions.
+func SQLContextWithOptions(driverName, dsn string, opts SQLOptions) (*sql.DB, error) {
+	if err := initXRayDriver(driverName, dsn); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driverName+":xray", dsn)
+	if err != nil {
+		return nil, err
+	}
+	d := db.Driver().(*driverDriver)
+	db.Close()
+
+	c, err := d.openConnector(dsn, opts)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(c), nil
+}
+
+// SetSQLDatabase sets the database the subsequent subsegments of conn are
+// named after. It is meant for applications switching databases in ways
+// that SQLOptions.TrackDatabaseChanges does not detect.
+// conn must be obtained from a database opened by SQLContext,
+// SQLContextWithOptions or SQLConnector.
+func SetSQLDatabase(conn *sql.Conn, dbname string) error {
+	return conn.Raw(func(rawConn interface{}) error {
+		dc, ok := rawConn.(*driverConn)
+		if !ok {
+			return errors.New("xray: connection is not traced")
+		}
+		dc.dbname = dbname
+		return nil
+	})
+}
+
 func (conn *driverConn) ResetSession(ctx context.Context) error {
 	if sr, ok := conn.Conn.(driver.SessionResetter); ok {
 		return sr.ResetSession(ctx)
@@ -45,6 +83,7 @@ type driverConnector struct {
 	driver   *driverDriver
 	filtered bool
 	name     string
+	opts     SQLOptions
 
 	mu   sync.RWMutex
 	attr *dbAttribute
@@ -56,8 +95,7 @@ func (c *driverConnector) Connect(ctx context.Context) (driver.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = Capture(ctx, attr.dbname+attr.host, func(ctx context.Context) error {
-		attr.populate(ctx, "CONNECT")
+	err = attr.captureConnection(ctx, attr.dbname+attr.host, "CONNECT", c.opts.ConnectSegmentName, func(ctx context.Context) error {
 		var err error
 		rawConn, err = c.Connector.Connect(ctx)
 		return err
@@ -67,8 +105,12 @@ func (c *driverConnector) Connect(ctx context.Context) (driver.Conn, error) {
 	}
 
 	conn := &driverConn{
-		Conn: rawConn,
-		attr: attr,
+		Conn:           rawConn,
+		attr:           attr,
+		dbname:         attr.dbname,
+		trackDBChanges: c.opts.TrackDatabaseChanges,
+
+		connectSegmentName: c.opts.ConnectSegmentName,
 	}
 	return conn, nil
 }
@@ -86,13 +128,17 @@ func (c *driverConnector) getAttr(ctx context.Context) (*dbAttribute, error) {
 	if c.attr != nil {
 		return c.attr, nil
 	}
-	conn, err := c.Connector.Connect(ctx)
-	if err != nil {
-		return nil, err
+	var conn driver.Conn
+	if !c.opts.SkipDetection {
+		var err error
+		conn, err = c.Connector.Connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
 	}
-	defer conn.Close()
 
-	attr, err = newDBAttribute(ctx, c.driver.baseName, c.driver.Driver, conn, c.name, c.filtered)
+	attr, err := newDBAttribute(ctx, c.driver.baseName, c.driver.Driver, conn, c.name, c.filtered, c.opts)
 	if err != nil {
 		return nil, err
 	}
@@ -128,6 +174,10 @@ func (c *fallbackConnector) Driver() driver.Driver {
 }
 
 func (d *driverDriver) OpenConnector(name string) (driver.Connector, error) {
+	return d.openConnector(name, SQLOptions{})
+}
+
+func (d *driverDriver) openConnector(name string, opts SQLOptions) (driver.Connector, error) {
 	var c driver.Connector
 	if dctx, ok := d.Driver.(driver.DriverContext); ok {
 		var err error
@@ -146,6 +196,7 @@ func (d *driverDriver) OpenConnector(name string) (driver.Connector, error) {
 		driver:    d,
 		filtered:  false,
 		name:      name,
+		opts:      opts,
 		// initialized attr lazy because we have no context here.
 	}
 	return c, nil
@@ -11,12 +11,15 @@
 package xray
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
+	"regexp"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-xray-sdk-go/strategy/ctxmissing"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -74,3 +77,273 @@ func TestDriverVersion(t *testing.T) {
 	assert.Equal(t, "sanitized-dsn", subseg.SQL.ConnectionString)
 	assert.Equal(t, "3.1415926535", subseg.SQL.DriverVersion)
 }
+
+func captureUseDatabase(t *testing.T, dsn string, opts SQLOptions, use func(ctx context.Context, conn *sql.Conn) error) []*Segment {
+	mockdb, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockdb.Close()
+	mockPostgreSQL(mock, nil)
+	mock.ExpectExec("USE `tenant_db`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(int64(1)))
+
+	db, err := SQLContextWithOptions("sqlmock", dsn, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	// Execute SQL on a single connection
+	ctx, root := BeginSegment(ctx, "test")
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := use(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+	var one int64
+	if err := conn.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	root.Close(nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// assertion
+	seg, err := td.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	subsegs := make([]*Segment, len(seg.Subsegments))
+	for i, raw := range seg.Subsegments {
+		if err := json.Unmarshal(raw, &subsegs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return subsegs
+}
+
+func TestSQLContextTrackDatabaseChanges(t *testing.T) {
+	subsegs := captureUseDatabase(t, "test-track-database-changes", SQLOptions{TrackDatabaseChanges: true}, func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "USE `tenant_db`")
+		return err
+	})
+
+	// CONNECT, USE and SELECT
+	if assert.Len(t, subsegs, 3) {
+		assert.Equal(t, "test database", subsegs[1].Name)
+		assert.Equal(t, "USE `tenant_db`", subsegs[1].SQL.SanitizedQuery)
+		assert.Equal(t, "tenant_db", subsegs[2].Name)
+		assert.Equal(t, "SELECT 1", subsegs[2].SQL.SanitizedQuery)
+	}
+}
+
+func TestSQLContextIgnoreDatabaseChanges(t *testing.T) {
+	subsegs := captureUseDatabase(t, "test-ignore-database-changes", SQLOptions{}, func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "USE `tenant_db`")
+		return err
+	})
+
+	if assert.Len(t, subsegs, 3) {
+		assert.Equal(t, "test database", subsegs[2].Name)
+	}
+}
+
+func TestSetSQLDatabase(t *testing.T) {
+	subsegs := captureUseDatabase(t, "test-set-sql-database", SQLOptions{}, func(ctx context.Context, conn *sql.Conn) error {
+		if _, err := conn.ExecContext(ctx, "USE `tenant_db`"); err != nil {
+			return err
+		}
+		return SetSQLDatabase(conn, "tenant_db")
+	})
+
+	if assert.Len(t, subsegs, 3) {
+		assert.Equal(t, "tenant_db", subsegs[2].Name)
+	}
+}
+
+func TestSQLContextSkipDetection(t *testing.T) {
+	dsn := "test-skip-detection"
+	mockdb, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockdb.Close()
+	// no detection query is expected
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(int64(1)))
+
+	db, err := SQLContextWithOptions("sqlmock", dsn, SQLOptions{
+		SkipDetection: true,
+		DatabaseType:  "Postgres",
+		DBName:        "app",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	var one int64
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		t.Fatal(err)
+	}
+	root.Close(nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	seg, err := td.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CONNECT and SELECT
+	if !assert.Len(t, seg.Subsegments, 2) {
+		return
+	}
+	var subseg *Segment
+	if err := json.Unmarshal(seg.Subsegments[1], &subseg); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "app", subseg.Name)
+	assert.Equal(t, "SELECT 1", subseg.SQL.SanitizedQuery)
+	assert.Equal(t, "Postgres", subseg.SQL.DatabaseType)
+	assert.Equal(t, "Unknown", subseg.SQL.DatabaseVersion)
+	assert.Equal(t, "Unknown", subseg.SQL.User)
+}
+
+func TestSQLConnectWithoutSegment(t *testing.T) {
+	dsn := "test-connect-without-segment"
+	mockdb, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockdb.Close()
+	mockPostgreSQL(mock, nil)
+
+	db, err := SQLContextWithOptions("sqlmock", dsn, SQLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx = withSamplingConfig(ctx, func(cfg *Config) {
+		cfg.ContextMissingStrategy = ctxmissing.NewDefaultRuntimeErrorStrategy()
+	})
+
+	// establishes a connection and pings it without a segment
+	assert.NotPanics(t, func() {
+		assert.NoError(t, db.PingContext(ctx))
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, err = td.Recv()
+	assert.Error(t, err, "nothing should be emitted")
+}
+
+func TestSQLConnectSegmentName(t *testing.T) {
+	dsn := "test-connect-segment-name"
+	mockdb, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockdb.Close()
+	mockPostgreSQL(mock, nil)
+
+	db, err := SQLContextWithOptions("sqlmock", dsn, SQLOptions{ConnectSegmentName: "sql-connect"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	if err := db.PingContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var queries []string
+	for i := 0; i < 2; i++ {
+		seg, err := td.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "sql-connect", seg.Name)
+		if assert.Len(t, seg.Subsegments, 1) {
+			var subseg *Segment
+			if err := json.Unmarshal(seg.Subsegments[0], &subseg); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, "remote", subseg.Namespace)
+			queries = append(queries, subseg.SQL.SanitizedQuery)
+		}
+	}
+	assert.Equal(t, []string{"CONNECT", "PING"}, queries)
+}
+
+func TestSQLContextQuerySanitizer(t *testing.T) {
+	dsn := "test-query-sanitizer"
+	mockdb, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockdb.Close()
+	mockPostgreSQL(mock, nil)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name FROM users WHERE email = 'jane@example.com' AND age > 42")).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("jane"))
+	mock.ExpectPrepare(regexp.QuoteMeta("UPDATE users SET token = 'secret' WHERE id = $1")).
+		ExpectExec().WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	db, err := SQLContextWithOptions("sqlmock", dsn, SQLOptions{QuerySanitizer: DefaultQuerySanitizer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	var name string
+	if err := db.QueryRowContext(ctx, "SELECT name FROM users WHERE email = 'jane@example.com' AND age > 42").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := db.PrepareContext(ctx, "UPDATE users SET token = 'secret' WHERE id = $1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stmt.ExecContext(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+	root.Close(nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	seg, err := td.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queries []string
+	for _, raw := range seg.Subsegments {
+		var subseg *Segment
+		if err := json.Unmarshal(raw, &subseg); err != nil {
+			t.Fatal(err)
+		}
+		queries = append(queries, subseg.SQL.SanitizedQuery)
+	}
+	assert.Contains(t, queries, "SELECT name FROM users WHERE email = ? AND age > ?")
+	assert.Contains(t, queries, "UPDATE users SET token = ? WHERE id = $1")
+	for _, q := range queries {
+		assert.NotContains(t, q, "secret")
+		assert.NotContains(t, q, "jane@example.com")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import "strings"
+
+// DefaultQuerySanitizer replaces the string and numeric literals of query
+// with "?", e.g. for SQLOptions.QuerySanitizer. Quoted strings may escape
+// quotes by doubling them or with backslashes, and dollar-quoted Postgres
+// strings are supported. Identifiers, placeholders and comments are kept.
+func DefaultQuerySanitizer(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'':
+			b.WriteByte('?')
+			i = skipQuoted(query, i, c, true)
+		case c == '"' || c == '`':
+			j := skipQuoted(query, i, c, false)
+			b.WriteString(query[i:j])
+			i = j
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				j = len(query) - i
+			}
+			b.WriteString(query[i : i+j])
+			i += j
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			j := strings.Index(query[i+2:], "*/")
+			if j < 0 {
+				j = len(query) - i
+			} else {
+				j += 4
+			}
+			b.WriteString(query[i : i+j])
+			i += j
+		case c == '$':
+			if tag := dollarQuoteTag(query, i); tag != "" {
+				b.WriteByte('?')
+				j := strings.Index(query[i+len(tag):], tag)
+				if j < 0 {
+					i = len(query)
+				} else {
+					i += len(tag) + j + len(tag)
+				}
+				continue
+			}
+			// positional placeholder, such as $1
+			j := skipIdentifier(query, i+1)
+			b.WriteString(query[i:j])
+			i = j
+		case c == ':' || c == '@':
+			// named placeholder, such as :1 or @p1
+			j := skipIdentifier(query, i+1)
+			b.WriteString(query[i:j])
+			i = j
+		case isDigit(c) || c == '.' && i+1 < len(query) && isDigit(query[i+1]):
+			b.WriteByte('?')
+			i = skipNumber(query, i)
+		case isIdentifierStart(c):
+			j := skipIdentifier(query, i)
+			b.WriteString(query[i:j])
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// skipQuoted returns the index following the string quoted with q starting
+// at i, or the length of query if it is not terminated. Quotes are escaped
+// by doubling them, or with a backslash if backslash is set.
+func skipQuoted(query string, i int, q byte, backslash bool) int {
+	for i++; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if backslash {
+				i++
+			}
+		case q:
+			if i+1 < len(query) && query[i+1] == q {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// dollarQuoteTag returns the tag of the dollar-quoted string starting at i,
+// such as $$ or $body$, or an empty string if there is none.
+func dollarQuoteTag(query string, i int) string {
+	j := i + 1
+	if j < len(query) && isIdentifierStart(query[j]) {
+		for j < len(query) && isIdentifierStart(query[j]) || j < len(query) && isDigit(query[j]) {
+			j++
+		}
+	}
+	if j < len(query) && query[j] == '$' {
+		return query[i : j+1]
+	}
+	return ""
+}
+
+// skipNumber returns the index following the number starting at i.
+func skipNumber(query string, i int) int {
+	if strings.HasPrefix(query[i:], "0x") || strings.HasPrefix(query[i:], "0X") {
+		i += 2
+		for i < len(query) && strings.IndexByte("0123456789abcdefABCDEF", query[i]) >= 0 {
+			i++
+		}
+		return i
+	}
+	for i < len(query) && (isDigit(query[i]) || query[i] == '.') {
+		i++
+	}
+	if i < len(query) && (query[i] == 'e' || query[i] == 'E') {
+		j := i + 1
+		if j < len(query) && (query[j] == '+' || query[j] == '-') {
+			j++
+		}
+		if j < len(query) && isDigit(query[j]) {
+			for i = j; i < len(query) && isDigit(query[i]); i++ {
+			}
+		}
+	}
+	return i
+}
+
+// skipIdentifier returns the index following the identifier starting at i.
+func skipIdentifier(query string, i int) int {
+	for i < len(query) && (isIdentifierStart(query[i]) || isDigit(query[i]) || query[i] == '$') {
+		i++
+	}
+	return i
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isIdentifierStart reports whether c may start an identifier. Bytes of
+// multi-byte UTF-8 characters are treated as letters.
+func isIdentifierStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= 0x80
+}
@@ -0,0 +1,63 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultQuerySanitizer(t *testing.T) {
+	cases := []struct {
+		name, query, expected string
+	}{
+		{"no literals", "SELECT * FROM users", "SELECT * FROM users"},
+		{"string", "SELECT * FROM users WHERE name = 'jane'", "SELECT * FROM users WHERE name = ?"},
+		{"doubled quote", "SELECT * FROM users WHERE name = 'o''brien'", "SELECT * FROM users WHERE name = ?"},
+		{"backslash quote", `SELECT * FROM users WHERE name = 'o\'brien' AND id = 1`, "SELECT * FROM users WHERE name = ? AND id = ?"},
+		{"unterminated string", "SELECT 'abc", "SELECT ?"},
+		{"integer", "SELECT * FROM users WHERE id = 42", "SELECT * FROM users WHERE id = ?"},
+		{"decimal", "UPDATE items SET price = 3.14 WHERE stock < .5", "UPDATE items SET price = ? WHERE stock < ?"},
+		{"exponent", "SELECT 1e10, 2.5E-3", "SELECT ?, ?"},
+		{"hex", "SELECT 0xDEADBEEF", "SELECT ?"},
+		{"list", "SELECT * FROM t WHERE id IN (1, 2, 3)", "SELECT * FROM t WHERE id IN (?, ?, ?)"},
+		{"identifier digits", "SELECT col1 FROM table2", "SELECT col1 FROM table2"},
+		{"quoted identifiers", "SELECT \"col 1\", `col2` FROM t", "SELECT \"col 1\", `col2` FROM t"},
+		{"dollar quoted", "SELECT $$it's a secret$$", "SELECT ?"},
+		{"tagged dollar quoted", "SELECT $tag$a $$ b$tag$, 1", "SELECT ?, ?"},
+		{"positional placeholders", "SELECT * FROM t WHERE a = $1 AND b = $2", "SELECT * FROM t WHERE a = $1 AND b = $2"},
+		{"named placeholders", "SELECT * FROM t WHERE a = :1 AND b = @p2 AND c = ?", "SELECT * FROM t WHERE a = :1 AND b = @p2 AND c = ?"},
+		{"line comment", "SELECT 1 -- id 42\nFROM t", "SELECT ? -- id 42\nFROM t"},
+		{"block comment", "SELECT /* 'x' */ 'y'", "SELECT /* 'x' */ ?"},
+		{"unicode", "SELECT * FROM tëst1 WHERE a = 'ü'", "SELECT * FROM tëst1 WHERE a = ?"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, DefaultQuerySanitizer(c.query))
+		})
+	}
+}
+
+func TestQuerySanitizerErrSkip(t *testing.T) {
+	attr := &dbAttribute{querySanitizer: strings.ToUpper}
+
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+	ctx, root := BeginSegment(ctx, "test")
+	ctx, subseg := BeginSubsegment(ctx, "query")
+	attr.populateErrSkip(ctx, "select 1")
+	subseg.Close(nil)
+	root.Close(nil)
+
+	// the suffix is appended after sanitization
+	assert.Equal(t, "SELECT 1"+msgErrSkip, subseg.GetSQL().SanitizedQuery)
+}
@@ -9,8 +9,13 @@
 package xray
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -372,3 +377,368 @@ func TestStripPasswords(t *testing.T) {
 		}
 	}
 }
+
+func TestParseUseStatement(t *testing.T) {
+	tc := []struct {
+		query  string
+		dbname string
+		ok     bool
+	}{
+		{query: "USE tenant_db", dbname: "tenant_db", ok: true},
+		{query: "  use tenant_db;  ", dbname: "tenant_db", ok: true},
+		{query: "USE\ttenant_db ;", dbname: "tenant_db", ok: true},
+		{query: "USE `tenant-db`", dbname: "tenant-db", ok: true},
+		{query: `USE "tenant_db"`, dbname: "tenant_db", ok: true},
+		{query: "USE [tenant_db]", dbname: "tenant_db", ok: true},
+		{query: "USER tenant_db"},
+		{query: "USE"},
+		{query: "USE ;"},
+		{query: "USE a; DROP TABLE b"},
+		{query: "SELECT * FROM tenant_db.users"},
+	}
+
+	for _, tt := range tc {
+		dbname, ok := parseUseStatement(tt.query)
+		assert.Equal(t, tt.ok, ok, tt.query)
+		assert.Equal(t, tt.dbname, dbname, tt.query)
+	}
+}
+
+// legacyDriver wraps a driver so that its connections and statements only
+// implement the driver.Execer and driver.Stmt Exec methods.
+type legacyDriver struct {
+	driver.Driver
+}
+
+func (d legacyDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return legacyConn{conn}, nil
+}
+
+type legacyConn struct {
+	driver.Conn
+}
+
+func (conn legacyConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := conn.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return legacyStmt{stmt}, nil
+}
+
+func (conn legacyConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return conn.Conn.(driver.Execer).Exec(query, args)
+}
+
+type legacyStmt struct {
+	driver.Stmt
+}
+
+var registerLegacyDriver sync.Once
+
+// captureExec runs exec against dsn opened with the given driver and returns
+// the subsegment of the executed statement.
+func captureExec(driverName, dsn string, exec func(ctx context.Context, db *sql.DB) error) (*Segment, error) {
+	ctx, td := NewTestDaemon()
+	defer td.Close()
+
+	db, err := SQLContext(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ctx, root := BeginSegment(ctx, "test")
+	if err := exec(ctx, db); err != nil {
+		return nil, err
+	}
+	root.Close(nil)
+
+	seg, err := td.Recv()
+	if err != nil {
+		return nil, err
+	}
+	var subseg *Segment
+	if err := json.Unmarshal(seg.Subsegments[len(seg.Subsegments)-1], &subseg); err != nil {
+		return nil, err
+	}
+	return subseg, nil
+}
+
+func execUpdate(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "UPDATE users SET name = 'x'")
+	return err
+}
+
+func execPreparedUpdate(ctx context.Context, db *sql.DB) error {
+	stmt, err := db.PrepareContext(ctx, "UPDATE users SET name = 'x'")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
+
+func TestExecResultMetadata(t *testing.T) {
+	dsn := "test-exec-result"
+	db, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mockPostgreSQL(mock, nil)
+	mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(7, 3))
+
+	subseg, err := captureExec("sqlmock", dsn, execUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, "UPDATE users SET name = 'x'", subseg.SQL.SanitizedQuery)
+	assert.Equal(t, map[string]interface{}{"rows_affected": 3.0, "last_insert_id": 7.0}, subseg.Metadata["sql"])
+}
+
+func TestExecResultMetadataPrepared(t *testing.T) {
+	dsn := "test-exec-result-prepared"
+	db, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mockPostgreSQL(mock, nil)
+	mock.ExpectPrepare(`UPDATE users`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 2))
+
+	subseg, err := captureExec("sqlmock", dsn, execPreparedUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, map[string]interface{}{"rows_affected": 2.0, "last_insert_id": 0.0}, subseg.Metadata["sql"])
+}
+
+func TestExecResultMetadataUnsupported(t *testing.T) {
+	dsn := "test-exec-result-unsupported"
+	db, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mockPostgreSQL(mock, nil)
+	mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewErrorResult(errors.New("not supported")))
+
+	subseg, err := captureExec("sqlmock", dsn, execUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Nil(t, subseg.Metadata["sql"])
+	assert.False(t, subseg.Fault)
+}
+
+func TestExecResultMetadataLegacyExecer(t *testing.T) {
+	dsn := "test-exec-result-legacy"
+	db, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	registerLegacyDriver.Do(func() {
+		sql.Register("sqlmock-legacy", legacyDriver{db.Driver()})
+	})
+	mockPostgreSQL(mock, nil)
+	mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(7, 3))
+	mock.ExpectPrepare(`UPDATE users`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 2))
+
+	subseg, err := captureExec("sqlmock-legacy", dsn, execUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]interface{}{"rows_affected": 3.0, "last_insert_id": 7.0}, subseg.Metadata["sql"])
+
+	subseg, err = captureExec("sqlmock-legacy", dsn, execPreparedUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]interface{}{"rows_affected": 2.0, "last_insert_id": 0.0}, subseg.Metadata["sql"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// contextDriver wraps a driver so that its connections only implement the
+// driver.ExecerContext and driver.QueryerContext methods.
+type contextDriver struct {
+	driver.Driver
+}
+
+func (d contextDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return contextConn{conn}, nil
+}
+
+type contextConn struct {
+	driver.Conn
+}
+
+func (conn contextConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return conn.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (conn contextConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return conn.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+var registerContextDriver sync.Once
+
+func execNamedUpdate(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "UPDATE users SET name = :name", sql.Named("name", "x"))
+	return err
+}
+
+func execPositionalUpdate(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "UPDATE users SET name = ?", "x")
+	return err
+}
+
+func TestExecNamedArgs(t *testing.T) {
+	dsn := "test-exec-named-args"
+	db, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mockPostgreSQL(mock, nil)
+	mock.ExpectExec(`UPDATE users`).WithArgs(sql.Named("name", "x")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users`).WithArgs("x").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	subseg, err := captureExec("sqlmock", dsn, execNamedUpdate)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "UPDATE users SET name = :name", subseg.SQL.SanitizedQuery)
+	}
+	_, err = captureExec("sqlmock", dsn, execPositionalUpdate)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExecNamedArgsContextExecer(t *testing.T) {
+	dsn := "test-exec-named-args-context"
+	db, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	registerContextDriver.Do(func() {
+		sql.Register("sqlmock-context", contextDriver{db.Driver()})
+	})
+	mockPostgreSQL(mock, nil)
+	mock.ExpectExec(`UPDATE users`).WithArgs(sql.Named("name", "x")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users`).WithArgs("x").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT name`).WithArgs(sql.Named("id", 1)).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("x"))
+
+	_, err = captureExec("sqlmock-context", dsn, execNamedUpdate)
+	assert.NoError(t, err)
+	_, err = captureExec("sqlmock-context", dsn, execPositionalUpdate)
+	assert.NoError(t, err)
+	subseg, err := captureExec("sqlmock-context", dsn, func(ctx context.Context, db *sql.DB) error {
+		var name string
+		return db.QueryRowContext(ctx, "SELECT name FROM users WHERE id = :id", sql.Named("id", 1)).Scan(&name)
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "SELECT name FROM users WHERE id = :id", subseg.SQL.SanitizedQuery)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExecNamedArgsLegacyExecer(t *testing.T) {
+	dsn := "test-exec-named-args-legacy"
+	db, mock, err := sqlmock.NewWithDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	registerLegacyDriver.Do(func() {
+		sql.Register("sqlmock-legacy", legacyDriver{db.Driver()})
+	})
+	mockPostgreSQL(mock, nil)
+	mock.ExpectExec(`UPDATE users`).WithArgs("x").WillReturnResult(sqlmock.NewResult(0, 1))
+	// named arguments can't be passed to Execer, database/sql prepares a
+	// statement instead, which fails the same way without StmtExecContext
+	mock.ExpectPrepare(`UPDATE users`)
+
+	_, err = captureExec("sqlmock-legacy", dsn, execPositionalUpdate)
+	assert.NoError(t, err)
+	_, err = captureExec("sqlmock-legacy", dsn, execNamedUpdate)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Named Parameters")
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLContextRepeatedInitialization(t *testing.T) {
+	defer ResetSQLRegistrationsForTest()
+
+	for i := 0; i < 3; i++ {
+		ResetSQLRegistrationsForTest()
+
+		dsn := fmt.Sprintf("test-repeated-initialization-%d", i)
+		mockdb, mock, err := sqlmock.NewWithDSN(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mockPostgreSQL(mock, nil)
+
+		assert.NotPanics(t, func() {
+			subseg, err := capturePing(dsn)
+			if assert.NoError(t, err) {
+				assert.Equal(t, "Postgres", subseg.SQL.DatabaseType)
+			}
+		})
+		assert.NoError(t, mock.ExpectationsWereMet())
+		mockdb.Close()
+	}
+}
+
+func TestSQLContextInitializedDriversBound(t *testing.T) {
+	mockdb, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mockdb.Close()
+
+	ResetSQLRegistrationsForTest()
+	defer ResetSQLRegistrationsForTest()
+	defer func(max int) { maxInitializedDrivers = max }(maxInitializedDrivers)
+	maxInitializedDrivers = 2
+
+	names := []string{"sqlmock-bound-0", "sqlmock-bound-1", "sqlmock-bound-2"}
+	for _, name := range names {
+		if !isDriverRegistered(name) {
+			sql.Register(name, mockdb.Driver())
+		}
+		assert.NoError(t, initXRayDriver(name, "test-bound"))
+	}
+
+	muInitializedDrivers.Lock()
+	assert.Len(t, initializedDrivers, 2)
+	assert.NotContains(t, initializedDrivers, "sqlmock-bound-0")
+	muInitializedDrivers.Unlock()
+
+	// the evicted driver is still registered and can be initialized again
+	assert.NotPanics(t, func() {
+		assert.NoError(t, initXRayDriver("sqlmock-bound-0", "test-bound"))
+	})
+	muInitializedDrivers.Lock()
+	assert.Len(t, initializedDrivers, 2)
+	assert.Contains(t, initializedDrivers, "sqlmock-bound-0")
+	assert.NotContains(t, initializedDrivers, "sqlmock-bound-1")
+	muInitializedDrivers.Unlock()
+}
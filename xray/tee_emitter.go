@@ -0,0 +1,218 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"context"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// teeQueueSize is the number of segments queued for each secondary emitter
+// of a TeeEmitter, beyond which segments are dropped.
+const teeQueueSize = 1000
+
+// TeeEmitter sends segments to a primary emitter, usually the one sending
+// them to the daemon, and to secondary emitters, e.g. a WriterEmitter
+// archiving them. Segments are serialized once: secondary emitters are given
+// a copy of each segment holding the documents serialized for the primary
+// emitter, so emitters inspecting the segment tree rather than serializing
+// it, such as ConsoleEmitter, should be the primary one.
+//
+// The primary emitter is called synchronously, as usual. Each secondary
+// emitter is called from its own goroutine, so that a slow or failing one
+// doesn't delay the primary emitter, and is queued up to 1000 segments;
+// segments which don't fit in the queue are dropped and counted by Dropped.
+// Close should be called once the emitter is no longer used.
+type TeeEmitter struct {
+	primary     Emitter
+	secondaries []*teeSecondary
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// teeSecondary is a secondary emitter of a TeeEmitter and its queue.
+type teeSecondary struct {
+	emitter Emitter
+	queue   chan teeItem
+	dropped atomic.Int64
+}
+
+// teeItem is a segment queued for a secondary emitter, or a Flush waiting
+// for the segments queued before it to be emitted.
+type teeItem struct {
+	seg     *Segment
+	flushed chan struct{}
+}
+
+// NewTeeEmitter initializes and returns a pointer to an instance of
+// TeeEmitter sending segments to primary and to the secondary emitters.
+func NewTeeEmitter(primary Emitter, secondary ...Emitter) *TeeEmitter {
+	te := &TeeEmitter{
+		primary: primary,
+		done:    make(chan struct{}),
+	}
+	for _, e := range secondary {
+		s := &teeSecondary{emitter: e, queue: make(chan teeItem, teeQueueSize)}
+		te.secondaries = append(te.secondaries, s)
+		go s.run(te.done)
+	}
+	return te
+}
+
+// RefreshEmitterWithAddress refreshes the address of the primary emitter.
+// Secondary emitters don't send segments to the daemon.
+func (te *TeeEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {
+	te.primary.RefreshEmitterWithAddress(raddr)
+}
+
+// RefreshEmitterWithUnixAddress refreshes the Unix domain socket of the
+// primary emitter, if it implements UnixEmitter.
+func (te *TeeEmitter) RefreshEmitterWithUnixAddress(raddr *net.UnixAddr) {
+	if ue, ok := te.primary.(UnixEmitter); ok {
+		ue.RefreshEmitterWithUnixAddress(raddr)
+	}
+}
+
+// Emit sends segment or subsegment to the primary emitter, and queues it for
+// the secondary emitters, if root segment is sampled.
+// seg has a write lock acquired by the caller.
+func (te *TeeEmitter) Emit(seg *Segment) {
+	defer func() {
+		if r := recover(); r != nil {
+			seg.log().Errorf("Panic emitting segment: %s\n%s", r, string(debug.Stack()))
+		}
+	}()
+
+	if seg == nil || !seg.ParentSegment.Sampled {
+		return
+	}
+
+	docs := packSegments(seg, nil)
+	te.emitPrimary(seg, docs)
+
+	if len(docs) == 0 {
+		return
+	}
+	select {
+	case <-te.done:
+		return
+	default:
+	}
+	snapshot := packedSegment(seg, docs)
+	for _, s := range te.secondaries {
+		select {
+		case s.queue <- teeItem{seg: snapshot}:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// emitPrimary sends the documents of seg to the primary emitter.
+// seg has a write lock acquired by the caller.
+func (te *TeeEmitter) emitPrimary(seg *Segment, docs [][]byte) {
+	// packSegments serializes seg again unless packed is set
+	seg.packed = append([][]byte{}, docs...)
+	defer func() {
+		seg.packed = nil
+	}()
+	te.primary.Emit(seg)
+}
+
+// packedSegment returns a copy of seg holding docs, its serialized documents,
+// which can be emitted once seg is unlocked.
+func packedSegment(seg *Segment, docs [][]byte) *Segment {
+	s := &Segment{
+		TraceID:       seg.TraceID,
+		ID:            seg.ID,
+		ParentID:      seg.ParentID,
+		Name:          seg.Name,
+		Type:          seg.Type,
+		Sampled:       true,
+		Configuration: seg.ParentSegment.Configuration,
+		packed:        docs,
+	}
+	s.ParentSegment = s
+	return s
+}
+
+// Dropped returns the number of segments dropped for each secondary emitter,
+// in order, because its queue was full.
+func (te *TeeEmitter) Dropped() []int64 {
+	dropped := make([]int64, len(te.secondaries))
+	for i, s := range te.secondaries {
+		dropped[i] = s.dropped.Load()
+	}
+	return dropped
+}
+
+// Flush waits until the segments queued for the secondary emitters are
+// emitted, then flushes the emitters implementing Flusher, see Flusher.
+func (te *TeeEmitter) Flush(ctx context.Context) error {
+	for _, s := range te.secondaries {
+		flushed := make(chan struct{})
+		select {
+		case s.queue <- teeItem{flushed: flushed}:
+		case <-te.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case <-flushed:
+		case <-te.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	emitters := []Emitter{te.primary}
+	for _, s := range te.secondaries {
+		emitters = append(emitters, s.emitter)
+	}
+	for _, e := range emitters {
+		if f, ok := e.(Flusher); ok {
+			if err := f.Flush(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close stops the goroutines calling the secondary emitters. Segments still
+// queued are dropped, and segments emitted afterwards are only sent to the
+// primary emitter. Close doesn't close the emitters.
+func (te *TeeEmitter) Close() error {
+	te.closeOnce.Do(func() {
+		close(te.done)
+	})
+	return nil
+}
+
+func (s *teeSecondary) run(done <-chan struct{}) {
+	for {
+		select {
+		case item := <-s.queue:
+			if item.flushed != nil {
+				close(item.flushed)
+				continue
+			}
+			item.seg.Lock()
+			s.emitter.Emit(item.seg)
+			item.seg.Unlock()
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTeeEmitterContext returns a context sampling every segment and emitting
+// them to te.
+func newTeeEmitterContext(t *testing.T, te *TeeEmitter) context.Context {
+	t.Cleanup(func() { te.Close() })
+	ctx, err := ContextWithConfig(context.Background(), Config{
+		Emitter:          te,
+		SamplingStrategy: sampling.NewFuncStrategy(func(*sampling.Request) bool { return true }),
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return ctx
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// blockingWriter blocks writes until it's unblocked.
+type blockingWriter struct {
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return len(p), nil
+}
+
+func TestTeeEmitterHandler(t *testing.T) {
+	var primary, secondary syncBuffer
+	me := NewMemoryEmitter()
+	te := NewTeeEmitter(NewWriterEmitter(&primary), NewWriterEmitter(&secondary), me)
+	ctx := newTeeEmitterContext(t, te)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = Capture(r.Context(), "lookup", func(context.Context) error {
+			return nil
+		})
+		w.WriteHeader(http.StatusAccepted)
+	})
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/orders", nil).WithContext(ctx)
+	Handler(NewFixedSegmentNamer("orders"), handler).ServeHTTP(httptest.NewRecorder(), req)
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !assert.NoError(t, me.WaitForSegments(waitCtx, 1)) {
+		return
+	}
+	assert.NoError(t, te.Flush(waitCtx))
+
+	lines := strings.Split(strings.TrimSuffix(primary.String(), "\n"), "\n")
+	if !assert.Len(t, lines, 1) {
+		return
+	}
+	assert.Equal(t, primary.String(), secondary.String())
+
+	var seg *Segment
+	if assert.NoError(t, json.Unmarshal([]byte(lines[0]), &seg)) {
+		assert.Equal(t, "orders", seg.Name)
+		assert.Equal(t, http.StatusAccepted, seg.HTTP.Response.Status)
+		assert.Len(t, seg.Subsegments, 1)
+	}
+	if segments := me.Segments(); assert.Len(t, segments, 1) {
+		assert.Equal(t, seg.ID, segments[0].ID)
+		assert.Len(t, segments[0].Subsegments, 1)
+	}
+	assert.Equal(t, []int64{0, 0}, te.Dropped())
+}
+
+func TestTeeEmitterBlockingSecondary(t *testing.T) {
+	w := &blockingWriter{unblock: make(chan struct{})}
+	defer close(w.unblock)
+	me := NewMemoryEmitter()
+	te := NewTeeEmitter(me, NewWriterEmitter(w))
+	ctx := newTeeEmitterContext(t, te)
+
+	// the secondary emitter blocks on the first segment and queues as many
+	// as it can, the others are dropped
+	n := teeQueueSize + 10
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			_, seg := BeginSegment(ctx, "test")
+			seg.Close(nil)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("primary emitter stalled by the secondary emitter")
+	}
+
+	assert.Len(t, me.Segments(), n)
+	// 9, or 10 if the first segment was still queued when the queue filled
+	if dropped := te.Dropped(); assert.Len(t, dropped, 1) {
+		assert.Contains(t, []int64{9, 10}, dropped[0])
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, te.Flush(flushCtx))
+}
+
+// refreshCountingEmitter counts the refreshes of its address.
+type refreshCountingEmitter struct {
+	TestEmitter
+	refreshes int
+}
+
+func (e *refreshCountingEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {
+	e.refreshes++
+}
+
+func TestTeeEmitterRefreshesPrimary(t *testing.T) {
+	primary := &refreshCountingEmitter{}
+	secondary := &refreshCountingEmitter{}
+	te := NewTeeEmitter(primary, secondary)
+	defer te.Close()
+
+	te.RefreshEmitterWithAddress(nil)
+	assert.Equal(t, 1, primary.refreshes)
+	assert.Equal(t, 0, secondary.refreshes)
+}
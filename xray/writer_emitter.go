@@ -0,0 +1,68 @@
+// Copyright 2017-2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance with the License. A copy of the License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language governing permissions and limitations under the License.
+
+package xray
+
+import (
+	"io"
+	"net"
+	"runtime/debug"
+	"sync"
+)
+
+// WriterEmitter writes the segment and subsegment documents it's given to w
+// as newline-delimited JSON, the documents DefaultEmitter would send to the
+// daemon, e.g. to archive them to a file or through a Firehose agent. Use it
+// as a secondary emitter of a TeeEmitter to archive the segments sent to the
+// daemon, so that slow writes don't delay them.
+type WriterEmitter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// NewWriterEmitter initializes and returns a
+// pointer to an instance of WriterEmitter writing to w.
+func NewWriterEmitter(w io.Writer) *WriterEmitter {
+	return &WriterEmitter{w: w}
+}
+
+// RefreshEmitterWithAddress is a no-op, WriterEmitter does not use the daemon.
+func (we *WriterEmitter) RefreshEmitterWithAddress(raddr *net.UDPAddr) {}
+
+// RefreshEmitterWithUnixAddress is a no-op, WriterEmitter does not use the daemon.
+func (we *WriterEmitter) RefreshEmitterWithUnixAddress(raddr *net.UnixAddr) {}
+
+// Emit writes the documents of segment or subsegment, one per line, if root
+// segment is sampled. They're written with a single call to Write.
+// seg has a write lock acquired by the caller.
+func (we *WriterEmitter) Emit(seg *Segment) {
+	defer func() {
+		if r := recover(); r != nil {
+			seg.log().Errorf("Panic emitting segment: %s\n%s", r, string(debug.Stack()))
+		}
+	}()
+
+	if seg == nil || !seg.ParentSegment.Sampled {
+		return
+	}
+
+	var b []byte
+	for _, p := range packSegments(seg, nil) {
+		b = append(append(b, p...), '\n')
+	}
+	if len(b) == 0 {
+		return
+	}
+
+	we.Lock()
+	_, err := we.w.Write(b)
+	we.Unlock()
+	if err != nil {
+		seg.log().Errorf("Error writing segment %s: %v", seg.Name, err)
+	}
+}